@@ -18,10 +18,16 @@ package v1beta1
 
 import (
 	"github.com/k0sproject/k0smotron/internal/cloudinit"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+// BootstrapDataOutdatedAnnotation is set on the Machines that have been bootstrapped with bootstrap data that was
+// regenerated afterwards, because the spec of the K0sWorkerConfig changed. The Machines must be replaced for the
+// change to take effect.
+const BootstrapDataOutdatedAnnotation = "k0smotron.io/bootstrap-data-outdated"
+
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
@@ -66,13 +72,29 @@ type K0sWorkerConfigSpec struct {
 
 	// Files specifies extra files to be passed to user_data upon creation.
 	// +kubebuilder:validation:Optional
-	Files []cloudinit.File `json:"files,omitempty"`
+	Files []File `json:"files,omitempty"`
 
 	// Args specifies extra arguments to be passed to k0s worker.
 	// See: https://docs.k0sproject.io/stable/worker-node-config/
 	// See: https://docs.k0sproject.io/stable/cli/k0s_worker/
 	Args []string `json:"args,omitempty"`
 
+	// WorkerProfile specifies the name of the k0s worker profile, defined in the control plane, used by the worker.
+	// +kubebuilder:validation:Optional
+	WorkerProfile string `json:"workerProfile,omitempty"`
+
+	// NodeLabels specifies the labels to be set on the node.
+	// +kubebuilder:validation:Optional
+	NodeLabels map[string]string `json:"nodeLabels,omitempty"`
+
+	// Taints specifies the taints to be set on the node.
+	// +kubebuilder:validation:Optional
+	Taints []corev1.Taint `json:"taints,omitempty"`
+
+	// KubeletExtraArgs specifies extra arguments to be passed to kubelet, without the leading dashes.
+	// +kubebuilder:validation:Optional
+	KubeletExtraArgs map[string]string `json:"kubeletExtraArgs,omitempty"`
+
 	// PreStartCommands specifies commands to be run before starting k0s worker.
 	// +kubebuilder:validation:Optional
 	PreStartCommands []string `json:"preStartCommands,omitempty"`
@@ -81,6 +103,10 @@ type K0sWorkerConfigSpec struct {
 	// +kubebuilder:validation:Optional
 	PostStartCommands []string `json:"postStartCommands,omitempty"`
 
+	// Commands specifies commands to be run in the phases of the bootstrap.
+	// +kubebuilder:validation:Optional
+	Commands *Commands `json:"commands,omitempty"`
+
 	// PreInstallK0s specifies whether k0s binary is pre-installed on the node.
 	// +kubebuilder:validation:Optional
 	PreInstalledK0s bool `json:"preInstalledK0s,omitempty"`
@@ -89,8 +115,191 @@ type K0sWorkerConfigSpec struct {
 	// If specified the version field is ignored and what ever version is downloaded from the URL is used.
 	// +kubebuilder:validation:Optional
 	DownloadURL string `json:"downloadURL,omitempty"`
+
+	// OCIArtifact specifies an OCI artifact to download the k0s binary from. Takes precedence over the download URL.
+	// +kubebuilder:validation:Optional
+	OCIArtifact *OCIArtifact `json:"ociArtifact,omitempty"`
+
+	// DownloadChecksum is the SHA-256 checksum of the k0s binary downloaded from the download URL.
+	// If set, the binary is installed only if the checksum matches. Ignored if the download URL is not set.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[a-fA-F0-9]{64}$`
+	DownloadChecksum string `json:"downloadChecksum,omitempty"`
+
+	// Registries specifies the containerd configuration of the container image registries.
+	// +kubebuilder:validation:Optional
+	Registries []Registry `json:"registries,omitempty"`
+
+	// Proxy specifies the proxy configuration of the machine. The proxy is used to download the k0s binary
+	// and by the k0s components, including containerd.
+	// +kubebuilder:validation:Optional
+	Proxy *ProxySpec `json:"proxy,omitempty"`
+
+	// AdditionalUserData specifies user data to be merged with the cloud-init data generated by k0smotron.
+	// Supported only with the cloud-config format.
+	// +kubebuilder:validation:Optional
+	AdditionalUserData *AdditionalUserData `json:"additionalUserData,omitempty"`
+
+	// Format specifies the output format of the bootstrap data.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=cloud-config
+	Format Format `json:"format,omitempty"`
+}
+
+// File defines a file to be written on the machine.
+type File struct {
+	cloudinit.File `json:",inline"`
+
+	// ContentFrom specifies a Secret or a ConfigMap key to read the content of the file from.
+	// If set, the content field is ignored.
+	// +kubebuilder:validation:Optional
+	ContentFrom *ContentSource `json:"contentFrom,omitempty"`
+}
+
+// AdditionalUserData defines the user data merged with the generated cloud-init data. It can be a cloud-config
+// or any other format supported by cloud-init, e.g. a shell script.
+type AdditionalUserData struct {
+	// Content is the user data.
+	// +kubebuilder:validation:Optional
+	Content string `json:"content,omitempty"`
+
+	// ContentFrom specifies a Secret or a ConfigMap key to read the user data from.
+	// If set, the content field is ignored.
+	// +kubebuilder:validation:Optional
+	ContentFrom *ContentSource `json:"contentFrom,omitempty"`
+}
+
+// ContentSource references the content of a file. Exactly one of the references must be set.
+type ContentSource struct {
+	// SecretRef is a reference to a key of a Secret in the namespace of the config.
+	// +kubebuilder:validation:Optional
+	SecretRef *ContentSourceRef `json:"secretRef,omitempty"`
+
+	// ConfigMapRef is a reference to a key of a ConfigMap in the namespace of the config.
+	// +kubebuilder:validation:Optional
+	ConfigMapRef *ContentSourceRef `json:"configMapRef,omitempty"`
+}
+
+type ContentSourceRef struct {
+	// Name is the name of the object.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+	// Key is the key in the object that contains the content.
+	// +kubebuilder:validation:Required
+	Key string `json:"key"`
+}
+
+// OCIArtifact defines an OCI artifact containing the k0s binary as its first layer, e.g. pushed with
+// `oras push registry.example.com/k0s:v1.28.4-k0s.0 k0s`.
+type OCIArtifact struct {
+	// Reference is the reference of the artifact, including the registry, e.g. registry.example.com/k0s:v1.28.4-k0s.0
+	// or registry.example.com/k0s@sha256:<digest>.
+	// +kubebuilder:validation:Required
+	Reference string `json:"reference"`
+
+	// PullSecretRef is a reference to a secret of type kubernetes.io/dockerconfigjson in the namespace of the config,
+	// with the credentials of the registry.
+	// +kubebuilder:validation:Optional
+	PullSecretRef *corev1.LocalObjectReference `json:"pullSecretRef,omitempty"`
+}
+
+// Commands defines the commands to be run in the phases of the bootstrap. The phases are run in the order
+// beforeDownload, beforeInstall, afterInstall and afterStart, the commands in the order they are listed.
+// The preStartCommands are run after the beforeDownload commands and the postStartCommands after the afterStart commands.
+type Commands struct {
+	// BeforeDownload specifies commands to be run before the k0s binary is downloaded.
+	// +kubebuilder:validation:Optional
+	BeforeDownload []Command `json:"beforeDownload,omitempty"`
+
+	// BeforeInstall specifies commands to be run before k0s is installed as a service.
+	// +kubebuilder:validation:Optional
+	BeforeInstall []Command `json:"beforeInstall,omitempty"`
+
+	// AfterInstall specifies commands to be run after k0s is installed as a service, before it's started.
+	// +kubebuilder:validation:Optional
+	AfterInstall []Command `json:"afterInstall,omitempty"`
+
+	// AfterStart specifies commands to be run after k0s is started.
+	// +kubebuilder:validation:Optional
+	AfterStart []Command `json:"afterStart,omitempty"`
+}
+
+type Command struct {
+	// Command is the shell command to be run.
+	// +kubebuilder:validation:Required
+	Command string `json:"command"`
+
+	// FailurePolicy defines what happens if the command fails. With Fail, the bootstrap is stopped and the
+	// machine is not bootstrapped. With Ignore, the bootstrap continues with the next command.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Fail
+	FailurePolicy CommandFailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// CommandFailurePolicy defines what happens if a bootstrap command fails.
+// +kubebuilder:validation:Enum=Fail;Ignore
+type CommandFailurePolicy string
+
+const (
+	// CommandFailurePolicyFail stops the bootstrap if the command fails.
+	CommandFailurePolicyFail CommandFailurePolicy = "Fail"
+	// CommandFailurePolicyIgnore continues the bootstrap if the command fails.
+	CommandFailurePolicyIgnore CommandFailurePolicy = "Ignore"
+)
+
+// Registry defines the containerd configuration of a container image registry.
+type Registry struct {
+	// Host is the host of the registry, with an optional port, e.g. docker.io or registry.example.com:5000.
+	// +kubebuilder:validation:Required
+	Host string `json:"host"`
+
+	// Mirrors is a list of mirror URLs to pull the images of the registry from, in the order they are tried.
+	// The registry itself is used if the images can't be pulled from the mirrors.
+	// +kubebuilder:validation:Optional
+	Mirrors []string `json:"mirrors,omitempty"`
+
+	// Insecure skips the verification of the TLS certificates of the registry and its mirrors.
+	// +kubebuilder:validation:Optional
+	Insecure bool `json:"insecure,omitempty"`
+
+	// AuthSecretRef is a reference to a secret of type kubernetes.io/basic-auth in the namespace of the config,
+	// with the credentials used to authenticate to the registry and its mirrors.
+	// +kubebuilder:validation:Optional
+	AuthSecretRef *RegistryAuthSecretRef `json:"authSecretRef,omitempty"`
+}
+
+type RegistryAuthSecretRef struct {
+	// Name is the name of the secret
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
 }
 
+type ProxySpec struct {
+	// HTTPProxy is the URL of the proxy used for HTTP requests.
+	// +kubebuilder:validation:Optional
+	HTTPProxy string `json:"httpProxy,omitempty"`
+
+	// HTTPSProxy is the URL of the proxy used for HTTPS requests.
+	// +kubebuilder:validation:Optional
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+
+	// NoProxy is a list of hosts, domains and CIDRs that are accessed without the proxy.
+	// Make sure it includes the pod and service CIDRs and the address of the control plane.
+	// +kubebuilder:validation:Optional
+	NoProxy []string `json:"noProxy,omitempty"`
+}
+
+// Format is the output format of the bootstrap data.
+// +kubebuilder:validation:Enum=cloud-config;ignition
+type Format string
+
+const (
+	// FormatCloudConfig renders the bootstrap data as cloud-init cloud-config.
+	FormatCloudConfig Format = "cloud-config"
+	// FormatIgnition renders the bootstrap data as an Ignition config, used by Flatcar and Fedora CoreOS.
+	FormatIgnition Format = "ignition"
+)
+
 type JoinTokenSecretRef struct {
 	// Name is the name of the secret
 	// +kubebuilder:validation:Required
@@ -148,6 +357,12 @@ type K0sControllerConfigSpec struct {
 	// +kubebuilder:validation:Optional
 	Version string `json:"version,omitempty"`
 
+	// VIP deploys kube-vip as a static pod announcing the virtual IP address of the control plane endpoint. The
+	// controller runs a worker for the static pod, and the address is added to the SANs of the API server instead
+	// of being set as its external address.
+	// +kubebuilder:validation:Optional
+	VIP *VIP `json:"vip,omitempty"`
+
 	*K0sConfigSpec `json:",inline"`
 }
 
@@ -160,7 +375,7 @@ type K0sConfigSpec struct {
 
 	// Files specifies extra files to be passed to user_data upon creation.
 	// +kubebuilder:validation:Optional
-	Files []cloudinit.File `json:"files,omitempty"`
+	Files []File `json:"files,omitempty"`
 
 	// Args specifies extra arguments to be passed to k0s controller.
 	// See: https://docs.k0sproject.io/stable/cli/k0s_controller/
@@ -174,6 +389,10 @@ type K0sConfigSpec struct {
 	// +kubebuilder:validation:Optional
 	PostStartCommands []string `json:"postStartCommands,omitempty"`
 
+	// Commands specifies commands to be run in the phases of the bootstrap.
+	// +kubebuilder:validation:Optional
+	Commands *Commands `json:"commands,omitempty"`
+
 	// PreInstallK0s specifies whether k0s binary is pre-installed on the node.
 	// +kubebuilder:validation:Optional
 	PreInstalledK0s bool `json:"preInstalledK0s,omitempty"`
@@ -183,9 +402,124 @@ type K0sConfigSpec struct {
 	// +kubebuilder:validation:Optional
 	DownloadURL string `json:"downloadURL,omitempty"`
 
+	// OCIArtifact specifies an OCI artifact to download the k0s binary from. Takes precedence over the download URL.
+	// +kubebuilder:validation:Optional
+	OCIArtifact *OCIArtifact `json:"ociArtifact,omitempty"`
+
+	// DownloadChecksum is the SHA-256 checksum of the k0s binary downloaded from the download URL.
+	// If set, the binary is installed only if the checksum matches. Ignored if the download URL is not set.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[a-fA-F0-9]{64}$`
+	DownloadChecksum string `json:"downloadChecksum,omitempty"`
+
+	// Registries specifies the containerd configuration of the container image registries.
+	// +kubebuilder:validation:Optional
+	Registries []Registry `json:"registries,omitempty"`
+
+	// Proxy specifies the proxy configuration of the machine. The proxy is used to download the k0s binary
+	// and by the k0s components, including containerd.
+	// +kubebuilder:validation:Optional
+	Proxy *ProxySpec `json:"proxy,omitempty"`
+
+	// AdditionalUserData specifies user data to be merged with the cloud-init data generated by k0smotron.
+	// Supported only with the cloud-config format.
+	// +kubebuilder:validation:Optional
+	AdditionalUserData *AdditionalUserData `json:"additionalUserData,omitempty"`
+
+	// Format specifies the output format of the bootstrap data.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=cloud-config
+	Format Format `json:"format,omitempty"`
+
 	// Tunneling defines the tunneling configuration for the cluster.
 	//+kubebuilder:validation:Optional
 	Tunneling TunnelingSpec `json:"tunneling,omitempty"`
+
+	// ExternalEtcd configures the controllers to use an externally managed etcd cluster instead of the etcd
+	// cluster managed by k0s. Overrides the storage of the k0s configuration.
+	//+kubebuilder:validation:Optional
+	ExternalEtcd *ExternalEtcd `json:"externalEtcd,omitempty"`
+
+	// Worker configures the controller to run the workloads too, enabling the --enable-worker arg of k0s
+	// controller.
+	//+kubebuilder:validation:Optional
+	Worker *ControllerWorker `json:"worker,omitempty"`
+}
+
+// ControllerWorker configures the worker of a controller running the workloads.
+type ControllerWorker struct {
+	// NoTaints disables the default taint of the controller node, so any workload can be scheduled on it.
+	//+kubebuilder:validation:Optional
+	NoTaints bool `json:"noTaints,omitempty"`
+
+	// NodeLabels specifies the labels to be set on the node.
+	//+kubebuilder:validation:Optional
+	NodeLabels map[string]string `json:"nodeLabels,omitempty"`
+
+	// Taints specifies the taints to be set on the node, in addition to the default taint unless NoTaints is set.
+	//+kubebuilder:validation:Optional
+	Taints []corev1.Taint `json:"taints,omitempty"`
+
+	// KubeletExtraArgs specifies extra arguments to be passed to the kubelet.
+	//+kubebuilder:validation:Optional
+	KubeletExtraArgs map[string]string `json:"kubeletExtraArgs,omitempty"`
+
+	// Profile specifies the name of the k0s worker profile used by the kubelet of the controller.
+	//+kubebuilder:validation:Optional
+	Profile string `json:"profile,omitempty"`
+}
+
+// VIP configures kube-vip announcing a virtual IP address of the API server with ARP from the leading controller.
+type VIP struct {
+	// Address is the virtual IP address of the API server.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Address string `json:"address"`
+
+	// Interface is the network interface the address is announced on. kube-vip detects the interface of the
+	// default route if empty.
+	// +kubebuilder:validation:Optional
+	Interface string `json:"interface,omitempty"`
+
+	// Image is the kube-vip image.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="ghcr.io/kube-vip/kube-vip:v0.7.2"
+	Image string `json:"image,omitempty"`
+}
+
+// IsSingleNode checks whether the controller runs in the k0s single node mode, enabled by the --single arg.
+// A single node controller runs the workloads too, has no etcd members and no other controller can join it.
+func (c *K0sConfigSpec) IsSingleNode() bool {
+	for _, arg := range c.Args {
+		if arg == "--single" || arg == "--single=true" {
+			return true
+		}
+	}
+	return false
+}
+
+type ExternalEtcd struct {
+	// Endpoints of the etcd cluster, e.g. https://etcd-0.example.com:2379.
+	//+kubebuilder:validation:MinItems=1
+	Endpoints []string `json:"endpoints"`
+	// EtcdPrefix is the prefix of the keys of the cluster in etcd, so several clusters can share the etcd cluster.
+	// If empty, the name of the cluster is used.
+	//+kubebuilder:validation:Optional
+	EtcdPrefix string `json:"etcdPrefix,omitempty"`
+	// CASecretRef is a reference to a secret with the CA certificate of the etcd cluster in the `ca.crt` key.
+	// Must be set together with ClientCertSecretRef.
+	//+kubebuilder:validation:Optional
+	CASecretRef *SecretRef `json:"caSecretRef,omitempty"`
+	// ClientCertSecretRef is a reference to a secret with the etcd client certificate and key in the `tls.crt`
+	// and `tls.key` keys. Must be set together with CASecretRef.
+	//+kubebuilder:validation:Optional
+	ClientCertSecretRef *SecretRef `json:"clientCertSecretRef,omitempty"`
+}
+
+type SecretRef struct {
+	// Name is the name of the secret.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
 }
 
 type TunnelingSpec struct {
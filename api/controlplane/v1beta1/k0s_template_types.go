@@ -12,6 +12,7 @@ func init() {
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:metadata:labels="cluster.x-k8s.io/v1beta1=v1beta1"
+// +kubebuilder:metadata:labels="cluster.x-k8s.io/provider=control-plane-k0smotron"
 
 type K0sControlPlaneTemplate struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -34,6 +35,17 @@ type K0sControlPlaneTemplateResourceSpec struct {
 	K0sConfigSpec   bootstrapv1.K0sConfigSpec       `json:"k0sConfigSpec"`
 	MachineTemplate *K0sControlPlaneMachineTemplate `json:"machineTemplate,omitempty"`
 	Version         string                          `json:"version,omitempty"`
+	// UpdateStrategy defines the strategy to use when updating the control plane.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Enum=InPlace;Recreate;RollingUpdate
+	//+kubebuilder:default=InPlace
+	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
+	// RollingUpdate configures the RollingUpdate update strategy.
+	//+kubebuilder:validation:Optional
+	RollingUpdate *RollingUpdate `json:"rollingUpdate,omitempty"`
+	// AllowUnsafeNonHA allows k0smotron to remove the only machine of a single replica control plane.
+	//+kubebuilder:validation:Optional
+	AllowUnsafeNonHA bool `json:"allowUnsafeNonHA,omitempty"`
 }
 
 // +kubebuilder:object:root=true
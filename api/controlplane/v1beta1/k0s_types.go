@@ -17,10 +17,14 @@ limitations under the License.
 package v1beta1
 
 import (
+	"time"
+
 	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	kmapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
 	"github.com/k0sproject/k0smotron/internal/cloudinit"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
@@ -28,16 +32,113 @@ func init() {
 	SchemeBuilder.Register(&K0sControlPlane{}, &K0sControlPlaneList{})
 }
 
+// RolloutRestartAnnotation triggers the replacement of the control plane machines created before the
+// RFC3339 timestamp set as the value of the annotation, even if the spec of the control plane hasn't changed.
+const RolloutRestartAnnotation = "k0smotron.io/rollout-restart"
+
+// SkipPreflightChecksAnnotation skips the preflight checks run before the control plane machines are created,
+// e.g. if the k0s download URL is reachable from the machines but not from the management cluster.
+const SkipPreflightChecksAnnotation = "k0smotron.io/skip-preflight-checks"
+
+// MachineTemplateHashAnnotation is set on the control plane machines to the hash of the infrastructure machine
+// template they were created from, so the machines are replaced when the template changes.
+const MachineTemplateHashAnnotation = "k0smotron.io/machine-template-hash"
+
+const (
+	// K0sControlPlaneFinalizer allows the controller to remove the pre-terminate hooks of the control plane machines
+	// before the control plane is deleted.
+	K0sControlPlaneFinalizer = "k0scontrolplane.k0smotron.io/finalizer"
+	// PreTerminateHookCleanupAnnotation is the pre-terminate hook set on the control plane machines, so the etcd member
+	// of a deleted machine leaves the etcd cluster before the infrastructure of the machine is deleted.
+	PreTerminateHookCleanupAnnotation = clusterv1.PreTerminateDeleteHookAnnotationPrefix + "/k0smotron-cleanup"
+)
+
 type UpdateStrategy string
 
 const (
+	// UpdateInPlace updates the k0s version of the existing machines with autopilot.
 	UpdateInPlace UpdateStrategy = "InPlace"
+	// UpdateRecreate replaces the outdated machines one by one, deleting the machine before creating its replacement.
+	UpdateRecreate UpdateStrategy = "Recreate"
+	// UpdateRollingUpdate replaces the outdated machines one by one, creating the replacement before deleting the machine.
+	UpdateRollingUpdate UpdateStrategy = "RollingUpdate"
+)
+
+const (
+	// AvailableCondition documents that the API server of the control plane is reachable.
+	AvailableCondition clusterv1.ConditionType = "Available"
+	// WaitingForKubeconfigReason (Severity=Info) documents that the admin kubeconfig of the cluster is not created yet.
+	WaitingForKubeconfigReason = "WaitingForKubeconfig"
+	// APIServerUnreachableReason (Severity=Warning) documents that the API server of the control plane is not reachable.
+	APIServerUnreachableReason = "APIServerUnreachable"
+
+	// CertificatesAvailableCondition documents that the cluster certificates are generated.
+	CertificatesAvailableCondition clusterv1.ConditionType = "CertificatesAvailable"
+	// CertificatesGenerationFailedReason (Severity=Warning) documents that the cluster certificates could not be generated.
+	CertificatesGenerationFailedReason = "CertificatesGenerationFailed"
+
+	// MachinesReadyCondition aggregates the Ready condition of the control plane machines.
+	MachinesReadyCondition clusterv1.ConditionType = "MachinesReady"
+
+	// MachinesSpecUpToDateCondition documents that all the control plane machines match the version and the machine template.
+	MachinesSpecUpToDateCondition clusterv1.ConditionType = "MachinesSpecUpToDate"
+	// RollingUpdateInProgressReason (Severity=Warning) documents that outdated control plane machines are being replaced.
+	RollingUpdateInProgressReason = "RollingUpdateInProgress"
+
+	// ResizedCondition documents that the number of control plane machines matches the desired replicas.
+	ResizedCondition clusterv1.ConditionType = "Resized"
+	// ScalingUpReason (Severity=Info) documents that the control plane is scaling up.
+	ScalingUpReason = "ScalingUp"
+	// ScalingDownReason (Severity=Info) documents that the control plane is scaling down.
+	ScalingDownReason = "ScalingDown"
+
+	// EtcdClusterHealthyCondition documents that all the etcd members of the control plane have joined the etcd cluster.
+	EtcdClusterHealthyCondition clusterv1.ConditionType = "EtcdClusterHealthy"
+	// EtcdClusterUnhealthyReason (Severity=Error) documents that some etcd members have not joined the etcd cluster.
+	EtcdClusterUnhealthyReason = "EtcdClusterUnhealthy"
+	// EtcdClusterUnknownReason (Severity=Info) documents that the etcd members cannot be inspected.
+	EtcdClusterUnknownReason = "EtcdClusterUnknown"
+
+	// PreflightChecksSucceededCondition documents that the checks run before creating the control plane machines,
+	// e.g. the k0s binary can be downloaded, have passed.
+	PreflightChecksSucceededCondition clusterv1.ConditionType = "PreflightChecksSucceeded"
+	// PreflightCheckFailedReason (Severity=Error) documents that a preflight check failed and no control plane
+	// machines are created until it passes.
+	PreflightCheckFailedReason = "PreflightCheckFailed"
+
+	// MachineCertificatesValidCondition documents that the certificates of the control plane machines are not about to expire.
+	MachineCertificatesValidCondition clusterv1.ConditionType = "MachineCertificatesValid"
+	// CertificatesExpiringSoonReason (Severity=Warning) documents that the certificates of some machines expire soon.
+	CertificatesExpiringSoonReason = "CertificatesExpiringSoon"
+	// CertificatesExpiryUnknownReason (Severity=Info) documents that the certificates of some machines cannot be inspected.
+	CertificatesExpiryUnknownReason = "CertificatesExpiryUnknown"
+
+	// WorkersUpgradedCondition documents that the worker nodes are upgraded to the k0s version of the control plane.
+	WorkersUpgradedCondition clusterv1.ConditionType = "WorkersUpgraded"
+	// WaitingForControlPlaneUpgradeReason (Severity=Info) documents that the worker nodes are upgraded once the
+	// control plane is upgraded.
+	WaitingForControlPlaneUpgradeReason = "WaitingForControlPlaneUpgrade"
+	// WorkerUpgradeInProgressReason (Severity=Info) documents that the autopilot plan is upgrading the worker nodes.
+	WorkerUpgradeInProgressReason = "WorkerUpgradeInProgress"
+	// WorkerUpgradeFailedReason (Severity=Error) documents that the autopilot plan failed to upgrade the worker nodes.
+	WorkerUpgradeFailedReason = "WorkerUpgradeFailed"
 )
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
 // +kubebuilder:metadata:labels="cluster.x-k8s.io/v1beta1=v1beta1"
 // +kubebuilder:metadata:labels="cluster.x-k8s.io/provider=control-plane-k0smotron"
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.metadata.labels['cluster\.x-k8s\.io/cluster-name']`
+// +kubebuilder:printcolumn:name="Ready",type=boolean,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Initialized",type=boolean,JSONPath=`.status.initialized`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.spec.version`
+// +kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=`.spec.replicas`
+// +kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.replicas`
+// +kubebuilder:printcolumn:name="Ready Replicas",type=integer,JSONPath=`.status.readyReplicas`
+// +kubebuilder:printcolumn:name="Updated",type=integer,JSONPath=`.status.updatedReplicas`,priority=1
+// +kubebuilder:printcolumn:name="Unavailable",type=integer,JSONPath=`.status.unavailableReplicas`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 type K0sControlPlane struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -48,22 +149,222 @@ type K0sControlPlane struct {
 }
 
 type K0sControlPlaneSpec struct {
-	K0sConfigSpec   bootstrapv1.K0sConfigSpec       `json:"k0sConfigSpec"`
+	K0sConfigSpec bootstrapv1.K0sConfigSpec `json:"k0sConfigSpec"`
+	// K0sConfigRef refers to a k0s configuration stored in a ConfigMap, a Secret or an OCI artifact. The
+	// k0sConfigSpec.k0s is merged over it, so its fields take precedence.
+	//+kubebuilder:validation:Optional
+	K0sConfigRef    *kmapi.K0sConfigRef             `json:"k0sConfigRef,omitempty"`
 	MachineTemplate *K0sControlPlaneMachineTemplate `json:"machineTemplate"`
 	//+kubebuilder:validation:Optional
 	//+kubebuilder:default=1
 	Replicas int32 `json:"replicas,omitempty"`
-	// UpdateStrategy defines the strategy to use when updating the control plane. Currently only InPlace is supported.
+	// UpdateStrategy defines the strategy to use when updating the control plane.
+	// InPlace updates the k0s version of the existing machines with autopilot. Recreate and RollingUpdate replace
+	// the machines when the version or the machine template changes.
 	//+kubebuilder:validation:Optional
-	//+kubebuilder:validation:Enum:InPlace
+	//+kubebuilder:validation:Enum=InPlace;Recreate;RollingUpdate
 	//+kubebuilder:default=InPlace
 	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
+	// RollingUpdate configures the RollingUpdate update strategy.
+	//+kubebuilder:validation:Optional
+	RollingUpdate *RollingUpdate `json:"rollingUpdate,omitempty"`
+	// AllowUnsafeNonHA allows k0smotron to remove the only machine of a single replica control plane, so the machine
+	// can be replaced during a rollout or a remediation. The control plane is unavailable until the replacement is
+	// ready and the state of the cluster is lost unless it's stored outside of the machine, e.g. in an external etcd.
+	//+kubebuilder:validation:Optional
+	AllowUnsafeNonHA bool `json:"allowUnsafeNonHA,omitempty"`
+	// MachineOverrides override the k0s install configuration of specific control plane machines, e.g. to enable
+	// debug logging on one replica. The overrides of all the entries matching a machine are applied in order.
+	//+kubebuilder:validation:Optional
+	MachineOverrides []MachineOverride `json:"machineOverrides,omitempty"`
+	// WorkerProfiles defines the k0s worker profiles rendered into the k0s configuration. Workers select a profile
+	// by name. Overrides the worker profiles of the k0s configuration.
+	// See: https://docs.k0sproject.io/stable/worker-node-config/#worker-profiles
+	//+kubebuilder:validation:Optional
+	WorkerProfiles []kmapi.WorkerProfile `json:"workerProfiles,omitempty"`
+	// Remediation configures the remediation of the unhealthy control plane machines.
+	//+kubebuilder:validation:Optional
+	Remediation *RemediationStrategy `json:"remediation,omitempty"`
+	// ClusterResourceSet defines the ClusterResourceSet applying addons, e.g. the CNI, to the cluster once its control
+	// plane is initialized.
+	//+kubebuilder:validation:Optional
+	ClusterResourceSet *kmapi.ClusterResourceSetSpec `json:"clusterResourceSet,omitempty"`
+	// HelmAddons labels the cluster to be selected by the HelmChartProxies of the Cluster API Add-on Provider for Helm
+	// once its kubeconfig secret is available.
+	//+kubebuilder:validation:Optional
+	HelmAddons *kmapi.HelmAddonsSpec `json:"helmAddons,omitempty"`
+	// WorkerUpgrade configures the upgrade of the k0s version of the worker nodes with an autopilot plan once the
+	// control plane is upgraded. If not set, the workers are not upgraded by k0smotron.
+	//+kubebuilder:validation:Optional
+	WorkerUpgrade *WorkerUpgradeSpec `json:"workerUpgrade,omitempty"`
+	// VIP deploys kube-vip on the controllers to announce a virtual IP address of the API server, which is set as
+	// the control plane endpoint of the cluster, so no external load balancer is needed. kube-vip runs as a static
+	// pod, so the controllers run a worker.
+	//+kubebuilder:validation:Optional
+	VIP *bootstrapv1.VIP `json:"vip,omitempty"`
+	// CloudProvider deploys the cloud-controller-manager of the cloud provider into the cluster with the
+	// credentials of the provider, and enables the external cloud provider of the kubelet on the controllers.
+	//+kubebuilder:validation:Optional
+	CloudProvider *CloudProviderSpec `json:"cloudProvider,omitempty"`
 	// Version defines the k0s version to be deployed. You can use a specific k0s version (e.g. v1.27.1+k0s.0) or
 	// just the Kubernetes version (e.g. v1.27.1). If left empty, k0smotron will select one automatically.
 	//+kubebuilder:validation:Optional
 	Version string `json:"version,omitempty"`
 }
 
+type RollingUpdate struct {
+	// MaxSurge is the maximum number of machines that can be created above the desired number of replicas
+	// during the update. The machines are replaced one at a time to keep the etcd quorum, so only 0 and 1 are allowed.
+	// With 0, the update is the same as with the Recreate strategy.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=1
+	//+kubebuilder:default=1
+	MaxSurge *int32 `json:"maxSurge,omitempty"`
+	// MaxUnavailable is the maximum number of machines that can be unavailable during the update. It's limited so
+	// the available machines keep the etcd quorum. Defaults to 0, or to 1 if MaxSurge is 0.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=0
+	MaxUnavailable *int32 `json:"maxUnavailable,omitempty"`
+	// SoakTime is the time a new machine must be ready before it's considered available, so the next outdated
+	// machine is removed only after the new machine has been running for a while.
+	//+kubebuilder:validation:Optional
+	SoakTime *metav1.Duration `json:"soakTime,omitempty"`
+}
+
+// WorkerUpgradeSpec configures the autopilot plan upgrading the worker nodes of the cluster.
+type WorkerUpgradeSpec struct {
+	// Concurrent is the number of worker nodes upgraded at the same time.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:default=1
+	Concurrent int32 `json:"concurrent,omitempty"`
+	// NodeSelector is a label selector of the worker nodes to upgrade, e.g. "pool=default". If empty, all the
+	// worker nodes are upgraded. The control plane nodes are never selected.
+	//+kubebuilder:validation:Optional
+	NodeSelector string `json:"nodeSelector,omitempty"`
+}
+
+// CloudProviderSpec configures the cloud-controller-manager deployed into the cluster.
+type CloudProviderSpec struct {
+	// Name is the cloud provider. The cloud-controller-manager Helm chart of the provider is added to the Helm
+	// extensions of the k0s configuration.
+	//+kubebuilder:validation:Required
+	//+kubebuilder:validation:Enum=openstack;aws;hetzner
+	Name string `json:"name"`
+	// CredentialsSecretRef refers to a Secret in the namespace of the control plane holding the credentials of the
+	// cloud provider. Its data is copied to the Secret the chart reads in the kube-system namespace of the cluster,
+	// so it must have the keys expected by the chart.
+	//+kubebuilder:validation:Required
+	CredentialsSecretRef corev1.LocalObjectReference `json:"credentialsSecretRef"`
+	// ChartVersion is the version of the cloud-controller-manager chart. If empty, the latest version is installed.
+	//+kubebuilder:validation:Optional
+	ChartVersion string `json:"chartVersion,omitempty"`
+	// Values are merged over the values k0smotron sets for the chart of the provider.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:pruning:PreserveUnknownFields
+	Values *runtime.RawExtension `json:"values,omitempty"`
+}
+
+// MachineOverride overrides the k0s install configuration of the control plane machines matching the name
+// pattern and the failure domain. The overrides are applied when the machine is created.
+type MachineOverride struct {
+	// NamePattern is a shell file name pattern matched against the name of the machine, e.g. "cp-test-0" or
+	// "cp-test-*". If empty, the machines match regardless of their name.
+	//+kubebuilder:validation:Optional
+	NamePattern string `json:"namePattern,omitempty"`
+	// FailureDomain is the failure domain of the machines. If empty, the machines match regardless of their
+	// failure domain.
+	//+kubebuilder:validation:Optional
+	FailureDomain string `json:"failureDomain,omitempty"`
+	// Args are appended to the arguments of the k0s controller install command.
+	//+kubebuilder:validation:Optional
+	Args []string `json:"args,omitempty"`
+	// Env sets environment variables of the k0s controller service.
+	//+kubebuilder:validation:Optional
+	Env map[string]string `json:"env,omitempty"`
+	// ExtraArgs are merged into spec.api.extraArgs of the k0s config, the extra arguments of the kube-apiserver.
+	//+kubebuilder:validation:Optional
+	ExtraArgs map[string]string `json:"extraArgs,omitempty"`
+}
+
+// RemediationStrategy configures how many times and how often an unhealthy control plane machine is replaced.
+// A machine that becomes unhealthy again within MinHealthyPeriod after its replacement is a retry of the
+// remediation, e.g. the infrastructure keeps failing to provision the machine.
+type RemediationStrategy struct {
+	// MaxRetries is the maximum number of times the remediation of a machine is retried. If not set, the machine
+	// is remediated until it becomes healthy.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=0
+	MaxRetries *int32 `json:"maxRetries,omitempty"`
+	// RetryPeriod is the time to wait before retrying the remediation of a machine. If not set, the remediation
+	// is retried as soon as the machine is marked as unhealthy again.
+	//+kubebuilder:validation:Optional
+	RetryPeriod metav1.Duration `json:"retryPeriod,omitempty"`
+	// MinHealthyPeriod is the time after the remediation of a machine after which the machine becoming unhealthy
+	// again is a new remediation and not a retry. Defaults to 1h.
+	//+kubebuilder:validation:Optional
+	MinHealthyPeriod *metav1.Duration `json:"minHealthyPeriod,omitempty"`
+}
+
+// GetMaxSurge returns the number of machines that can be created above the desired number of replicas during the update.
+// No machine can be created above the single replica of a single node control plane, as it can't join the cluster.
+func (kcp *K0sControlPlane) GetMaxSurge() int32 {
+	if kcp.IsSingleNode() {
+		return 0
+	}
+
+	switch kcp.Spec.UpdateStrategy {
+	case UpdateRollingUpdate:
+		if kcp.Spec.RollingUpdate == nil || kcp.Spec.RollingUpdate.MaxSurge == nil {
+			return 1
+		}
+		return *kcp.Spec.RollingUpdate.MaxSurge
+	default:
+		return 0
+	}
+}
+
+// IsSingleNode checks whether the controllers run in the k0s single node mode.
+func (kcp *K0sControlPlane) IsSingleNode() bool {
+	return kcp.Spec.K0sConfigSpec.IsSingleNode()
+}
+
+// GetMaxUnavailable returns the number of machines that can be unavailable during the update. At least one machine
+// can be unavailable if no machine can be created above the desired number of replicas, otherwise at most as many
+// machines as the etcd cluster of the desired number of replicas can lose without losing the quorum.
+func (kcp *K0sControlPlane) GetMaxUnavailable() int32 {
+	var maxUnavailable int32
+	if kcp.Spec.UpdateStrategy == UpdateRollingUpdate && kcp.Spec.RollingUpdate != nil && kcp.Spec.RollingUpdate.MaxUnavailable != nil {
+		maxUnavailable = *kcp.Spec.RollingUpdate.MaxUnavailable
+	}
+	if maxUnavailable == 0 && kcp.GetMaxSurge() == 0 {
+		return 1
+	}
+
+	if quorumLimit := max((kcp.Spec.Replicas-1)/2, 1); maxUnavailable > quorumLimit {
+		return quorumLimit
+	}
+	return maxUnavailable
+}
+
+// GetMinHealthyPeriod returns the time after the remediation of a machine after which the next remediation
+// of the machine is not a retry.
+func (kcp *K0sControlPlane) GetMinHealthyPeriod() time.Duration {
+	if kcp.Spec.Remediation == nil || kcp.Spec.Remediation.MinHealthyPeriod == nil {
+		return time.Hour
+	}
+	return kcp.Spec.Remediation.MinHealthyPeriod.Duration
+}
+
+// GetSoakTime returns the time a new machine must be ready before it's considered available during the update.
+func (kcp *K0sControlPlane) GetSoakTime() time.Duration {
+	if kcp.Spec.UpdateStrategy != UpdateRollingUpdate || kcp.Spec.RollingUpdate == nil || kcp.Spec.RollingUpdate.SoakTime == nil {
+		return 0
+	}
+	return kcp.Spec.RollingUpdate.SoakTime.Duration
+}
+
 type K0sBootstrapConfigSpec struct {
 	// Files specifies extra files to be passed to user_data upon creation.
 	// +kubebuilder:validation:Optional
@@ -113,10 +414,71 @@ type K0sControlPlaneList struct {
 
 type K0sControlPlaneStatus struct {
 	// Ready denotes that the control plane is ready
-	Ready                       bool   `json:"ready"`
-	ControlPlaneReady           bool   `json:"controlPlaneReady"`
-	Inititalized                bool   `json:"initialized"`
-	ExternalManagedControlPlane bool   `json:"externalManagedControlPlane"`
-	Replicas                    int32  `json:"replicas"`
-	Version                     string `json:"version"`
+	Ready                       bool  `json:"ready"`
+	ControlPlaneReady           bool  `json:"controlPlaneReady"`
+	Inititalized                bool  `json:"initialized"`
+	ExternalManagedControlPlane bool  `json:"externalManagedControlPlane"`
+	Replicas                    int32 `json:"replicas"`
+	// Version is the Kubernetes version of the control plane, without the k0s build suffix.
+	Version string `json:"version"`
+	// K0sVersion is the k0s version of the control plane.
+	// +optional
+	K0sVersion string `json:"k0sVersion,omitempty"`
+	// Selector is the label selector of the control plane machines in string format, used by the scale subresource.
+	// +optional
+	Selector string `json:"selector,omitempty"`
+	// UpdatedReplicas is the number of machines matching the version and the machine template of the control plane.
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	// ReadyReplicas is the number of bootstrapped machines with provisioned infrastructure.
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// UnavailableReplicas is the number of machines that are not ready.
+	UnavailableReplicas int32 `json:"unavailableReplicas"`
+	// MachineFailureDomains maps the names of the control plane machines to their failure domains.
+	// +optional
+	MachineFailureDomains map[string]string `json:"machineFailureDomains,omitempty"`
+	// LastRemediation is the last remediation of a control plane machine.
+	// +optional
+	LastRemediation *LastRemediationStatus `json:"lastRemediation,omitempty"`
+	// WorkerUpgrade is the progress of the autopilot plan upgrading the worker nodes.
+	// +optional
+	WorkerUpgrade *WorkerUpgradeStatus `json:"workerUpgrade,omitempty"`
+	// Conditions defines current service state of the K0sControlPlane.
+	// +optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+}
+
+// LastRemediationStatus is the last remediation of a control plane machine.
+type LastRemediationStatus struct {
+	// Machine is the name of the remediated machine. The replacement of the machine has the same name.
+	Machine string `json:"machine"`
+	// Timestamp is the time the remediation started.
+	Timestamp metav1.Time `json:"timestamp"`
+	// RetryCount is the number of times the remediation of the machine has been retried.
+	RetryCount int32 `json:"retryCount"`
+}
+
+// WorkerUpgradeStatus is the progress of the autopilot plan upgrading the worker nodes.
+type WorkerUpgradeStatus struct {
+	// Version is the k0s version the worker nodes are upgraded to.
+	Version string `json:"version"`
+	// PlanID is the id of the autopilot plan upgrading the worker nodes.
+	// +optional
+	PlanID string `json:"planID,omitempty"`
+	// State is the state of the autopilot plan, e.g. Schedulable or Completed.
+	// +optional
+	State string `json:"state,omitempty"`
+	// Workers is the number of worker nodes targeted by the plan.
+	Workers int32 `json:"workers"`
+	// UpdatedWorkers is the number of worker nodes upgraded.
+	UpdatedWorkers int32 `json:"updatedWorkers"`
+}
+
+// GetConditions returns the set of conditions for this object.
+func (kcp *K0sControlPlane) GetConditions() clusterv1.Conditions {
+	return kcp.Status.Conditions
+}
+
+// SetConditions sets the conditions on this object.
+func (kcp *K0sControlPlane) SetConditions(conditions clusterv1.Conditions) {
+	kcp.Status.Conditions = conditions
 }
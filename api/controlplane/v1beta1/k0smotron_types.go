@@ -19,10 +19,18 @@ package v1beta1
 import (
 	kmapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
 const K0smotronControlPlaneFinalizer = "k0smotron.controlplane.cluster.x-k8s.io"
 
+const (
+	// ControlPlaneUpToDateCondition documents that the control plane pods run the current spec of the K0smotronControlPlane.
+	ControlPlaneUpToDateCondition clusterv1.ConditionType = "ControlPlaneUpToDate"
+	// ControlPlaneUpdatingReason (Severity=Info) documents that the spec changes are being rolled out to the control plane pods.
+	ControlPlaneUpdatingReason = "ControlPlaneUpdating"
+)
+
 func init() {
 	SchemeBuilder.Register(&K0smotronControlPlane{}, &K0smotronControlPlaneList{})
 }
@@ -31,6 +39,7 @@ func init() {
 // +kubebuilder:subresource:status
 // +kubebuilder:metadata:labels="cluster.x-k8s.io/v1beta1=v1beta1"
 // +kubebuilder:metadata:labels="cluster.x-k8s.io/provider=control-plane-k0smotron"
+// +kubebuilder:storageversion
 
 type K0smotronControlPlane struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -54,4 +63,34 @@ type K0smotronControlPlaneStatus struct {
 	ControlPlaneReady           bool `json:"controlPlaneReady"`
 	Inititalized                bool `json:"initialized"`
 	ExternalManagedControlPlane bool `json:"externalManagedControlPlane"`
+	// Version is the Kubernetes version of the control plane pods once they are all updated.
+	// +kubebuilder:validation:Optional
+	Version string `json:"version,omitempty"`
+	// K0sVersion is the k0s version of the control plane pods once they are all updated.
+	// +kubebuilder:validation:Optional
+	K0sVersion string `json:"k0sVersion,omitempty"`
+	// Replicas is the number of control plane pods.
+	Replicas int32 `json:"replicas"`
+	// UpdatedReplicas is the number of control plane pods running the current pod template.
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	// ReadyReplicas is the number of ready control plane pods.
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// UnavailableReplicas is the number of control plane pods that are not ready.
+	UnavailableReplicas int32 `json:"unavailableReplicas"`
+	// ReconciliationStatus is the reconciliation status of the k0smotron Cluster running the control plane.
+	// +kubebuilder:validation:Optional
+	ReconciliationStatus string `json:"reconciliationStatus,omitempty"`
+	// Conditions defines current service state of the K0smotronControlPlane.
+	// +optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+}
+
+// GetConditions returns the set of conditions for this object.
+func (kcp *K0smotronControlPlane) GetConditions() clusterv1.Conditions {
+	return kcp.Status.Conditions
+}
+
+// SetConditions sets the conditions on this object.
+func (kcp *K0smotronControlPlane) SetConditions(conditions clusterv1.Conditions) {
+	kcp.Status.Conditions = conditions
 }
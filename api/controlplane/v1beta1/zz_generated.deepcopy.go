@@ -21,10 +21,35 @@ limitations under the License.
 package v1beta1
 
 import (
+	bootstrapv1beta1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	k0smotron_iov1beta1 "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
 	"github.com/k0sproject/k0smotron/internal/cloudinit"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	apiv1beta1 "sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CloudProviderSpec) DeepCopyInto(out *CloudProviderSpec) {
+	*out = *in
+	out.CredentialsSecretRef = in.CredentialsSecretRef
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloudProviderSpec.
+func (in *CloudProviderSpec) DeepCopy() *CloudProviderSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CloudProviderSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *K0sBootstrapConfigSpec) DeepCopyInto(out *K0sBootstrapConfigSpec) {
 	*out = *in
@@ -66,7 +91,7 @@ func (in *K0sControlPlane) DeepCopyInto(out *K0sControlPlane) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new K0sControlPlane.
@@ -140,11 +165,65 @@ func (in *K0sControlPlaneMachineTemplate) DeepCopy() *K0sControlPlaneMachineTemp
 func (in *K0sControlPlaneSpec) DeepCopyInto(out *K0sControlPlaneSpec) {
 	*out = *in
 	in.K0sConfigSpec.DeepCopyInto(&out.K0sConfigSpec)
+	if in.K0sConfigRef != nil {
+		in, out := &in.K0sConfigRef, &out.K0sConfigRef
+		*out = new(k0smotron_iov1beta1.K0sConfigRef)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.MachineTemplate != nil {
 		in, out := &in.MachineTemplate, &out.MachineTemplate
 		*out = new(K0sControlPlaneMachineTemplate)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.RollingUpdate != nil {
+		in, out := &in.RollingUpdate, &out.RollingUpdate
+		*out = new(RollingUpdate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MachineOverrides != nil {
+		in, out := &in.MachineOverrides, &out.MachineOverrides
+		*out = make([]MachineOverride, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.WorkerProfiles != nil {
+		in, out := &in.WorkerProfiles, &out.WorkerProfiles
+		*out = make([]k0smotron_iov1beta1.WorkerProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Remediation != nil {
+		in, out := &in.Remediation, &out.Remediation
+		*out = new(RemediationStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ClusterResourceSet != nil {
+		in, out := &in.ClusterResourceSet, &out.ClusterResourceSet
+		*out = new(k0smotron_iov1beta1.ClusterResourceSetSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HelmAddons != nil {
+		in, out := &in.HelmAddons, &out.HelmAddons
+		*out = new(k0smotron_iov1beta1.HelmAddonsSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WorkerUpgrade != nil {
+		in, out := &in.WorkerUpgrade, &out.WorkerUpgrade
+		*out = new(WorkerUpgradeSpec)
+		**out = **in
+	}
+	if in.VIP != nil {
+		in, out := &in.VIP, &out.VIP
+		*out = new(bootstrapv1beta1.VIP)
+		**out = **in
+	}
+	if in.CloudProvider != nil {
+		in, out := &in.CloudProvider, &out.CloudProvider
+		*out = new(CloudProviderSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new K0sControlPlaneSpec.
@@ -160,6 +239,30 @@ func (in *K0sControlPlaneSpec) DeepCopy() *K0sControlPlaneSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *K0sControlPlaneStatus) DeepCopyInto(out *K0sControlPlaneStatus) {
 	*out = *in
+	if in.MachineFailureDomains != nil {
+		in, out := &in.MachineFailureDomains, &out.MachineFailureDomains
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.LastRemediation != nil {
+		in, out := &in.LastRemediation, &out.LastRemediation
+		*out = new(LastRemediationStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WorkerUpgrade != nil {
+		in, out := &in.WorkerUpgrade, &out.WorkerUpgrade
+		*out = new(WorkerUpgradeStatus)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(apiv1beta1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new K0sControlPlaneStatus.
@@ -256,6 +359,11 @@ func (in *K0sControlPlaneTemplateResourceSpec) DeepCopyInto(out *K0sControlPlane
 		*out = new(K0sControlPlaneMachineTemplate)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.RollingUpdate != nil {
+		in, out := &in.RollingUpdate, &out.RollingUpdate
+		*out = new(RollingUpdate)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new K0sControlPlaneTemplateResourceSpec.
@@ -290,7 +398,7 @@ func (in *K0smotronControlPlane) DeepCopyInto(out *K0smotronControlPlane) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new K0smotronControlPlane.
@@ -346,6 +454,13 @@ func (in *K0smotronControlPlaneList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *K0smotronControlPlaneStatus) DeepCopyInto(out *K0smotronControlPlaneStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(apiv1beta1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new K0smotronControlPlaneStatus.
@@ -448,3 +563,139 @@ func (in *K0smotronControlPlaneTemplateSpec) DeepCopy() *K0smotronControlPlaneTe
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LastRemediationStatus) DeepCopyInto(out *LastRemediationStatus) {
+	*out = *in
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LastRemediationStatus.
+func (in *LastRemediationStatus) DeepCopy() *LastRemediationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LastRemediationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MachineOverride) DeepCopyInto(out *MachineOverride) {
+	*out = *in
+	if in.Args != nil {
+		in, out := &in.Args, &out.Args
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ExtraArgs != nil {
+		in, out := &in.ExtraArgs, &out.ExtraArgs
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MachineOverride.
+func (in *MachineOverride) DeepCopy() *MachineOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(MachineOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemediationStrategy) DeepCopyInto(out *RemediationStrategy) {
+	*out = *in
+	if in.MaxRetries != nil {
+		in, out := &in.MaxRetries, &out.MaxRetries
+		*out = new(int32)
+		**out = **in
+	}
+	out.RetryPeriod = in.RetryPeriod
+	if in.MinHealthyPeriod != nil {
+		in, out := &in.MinHealthyPeriod, &out.MinHealthyPeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemediationStrategy.
+func (in *RemediationStrategy) DeepCopy() *RemediationStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(RemediationStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RollingUpdate) DeepCopyInto(out *RollingUpdate) {
+	*out = *in
+	if in.MaxSurge != nil {
+		in, out := &in.MaxSurge, &out.MaxSurge
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxUnavailable != nil {
+		in, out := &in.MaxUnavailable, &out.MaxUnavailable
+		*out = new(int32)
+		**out = **in
+	}
+	if in.SoakTime != nil {
+		in, out := &in.SoakTime, &out.SoakTime
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RollingUpdate.
+func (in *RollingUpdate) DeepCopy() *RollingUpdate {
+	if in == nil {
+		return nil
+	}
+	out := new(RollingUpdate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkerUpgradeSpec) DeepCopyInto(out *WorkerUpgradeSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkerUpgradeSpec.
+func (in *WorkerUpgradeSpec) DeepCopy() *WorkerUpgradeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkerUpgradeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkerUpgradeStatus) DeepCopyInto(out *WorkerUpgradeStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkerUpgradeStatus.
+func (in *WorkerUpgradeStatus) DeepCopy() *WorkerUpgradeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkerUpgradeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	utilconversion "sigs.k8s.io/cluster-api/util/conversion"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kmv1beta1 "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kmv1beta2 "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta2"
+)
+
+// ConvertTo converts the K0smotronControlPlane to the hub version.
+func (src *K0smotronControlPlane) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.K0smotronControlPlane)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	if err := kmv1beta2.ConvertClusterSpecToHub(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+
+	dst.Status = v1beta1.K0smotronControlPlaneStatus{
+		Ready:                       src.Status.Ready,
+		ControlPlaneReady:           src.Status.Ready,
+		Inititalized:                src.Status.Initialized,
+		ExternalManagedControlPlane: src.Status.ExternalManagedControlPlane,
+		Version:                     src.Status.Version,
+		K0sVersion:                  src.Status.K0sVersion,
+		Replicas:                    src.Status.Replicas,
+		UpdatedReplicas:             src.Status.UpdatedReplicas,
+		ReadyReplicas:               src.Status.ReadyReplicas,
+		UnavailableReplicas:         src.Status.UnavailableReplicas,
+	}
+	for _, c := range src.Status.Conditions {
+		if c.Type != ClusterReconciledCondition {
+			dst.Status.Conditions = append(dst.Status.Conditions, *c.DeepCopy())
+			continue
+		}
+		if c.Status == corev1.ConditionTrue {
+			dst.Status.ReconciliationStatus = kmv1beta1.ReconciliationSuccessful
+		} else {
+			dst.Status.ReconciliationStatus = c.Message
+		}
+	}
+
+	restored := &v1beta1.K0smotronControlPlane{}
+	if ok, err := utilconversion.UnmarshalData(dst, restored); err != nil || !ok {
+		return err
+	}
+	kmv1beta2.RestoreK0sConfigMeta(restored.Spec.K0sConfig, dst.Spec.K0sConfig)
+	dst.Status.ControlPlaneReady = restored.Status.ControlPlaneReady
+
+	return nil
+}
+
+// ConvertFrom converts the hub version to the K0smotronControlPlane.
+func (dst *K0smotronControlPlane) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.K0smotronControlPlane)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	if err := kmv1beta2.ConvertClusterSpecFromHub(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+
+	dst.Status = K0smotronControlPlaneStatus{
+		Ready:                       src.Status.Ready,
+		Initialized:                 src.Status.Inititalized,
+		ExternalManagedControlPlane: src.Status.ExternalManagedControlPlane,
+		Version:                     src.Status.Version,
+		K0sVersion:                  src.Status.K0sVersion,
+		Replicas:                    src.Status.Replicas,
+		UpdatedReplicas:             src.Status.UpdatedReplicas,
+		ReadyReplicas:               src.Status.ReadyReplicas,
+		UnavailableReplicas:         src.Status.UnavailableReplicas,
+		Conditions:                  src.Status.Conditions.DeepCopy(),
+	}
+	switch src.Status.ReconciliationStatus {
+	case "":
+	case kmv1beta1.ReconciliationSuccessful:
+		dst.Status.Conditions = append(dst.Status.Conditions, clusterv1.Condition{
+			Type:   ClusterReconciledCondition,
+			Status: corev1.ConditionTrue,
+			// The hub version doesn't track the time of the transition
+			LastTransitionTime: src.CreationTimestamp,
+		})
+	default:
+		dst.Status.Conditions = append(dst.Status.Conditions, clusterv1.Condition{
+			Type:               ClusterReconciledCondition,
+			Status:             corev1.ConditionFalse,
+			Severity:           clusterv1.ConditionSeverityInfo,
+			Reason:             ClusterReconciliationFailedReason,
+			Message:            src.Status.ReconciliationStatus,
+			LastTransitionTime: src.CreationTimestamp,
+		})
+	}
+
+	// Keep the hub version in an annotation to restore the fields that have no v1beta2 representation.
+	return utilconversion.MarshalData(src, dst)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+
+	"github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kmv1beta1 "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestK0smotronControlPlane_IsConvertible(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1beta1.AddToScheme(scheme))
+	require.NoError(t, AddToScheme(scheme))
+
+	ok, err := conversion.IsConvertible(scheme, &v1beta1.K0smotronControlPlane{})
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestK0smotronControlPlane_Convert(t *testing.T) {
+	tests := []struct {
+		name                 string
+		reconciliationStatus string
+		wantReconciled       corev1.ConditionStatus
+	}{
+		{
+			name: "not reconciled yet",
+		},
+		{
+			name:                 "reconciled",
+			reconciliationStatus: kmv1beta1.ReconciliationSuccessful,
+			wantReconciled:       corev1.ConditionTrue,
+		},
+		{
+			name:                 "reconciliation failed",
+			reconciliationStatus: "Failed reconciling statefulset",
+			wantReconciled:       corev1.ConditionFalse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := &v1beta1.K0smotronControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				Spec:       kmv1beta1.ClusterSpec{Replicas: 3, Version: "v1.28.4-k0s.0"},
+				Status: v1beta1.K0smotronControlPlaneStatus{
+					Ready:                       true,
+					ControlPlaneReady:           true,
+					Inititalized:                true,
+					ExternalManagedControlPlane: true,
+					Version:                     "v1.28.4",
+					Replicas:                    3,
+					ReconciliationStatus:        tt.reconciliationStatus,
+					Conditions: clusterv1.Conditions{{
+						Type:   v1beta1.ControlPlaneUpToDateCondition,
+						Status: corev1.ConditionTrue,
+					}},
+				},
+			}
+
+			kcp := &K0smotronControlPlane{}
+			require.NoError(t, kcp.ConvertFrom(hub))
+			require.Equal(t, int32(3), kcp.Spec.Replicas)
+			require.True(t, kcp.Status.Initialized)
+			require.True(t, conditions.IsTrue(kcp, v1beta1.ControlPlaneUpToDateCondition))
+			if tt.wantReconciled == "" {
+				require.False(t, conditions.Has(kcp, ClusterReconciledCondition))
+			} else {
+				require.Equal(t, tt.wantReconciled, conditions.Get(kcp, ClusterReconciledCondition).Status)
+			}
+
+			restored := &v1beta1.K0smotronControlPlane{}
+			require.NoError(t, kcp.ConvertTo(restored))
+			require.Empty(t, restored.Annotations)
+			require.True(t, apiequality.Semantic.DeepEqual(hub.Spec, restored.Spec))
+			require.Equal(t, hub.Status, restored.Status)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	kmapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+const (
+	// ClusterReconciledCondition reports the result of the last reconciliation of the k0smotron Cluster running
+	// the control plane.
+	ClusterReconciledCondition clusterv1.ConditionType = "ClusterReconciled"
+	// ClusterReconciliationFailedReason (Severity=Info) documents that the k0smotron Cluster reconciliation
+	// fails or waits for a dependency.
+	ClusterReconciliationFailedReason = "ClusterReconciliationFailed"
+)
+
+func init() {
+	SchemeBuilder.Register(&K0smotronControlPlane{}, &K0smotronControlPlaneList{})
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:metadata:labels="cluster.x-k8s.io/v1beta1=v1beta1"
+// +kubebuilder:metadata:labels="cluster.x-k8s.io/provider=control-plane-k0smotron"
+// +kubebuilder:unservedversion
+
+type K0smotronControlPlane struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              kmapi.ClusterSpec `json:"spec,omitempty"`
+
+	Status K0smotronControlPlaneStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type K0smotronControlPlaneList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []K0smotronControlPlane `json:"items"`
+}
+
+type K0smotronControlPlaneStatus struct {
+	// Ready denotes that the control plane is ready.
+	Ready bool `json:"ready"`
+	// Initialized denotes that the control plane is initialized.
+	Initialized bool `json:"initialized"`
+	// ExternalManagedControlPlane denotes that the control plane is not run on Machines.
+	ExternalManagedControlPlane bool `json:"externalManagedControlPlane"`
+	// Version is the Kubernetes version of the control plane pods once they are all updated.
+	// +kubebuilder:validation:Optional
+	Version string `json:"version,omitempty"`
+	// K0sVersion is the k0s version of the control plane pods once they are all updated.
+	// +kubebuilder:validation:Optional
+	K0sVersion string `json:"k0sVersion,omitempty"`
+	// Replicas is the number of control plane pods.
+	Replicas int32 `json:"replicas"`
+	// UpdatedReplicas is the number of control plane pods running the current pod template.
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	// ReadyReplicas is the number of ready control plane pods.
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// UnavailableReplicas is the number of control plane pods that are not ready.
+	UnavailableReplicas int32 `json:"unavailableReplicas"`
+	// Conditions defines current service state of the K0smotronControlPlane. The ClusterReconciled condition
+	// reports the reconciliation status of the k0smotron Cluster running the control plane.
+	// +optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+}
+
+// GetConditions returns the set of conditions for this object.
+func (kcp *K0smotronControlPlane) GetConditions() clusterv1.Conditions {
+	return kcp.Status.Conditions
+}
+
+// SetConditions sets the conditions on this object.
+func (kcp *K0smotronControlPlane) SetConditions(conditions clusterv1.Conditions) {
+	kcp.Status.Conditions = conditions
+}
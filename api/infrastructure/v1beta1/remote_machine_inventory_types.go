@@ -0,0 +1,175 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+func init() {
+	SchemeBuilder.Register(&RemoteMachineInventory{}, &RemoteMachineInventoryList{})
+}
+
+const (
+	// InventoryLabel is set on the PooledRemoteMachines created by a RemoteMachineInventory to the name of the
+	// inventory.
+	InventoryLabel = "infrastructure.cluster.x-k8s.io/remote-machine-inventory"
+
+	// InventorySyncedCondition documents that the PooledRemoteMachines of the inventory match its hosts.
+	InventorySyncedCondition clusterv1.ConditionType = "InventorySynced"
+	// InventorySyncFailedReason (Severity=Error) documents that the hosts of the inventory can't be read or that
+	// some of the PooledRemoteMachines can't be created, updated or deleted.
+	InventorySyncFailedReason = "InventorySyncFailed"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:metadata:labels="cluster.x-k8s.io/v1beta1=v1beta1"
+// +kubebuilder:metadata:labels="cluster.x-k8s.io/provider=infrastructure-k0smotron"
+// +kubebuilder:printcolumn:name="Pool",type=string,JSONPath=`.spec.pool`
+// +kubebuilder:printcolumn:name="Machines",type=integer,JSONPath=`.status.machines`
+// +kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="InventorySynced")].status`
+
+// RemoteMachineInventory creates the PooledRemoteMachines of a pool from a list of hosts.
+type RemoteMachineInventory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RemoteMachineInventorySpec   `json:"spec,omitempty"`
+	Status RemoteMachineInventoryStatus `json:"status,omitempty"`
+}
+
+// RemoteMachineInventorySpec defines the hosts of a RemoteMachineInventory.
+type RemoteMachineInventorySpec struct {
+	// Pool is the pool the PooledRemoteMachines are created in.
+	// +kubebuilder:validation:Required
+	Pool string `json:"pool"`
+
+	// Machine holds the settings shared by all the hosts.
+	// +kubebuilder:validation:Optional
+	Machine InventoryMachineSpec `json:"machine,omitempty"`
+
+	// Hosts are the hosts of the inventory.
+	// +kubebuilder:validation:Optional
+	Hosts []InventoryHost `json:"hosts,omitempty"`
+
+	// HostsFrom is a reference to a ConfigMap that contains more hosts of the inventory in the CSV format. The
+	// first line is the header, with the columns "name" and "address" and optionally "port", "user" and a
+	// "label.<key>" column for each label.
+	// +kubebuilder:validation:Optional
+	HostsFrom *ConfigMapKeyRef `json:"hostsFrom,omitempty"`
+}
+
+// InventoryMachineSpec defines the settings shared by the hosts of a RemoteMachineInventory.
+type InventoryMachineSpec struct {
+	// Port is the SSH port of the hosts. Defaults to 22.
+	// +kubebuilder:validation:Optional
+	Port int `json:"port,omitempty"`
+
+	// User is the user to use when connecting to the hosts. Defaults to root.
+	// +kubebuilder:validation:Optional
+	User string `json:"user,omitempty"`
+
+	// UseSudo runs the provisioning and cleanup commands with sudo.
+	// +kubebuilder:validation:Optional
+	UseSudo bool `json:"useSudo,omitempty"`
+
+	// SudoPasswordSecretRef is a reference to a secret that contains the sudo password of the user.
+	// +kubebuilder:validation:Optional
+	SudoPasswordSecretRef *SecretRef `json:"sudoPasswordSecretRef,omitempty"`
+
+	// SSHKeyRef is a reference to a secret that contains the SSH private key of the hosts.
+	// +kubebuilder:validation:Optional
+	SSHKeyRef SecretRef `json:"sshKeyRef,omitempty"`
+
+	// SSHCredentialsRef is a reference to the SSH credentials of the hosts in an external secret store.
+	// +kubebuilder:validation:Optional
+	SSHCredentialsRef *ExternalSecretRef `json:"sshCredentialsRef,omitempty"`
+
+	// KnownHostsSecretRef is a reference to a secret that contains the known hosts of the hosts.
+	// +kubebuilder:validation:Optional
+	KnownHostsSecretRef *SecretRef `json:"knownHostsSecretRef,omitempty"`
+
+	// Bastion is the SSH bastion host through which the connections to the hosts are made.
+	// +kubebuilder:validation:Optional
+	Bastion *BastionSpec `json:"bastion,omitempty"`
+}
+
+// InventoryHost defines a host of a RemoteMachineInventory.
+type InventoryHost struct {
+	// Name is the name of the PooledRemoteMachine of the host.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Address is the IP address or DNS name of the host.
+	// +kubebuilder:validation:Required
+	Address string `json:"address"`
+
+	// Port is the SSH port of the host, if different from the port of the inventory.
+	// +kubebuilder:validation:Optional
+	Port int `json:"port,omitempty"`
+
+	// User is the user to use when connecting to the host, if different from the user of the inventory.
+	// +kubebuilder:validation:Optional
+	User string `json:"user,omitempty"`
+
+	// Labels are set on the PooledRemoteMachine of the host, to select it with the pool selector of a RemoteMachine.
+	// +kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ConfigMapKeyRef is a reference to a key of a ConfigMap.
+type ConfigMapKeyRef struct {
+	// Name is the name of the ConfigMap.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Key is the key of the ConfigMap.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="hosts.csv"
+	Key string `json:"key,omitempty"`
+}
+
+// RemoteMachineInventoryStatus defines the observed state of a RemoteMachineInventory.
+type RemoteMachineInventoryStatus struct {
+	// Machines is the number of PooledRemoteMachines of the inventory.
+	// +optional
+	Machines int `json:"machines,omitempty"`
+
+	// Conditions defines current service state of the RemoteMachineInventory.
+	// +optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+type RemoteMachineInventoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RemoteMachineInventory `json:"items"`
+}
+
+// GetConditions returns the set of conditions for this object.
+func (i *RemoteMachineInventory) GetConditions() clusterv1.Conditions {
+	return i.Status.Conditions
+}
+
+// SetConditions sets the conditions on this object.
+func (i *RemoteMachineInventory) SetConditions(conditions clusterv1.Conditions) {
+	i.Status.Conditions = conditions
+}
@@ -50,7 +50,15 @@ type RemoteMachineTemplateResource struct {
 }
 
 type RemoteMachineTemplateResourceSpec struct {
-	Pool string `json:"pool"`
+	// Pool is the name of the pool where the machines belong to.
+	// +kubebuilder:validation:Optional
+	Pool string `json:"pool,omitempty"`
+	// PoolSelector selects the pooled machines by their labels.
+	// +kubebuilder:validation:Optional
+	PoolSelector *metav1.LabelSelector `json:"poolSelector,omitempty"`
+	// HealthCheck configures the periodic health probing of the machines once they're provisioned.
+	// +kubebuilder:validation:Optional
+	HealthCheck *HealthCheckSpec `json:"healthCheck,omitempty"`
 }
 
 // +kubebuilder:object:root=true
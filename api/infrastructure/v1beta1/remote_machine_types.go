@@ -20,6 +20,8 @@ import (
 	v1 "k8s.io/api/batch/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	kmapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -45,6 +47,78 @@ type RemoteCluster struct {
 // RemoteClusterSpec defines the desired state of RemoteCluster
 type RemoteClusterSpec struct {
 	ControlPlaneEndpoint clusterv1.APIEndpoint `json:"controlPlaneEndpoint"`
+
+	// Provisioning configures the provisioning of the RemoteMachines of the cluster.
+	// +kubebuilder:validation:Optional
+	Provisioning ProvisioningSpec `json:"provisioning,omitempty"`
+
+	// APITunnel configures an SSH tunnel to one of the control plane hosts, through which the management cluster
+	// reaches the API of the workload cluster, so the API doesn't need to be exposed, e.g. for control planes
+	// behind NAT.
+	// +kubebuilder:validation:Optional
+	APITunnel *APITunnelSpec `json:"apiTunnel,omitempty"`
+}
+
+// APITunnelSpec defines the SSH tunnel to the API of the workload cluster.
+type APITunnelSpec struct {
+	// Address is the IP address or DNS name of the control plane host the tunnel is established to.
+	// +kubebuilder:validation:Required
+	Address string `json:"address"`
+
+	// Port is the SSH port of the host.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=22
+	Port int `json:"port,omitempty"`
+
+	// User is the user to use when connecting to the host.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=root
+	User string `json:"user,omitempty"`
+
+	// SSHKeyRef is a reference to a secret that contains the SSH private key.
+	// The key must be placed on the secret using the key "value".
+	// +kubebuilder:validation:Required
+	SSHKeyRef SecretRef `json:"sshKeyRef"`
+
+	// KnownHostsSecretRef is a reference to a secret that contains the known hosts, in the format of the OpenSSH
+	// known_hosts file, the host key of the host is verified against.
+	// The known hosts must be placed on the secret using the key "value". If empty, the host key is verified against
+	// the host key pinned by the RemoteMachine of the cluster at the address, and the tunnel waits until it's pinned.
+	// +kubebuilder:validation:Optional
+	KnownHostsSecretRef *SecretRef `json:"knownHostsSecretRef,omitempty"`
+
+	// APIPort is the port the API listens on on the host. Defaults to the port of the control plane endpoint.
+	// +kubebuilder:validation:Optional
+	APIPort int `json:"apiPort,omitempty"`
+
+	// Image is the image of the tunnel. The image must contain the OpenSSH client, as the ssh command.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Image string `json:"image"`
+}
+
+// ProvisioningSpec defines the concurrency and the retries of the provisioning of the RemoteMachines of a cluster.
+type ProvisioningSpec struct {
+	// MaxConcurrent is the maximum number of machines of the cluster provisioned at the same time.
+	// Zero means no limit besides the limit of the controller.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+
+	// MaxAttempts is the number of attempts to provision a machine if the SSH connection to the machine fails,
+	// before the machine is marked as failed. Defaults to 5.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+
+	// InitialBackoff is the time to wait before the second attempt. The time is doubled for each further attempt.
+	// Defaults to 10s.
+	// +kubebuilder:validation:Optional
+	InitialBackoff *metav1.Duration `json:"initialBackoff,omitempty"`
+
+	// MaxBackoff is the maximum time to wait between the attempts. Defaults to 5m.
+	// +kubebuilder:validation:Optional
+	MaxBackoff *metav1.Duration `json:"maxBackoff,omitempty"`
 }
 
 // RemoteClusterStatus defines the observed state of RemoteCluster
@@ -53,6 +127,32 @@ type RemoteClusterStatus struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:default=false
 	Ready bool `json:"ready"`
+
+	// Conditions defines current service state of the RemoteCluster.
+	// +optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+}
+
+const (
+	// APITunnelReadyCondition documents that the SSH tunnel to the API of the workload cluster is established and
+	// the kubeconfig of the cluster points to it.
+	APITunnelReadyCondition clusterv1.ConditionType = "APITunnelReady"
+	// APITunnelNotReadyReason (Severity=Warning) documents that the SSH tunnel is not connected yet, the host key of the
+	// host is not pinned yet or the kubeconfig of the cluster is not generated yet.
+	APITunnelNotReadyReason = "APITunnelNotReady"
+	// APITunnelFailedReason (Severity=Error) documents that the SSH tunnel or the kubeconfig of the cluster can't be
+	// reconciled.
+	APITunnelFailedReason = "APITunnelFailed"
+)
+
+// GetConditions returns the set of conditions for this object.
+func (c *RemoteCluster) GetConditions() clusterv1.Conditions {
+	return c.Status.Conditions
+}
+
+// SetConditions sets the conditions on this object.
+func (c *RemoteCluster) SetConditions(conditions clusterv1.Conditions) {
+	c.Status.Conditions = conditions
 }
 
 // +kubebuilder:object:root=true
@@ -67,6 +167,13 @@ type RemoteClusterList struct {
 // +kubebuilder:subresource:status
 // +kubebuilder:metadata:labels="cluster.x-k8s.io/v1beta1=v1beta1"
 // +kubebuilder:metadata:labels="cluster.x-k8s.io/provider=infrastructure-k0smotron"
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.metadata.labels['cluster\.x-k8s\.io/cluster-name']`
+// +kubebuilder:printcolumn:name="Address",type=string,JSONPath=`.spec.address`
+// +kubebuilder:printcolumn:name="Ready",type=boolean,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.failureReason`
+// +kubebuilder:printcolumn:name="Pool",type=string,JSONPath=`.spec.pool`,priority=1
+// +kubebuilder:printcolumn:name="Provider ID",type=string,JSONPath=`.spec.providerID`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 type RemoteMachine struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -82,6 +189,12 @@ type RemoteMachineSpec struct {
 	// +kubebuilder:validation:Optional
 	Pool string `json:"pool,omitempty"`
 
+	// PoolSelector selects the pooled machine by its labels. If the pool is set too, the pooled machine must belong
+	// to the pool. Of the matching free pooled machines, the one with the fewest labels is reserved, so the more
+	// specific machines are kept for the machines requesting them.
+	// +kubebuilder:validation:Optional
+	PoolSelector *metav1.LabelSelector `json:"poolSelector,omitempty"`
+
 	// ProviderID is the ID of the machine in the provider.
 	// +kubebuilder:validation:Optional
 	ProviderID string `json:"providerID,omitempty"`
@@ -100,18 +213,220 @@ type RemoteMachineSpec struct {
 	// +kubebuilder:default="root"
 	User string `json:"user,omitempty"`
 
+	// UseSudo runs the provisioning and cleanup commands with sudo, for machines that don't allow root logins.
 	// +kubebuilder:validation:Optional
 	UseSudo bool `json:"useSudo,omitempty"`
 
+	// SudoPasswordSecretRef is a reference to a secret that contains the sudo password of the user.
+	// The password must be placed on the secret using the key "value". If empty, passwordless sudo is used.
+	// +kubebuilder:validation:Optional
+	SudoPasswordSecretRef *SecretRef `json:"sudoPasswordSecretRef,omitempty"`
+
 	// SSHKeyRef is a reference to a secret that contains the SSH private key.
 	// The key must be placed on the secret using the key "value".
 	// +kubebuilder:validation:Optional
 	SSHKeyRef SecretRef `json:"sshKeyRef,omitempty"`
 
+	// SSHCredentialsRef is a reference to the SSH credentials of the machine in an external secret store. The SSH
+	// private key is read from the key "value", the password from the key "password" and the sudo password from the
+	// key "sudoPassword" of the secret. If set, the SSH key and the sudo password secrets are not used.
+	// +kubebuilder:validation:Optional
+	SSHCredentialsRef *ExternalSecretRef `json:"sshCredentialsRef,omitempty"`
+
+	// KnownHostsSecretRef is a reference to a secret that contains the known hosts, in the format of the OpenSSH
+	// known_hosts file, the host keys of the machine and the bastion host are verified against.
+	// The known hosts must be placed on the secret using the key "value". If empty, the host keys presented at the
+	// first connection are trusted and pinned in the status.
+	// +kubebuilder:validation:Optional
+	KnownHostsSecretRef *SecretRef `json:"knownHostsSecretRef,omitempty"`
+
+	// Bastion is the SSH bastion host through which the connection to the machine is made.
+	// +kubebuilder:validation:Optional
+	Bastion *BastionSpec `json:"bastion,omitempty"`
+
+	// CleanupCommands are run on the machine over SSH when the machine is deleted, after k0s is stopped and reset.
+	// +kubebuilder:validation:Optional
+	CleanupCommands []string `json:"cleanupCommands,omitempty"`
+
+	// Network is the static network configuration applied to the machine before it's bootstrapped.
+	// +kubebuilder:validation:Optional
+	Network *NetworkSpec `json:"network,omitempty"`
+
+	// Hooks are run on the machine over SSH during the provisioning, before the bootstrap data is executed and
+	// after the machine has joined the cluster.
+	// +kubebuilder:validation:Optional
+	Hooks *ProvisioningHooks `json:"hooks,omitempty"`
+
+	// HealthCheck configures the periodic health probing of the machine once it's provisioned.
+	// +kubebuilder:validation:Optional
+	HealthCheck *HealthCheckSpec `json:"healthCheck,omitempty"`
+
+	// PowerManagement is the out-of-band power management of the machine.
+	// +kubebuilder:validation:Optional
+	PowerManagement *PowerManagementSpec `json:"powerManagement,omitempty"`
+
 	// ProvisionJob describes the kubernetes Job to use to provision the machine.
 	ProvisionJob *ProvisionJob `json:"provisionJob,omitempty"`
 }
 
+// BastionSpec defines the SSH bastion host, also known as jump host, of a remote machine.
+type BastionSpec struct {
+	// Address is the IP address or DNS name of the bastion host.
+	// +kubebuilder:validation:Required
+	Address string `json:"address"`
+
+	// Port is the SSH port of the bastion host.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=22
+	Port int `json:"port,omitempty"`
+
+	// User is the user to use when connecting to the bastion host.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="root"
+	User string `json:"user,omitempty"`
+
+	// SSHKeyRef is a reference to a secret that contains the SSH private key of the bastion host.
+	// The key must be placed on the secret using the key "value". If empty, the SSH key of the machine is used.
+	// +kubebuilder:validation:Optional
+	SSHKeyRef *SecretRef `json:"sshKeyRef,omitempty"`
+}
+
+// NetworkSpec defines the static network configuration of a remote machine.
+type NetworkSpec struct {
+	// Renderer is the network configuration tool of the machine the configuration is rendered for.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Netplan;NetworkManager
+	// +kubebuilder:default=Netplan
+	Renderer string `json:"renderer,omitempty"`
+
+	// Interfaces are the statically configured network interfaces.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	Interfaces []NetworkInterface `json:"interfaces"`
+
+	// Nameservers are the addresses of the DNS servers.
+	// +kubebuilder:validation:Optional
+	Nameservers []string `json:"nameservers,omitempty"`
+
+	// SearchDomains are the DNS search domains.
+	// +kubebuilder:validation:Optional
+	SearchDomains []string `json:"searchDomains,omitempty"`
+}
+
+// NetworkInterface defines the static configuration of a network interface.
+type NetworkInterface struct {
+	// Name is the name of the interface, e.g. eno1.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Addresses are the IPv4 and IPv6 addresses of the interface in the CIDR notation, e.g. 10.0.0.10/24.
+	// +kubebuilder:validation:Optional
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Gateway is the address of the default gateway of the interface.
+	// +kubebuilder:validation:Optional
+	Gateway string `json:"gateway,omitempty"`
+
+	// MTU is the MTU of the interface.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MTU int `json:"mtu,omitempty"`
+
+	// VLANs are the VLANs on the interface.
+	// +kubebuilder:validation:Optional
+	VLANs []VLAN `json:"vlans,omitempty"`
+}
+
+// VLAN defines the static configuration of a VLAN interface.
+type VLAN struct {
+	// ID is the VLAN ID. The VLAN interface is named after the parent interface and the ID, e.g. eno1.100.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4094
+	ID int `json:"id"`
+
+	// Addresses are the IPv4 and IPv6 addresses of the VLAN interface in the CIDR notation.
+	// +kubebuilder:validation:Optional
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Gateway is the address of the default gateway of the VLAN interface.
+	// +kubebuilder:validation:Optional
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// ProvisioningHooks defines the hooks run on a remote machine during its provisioning.
+type ProvisioningHooks struct {
+	// PreBootstrap hooks are run in order before the bootstrap data is executed, e.g. to set up RAID or to harden
+	// the OS.
+	// +kubebuilder:validation:Optional
+	PreBootstrap []ProvisioningHook `json:"preBootstrap,omitempty"`
+
+	// PostJoin hooks are run in order after the bootstrap data is executed and k0s has been started, e.g. to
+	// register the machine in an external inventory.
+	// +kubebuilder:validation:Optional
+	PostJoin []ProvisioningHook `json:"postJoin,omitempty"`
+}
+
+// ProvisioningHook defines a command or a script run on a remote machine. Exactly one of command and scriptRef
+// must be set.
+type ProvisioningHook struct {
+	// Name identifies the hook in the errors.
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Command is the command to run.
+	// +kubebuilder:validation:Optional
+	Command string `json:"command,omitempty"`
+
+	// ScriptRef is a reference to a secret that contains the script to run. The script is passed to "sh" on the
+	// standard input. The script must be placed on the secret using the key "value".
+	// +kubebuilder:validation:Optional
+	ScriptRef *SecretRef `json:"scriptRef,omitempty"`
+}
+
+// HealthCheckSpec defines the periodic health probing of a provisioned remote machine. The machine is probed over
+// SSH and checked to have k0s running. A machine failing the probe FailureThreshold times in a row is marked as
+// failed, so a MachineHealthCheck of the machine remediates it.
+type HealthCheckSpec struct {
+	// Interval is the time between the probes.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="1m"
+	Interval metav1.Duration `json:"interval,omitempty"`
+
+	// FailureThreshold is the number of consecutive failed probes after which the machine is marked as failed.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=3
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+}
+
+// PowerManagementSpec defines the power management of a remote machine through the Redfish API of its BMC.
+type PowerManagementSpec struct {
+	// Address is the address of the Redfish service of the BMC, e.g. https://10.0.0.100.
+	// +kubebuilder:validation:Required
+	Address string `json:"address"`
+
+	// SystemID is the ID of the computer system of the machine in the Redfish service.
+	// If empty, the Redfish service must have exactly one computer system.
+	// +kubebuilder:validation:Optional
+	SystemID string `json:"systemID,omitempty"`
+
+	// CredentialsRef is a reference to a secret that contains the credentials of the BMC.
+	// The credentials must be placed on the secret using the keys "username" and "password".
+	// +kubebuilder:validation:Required
+	CredentialsRef SecretRef `json:"credentialsRef"`
+
+	// InsecureSkipVerify disables the verification of the TLS certificate of the BMC.
+	// +kubebuilder:validation:Optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+	// PowerCycleAfter is the time after which a machine that doesn't accept the SSH connection during provisioning
+	// is power-cycled. Zero disables the power cycling.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="10m"
+	PowerCycleAfter *metav1.Duration `json:"powerCycleAfter,omitempty"`
+}
+
 type ProvisionJob struct {
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default="ssh"
@@ -131,6 +446,129 @@ type RemoteMachineStatus struct {
 
 	FailureReason  string `json:"failureReason,omitempty"`
 	FailureMessage string `json:"failureMessage,omitempty"`
+
+	// ConfiguredAddress is the address the machine is connected to once its static network configuration has been
+	// applied, if the configuration doesn't keep the address of the machine.
+	// +optional
+	ConfiguredAddress string `json:"configuredAddress,omitempty"`
+
+	// FailureArtifactsRef is a reference to the secret that contains the logs collected from the machine when the
+	// last provisioning attempt failed: the error in the key "error", the output of the failed bootstrap command in
+	// the key "command-output", the cloud-init output log in the key "cloud-init.log" and the k0s log in the key
+	// "k0s.log".
+	// +optional
+	FailureArtifactsRef *SecretRef `json:"failureArtifactsRef,omitempty"`
+
+	// LastHealthCheckTime is the time of the last health probe of the machine.
+	// +optional
+	LastHealthCheckTime *metav1.Time `json:"lastHealthCheckTime,omitempty"`
+
+	// HealthCheckFailures is the number of consecutive failed health probes of the machine.
+	// +optional
+	HealthCheckFailures int `json:"healthCheckFailures,omitempty"`
+
+	// Components is the health of the k0s control plane components of a controller machine, checked by the health
+	// probe. The unhealthy components don't fail the probe.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Components []kmapi.ComponentHealth `json:"components,omitempty"`
+
+	// ProvisionAttempts is the number of attempts made to provision the machine.
+	// +optional
+	ProvisionAttempts int `json:"provisionAttempts,omitempty"`
+
+	// LastProvisionError is the error of the last failed provisioning attempt.
+	// +optional
+	LastProvisionError string `json:"lastProvisionError,omitempty"`
+
+	// HostKey is the pinned SSH host key of the machine. The SSH connections are made only if the machine presents
+	// this key.
+	// +optional
+	HostKey string `json:"hostKey,omitempty"`
+
+	// BastionHostKey is the pinned SSH host key of the bastion host.
+	// +optional
+	BastionHostKey string `json:"bastionHostKey,omitempty"`
+
+	// LastPowerCycleTime is the time the machine was last power-cycled because it didn't accept the SSH connection.
+	// +optional
+	LastPowerCycleTime *metav1.Time `json:"lastPowerCycleTime,omitempty"`
+
+	// Conditions defines current service state of the RemoteMachine.
+	// +optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+}
+
+const (
+	// PreflightChecksSucceededCondition documents that the checks run before provisioning the machine, e.g. the
+	// SSH connection to the machine, have passed.
+	PreflightChecksSucceededCondition clusterv1.ConditionType = "PreflightChecksSucceeded"
+	// PreflightCheckFailedReason (Severity=Error) documents that a preflight check failed and the machine is not
+	// provisioned until it passes.
+	PreflightCheckFailedReason = "PreflightCheckFailed"
+
+	// PooledMachineReservedCondition documents that a pooled machine matching the pool and the pool selector of the
+	// machine has been reserved for it.
+	PooledMachineReservedCondition clusterv1.ConditionType = "PooledMachineReserved"
+	// NoMatchingPooledMachineReason (Severity=Warning) documents that no free pooled machine matches the pool and
+	// the pool selector of the machine.
+	NoMatchingPooledMachineReason = "NoMatchingPooledMachine"
+
+	// ProvisionedCondition documents that the machine has been provisioned.
+	ProvisionedCondition clusterv1.ConditionType = "Provisioned"
+	// WaitingForProvisioningSlotReason (Severity=Info) documents that the machine waits for the other machines of
+	// the cluster to be provisioned, as the maximum number of machines are provisioned at the same time.
+	WaitingForProvisioningSlotReason = "WaitingForProvisioningSlot"
+	// ProvisionRetryingReason (Severity=Warning) documents that a provisioning attempt failed and the provisioning
+	// is retried after a backoff.
+	ProvisionRetryingReason = "ProvisionRetrying"
+	// ProvisionFailedReason (Severity=Error) documents that the provisioning of the machine failed.
+	ProvisionFailedReason = "ProvisionFailed"
+
+	// HostReachableCondition documents that the provisioned machine accepts the SSH connection.
+	HostReachableCondition clusterv1.ConditionType = "HostReachable"
+	// HostUnreachableReason (Severity=Warning) documents that the health probe can't connect to the machine over SSH.
+	HostUnreachableReason = "HostUnreachable"
+
+	// K0sRunningCondition documents that k0s is running on the provisioned machine.
+	K0sRunningCondition clusterv1.ConditionType = "K0sRunning"
+	// K0sNotRunningReason (Severity=Warning) documents that "k0s status" fails on the machine.
+	K0sNotRunningReason = "K0sNotRunning"
+
+	// HealthCheckFailedReason (Severity=Error) documents that the machine failed the health probe too many times in
+	// a row and is marked as failed.
+	HealthCheckFailedReason = "HealthCheckFailed"
+
+	// PoweredOnCondition documents that the machine has been powered on through its power management.
+	PoweredOnCondition clusterv1.ConditionType = "PoweredOn"
+	// PowerManagementFailedReason (Severity=Error) documents that the power state of the machine can't be read or
+	// changed through its power management.
+	PowerManagementFailedReason = "PowerManagementFailed"
+)
+
+// UsesPool checks whether the machine is reserved from the pooled machines.
+func (rm *RemoteMachine) UsesPool() bool {
+	return rm.Spec.Pool != "" || rm.Spec.PoolSelector != nil
+}
+
+// SSHAddress returns the address the machine is connected to over SSH: the configured address if the static network
+// configuration of the machine has changed its address, the address of the machine otherwise.
+func (rm *RemoteMachine) SSHAddress() string {
+	if rm.Status.ConfiguredAddress != "" {
+		return rm.Status.ConfiguredAddress
+	}
+	return rm.Spec.Address
+}
+
+// GetConditions returns the set of conditions for this object.
+func (rm *RemoteMachine) GetConditions() clusterv1.Conditions {
+	return rm.Status.Conditions
+}
+
+// SetConditions sets the conditions on this object.
+func (rm *RemoteMachine) SetConditions(conditions clusterv1.Conditions) {
+	rm.Status.Conditions = conditions
 }
 
 type SecretRef struct {
@@ -139,6 +577,20 @@ type SecretRef struct {
 	Name string `json:"name"`
 }
 
+// ExternalSecretRef is a reference to a secret in an external secret store configured for the k0smotron manager.
+type ExternalSecretRef struct {
+	// Provider is the secret store the secret is read from.
+	// +kubebuilder:validation:Enum=Vault;AWSSecretsManager
+	// +kubebuilder:validation:Required
+	Provider string `json:"provider"`
+
+	// Path is the path of the secret in the secret store, relative to k0smotron/<namespace>/ where namespace is the
+	// namespace of the machine: the path of the secret in the KV v2 secrets engine of Vault or the name of the secret
+	// in AWS Secrets Manager. Secrets stored for other namespaces can't be read.
+	// +kubebuilder:validation:Required
+	Path string `json:"path"`
+}
+
 // +kubebuilder:object:root=true
 
 type RemoteMachineList struct {
@@ -180,15 +632,67 @@ type PooledMachineSpec struct {
 	// +kubebuilder:default="root"
 	User string `json:"user"`
 
+	// UseSudo runs the provisioning and cleanup commands with sudo, for machines that don't allow root logins.
+	// +kubebuilder:validation:Optional
+	UseSudo bool `json:"useSudo,omitempty"`
+
+	// SudoPasswordSecretRef is a reference to a secret that contains the sudo password of the user.
+	// The password must be placed on the secret using the key "value". If empty, passwordless sudo is used.
+	// +kubebuilder:validation:Optional
+	SudoPasswordSecretRef *SecretRef `json:"sudoPasswordSecretRef,omitempty"`
+
 	// SSHKeyRef is a reference to a secret that contains the SSH private key.
-	// The key must be placed on the secret using the key "value".
-	// +kubebuilder:validation:Required
-	SSHKeyRef SecretRef `json:"sshKeyRef"`
+	// The key must be placed on the secret using the key "value". Either the SSH key or the SSH credentials must be set.
+	// +kubebuilder:validation:Optional
+	SSHKeyRef SecretRef `json:"sshKeyRef,omitempty"`
+
+	// SSHCredentialsRef is a reference to the SSH credentials of the machine in an external secret store. The SSH
+	// private key is read from the key "value", the password from the key "password" and the sudo password from the
+	// key "sudoPassword" of the secret. If set, the SSH key and the sudo password secrets are not used.
+	// +kubebuilder:validation:Optional
+	SSHCredentialsRef *ExternalSecretRef `json:"sshCredentialsRef,omitempty"`
+
+	// KnownHostsSecretRef is a reference to a secret that contains the known hosts, in the format of the OpenSSH
+	// known_hosts file, the host keys of the machine and the bastion host are verified against.
+	// The known hosts must be placed on the secret using the key "value". If empty, the host keys presented at the
+	// first connection are trusted and pinned in the status.
+	// +kubebuilder:validation:Optional
+	KnownHostsSecretRef *SecretRef `json:"knownHostsSecretRef,omitempty"`
+
+	// Bastion is the SSH bastion host through which the connection to the machine is made.
+	// +kubebuilder:validation:Optional
+	Bastion *BastionSpec `json:"bastion,omitempty"`
+
+	// CleanupCommands are run on the machine over SSH when the machine is deleted, after k0s is stopped and reset.
+	// +kubebuilder:validation:Optional
+	CleanupCommands []string `json:"cleanupCommands,omitempty"`
+
+	// Network is the static network configuration applied to the machine before it's bootstrapped.
+	// +kubebuilder:validation:Optional
+	Network *NetworkSpec `json:"network,omitempty"`
+
+	// Hooks are run on the machine over SSH during the provisioning, before the bootstrap data is executed and
+	// after the machine has joined the cluster.
+	// +kubebuilder:validation:Optional
+	Hooks *ProvisioningHooks `json:"hooks,omitempty"`
+
+	// HealthCheck configures the periodic health probing of the machine once it's provisioned.
+	// +kubebuilder:validation:Optional
+	HealthCheck *HealthCheckSpec `json:"healthCheck,omitempty"`
+
+	// PowerManagement is the out-of-band power management of the machine. The machine is powered on when it's
+	// reserved and powered off when it's released back to the pool.
+	// +kubebuilder:validation:Optional
+	PowerManagement *PowerManagementSpec `json:"powerManagement,omitempty"`
 }
 
 type PooledRemoteMachineStatus struct {
 	Reserved   bool             `json:"reserved"`
 	MachineRef RemoteMachineRef `json:"machineRef"`
+	// CleanupFailureMessage is set if the cleanup of the machine failed when the RemoteMachine it was reserved for
+	// was deleted. The machine stays reserved, so it's not reused before it's cleaned up and released manually.
+	// +kubebuilder:validation:Optional
+	CleanupFailureMessage string `json:"cleanupFailureMessage,omitempty"`
 }
 
 type RemoteMachineRef struct {
@@ -21,14 +21,266 @@ limitations under the License.
 package v1beta1
 
 import (
-	"k8s.io/api/batch/v1"
+	k0smotron_iov1beta1 "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	apiv1beta1 "sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *APITunnelSpec) DeepCopyInto(out *APITunnelSpec) {
+	*out = *in
+	out.SSHKeyRef = in.SSHKeyRef
+	if in.KnownHostsSecretRef != nil {
+		in, out := &in.KnownHostsSecretRef, &out.KnownHostsSecretRef
+		*out = new(SecretRef)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new APITunnelSpec.
+func (in *APITunnelSpec) DeepCopy() *APITunnelSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(APITunnelSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BastionSpec) DeepCopyInto(out *BastionSpec) {
+	*out = *in
+	if in.SSHKeyRef != nil {
+		in, out := &in.SSHKeyRef, &out.SSHKeyRef
+		*out = new(SecretRef)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BastionSpec.
+func (in *BastionSpec) DeepCopy() *BastionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BastionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigMapKeyRef) DeepCopyInto(out *ConfigMapKeyRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapKeyRef.
+func (in *ConfigMapKeyRef) DeepCopy() *ConfigMapKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigMapKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExternalSecretRef) DeepCopyInto(out *ExternalSecretRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalSecretRef.
+func (in *ExternalSecretRef) DeepCopy() *ExternalSecretRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ExternalSecretRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthCheckSpec) DeepCopyInto(out *HealthCheckSpec) {
+	*out = *in
+	out.Interval = in.Interval
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthCheckSpec.
+func (in *HealthCheckSpec) DeepCopy() *HealthCheckSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthCheckSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InventoryHost) DeepCopyInto(out *InventoryHost) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InventoryHost.
+func (in *InventoryHost) DeepCopy() *InventoryHost {
+	if in == nil {
+		return nil
+	}
+	out := new(InventoryHost)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InventoryMachineSpec) DeepCopyInto(out *InventoryMachineSpec) {
+	*out = *in
+	if in.SudoPasswordSecretRef != nil {
+		in, out := &in.SudoPasswordSecretRef, &out.SudoPasswordSecretRef
+		*out = new(SecretRef)
+		**out = **in
+	}
+	out.SSHKeyRef = in.SSHKeyRef
+	if in.SSHCredentialsRef != nil {
+		in, out := &in.SSHCredentialsRef, &out.SSHCredentialsRef
+		*out = new(ExternalSecretRef)
+		**out = **in
+	}
+	if in.KnownHostsSecretRef != nil {
+		in, out := &in.KnownHostsSecretRef, &out.KnownHostsSecretRef
+		*out = new(SecretRef)
+		**out = **in
+	}
+	if in.Bastion != nil {
+		in, out := &in.Bastion, &out.Bastion
+		*out = new(BastionSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InventoryMachineSpec.
+func (in *InventoryMachineSpec) DeepCopy() *InventoryMachineSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(InventoryMachineSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkInterface) DeepCopyInto(out *NetworkInterface) {
+	*out = *in
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.VLANs != nil {
+		in, out := &in.VLANs, &out.VLANs
+		*out = make([]VLAN, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkInterface.
+func (in *NetworkInterface) DeepCopy() *NetworkInterface {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkInterface)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkSpec) DeepCopyInto(out *NetworkSpec) {
+	*out = *in
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]NetworkInterface, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Nameservers != nil {
+		in, out := &in.Nameservers, &out.Nameservers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SearchDomains != nil {
+		in, out := &in.SearchDomains, &out.SearchDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkSpec.
+func (in *NetworkSpec) DeepCopy() *NetworkSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PooledMachineSpec) DeepCopyInto(out *PooledMachineSpec) {
 	*out = *in
+	if in.SudoPasswordSecretRef != nil {
+		in, out := &in.SudoPasswordSecretRef, &out.SudoPasswordSecretRef
+		*out = new(SecretRef)
+		**out = **in
+	}
 	out.SSHKeyRef = in.SSHKeyRef
+	if in.SSHCredentialsRef != nil {
+		in, out := &in.SSHCredentialsRef, &out.SSHCredentialsRef
+		*out = new(ExternalSecretRef)
+		**out = **in
+	}
+	if in.KnownHostsSecretRef != nil {
+		in, out := &in.KnownHostsSecretRef, &out.KnownHostsSecretRef
+		*out = new(SecretRef)
+		**out = **in
+	}
+	if in.Bastion != nil {
+		in, out := &in.Bastion, &out.Bastion
+		*out = new(BastionSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CleanupCommands != nil {
+		in, out := &in.CleanupCommands, &out.CleanupCommands
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Network != nil {
+		in, out := &in.Network, &out.Network
+		*out = new(NetworkSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Hooks != nil {
+		in, out := &in.Hooks, &out.Hooks
+		*out = new(ProvisioningHooks)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(HealthCheckSpec)
+		**out = **in
+	}
+	if in.PowerManagement != nil {
+		in, out := &in.PowerManagement, &out.PowerManagement
+		*out = new(PowerManagementSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PooledMachineSpec.
@@ -46,7 +298,7 @@ func (in *PooledRemoteMachine) DeepCopyInto(out *PooledRemoteMachine) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	out.Status = in.Status
 }
 
@@ -103,7 +355,7 @@ func (in *PooledRemoteMachineList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PooledRemoteMachineSpec) DeepCopyInto(out *PooledRemoteMachineSpec) {
 	*out = *in
-	out.Machine = in.Machine
+	in.Machine.DeepCopyInto(&out.Machine)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PooledRemoteMachineSpec.
@@ -132,12 +384,33 @@ func (in *PooledRemoteMachineStatus) DeepCopy() *PooledRemoteMachineStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PowerManagementSpec) DeepCopyInto(out *PowerManagementSpec) {
+	*out = *in
+	out.CredentialsRef = in.CredentialsRef
+	if in.PowerCycleAfter != nil {
+		in, out := &in.PowerCycleAfter, &out.PowerCycleAfter
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PowerManagementSpec.
+func (in *PowerManagementSpec) DeepCopy() *PowerManagementSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PowerManagementSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ProvisionJob) DeepCopyInto(out *ProvisionJob) {
 	*out = *in
 	if in.JobTemplate != nil {
 		in, out := &in.JobTemplate, &out.JobTemplate
-		*out = new(v1.JobTemplateSpec)
+		*out = new(batchv1.JobTemplateSpec)
 		(*in).DeepCopyInto(*out)
 	}
 }
@@ -152,13 +425,87 @@ func (in *ProvisionJob) DeepCopy() *ProvisionJob {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvisioningHook) DeepCopyInto(out *ProvisioningHook) {
+	*out = *in
+	if in.ScriptRef != nil {
+		in, out := &in.ScriptRef, &out.ScriptRef
+		*out = new(SecretRef)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvisioningHook.
+func (in *ProvisioningHook) DeepCopy() *ProvisioningHook {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvisioningHook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvisioningHooks) DeepCopyInto(out *ProvisioningHooks) {
+	*out = *in
+	if in.PreBootstrap != nil {
+		in, out := &in.PreBootstrap, &out.PreBootstrap
+		*out = make([]ProvisioningHook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PostJoin != nil {
+		in, out := &in.PostJoin, &out.PostJoin
+		*out = make([]ProvisioningHook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvisioningHooks.
+func (in *ProvisioningHooks) DeepCopy() *ProvisioningHooks {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvisioningHooks)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvisioningSpec) DeepCopyInto(out *ProvisioningSpec) {
+	*out = *in
+	if in.InitialBackoff != nil {
+		in, out := &in.InitialBackoff, &out.InitialBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxBackoff != nil {
+		in, out := &in.MaxBackoff, &out.MaxBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvisioningSpec.
+func (in *ProvisioningSpec) DeepCopy() *ProvisioningSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvisioningSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RemoteCluster) DeepCopyInto(out *RemoteCluster) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteCluster.
@@ -215,6 +562,12 @@ func (in *RemoteClusterList) DeepCopyObject() runtime.Object {
 func (in *RemoteClusterSpec) DeepCopyInto(out *RemoteClusterSpec) {
 	*out = *in
 	out.ControlPlaneEndpoint = in.ControlPlaneEndpoint
+	in.Provisioning.DeepCopyInto(&out.Provisioning)
+	if in.APITunnel != nil {
+		in, out := &in.APITunnel, &out.APITunnel
+		*out = new(APITunnelSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteClusterSpec.
@@ -230,6 +583,13 @@ func (in *RemoteClusterSpec) DeepCopy() *RemoteClusterSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RemoteClusterStatus) DeepCopyInto(out *RemoteClusterStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(apiv1beta1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteClusterStatus.
@@ -248,7 +608,7 @@ func (in *RemoteMachine) DeepCopyInto(out *RemoteMachine) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteMachine.
@@ -269,6 +629,115 @@ func (in *RemoteMachine) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemoteMachineInventory) DeepCopyInto(out *RemoteMachineInventory) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteMachineInventory.
+func (in *RemoteMachineInventory) DeepCopy() *RemoteMachineInventory {
+	if in == nil {
+		return nil
+	}
+	out := new(RemoteMachineInventory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RemoteMachineInventory) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemoteMachineInventoryList) DeepCopyInto(out *RemoteMachineInventoryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RemoteMachineInventory, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteMachineInventoryList.
+func (in *RemoteMachineInventoryList) DeepCopy() *RemoteMachineInventoryList {
+	if in == nil {
+		return nil
+	}
+	out := new(RemoteMachineInventoryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RemoteMachineInventoryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemoteMachineInventorySpec) DeepCopyInto(out *RemoteMachineInventorySpec) {
+	*out = *in
+	in.Machine.DeepCopyInto(&out.Machine)
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]InventoryHost, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.HostsFrom != nil {
+		in, out := &in.HostsFrom, &out.HostsFrom
+		*out = new(ConfigMapKeyRef)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteMachineInventorySpec.
+func (in *RemoteMachineInventorySpec) DeepCopy() *RemoteMachineInventorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RemoteMachineInventorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemoteMachineInventoryStatus) DeepCopyInto(out *RemoteMachineInventoryStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(apiv1beta1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteMachineInventoryStatus.
+func (in *RemoteMachineInventoryStatus) DeepCopy() *RemoteMachineInventoryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RemoteMachineInventoryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RemoteMachineList) DeepCopyInto(out *RemoteMachineList) {
 	*out = *in
@@ -319,7 +788,57 @@ func (in *RemoteMachineRef) DeepCopy() *RemoteMachineRef {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RemoteMachineSpec) DeepCopyInto(out *RemoteMachineSpec) {
 	*out = *in
+	if in.PoolSelector != nil {
+		in, out := &in.PoolSelector, &out.PoolSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SudoPasswordSecretRef != nil {
+		in, out := &in.SudoPasswordSecretRef, &out.SudoPasswordSecretRef
+		*out = new(SecretRef)
+		**out = **in
+	}
 	out.SSHKeyRef = in.SSHKeyRef
+	if in.SSHCredentialsRef != nil {
+		in, out := &in.SSHCredentialsRef, &out.SSHCredentialsRef
+		*out = new(ExternalSecretRef)
+		**out = **in
+	}
+	if in.KnownHostsSecretRef != nil {
+		in, out := &in.KnownHostsSecretRef, &out.KnownHostsSecretRef
+		*out = new(SecretRef)
+		**out = **in
+	}
+	if in.Bastion != nil {
+		in, out := &in.Bastion, &out.Bastion
+		*out = new(BastionSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CleanupCommands != nil {
+		in, out := &in.CleanupCommands, &out.CleanupCommands
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Network != nil {
+		in, out := &in.Network, &out.Network
+		*out = new(NetworkSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Hooks != nil {
+		in, out := &in.Hooks, &out.Hooks
+		*out = new(ProvisioningHooks)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(HealthCheckSpec)
+		**out = **in
+	}
+	if in.PowerManagement != nil {
+		in, out := &in.PowerManagement, &out.PowerManagement
+		*out = new(PowerManagementSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ProvisionJob != nil {
 		in, out := &in.ProvisionJob, &out.ProvisionJob
 		*out = new(ProvisionJob)
@@ -340,6 +859,31 @@ func (in *RemoteMachineSpec) DeepCopy() *RemoteMachineSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RemoteMachineStatus) DeepCopyInto(out *RemoteMachineStatus) {
 	*out = *in
+	if in.FailureArtifactsRef != nil {
+		in, out := &in.FailureArtifactsRef, &out.FailureArtifactsRef
+		*out = new(SecretRef)
+		**out = **in
+	}
+	if in.LastHealthCheckTime != nil {
+		in, out := &in.LastHealthCheckTime, &out.LastHealthCheckTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Components != nil {
+		in, out := &in.Components, &out.Components
+		*out = make([]k0smotron_iov1beta1.ComponentHealth, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastPowerCycleTime != nil {
+		in, out := &in.LastPowerCycleTime, &out.LastPowerCycleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(apiv1beta1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteMachineStatus.
@@ -414,7 +958,7 @@ func (in *RemoteMachineTemplateList) DeepCopyObject() runtime.Object {
 func (in *RemoteMachineTemplateResource) DeepCopyInto(out *RemoteMachineTemplateResource) {
 	*out = *in
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteMachineTemplateResource.
@@ -430,6 +974,16 @@ func (in *RemoteMachineTemplateResource) DeepCopy() *RemoteMachineTemplateResour
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RemoteMachineTemplateResourceSpec) DeepCopyInto(out *RemoteMachineTemplateResourceSpec) {
 	*out = *in
+	if in.PoolSelector != nil {
+		in, out := &in.PoolSelector, &out.PoolSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(HealthCheckSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteMachineTemplateResourceSpec.
@@ -472,3 +1026,23 @@ func (in *SecretRef) DeepCopy() *SecretRef {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VLAN) DeepCopyInto(out *VLAN) {
+	*out = *in
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VLAN.
+func (in *VLAN) DeepCopy() *VLAN {
+	if in == nil {
+		return nil
+	}
+	out := new(VLAN)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterFleetStatusName is the name of the ClusterFleetStatus maintained by the manager.
+const ClusterFleetStatusName = "default"
+
+// ClusterFleetStatusStatus summarizes the clusters managed by k0smotron.
+type ClusterFleetStatusStatus struct {
+	// HostedClusters summarizes the k0smotron Clusters, whose control planes run in pods. This includes the
+	// clusters of the K0smotronControlPlanes.
+	//+kubebuilder:validation:Optional
+	HostedClusters FleetSummary `json:"hostedClusters,omitempty"`
+	// ControlPlanes summarizes the K0sControlPlanes, whose control planes run on machines.
+	//+kubebuilder:validation:Optional
+	ControlPlanes FleetSummary `json:"controlPlanes,omitempty"`
+	// LastUpdateTime is the time the summary was last computed.
+	//+kubebuilder:validation:Optional
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// FleetSummary summarizes a kind of clusters.
+type FleetSummary struct {
+	// Total is the number of clusters.
+	Total int32 `json:"total"`
+	// NotReady is the number of clusters that are not ready.
+	NotReady int32 `json:"notReady"`
+	// PendingUpgrade is the number of clusters whose control plane is not yet updated to the desired version
+	// and configuration.
+	PendingUpgrade int32 `json:"pendingUpgrade"`
+	// ExpiringCertificates is the number of clusters with certificates expiring in less than 30 days.
+	ExpiringCertificates int32 `json:"expiringCertificates"`
+	// Versions is the number of clusters by desired k0s version.
+	//+kubebuilder:validation:Optional
+	//+listType=map
+	//+listMapKey=version
+	Versions []VersionCount `json:"versions,omitempty"`
+}
+
+// VersionCount is the number of clusters of a version.
+type VersionCount struct {
+	// Version is the k0s version.
+	Version string `json:"version"`
+	// Count is the number of clusters of the version.
+	Count int32 `json:"count"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster,shortName=fleet
+//+kubebuilder:printcolumn:name="Hosted",type=integer,JSONPath=`.status.hostedClusters.total`
+//+kubebuilder:printcolumn:name="Control Planes",type=integer,JSONPath=`.status.controlPlanes.total`
+//+kubebuilder:printcolumn:name="Hosted Not Ready",type=integer,JSONPath=`.status.hostedClusters.notReady`
+//+kubebuilder:printcolumn:name="Control Planes Not Ready",type=integer,JSONPath=`.status.controlPlanes.notReady`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ClusterFleetStatus summarizes all the clusters managed by k0smotron, for dashboards of large fleets.
+// It is maintained by the manager in a single object named default.
+type ClusterFleetStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status ClusterFleetStatusStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterFleetStatusList contains a list of ClusterFleetStatus
+type ClusterFleetStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterFleetStatus `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterFleetStatus{}, &ClusterFleetStatusList{})
+}
@@ -41,8 +41,10 @@ type JoinTokenRequestSpec struct {
 type ClusterRef struct {
 	// Name of the cluster.
 	Name string `json:"name"`
-	// Namespace of the cluster.
-	Namespace string `json:"namespace"`
+	// Namespace of the cluster. If empty, the namespace of the referencing resource is used.
+	// Referencing a cluster in another namespace requires a ReferenceGrant in the namespace of the cluster.
+	//+kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
 }
 
 // JoinTokenRequestStatus defines the observed state of K0smotronJoinTokenRequest
@@ -50,11 +52,20 @@ type JoinTokenRequestStatus struct {
 	ReconciliationStatus string    `json:"reconciliationStatus"`
 	TokenID              string    `json:"tokenID,omitempty"`
 	ClusterUID           types.UID `json:"clusterUID,omitempty"`
+	// ExpiresAt is the expiration time of the issued token. It is not set for the tokens that don't expire.
+	//+kubebuilder:validation:Optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:shortName=jtr
+//+kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterRef.name`
+//+kubebuilder:printcolumn:name="Role",type=string,JSONPath=`.spec.role`
+//+kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.reconciliationStatus`
+//+kubebuilder:printcolumn:name="Token ID",type=string,JSONPath=`.status.tokenID`,priority=1
+//+kubebuilder:printcolumn:name="Expires",type=date,JSONPath=`.status.expiresAt`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // JoinTokenRequest is the Schema for the join token request API
 type JoinTokenRequest struct {
@@ -21,9 +21,11 @@ import (
 	"strings"
 
 	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -53,6 +55,10 @@ type ClusterSpec struct {
 	//+kubebuilder:validation:Optional
 	//+kubebuilder:default={"type":"ClusterIP","apiPort":30443,"konnectivityPort":30132}
 	Service ServiceSpec `json:"service,omitempty"`
+	// DNS publishes a DNS record of the API endpoint of the cluster with external-dns, so the kubeconfigs stay valid
+	// when the address of the service changes. The hostname is used as the external address if none is set.
+	//+kubebuilder:validation:Optional
+	DNS *DNSSpec `json:"dns,omitempty"`
 	// Persistence defines the persistence configuration. If empty k0smotron
 	// will use emptyDir as a volume.
 	//+kubebuilder:validation:Optional
@@ -72,8 +78,41 @@ type ClusterSpec struct {
 	//+kubebuilder:validation:Optional
 	//+kubebuilder:pruning:PreserveUnknownFields
 	K0sConfig *unstructured.Unstructured `json:"k0sConfig,omitempty"`
+	// K0sConfigRef refers to a k0s configuration stored in a ConfigMap, a Secret or an OCI artifact. The k0sConfig
+	// is merged over it, so its fields take precedence.
+	//+kubebuilder:validation:Optional
+	K0sConfigRef *K0sConfigRef `json:"k0sConfigRef,omitempty"`
+	// WorkerProfiles defines the k0s worker profiles rendered into the k0s configuration. Workers select a profile
+	// by name. Overrides the worker profiles of the k0s configuration.
+	// See: https://docs.k0sproject.io/stable/worker-node-config/#worker-profiles
+	//+kubebuilder:validation:Optional
+	WorkerProfiles []WorkerProfile `json:"workerProfiles,omitempty"`
+	// Extensions defines the k0s extensions, i.e. the Helm charts deployed in the cluster by k0s, rendered into the
+	// k0s configuration. Overrides the Helm extensions of the k0s configuration.
+	// See: https://docs.k0sproject.io/stable/helm-charts/
+	//+kubebuilder:validation:Optional
+	Extensions ExtensionsSpec `json:"extensions,omitempty"`
+	// CNI configures the network provider of the cluster from a preset, rendered into the k0s configuration with the
+	// Helm extension deploying it if needed. Overrides the network provider of the k0s configuration.
+	//+kubebuilder:validation:Optional
+	CNI *CNISpec `json:"cni,omitempty"`
 	// CertificateRefs defines the certificate references.
 	CertificateRefs []CertificateRef `json:"certificateRefs,omitempty"`
+	// Certificates defines the configuration of the certificates served by the control plane.
+	//+kubebuilder:validation:Optional
+	Certificates CertificatesSpec `json:"certificates,omitempty"`
+	// Webhooks configures the API server to call external authorization and admission webhooks, e.g. OPA, with the
+	// kubeconfigs read from Secrets.
+	//+kubebuilder:validation:Optional
+	Webhooks WebhooksSpec `json:"webhooks,omitempty"`
+	// SecretStore defines where the generated credentials of the cluster, i.e. the admin kubeconfig
+	// and the join tokens, are stored. If empty, the store configured for the manager is used.
+	//+kubebuilder:validation:Optional
+	SecretStore *SecretStoreSpec `json:"secretStore,omitempty"`
+	// AccessControl defines the initial access control of the cluster, which is reconciled into the cluster
+	// as soon as the control plane is up.
+	//+kubebuilder:validation:Optional
+	AccessControl AccessControlSpec `json:"accessControl,omitempty"`
 	// Manifests allows to specify list of volumes with manifests to be
 	// deployed in the cluster. The volumes will be mounted
 	// in /var/lib/k0s/manifests/<manifests.name>, for this reason each
@@ -84,6 +123,12 @@ type ClusterSpec struct {
 	// https://kubernetes.io/docs/concepts/storage/volumes
 	//+kubebuilder:validation:Optional
 	Manifests []v1.Volume `json:"manifests,omitempty"`
+	// ManifestBundles defines the bundles of manifests applied to the cluster by k0smotron once its API is reachable.
+	// The manifests are applied again periodically, so the changes made to them in the cluster are reverted.
+	//+kubebuilder:validation:Optional
+	//+listType=map
+	//+listMapKey=name
+	ManifestBundles []ManifestBundle `json:"manifestBundles,omitempty"`
 	// ControlPlaneFlags allows to configure additional flags for k0s
 	// control plane and to override existing ones. The default flags are
 	// kept unless they are overriden explicitly. Flags with arguments must
@@ -93,6 +138,34 @@ type ClusterSpec struct {
 	// Monitoring defines the monitoring configuration.
 	//+kubebuilder:validation:Optional
 	Monitoring MonitoringSpec `json:"monitoring,omitempty"`
+	// Logging defines the forwarding of the control plane logs.
+	//+kubebuilder:validation:Optional
+	Logging LoggingSpec `json:"logging,omitempty"`
+	// GitOps defines the registration of the cluster as a deployment target of the GitOps tools.
+	//+kubebuilder:validation:Optional
+	GitOps GitOpsSpec `json:"gitops,omitempty"`
+	// HubRegistrations register the cluster in the fleet managers by installing their agent into the cluster, once its
+	// API is reachable.
+	//+kubebuilder:validation:Optional
+	//+listType=map
+	//+listMapKey=type
+	HubRegistrations []HubRegistration `json:"hubRegistrations,omitempty"`
+	// ClusterResourceSet defines the ClusterResourceSet applying addons, e.g. the CNI, to the Cluster API cluster once its
+	// control plane is initialized. Ignored for the clusters not provisioned with Cluster API.
+	//+kubebuilder:validation:Optional
+	ClusterResourceSet *ClusterResourceSetSpec `json:"clusterResourceSet,omitempty"`
+	// HelmAddons labels the Cluster API cluster to be selected by the HelmChartProxies of the Cluster API Add-on
+	// Provider for Helm once its kubeconfig secret is available. Ignored for the clusters not provisioned with Cluster
+	// API.
+	//+kubebuilder:validation:Optional
+	HelmAddons *HelmAddonsSpec `json:"helmAddons,omitempty"`
+	// Backup defines the integration of the control plane with the backup tools of the management cluster.
+	//+kubebuilder:validation:Optional
+	Backup BackupSpec `json:"backup,omitempty"`
+	// Notifications configures the webhooks notified about the lifecycle events of the cluster, in addition to the
+	// webhooks configured for the manager.
+	//+kubebuilder:validation:Optional
+	Notifications NotificationsSpec `json:"notifications,omitempty"`
 	// Etcd defines the etcd configuration.
 	//+kubebuilder:default={"image":"quay.io/k0sproject/etcd:v3.5.13","persistence":{}}
 	Etcd EtcdSpec `json:"etcd,omitempty"`
@@ -107,7 +180,8 @@ const (
 	defaultK0SSuffix  = "k0s.0"
 )
 
-func (c *ClusterSpec) GetImage() string {
+// GetVersion returns the k0s version of the cluster in the format of the k0s image tags, e.g. v1.27.9-k0s.0.
+func (c *ClusterSpec) GetVersion() string {
 	k0sVersion := c.Version
 	if k0sVersion == "" {
 		k0sVersion = defaultK0SVersion
@@ -117,6 +191,12 @@ func (c *ClusterSpec) GetImage() string {
 		k0sVersion = fmt.Sprintf("%s-%s", k0sVersion, defaultK0SSuffix)
 	}
 
+	return k0sVersion
+}
+
+func (c *ClusterSpec) GetImage() string {
+	k0sVersion := c.GetVersion()
+
 	if c.Image == "" {
 		return fmt.Sprintf("%s:%s", defaultK0SImage, k0sVersion)
 	}
@@ -128,11 +208,155 @@ func (c *ClusterSpec) GetImage() string {
 type ClusterStatus struct {
 	ReconciliationStatus string `json:"reconciliationStatus"`
 	Ready                bool   `json:"ready,omitempty"`
+	// Replicas is the number of controller pods of the cluster.
+	Replicas int32 `json:"replicas"`
+	// Selector is the label selector of the controller pods in string format, used by the scale subresource.
+	Selector string `json:"selector,omitempty"`
+	// ObservedGeneration is the generation of the cluster the status was last updated for.
+	//+kubebuilder:validation:Optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Components is the health of the k0s control plane components, checked periodically on all the controller pods.
+	//+kubebuilder:validation:Optional
+	//+listType=map
+	//+listMapKey=name
+	Components []ComponentHealth `json:"components,omitempty"`
+	// ReplicaStatus is the status of each controller and etcd pod of the cluster.
+	//+kubebuilder:validation:Optional
+	//+listType=map
+	//+listMapKey=name
+	ReplicaStatus []ReplicaStatus `json:"replicaStatus,omitempty"`
+	// Backup is the result of the Velero backups of the cluster. It is set if spec.backup.velero is set.
+	//+kubebuilder:validation:Optional
+	Backup *BackupStatus `json:"backup,omitempty"`
+	// Conditions defines the current state of the cluster. The Ready condition aggregates the other conditions,
+	// so tools like kstatus can health-check the cluster.
+	//+kubebuilder:validation:Optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// ReadyCondition reports that all the other conditions of the cluster are true.
+	ReadyCondition = "Ready"
+	// ServiceReadyCondition reports that the service of the cluster exists and has an address.
+	ServiceReadyCondition = "ServiceReady"
+	// StatefulSetReadyCondition reports that all the controller pods of the cluster are updated and ready.
+	StatefulSetReadyCondition = "StatefulSetReady"
+	// EtcdHealthyCondition reports that all the etcd pods of the cluster are ready. It is not set if the cluster
+	// uses kine or an external etcd.
+	EtcdHealthyCondition = "EtcdHealthy"
+	// APIReachableCondition reports that the API server of the cluster is ready and reachable through its service.
+	APIReachableCondition = "APIReachable"
+	// KonnectivityReadyCondition reports that the konnectivity server of the cluster is reachable through its service.
+	KonnectivityReadyCondition = "KonnectivityReady"
+	// KubeconfigReadyCondition reports that the admin kubeconfig of the cluster is generated.
+	KubeconfigReadyCondition = "KubeconfigReady"
+	// ManifestBundlesAppliedCondition reports that the manifest bundles are applied to the cluster. It is not set if the
+	// cluster has no manifest bundles and is not aggregated to the Ready condition.
+	ManifestBundlesAppliedCondition = "ManifestBundlesApplied"
+	// HubRegisteredCondition reports that the cluster is registered in the fleet managers of its hub registrations. It is
+	// not set if the cluster has no hub registrations and is not aggregated to the Ready condition.
+	HubRegisteredCondition = "HubRegistered"
+	// DNSRecordPublishedCondition reports that the DNS record of the API endpoint is published with the addresses of the
+	// service. It is not set if the cluster has no DNS record and is not aggregated to the Ready condition.
+	DNSRecordPublishedCondition = "DNSRecordPublished"
+	// BackupSucceededCondition reports that the latest finished Velero backup of the cluster is completed. It is not
+	// set if the cluster has no Velero backup and is not aggregated to the Ready condition.
+	BackupSucceededCondition = "BackupSucceeded"
+
+	// AvailableReason is set to the conditions that are true.
+	AvailableReason = "Available"
+	// ServiceNotReadyReason is set to the ServiceReady condition when the service doesn't exist or has no load
+	// balancer address yet.
+	ServiceNotReadyReason = "ServiceNotReady"
+	// StatefulSetNotReadyReason is set to the StatefulSetReady condition when the controller pods are not all
+	// updated and ready.
+	StatefulSetNotReadyReason = "StatefulSetNotReady"
+	// EtcdNotHealthyReason is set to the EtcdHealthy condition when the etcd pods are not all ready.
+	EtcdNotHealthyReason = "EtcdNotHealthy"
+	// WaitingForStatefulSetReason is set to the APIReachable and KonnectivityReady conditions until the
+	// controller pods are ready.
+	WaitingForStatefulSetReason = "WaitingForStatefulSet"
+	// APIUnreachableReason is set to the APIReachable condition when the API server readiness check fails.
+	APIUnreachableReason = "APIUnreachable"
+	// KonnectivityUnreachableReason is set to the KonnectivityReady condition when the konnectivity server
+	// can't be connected to.
+	KonnectivityUnreachableReason = "KonnectivityUnreachable"
+	// KubeconfigNotReadyReason is set to the KubeconfigReady condition when the admin kubeconfig is not generated.
+	KubeconfigNotReadyReason = "KubeconfigNotReady"
+	// ManifestBundlesNotAppliedReason is set to the ManifestBundlesApplied condition when a bundle can't be read or
+	// applied.
+	ManifestBundlesNotAppliedReason = "ManifestBundlesNotApplied"
+	// HubNotRegisteredReason is set to the HubRegistered condition when a hub registration fails.
+	HubNotRegisteredReason = "HubNotRegistered"
+	// DNSRecordNotPublishedReason is set to the DNSRecordPublished condition when the service has no address yet or the
+	// DNSEndpoint can't be applied.
+	DNSRecordNotPublishedReason = "DNSRecordNotPublished"
+	// WaitingForAPIReason is set to the ManifestBundlesApplied and HubRegistered conditions until the API server is
+	// reachable.
+	WaitingForAPIReason = "WaitingForAPI"
+	// BackupFailedReason is set to the BackupSucceeded condition when the latest finished Velero backup failed.
+	BackupFailedReason = "BackupFailed"
+	// WaitingForBackupReason is set to the BackupSucceeded condition until a Velero backup of the cluster is finished.
+	WaitingForBackupReason = "WaitingForBackup"
+)
+
+// BackupStatus is the result of the Velero backups of the cluster.
+type BackupStatus struct {
+	// LastSuccessTime is the completion time of the latest completed Velero backup of the cluster.
+	//+kubebuilder:validation:Optional
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+	// LastFailureTime is the completion time of the latest failed Velero backup of the cluster.
+	//+kubebuilder:validation:Optional
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
+}
+
+// ReplicaStatus is the status of a controller or etcd pod of the cluster.
+type ReplicaStatus struct {
+	// Name is the name of the pod.
+	Name string `json:"name"`
+	// Component is the control plane component the pod runs: controller or etcd.
+	//+kubebuilder:validation:Enum=controller;etcd
+	Component string `json:"component"`
+	// Phase is the phase of the pod.
+	Phase v1.PodPhase `json:"phase"`
+	// Ready denotes that all the containers of the pod are ready.
+	Ready bool `json:"ready"`
+	// RestartCount is the total number of restarts of the containers of the pod.
+	RestartCount int32 `json:"restartCount"`
+	// NodeName is the name of the node the pod is scheduled to.
+	//+kubebuilder:validation:Optional
+	NodeName string `json:"nodeName,omitempty"`
+	// EtcdRole is the role of the etcd member run by the pod: leader, follower or learner. It is empty for the
+	// controller pods and for the etcd pods whose member status can't be read.
+	//+kubebuilder:validation:Optional
+	EtcdRole string `json:"etcdRole,omitempty"`
+}
+
+// ComponentHealth is the health of a k0s control plane component.
+type ComponentHealth struct {
+	// Name is the name of the component: k0s, apiserver, etcd, scheduler, controller-manager or konnectivity.
+	Name string `json:"name"`
+	// Healthy denotes that the component passed its health check on all the controllers.
+	Healthy bool `json:"healthy"`
+	// Message describes why the component is not healthy.
+	//+kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
 //+kubebuilder:resource:shortName=kmc
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.spec.version`
+//+kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.spec.replicas`
+//+kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.spec.externalAddress`
+//+kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.service.type`,priority=1
+//+kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.reconciliationStatus`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+//+kubebuilder:storageversion
 
 // Cluster is the Schema for the k0smotronclusters API
 type Cluster struct {
@@ -165,6 +389,22 @@ type ServiceSpec struct {
 	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
+// DNSSpec defines the DNS record of the API endpoint of the cluster. The record is published with a DNSEndpoint of
+// external-dns, which must run with the crd source in the management cluster and is configured with the DNS provider.
+type DNSSpec struct {
+	// Hostname is the fully qualified name of the record, e.g. api.tenant-a.example.com.
+	//+kubebuilder:validation:MinLength=1
+	Hostname string `json:"hostname"`
+	// TTL is the TTL of the record in seconds.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:default=60
+	TTL int64 `json:"ttl,omitempty"`
+	// Labels are set on the DNSEndpoint, e.g. to match the label filter of external-dns.
+	//+kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
 //+kubebuilder:object:root=true
 
 // ClusterList contains a list of K0smotronCluster
@@ -234,6 +474,317 @@ type MonitoringSpec struct {
 	// ProxyImage defines the image used for the nginx proxy sidecar.
 	//+kubebuilder:default="nginx:1.19.10"
 	ProxyImage string `json:"proxyImage"`
+	// Labels are added to all the metrics of the cluster, in addition to the k0smotron_cluster and
+	// k0smotron_namespace labels, e.g. to tell apart the tenants or the environments of a fleet of clusters.
+	// The label names must be valid Prometheus label names.
+	//+kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// MetricRelabelings are applied to the metrics of the cluster before they are exposed, e.g. to drop or
+	// rename metrics.
+	//+kubebuilder:validation:Optional
+	MetricRelabelings []RelabelConfig `json:"metricRelabelings,omitempty"`
+	// Auth secures the metrics endpoint. If set, the metrics are served over HTTPS with the API server
+	// certificate of the cluster and only to the scrapers presenting the bearer token.
+	//+kubebuilder:validation:Optional
+	Auth *MonitoringAuthSpec `json:"auth,omitempty"`
+	// PodMonitorLabels are set on the PodMonitor generated if the Prometheus Operator is installed, e.g. to match
+	// the podMonitorSelector of the Prometheus.
+	//+kubebuilder:validation:Optional
+	PodMonitorLabels map[string]string `json:"podMonitorLabels,omitempty"`
+	// ScrapeInterval is the interval the metrics of the control plane components are scraped at, in the format of
+	// the Prometheus durations. Defaults to 10s.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Pattern=`^(([0-9]+)h)?(([0-9]+)m)?(([0-9]+)s)?(([0-9]+)ms)?$`
+	ScrapeInterval string `json:"scrapeInterval,omitempty"`
+	// Retention is how long the scraped metrics are kept by the prometheus sidecar, in the format of the Prometheus
+	// durations, e.g. "6h". The metrics are dropped anyway once they take 200MB.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Pattern=`^(([0-9]+)y)?(([0-9]+)w)?(([0-9]+)d)?(([0-9]+)h)?(([0-9]+)m)?(([0-9]+)s)?(([0-9]+)ms)?$`
+	Retention string `json:"retention,omitempty"`
+	// AgentResources describes the compute resource requirements of the prometheus sidecar.
+	//+kubebuilder:validation:Optional
+	AgentResources v1.ResourceRequirements `json:"agentResources,omitempty"`
+	// ProxyResources describes the compute resource requirements of the nginx proxy sidecar.
+	//+kubebuilder:validation:Optional
+	ProxyResources v1.ResourceRequirements `json:"proxyResources,omitempty"`
+	// RemoteWrite sends the scraped metrics to external time series databases with the Prometheus remote write
+	// protocol, in addition to exposing them.
+	//+kubebuilder:validation:Optional
+	RemoteWrite []RemoteWriteSpec `json:"remoteWrite,omitempty"`
+}
+
+// RemoteWriteSpec is a remote write endpoint the metrics are sent to.
+type RemoteWriteSpec struct {
+	// URL is the URL of the remote write endpoint.
+	//+kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+	// BearerTokenSecretName is the name of the secret holding the bearer token sent to the endpoint under the
+	// key "token".
+	//+kubebuilder:validation:Optional
+	BearerTokenSecretName string `json:"bearerTokenSecretName,omitempty"`
+	// BasicAuth sets the basic authentication credentials sent to the endpoint. Can't be set together with the
+	// bearer token.
+	//+kubebuilder:validation:Optional
+	BasicAuth *BasicAuth `json:"basicAuth,omitempty"`
+	// WriteRelabelings are applied to the metrics before they are sent, e.g. to send only a subset of them.
+	//+kubebuilder:validation:Optional
+	WriteRelabelings []RelabelConfig `json:"writeRelabelings,omitempty"`
+}
+
+// BasicAuth are basic authentication credentials.
+type BasicAuth struct {
+	// Username is the username sent to the endpoint.
+	Username string `json:"username"`
+	// PasswordSecretName is the name of the secret holding the password under the key "password".
+	PasswordSecretName string `json:"passwordSecretName"`
+}
+
+// RelabelConfig is a Prometheus metric relabeling rule.
+type RelabelConfig struct {
+	// SourceLabels are the labels whose values are concatenated with the separator and matched against the regex.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:items:Pattern=`^[a-zA-Z_][a-zA-Z0-9_]*$`
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+	// Separator is placed between the concatenated source label values. Defaults to ";".
+	//+kubebuilder:validation:Optional
+	Separator string `json:"separator,omitempty"`
+	// Regex is matched against the concatenated source label values. Defaults to "(.*)".
+	//+kubebuilder:validation:Optional
+	Regex string `json:"regex,omitempty"`
+	// TargetLabel is the label the replacement is written to.
+	//+kubebuilder:validation:Optional
+	TargetLabel string `json:"targetLabel,omitempty"`
+	// Replacement is written to the target label, with the regex capture groups expanded. Defaults to "$1".
+	//+kubebuilder:validation:Optional
+	Replacement *string `json:"replacement,omitempty"`
+	// Action is the relabeling action. Defaults to replace.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Enum=replace;keep;drop;labelmap;labeldrop;labelkeep
+	Action string `json:"action,omitempty"`
+}
+
+type MonitoringAuthSpec struct {
+	// BearerTokenSecretName is the name of the secret holding the bearer token under the key "token". The secret
+	// can be shared by the clusters of the namespace, so a single Prometheus can scrape all of them.
+	BearerTokenSecretName string `json:"bearerTokenSecretName"`
+}
+
+// LoggingSpec defines the forwarding of the logs of the control plane components, i.e. the API server,
+// konnectivity and the other k0s controller components as well as etcd, to an external log store, so the logs
+// are kept when the pods are restarted.
+type LoggingSpec struct {
+	// Enabled enables the log forwarder sidecar in the control plane and etcd pods.
+	Enabled bool `json:"enabled"`
+	// Image defines the image used for the fluent-bit log forwarder sidecar.
+	//+kubebuilder:default="fluent/fluent-bit:3.0.7"
+	Image string `json:"image"`
+	// Resources describes the compute resource requirements of the log forwarder sidecar.
+	//+kubebuilder:validation:Optional
+	Resources v1.ResourceRequirements `json:"resources,omitempty"`
+	// Labels are added to all the log records of the cluster, in addition to the k0smotron_cluster,
+	// k0smotron_namespace, component and pod labels.
+	//+kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// Outputs are the log stores the logs are forwarded to.
+	//+kubebuilder:validation:Optional
+	Outputs []LogOutput `json:"outputs,omitempty"`
+}
+
+// LogOutput is a log store the logs are forwarded to. Exactly the field matching the type must be set.
+type LogOutput struct {
+	// Type is the type of the log store.
+	//+kubebuilder:validation:Enum=loki;syslog;cloudwatch
+	Type string `json:"type"`
+	// Loki configures the forwarding to Grafana Loki.
+	//+kubebuilder:validation:Optional
+	Loki *LokiOutput `json:"loki,omitempty"`
+	// Syslog configures the forwarding to a syslog server.
+	//+kubebuilder:validation:Optional
+	Syslog *SyslogOutput `json:"syslog,omitempty"`
+	// CloudWatch configures the forwarding to Amazon CloudWatch Logs.
+	//+kubebuilder:validation:Optional
+	CloudWatch *CloudWatchOutput `json:"cloudWatch,omitempty"`
+}
+
+// LokiOutput configures the forwarding to Grafana Loki.
+type LokiOutput struct {
+	// URL is the URL of the Loki push API, e.g. https://loki.example.com/loki/api/v1/push.
+	//+kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+	// TenantID is sent as the X-Scope-OrgID header to the multi-tenant Loki.
+	//+kubebuilder:validation:Optional
+	TenantID string `json:"tenantID,omitempty"`
+	// BearerTokenSecretName is the name of the secret holding the bearer token sent to Loki under the key "token".
+	//+kubebuilder:validation:Optional
+	BearerTokenSecretName string `json:"bearerTokenSecretName,omitempty"`
+	// BasicAuth sets the basic authentication credentials sent to Loki. Can't be set together with the
+	// bearer token.
+	//+kubebuilder:validation:Optional
+	BasicAuth *BasicAuth `json:"basicAuth,omitempty"`
+}
+
+// SyslogOutput configures the forwarding to a syslog server.
+type SyslogOutput struct {
+	// Host is the address of the syslog server.
+	Host string `json:"host"`
+	// Port is the port of the syslog server.
+	//+kubebuilder:default=514
+	Port int `json:"port,omitempty"`
+	// Mode is the transport protocol used to send the messages.
+	//+kubebuilder:validation:Enum=udp;tcp;tls
+	//+kubebuilder:default=udp
+	Mode string `json:"mode,omitempty"`
+	// Format is the syslog message format.
+	//+kubebuilder:validation:Enum=rfc3164;rfc5424
+	//+kubebuilder:default=rfc5424
+	Format string `json:"format,omitempty"`
+}
+
+// CloudWatchOutput configures the forwarding to Amazon CloudWatch Logs.
+type CloudWatchOutput struct {
+	// Region is the AWS region of the log group.
+	Region string `json:"region"`
+	// LogGroupName is the name of the log group, which is created if it doesn't exist.
+	LogGroupName string `json:"logGroupName"`
+	// LogStreamPrefix is prepended to the names of the log streams, which are named after the pods.
+	// Defaults to "<namespace>.<cluster>.".
+	//+kubebuilder:validation:Optional
+	LogStreamPrefix string `json:"logStreamPrefix,omitempty"`
+	// CredentialsSecretName is the name of the secret holding the AWS credentials under the keys
+	// "AWS_ACCESS_KEY_ID" and "AWS_SECRET_ACCESS_KEY". If empty, the credentials are read from the environment
+	// of the nodes, e.g. the instance profile.
+	//+kubebuilder:validation:Optional
+	CredentialsSecretName string `json:"credentialsSecretName,omitempty"`
+}
+
+// GitOpsSpec defines the secrets registering the cluster as a deployment target of Argo CD and Flux. The secrets hold
+// the credentials of a cluster admin.
+type GitOpsSpec struct {
+	// ArgoCD enables the Argo CD cluster secret of the cluster, so the cluster is added to Argo CD.
+	//+kubebuilder:validation:Optional
+	ArgoCD bool `json:"argocd,omitempty"`
+	// ArgoCDNamespace is the namespace Argo CD is installed in, where the cluster secret is created.
+	//+kubebuilder:default=argocd
+	//+kubebuilder:validation:Optional
+	ArgoCDNamespace string `json:"argocdNamespace,omitempty"`
+	// Flux enables the kubeconfig secret of the cluster in the format expected by the spec.kubeConfig.secretRef of the
+	// Flux Kustomizations and HelmReleases.
+	//+kubebuilder:validation:Optional
+	Flux bool `json:"flux,omitempty"`
+	// FluxNamespace is the namespace of the Flux Kustomizations and HelmReleases deploying to the cluster, where the
+	// kubeconfig secret is created. Defaults to the namespace of the cluster.
+	//+kubebuilder:validation:Optional
+	FluxNamespace string `json:"fluxNamespace,omitempty"`
+	// Labels are added to the generated secrets, e.g. to select the cluster in the generators of the Argo CD
+	// ApplicationSets.
+	//+kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ClusterResourceSetSpec defines the ClusterResourceSet created for the cluster. The cluster is labeled to be selected
+// by it.
+type ClusterResourceSetSpec struct {
+	// Resources are the ConfigMaps and Secrets in the namespace of the cluster holding the manifests applied to the
+	// cluster. The Secrets must have the addons.cluster.x-k8s.io/resource-set type.
+	//+kubebuilder:validation:MinItems=1
+	Resources []ClusterResourceSetResource `json:"resources"`
+	// Strategy is the strategy of applying the resources. The resources are applied again when it changes.
+	//+kubebuilder:validation:Enum=ApplyOnce;Reconcile
+	//+kubebuilder:default=ApplyOnce
+	//+kubebuilder:validation:Optional
+	Strategy string `json:"strategy,omitempty"`
+}
+
+// ClusterResourceSetResource is a ConfigMap or a Secret holding the manifests applied to the cluster.
+type ClusterResourceSetResource struct {
+	// Name is the name of the ConfigMap or the Secret.
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Kind is the kind of the resource.
+	//+kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+}
+
+// HelmAddonsSpec defines the labels set on the Cluster API cluster for the HelmChartProxies. The
+// k0smotron.io/helm-addons-ready label is set along with them, so the charts are installed as soon as the cluster can
+// be reached.
+type HelmAddonsSpec struct {
+	// Labels are the labels matched by the clusterSelector of the HelmChartProxies, e.g. the CNI or the ingress
+	// controller installed in the cluster.
+	//+kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// BackupSpec defines the integration of the control plane with the backup tools.
+type BackupSpec struct {
+	// Velero annotates the control plane pods with the Velero backup hooks and generates the Velero Backup of the
+	// control plane, so the Velero backups of the management cluster produce restorable control planes. The backups
+	// are reported in the status of the cluster.
+	//+kubebuilder:validation:Optional
+	Velero *VeleroBackupSpec `json:"velero,omitempty"`
+}
+
+// VeleroBackupSpec defines the Velero backups of the control plane.
+type VeleroBackupSpec struct {
+	// Namespace is the namespace Velero runs in, used in the generated Backup and where the backups of the control
+	// plane are looked up.
+	//+kubebuilder:default=velero
+	//+kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+	// FSBackup opts the persistent volumes of the control plane pods in to the Velero file system backup. If false,
+	// the volumes are expected to be backed up with volume snapshots.
+	//+kubebuilder:validation:Optional
+	FSBackup bool `json:"fsBackup,omitempty"`
+}
+
+// NotificationsSpec defines the notifications about the events of the cluster.
+type NotificationsSpec struct {
+	// Webhooks are the endpoints the events of the cluster are posted to.
+	//+kubebuilder:validation:Optional
+	Webhooks []NotificationWebhook `json:"webhooks,omitempty"`
+}
+
+// NotificationWebhook defines an endpoint the events of the cluster are posted to, e.g. a CMDB or a chat webhook.
+type NotificationWebhook struct {
+	// URLSecretRef refers to a Secret in the namespace of the cluster holding the URL of the webhook in the url key.
+	URLSecretRef v1.LocalObjectReference `json:"urlSecretRef"`
+	// Format is the format of the payload: generic posts the event as a JSON object, slack posts a Slack-compatible
+	// message with the text of the event.
+	//+kubebuilder:validation:Enum=generic;slack
+	//+kubebuilder:default=generic
+	//+kubebuilder:validation:Optional
+	Format string `json:"format,omitempty"`
+	// Events are the types of the events posted to the webhook. All the events are posted if empty.
+	//+kubebuilder:validation:Optional
+	Events []NotificationEventType `json:"events,omitempty"`
+}
+
+// NotificationEventType is the type of an event about the cluster.
+// +kubebuilder:validation:Enum=ClusterCreated;ClusterReady;ClusterUpgraded;ClusterDeleted;BackupCompleted;BackupFailed;CertificateExpiring
+type NotificationEventType string
+
+// HubRegistration registers the cluster in a fleet manager.
+type HubRegistration struct {
+	// Type is the fleet manager: ocm installs the open-cluster-management klusterlet, rancher applies the
+	// registration manifest of the Rancher agent and sveltos creates a SveltosCluster.
+	//+kubebuilder:validation:Enum=ocm;rancher;sveltos
+	Type string `json:"type"`
+	// SecretRef refers to a Secret in the namespace of the cluster holding the credentials of the hub: the bootstrap
+	// kubeconfig of the hub in the kubeconfig key for ocm, the URL of the registration manifest in the manifestURL
+	// key for rancher, and the kubeconfig of the Sveltos management cluster in the kubeconfig key for sveltos.
+	// Optional for sveltos, which uses the management cluster of k0smotron if not set.
+	//+kubebuilder:validation:Optional
+	SecretRef *v1.LocalObjectReference `json:"secretRef,omitempty"`
+	// ClusterName is the name of the cluster in the hub. Defaults to the name of the cluster.
+	//+kubebuilder:validation:Optional
+	ClusterName string `json:"clusterName,omitempty"`
+	// Namespace is the namespace of the SveltosCluster in the Sveltos management cluster. Defaults to the namespace
+	// of the cluster. Only used by sveltos.
+	//+kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+	// Labels are set on the SveltosCluster, e.g. to select the cluster in the ClusterProfiles. Only used by sveltos.
+	//+kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type EtcdSpec struct {
@@ -246,6 +797,24 @@ type EtcdSpec struct {
 	// Persistence defines the persistence configuration.
 	//+kubebuilder:validation:Optional
 	Persistence EtcdPersistenceSpec `json:"persistence"`
+	// External makes the cluster use an existing etcd cluster instead of deploying its own etcd. The image, args
+	// and persistence settings are ignored if set.
+	//+kubebuilder:validation:Optional
+	External *ExternalEtcdSpec `json:"external,omitempty"`
+}
+
+// ExternalEtcdSpec defines the connection to an etcd cluster not managed by k0smotron.
+type ExternalEtcdSpec struct {
+	// Endpoints are the client URLs of the etcd members, e.g. https://etcd-0.example.com:2379.
+	//+kubebuilder:validation:MinItems=1
+	Endpoints []string `json:"endpoints"`
+	// ClientCertSecretRef is the Secret holding the CA certificate of the etcd cluster in ca.crt and the client
+	// certificate and key of the API server in tls.crt and tls.key.
+	ClientCertSecretRef v1.LocalObjectReference `json:"clientCertSecretRef"`
+	// EtcdPrefix is the prefix of the keys of the cluster, so several clusters can share one etcd cluster.
+	// Default: <namespace>-<name>
+	//+kubebuilder:validation:Optional
+	EtcdPrefix string `json:"etcdPrefix,omitempty"`
 }
 
 type EtcdPersistenceSpec struct {
@@ -258,6 +827,288 @@ type EtcdPersistenceSpec struct {
 	Size resource.Quantity `json:"size"`
 }
 
+type CertificatesSpec struct {
+	// CertManager configures cert-manager to issue and renew the API server serving certificate
+	// for the external address of the cluster, e.g. the LoadBalancer or Ingress hostname.
+	//+kubebuilder:validation:Optional
+	CertManager *CertManagerSpec `json:"certManager,omitempty"`
+}
+
+// WebhooksSpec defines the external webhooks of the API server.
+type WebhooksSpec struct {
+	// Authorization authorizes the requests not allowed by the Node and RBAC authorizers with a webhook.
+	//+kubebuilder:validation:Optional
+	Authorization *AuthorizationWebhookSpec `json:"authorization,omitempty"`
+	// Admission defines the credentials the API server presents to the validating and mutating admission webhooks.
+	//+kubebuilder:validation:Optional
+	Admission *AdmissionWebhookSpec `json:"admission,omitempty"`
+}
+
+// AuthorizationWebhookSpec defines the authorization webhook of the API server.
+// See https://kubernetes.io/docs/reference/access-authn-authz/webhook/
+type AuthorizationWebhookSpec struct {
+	// KubeconfigSecretRef refers to a Secret in the namespace of the cluster holding the kubeconfig of the webhook
+	// in the kubeconfig key.
+	KubeconfigSecretRef v1.LocalObjectReference `json:"kubeconfigSecretRef"`
+	// CacheAuthorizedTTL is the duration to cache the authorized responses of the webhook.
+	//+kubebuilder:validation:Optional
+	CacheAuthorizedTTL *metav1.Duration `json:"cacheAuthorizedTTL,omitempty"`
+	// CacheUnauthorizedTTL is the duration to cache the unauthorized responses of the webhook.
+	//+kubebuilder:validation:Optional
+	CacheUnauthorizedTTL *metav1.Duration `json:"cacheUnauthorizedTTL,omitempty"`
+}
+
+// AdmissionWebhookSpec defines the credentials of the API server for the admission webhooks.
+// See https://kubernetes.io/docs/reference/access-authn-authz/extensible-admission-controllers/#authenticate-apiservers
+type AdmissionWebhookSpec struct {
+	// KubeconfigSecretRef refers to a Secret in the namespace of the cluster holding the kubeconfig with the
+	// credentials of the webhooks in the kubeconfig key.
+	KubeconfigSecretRef v1.LocalObjectReference `json:"kubeconfigSecretRef"`
+}
+
+type CertManagerSpec struct {
+	// IssuerRef is the reference to the cert-manager issuer used to issue the API server serving certificate.
+	IssuerRef CertManagerIssuerRef `json:"issuerRef"`
+	// DNSNames defines additional DNS names of the API server serving certificate.
+	// The external address is always included if it is a DNS name.
+	//+kubebuilder:validation:Optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+}
+
+type CertManagerIssuerRef struct {
+	// Name of the issuer.
+	Name string `json:"name"`
+	// Kind of the issuer, Issuer or ClusterIssuer.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:default=Issuer
+	Kind string `json:"kind,omitempty"`
+	// Group of the issuer.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:default=cert-manager.io
+	Group string `json:"group,omitempty"`
+}
+
+type SecretStoreSpec struct {
+	// Provider defines the secret store provider. Kubernetes stores the credentials in Secrets,
+	// other providers must be configured for the k0smotron manager.
+	//+kubebuilder:validation:Enum=Kubernetes;Vault;AWSSecretsManager
+	//+kubebuilder:default=Kubernetes
+	Provider string `json:"provider,omitempty"`
+}
+
+type AccessControlSpec struct {
+	// ClusterRoleBindings defines the ClusterRoleBindings created in the cluster, e.g. to bind OIDC groups to cluster roles.
+	//+kubebuilder:validation:Optional
+	ClusterRoleBindings []ClusterRoleBindingSpec `json:"clusterRoleBindings,omitempty"`
+	// BreakGlassUser defines a user authenticated by a client certificate signed by the cluster CA.
+	// The kubeconfig of the user is stored in the <cluster name>-break-glass-kubeconfig secret.
+	//+kubebuilder:validation:Optional
+	BreakGlassUser *BreakGlassUserSpec `json:"breakGlassUser,omitempty"`
+}
+
+type ClusterRoleBindingSpec struct {
+	// Name of the ClusterRoleBinding.
+	Name string `json:"name"`
+	// ClusterRole is the name of the bound ClusterRole.
+	ClusterRole string `json:"clusterRole"`
+	// Subjects holds references to the users, groups or service accounts the role applies to.
+	// OIDC groups must include the prefix configured for the API server, if any.
+	//+kubebuilder:validation:MinItems=1
+	Subjects []rbacv1.Subject `json:"subjects"`
+}
+
+type BreakGlassUserSpec struct {
+	// Name of the user.
+	//+kubebuilder:default=k0smotron-break-glass
+	//+kubebuilder:validation:Pattern=`^[a-zA-Z0-9:._@-]+$`
+	Name string `json:"name,omitempty"`
+	// Groups of the user.
+	//+kubebuilder:default={"system:masters"}
+	//+kubebuilder:validation:items:Pattern=`^[a-zA-Z0-9:._@-]+$`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// WorkerProfile defines a k0s worker profile.
+type WorkerProfile struct {
+	// Name is the name of the profile.
+	//+kubebuilder:validation:Required
+	Name string `json:"name"`
+	// Values is the kubelet configuration of the profile.
+	//+kubebuilder:validation:Required
+	//+kubebuilder:pruning:PreserveUnknownFields
+	Values runtime.RawExtension `json:"values"`
+}
+
+// ExtensionsSpec defines the k0s extensions of the cluster.
+type ExtensionsSpec struct {
+	// Helm defines the Helm repositories and charts deployed in the cluster.
+	//+kubebuilder:validation:Optional
+	Helm *HelmExtensions `json:"helm,omitempty"`
+}
+
+// HelmExtensions defines the Helm repositories and charts deployed in the cluster by the k0s helm controller.
+type HelmExtensions struct {
+	// ConcurrencyLevel is the number of charts installed in parallel. If empty, the k0s default is used.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Minimum=1
+	ConcurrencyLevel int `json:"concurrencyLevel,omitempty"`
+	// Repositories are the Helm repositories the charts are installed from.
+	//+kubebuilder:validation:Optional
+	Repositories []HelmRepository `json:"repositories,omitempty"`
+	// Charts are the Helm charts installed in the cluster.
+	//+kubebuilder:validation:Optional
+	Charts []HelmChart `json:"charts,omitempty"`
+}
+
+// HelmRepository defines a Helm repository.
+type HelmRepository struct {
+	// Name is the name of the repository, used in the chart names, e.g. <name>/<chart>.
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// URL is the URL of the repository.
+	//+kubebuilder:validation:MinLength=1
+	URL string `json:"url"`
+	// CAFile is the path of the CA bundle verifying the repository in the controller pods.
+	//+kubebuilder:validation:Optional
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile is the path of the client certificate in the controller pods.
+	//+kubebuilder:validation:Optional
+	CertFile string `json:"certFile,omitempty"`
+	// KeyFile is the path of the client key in the controller pods.
+	//+kubebuilder:validation:Optional
+	KeyFile string `json:"keyfile,omitempty"`
+	// Insecure skips the verification of the repository certificate.
+	//+kubebuilder:validation:Optional
+	Insecure bool `json:"insecure,omitempty"`
+	// Username is the username of the repository.
+	//+kubebuilder:validation:Optional
+	Username string `json:"username,omitempty"`
+	// Password is the password of the repository. Note, that it is stored in plain text in the k0s configuration.
+	//+kubebuilder:validation:Optional
+	Password string `json:"password,omitempty"`
+}
+
+// HelmChart defines a Helm chart release.
+type HelmChart struct {
+	// Name is the name of the release.
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// ChartName is the chart to install, e.g. <repository>/<chart> or an oci:// reference.
+	//+kubebuilder:validation:MinLength=1
+	ChartName string `json:"chartname"`
+	// Version is the version of the chart. If empty, the latest version is installed.
+	//+kubebuilder:validation:Optional
+	Version string `json:"version,omitempty"`
+	// Namespace is the namespace the release is installed in.
+	//+kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+	// Values are the values of the release.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:pruning:PreserveUnknownFields
+	Values *runtime.RawExtension `json:"values,omitempty"`
+	// Timeout is the timeout of the installation and the upgrades of the release. If empty, the k0s default is used.
+	//+kubebuilder:validation:Optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Order defines the order the charts are installed in, lower first.
+	//+kubebuilder:validation:Optional
+	Order int `json:"order,omitempty"`
+}
+
+// CNISpec defines the network provider of the cluster.
+type CNISpec struct {
+	// Preset is the network provider: calico is deployed by k0s, cilium with a Helm extension, and none leaves
+	// deploying the network provider to the user.
+	//+kubebuilder:validation:Enum=calico;cilium;none
+	Preset string `json:"preset"`
+	// Calico configures the calico preset.
+	//+kubebuilder:validation:Optional
+	Calico *CalicoCNISpec `json:"calico,omitempty"`
+	// Cilium configures the cilium preset.
+	//+kubebuilder:validation:Optional
+	Cilium *CiliumCNISpec `json:"cilium,omitempty"`
+}
+
+// CalicoCNISpec defines the options of the calico preset.
+type CalicoCNISpec struct {
+	// Mode is the encapsulation mode of the pod network.
+	//+kubebuilder:validation:Enum=vxlan;ipip;bird
+	//+kubebuilder:default=vxlan
+	Mode string `json:"mode,omitempty"`
+	// MTU is the MTU of the pod network. If empty, the k0s default is used.
+	//+kubebuilder:validation:Optional
+	MTU int `json:"mtu,omitempty"`
+}
+
+// CiliumCNISpec defines the options of the cilium preset.
+type CiliumCNISpec struct {
+	// Version is the version of the cilium chart.
+	//+kubebuilder:default="1.15.6"
+	Version string `json:"version,omitempty"`
+	// KubeProxyReplacement replaces kube-proxy with cilium, so k0s doesn't deploy kube-proxy and cilium reaches the API
+	// server through the external address of the cluster.
+	//+kubebuilder:validation:Optional
+	KubeProxyReplacement bool `json:"kubeProxyReplacement,omitempty"`
+	// Hubble enables Hubble and its relay.
+	//+kubebuilder:validation:Optional
+	Hubble bool `json:"hubble,omitempty"`
+	// Values are merged over the values rendered from the options.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:pruning:PreserveUnknownFields
+	Values *runtime.RawExtension `json:"values,omitempty"`
+}
+
+// ManifestBundle defines a bundle of manifests applied to the cluster. Exactly one source must be set.
+type ManifestBundle struct {
+	// Name is the name of the bundle. The applied objects are labeled with it.
+	//+kubebuilder:validation:MinLength=1
+	//+kubebuilder:validation:MaxLength=63
+	Name string `json:"name"`
+	// Inline holds the manifests of the bundle as YAML documents.
+	//+kubebuilder:validation:Optional
+	Inline string `json:"inline,omitempty"`
+	// ConfigMapRef refers to a ConfigMap in the namespace of the cluster holding the manifests in its keys. The keys
+	// are applied in alphabetical order.
+	//+kubebuilder:validation:Optional
+	ConfigMapRef *v1.LocalObjectReference `json:"configMapRef,omitempty"`
+	// URL is the HTTP or HTTPS address the manifests are downloaded from.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url,omitempty"`
+}
+
+// K0sConfigRef refers to a k0s configuration stored outside of the object, e.g. a baseline configuration shared
+// by many clusters. Exactly one source must be set.
+type K0sConfigRef struct {
+	// ConfigMapRef refers to a ConfigMap in the namespace of the object holding the k0s configuration.
+	//+kubebuilder:validation:Optional
+	ConfigMapRef *v1.LocalObjectReference `json:"configMapRef,omitempty"`
+	// SecretRef refers to a Secret in the namespace of the object holding the k0s configuration.
+	//+kubebuilder:validation:Optional
+	SecretRef *v1.LocalObjectReference `json:"secretRef,omitempty"`
+	// Key is the key of the ConfigMap or the Secret holding the k0s configuration.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:default=k0s.yaml
+	Key string `json:"key,omitempty"`
+	// OCI refers to an OCI artifact holding the k0s configuration.
+	//+kubebuilder:validation:Optional
+	OCI *OCIArtifactRef `json:"oci,omitempty"`
+}
+
+// OCIArtifactRef refers to an OCI artifact with a single layer, e.g. a file pushed with `oras push`.
+type OCIArtifactRef struct {
+	// Reference is the reference of the artifact, e.g. ghcr.io/acme/k0s-config:v1 or
+	// ghcr.io/acme/k0s-config@sha256:... A tag is resolved again on every reconciliation.
+	//+kubebuilder:validation:MinLength=1
+	Reference string `json:"reference"`
+	// PullSecretRef refers to a Secret of type kubernetes.io/dockerconfigjson in the namespace of the object
+	// holding the credentials of the registry.
+	//+kubebuilder:validation:Optional
+	PullSecretRef *v1.LocalObjectReference `json:"pullSecretRef,omitempty"`
+	// Insecure pulls the artifact over plain HTTP.
+	//+kubebuilder:validation:Optional
+	Insecure bool `json:"insecure,omitempty"`
+}
+
 type CertificateRef struct {
 	//+kubebuilder:validation:Enum=ca;sa;proxy;etcd;apiserver-etcd-client;etcd-peer;etcd-server
 	Type string `json:"type"`
@@ -285,6 +1136,17 @@ func (kmc *Cluster) GetAdminConfigSecretName() string {
 	return fmt.Sprintf("%s-kubeconfig", kmc.Name)
 }
 
+// GetArgoCDSecretName returns the name of the Argo CD cluster secret. The namespace of the cluster is a part of the
+// name, as the secrets of all the clusters are created in the namespace of Argo CD.
+func (kmc *Cluster) GetArgoCDSecretName() string {
+	return fmt.Sprintf("kmc-%s-%s-argocd", kmc.Namespace, kmc.Name)
+}
+
+// GetFluxKubeconfigSecretName returns the name of the Flux kubeconfig secret, unique across the namespaces as well.
+func (kmc *Cluster) GetFluxKubeconfigSecretName() string {
+	return fmt.Sprintf("kmc-%s-%s-flux-kubeconfig", kmc.Namespace, kmc.Name)
+}
+
 func (kmc *Cluster) GetEntrypointConfigMapName() string {
 	return fmt.Sprintf("kmc-entrypoint-%s-config", kmc.Name)
 }
@@ -293,6 +1155,57 @@ func (kmc *Cluster) GetMonitoringConfigMapName() string {
 	return fmt.Sprintf("kmc-prometheus-%s-config", kmc.Name)
 }
 
+func (kmc *Cluster) GetMonitoringAuthSecretName() string {
+	return fmt.Sprintf("kmc-prometheus-%s-auth", kmc.Name)
+}
+
+func (kmc *Cluster) GetLoggingConfigMapName() string {
+	return fmt.Sprintf("kmc-fluent-bit-%s-config", kmc.Name)
+}
+
+func (kmc *Cluster) GetPodMonitorName() string {
+	return fmt.Sprintf("kmc-%s", kmc.Name)
+}
+
+func (kmc *Cluster) GetAPIServingCertificateName() string {
+	return fmt.Sprintf("kmc-%s-api-serving", kmc.Name)
+}
+
+func (kmc *Cluster) GetAPIServingCertificateSecretName() string {
+	return fmt.Sprintf("kmc-%s-api-serving-cert", kmc.Name)
+}
+
+// GetSecretStoreProvider returns the secret store provider of the cluster or empty string if the default store should be used.
+func (kmc *Cluster) GetSecretStoreProvider() string {
+	if kmc.Spec.SecretStore == nil {
+		return ""
+	}
+	return kmc.Spec.SecretStore.Provider
+}
+
+func (kmc *Cluster) GetBreakGlassConfigSecretName() string {
+	return fmt.Sprintf("%s-break-glass-kubeconfig", kmc.Name)
+}
+
+func (kmc *Cluster) GetAccessControlConfigMapName() string {
+	return fmt.Sprintf("kmc-%s-access-control", kmc.Name)
+}
+
+// GetVeleroBackupConfigMapName returns the name of the configmap holding the Velero Backup of the cluster.
+func (kmc *Cluster) GetVeleroBackupConfigMapName() string {
+	return fmt.Sprintf("kmc-%s-velero-backup", kmc.Name)
+}
+
+// GetDNSEndpointName returns the name of the external-dns DNSEndpoint publishing the API endpoint of the cluster.
+func (kmc *Cluster) GetDNSEndpointName() string {
+	return fmt.Sprintf("kmc-%s-api", kmc.Name)
+}
+
+// GetWebhooksConfigMapName returns the name of the configmap holding the admission configuration of the API server.
+func (kmc *Cluster) GetWebhooksConfigMapName() string {
+	return fmt.Sprintf("kmc-%s-webhooks", kmc.Name)
+}
+
 func (kmc *Cluster) GetConfigMapName() string {
 	return fmt.Sprintf("kmc-%s-config", kmc.Name)
 }
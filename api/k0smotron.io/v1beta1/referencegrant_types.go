@@ -0,0 +1,101 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReferenceGrantSpec identifies the resources in other namespaces that are trusted
+// to reference the resources in the namespace of the ReferenceGrant.
+type ReferenceGrantSpec struct {
+	// From describes the trusted namespaces and kinds that can reference the resources described in To.
+	//+kubebuilder:validation:MinItems=1
+	From []ReferenceGrantFrom `json:"from"`
+	// To describes the resources that may be referenced by the resources described in From.
+	//+kubebuilder:validation:MinItems=1
+	To []ReferenceGrantTo `json:"to"`
+}
+
+// ReferenceGrantFrom describes the trusted namespace and kind of the referencing resource.
+type ReferenceGrantFrom struct {
+	// Group of the referencing resource, e.g. k0smotron.io.
+	Group string `json:"group"`
+	// Kind of the referencing resource, e.g. JoinTokenRequest.
+	Kind string `json:"kind"`
+	// Namespace of the referencing resource.
+	Namespace string `json:"namespace"`
+}
+
+// ReferenceGrantTo describes the resource that may be referenced.
+type ReferenceGrantTo struct {
+	// Group of the referenced resource, e.g. k0smotron.io.
+	Group string `json:"group"`
+	// Kind of the referenced resource, e.g. Cluster.
+	Kind string `json:"kind"`
+	// Name of the referenced resource. If empty, all resources of the given group and kind
+	// in the namespace of the ReferenceGrant may be referenced.
+	//+kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:shortName=refgrant
+
+// ReferenceGrant allows resources in other namespaces to reference resources in the namespace of the ReferenceGrant.
+type ReferenceGrant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ReferenceGrantSpec `json:"spec,omitempty"`
+}
+
+// Permits returns true if the grant allows the given resource to reference the resource
+// with the given group, kind and name in the namespace of the grant.
+func (g *ReferenceGrant) Permits(from ReferenceGrantFrom, to ReferenceGrantTo) bool {
+	fromAllowed := false
+	for _, f := range g.Spec.From {
+		if f.Group == from.Group && f.Kind == from.Kind && f.Namespace == from.Namespace {
+			fromAllowed = true
+			break
+		}
+	}
+	if !fromAllowed {
+		return false
+	}
+
+	for _, t := range g.Spec.To {
+		if t.Group == to.Group && t.Kind == to.Kind && (t.Name == "" || t.Name == to.Name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//+kubebuilder:object:root=true
+
+// ReferenceGrantList contains a list of ReferenceGrant
+type ReferenceGrantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ReferenceGrant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ReferenceGrant{}, &ReferenceGrantList{})
+}
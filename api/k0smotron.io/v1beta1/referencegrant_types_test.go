@@ -0,0 +1,122 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferenceGrant_Permits(t *testing.T) {
+	tokenRequestFrom := ReferenceGrantFrom{Group: "k0smotron.io", Kind: "JoinTokenRequest", Namespace: "tenant"}
+
+	tests := []struct {
+		name  string
+		grant ReferenceGrantSpec
+		from  ReferenceGrantFrom
+		to    ReferenceGrantTo
+		want  bool
+	}{
+		{
+			name: "matching name",
+			grant: ReferenceGrantSpec{
+				From: []ReferenceGrantFrom{tokenRequestFrom},
+				To:   []ReferenceGrantTo{{Group: "k0smotron.io", Kind: "Cluster", Name: "my-cluster"}},
+			},
+			from: tokenRequestFrom,
+			to:   ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "my-cluster"},
+			want: true,
+		},
+		{
+			name: "different name",
+			grant: ReferenceGrantSpec{
+				From: []ReferenceGrantFrom{tokenRequestFrom},
+				To:   []ReferenceGrantTo{{Group: "k0smotron.io", Kind: "Cluster", Name: "my-cluster"}},
+			},
+			from: tokenRequestFrom,
+			to:   ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "another-cluster"},
+			want: false,
+		},
+		{
+			name: "empty name allows all clusters in namespace",
+			grant: ReferenceGrantSpec{
+				From: []ReferenceGrantFrom{tokenRequestFrom},
+				To:   []ReferenceGrantTo{{Group: "k0smotron.io", Kind: "Cluster"}},
+			},
+			from: tokenRequestFrom,
+			to:   ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "another-cluster"},
+			want: true,
+		},
+		{
+			name: "different from namespace",
+			grant: ReferenceGrantSpec{
+				From: []ReferenceGrantFrom{tokenRequestFrom},
+				To:   []ReferenceGrantTo{{Group: "k0smotron.io", Kind: "Cluster"}},
+			},
+			from: ReferenceGrantFrom{Group: "k0smotron.io", Kind: "JoinTokenRequest", Namespace: "other"},
+			to:   ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "my-cluster"},
+			want: false,
+		},
+		{
+			name: "different from kind",
+			grant: ReferenceGrantSpec{
+				From: []ReferenceGrantFrom{tokenRequestFrom},
+				To:   []ReferenceGrantTo{{Group: "k0smotron.io", Kind: "Cluster"}},
+			},
+			from: ReferenceGrantFrom{Group: "k0smotron.io", Kind: "Cluster", Namespace: "tenant"},
+			to:   ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "my-cluster"},
+			want: false,
+		},
+		{
+			name: "different from group",
+			grant: ReferenceGrantSpec{
+				From: []ReferenceGrantFrom{tokenRequestFrom},
+				To:   []ReferenceGrantTo{{Group: "k0smotron.io", Kind: "Cluster"}},
+			},
+			from: ReferenceGrantFrom{Group: "cluster.x-k8s.io", Kind: "JoinTokenRequest", Namespace: "tenant"},
+			to:   ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "my-cluster"},
+			want: false,
+		},
+		{
+			name: "different to kind",
+			grant: ReferenceGrantSpec{
+				From: []ReferenceGrantFrom{tokenRequestFrom},
+				To:   []ReferenceGrantTo{{Group: "k0smotron.io", Kind: "Cluster"}},
+			},
+			from: tokenRequestFrom,
+			to:   ReferenceGrantTo{Group: "k0smotron.io", Kind: "JoinTokenRequest", Name: "my-cluster"},
+			want: false,
+		},
+		{
+			name: "different to group",
+			grant: ReferenceGrantSpec{
+				From: []ReferenceGrantFrom{tokenRequestFrom},
+				To:   []ReferenceGrantTo{{Group: "k0smotron.io", Kind: "Cluster"}},
+			},
+			from: tokenRequestFrom,
+			to:   ReferenceGrantTo{Group: "cluster.x-k8s.io", Kind: "Cluster", Name: "my-cluster"},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &ReferenceGrant{Spec: tt.grant}
+			require.Equal(t, tt.want, g.Permits(tt.from, tt.to))
+		})
+	}
+}
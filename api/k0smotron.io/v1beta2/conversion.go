@@ -0,0 +1,291 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/json"
+	utilconversion "sigs.k8s.io/cluster-api/util/conversion"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+const (
+	k0sConfigAPIVersion = "k0s.k0sproject.io/v1beta1"
+	k0sConfigKind       = "ClusterConfig"
+)
+
+// ConvertTo converts the Cluster to the hub version.
+func (src *Cluster) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.Cluster)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	if err := ConvertClusterSpecToHub(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	dst.Status = v1beta1.ClusterStatus{
+		ReconciliationStatus: reconciliationStatusFromConditions(src.Status.Conditions),
+		Ready:                src.Status.Ready,
+		Replicas:             src.Status.Replicas,
+		Selector:             src.Status.Selector,
+		ObservedGeneration:   src.Status.ObservedGeneration,
+	}
+	for _, c := range src.Status.Components {
+		dst.Status.Components = append(dst.Status.Components, v1beta1.ComponentHealth(c))
+	}
+	for _, r := range src.Status.ReplicaStatus {
+		dst.Status.ReplicaStatus = append(dst.Status.ReplicaStatus, v1beta1.ReplicaStatus(r))
+	}
+	if src.Status.Backup != nil {
+		dst.Status.Backup = (*v1beta1.BackupStatus)(src.Status.Backup.DeepCopy())
+	}
+	// The Reconciled condition is kept in the reconciliation status of the hub version
+	for _, c := range src.Status.Conditions {
+		if c.Type != ReconciledCondition {
+			dst.Status.Conditions = append(dst.Status.Conditions, *c.DeepCopy())
+		}
+	}
+
+	restored := &v1beta1.Cluster{}
+	if ok, err := utilconversion.UnmarshalData(dst, restored); err != nil || !ok {
+		return err
+	}
+	// Restore the hub-only fields
+	RestoreK0sConfigMeta(restored.Spec.K0sConfig, dst.Spec.K0sConfig)
+	dst.Spec.WorkerProfiles = restored.Spec.WorkerProfiles
+
+	return nil
+}
+
+// ConvertFrom converts the hub version to the Cluster.
+func (dst *Cluster) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.Cluster)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	if err := ConvertClusterSpecFromHub(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	dst.Status = ClusterStatus{
+		Ready:              src.Status.Ready,
+		Replicas:           src.Status.Replicas,
+		Selector:           src.Status.Selector,
+		ObservedGeneration: src.Status.ObservedGeneration,
+		Conditions:         conditionsFromReconciliationStatus(src.Status.ReconciliationStatus, src.CreationTimestamp),
+	}
+	for _, c := range src.Status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, *c.DeepCopy())
+	}
+	for _, c := range src.Status.Components {
+		dst.Status.Components = append(dst.Status.Components, ComponentHealth(c))
+	}
+	for _, r := range src.Status.ReplicaStatus {
+		dst.Status.ReplicaStatus = append(dst.Status.ReplicaStatus, ReplicaStatus(r))
+	}
+	if src.Status.Backup != nil {
+		dst.Status.Backup = (*BackupStatus)(src.Status.Backup.DeepCopy())
+	}
+
+	// Keep the hub version in an annotation to restore the fields that have no v1beta2 representation.
+	return utilconversion.MarshalData(src, dst)
+}
+
+// ConvertClusterSpecToHub converts the ClusterSpec to the hub version.
+func ConvertClusterSpecToHub(src *ClusterSpec, dst *v1beta1.ClusterSpec) error {
+	spec := src.DeepCopy()
+	spec.K0sConfig = nil
+	*dst = v1beta1.ClusterSpec{}
+	if err := convertJSON(spec, dst); err != nil {
+		return err
+	}
+
+	k0sConfig, err := convertK0sConfigToHub(src.K0sConfig)
+	if err != nil {
+		return fmt.Errorf("failed to convert k0s config: %w", err)
+	}
+	dst.K0sConfig = k0sConfig
+
+	return nil
+}
+
+// ConvertClusterSpecFromHub converts the hub version of the ClusterSpec to the ClusterSpec.
+func ConvertClusterSpecFromHub(src *v1beta1.ClusterSpec, dst *ClusterSpec) error {
+	spec := src.DeepCopy()
+	spec.K0sConfig = nil
+	*dst = ClusterSpec{}
+	if err := convertJSON(spec, dst); err != nil {
+		return err
+	}
+
+	k0sConfig, err := convertK0sConfigFromHub(src.K0sConfig)
+	if err != nil {
+		return fmt.Errorf("failed to convert k0s config: %w", err)
+	}
+	dst.K0sConfig = k0sConfig
+
+	return nil
+}
+
+// RestoreK0sConfigMeta restores the apiVersion, kind and metadata of the k0s config, which are not
+// part of the v1beta2 k0s config.
+func RestoreK0sConfigMeta(restored *unstructured.Unstructured, dst *unstructured.Unstructured) {
+	if restored == nil || dst == nil {
+		return
+	}
+	dst.SetAPIVersion(restored.GetAPIVersion())
+	dst.SetKind(restored.GetKind())
+	if metadata, ok := restored.Object["metadata"]; ok {
+		dst.Object["metadata"] = runtime.DeepCopyJSONValue(metadata)
+	}
+}
+
+func convertK0sConfigToHub(src *K0sConfig) (*unstructured.Unstructured, error) {
+	if src == nil {
+		return nil, nil
+	}
+
+	spec := map[string]interface{}{}
+	if src.Extra != nil && len(src.Extra.Raw) > 0 {
+		if err := json.Unmarshal(src.Extra.Raw, &spec); err != nil {
+			return nil, err
+		}
+	}
+
+	typed := src.DeepCopy()
+	typed.Extra = nil
+	typedSpec := map[string]interface{}{}
+	if err := convertJSON(typed, &typedSpec); err != nil {
+		return nil, err
+	}
+	mergeMaps(spec, typedSpec)
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": k0sConfigAPIVersion,
+		"kind":       k0sConfigKind,
+		"spec":       spec,
+	}}, nil
+}
+
+func convertK0sConfigFromHub(src *unstructured.Unstructured) (*K0sConfig, error) {
+	if src == nil {
+		return nil, nil
+	}
+
+	spec, _, err := unstructured.NestedMap(src.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+
+	dst := &K0sConfig{}
+	if err := convertJSON(spec, dst); err != nil {
+		return nil, err
+	}
+	dst.Extra = nil
+
+	// The typed fields are removed from the rest of the config
+	typedSpec := map[string]interface{}{}
+	if err := convertJSON(dst, &typedSpec); err != nil {
+		return nil, err
+	}
+	removeMapKeys(spec, typedSpec)
+
+	if len(spec) > 0 {
+		raw, err := json.Marshal(spec)
+		if err != nil {
+			return nil, err
+		}
+		dst.Extra = &runtime.RawExtension{Raw: raw}
+	}
+
+	return dst, nil
+}
+
+func reconciliationStatusFromConditions(conditions []metav1.Condition) string {
+	c := meta.FindStatusCondition(conditions, ReconciledCondition)
+	if c == nil {
+		return ""
+	}
+	if c.Status == metav1.ConditionTrue {
+		return v1beta1.ReconciliationSuccessful
+	}
+	return c.Message
+}
+
+// conditionsFromReconciliationStatus converts the reconciliation status to the Reconciled condition.
+// The hub version doesn't track the time of the transition, so the creation time of the object is used.
+func conditionsFromReconciliationStatus(status string, created metav1.Time) []metav1.Condition {
+	if status == "" {
+		return nil
+	}
+	if status == v1beta1.ReconciliationSuccessful {
+		return []metav1.Condition{{
+			Type:               ReconciledCondition,
+			Status:             metav1.ConditionTrue,
+			Reason:             ReconciliationSucceededReason,
+			LastTransitionTime: created,
+		}}
+	}
+	return []metav1.Condition{{
+		Type:               ReconciledCondition,
+		Status:             metav1.ConditionFalse,
+		Reason:             ReconciliationFailedReason,
+		Message:            status,
+		LastTransitionTime: created,
+	}}
+}
+
+// convertJSON converts the fields of src to dst by their JSON representation.
+func convertJSON(src interface{}, dst interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// mergeMaps merges src to dst recursively, the values of src take precedence.
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeMaps(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// removeMapKeys removes the keys of keys from m recursively, the emptied maps are removed as well.
+func removeMapKeys(m, keys map[string]interface{}) {
+	for k, v := range keys {
+		keysMap, keysIsMap := v.(map[string]interface{})
+		mMap, mIsMap := m[k].(map[string]interface{})
+		if keysIsMap && mIsMap {
+			removeMapKeys(mMap, keysMap)
+			if len(mMap) > 0 {
+				continue
+			}
+		}
+		delete(m, k)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"fmt"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	runtimeserializer "k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/utils/ptr"
+	utilconversion "sigs.k8s.io/cluster-api/util/conversion"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+
+	"github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestCluster_IsConvertible(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1beta1.AddToScheme(scheme))
+	require.NoError(t, AddToScheme(scheme))
+
+	ok, err := conversion.IsConvertible(scheme, &v1beta1.Cluster{})
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestCluster_ConvertFrom(t *testing.T) {
+	hub := &v1beta1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1beta1.ClusterSpec{
+			Replicas: 3,
+			Version:  "v1.28.4-k0s.0",
+			Service:  v1beta1.ServiceSpec{Type: "LoadBalancer", APIPort: 6443},
+			K0sConfig: &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "k0s.k0sproject.io/v1beta1",
+				"kind":       "ClusterConfig",
+				"metadata":   map[string]interface{}{"name": "k0s"},
+				"spec": map[string]interface{}{
+					"api": map[string]interface{}{
+						"sans": []interface{}{"example.com"},
+					},
+					"network": map[string]interface{}{
+						"provider": "calico",
+						"calico":   map[string]interface{}{"mode": "vxlan"},
+					},
+					"telemetry": map[string]interface{}{"enabled": false},
+					"extensions": map[string]interface{}{
+						"helm": map[string]interface{}{"concurrencyLevel": int64(5)},
+					},
+				},
+			}},
+		},
+		Status: v1beta1.ClusterStatus{
+			ReconciliationStatus: "Failed reconciling services",
+			Replicas:             3,
+			ObservedGeneration:   2,
+			Conditions: []metav1.Condition{{
+				Type:    v1beta1.ReadyCondition,
+				Status:  metav1.ConditionFalse,
+				Reason:  v1beta1.ServiceNotReadyReason,
+				Message: "Waiting for the load balancer address",
+			}},
+		},
+	}
+
+	kmc := &Cluster{}
+	require.NoError(t, kmc.ConvertFrom(hub))
+
+	require.Equal(t, int32(3), kmc.Spec.Replicas)
+	require.Equal(t, "LoadBalancer", string(kmc.Spec.Service.Type))
+	require.Equal(t, &K0sAPISpec{SANs: []string{"example.com"}}, kmc.Spec.K0sConfig.API)
+	require.Equal(t, &K0sNetworkSpec{Provider: "calico"}, kmc.Spec.K0sConfig.Network)
+	require.Equal(t, &K0sTelemetrySpec{Enabled: ptr.To(false)}, kmc.Spec.K0sConfig.Telemetry)
+	require.JSONEq(t, `{"network":{"calico":{"mode":"vxlan"}},"extensions":{"helm":{"concurrencyLevel":5}}}`, string(kmc.Spec.K0sConfig.Extra.Raw))
+
+	c := meta.FindStatusCondition(kmc.Status.Conditions, ReconciledCondition)
+	require.NotNil(t, c)
+	require.Equal(t, metav1.ConditionFalse, c.Status)
+	require.Equal(t, "Failed reconciling services", c.Message)
+	require.NotNil(t, meta.FindStatusCondition(kmc.Status.Conditions, v1beta1.ReadyCondition))
+	require.Equal(t, int64(2), kmc.Status.ObservedGeneration)
+	require.Contains(t, kmc.Annotations, utilconversion.DataAnnotation)
+	require.NotContains(t, hub.Annotations, utilconversion.DataAnnotation)
+}
+
+func TestCluster_ConvertTo(t *testing.T) {
+	kmc := &Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: ClusterSpec{
+			Replicas: 1,
+			K0sConfig: &K0sConfig{
+				Network: &K0sNetworkSpec{PodCIDR: "10.10.0.0/16"},
+				Extra:   &runtime.RawExtension{Raw: []byte(`{"network":{"podCIDR":"10.20.0.0/16","kuberouter":{"mtu":1400}}}`)},
+			},
+		},
+		Status: ClusterStatus{
+			Ready: true,
+			Conditions: []metav1.Condition{{
+				Type:   ReconciledCondition,
+				Status: metav1.ConditionTrue,
+				Reason: ReconciliationSucceededReason,
+			}},
+		},
+	}
+
+	hub := &v1beta1.Cluster{}
+	require.NoError(t, kmc.ConvertTo(hub))
+
+	require.Equal(t, map[string]interface{}{
+		"apiVersion": "k0s.k0sproject.io/v1beta1",
+		"kind":       "ClusterConfig",
+		"spec": map[string]interface{}{
+			"network": map[string]interface{}{
+				"podCIDR":    "10.10.0.0/16",
+				"kuberouter": map[string]interface{}{"mtu": int64(1400)},
+			},
+		},
+	}, hub.Spec.K0sConfig.Object)
+	require.Equal(t, v1beta1.ClusterStatus{ReconciliationStatus: v1beta1.ReconciliationSuccessful, Ready: true}, hub.Status)
+}
+
+func TestFuzzyConversion(t *testing.T) {
+	t.Run("for Cluster", utilconversion.FuzzTestFunc(utilconversion.FuzzTestFuncInput{
+		Hub:         &v1beta1.Cluster{},
+		Spoke:       &Cluster{},
+		FuzzerFuncs: []fuzzer.FuzzerFuncs{fuzzFuncs},
+	}))
+}
+
+func fuzzFuncs(_ runtimeserializer.CodecFactory) []interface{} {
+	return []interface{}{
+		fuzzK0sConfig,
+		fuzzHubK0sConfig,
+		fuzzClusterStatus,
+		fuzzHubWorkerProfile,
+	}
+}
+
+// fuzzK0sConfig keeps the fields of Extra apart from the typed fields, which take precedence over them.
+func fuzzK0sConfig(in *K0sConfig, c fuzz.Continue) {
+	c.FuzzNoCustom(in)
+	in.Extra = nil
+	if c.RandBool() {
+		in.Extra = &runtime.RawExtension{Raw: []byte(fmt.Sprintf(`{"extensions":{"helm":{"concurrencyLevel":%d}}}`, c.Uint32()))}
+	}
+}
+
+// fuzzHubK0sConfig generates a k0s config of the hub version from the fields the v1beta2 k0s config represents,
+// and the metadata which is restored from the conversion data annotation.
+func fuzzHubK0sConfig(in *unstructured.Unstructured, c fuzz.Continue) {
+	var spoke K0sConfig
+	c.Fuzz(&spoke)
+	hub, err := convertK0sConfigToHub(&spoke)
+	if err != nil {
+		panic(err)
+	}
+	if c.RandBool() {
+		hub.SetName(c.RandString())
+	}
+	*in = *hub
+}
+
+// fuzzClusterStatus removes the Reconciled condition, which is converted to the reconciliation status of the hub
+// version, see TestCluster_ConvertFrom.
+func fuzzClusterStatus(in *ClusterStatus, c fuzz.Continue) {
+	c.FuzzNoCustom(in)
+	meta.RemoveStatusCondition(&in.Conditions, ReconciledCondition)
+}
+
+// fuzzHubWorkerProfile sets the values of the worker profile to a JSON object with sorted keys, as they are stored
+// in the conversion data annotation.
+func fuzzHubWorkerProfile(in *v1beta1.WorkerProfile, c fuzz.Continue) {
+	in.Name = c.RandString()
+	in.Values = runtime.RawExtension{Raw: []byte(fmt.Sprintf(`{"kubeletConfiguration":{"maxPods":%d}}`, c.Uint32()))}
+}
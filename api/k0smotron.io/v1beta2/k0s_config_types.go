@@ -0,0 +1,83 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// K0sConfig is the spec of the k0s ClusterConfig. The commonly used fields are typed, the rest of the
+// configuration is kept as is in Extra. @see https://docs.k0sproject.io/stable/configuration/
+type K0sConfig struct {
+	// API defines the k0s API configuration.
+	//+kubebuilder:validation:Optional
+	API *K0sAPISpec `json:"api,omitempty"`
+	// Network defines the k0s network configuration.
+	//+kubebuilder:validation:Optional
+	Network *K0sNetworkSpec `json:"network,omitempty"`
+	// Telemetry defines the k0s telemetry configuration.
+	//+kubebuilder:validation:Optional
+	Telemetry *K0sTelemetrySpec `json:"telemetry,omitempty"`
+	// Extra defines the rest of the k0s ClusterConfig spec, e.g. extensions or worker profiles.
+	// The typed fields take precedence over the same fields in Extra.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:pruning:PreserveUnknownFields
+	Extra *runtime.RawExtension `json:"extra,omitempty"`
+}
+
+// K0sAPISpec defines the k0s API configuration.
+type K0sAPISpec struct {
+	// ExternalAddress is the address of the API server advertised to the nodes.
+	//+kubebuilder:validation:Optional
+	ExternalAddress string `json:"externalAddress,omitempty"`
+	// SANs defines the additional subject alternative names of the API server certificate.
+	//+kubebuilder:validation:Optional
+	SANs []string `json:"sans,omitempty"`
+	// Port is the port of the API server.
+	//+kubebuilder:validation:Optional
+	Port int `json:"port,omitempty"`
+	// K0sAPIPort is the port of the k0s API.
+	//+kubebuilder:validation:Optional
+	K0sAPIPort int `json:"k0sApiPort,omitempty"`
+	// ExtraArgs defines the additional arguments of the API server.
+	//+kubebuilder:validation:Optional
+	ExtraArgs map[string]string `json:"extraArgs,omitempty"`
+}
+
+// K0sNetworkSpec defines the k0s network configuration.
+type K0sNetworkSpec struct {
+	// Provider is the network provider, kuberouter, calico or custom.
+	//+kubebuilder:validation:Optional
+	//+kubebuilder:validation:Enum=kuberouter;calico;custom
+	Provider string `json:"provider,omitempty"`
+	// PodCIDR is the CIDR of the pod network.
+	//+kubebuilder:validation:Optional
+	PodCIDR string `json:"podCIDR,omitempty"`
+	// ServiceCIDR is the CIDR of the service network.
+	//+kubebuilder:validation:Optional
+	ServiceCIDR string `json:"serviceCIDR,omitempty"`
+	// ClusterDomain is the DNS domain of the cluster.
+	//+kubebuilder:validation:Optional
+	ClusterDomain string `json:"clusterDomain,omitempty"`
+}
+
+// K0sTelemetrySpec defines the k0s telemetry configuration.
+type K0sTelemetrySpec struct {
+	// Enabled enables the k0s telemetry.
+	//+kubebuilder:validation:Optional
+	Enabled *bool `json:"enabled,omitempty"`
+}
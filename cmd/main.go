@@ -17,10 +17,13 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -29,23 +32,38 @@ import (
 
 	bootstrapv1beta1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
 	controlplanev1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	controlplanev1beta2 "github.com/k0sproject/k0smotron/api/controlplane/v1beta2"
 	infrastructurev1beta1 "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
 	k0smotronv1beta1 "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	k0smotronv1beta2 "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta2"
 	"github.com/k0sproject/k0smotron/internal/controller/bootstrap"
 	"github.com/k0sproject/k0smotron/internal/controller/controlplane"
 	"github.com/k0sproject/k0smotron/internal/controller/infrastructure"
 	controller "github.com/k0sproject/k0smotron/internal/controller/k0smotron.io"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/exec"
+	"github.com/k0sproject/k0smotron/internal/metrics"
+	"github.com/k0sproject/k0smotron/internal/notify"
+	"github.com/k0sproject/k0smotron/internal/profiling"
+	"github.com/k0sproject/k0smotron/internal/secretstore"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
+	"github.com/k0sproject/k0smotron/internal/webhooks"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	addonsv1 "sigs.k8s.io/cluster-api/exp/addons/api/v1beta1"
+	expv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -70,12 +88,16 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(k0smotronv1beta1.AddToScheme(scheme))
+	utilruntime.Must(k0smotronv1beta2.AddToScheme(scheme))
 	utilruntime.Must(bootstrapv1beta1.AddToScheme(scheme))
 
 	// Register cluster-api types
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(clusterv1.AddToScheme(scheme))
+	utilruntime.Must(expv1.AddToScheme(scheme))
+	utilruntime.Must(addonsv1.AddToScheme(scheme))
 	utilruntime.Must(controlplanev1beta1.AddToScheme(scheme))
+	utilruntime.Must(controlplanev1beta2.AddToScheme(scheme))
 	utilruntime.Must(infrastructurev1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
@@ -85,12 +107,77 @@ func main() {
 	var enableLeaderElection bool
 	var probeAddr string
 	var enabledController string
+	var secretStore string
+	var enableWebhooks bool
+	var remoteMachineMaxConcurrentProvisions int
+	var clusterConcurrency int
+	var joinTokenRequestConcurrency int
+	var k0sControlPlaneConcurrency int
+	var podExecLimits exec.Limits
+	var podExecClusterQPS float64
+	var otlpEndpoint string
+	var auditLogFile string
+	var notificationWebhookURL string
+	var notificationWebhookFormat string
+	var notificationEvents string
+	var shardName string
+	var shardCount int
+	var shardIndex int
+	var watchNamespaces string
+	var enablePprof bool
+	var pprofAddr string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&enabledController, "enable-controller", "", "The controller to enable. Default: all")
+	flag.StringVar(&secretStore, "secret-store", secretstore.ProviderKubernetes,
+		"The default store for the generated credentials: Kubernetes, Vault or AWSSecretsManager. "+
+			"Vault is configured by VAULT_ADDR and VAULT_TOKEN, AWS Secrets Manager by the AWS_REGION and AWS credentials environment variables.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Serve the conversion webhooks of the k0smotron.io and controlplane APIs and the defaulting and validating webhooks of the enabled controllers. "+
+			"Requires the webhook serving certificate in /tmp/k8s-webhook-server/serving-certs.")
+	flag.IntVar(&remoteMachineMaxConcurrentProvisions, "remote-machine-max-concurrent-provisions", 10,
+		"The maximum number of RemoteMachines provisioned at the same time.")
+	flag.IntVar(&clusterConcurrency, "cluster-concurrency", 1,
+		"The number of k0smotron Clusters reconciled at the same time.")
+	flag.IntVar(&joinTokenRequestConcurrency, "jointokenrequest-concurrency", 1,
+		"The number of JoinTokenRequests reconciled at the same time.")
+	flag.IntVar(&k0sControlPlaneConcurrency, "k0scontrolplane-concurrency", 1,
+		"The number of K0sControlPlanes reconciled at the same time.")
+	flag.IntVar(&podExecLimits.MaxConcurrent, "pod-exec-max-concurrent", 20,
+		"The maximum number of commands run in the control plane pods at the same time. Unlimited if 0.")
+	flag.Float64Var(&podExecClusterQPS, "pod-exec-cluster-qps", 5,
+		"The number of commands run per second in the pods of a control plane. Unlimited if 0.")
+	flag.IntVar(&podExecLimits.ClusterBurst, "pod-exec-cluster-burst", 10,
+		"The number of commands run at once in the pods of a control plane before --pod-exec-cluster-qps applies.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "",
+		"The host:port of the OTLP gRPC endpoint the traces of the reconciles are exported to. Tracing is disabled if empty. "+
+			"The exporter is configured further by the OTEL_EXPORTER_OTLP_* environment variables, e.g. OTEL_EXPORTER_OTLP_INSECURE.")
+	flag.StringVar(&auditLogFile, "audit-log-file", "",
+		"The file the commands run by the operator in the control plane pods and on the RemoteMachines are appended to as JSON lines, in addition to the Events. "+
+			"Use - for the standard output. Disabled if empty.")
+	flag.StringVar(&notificationWebhookURL, "notification-webhook-url", "",
+		"The URL of the webhook the lifecycle events of all the clusters are posted to, in addition to the webhooks of the clusters. "+
+			"The URL can be read from an environment variable with $(VAR) in the container args. Disabled if empty.")
+	flag.StringVar(&notificationWebhookFormat, "notification-webhook-format", notify.FormatGeneric,
+		"The format of the payload posted to --notification-webhook-url: generic or slack.")
+	flag.StringVar(&notificationEvents, "notification-events", "",
+		"The comma-separated types of the events posted to --notification-webhook-url, e.g. ClusterReady,BackupFailed. All the events are posted if empty.")
+	flag.StringVar(&shardName, "shard", "",
+		"Reconcile only the resources in the namespaces with the "+sharding.ShardLabel+" label set to this value. "+
+			"The namespaces without the label belong to the "+sharding.DefaultShard+" shard. Disabled if empty.")
+	flag.IntVar(&shardCount, "shard-count", 1,
+		"The number of shards the namespaces are split into by the hash of their name. Each shard is reconciled by the manager started with its --shard-index.")
+	flag.IntVar(&shardIndex, "shard-index", 0,
+		"The index of the shard reconciled by this manager, from 0 to --shard-count - 1.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"The comma-separated namespaces whose resources are reconciled. All the namespaces are watched if empty.")
+	flag.BoolVar(&enablePprof, "enable-pprof", false,
+		"Serve the pprof profiles, the Go runtime metrics and the runtime tuning endpoint on --pprof-bind-address.")
+	flag.StringVar(&pprofAddr, "pprof-bind-address", "localhost:6060",
+		"The loopback address the pprof endpoints bind to when enabled.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -105,14 +192,65 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	podExecLimits.ClusterQPS = float32(podExecClusterQPS)
+	exec.SetLimits(podExecLimits)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), otlpEndpoint)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
+	switch auditLogFile {
+	case "":
+	case "-":
+		kcutil.SetAuditSink(os.Stdout)
+	default:
+		f, err := os.OpenFile(auditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			setupLog.Error(err, "unable to open the audit log file")
+			os.Exit(1)
+		}
+		kcutil.SetAuditSink(f)
+	}
+
+	var notificationWebhooks []notify.Webhook
+	if notificationWebhookURL != "" {
+		events, err := notify.ParseEventTypes(notificationEvents)
+		if err != nil {
+			setupLog.Error(err, "invalid --notification-events")
+			os.Exit(1)
+		}
+		if notificationWebhookFormat != notify.FormatGeneric && notificationWebhookFormat != notify.FormatSlack {
+			setupLog.Error(fmt.Errorf("unsupported format %q", notificationWebhookFormat), "invalid --notification-webhook-format")
+			os.Exit(1)
+		}
+		notificationWebhooks = append(notificationWebhooks, notify.Webhook{
+			URL:    notificationWebhookURL,
+			Format: notificationWebhookFormat,
+			Events: events,
+		})
+	}
+
+	var shard *sharding.Shard
+	if shardName != "" || shardCount > 1 {
+		shard = &sharding.Shard{Name: shardName, Count: shardCount, Index: shardIndex}
+	}
+	if err := shard.Validate(); err != nil {
+		setupLog.Error(err, "invalid sharding flags")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
 		Metrics: metricsserver.Options{
 			BindAddress: metricsAddr,
 		},
 		HealthProbeBindAddress: probeAddr,
+		Cache:                  cacheOptions(watchNamespaces),
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       fmt.Sprintf("%x.k0smotron.io", md5.Sum([]byte(enabledController))),
+		// Every controller group and shard has its own leader
+		LeaderElectionID: fmt.Sprintf("%x.k0smotron.io", md5.Sum([]byte(enabledController+shard.ID()))),
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -141,33 +279,64 @@ func main() {
 		os.Exit(1)
 	}
 
+	secretStores, err := secretstore.NewRegistryFromEnv(secretStore)
+	if err != nil {
+		setupLog.Error(err, "unable to configure secret stores")
+		os.Exit(1)
+	}
+
 	if err = (&controller.ClusterReconciler{
-		Client:     mgr.GetClient(),
-		Scheme:     mgr.GetScheme(),
-		ClientSet:  clientSet,
-		RESTConfig: restConfig,
+		Client:       mgr.GetClient(),
+		Scheme:       mgr.GetScheme(),
+		ClientSet:    clientSet,
+		RESTConfig:   restConfig,
+		SecretStores: secretStores,
+		Recorder:     mgr.GetEventRecorderFor("k0smotron-cluster-controller"),
+		Notifier:     notify.New(notificationWebhooks...),
+		Shard:        shard,
+
+		MaxConcurrentReconciles: clusterConcurrency,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "K0smotronCluster")
 		os.Exit(1)
 	}
 
 	if err = (&controller.JoinTokenRequestReconciler{
-		Client:     mgr.GetClient(),
-		Scheme:     mgr.GetScheme(),
-		ClientSet:  clientSet,
-		RESTConfig: restConfig,
+		Client:       mgr.GetClient(),
+		Scheme:       mgr.GetScheme(),
+		ClientSet:    clientSet,
+		RESTConfig:   restConfig,
+		SecretStores: secretStores,
+		Recorder:     mgr.GetEventRecorderFor("jointokenrequest-controller"),
+		Shard:        shard,
+
+		MaxConcurrentReconciles: joinTokenRequestConcurrency,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "JoinTokenRequest")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
 
+	if enableWebhooks {
+		if err = (&webhooks.Cluster{
+			Client: mgr.GetClient(),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "K0smotronCluster")
+			os.Exit(1)
+		}
+		if err = ctrl.NewWebhookManagedBy(mgr).For(&controlplanev1beta1.K0smotronControlPlane{}).Complete(); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "K0smotronControlPlane")
+			os.Exit(1)
+		}
+	}
+
 	if isControllerEnabled(bootstrapController) {
 		if err = (&bootstrap.Controller{
 			Client:     mgr.GetClient(),
 			Scheme:     mgr.GetScheme(),
 			ClientSet:  clientSet,
 			RESTConfig: restConfig,
+			Shard:      shard,
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "Bootstrap")
 			os.Exit(1)
@@ -177,10 +346,19 @@ func main() {
 			Scheme:     mgr.GetScheme(),
 			ClientSet:  clientSet,
 			RESTConfig: restConfig,
+			Shard:      shard,
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "Bootstrap")
 			os.Exit(1)
 		}
+		if enableWebhooks {
+			if err = (&webhooks.K0sWorkerConfig{
+				Client: mgr.GetClient(),
+			}).SetupWebhookWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create webhook", "webhook", "K0sWorkerConfig")
+				os.Exit(1)
+			}
+		}
 	}
 
 	if isControllerEnabled(controlPlaneController) {
@@ -189,6 +367,8 @@ func main() {
 			Scheme:     mgr.GetScheme(),
 			ClientSet:  clientSet,
 			RESTConfig: restConfig,
+			Recorder:   mgr.GetEventRecorderFor("k0smotron-controlplane-controller"),
+			Shard:      shard,
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "K0smotronControlPlane")
 			os.Exit(1)
@@ -199,18 +379,44 @@ func main() {
 			Scheme:     mgr.GetScheme(),
 			ClientSet:  clientSet,
 			RESTConfig: restConfig,
+			Recorder:   mgr.GetEventRecorderFor("k0s-controlplane-controller"),
+			Shard:      shard,
+
+			MaxConcurrentReconciles: k0sControlPlaneConcurrency,
+			KubeClients:             kcutil.NewKubeClientCache(),
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "K0sController")
 			os.Exit(1)
 		}
+		if err = (&controller.ClusterFleetStatusReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+			Shard:  shard,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ClusterFleetStatus")
+			os.Exit(1)
+		}
+		if enableWebhooks {
+			if err = (&webhooks.K0sControlPlane{
+				Client: mgr.GetClient(),
+			}).SetupWebhookWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create webhook", "webhook", "K0sControlPlane")
+				os.Exit(1)
+			}
+		}
 	}
 
 	if isControllerEnabled(infrastructureController) {
 		if err = (&infrastructure.RemoteMachineController{
-			Client:     mgr.GetClient(),
-			Scheme:     mgr.GetScheme(),
-			ClientSet:  clientSet,
-			RESTConfig: restConfig,
+			Client:       mgr.GetClient(),
+			Scheme:       mgr.GetScheme(),
+			ClientSet:    clientSet,
+			RESTConfig:   restConfig,
+			SecretStores: secretStores,
+			Recorder:     mgr.GetEventRecorderFor("remotemachine-controller"),
+			Shard:        shard,
+
+			MaxConcurrentProvisions: remoteMachineMaxConcurrentProvisions,
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "RemoteMachine")
 			os.Exit(1)
@@ -219,10 +425,36 @@ func main() {
 		if err = (&infrastructure.ClusterController{
 			Client: mgr.GetClient(),
 			Scheme: mgr.GetScheme(),
+			Shard:  shard,
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "RemoteCluster")
 			os.Exit(1)
 		}
+		if err = (&infrastructure.RemoteMachineInventoryController{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+			Shard:  shard,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "RemoteMachineInventory")
+			os.Exit(1)
+		}
+	}
+
+	if err := ctrlmetrics.Registry.Register(metrics.NewStateCollector(mgr.GetClient())); err != nil {
+		setupLog.Error(err, "unable to register the state metrics")
+		os.Exit(1)
+	}
+
+	if enablePprof {
+		server, err := profiling.NewServer(pprofAddr)
+		if err != nil {
+			setupLog.Error(err, "unable to set up the pprof endpoints")
+			os.Exit(1)
+		}
+		if err := mgr.Add(server); err != nil {
+			setupLog.Error(err, "unable to set up the pprof endpoints")
+			os.Exit(1)
+		}
 	}
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -239,6 +471,12 @@ func main() {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		setupLog.Error(err, "unable to flush the traces")
+	}
 }
 
 // loadRestConfig loads the rest config from the KUBECONFIG env var or from the in-cluster config
@@ -252,3 +490,19 @@ func loadRestConfig() (*rest.Config, error) {
 func isControllerEnabled(controllerName string) bool {
 	return enabledControllers[controllerName]
 }
+
+// cacheOptions returns the options of the manager cache watching only the comma-separated namespaces, or all the
+// namespaces if empty.
+func cacheOptions(watchNamespaces string) cache.Options {
+	var opts cache.Options
+	for _, ns := range strings.Split(watchNamespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns == "" {
+			continue
+		}
+		if opts.DefaultNamespaces == nil {
+			opts.DefaultNamespaces = map[string]cache.Config{}
+		}
+		opts.DefaultNamespaces[ns] = cache.Config{}
+	}
+	return opts
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+func TestCacheOptions(t *testing.T) {
+	tests := []struct {
+		name            string
+		watchNamespaces string
+		want            map[string]cache.Config
+	}{
+		{
+			name: "all namespaces",
+		},
+		{
+			name:            "single namespace",
+			watchNamespaces: "tenant-a",
+			want:            map[string]cache.Config{"tenant-a": {}},
+		},
+		{
+			name:            "multiple namespaces",
+			watchNamespaces: "tenant-a,tenant-b",
+			want:            map[string]cache.Config{"tenant-a": {}, "tenant-b": {}},
+		},
+		{
+			name:            "whitespace and empty entries",
+			watchNamespaces: " tenant-a , ,tenant-b,",
+			want:            map[string]cache.Config{"tenant-a": {}, "tenant-b": {}},
+		},
+		{
+			name:            "only whitespace",
+			watchNamespaces: " , ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, cacheOptions(tt.watchNamespaces).DefaultNamespaces)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// veleroCompletedPhase is the phase of the successful Velero Backups and Restores. The other final phases are
+// failures.
+const veleroCompletedPhase = "Completed"
+
+var veleroFinalPhases = map[string]bool{
+	veleroCompletedPhase: true,
+	"PartiallyFailed":    true,
+	"Failed":             true,
+	"FailedValidation":   true,
+}
+
+// createBackup creates the Velero Backup generated for the cluster in its kmc-<cluster>-velero-backup ConfigMap.
+func createBackup(fs *flag.FlagSet) func(ctx context.Context, cmd *command, args []string) error {
+	waitFlag := fs.Bool("wait", false, "Wait for the backup to finish")
+	timeout := fs.Duration("timeout", 30*time.Minute, "How long to wait for the backup to finish")
+
+	return func(ctx context.Context, cmd *command, args []string) error {
+		if err := exactArgs(args, "<cluster>"); err != nil {
+			return err
+		}
+
+		kmc := &km.Cluster{ObjectMeta: metav1.ObjectMeta{Namespace: cmd.namespace, Name: args[0]}}
+		var cm v1.ConfigMap
+		key := client.ObjectKey{Namespace: cmd.namespace, Name: kmc.GetVeleroBackupConfigMapName()}
+		if err := cmd.client.Get(ctx, key, &cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				return fmt.Errorf("backup ConfigMap %s not found, set spec.backup.velero of the cluster to back it up", key)
+			}
+			return err
+		}
+
+		backup := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(cm.Data["backup.yaml"]), &backup.Object); err != nil {
+			return fmt.Errorf("failed to parse the backup of the ConfigMap %s: %w", key, err)
+		}
+		if err := cmd.client.Create(ctx, backup); err != nil {
+			return fmt.Errorf("failed to create the Velero Backup: %w", err)
+		}
+		fmt.Fprintf(cmd.out, "Backup %s/%s created\n", backup.GetNamespace(), backup.GetName())
+
+		if !*waitFlag {
+			return nil
+		}
+		return waitForVelero(ctx, cmd, backup, *timeout)
+	}
+}
+
+// createRestore creates a Velero Restore of the backup.
+func createRestore(fs *flag.FlagSet) func(ctx context.Context, cmd *command, args []string) error {
+	veleroNamespace := fs.String("velero-namespace", "velero", "Namespace of the Velero installation")
+	waitFlag := fs.Bool("wait", false, "Wait for the restore to finish")
+	timeout := fs.Duration("timeout", 30*time.Minute, "How long to wait for the restore to finish")
+
+	return func(ctx context.Context, cmd *command, args []string) error {
+		if err := exactArgs(args, "<backup>"); err != nil {
+			return err
+		}
+
+		backup := &unstructured.Unstructured{}
+		backup.SetAPIVersion("velero.io/v1")
+		backup.SetKind("Backup")
+		key := client.ObjectKey{Namespace: *veleroNamespace, Name: args[0]}
+		if err := cmd.client.Get(ctx, key, backup); err != nil {
+			return fmt.Errorf("failed to get the Velero Backup %s: %w", key, err)
+		}
+		if phase, _, _ := unstructured.NestedString(backup.Object, "status", "phase"); phase != veleroCompletedPhase {
+			return fmt.Errorf("the Velero Backup %s is in phase %q, only completed backups can be restored", key, phase)
+		}
+
+		restore := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "velero.io/v1",
+			"kind":       "Restore",
+			"metadata": map[string]interface{}{
+				"generateName": args[0] + "-",
+				"namespace":    *veleroNamespace,
+			},
+			"spec": map[string]interface{}{
+				"backupName": args[0],
+			},
+		}}
+		if err := cmd.client.Create(ctx, restore); err != nil {
+			return fmt.Errorf("failed to create the Velero Restore: %w", err)
+		}
+		fmt.Fprintf(cmd.out, "Restore %s/%s created\n", restore.GetNamespace(), restore.GetName())
+
+		if !*waitFlag {
+			return nil
+		}
+		return waitForVelero(ctx, cmd, restore, *timeout)
+	}
+}
+
+// waitForVelero waits for the Velero Backup or Restore to reach a final phase and fails unless it is completed.
+func waitForVelero(ctx context.Context, cmd *command, obj *unstructured.Unstructured, timeout time.Duration) error {
+	var phase string
+	err := wait.PollUntilContextTimeout(ctx, cmd.pollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		if err := cmd.client.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			return false, err
+		}
+		phase, _, _ = unstructured.NestedString(obj.Object, "status", "phase")
+		return veleroFinalPhases[phase], nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for the %s %s/%s: %w", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+	}
+	if phase != veleroCompletedPhase {
+		return fmt.Errorf("the %s %s/%s finished in phase %s", obj.GetKind(), obj.GetNamespace(), obj.GetName(), phase)
+	}
+	fmt.Fprintf(cmd.out, "%s %s/%s completed\n", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cli implements the kubectl-k0smotron plugin, running the day-2 operations of the k0smotron clusters by
+// creating and reading the k0smotron resources in the management cluster.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+const usage = `kubectl-k0smotron runs the day-2 operations of the k0smotron clusters.
+
+Usage:
+  kubectl k0smotron <command> [flags]
+
+Commands:
+  kubeconfig <cluster>              Print the admin kubeconfig of a cluster
+  token create <cluster>            Create a join token and print it
+  token list [<cluster>]            List the join token requests
+  token invalidate <request>...     Invalidate join tokens by deleting their requests
+  backup <cluster>                  Create a Velero backup of the control plane of a cluster
+  restore <backup>                  Restore a Velero backup
+  status                            Show the status of the fleet of clusters
+  render <cluster> | -f <file>      Print the objects generated for a cluster without applying them
+
+Flags of all the commands:
+  -n, --namespace string    Namespace of the resources, defaults to the namespace of the kubeconfig context
+      --kubeconfig string   Path to the kubeconfig of the management cluster
+      --context string      Kubeconfig context to use
+`
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(km.AddToScheme(scheme))
+}
+
+// CLI runs the commands of the plugin.
+type CLI struct {
+	In  io.Reader
+	Out io.Writer
+	Err io.Writer
+	// NewClient creates the client to the management cluster and returns the namespace of the kubeconfig context.
+	// Defaults to NewClient.
+	NewClient func(kubeconfig, kubeContext string) (client.Client, string, error)
+	// PollInterval is how often the resources are checked while waiting for them. Defaults to one second.
+	PollInterval time.Duration
+}
+
+// command is a command bound to the management cluster.
+type command struct {
+	client       client.Client
+	namespace    string
+	in           io.Reader
+	out          io.Writer
+	pollInterval time.Duration
+}
+
+// Run runs the command of the arguments, without the name of the program.
+func (c *CLI) Run(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(c.Out, usage)
+		return nil
+	}
+
+	name, args := args[0], args[1:]
+	if name == "token" {
+		if len(args) == 0 {
+			return errors.New("token requires a subcommand: create, list or invalidate")
+		}
+		name, args = "token "+args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("kubectl k0smotron "+name, flag.ContinueOnError)
+	fs.SetOutput(c.Err)
+	var namespace, kubeconfig, kubeContext string
+	fs.StringVar(&namespace, "namespace", "", "Namespace of the resources")
+	fs.StringVar(&namespace, "n", "", "Namespace of the resources (shorthand)")
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig of the management cluster")
+	fs.StringVar(&kubeContext, "context", "", "Kubeconfig context to use")
+
+	var run func(ctx context.Context, cmd *command, args []string) error
+	switch name {
+	case "kubeconfig":
+		run = getKubeconfig
+	case "token create":
+		run = createToken(fs)
+	case "token list":
+		run = listTokens
+	case "token invalidate":
+		run = invalidateTokens
+	case "backup":
+		run = createBackup(fs)
+	case "restore":
+		run = createRestore(fs)
+	case "status":
+		run = fleetStatus
+	case "render":
+		run = render(fs)
+	default:
+		return fmt.Errorf("unknown command %q, see kubectl k0smotron help", name)
+	}
+
+	positional, err := parseArgs(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	newClient := c.NewClient
+	if newClient == nil {
+		newClient = NewClient
+	}
+	cl, defaultNamespace, err := newClient(kubeconfig, kubeContext)
+	if err != nil {
+		return err
+	}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	pollInterval := c.PollInterval
+	if pollInterval == 0 {
+		pollInterval = time.Second
+	}
+
+	return run(ctx, &command{client: cl, namespace: namespace, in: c.In, out: c.Out, pollInterval: pollInterval}, positional)
+}
+
+// NewClient creates the client to the management cluster from the kubeconfig, loaded like kubectl does if the path
+// is empty, and returns the namespace of the context.
+func NewClient(kubeconfig, kubeContext string) (client.Client, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: kubeContext})
+
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load the kubeconfig: %w", err)
+	}
+	namespace, _, err := config.Namespace()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get the namespace of the kubeconfig context: %w", err)
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, "", err
+	}
+	return c, namespace, nil
+}
+
+// parseArgs parses the flags anywhere in the arguments, as kubectl does, and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func exactArgs(args []string, names ...string) error {
+	if len(names) == 0 && len(args) > 0 {
+		return fmt.Errorf("expected no arguments, got %d", len(args))
+	}
+	if len(args) != len(names) {
+		return fmt.Errorf("expected the arguments %s, got %d argument(s)", strings.Join(names, " "), len(args))
+	}
+	return nil
+}
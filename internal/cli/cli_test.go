@@ -0,0 +1,311 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	s := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(s))
+	require.NoError(t, km.AddToScheme(s))
+	for _, kind := range []string{"Backup", "Restore"} {
+		gv := schema.GroupVersion{Group: "velero.io", Version: "v1"}
+		s.AddKnownTypeWithName(gv.WithKind(kind), &unstructured.Unstructured{})
+		s.AddKnownTypeWithName(gv.WithKind(kind+"List"), &unstructured.UnstructuredList{})
+	}
+	return s
+}
+
+// run runs the command against the client, with default as the namespace of the kubeconfig context.
+func run(t *testing.T, c client.Client, args ...string) (string, error) {
+	var out bytes.Buffer
+	cli := &CLI{
+		Out:          &out,
+		Err:          &out,
+		NewClient:    func(string, string) (client.Client, string, error) { return c, "default", nil },
+		PollInterval: 10 * time.Millisecond,
+	}
+	err := cli.Run(context.Background(), args)
+	return out.String(), err
+}
+
+func fields(output string) [][]string {
+	var lines [][]string
+	for _, l := range strings.Split(strings.TrimSpace(output), "\n") {
+		lines = append(lines, strings.Fields(l))
+	}
+	return lines
+}
+
+func TestKubeconfig(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-kubeconfig", Namespace: "tenant"},
+		Data:       map[string][]byte{"value": []byte("apiVersion: v1\nkind: Config\n")},
+	}).Build()
+
+	out, err := run(t, c, "kubeconfig", "test", "-n", "tenant")
+	require.NoError(t, err)
+	assert.Equal(t, "apiVersion: v1\nkind: Config\n", out)
+
+	_, err = run(t, c, "kubeconfig", "test")
+	assert.ErrorContains(t, err, "kubeconfig secret default/test-kubeconfig not found")
+
+	_, err = run(t, c, "kubeconfig")
+	assert.ErrorContains(t, err, "expected the arguments <cluster>")
+}
+
+func TestTokenCreate(t *testing.T) {
+	// The interceptor issues the token like the JoinTokenRequest controller
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if err := c.Create(ctx, obj, opts...); err != nil {
+				return err
+			}
+			jtr, ok := obj.(*km.JoinTokenRequest)
+			if !ok {
+				return nil
+			}
+			jtr.Status = km.JoinTokenRequestStatus{ReconciliationStatus: tokenIssuedStatus, TokenID: "abc123"}
+			if err := c.Update(ctx, jtr); err != nil {
+				return err
+			}
+			return c.Create(ctx, &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: jtr.Name, Namespace: jtr.Namespace},
+				Data:       map[string][]byte{"token": []byte("H4sIAAAA")},
+			})
+		},
+	}).Build()
+
+	out, err := run(t, c, "token", "create", "test", "--role", "controller", "--expiry", "1h")
+	require.NoError(t, err)
+	assert.Equal(t, "H4sIAAAA\n", out)
+
+	var jtrs km.JoinTokenRequestList
+	require.NoError(t, c.List(context.Background(), &jtrs))
+	require.Len(t, jtrs.Items, 1)
+	jtr := jtrs.Items[0]
+	assert.True(t, strings.HasPrefix(jtr.Name, "test-controller-"))
+	assert.Equal(t, "default", jtr.Namespace)
+	assert.Equal(t, km.JoinTokenRequestSpec{ClusterRef: km.ClusterRef{Name: "test"}, Expiry: "1h", Role: "controller"}, jtr.Spec)
+
+	_, err = run(t, c, "token", "create", "test", "--role", "admin")
+	assert.ErrorContains(t, err, `invalid role "admin"`)
+}
+
+func TestTokenCreateTimeout(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).Build()
+
+	_, err := run(t, c, "token", "create", "test", "--name", "pending", "--timeout", "50ms")
+	assert.ErrorContains(t, err, "failed waiting for the token of the JoinTokenRequest default/pending")
+}
+
+func TestTokenListAndInvalidate(t *testing.T) {
+	expires := metav1.NewTime(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	jtr := func(name, cluster string, status km.JoinTokenRequestStatus) *km.JoinTokenRequest {
+		return &km.JoinTokenRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       km.JoinTokenRequestSpec{ClusterRef: km.ClusterRef{Name: cluster}, Role: "worker"},
+			Status:     status,
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(
+		jtr("expiring", "test", km.JoinTokenRequestStatus{ReconciliationStatus: tokenIssuedStatus, TokenID: "abc", ExpiresAt: &expires}),
+		jtr("permanent", "test", km.JoinTokenRequestStatus{ReconciliationStatus: tokenIssuedStatus, TokenID: "def"}),
+		jtr("other", "other", km.JoinTokenRequestStatus{}),
+	).Build()
+
+	out, err := run(t, c, "token", "list", "test")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"NAME", "CLUSTER", "ROLE", "TOKEN", "ID", "EXPIRES", "STATUS"},
+		{"expiring", "test", "worker", "abc", "2024-01-01T12:00:00Z", "Reconciliation", "successful"},
+		{"permanent", "test", "worker", "def", "never", "Reconciliation", "successful"},
+	}, fields(out))
+
+	out, err = run(t, c, "token", "invalidate", "expiring", "permanent")
+	require.NoError(t, err)
+	assert.Contains(t, out, "JoinTokenRequest permanent deleted")
+	err = c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "expiring"}, &km.JoinTokenRequest{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	_, err = run(t, c, "token", "invalidate", "missing")
+	assert.ErrorContains(t, err, "failed to delete the JoinTokenRequest missing")
+
+	_, err = run(t, c, "token", "revoke")
+	assert.ErrorContains(t, err, `unknown command "token revoke"`)
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kmc-test-velero-backup", Namespace: "default"},
+		Data: map[string]string{"backup.yaml": `apiVersion: velero.io/v1
+kind: Backup
+metadata:
+  generateName: default-test-
+  namespace: velero
+  labels:
+    app: k0smotron
+    cluster: test
+spec:
+  includedNamespaces:
+  - default
+`},
+	}).Build()
+	ctx := context.Background()
+
+	out, err := run(t, c, "backup", "test")
+	require.NoError(t, err)
+	backups := &unstructured.UnstructuredList{}
+	backups.SetAPIVersion("velero.io/v1")
+	backups.SetKind("BackupList")
+	require.NoError(t, c.List(ctx, backups, client.InNamespace("velero")))
+	require.Len(t, backups.Items, 1)
+	backup := backups.Items[0]
+	assert.Equal(t, "Backup velero/"+backup.GetName()+" created\n", out)
+	assert.Equal(t, map[string]string{"app": "k0smotron", "cluster": "test"}, backup.GetLabels())
+
+	_, err = run(t, c, "backup", "other")
+	assert.ErrorContains(t, err, "set spec.backup.velero of the cluster")
+
+	// Only the completed backups are restored
+	_, err = run(t, c, "restore", backup.GetName())
+	assert.ErrorContains(t, err, "only completed backups can be restored")
+
+	require.NoError(t, unstructured.SetNestedField(backup.Object, "Completed", "status", "phase"))
+	require.NoError(t, c.Update(ctx, &backup))
+	out, err = run(t, c, "restore", backup.GetName())
+	require.NoError(t, err)
+	restores := &unstructured.UnstructuredList{}
+	restores.SetAPIVersion("velero.io/v1")
+	restores.SetKind("RestoreList")
+	require.NoError(t, c.List(ctx, restores, client.InNamespace("velero")))
+	require.Len(t, restores.Items, 1)
+	restore := restores.Items[0]
+	assert.Equal(t, "Restore velero/"+restore.GetName()+" created\n", out)
+	backupName, _, _ := unstructured.NestedString(restore.Object, "spec", "backupName")
+	assert.Equal(t, backup.GetName(), backupName)
+}
+
+func TestWaitForVelero(t *testing.T) {
+	restore := &unstructured.Unstructured{}
+	restore.SetAPIVersion("velero.io/v1")
+	restore.SetKind("Restore")
+	restore.SetNamespace("velero")
+	restore.SetName("test")
+	require.NoError(t, unstructured.SetNestedField(restore.Object, "PartiallyFailed", "status", "phase"))
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(restore).Build()
+
+	var out bytes.Buffer
+	cmd := &command{client: c, out: &out, pollInterval: 10 * time.Millisecond}
+	err := waitForVelero(context.Background(), cmd, restore, time.Second)
+	assert.EqualError(t, err, "the Restore velero/test finished in phase PartiallyFailed")
+}
+
+func TestFleetStatus(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).Build()
+	_, err := run(t, c, "status")
+	assert.ErrorContains(t, err, "the fleet status is not computed yet")
+
+	c = fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(&km.ClusterFleetStatus{
+		ObjectMeta: metav1.ObjectMeta{Name: km.ClusterFleetStatusName},
+		Status: km.ClusterFleetStatusStatus{
+			HostedClusters: km.FleetSummary{Total: 3, NotReady: 1, PendingUpgrade: 1, ExpiringCertificates: 2, Versions: []km.VersionCount{
+				{Version: "v1.28.4-k0s.0", Count: 2},
+				{Version: "v1.27.9-k0s.0", Count: 1},
+			}},
+			ControlPlanes: km.FleetSummary{Total: 1, Versions: []km.VersionCount{{Version: "v1.28.4-k0s.0", Count: 1}}},
+		},
+	}).Build()
+	out, err := run(t, c, "status")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"CLUSTERS", "TOTAL", "NOT", "READY", "PENDING", "UPGRADE", "EXPIRING", "CERTIFICATES"},
+		{"Hosted", "3", "1", "1", "2"},
+		{"Control", "planes", "1", "0", "0", "0"},
+		{},
+		{"VERSION", "HOSTED", "CONTROL", "PLANES"},
+		{"v1.27.9-k0s.0", "1", "0"},
+		{"v1.28.4-k0s.0", "2", "1"},
+	}, fields(out))
+}
+
+func TestRender(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant"},
+		Spec: km.ClusterSpec{
+			Service:           km.ServiceSpec{Type: v1.ServiceTypeClusterIP, APIPort: 30443, KonnectivityPort: 30132},
+			KineDataSourceURL: "postgres://postgres:5432/kine",
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(kmc).Build()
+
+	out, err := run(t, c, "render", "test", "-n", "tenant")
+	require.NoError(t, err)
+	assert.Contains(t, out, "---\napiVersion: v1\nkind: Service\n")
+	assert.Contains(t, out, "kind: StatefulSet")
+	assert.Contains(t, out, "name: kmc-test\n")
+	assert.Contains(t, out, "dataSource: postgres://postgres:5432/kine")
+	assert.NotContains(t, out, "kmc-test-etcd")
+
+	file := filepath.Join(t.TempDir(), "cluster.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`apiVersion: k0smotron.io/v1beta1
+kind: Cluster
+metadata:
+  name: test
+spec:
+  replicas: 3
+  service:
+    type: ClusterIP
+    apiPort: 30443
+    konnectivityPort: 30132
+  kineDataSourceURL: postgres://postgres:5432/changed
+`), 0o600))
+	out, err = run(t, c, "render", "-f", file, "-n", "tenant")
+	require.NoError(t, err)
+	assert.Contains(t, out, "dataSource: postgres://postgres:5432/changed")
+	assert.Contains(t, out, "replicas: 3")
+
+	require.NoError(t, os.WriteFile(file, []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n"), 0o600))
+	_, err = run(t, c, "render", "-f", file)
+	assert.ErrorContains(t, err, "expected a k0smotron.io/v1beta1 Cluster, got v1 ConfigMap")
+
+	_, err = run(t, c, "render", "missing")
+	assert.True(t, apierrors.IsNotFound(err))
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getKubeconfig prints the admin kubeconfig of the cluster from its <cluster>-kubeconfig secret, written for both
+// the hosted clusters and the K0sControlPlanes.
+func getKubeconfig(ctx context.Context, cmd *command, args []string) error {
+	if err := exactArgs(args, "<cluster>"); err != nil {
+		return err
+	}
+
+	var s v1.Secret
+	key := client.ObjectKey{Namespace: cmd.namespace, Name: secret.Name(args[0], secret.Kubeconfig)}
+	if err := cmd.client.Get(ctx, key, &s); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("kubeconfig secret %s not found, the cluster may not be ready yet or may store its kubeconfig in an external secret store", key)
+		}
+		return err
+	}
+	kubeconfig, ok := s.Data[secret.KubeconfigDataName]
+	if !ok {
+		return fmt.Errorf("kubeconfig secret %s has no %s key", key, secret.KubeconfigDataName)
+	}
+
+	_, err := cmd.out.Write(kubeconfig)
+	return err
+}
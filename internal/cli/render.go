@@ -0,0 +1,120 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	controller "github.com/k0sproject/k0smotron/internal/controller/k0smotron.io"
+)
+
+// render prints the objects k0smotron generates for the cluster, from the cluster of the management cluster or a
+// cluster of a file, without applying them.
+func render(fs *flag.FlagSet) func(ctx context.Context, cmd *command, args []string) error {
+	var filename string
+	fs.StringVar(&filename, "filename", "", "File of the cluster to render, - for the standard input")
+	fs.StringVar(&filename, "f", "", "File of the cluster to render, - for the standard input (shorthand)")
+
+	return func(ctx context.Context, cmd *command, args []string) error {
+		var kmc *km.Cluster
+		if filename == "" {
+			if err := exactArgs(args, "<cluster>"); err != nil {
+				return err
+			}
+			kmc = &km.Cluster{}
+			if err := cmd.client.Get(ctx, client.ObjectKey{Namespace: cmd.namespace, Name: args[0]}, kmc); err != nil {
+				return err
+			}
+		} else {
+			if err := exactArgs(args); err != nil {
+				return err
+			}
+			var err error
+			if kmc, err = readCluster(ctx, cmd, filename); err != nil {
+				return err
+			}
+		}
+
+		r := &controller.ClusterReconciler{Client: cmd.client, Scheme: scheme}
+		objs, err := r.Render(ctx, kmc)
+		if err != nil {
+			return fmt.Errorf("failed to render the cluster %s/%s: %w", kmc.Namespace, kmc.Name, err)
+		}
+		for _, obj := range objs {
+			b, err := yaml.Marshal(obj)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.out, "---\n%s", b)
+		}
+		return nil
+	}
+}
+
+// readCluster reads the cluster of the file and applies it with a server-side dry run, so the defaults and the
+// validation of the API server and the webhooks apply as if the cluster was applied. The external address set by
+// k0smotron on the existing cluster is kept if the file doesn't set one.
+func readCluster(ctx context.Context, cmd *command, filename string) (*km.Cluster, error) {
+	var b []byte
+	var err error
+	if filename == "-" {
+		b, err = io.ReadAll(cmd.in)
+	} else {
+		b, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cluster: %w", err)
+	}
+
+	kmc := &km.Cluster{}
+	if err := yaml.Unmarshal(b, kmc); err != nil {
+		return nil, fmt.Errorf("failed to parse the cluster: %w", err)
+	}
+	if kmc.APIVersion != km.GroupVersion.String() || kmc.Kind != "Cluster" {
+		return nil, fmt.Errorf("expected a %s Cluster, got %s %s", km.GroupVersion, kmc.APIVersion, kmc.Kind)
+	}
+	if kmc.Namespace == "" {
+		kmc.Namespace = cmd.namespace
+	}
+
+	var existing km.Cluster
+	err = cmd.client.Get(ctx, client.ObjectKeyFromObject(kmc), &existing)
+	switch {
+	case apierrors.IsNotFound(err):
+		err = cmd.client.Create(ctx, kmc, client.DryRunAll)
+	case err == nil:
+		kmc.UID = existing.UID
+		kmc.ResourceVersion = existing.ResourceVersion
+		if kmc.Spec.ExternalAddress == "" && kmc.Spec.Service.Type == existing.Spec.Service.Type {
+			kmc.Spec.ExternalAddress = existing.Spec.ExternalAddress
+		}
+		err = cmd.client.Update(ctx, kmc, client.DryRunAll)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate the cluster %s/%s: %w", kmc.Namespace, kmc.Name, err)
+	}
+	return kmc, nil
+}
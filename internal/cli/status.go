@@ -0,0 +1,82 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// fleetStatus prints the summary of the clusters from the ClusterFleetStatus maintained by the manager.
+func fleetStatus(ctx context.Context, cmd *command, args []string) error {
+	if err := exactArgs(args); err != nil {
+		return err
+	}
+
+	var fleet km.ClusterFleetStatus
+	if err := cmd.client.Get(ctx, client.ObjectKey{Name: km.ClusterFleetStatusName}, &fleet); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("ClusterFleetStatus %s not found, the fleet status is not computed yet", km.ClusterFleetStatusName)
+		}
+		return err
+	}
+	status := fleet.Status
+
+	w := tabwriter.NewWriter(cmd.out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CLUSTERS\tTOTAL\tNOT READY\tPENDING UPGRADE\tEXPIRING CERTIFICATES")
+	for _, s := range []struct {
+		name    string
+		summary km.FleetSummary
+	}{{"Hosted", status.HostedClusters}, {"Control planes", status.ControlPlanes}} {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", s.name, s.summary.Total, s.summary.NotReady, s.summary.PendingUpgrade, s.summary.ExpiringCertificates)
+	}
+
+	versions := map[string][2]int32{}
+	for _, v := range status.HostedClusters.Versions {
+		c := versions[v.Version]
+		c[0] = v.Count
+		versions[v.Version] = c
+	}
+	for _, v := range status.ControlPlanes.Versions {
+		c := versions[v.Version]
+		c[1] = v.Count
+		versions[v.Version] = c
+	}
+	if len(versions) > 0 {
+		names := make([]string, 0, len(versions))
+		for v := range versions {
+			names = append(names, v)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "\nVERSION\tHOSTED\tCONTROL PLANES")
+		for _, v := range names {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", v, versions[v][0], versions[v][1])
+		}
+	}
+	if !status.LastUpdateTime.IsZero() {
+		fmt.Fprintf(w, "\nLast updated %s ago\n", time.Since(status.LastUpdateTime.Time).Round(time.Second))
+	}
+	return w.Flush()
+}
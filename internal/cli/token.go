@@ -0,0 +1,143 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// tokenIssuedStatus is the reconciliation status of the JoinTokenRequests whose token is written.
+const tokenIssuedStatus = "Reconciliation successful"
+
+// createToken creates a JoinTokenRequest for the cluster, waits for the token to be issued and prints it.
+func createToken(fs *flag.FlagSet) func(ctx context.Context, cmd *command, args []string) error {
+	role := fs.String("role", "worker", "Role of the node joining with the token: worker or controller")
+	expiry := fs.String("expiry", "", "Expiration time of the token, e.g. 1h30m. The token doesn't expire if not set")
+	name := fs.String("name", "", "Name of the JoinTokenRequest. Generated from the cluster name if not set")
+	timeout := fs.Duration("timeout", 2*time.Minute, "How long to wait for the token to be issued")
+
+	return func(ctx context.Context, cmd *command, args []string) error {
+		if err := exactArgs(args, "<cluster>"); err != nil {
+			return err
+		}
+		if *role != "worker" && *role != "controller" {
+			return fmt.Errorf("invalid role %q, expected worker or controller", *role)
+		}
+		if *expiry != "" {
+			if _, err := time.ParseDuration(*expiry); err != nil {
+				return fmt.Errorf("invalid expiry: %w", err)
+			}
+		}
+
+		jtr := &km.JoinTokenRequest{
+			ObjectMeta: metav1.ObjectMeta{Namespace: cmd.namespace, Name: *name},
+			Spec: km.JoinTokenRequestSpec{
+				ClusterRef: km.ClusterRef{Name: args[0]},
+				Expiry:     *expiry,
+				Role:       *role,
+			},
+		}
+		if jtr.Name == "" {
+			jtr.GenerateName = fmt.Sprintf("%s-%s-", args[0], *role)
+		}
+		if err := cmd.client.Create(ctx, jtr); err != nil {
+			return fmt.Errorf("failed to create the JoinTokenRequest: %w", err)
+		}
+
+		key := client.ObjectKeyFromObject(jtr)
+		err := wait.PollUntilContextTimeout(ctx, cmd.pollInterval, *timeout, true, func(ctx context.Context) (bool, error) {
+			if err := cmd.client.Get(ctx, key, jtr); err != nil {
+				return false, err
+			}
+			return jtr.Status.TokenID != "" && jtr.Status.ReconciliationStatus == tokenIssuedStatus, nil
+		})
+		if err != nil {
+			if jtr.Status.ReconciliationStatus != "" {
+				return fmt.Errorf("the token of the JoinTokenRequest %s is not issued: %s", key, jtr.Status.ReconciliationStatus)
+			}
+			return fmt.Errorf("failed waiting for the token of the JoinTokenRequest %s: %w", key, err)
+		}
+
+		var s v1.Secret
+		if err := cmd.client.Get(ctx, key, &s); err != nil {
+			if apierrors.IsNotFound(err) {
+				return fmt.Errorf("token secret %s not found, the token may be written to an external secret store", key)
+			}
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.out, string(s.Data["token"]))
+		return err
+	}
+}
+
+// listTokens lists the JoinTokenRequests of the namespace, optionally only the ones of a cluster.
+func listTokens(ctx context.Context, cmd *command, args []string) error {
+	if len(args) > 1 {
+		return errors.New("expected at most the argument <cluster>")
+	}
+
+	var jtrs km.JoinTokenRequestList
+	if err := cmd.client.List(ctx, &jtrs, client.InNamespace(cmd.namespace)); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCLUSTER\tROLE\tTOKEN ID\tEXPIRES\tSTATUS")
+	for _, jtr := range jtrs.Items {
+		if len(args) == 1 && jtr.Spec.ClusterRef.Name != args[0] {
+			continue
+		}
+		expires := ""
+		if jtr.Status.ExpiresAt != nil {
+			expires = jtr.Status.ExpiresAt.UTC().Format(time.RFC3339)
+		} else if jtr.Status.TokenID != "" {
+			expires = "never"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", jtr.Name, jtr.Spec.ClusterRef.Name, jtr.Spec.Role, jtr.Status.TokenID, expires, jtr.Status.ReconciliationStatus)
+	}
+	return w.Flush()
+}
+
+// invalidateTokens deletes the JoinTokenRequests, k0smotron invalidates their tokens in the clusters before the
+// requests are removed.
+func invalidateTokens(ctx context.Context, cmd *command, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected the names of the JoinTokenRequests")
+	}
+
+	for _, name := range args {
+		jtr := &km.JoinTokenRequest{ObjectMeta: metav1.ObjectMeta{Namespace: cmd.namespace, Name: name}}
+		if err := cmd.client.Delete(ctx, jtr); err != nil {
+			return fmt.Errorf("failed to delete the JoinTokenRequest %s: %w", name, err)
+		}
+		fmt.Fprintf(cmd.out, "JoinTokenRequest %s deleted, its token is invalidated\n", name)
+	}
+	return nil
+}
@@ -17,9 +17,18 @@ limitations under the License.
 package cloudinit
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCloudInit(t *testing.T) {
@@ -63,3 +72,94 @@ func TestPermissions(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, int64(420), perm)
 }
+
+func TestIgnition(t *testing.T) {
+	c := &CloudInit{
+		Files: []File{
+			{
+				Path:        "/etc/hosts",
+				Content:     "foobar",
+				Permissions: "0600",
+			},
+			{
+				Path:    "/etc/k0s.token",
+				Content: "token",
+			},
+		},
+		RunCmds: []string{
+			"echo 'hello world'",
+		},
+	}
+
+	b, err := c.AsIgnition()
+	require.NoError(t, err)
+
+	var cfg ignitionConfig
+	require.NoError(t, json.Unmarshal(b, &cfg))
+	assert.Equal(t, "3.3.0", cfg.Ignition.Version)
+	require.Len(t, cfg.Storage.Files, 3)
+
+	assert.Equal(t, "/etc/hosts", cfg.Storage.Files[0].Path)
+	assert.Equal(t, int64(0600), cfg.Storage.Files[0].Mode)
+	assert.Equal(t, "data:;base64,Zm9vYmFy", cfg.Storage.Files[0].Contents.Source)
+	assert.Equal(t, int64(0644), cfg.Storage.Files[1].Mode)
+
+	script := cfg.Storage.Files[2]
+	assert.Equal(t, "/etc/k0smotron/bootstrap.sh", script.Path)
+	assert.Equal(t, int64(0755), script.Mode)
+	content, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(script.Contents.Source, "data:;base64,"))
+	require.NoError(t, err)
+	assert.Equal(t, `#!/bin/sh
+set -e
+echo 'hello world'
+mkdir -p /var/lib/k0smotron && touch /var/lib/k0smotron/bootstrap.complete
+`, string(content))
+
+	require.Len(t, cfg.Systemd.Units, 1)
+	assert.Equal(t, "k0smotron-bootstrap.service", cfg.Systemd.Units[0].Name)
+	assert.True(t, cfg.Systemd.Units[0].Enabled)
+	assert.Contains(t, cfg.Systemd.Units[0].Contents, "ExecStart=/etc/k0smotron/bootstrap.sh")
+}
+
+func TestIgnitionInvalidPermissions(t *testing.T) {
+	c := &CloudInit{
+		Files: []File{{Path: "/etc/hosts", Permissions: "rw"}},
+	}
+
+	_, err := c.AsIgnition()
+	assert.Error(t, err)
+}
+
+func TestMultipart(t *testing.T) {
+	c := &CloudInit{
+		RunCmds: []string{"k0s start"},
+	}
+
+	b, err := c.AsMultipartBytes([]byte("#!/bin/sh\necho hello\n"))
+	require.NoError(t, err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	var types, contents []string
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, "list(append)+dict(no_replace,recurse_list)+str()", p.Header.Get("Merge-Type"))
+		content, err := io.ReadAll(p)
+		require.NoError(t, err)
+		types = append(types, p.Header.Get("Content-Type"))
+		contents = append(contents, string(content))
+	}
+
+	assert.Equal(t, []string{`text/x-shellscript; charset="utf-8"`, `text/cloud-config; charset="utf-8"`}, types)
+	assert.Equal(t, "#!/bin/sh\necho hello\n", contents[0])
+	assert.Equal(t, "#cloud-config\nruncmd:\n  - k0s start\n", contents[1])
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	ignitionVersion = "3.3.0"
+
+	// ignitionBootstrapScript is the script running the commands, as Ignition has no equivalent to runcmd.
+	ignitionBootstrapScript = "/etc/k0smotron/bootstrap.sh"
+	// ignitionBootstrapSentinel prevents the bootstrap commands to be run again on reboot.
+	ignitionBootstrapSentinel = "/var/lib/k0smotron/bootstrap.complete"
+	ignitionBootstrapUnit     = "k0smotron-bootstrap.service"
+)
+
+// Very basic type definitions to generate Ignition v3 json
+
+type ignitionConfig struct {
+	Ignition ignitionMeta    `json:"ignition"`
+	Storage  ignitionStorage `json:"storage,omitempty"`
+	Systemd  ignitionSystemd `json:"systemd,omitempty"`
+}
+
+type ignitionMeta struct {
+	Version string `json:"version"`
+}
+
+type ignitionStorage struct {
+	Files []ignitionFile `json:"files,omitempty"`
+}
+
+type ignitionFile struct {
+	Path      string           `json:"path"`
+	Mode      int64            `json:"mode"`
+	Overwrite bool             `json:"overwrite"`
+	Contents  ignitionContents `json:"contents"`
+}
+
+type ignitionContents struct {
+	Source string `json:"source"`
+}
+
+type ignitionSystemd struct {
+	Units []ignitionUnit `json:"units,omitempty"`
+}
+
+type ignitionUnit struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Contents string `json:"contents"`
+}
+
+// AsIgnition renders the cloud-init data as an Ignition config. The files are written by Ignition and the
+// commands are run once by a systemd unit on the first boot.
+func (c *CloudInit) AsIgnition() ([]byte, error) {
+	cfg := ignitionConfig{
+		Ignition: ignitionMeta{Version: ignitionVersion},
+	}
+
+	for _, f := range c.Files {
+		mode := int64(0644)
+		if f.Permissions != "" {
+			var err error
+			mode, err = f.PermissionsAsInt()
+			if err != nil {
+				return nil, fmt.Errorf("invalid permissions %q for file %s: %w", f.Permissions, f.Path, err)
+			}
+		}
+		cfg.Storage.Files = append(cfg.Storage.Files, ignitionFileWithContent(f.Path, mode, f.Content))
+	}
+
+	if len(c.RunCmds) > 0 {
+		var script strings.Builder
+		script.WriteString("#!/bin/sh\nset -e\n")
+		for _, cmd := range c.RunCmds {
+			script.WriteString(cmd)
+			script.WriteString("\n")
+		}
+		fmt.Fprintf(&script, "mkdir -p %s && touch %s\n", path.Dir(ignitionBootstrapSentinel), ignitionBootstrapSentinel)
+		cfg.Storage.Files = append(cfg.Storage.Files, ignitionFileWithContent(ignitionBootstrapScript, 0755, script.String()))
+
+		cfg.Systemd.Units = append(cfg.Systemd.Units, ignitionUnit{
+			Name:    ignitionBootstrapUnit,
+			Enabled: true,
+			Contents: fmt.Sprintf(`[Unit]
+Description=k0smotron bootstrap
+Wants=network-online.target
+After=network-online.target
+ConditionPathExists=!%s
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=%s
+
+[Install]
+WantedBy=multi-user.target
+`, ignitionBootstrapSentinel, ignitionBootstrapScript),
+		})
+	}
+
+	return json.Marshal(cfg)
+}
+
+func ignitionFileWithContent(filePath string, mode int64, content string) ignitionFile {
+	return ignitionFile{
+		Path:      filePath,
+		Mode:      mode,
+		Overwrite: true,
+		Contents: ignitionContents{
+			Source: "data:;base64," + base64.StdEncoding.EncodeToString([]byte(content)),
+		},
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudinit
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+const (
+	multipartBoundary = "k0smotron-user-data-boundary"
+
+	// multipartMergeType makes cloud-init append the lists, e.g. runcmd and write_files, of the cloud-config parts
+	// instead of replacing them.
+	multipartMergeType = "list(append)+dict(no_replace,recurse_list)+str()"
+)
+
+// AsMultipartBytes renders the cloud-init data merged with the additional user data as a multipart MIME message.
+// The additional user data is processed by cloud-init before the k0smotron generated data, so its commands are run
+// before k0s is installed.
+func (c *CloudInit) AsMultipartBytes(additionalUserData []byte) ([]byte, error) {
+	generated, err := c.AsBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%q\nMIME-Version: 1.0\n\n", multipartBoundary)
+
+	w := multipart.NewWriter(&b)
+	if err := w.SetBoundary(multipartBoundary); err != nil {
+		return nil, err
+	}
+	for _, part := range [][]byte{additionalUserData, generated} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", userDataContentType(part)+`; charset="utf-8"`)
+		header.Set("Merge-Type", multipartMergeType)
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write(part); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// userDataContentType returns the cloud-init content type of the user data, based on its header.
+// See: https://cloudinit.readthedocs.io/en/latest/explanation/format.html
+func userDataContentType(data []byte) string {
+	s := string(data)
+	switch {
+	case strings.HasPrefix(s, "#!"):
+		return "text/x-shellscript"
+	case strings.HasPrefix(s, "#cloud-boothook"):
+		return "text/cloud-boothook"
+	case strings.HasPrefix(s, "#include"):
+		return "text/x-include-url"
+	default:
+		return "text/cloud-config"
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,28 +34,30 @@ import (
 	"k8s.io/utils/ptr"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	bsutil "sigs.k8s.io/cluster-api/bootstrap/util"
-	"sigs.k8s.io/cluster-api/controllers/remote"
+	expv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
 	capiutil "sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/annotations"
 	"sigs.k8s.io/cluster-api/util/secret"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
 	"github.com/k0sproject/k0smotron/internal/cloudinit"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
 	kutil "github.com/k0sproject/k0smotron/internal/util"
 )
 
-const (
-	defaultK0sSuffix = "k0s.0"
-)
-
 type Controller struct {
 	client.Client
 	Scheme     *runtime.Scheme
 	ClientSet  *kubernetes.Clientset
 	RESTConfig *rest.Config
+	// Shard selects the resources reconciled by the controller. All the resources are reconciled if nil.
+	Shard *sharding.Shard
 }
 
 type Scope struct {
@@ -65,7 +69,7 @@ type Scope struct {
 // +kubebuilder:rbac:groups=bootstrap.cluster.x-k8s.io,resources=k0sworkerconfigs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=bootstrap.cluster.x-k8s.io,resources=k0sworkerconfigs/status,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;clusters/status;machines;machines/status,verbs=get;list;watch
-// +kubebuilder:rbac:groups=exp.cluster.x-k8s.io,resources=machinepools;machinepools/status,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinepools;machinepools/status,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=secrets;events;configmaps,verbs=get;list;watch;create;update;patch;delete
 
 func (r *Controller) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, err error) {
@@ -101,13 +105,18 @@ func (r *Controller) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.
 	}
 
 	log = log.WithValues("kind", configOwner.GetKind(), "version", configOwner.GetResourceVersion(), "name", configOwner.GetName())
-
-	machine := &clusterv1.Machine{}
-	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(configOwner.Object, machine); err != nil {
-		return ctrl.Result{}, fmt.Errorf("error converting %s to Machine: %w", configOwner.GetKind(), err)
+	ctx = tracing.WithCluster(ctx, configOwner.ClusterName())
+
+	// The machine is nil if the config is owned by a MachinePool
+	var machine *clusterv1.Machine
+	if !configOwner.IsMachinePool() {
+		machine = &clusterv1.Machine{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(configOwner.Object, machine); err != nil {
+			return ctrl.Result{}, fmt.Errorf("error converting %s to Machine: %w", configOwner.GetKind(), err)
+		}
 	}
-	if config.Spec.Version == "" && machine.Spec.Version != nil {
-		config.Spec.Version = fmt.Sprintf("%s+%s", *machine.Spec.Version, defaultK0sSuffix)
+	if config.Spec.Version == "" && configOwner.KubernetesVersion() != "" {
+		config.Spec.Version = kutil.K0sVersion(configOwner.KubernetesVersion())
 	}
 
 	// Lookup the cluster the config owner is associated with
@@ -137,15 +146,39 @@ func (r *Controller) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.
 		Cluster:     cluster,
 	}
 
-	// TODO Check if the secret is already present etc. to bail out early
-
-	log.Info("Finding the token secret")
-	// Get the token from a secret
-	token, err := r.getK0sToken(ctx, scope)
+	state, err := r.checkBootstrapData(ctx, config, machine)
 	if err != nil {
-		log.Error(err, "Failed to get token")
+		log.Error(err, "Failed to check bootstrap data")
 		return ctrl.Result{}, err
 	}
+	if state.upToDate {
+		return ctrl.Result{RequeueAfter: state.renewAfter}, nil
+	}
+
+	secretAnnotations := map[string]string{
+		configGenerationAnnotation: strconv.FormatInt(config.Generation, 10),
+	}
+	var token string
+	if config.Spec.JoinTokenSecretRef != nil {
+		log.Info("Reading the token from the join token secret")
+		token, err = r.getJoinTokenFromSecret(ctx, config)
+		if err != nil {
+			log.Error(err, "Failed to get token")
+			return ctrl.Result{}, err
+		}
+		secretAnnotations[joinTokenHashAnnotation] = joinTokenHash(token)
+	} else {
+		log.Info("Finding the token secret")
+		// Get the token from a secret
+		var expiration time.Time
+		token, expiration, err = r.getK0sToken(ctx, scope)
+		if err != nil {
+			log.Error(err, "Failed to get token")
+			return ctrl.Result{}, err
+		}
+		secretAnnotations[joinTokenExpirationAnnotation] = expiration.Format(time.RFC3339)
+		res.RequeueAfter = time.Until(expiration.Add(-joinTokenRenewBefore))
+	}
 
 	log.Info("Creating bootstrap data")
 
@@ -158,29 +191,62 @@ func (r *Controller) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.
 		},
 	}
 
-	files = append(files, config.Spec.Files...)
-	downloadCommands := createDownloadCommands(config)
+	registryConfigFiles, err := registryFiles(ctx, r.Client, config.Namespace, config.Spec.Registries)
+	if err != nil {
+		log.Error(err, "Failed to generate registry files")
+		return ctrl.Result{}, err
+	}
+	files = append(files, registryConfigFiles...)
+
+	if !config.Spec.PreInstalledK0s {
+		ociFiles, err := ociArtifactFiles(ctx, r.Client, config.Namespace, config.Spec.OCIArtifact)
+		if err != nil {
+			log.Error(err, "Failed to generate OCI artifact files")
+			return ctrl.Result{}, err
+		}
+		files = append(files, ociFiles...)
+	}
+
+	extraFiles, err := resolveFiles(ctx, r.Client, config.Namespace, config.Spec.Files)
+	if err != nil {
+		log.Error(err, "Failed to resolve files")
+		return ctrl.Result{}, err
+	}
+	files = append(files, extraFiles...)
+	downloadCommands := withProxyEnv(config.Spec.Proxy, createDownloadCommands(config))
 	installCmd := createInstallCmd(config)
 
-	commands := config.Spec.PreStartCommands
-	commands = append(commands, downloadCommands...)
 	startCmd, err := getStartCommand("worker") // The bootstrap controller only supports worker nodes currently
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	commands = append(commands, installCmd, startCmd)
-	commands = append(commands, config.Spec.PostStartCommands...)
+
+	commands := newBootstrapCommands(config.Spec.Commands)
+	commands.addPhase(commands.phases.BeforeDownload)
+	commands.add(config.Spec.PreStartCommands...)
+	commands.add(downloadCommands...)
+	commands.addPhase(commands.phases.BeforeInstall)
+	commands.add(installCmd)
+	commands.addPhase(commands.phases.AfterInstall)
+	commands.add(startCmd)
+	commands.addPhase(commands.phases.AfterStart)
+	commands.add(config.Spec.PostStartCommands...)
 	// Create the sentinel file as the last step so we know all previous _stuff_ has completed
 	// https://cluster-api.sigs.k8s.io/developer/providers/bootstrap.html#sentinel-file
-	commands = append(commands, "mkdir -p /run/cluster-api && touch /run/cluster-api/bootstrap-success.complete")
+	commands.add("mkdir -p /run/cluster-api && touch /run/cluster-api/bootstrap-success.complete")
 
 	ci := &cloudinit.CloudInit{
 		Files:   files,
-		RunCmds: commands,
+		RunCmds: commands.commands,
 	}
 
 	// Create the bootstrap data
-	bootstrapData, err := ci.AsBytes()
+	additionalUserData, err := resolveAdditionalUserData(ctx, r.Client, config.Namespace, config.Spec.AdditionalUserData)
+	if err != nil {
+		log.Error(err, "Failed to resolve additional user data")
+		return ctrl.Result{}, err
+	}
+	bootstrapData, err := renderBootstrapData(ci, config.Spec.Format, additionalUserData)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
@@ -196,6 +262,7 @@ func (r *Controller) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.
 			Labels: map[string]string{
 				clusterv1.ClusterNameLabel: scope.Cluster.Name,
 			},
+			Annotations: secretAnnotations,
 			OwnerReferences: []metav1.OwnerReference{
 				{
 					APIVersion: bootstrapv1.GroupVersion.String(),
@@ -207,7 +274,8 @@ func (r *Controller) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.
 			},
 		},
 		Data: map[string][]byte{
-			"value": bootstrapData,
+			"value":  bootstrapData,
+			"format": []byte(bootstrapDataFormat(config.Spec.Format)),
 		},
 		Type: clusterv1.ClusterSecretType,
 	}
@@ -219,17 +287,25 @@ func (r *Controller) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.
 
 	log.Info("Bootstrap secret created", "secret", bootstrapSecret.Name)
 
+	if state.specChanged && machine != nil {
+		if err := r.markMachineOutdated(ctx, machine); err != nil {
+			log.Error(err, "Failed to mark machine outdated")
+			return ctrl.Result{}, err
+		}
+	}
+
 	// Set the status to ready
 	scope.Config.Status.Ready = true
 	scope.Config.Status.DataSecretName = ptr.To(bootstrapSecret.Name)
-	if err := r.Status().Update(ctx, scope.Config); err != nil {
+	status := scope.Config.Status
+	if err := kcutil.UpdateStatus(ctx, r.Client, scope.Config, func(config *bootstrapv1.K0sWorkerConfig) { config.Status = status }); err != nil {
 		log.Error(err, "Failed to patch config status")
 		return ctrl.Result{}, err
 	}
 
 	log.Info("Reconciled succesfully")
 
-	return ctrl.Result{}, nil
+	return res, nil
 }
 
 const startCommandTemplate = `(command -v systemctl > /dev/null 2>&1 && systemctl start %s) || (command -v rc-service > /dev/null 2>&1 && rc-service %s start) || (echo "Not a supported init system"; false)`
@@ -248,20 +324,28 @@ func getStartCommand(role string) (string, error) {
 	}
 }
 
-func (r *Controller) getK0sToken(ctx context.Context, scope *Scope) (string, error) {
+// getK0sToken creates a bootstrap token in the child cluster and returns the join token using it and the expiration
+// of the bootstrap token.
+func (r *Controller) getK0sToken(ctx context.Context, scope *Scope) (string, time.Time, error) {
 	if scope.Cluster.Spec.ControlPlaneEndpoint.IsZero() {
-		return "", errors.New("control plane endpoint is not set")
+		return "", time.Time{}, errors.New("control plane endpoint is not set")
 
 	}
-	childClient, err := remote.NewClusterClient(ctx, "k0smotron", r.Client, capiutil.ObjectKey(scope.Cluster))
+	childClient, err := tracing.NewClusterClient(ctx, "k0smotron", r.Client, capiutil.ObjectKey(scope.Cluster))
 	if err != nil {
-		return "", fmt.Errorf("failed to create child cluster client: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to create child cluster client: %w", err)
 	}
 
 	// Create the token using the child cluster client
 	tokenID := kutil.RandomString(6)
 	tokenSecret := kutil.RandomString(16)
 	token := fmt.Sprintf("%s.%s", tokenID, tokenSecret)
+	// TODO We need bit shorter time for the token
+	expiration := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	description := "Worker bootstrap token generated by k0smotron"
+	if scope.ConfigOwner.IsMachinePool() {
+		description = fmt.Sprintf("%s for machine pool %s", description, scope.ConfigOwner.GetName())
+	}
 	if err := childClient.Create(ctx, &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf("bootstrap-token-%s", tokenID),
@@ -269,55 +353,109 @@ func (r *Controller) getK0sToken(ctx context.Context, scope *Scope) (string, err
 		},
 		Type: corev1.SecretTypeBootstrapToken,
 		StringData: map[string]string{
-			"token-id":     tokenID,
-			"token-secret": tokenSecret,
-			// TODO We need bit shorter time for the token
-			"expiration":                       time.Now().Add(24 * time.Hour).Format(time.RFC3339),
+			"token-id":                         tokenID,
+			"token-secret":                     tokenSecret,
+			"expiration":                       expiration.Format(time.RFC3339),
 			"usage-bootstrap-api-auth":         "true",
-			"description":                      "Worker bootstrap token generated by k0smotron",
+			"description":                      description,
 			"usage-bootstrap-authentication":   "true",
 			"usage-bootstrap-api-worker-calls": "true",
 		},
 	}); err != nil {
-		return "", fmt.Errorf("failed to create token secret: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to create token secret: %w", err)
 	}
 
 	certificates := secret.NewCertificatesForWorker("")
 	if err := certificates.Lookup(ctx, r.Client, capiutil.ObjectKey(scope.Cluster)); err != nil {
-		return "", fmt.Errorf("failed to lookup CA certificates: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to lookup CA certificates: %w", err)
 	}
 	ca := certificates.GetByPurpose(secret.ClusterCA)
 	if ca.KeyPair == nil {
-		return "", errors.New("failed to get CA certificate key pair")
+		return "", time.Time{}, errors.New("failed to get CA certificate key pair")
 
 	}
 
 	joinToken, err := kutil.CreateK0sJoinToken(ca.KeyPair.Cert, token, fmt.Sprintf("https://%s:%d", scope.Cluster.Spec.ControlPlaneEndpoint.Host, scope.Cluster.Spec.ControlPlaneEndpoint.Port), "kubelet-bootstrap")
 	if err != nil {
-		return "", fmt.Errorf("failed to create join token: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to create join token: %w", err)
 	}
-	return joinToken, nil
+	return joinToken, expiration, nil
 }
 
 func createInstallCmd(config *bootstrapv1.K0sWorkerConfig) string {
 	installCmd := []string{
 		"k0s install worker --token-file /etc/k0s.token"}
+	installCmd = append(installCmd, proxyInstallArgs(config.Spec.Proxy)...)
+	installCmd = append(installCmd, nodeInstallArgs(config)...)
 	if config.Spec.Args != nil && len(config.Spec.Args) > 0 {
 		installCmd = append(installCmd, config.Spec.Args...)
 	}
 	return strings.Join(installCmd, " ")
 }
 
+// nodeInstallArgs returns the k0s worker install arguments for the worker profile, node labels, taints and kubelet
+// arguments.
+func nodeInstallArgs(config *bootstrapv1.K0sWorkerConfig) []string {
+	args := nodeShapeArgs(config.Spec.WorkerProfile, config.Spec.NodeLabels, config.Spec.Taints)
+	if kubeletArgs := kubeletExtraArgs(config.Spec.KubeletExtraArgs); len(kubeletArgs) > 0 {
+		args = append(args, fmt.Sprintf("--kubelet-extra-args='%s'", strings.Join(kubeletArgs, " ")))
+	}
+	return args
+}
+
+// nodeShapeArgs returns the k0s install arguments for the worker profile, node labels and taints.
+func nodeShapeArgs(profile string, nodeLabels map[string]string, nodeTaints []corev1.Taint) []string {
+	var args []string
+
+	if profile != "" {
+		args = append(args, "--profile="+profile)
+	}
+
+	if len(nodeLabels) > 0 {
+		labels := make([]string, 0, len(nodeLabels))
+		for k, v := range nodeLabels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		args = append(args, "--labels="+strings.Join(labels, ","))
+	}
+
+	if len(nodeTaints) > 0 {
+		taints := make([]string, 0, len(nodeTaints))
+		for _, t := range nodeTaints {
+			taint := t.Key
+			if t.Value != "" {
+				taint += "=" + t.Value
+			}
+			taints = append(taints, taint+":"+string(t.Effect))
+		}
+		args = append(args, "--taints="+strings.Join(taints, ","))
+	}
+
+	return args
+}
+
+// kubeletExtraArgs returns the sorted kubelet arguments.
+func kubeletExtraArgs(extraArgs map[string]string) []string {
+	kubeletArgs := make([]string, 0, len(extraArgs))
+	for k, v := range extraArgs {
+		kubeletArgs = append(kubeletArgs, fmt.Sprintf("--%s=%s", k, v))
+	}
+	sort.Strings(kubeletArgs)
+	return kubeletArgs
+}
+
 func createDownloadCommands(config *bootstrapv1.K0sWorkerConfig) []string {
 	if config.Spec.PreInstalledK0s {
 		return nil
 	}
 
+	if config.Spec.OCIArtifact != nil {
+		return createOCIDownloadCommands(config.Spec.OCIArtifact)
+	}
+
 	if config.Spec.DownloadURL != "" {
-		return []string{
-			fmt.Sprintf("curl -sSfL %s -o /usr/local/bin/k0s", config.Spec.DownloadURL),
-			"chmod +x /usr/local/bin/k0s",
-		}
+		return createDownloadURLCommands(config.Spec.DownloadURL, config.Spec.DownloadChecksum)
 	}
 
 	// Figure out version to download if download URL is not set
@@ -328,8 +466,54 @@ func createDownloadCommands(config *bootstrapv1.K0sWorkerConfig) []string {
 	return []string{"curl -sSfL https://get.k0s.sh | sh"}
 }
 
+// createDownloadURLCommands returns the commands to install the k0s binary from the given URL. If the checksum
+// is set, the binary is moved in place only if the checksum matches, so the install fails without it.
+func createDownloadURLCommands(url, checksum string) []string {
+	if checksum == "" {
+		return []string{
+			fmt.Sprintf("curl -sSfL %s -o /usr/local/bin/k0s", url),
+			"chmod +x /usr/local/bin/k0s",
+		}
+	}
+
+	return []string{
+		fmt.Sprintf("curl -sSfL %s -o /usr/local/bin/k0s.download", url),
+		fmt.Sprintf("echo '%s  /usr/local/bin/k0s.download' | sha256sum -c - && mv /usr/local/bin/k0s.download /usr/local/bin/k0s", strings.ToLower(checksum)),
+		"chmod +x /usr/local/bin/k0s",
+	}
+}
+
+// renderBootstrapData renders the bootstrap data in the requested format, merged with the additional user data if set.
+func renderBootstrapData(ci *cloudinit.CloudInit, format bootstrapv1.Format, additionalUserData []byte) ([]byte, error) {
+	if format == bootstrapv1.FormatIgnition {
+		if len(additionalUserData) > 0 {
+			return nil, errors.New("additional user data is not supported with the ignition format")
+		}
+		return ci.AsIgnition()
+	}
+	if len(additionalUserData) > 0 {
+		return ci.AsMultipartBytes(additionalUserData)
+	}
+	return ci.AsBytes()
+}
+
+// bootstrapDataFormat returns the value of the format key of the bootstrap secret, as expected by the
+// infrastructure providers.
+func bootstrapDataFormat(format bootstrapv1.Format) bootstrapv1.Format {
+	if format == "" {
+		return bootstrapv1.FormatCloudConfig
+	}
+	return format
+}
+
 func (r *Controller) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &bootstrapv1.K0sWorkerConfig{}, configJoinTokenSecretField, indexConfigJoinTokenSecret); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&bootstrapv1.K0sWorkerConfig{}).
-		Complete(r)
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.requestsForJoinTokenSecret)).
+		Watches(&expv1.MachinePool{}, handler.EnqueueRequestsFromMapFunc(machinePoolToBootstrapConfig)).
+		Complete(r.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("K0sWorkerConfig", r)))
 }
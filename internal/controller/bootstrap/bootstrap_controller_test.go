@@ -21,6 +21,7 @@ import (
 
 	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
 )
 
 func Test_createInstallCmd(t *testing.T) {
@@ -44,6 +45,44 @@ func Test_createInstallCmd(t *testing.T) {
 			},
 			want: base + " --debug --labels=k0sproject.io/foo=bar",
 		},
+		{
+			name: "with proxy",
+			config: &bootstrapv1.K0sWorkerConfig{
+				Spec: bootstrapv1.K0sWorkerConfigSpec{
+					Args: []string{"--debug"},
+					Proxy: &bootstrapv1.ProxySpec{
+						HTTPProxy:  "http://proxy.example.com:3128",
+						HTTPSProxy: "http://proxy.example.com:3128",
+						NoProxy:    []string{"10.0.0.0/8", ".svc", "example.com"},
+					},
+				},
+			},
+			want: base + " --env=HTTP_PROXY=http://proxy.example.com:3128 --env=HTTPS_PROXY=http://proxy.example.com:3128 --env=NO_PROXY=10.0.0.0/8,.svc,example.com --debug",
+		},
+		{
+			name: "with worker profile",
+			config: &bootstrapv1.K0sWorkerConfig{
+				Spec: bootstrapv1.K0sWorkerConfigSpec{
+					WorkerProfile: "custom",
+					NodeLabels:    map[string]string{"pool": "gpu"},
+				},
+			},
+			want: base + " --profile=custom --labels=pool=gpu",
+		},
+		{
+			name: "with node labels, taints and kubelet args",
+			config: &bootstrapv1.K0sWorkerConfig{
+				Spec: bootstrapv1.K0sWorkerConfigSpec{
+					NodeLabels: map[string]string{"pool": "gpu", "example.com/zone": "a"},
+					Taints: []corev1.Taint{
+						{Key: "nvidia.com/gpu", Value: "true", Effect: corev1.TaintEffectNoSchedule},
+						{Key: "dedicated", Effect: corev1.TaintEffectNoExecute},
+					},
+					KubeletExtraArgs: map[string]string{"max-pods": "50", "cpu-manager-policy": "static"},
+				},
+			},
+			want: base + " --labels=example.com/zone=a,pool=gpu --taints=nvidia.com/gpu=true:NoSchedule,dedicated:NoExecute --kubelet-extra-args='--cpu-manager-policy=static --max-pods=50'",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -97,6 +136,34 @@ func Test_createDownloadCommands(t *testing.T) {
 				"chmod +x /usr/local/bin/k0s",
 			},
 		},
+		{
+			name: "with OCI artifact",
+			config: &bootstrapv1.K0sWorkerConfig{
+				Spec: bootstrapv1.K0sWorkerConfigSpec{
+					DownloadURL: "https://example.com/k0s",
+					OCIArtifact: &bootstrapv1.OCIArtifact{Reference: "registry.example.com/k0s:v1.28.4-k0s.0"},
+				},
+			},
+			want: []string{
+				"/etc/k0smotron/oci-pull.sh registry.example.com k0s v1.28.4-k0s.0 /usr/local/bin/k0s",
+				"chmod +x /usr/local/bin/k0s",
+			},
+		},
+		{
+			name: "with custom download URL and checksum",
+			config: &bootstrapv1.K0sWorkerConfig{
+				Spec: bootstrapv1.K0sWorkerConfigSpec{
+					DownloadURL:      "https://example.com/k0s",
+					DownloadChecksum: "1A2B3C4D5E6F7A8B9C0D1E2F3A4B5C6D7E8F9A0B1C2D3E4F5A6B7C8D9E0F1A2B",
+					Version:          "v1.2.3",
+				},
+			},
+			want: []string{
+				"curl -sSfL https://example.com/k0s -o /usr/local/bin/k0s.download",
+				"echo '1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b  /usr/local/bin/k0s.download' | sha256sum -c - && mv /usr/local/bin/k0s.download /usr/local/bin/k0s",
+				"chmod +x /usr/local/bin/k0s",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,58 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"fmt"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+)
+
+// bootstrapCommands assembles the bootstrap commands in the order of the phases.
+type bootstrapCommands struct {
+	phases   bootstrapv1.Commands
+	commands []string
+}
+
+func newBootstrapCommands(phases *bootstrapv1.Commands) *bootstrapCommands {
+	b := &bootstrapCommands{}
+	if phases != nil {
+		b.phases = *phases
+	}
+	return b
+}
+
+// add appends the commands run by k0smotron itself.
+func (b *bootstrapCommands) add(commands ...string) {
+	b.commands = append(b.commands, commands...)
+}
+
+// addPhase appends the user defined commands of a phase, with their failure policy applied.
+func (b *bootstrapCommands) addPhase(commands []bootstrapv1.Command) {
+	for _, c := range commands {
+		b.commands = append(b.commands, renderCommand(c))
+	}
+}
+
+// renderCommand returns the shell command with the failure policy applied. The command is run in a subshell,
+// so it can be composed of several statements.
+func renderCommand(c bootstrapv1.Command) string {
+	if c.FailurePolicy == bootstrapv1.CommandFailurePolicyIgnore {
+		return fmt.Sprintf("(%s) || true", c.Command)
+	}
+	return fmt.Sprintf("(%s) || exit 1", c.Command)
+}
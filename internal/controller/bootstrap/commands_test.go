@@ -0,0 +1,52 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+)
+
+func Test_bootstrapCommands(t *testing.T) {
+	commands := newBootstrapCommands(&bootstrapv1.Commands{
+		BeforeDownload: []bootstrapv1.Command{{Command: "mkfs.ext4 /dev/sdb && mount /dev/sdb /var/lib/k0s"}},
+		AfterStart:     []bootstrapv1.Command{{Command: "harden.sh", FailurePolicy: bootstrapv1.CommandFailurePolicyIgnore}},
+	})
+	commands.addPhase(commands.phases.BeforeDownload)
+	commands.add("download")
+	commands.addPhase(commands.phases.BeforeInstall)
+	commands.add("install")
+	commands.addPhase(commands.phases.AfterStart)
+
+	require.Equal(t, []string{
+		"(mkfs.ext4 /dev/sdb && mount /dev/sdb /var/lib/k0s) || exit 1",
+		"download",
+		"install",
+		"(harden.sh) || true",
+	}, commands.commands)
+}
+
+func Test_bootstrapCommandsWithoutPhases(t *testing.T) {
+	commands := newBootstrapCommands(nil)
+	commands.addPhase(commands.phases.BeforeDownload)
+	commands.add("download")
+
+	require.Equal(t, []string{"download"}, commands.commands)
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -31,14 +32,13 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/util/retry"
 	"k8s.io/utils/ptr"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	kubeadmbootstrapv1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1beta1"
 	bsutil "sigs.k8s.io/cluster-api/bootstrap/util"
-	"sigs.k8s.io/cluster-api/controllers/remote"
 	capiutil "sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/collections"
 	"sigs.k8s.io/cluster-api/util/secret"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -46,6 +46,9 @@ import (
 
 	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
 	"github.com/k0sproject/k0smotron/internal/cloudinit"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
 	kutil "github.com/k0sproject/k0smotron/internal/util"
 )
 
@@ -54,14 +57,19 @@ type ControlPlaneController struct {
 	Scheme     *runtime.Scheme
 	ClientSet  *kubernetes.Clientset
 	RESTConfig *rest.Config
+	// Shard selects the resources reconciled by the controller. All the resources are reconciled if nil.
+	Shard *sharding.Shard
 }
 
-const joinTokenFilePath = "/etc/k0s.token"
+const (
+	joinTokenFilePath    = "/etc/k0s.token"
+	externalEtcdCertsDir = "/etc/k0s/external-etcd"
+)
 
 // +kubebuilder:rbac:groups=bootstrap.cluster.x-k8s.io,resources=k0scontrollerconfigs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=bootstrap.cluster.x-k8s.io,resources=k0scontrollerconfigs/status,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;clusters/status;machines;machines/status,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=exp.cluster.x-k8s.io,resources=machinepools;machinepools/status,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinepools;machinepools/status,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=secrets;events;configmaps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 
@@ -97,13 +105,14 @@ func (c *ControlPlaneController) Reconcile(ctx context.Context, req ctrl.Request
 	}
 
 	log = log.WithValues("kind", configOwner.GetKind(), "version", configOwner.GetResourceVersion(), "name", configOwner.GetName())
+	ctx = tracing.WithCluster(ctx, configOwner.ClusterName())
 
 	machine := &clusterv1.Machine{}
 	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(configOwner.Object, machine); err != nil {
 		return ctrl.Result{}, fmt.Errorf("error converting %s to Machine: %w", configOwner.GetKind(), err)
 	}
 	if config.Spec.Version == "" && machine.Spec.Version != nil {
-		config.Spec.Version = fmt.Sprintf("%s+%s", *machine.Spec.Version, defaultK0sSuffix)
+		config.Spec.Version = kutil.K0sVersion(*machine.Spec.Version)
 	}
 
 	// Lookup the cluster the config owner is associated with
@@ -140,29 +149,47 @@ func (c *ControlPlaneController) Reconcile(ctx context.Context, req ctrl.Request
 		installCmd string
 	)
 
+	if config.Spec.ExternalEtcd != nil {
+		externalEtcdFiles, err := c.genExternalEtcdFiles(ctx, scope, config)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error generating external etcd files: %v", err)
+		}
+		files = append(files, externalEtcdFiles...)
+	}
+
+	if config.Spec.VIP != nil && config.Spec.K0s == nil {
+		config.Spec.K0s = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "k0s.k0sproject.io/v1beta1",
+			"kind":       "ClusterConfig",
+		}}
+	}
+
 	if config.Spec.K0s != nil {
-		err = unstructured.SetNestedField(config.Spec.K0s.Object, scope.Cluster.Spec.ControlPlaneEndpoint.Host, "spec", "api", "externalAddress")
+		// The VIP is announced by kube-vip running on the controllers, so the controllers can't reach the API through
+		// it before they are up. The VIP is only added to the SANs then.
+		if config.Spec.VIP != nil {
+			err = appendSAN(config.Spec.K0s, config.Spec.VIP.Address)
+		} else {
+			err = unstructured.SetNestedField(config.Spec.K0s.Object, scope.Cluster.Spec.ControlPlaneEndpoint.Host, "spec", "api", "externalAddress")
+		}
 		if err != nil {
 			return ctrl.Result{}, fmt.Errorf("error setting control plane endpoint: %v", err)
 		}
 
 		if config.Spec.Tunneling.ServerAddress != "" {
-			sans, _, err := unstructured.NestedSlice(config.Spec.K0s.Object, "spec", "api", "sans")
-			if err != nil {
-				return ctrl.Result{}, fmt.Errorf("error getting sans from config: %v", err)
-			}
-			sans = append(sans, config.Spec.Tunneling.ServerAddress)
-			err = unstructured.SetNestedSlice(config.Spec.K0s.Object, sans, "spec", "api", "sans")
-			if err != nil {
-				return ctrl.Result{}, fmt.Errorf("error setting sans to the config: %v", err)
+			if err := appendSAN(config.Spec.K0s, config.Spec.Tunneling.ServerAddress); err != nil {
+				return ctrl.Result{}, err
 			}
 		}
 
-		// Reconcile the dynamic config
-		dErr := kutil.ReconcileDynamicConfig(ctx, cluster, c.Client, config.Spec.K0s)
-		if dErr != nil {
-			// Don't return error from dynamic config reconciliation, as it may not be created yet
-			log.Error(fmt.Errorf("failed to reconcile dynamic config, kubeconfig may not be available yet: %w", dErr), "Failed to reconcile dynamic config")
+		// Reconcile the dynamic config. The dynamic config of a K0sControlPlane is reconciled by the control plane
+		// controller, so the config of an older machine doesn't override the current one.
+		if owner := metav1.GetControllerOf(machine); owner == nil || owner.Kind != "K0sControlPlane" {
+			dErr := kutil.ReconcileDynamicConfig(ctx, cluster, c.Client, config.Spec.K0s)
+			if dErr != nil {
+				// Don't return error from dynamic config reconciliation, as it may not be created yet
+				log.Error(fmt.Errorf("failed to reconcile dynamic config, kubeconfig may not be available yet: %w", dErr), "Failed to reconcile dynamic config")
+			}
 		}
 
 		k0sConfigBytes, err := config.Spec.K0s.MarshalJSON()
@@ -185,14 +212,23 @@ func (c *ControlPlaneController) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, fmt.Errorf("control plane endpoint is not set")
 	}
 
-	if strings.HasSuffix(config.Name, "-0") {
+	// A controller in the single node mode doesn't join other controllers
+	var joinMachine string
+	if !config.Spec.IsSingleNode() {
+		joinMachine, err = c.findJoinMachine(ctx, scope, config)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error finding control plane machine to join: %v", err)
+		}
+	}
+
+	if joinMachine == "" {
 		files, err = c.genInitialControlPlaneFiles(ctx, scope, files)
 		if err != nil {
 			return ctrl.Result{}, fmt.Errorf("error generating initial control plane files: %v", err)
 		}
 		installCmd = createCPInstallCmd(config)
 	} else {
-		files, err = c.genControlPlaneJoinFiles(ctx, scope, config, files)
+		files, err = c.genControlPlaneJoinFiles(ctx, scope, config, joinMachine, files)
 		if err != nil {
 			return ctrl.Result{}, fmt.Errorf("error generating control plane join files: %v", err)
 		}
@@ -205,28 +241,57 @@ func (c *ControlPlaneController) Reconcile(ctx context.Context, req ctrl.Request
 		}
 		files = append(files, tunnelingFiles...)
 	}
-	files = append(files, config.Spec.Files...)
+	if config.Spec.VIP != nil {
+		files = append(files, kubeVIPFiles(config.Spec.VIP, scope.Cluster.Spec.ControlPlaneEndpoint.Port)...)
+	}
+	registryConfigFiles, err := registryFiles(ctx, c.Client, config.Namespace, config.Spec.Registries)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error generating registry files: %v", err)
+	}
+	files = append(files, registryConfigFiles...)
+	if !config.Spec.PreInstalledK0s {
+		ociFiles, err := ociArtifactFiles(ctx, c.Client, config.Namespace, config.Spec.OCIArtifact)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error generating OCI artifact files: %v", err)
+		}
+		files = append(files, ociFiles...)
+	}
+	extraFiles, err := resolveFiles(ctx, c.Client, config.Namespace, config.Spec.Files)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error resolving files: %v", err)
+	}
+	files = append(files, extraFiles...)
 	files = append(files, genShutdownServiceFiles()...)
 
-	downloadCommands := createCPDownloadCommands(config)
-
-	commands := config.Spec.PreStartCommands
-	commands = append(commands, downloadCommands...)
-	commands = append(commands, "(command -v systemctl > /dev/null 2>&1 && (cp /k0s/k0sleave.service /etc/systemd/system/k0sleave.service && systemctl daemon-reload && systemctl enable k0sleave.service && systemctl start k0sleave.service) || true)")
-	commands = append(commands, "(command -v rc-service > /dev/null 2>&1 && (cp /k0s/k0sleave-openrc /etc/init.d/k0sleave && rc-update add k0sleave shutdown) || true)")
-	commands = append(commands, installCmd, "k0s start")
-	commands = append(commands, config.Spec.PostStartCommands...)
+	downloadCommands := withProxyEnv(config.Spec.Proxy, createCPDownloadCommands(config))
+
+	commands := newBootstrapCommands(config.Spec.Commands)
+	commands.addPhase(commands.phases.BeforeDownload)
+	commands.add(config.Spec.PreStartCommands...)
+	commands.add(downloadCommands...)
+	commands.add("(command -v systemctl > /dev/null 2>&1 && (cp /k0s/k0sleave.service /etc/systemd/system/k0sleave.service && systemctl daemon-reload && systemctl enable k0sleave.service && systemctl start k0sleave.service) || true)")
+	commands.add("(command -v rc-service > /dev/null 2>&1 && (cp /k0s/k0sleave-openrc /etc/init.d/k0sleave && rc-update add k0sleave shutdown) || true)")
+	commands.addPhase(commands.phases.BeforeInstall)
+	commands.add(installCmd)
+	commands.addPhase(commands.phases.AfterInstall)
+	commands.add("k0s start")
+	commands.addPhase(commands.phases.AfterStart)
+	commands.add(config.Spec.PostStartCommands...)
 	// Create the sentinel file as the last step so we know all previous _stuff_ has completed
 	// https://cluster-api.sigs.k8s.io/developer/providers/bootstrap.html#sentinel-file
-	commands = append(commands, "mkdir -p /run/cluster-api && touch /run/cluster-api/bootstrap-success.complete")
+	commands.add("mkdir -p /run/cluster-api && touch /run/cluster-api/bootstrap-success.complete")
 
 	ci := &cloudinit.CloudInit{
 		Files:   files,
-		RunCmds: commands,
+		RunCmds: commands.commands,
 	}
 
 	// Create the bootstrap data
-	bootstrapData, err := ci.AsBytes()
+	additionalUserData, err := resolveAdditionalUserData(ctx, c.Client, config.Namespace, config.Spec.AdditionalUserData)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error resolving additional user data: %v", err)
+	}
+	bootstrapData, err := renderBootstrapData(ci, config.Spec.K0sConfigSpec.Format, additionalUserData)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
@@ -253,7 +318,8 @@ func (c *ControlPlaneController) Reconcile(ctx context.Context, req ctrl.Request
 			},
 		},
 		Data: map[string][]byte{
-			"value": bootstrapData,
+			"value":  bootstrapData,
+			"format": []byte(bootstrapDataFormat(config.Spec.K0sConfigSpec.Format)),
 		},
 		Type: clusterv1.ClusterSecretType,
 	}
@@ -269,9 +335,8 @@ func (c *ControlPlaneController) Reconcile(ctx context.Context, req ctrl.Request
 	config.Status.Ready = true
 	config.Status.DataSecretName = ptr.To(bootstrapSecret.Name)
 
-	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
-		return c.Status().Update(ctx, config)
-	})
+	status := config.Status
+	err = kcutil.UpdateStatus(ctx, c.Client, config, func(config *bootstrapv1.K0sControllerConfig) { config.Status = status })
 	if err != nil {
 		log.Error(err, "Failed to patch config status")
 		return ctrl.Result{}, err
@@ -295,7 +360,7 @@ func (c *ControlPlaneController) genInitialControlPlaneFiles(ctx context.Context
 	return files, nil
 }
 
-func (c *ControlPlaneController) genControlPlaneJoinFiles(ctx context.Context, scope *Scope, config *bootstrapv1.K0sControllerConfig, files []cloudinit.File) ([]cloudinit.File, error) {
+func (c *ControlPlaneController) genControlPlaneJoinFiles(ctx context.Context, scope *Scope, config *bootstrapv1.K0sControllerConfig, joinMachine string, files []cloudinit.File) ([]cloudinit.File, error) {
 	log := log.FromContext(ctx).WithValues("K0sControllerConfig cluster", scope.Cluster.Name)
 
 	_, ca, err := c.getCerts(ctx, scope)
@@ -310,7 +375,7 @@ func (c *ControlPlaneController) genControlPlaneJoinFiles(ctx context.Context, s
 	token := fmt.Sprintf("%s.%s", tokenID, tokenSecret)
 	tokenKubeSecret := createTokenSecret(tokenID, tokenSecret)
 
-	chCS, err := remote.NewClusterClient(ctx, "k0smotron", c.Client, capiutil.ObjectKey(scope.Cluster))
+	chCS, err := tracing.NewClusterClient(ctx, "k0smotron", c.Client, capiutil.ObjectKey(scope.Cluster))
 	if err != nil {
 		log.Error(err, "Failed to getting child cluster client set")
 		return nil, err
@@ -322,7 +387,7 @@ func (c *ControlPlaneController) genControlPlaneJoinFiles(ctx context.Context, s
 		return nil, err
 	}
 
-	host, err := c.findFirstControllerIP(ctx, config)
+	host, err := c.findControllerIP(ctx, joinMachine, config)
 	if err != nil {
 		log.Error(err, "Failed to get controller IP")
 		return nil, err
@@ -423,6 +488,86 @@ spec:
 	}}, nil
 }
 
+// genExternalEtcdFiles points the k0s storage to the external etcd cluster and generates the files with the
+// etcd client certificates.
+func (c *ControlPlaneController) genExternalEtcdFiles(ctx context.Context, scope *Scope, kcs *bootstrapv1.K0sControllerConfig) ([]cloudinit.File, error) {
+	externalEtcd := kcs.Spec.ExternalEtcd
+	if (externalEtcd.CASecretRef == nil) != (externalEtcd.ClientCertSecretRef == nil) {
+		return nil, fmt.Errorf("caSecretRef and clientCertSecretRef must be set together")
+	}
+
+	etcdPrefix := externalEtcd.EtcdPrefix
+	if etcdPrefix == "" {
+		etcdPrefix = scope.Cluster.Name
+	}
+	endpoints := make([]interface{}, 0, len(externalEtcd.Endpoints))
+	for _, endpoint := range externalEtcd.Endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+	externalCluster := map[string]interface{}{
+		"endpoints":  endpoints,
+		"etcdPrefix": etcdPrefix,
+	}
+
+	var files []cloudinit.File
+	if externalEtcd.ClientCertSecretRef != nil {
+		caFiles, err := c.genExternalEtcdSecretFiles(ctx, kcs.Namespace, externalEtcd.CASecretRef.Name, "ca.crt")
+		if err != nil {
+			return nil, err
+		}
+		clientFiles, err := c.genExternalEtcdSecretFiles(ctx, kcs.Namespace, externalEtcd.ClientCertSecretRef.Name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+		if err != nil {
+			return nil, err
+		}
+		files = append(caFiles, clientFiles...)
+
+		externalCluster["caFile"] = files[0].Path
+		externalCluster["clientCertFile"] = files[1].Path
+		externalCluster["clientKeyFile"] = files[2].Path
+	}
+
+	if kcs.Spec.K0s == nil {
+		kcs.Spec.K0s = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "k0s.k0sproject.io/v1beta1",
+			"kind":       "ClusterConfig",
+			"metadata":   map[string]interface{}{"name": "k0s"},
+		}}
+	}
+	err := unstructured.SetNestedField(kcs.Spec.K0s.Object, map[string]interface{}{
+		"type": "etcd",
+		"etcd": map[string]interface{}{
+			"externalCluster": externalCluster,
+		},
+	}, "spec", "storage")
+	if err != nil {
+		return nil, fmt.Errorf("error setting external etcd to the config: %v", err)
+	}
+
+	return files, nil
+}
+
+// genExternalEtcdSecretFiles generates a file for each of the given keys of the secret.
+func (c *ControlPlaneController) genExternalEtcdSecretFiles(ctx context.Context, namespace, name string, keys ...string) ([]cloudinit.File, error) {
+	s := corev1.Secret{}
+	if err := c.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &s); err != nil {
+		return nil, fmt.Errorf("failed to get external etcd secret %s: %w", name, err)
+	}
+
+	files := make([]cloudinit.File, 0, len(keys))
+	for _, key := range keys {
+		data, ok := s.Data[key]
+		if !ok || len(data) == 0 {
+			return nil, fmt.Errorf("external etcd secret %s has no %s key", name, key)
+		}
+		files = append(files, cloudinit.File{
+			Path:        externalEtcdCertsDir + "/" + key,
+			Permissions: "0600",
+			Content:     string(data),
+		})
+	}
+	return files, nil
+}
+
 func (c *ControlPlaneController) getCerts(ctx context.Context, scope *Scope) ([]cloudinit.File, *secret.Certificate, error) {
 	var files []cloudinit.File
 	certificates := secret.NewCertificatesForInitialControlPlane(&kubeadmbootstrapv1.ClusterConfiguration{
@@ -482,7 +627,7 @@ func createTokenSecret(tokenID, tokenSecret string) *corev1.Secret {
 func (c *ControlPlaneController) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&bootstrapv1.K0sControllerConfig{}).
-		Complete(c)
+		Complete(c.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("K0sControllerConfig", c)))
 }
 
 func createCPDownloadCommands(config *bootstrapv1.K0sControllerConfig) []string {
@@ -490,11 +635,12 @@ func createCPDownloadCommands(config *bootstrapv1.K0sControllerConfig) []string
 		return nil
 	}
 
+	if config.Spec.OCIArtifact != nil {
+		return createOCIDownloadCommands(config.Spec.OCIArtifact)
+	}
+
 	if config.Spec.DownloadURL != "" {
-		return []string{
-			fmt.Sprintf("curl -sSfL %s -o /usr/local/bin/k0s", config.Spec.DownloadURL),
-			"chmod +x /usr/local/bin/k0s",
-		}
+		return createDownloadURLCommands(config.Spec.DownloadURL, config.Spec.DownloadChecksum)
 	}
 
 	// Figure out version to download if download URL is not set
@@ -511,8 +657,10 @@ func createCPInstallCmd(config *bootstrapv1.K0sControllerConfig) string {
 		"--force",
 		"--enable-dynamic-config",
 		"--env AUTOPILOT_HOSTNAME=" + config.Name,
-		"--kubelet-extra-args=--hostname-override=" + config.Name,
+		controllerKubeletExtraArgs(config),
 	}
+	installCmd = append(installCmd, proxyInstallArgs(config.Spec.Proxy)...)
+	installCmd = append(installCmd, controllerWorkerInstallArgs(config)...)
 	if config.Spec.Args != nil && len(config.Spec.Args) > 0 {
 		installCmd = append(installCmd, config.Spec.Args...)
 	}
@@ -525,8 +673,10 @@ func createCPInstallCmdWithJoinToken(config *bootstrapv1.K0sControllerConfig, to
 		"--force",
 		"--enable-dynamic-config",
 		"--env AUTOPILOT_HOSTNAME=" + config.Name,
-		"--kubelet-extra-args=--hostname-override=" + config.Name,
+		controllerKubeletExtraArgs(config),
 	}
+	installCmd = append(installCmd, proxyInstallArgs(config.Spec.Proxy)...)
+	installCmd = append(installCmd, controllerWorkerInstallArgs(config)...)
 	installCmd = append(installCmd, "--token-file", tokenPath)
 	if config.Spec.Args != nil && len(config.Spec.Args) > 0 {
 		installCmd = append(installCmd, config.Spec.Args...)
@@ -534,11 +684,91 @@ func createCPInstallCmdWithJoinToken(config *bootstrapv1.K0sControllerConfig, to
 	return strings.Join(installCmd, " ")
 }
 
-func (c *ControlPlaneController) findFirstControllerIP(ctx context.Context, config *bootstrapv1.K0sControllerConfig) (string, error) {
+// controllerKubeletExtraArgs returns the kubelet arguments of the controller, used if the controller runs the
+// workloads. The hostname is overridden so the node name matches the name of the machine. With a VIP, the kubelet
+// runs the kube-vip static pod.
+func controllerKubeletExtraArgs(config *bootstrapv1.K0sControllerConfig) string {
+	kubeletArgs := []string{"--hostname-override=" + config.Name}
+	if config.Spec.VIP != nil {
+		kubeletArgs = append(kubeletArgs, "--pod-manifest-path="+kubeVIPManifestDir)
+	}
+	if config.Spec.Worker != nil {
+		kubeletArgs = append(kubeletArgs, kubeletExtraArgs(config.Spec.Worker.KubeletExtraArgs)...)
+	}
+	if len(kubeletArgs) == 1 {
+		return "--kubelet-extra-args=" + kubeletArgs[0]
+	}
+	return fmt.Sprintf("--kubelet-extra-args='%s'", strings.Join(kubeletArgs, " "))
+}
+
+// controllerWorkerInstallArgs returns the k0s controller install arguments running the workloads on the controller.
+// A controller with a VIP runs a worker for the kube-vip static pod.
+func controllerWorkerInstallArgs(config *bootstrapv1.K0sControllerConfig) []string {
+	worker := config.Spec.Worker
+	if worker == nil && config.Spec.VIP != nil {
+		worker = &bootstrapv1.ControllerWorker{}
+	}
+	if worker == nil {
+		return nil
+	}
+
+	var args []string
+	if !config.Spec.IsSingleNode() && !slices.ContainsFunc(config.Spec.Args, isEnableWorkerArg) {
+		args = append(args, "--enable-worker")
+	}
+	if worker.NoTaints {
+		args = append(args, "--no-taints")
+	}
+	return append(args, nodeShapeArgs(worker.Profile, worker.NodeLabels, worker.Taints)...)
+}
+
+// appendSAN adds an address to the SANs of the API server in the k0s config.
+func appendSAN(k0sConfig *unstructured.Unstructured, address string) error {
+	sans, _, err := unstructured.NestedSlice(k0sConfig.Object, "spec", "api", "sans")
+	if err != nil {
+		return fmt.Errorf("error getting sans from config: %v", err)
+	}
+	sans = append(sans, address)
+	if err := unstructured.SetNestedSlice(k0sConfig.Object, sans, "spec", "api", "sans"); err != nil {
+		return fmt.Errorf("error setting sans to the config: %v", err)
+	}
+	return nil
+}
+
+func isEnableWorkerArg(arg string) bool {
+	return arg == "--enable-worker" || arg == "--enable-worker=true"
+}
+
+// findJoinMachine returns the name of the control plane machine the controller joins to. If there is a running
+// controller, the machine joins to it. Otherwise, the first controller of the control plane initializes the cluster
+// and the other controllers join to it.
+func (c *ControlPlaneController) findJoinMachine(ctx context.Context, scope *Scope, config *bootstrapv1.K0sControllerConfig) (string, error) {
+	var machines clusterv1.MachineList
+	err := c.List(ctx, &machines, client.InNamespace(config.Namespace), client.HasLabels{clusterv1.MachineControlPlaneLabel})
+	if err != nil {
+		return "", fmt.Errorf("error listing control plane machines: %w", err)
+	}
+
+	for _, m := range collections.FromMachineList(&machines).SortedByCreationTimestamp() {
+		if m.Name == config.Name || m.Spec.ClusterName != scope.Cluster.Name || !m.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if m.Status.BootstrapReady && m.Status.InfrastructureReady {
+			return m.Name, nil
+		}
+	}
+
+	if strings.HasSuffix(config.Name, "-0") {
+		return "", nil
+	}
+
 	// Dirty first controller name generation
 	nameParts := strings.Split(config.Name, "-")
 	nameParts[len(nameParts)-1] = "0"
-	name := strings.Join(nameParts, "-")
+	return strings.Join(nameParts, "-"), nil
+}
+
+func (c *ControlPlaneController) findControllerIP(ctx context.Context, name string, config *bootstrapv1.K0sControllerConfig) (string, error) {
 	machine, machineImpl, err := c.getMachineImplementation(ctx, name, config)
 	if err != nil {
 		return "", fmt.Errorf("error getting machine implementation: %w", err)
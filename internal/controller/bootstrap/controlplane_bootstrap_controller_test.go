@@ -0,0 +1,271 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/cloudinit"
+)
+
+func newControlPlaneMachine(name string, ready bool) *clusterv1.Machine {
+	return &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(time.Now()),
+			Labels:            map[string]string{clusterv1.MachineControlPlaneLabel: "true"},
+		},
+		Spec: clusterv1.MachineSpec{ClusterName: "cp-test"},
+		Status: clusterv1.MachineStatus{
+			BootstrapReady:      ready,
+			InfrastructureReady: ready,
+		},
+	}
+}
+
+func TestControlPlaneController_findJoinMachine(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		machines []client.Object
+		want     string
+	}{
+		{
+			name:   "joining controller",
+			config: "cp-test-2",
+			want:   "cp-test-0",
+		},
+		{
+			name:     "initial controller",
+			config:   "cp-test-0",
+			machines: []client.Object{newControlPlaneMachine("cp-test-0", false), newControlPlaneMachine("cp-test-1", false)},
+			want:     "",
+		},
+		{
+			name:     "joining running controller",
+			config:   "cp-test-3",
+			machines: []client.Object{newControlPlaneMachine("cp-test-1", true), newControlPlaneMachine("cp-test-2", true)},
+			want:     "cp-test-1",
+		},
+		{
+			name:     "replaced initial controller",
+			config:   "cp-test-0",
+			machines: []client.Object{newControlPlaneMachine("cp-test-0", false), newControlPlaneMachine("cp-test-1", true)},
+			want:     "cp-test-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, clusterv1.AddToScheme(scheme))
+			c := &ControlPlaneController{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.machines...).Build(),
+			}
+
+			scope := &Scope{Cluster: &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "cp-test", Namespace: "default"}}}
+			config := &bootstrapv1.K0sControllerConfig{ObjectMeta: metav1.ObjectMeta{Name: tt.config, Namespace: "default"}}
+
+			got, err := c.findJoinMachine(context.Background(), scope, config)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestControlPlaneController_genExternalEtcdFiles(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	c := &ControlPlaneController{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "etcd-ca", Namespace: "default"},
+				Data:       map[string][]byte{"ca.crt": []byte("ca")},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "etcd-client", Namespace: "default"},
+				Data:       map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("key")},
+			},
+		).Build(),
+	}
+	scope := &Scope{Cluster: &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "cp-test", Namespace: "default"}}}
+
+	t.Run("without tls", func(t *testing.T) {
+		config := &bootstrapv1.K0sControllerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cp-test-0", Namespace: "default"},
+			Spec: bootstrapv1.K0sControllerConfigSpec{K0sConfigSpec: &bootstrapv1.K0sConfigSpec{
+				ExternalEtcd: &bootstrapv1.ExternalEtcd{Endpoints: []string{"http://etcd:2379"}},
+			}},
+		}
+
+		files, err := c.genExternalEtcdFiles(context.Background(), scope, config)
+		require.NoError(t, err)
+		require.Empty(t, files)
+
+		externalCluster, _, err := unstructured.NestedMap(config.Spec.K0s.Object, "spec", "storage", "etcd", "externalCluster")
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"endpoints":  []interface{}{"http://etcd:2379"},
+			"etcdPrefix": "cp-test",
+		}, externalCluster)
+	})
+
+	t.Run("with tls", func(t *testing.T) {
+		config := &bootstrapv1.K0sControllerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cp-test-0", Namespace: "default"},
+			Spec: bootstrapv1.K0sControllerConfigSpec{K0sConfigSpec: &bootstrapv1.K0sConfigSpec{
+				K0s: &unstructured.Unstructured{Object: map[string]interface{}{
+					"spec": map[string]interface{}{"api": map[string]interface{}{"port": int64(6443)}},
+				}},
+				ExternalEtcd: &bootstrapv1.ExternalEtcd{
+					Endpoints:           []string{"https://etcd:2379"},
+					EtcdPrefix:          "my-prefix",
+					CASecretRef:         &bootstrapv1.SecretRef{Name: "etcd-ca"},
+					ClientCertSecretRef: &bootstrapv1.SecretRef{Name: "etcd-client"},
+				},
+			}},
+		}
+
+		files, err := c.genExternalEtcdFiles(context.Background(), scope, config)
+		require.NoError(t, err)
+		require.Equal(t, []cloudinit.File{
+			{Path: "/etc/k0s/external-etcd/ca.crt", Permissions: "0600", Content: "ca"},
+			{Path: "/etc/k0s/external-etcd/tls.crt", Permissions: "0600", Content: "cert"},
+			{Path: "/etc/k0s/external-etcd/tls.key", Permissions: "0600", Content: "key"},
+		}, files)
+
+		externalCluster, _, err := unstructured.NestedMap(config.Spec.K0s.Object, "spec", "storage", "etcd", "externalCluster")
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"endpoints":      []interface{}{"https://etcd:2379"},
+			"etcdPrefix":     "my-prefix",
+			"caFile":         "/etc/k0s/external-etcd/ca.crt",
+			"clientCertFile": "/etc/k0s/external-etcd/tls.crt",
+			"clientKeyFile":  "/etc/k0s/external-etcd/tls.key",
+		}, externalCluster)
+		port, _, _ := unstructured.NestedInt64(config.Spec.K0s.Object, "spec", "api", "port")
+		require.Equal(t, int64(6443), port)
+	})
+
+	t.Run("ca without client certificate", func(t *testing.T) {
+		config := &bootstrapv1.K0sControllerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "cp-test-0", Namespace: "default"},
+			Spec: bootstrapv1.K0sControllerConfigSpec{K0sConfigSpec: &bootstrapv1.K0sConfigSpec{
+				ExternalEtcd: &bootstrapv1.ExternalEtcd{
+					Endpoints:   []string{"https://etcd:2379"},
+					CASecretRef: &bootstrapv1.SecretRef{Name: "etcd-ca"},
+				},
+			}},
+		}
+
+		_, err := c.genExternalEtcdFiles(context.Background(), scope, config)
+		require.Error(t, err)
+	})
+}
+
+func Test_createCPInstallCmd(t *testing.T) {
+	base := "k0s install controller --force --enable-dynamic-config --env AUTOPILOT_HOSTNAME=cp-0"
+
+	tests := []struct {
+		name string
+		spec *bootstrapv1.K0sConfigSpec
+		vip  *bootstrapv1.VIP
+		want string
+	}{
+		{
+			name: "with default config",
+			spec: &bootstrapv1.K0sConfigSpec{},
+			want: base + " --kubelet-extra-args=--hostname-override=cp-0",
+		},
+		{
+			name: "with worker",
+			spec: &bootstrapv1.K0sConfigSpec{
+				Args: []string{"--debug"},
+				Worker: &bootstrapv1.ControllerWorker{
+					NoTaints:   true,
+					NodeLabels: map[string]string{"pool": "control-plane"},
+					Taints: []corev1.Taint{
+						{Key: "dedicated", Value: "infra", Effect: corev1.TaintEffectNoSchedule},
+					},
+					KubeletExtraArgs: map[string]string{"max-pods": "50"},
+					Profile:          "custom",
+				},
+			},
+			want: base + " --kubelet-extra-args='--hostname-override=cp-0 --max-pods=50' --enable-worker --no-taints --profile=custom --labels=pool=control-plane --taints=dedicated=infra:NoSchedule --debug",
+		},
+		{
+			name: "with worker enabled in args",
+			spec: &bootstrapv1.K0sConfigSpec{
+				Args:   []string{"--enable-worker"},
+				Worker: &bootstrapv1.ControllerWorker{NoTaints: true},
+			},
+			want: base + " --kubelet-extra-args=--hostname-override=cp-0 --no-taints --enable-worker",
+		},
+		{
+			name: "with worker in single node mode",
+			spec: &bootstrapv1.K0sConfigSpec{
+				Args:   []string{"--single"},
+				Worker: &bootstrapv1.ControllerWorker{Profile: "custom"},
+			},
+			want: base + " --kubelet-extra-args=--hostname-override=cp-0 --profile=custom --single",
+		},
+		{
+			name: "with vip",
+			spec: &bootstrapv1.K0sConfigSpec{},
+			vip:  &bootstrapv1.VIP{Address: "192.168.1.100"},
+			want: base + " --kubelet-extra-args='--hostname-override=cp-0 --pod-manifest-path=/etc/k0smotron/manifests' --enable-worker",
+		},
+		{
+			name: "with vip and worker",
+			spec: &bootstrapv1.K0sConfigSpec{Worker: &bootstrapv1.ControllerWorker{NoTaints: true}},
+			vip:  &bootstrapv1.VIP{Address: "192.168.1.100"},
+			want: base + " --kubelet-extra-args='--hostname-override=cp-0 --pod-manifest-path=/etc/k0smotron/manifests' --enable-worker --no-taints",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &bootstrapv1.K0sControllerConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "cp-0"},
+				Spec:       bootstrapv1.K0sControllerConfigSpec{VIP: tt.vip, K0sConfigSpec: tt.spec},
+			}
+			require.Equal(t, tt.want, createCPInstallCmd(config))
+		})
+	}
+}
+
+func Test_createCPInstallCmdWithJoinToken(t *testing.T) {
+	config := &bootstrapv1.K0sControllerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cp-1"},
+		Spec: bootstrapv1.K0sControllerConfigSpec{K0sConfigSpec: &bootstrapv1.K0sConfigSpec{
+			Worker: &bootstrapv1.ControllerWorker{NoTaints: true},
+		}},
+	}
+
+	require.Equal(t, "k0s install controller --force --enable-dynamic-config --env AUTOPILOT_HOSTNAME=cp-1 --kubelet-extra-args=--hostname-override=cp-1 --enable-worker --no-taints --token-file /etc/k0s.token", createCPInstallCmdWithJoinToken(config, "/etc/k0s.token"))
+}
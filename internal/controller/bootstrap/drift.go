@@ -0,0 +1,182 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	expv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+)
+
+const (
+	// configGenerationAnnotation is set on the bootstrap secret to the generation of the config it was generated from.
+	configGenerationAnnotation = "k0smotron.io/config-generation"
+	// joinTokenExpirationAnnotation is set on the bootstrap secret to the expiration of the join token generated by k0smotron.
+	joinTokenExpirationAnnotation = "k0smotron.io/join-token-expiration"
+	// joinTokenHashAnnotation is set on the bootstrap secret to the hash of the join token read from the join token secret.
+	joinTokenHashAnnotation = "k0smotron.io/join-token-hash"
+
+	// joinTokenRenewBefore is how long before the expiration the join token of a machine that has not joined yet is renewed.
+	joinTokenRenewBefore = time.Hour
+
+	// configJoinTokenSecretField is the field index of the join token secret of a K0sWorkerConfig.
+	configJoinTokenSecretField = "spec.joinTokenSecretRef.name"
+)
+
+// bootstrapDataState tells whether the bootstrap data of the config must be regenerated.
+type bootstrapDataState struct {
+	upToDate bool
+	// specChanged is set if the data is outdated because the spec of the config changed.
+	specChanged bool
+	// renewAfter is the time after which the join token must be renewed, if the machine has not joined yet.
+	renewAfter time.Duration
+}
+
+// checkBootstrapData checks whether the bootstrap secret is up-to-date with the config and the join token.
+// The machine is nil if the config is owned by a MachinePool.
+func (r *Controller) checkBootstrapData(ctx context.Context, config *bootstrapv1.K0sWorkerConfig, machine *clusterv1.Machine) (bootstrapDataState, error) {
+	if !config.Status.Ready || config.Status.DataSecretName == nil {
+		return bootstrapDataState{}, nil
+	}
+
+	var s corev1.Secret
+	if err := r.Get(ctx, client.ObjectKey{Name: *config.Status.DataSecretName, Namespace: config.Namespace}, &s); err != nil {
+		if apierrors.IsNotFound(err) {
+			return bootstrapDataState{}, nil
+		}
+		return bootstrapDataState{}, fmt.Errorf("failed to get bootstrap secret: %w", err)
+	}
+
+	if s.Annotations[configGenerationAnnotation] != strconv.FormatInt(config.Generation, 10) {
+		return bootstrapDataState{specChanged: true}, nil
+	}
+
+	if config.Spec.JoinTokenSecretRef != nil {
+		token, err := r.getJoinTokenFromSecret(ctx, config)
+		if err != nil {
+			return bootstrapDataState{}, err
+		}
+		if s.Annotations[joinTokenHashAnnotation] != joinTokenHash(token) {
+			return bootstrapDataState{}, nil
+		}
+		return bootstrapDataState{upToDate: true}, nil
+	}
+
+	// The token of a machine that has joined the cluster is not used anymore. The token of a machine pool is kept
+	// valid, as new machines can join the pool at any time.
+	if machine != nil && machine.Status.NodeRef != nil {
+		return bootstrapDataState{upToDate: true}, nil
+	}
+	expiration, err := time.Parse(time.RFC3339, s.Annotations[joinTokenExpirationAnnotation])
+	if err != nil {
+		// The secret was created before the expiration was tracked
+		return bootstrapDataState{upToDate: true}, nil
+	}
+	renewAfter := time.Until(expiration.Add(-joinTokenRenewBefore))
+	if renewAfter <= 0 {
+		return bootstrapDataState{}, nil
+	}
+	return bootstrapDataState{upToDate: true, renewAfter: renewAfter}, nil
+}
+
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines,verbs=patch
+
+// markMachineOutdated marks the machine that was bootstrapped with the previous bootstrap data for a rollout.
+func (r *Controller) markMachineOutdated(ctx context.Context, machine *clusterv1.Machine) error {
+	if machine.Name == "" || machine.Status.NodeRef == nil {
+		return nil
+	}
+	if _, ok := machine.Annotations[bootstrapv1.BootstrapDataOutdatedAnnotation]; ok {
+		return nil
+	}
+
+	patch := client.MergeFrom(machine.DeepCopy())
+	if machine.Annotations == nil {
+		machine.Annotations = map[string]string{}
+	}
+	machine.Annotations[bootstrapv1.BootstrapDataOutdatedAnnotation] = "true"
+	if err := r.Patch(ctx, machine, patch); err != nil {
+		return fmt.Errorf("failed to mark machine %s outdated: %w", machine.Name, err)
+	}
+	return nil
+}
+
+func (r *Controller) getJoinTokenFromSecret(ctx context.Context, config *bootstrapv1.K0sWorkerConfig) (string, error) {
+	ref := config.Spec.JoinTokenSecretRef
+	var s corev1.Secret
+	if err := r.Get(ctx, client.ObjectKey{Name: ref.Name, Namespace: config.Namespace}, &s); err != nil {
+		return "", fmt.Errorf("failed to get join token secret: %w", err)
+	}
+	token, ok := s.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in join token secret %s", ref.Key, ref.Name)
+	}
+	return string(token), nil
+}
+
+func joinTokenHash(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// machinePoolToBootstrapConfig maps the MachinePool to the K0sWorkerConfig referenced by its machine template.
+func machinePoolToBootstrapConfig(_ context.Context, obj client.Object) []reconcile.Request {
+	mp, ok := obj.(*expv1.MachinePool)
+	if !ok {
+		return nil
+	}
+
+	configRef := mp.Spec.Template.Spec.Bootstrap.ConfigRef
+	if configRef == nil || configRef.GroupVersionKind().GroupKind() != bootstrapv1.GroupVersion.WithKind("K0sWorkerConfig").GroupKind() {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: configRef.Name, Namespace: mp.Namespace}}}
+}
+
+// requestsForJoinTokenSecret maps the join token secret to the configs referencing it, so the bootstrap data
+// is regenerated when the token is rotated.
+func (r *Controller) requestsForJoinTokenSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	var configs bootstrapv1.K0sWorkerConfigList
+	if err := r.List(ctx, &configs, client.InNamespace(obj.GetNamespace()), client.MatchingFields{configJoinTokenSecretField: obj.GetName()}); err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, config := range configs.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&config)})
+	}
+	return requests
+}
+
+// indexConfigJoinTokenSecret indexes the configs by the name of the join token secret they reference.
+func indexConfigJoinTokenSecret(obj client.Object) []string {
+	config, ok := obj.(*bootstrapv1.K0sWorkerConfig)
+	if !ok || config.Spec.JoinTokenSecretRef == nil {
+		return nil
+	}
+	return []string{config.Spec.JoinTokenSecretRef.Name}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	expv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+)
+
+func newDriftTestClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, clusterv1.AddToScheme(scheme))
+	require.NoError(t, bootstrapv1.AddToScheme(scheme))
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithIndex(&bootstrapv1.K0sWorkerConfig{}, configJoinTokenSecretField, indexConfigJoinTokenSecret).
+		Build()
+}
+
+func TestController_checkBootstrapData(t *testing.T) {
+	readyConfig := func(spec bootstrapv1.K0sWorkerConfigSpec) *bootstrapv1.K0sWorkerConfig {
+		return &bootstrapv1.K0sWorkerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default", Generation: 2},
+			Spec:       spec,
+			Status:     bootstrapv1.K0sWorkerConfigStatus{Ready: true, DataSecretName: ptr.To("worker")},
+		}
+	}
+	bootstrapSecret := func(annotations map[string]string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default", Annotations: annotations}}
+	}
+	joinTokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "join-token", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("rotated")},
+	}
+	joinTokenRef := bootstrapv1.K0sWorkerConfigSpec{JoinTokenSecretRef: &bootstrapv1.JoinTokenSecretRef{Name: "join-token", Key: "token"}}
+	expiresIn := func(d time.Duration) string {
+		return time.Now().Add(d).Format(time.RFC3339)
+	}
+
+	tests := []struct {
+		name        string
+		config      *bootstrapv1.K0sWorkerConfig
+		machine     *clusterv1.Machine
+		objs        []client.Object
+		upToDate    bool
+		specChanged bool
+		renew       bool
+	}{
+		{
+			name:    "not ready",
+			config:  &bootstrapv1.K0sWorkerConfig{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default"}},
+			machine: &clusterv1.Machine{},
+		},
+		{
+			name:    "secret missing",
+			config:  readyConfig(bootstrapv1.K0sWorkerConfigSpec{}),
+			machine: &clusterv1.Machine{},
+		},
+		{
+			name:        "spec changed",
+			config:      readyConfig(bootstrapv1.K0sWorkerConfigSpec{}),
+			machine:     &clusterv1.Machine{},
+			objs:        []client.Object{bootstrapSecret(map[string]string{configGenerationAnnotation: "1"})},
+			specChanged: true,
+		},
+		{
+			name:     "token valid",
+			config:   readyConfig(bootstrapv1.K0sWorkerConfigSpec{}),
+			machine:  &clusterv1.Machine{},
+			objs:     []client.Object{bootstrapSecret(map[string]string{configGenerationAnnotation: "2", joinTokenExpirationAnnotation: expiresIn(10 * time.Hour)})},
+			upToDate: true,
+			renew:    true,
+		},
+		{
+			name:    "token expiring",
+			config:  readyConfig(bootstrapv1.K0sWorkerConfigSpec{}),
+			machine: &clusterv1.Machine{},
+			objs:    []client.Object{bootstrapSecret(map[string]string{configGenerationAnnotation: "2", joinTokenExpirationAnnotation: expiresIn(30 * time.Minute)})},
+		},
+		{
+			name:     "token expiring for joined machine",
+			config:   readyConfig(bootstrapv1.K0sWorkerConfigSpec{}),
+			machine:  &clusterv1.Machine{Status: clusterv1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node"}}},
+			objs:     []client.Object{bootstrapSecret(map[string]string{configGenerationAnnotation: "2", joinTokenExpirationAnnotation: expiresIn(30 * time.Minute)})},
+			upToDate: true,
+		},
+		{
+			name:     "token valid for machine pool",
+			config:   readyConfig(bootstrapv1.K0sWorkerConfigSpec{}),
+			objs:     []client.Object{bootstrapSecret(map[string]string{configGenerationAnnotation: "2", joinTokenExpirationAnnotation: expiresIn(10 * time.Hour)})},
+			upToDate: true,
+			renew:    true,
+		},
+		{
+			name:   "token expiring for machine pool",
+			config: readyConfig(bootstrapv1.K0sWorkerConfigSpec{}),
+			objs:   []client.Object{bootstrapSecret(map[string]string{configGenerationAnnotation: "2", joinTokenExpirationAnnotation: expiresIn(30 * time.Minute)})},
+		},
+		{
+			name:     "join token secret unchanged",
+			config:   readyConfig(joinTokenRef),
+			machine:  &clusterv1.Machine{},
+			objs:     []client.Object{joinTokenSecret, bootstrapSecret(map[string]string{configGenerationAnnotation: "2", joinTokenHashAnnotation: joinTokenHash("rotated")})},
+			upToDate: true,
+		},
+		{
+			name:    "join token secret rotated",
+			config:  readyConfig(joinTokenRef),
+			machine: &clusterv1.Machine{},
+			objs:    []client.Object{joinTokenSecret, bootstrapSecret(map[string]string{configGenerationAnnotation: "2", joinTokenHashAnnotation: joinTokenHash("original")})},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Controller{Client: newDriftTestClient(t, tt.objs...)}
+			state, err := r.checkBootstrapData(context.Background(), tt.config, tt.machine)
+			require.NoError(t, err)
+			require.Equal(t, tt.upToDate, state.upToDate)
+			require.Equal(t, tt.specChanged, state.specChanged)
+			require.Equal(t, tt.renew, state.renewAfter > 0)
+		})
+	}
+}
+
+func TestController_markMachineOutdated(t *testing.T) {
+	joined := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "joined", Namespace: "default"},
+		Status:     clusterv1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node"}},
+	}
+	pending := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default"}}
+	c := newDriftTestClient(t, joined, pending)
+	r := &Controller{Client: c}
+
+	require.NoError(t, r.markMachineOutdated(context.Background(), joined))
+	require.NoError(t, r.markMachineOutdated(context.Background(), pending))
+
+	var m clusterv1.Machine
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(joined), &m))
+	require.Equal(t, "true", m.Annotations[bootstrapv1.BootstrapDataOutdatedAnnotation])
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(pending), &m))
+	require.NotContains(t, m.Annotations, bootstrapv1.BootstrapDataOutdatedAnnotation)
+}
+
+func TestController_requestsForJoinTokenSecret(t *testing.T) {
+	c := newDriftTestClient(t,
+		&bootstrapv1.K0sWorkerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "with-ref", Namespace: "default"},
+			Spec:       bootstrapv1.K0sWorkerConfigSpec{JoinTokenSecretRef: &bootstrapv1.JoinTokenSecretRef{Name: "join-token", Key: "token"}},
+		},
+		&bootstrapv1.K0sWorkerConfig{ObjectMeta: metav1.ObjectMeta{Name: "without-ref", Namespace: "default"}},
+	)
+	r := &Controller{Client: c}
+
+	requests := r.requestsForJoinTokenSecret(context.Background(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "join-token", Namespace: "default"}})
+	require.Len(t, requests, 1)
+	require.Equal(t, "with-ref", requests[0].Name)
+}
+
+func Test_machinePoolToBootstrapConfig(t *testing.T) {
+	machinePool := func(configRef *corev1.ObjectReference) *expv1.MachinePool {
+		return &expv1.MachinePool{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool", Namespace: "default"},
+			Spec: expv1.MachinePoolSpec{Template: clusterv1.MachineTemplateSpec{Spec: clusterv1.MachineSpec{
+				Bootstrap: clusterv1.Bootstrap{ConfigRef: configRef},
+			}}},
+		}
+	}
+
+	requests := machinePoolToBootstrapConfig(context.Background(), machinePool(&corev1.ObjectReference{
+		APIVersion: bootstrapv1.GroupVersion.String(),
+		Kind:       "K0sWorkerConfig",
+		Name:       "pool-config",
+	}))
+	require.Len(t, requests, 1)
+	require.Equal(t, client.ObjectKey{Name: "pool-config", Namespace: "default"}, requests[0].NamespacedName)
+
+	require.Empty(t, machinePoolToBootstrapConfig(context.Background(), machinePool(&corev1.ObjectReference{
+		APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1",
+		Kind:       "KubeadmConfig",
+		Name:       "pool-config",
+	})))
+	require.Empty(t, machinePoolToBootstrapConfig(context.Background(), machinePool(nil)))
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/cloudinit"
+)
+
+// resolveFiles returns the files to be written on the machine, with the content of the files using contentFrom
+// read from the referenced Secrets and ConfigMaps.
+func resolveFiles(ctx context.Context, c client.Reader, namespace string, files []bootstrapv1.File) ([]cloudinit.File, error) {
+	resolved := make([]cloudinit.File, 0, len(files))
+	for _, f := range files {
+		file := f.File
+		if f.ContentFrom != nil {
+			content, err := resolveFileContent(ctx, c, namespace, f.ContentFrom)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve content of file %s: %w", f.Path, err)
+			}
+			file.Content = content
+		}
+		resolved = append(resolved, file)
+	}
+
+	return resolved, nil
+}
+
+// resolveAdditionalUserData returns the additional user data, read from the referenced Secret or ConfigMap if
+// contentFrom is set.
+func resolveAdditionalUserData(ctx context.Context, c client.Reader, namespace string, userData *bootstrapv1.AdditionalUserData) ([]byte, error) {
+	if userData == nil {
+		return nil, nil
+	}
+	if userData.ContentFrom == nil {
+		return []byte(userData.Content), nil
+	}
+
+	content, err := resolveFileContent(ctx, c, namespace, userData.ContentFrom)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve additional user data: %w", err)
+	}
+	return []byte(content), nil
+}
+
+func resolveFileContent(ctx context.Context, c client.Reader, namespace string, source *bootstrapv1.ContentSource) (string, error) {
+	switch {
+	case source.SecretRef != nil && source.ConfigMapRef != nil:
+		return "", fmt.Errorf("only one of secretRef and configMapRef can be set")
+	case source.SecretRef != nil:
+		var s corev1.Secret
+		if err := c.Get(ctx, client.ObjectKey{Name: source.SecretRef.Name, Namespace: namespace}, &s); err != nil {
+			return "", fmt.Errorf("failed to get secret %s: %w", source.SecretRef.Name, err)
+		}
+		content, ok := s.Data[source.SecretRef.Key]
+		if !ok {
+			return "", fmt.Errorf("key %s not found in secret %s", source.SecretRef.Key, source.SecretRef.Name)
+		}
+		return string(content), nil
+	case source.ConfigMapRef != nil:
+		var cm corev1.ConfigMap
+		if err := c.Get(ctx, client.ObjectKey{Name: source.ConfigMapRef.Name, Namespace: namespace}, &cm); err != nil {
+			return "", fmt.Errorf("failed to get config map %s: %w", source.ConfigMapRef.Name, err)
+		}
+		if content, ok := cm.Data[source.ConfigMapRef.Key]; ok {
+			return content, nil
+		}
+		if content, ok := cm.BinaryData[source.ConfigMapRef.Key]; ok {
+			return string(content), nil
+		}
+		return "", fmt.Errorf("key %s not found in config map %s", source.ConfigMapRef.Key, source.ConfigMapRef.Name)
+	default:
+		return "", fmt.Errorf("one of secretRef and configMapRef must be set")
+	}
+}
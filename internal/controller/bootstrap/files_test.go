@@ -0,0 +1,146 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/cloudinit"
+)
+
+func Test_resolveFiles(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default"},
+			Data:       map[string][]byte{"config.json": []byte(`{"auths":{}}`)},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "default"},
+			Data:       map[string]string{"ca.crt": "ca"},
+			BinaryData: map[string][]byte{"ca.der": []byte("der")},
+		},
+	).Build()
+
+	tests := []struct {
+		name    string
+		files   []bootstrapv1.File
+		want    []cloudinit.File
+		wantErr bool
+	}{
+		{
+			name:  "inline content",
+			files: []bootstrapv1.File{{File: cloudinit.File{Path: "/etc/foo", Content: "foo", Permissions: "0644"}}},
+			want:  []cloudinit.File{{Path: "/etc/foo", Content: "foo", Permissions: "0644"}},
+		},
+		{
+			name: "content from secret",
+			files: []bootstrapv1.File{{
+				File:        cloudinit.File{Path: "/root/.docker/config.json", Permissions: "0600"},
+				ContentFrom: &bootstrapv1.ContentSource{SecretRef: &bootstrapv1.ContentSourceRef{Name: "registry", Key: "config.json"}},
+			}},
+			want: []cloudinit.File{{Path: "/root/.docker/config.json", Content: `{"auths":{}}`, Permissions: "0600"}},
+		},
+		{
+			name: "content from config map",
+			files: []bootstrapv1.File{
+				{
+					File:        cloudinit.File{Path: "/etc/ssl/ca.crt", Content: "ignored"},
+					ContentFrom: &bootstrapv1.ContentSource{ConfigMapRef: &bootstrapv1.ContentSourceRef{Name: "ca", Key: "ca.crt"}},
+				},
+				{
+					File:        cloudinit.File{Path: "/etc/ssl/ca.der"},
+					ContentFrom: &bootstrapv1.ContentSource{ConfigMapRef: &bootstrapv1.ContentSourceRef{Name: "ca", Key: "ca.der"}},
+				},
+			},
+			want: []cloudinit.File{{Path: "/etc/ssl/ca.crt", Content: "ca"}, {Path: "/etc/ssl/ca.der", Content: "der"}},
+		},
+		{
+			name: "missing key",
+			files: []bootstrapv1.File{{
+				File:        cloudinit.File{Path: "/etc/foo"},
+				ContentFrom: &bootstrapv1.ContentSource{SecretRef: &bootstrapv1.ContentSourceRef{Name: "registry", Key: "missing"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "missing secret",
+			files: []bootstrapv1.File{{
+				File:        cloudinit.File{Path: "/etc/foo"},
+				ContentFrom: &bootstrapv1.ContentSource{SecretRef: &bootstrapv1.ContentSourceRef{Name: "missing", Key: "key"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "both references",
+			files: []bootstrapv1.File{{
+				File: cloudinit.File{Path: "/etc/foo"},
+				ContentFrom: &bootstrapv1.ContentSource{
+					SecretRef:    &bootstrapv1.ContentSourceRef{Name: "registry", Key: "config.json"},
+					ConfigMapRef: &bootstrapv1.ContentSourceRef{Name: "ca", Key: "ca.crt"},
+				},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveFiles(context.Background(), c, "default", tt.files)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_resolveAdditionalUserData(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "user-data", Namespace: "default"},
+			Data:       map[string][]byte{"value": []byte("#cloud-config\npackages: [htop]\n")},
+		},
+	).Build()
+
+	userData, err := resolveAdditionalUserData(context.Background(), c, "default", nil)
+	require.NoError(t, err)
+	require.Nil(t, userData)
+
+	userData, err = resolveAdditionalUserData(context.Background(), c, "default", &bootstrapv1.AdditionalUserData{Content: "#!/bin/sh"})
+	require.NoError(t, err)
+	require.Equal(t, "#!/bin/sh", string(userData))
+
+	userData, err = resolveAdditionalUserData(context.Background(), c, "default", &bootstrapv1.AdditionalUserData{
+		ContentFrom: &bootstrapv1.ContentSource{SecretRef: &bootstrapv1.ContentSourceRef{Name: "user-data", Key: "value"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "#cloud-config\npackages: [htop]\n", string(userData))
+}
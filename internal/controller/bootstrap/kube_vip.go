@@ -0,0 +1,110 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"fmt"
+	"path/filepath"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/cloudinit"
+)
+
+const (
+	// kubeVIPManifestDir is the static pod directory of the kubelet running on the controllers with a VIP.
+	kubeVIPManifestDir = "/etc/k0smotron/manifests"
+	// defaultKubeVIPImage is the kube-vip image used if the VIP has no image, matching the CRD default.
+	defaultKubeVIPImage = "ghcr.io/kube-vip/kube-vip:v0.7.2"
+)
+
+// kubeVIPManifest is the kube-vip static pod announcing the VIP of the control plane with ARP. kube-vip talks to the
+// local API server with the admin kubeconfig of k0s, so it doesn't depend on the VIP itself.
+const kubeVIPManifest = `apiVersion: v1
+kind: Pod
+metadata:
+  name: kube-vip
+  namespace: kube-system
+spec:
+  containers:
+  - name: kube-vip
+    image: %s
+    imagePullPolicy: IfNotPresent
+    args:
+    - manager
+    env:
+    - name: vip_arp
+      value: "true"
+    - name: port
+      value: "%d"%s
+    - name: vip_cidr
+      value: "32"
+    - name: cp_enable
+      value: "true"
+    - name: cp_namespace
+      value: kube-system
+    - name: vip_ddns
+      value: "false"
+    - name: vip_leaderelection
+      value: "true"
+    - name: vip_leasename
+      value: plndr-cp-lock
+    - name: vip_leaseduration
+      value: "15"
+    - name: vip_renewdeadline
+      value: "10"
+    - name: vip_retryperiod
+      value: "2"
+    - name: address
+      value: %s
+    securityContext:
+      capabilities:
+        add:
+        - NET_ADMIN
+        - NET_RAW
+    volumeMounts:
+    - mountPath: /etc/kubernetes/admin.conf
+      name: kubeconfig
+  hostAliases:
+  - hostnames:
+    - kubernetes
+    ip: 127.0.0.1
+  hostNetwork: true
+  tolerations:
+  - operator: Exists
+  volumes:
+  - hostPath:
+      path: /var/lib/k0s/pki/admin.conf
+    name: kubeconfig
+`
+
+// kubeVIPFiles returns the kube-vip static pod of a controller announcing the VIP on the given API port.
+func kubeVIPFiles(vip *bootstrapv1.VIP, port int32) []cloudinit.File {
+	image := vip.Image
+	if image == "" {
+		image = defaultKubeVIPImage
+	}
+	var interfaceEnv string
+	if vip.Interface != "" {
+		interfaceEnv = fmt.Sprintf("\n    - name: vip_interface\n      value: %s", vip.Interface)
+	}
+
+	return []cloudinit.File{{
+		Path:        filepath.Join(kubeVIPManifestDir, "kube-vip.yaml"),
+		Permissions: "0644",
+		Content:     fmt.Sprintf(kubeVIPManifest, image, port, interfaceEnv, vip.Address),
+	}}
+}
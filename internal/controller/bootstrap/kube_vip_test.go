@@ -0,0 +1,51 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+)
+
+func Test_kubeVIPFiles(t *testing.T) {
+	files := kubeVIPFiles(&bootstrapv1.VIP{Address: "192.168.1.100", Interface: "eth0"}, 7443)
+	require.Len(t, files, 1)
+	require.Equal(t, "/etc/k0smotron/manifests/kube-vip.yaml", files[0].Path)
+
+	var pod corev1.Pod
+	require.NoError(t, yaml.UnmarshalStrict([]byte(files[0].Content), &pod))
+	require.True(t, pod.Spec.HostNetwork)
+	require.Len(t, pod.Spec.Containers, 1)
+	require.Equal(t, defaultKubeVIPImage, pod.Spec.Containers[0].Image)
+	env := map[string]string{}
+	for _, e := range pod.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	require.Equal(t, "192.168.1.100", env["address"])
+	require.Equal(t, "7443", env["port"])
+	require.Equal(t, "eth0", env["vip_interface"])
+
+	files = kubeVIPFiles(&bootstrapv1.VIP{Address: "192.168.1.100", Image: "registry.example.com/kube-vip:v0.7.2"}, 6443)
+	require.NoError(t, yaml.UnmarshalStrict([]byte(files[0].Content), &pod))
+	require.Equal(t, "registry.example.com/kube-vip:v0.7.2", pod.Spec.Containers[0].Image)
+	require.NotContains(t, files[0].Content, "vip_interface")
+}
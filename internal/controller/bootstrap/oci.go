@@ -0,0 +1,179 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/cloudinit"
+)
+
+const (
+	ociPullScriptPath      = "/etc/k0smotron/oci-pull.sh"
+	ociCredentialsFilePath = "/etc/k0smotron/oci-credentials"
+)
+
+// ociPullScript downloads the first layer of an OCI artifact using the registry HTTP API, so no additional tools
+// are needed on the machine. The registries using token authentication are supported. The digest of the layer
+// is verified.
+const ociPullScript = `#!/bin/sh
+# Usage: oci-pull.sh <registry> <repository> <reference> <output>
+set -eu
+registry="$1"
+repository="$2"
+reference="$3"
+output="$4"
+
+auth=""
+if [ -f ` + ociCredentialsFilePath + ` ]; then
+  auth="Basic $(cat ` + ociCredentialsFilePath + `)"
+fi
+
+request() {
+  if [ -n "$auth" ]; then
+    curl -sSfL -H "Authorization: $auth" -H "Accept: $2" "$1"
+  else
+    curl -sSfL -H "Accept: $2" "$1"
+  fi
+}
+
+# Exchange the credentials for a bearer token if the registry uses token authentication
+challenge=$(curl -sS -o /dev/null -D - "https://$registry/v2/" | tr -d '\r' | sed -n 's/^[Ww][Ww][Ww]-[Aa]uthenticate: Bearer //p')
+if [ -n "$challenge" ]; then
+  realm=$(echo "$challenge" | sed -n 's/.*realm="\([^"]*\)".*/\1/p')
+  service=$(echo "$challenge" | sed -n 's/.*service="\([^"]*\)".*/\1/p')
+  token=$(request "$realm?service=$service&scope=repository:$repository:pull" application/json | tr -d ' \n' | sed -n 's/.*"\(access_\)\{0,1\}token":"\([^"]*\)".*/\2/p')
+  auth="Bearer $token"
+fi
+
+manifest=$(request "https://$registry/v2/$repository/manifests/$reference" "application/vnd.oci.image.manifest.v1+json,application/vnd.docker.distribution.manifest.v2+json")
+digest=$(echo "$manifest" | tr -d ' \n' | sed -n 's/.*"layers":\[{[^}]*"digest":"\([^"]*\)".*/\1/p')
+if [ -z "$digest" ]; then
+  echo "no layer found in $registry/$repository:$reference" >&2
+  exit 1
+fi
+
+request "https://$registry/v2/$repository/blobs/$digest" application/octet-stream > "$output.download"
+echo "${digest#sha256:}  $output.download" | sha256sum -c -
+mv "$output.download" "$output"
+`
+
+type ociReference struct {
+	registry   string
+	repository string
+	reference  string
+}
+
+// parseOCIReference parses the artifact reference. The registry must be set explicitly.
+func parseOCIReference(ref string) (ociReference, error) {
+	registry, path, ok := strings.Cut(ref, "/")
+	if !ok || path == "" || !(strings.ContainsAny(registry, ".:") || registry == "localhost") {
+		return ociReference{}, fmt.Errorf("invalid OCI artifact reference %s: the registry must be set", ref)
+	}
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+
+	if repository, digest, ok := strings.Cut(path, "@"); ok {
+		return ociReference{registry: registry, repository: repository, reference: digest}, nil
+	}
+	// The tag is separated by the last colon after the last slash
+	if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") {
+		return ociReference{registry: registry, repository: path[:i], reference: path[i+1:]}, nil
+	}
+	return ociReference{registry: registry, repository: path, reference: "latest"}, nil
+}
+
+// ociArtifactFiles returns the files needed to download the k0s binary from the OCI artifact.
+func ociArtifactFiles(ctx context.Context, c client.Reader, namespace string, artifact *bootstrapv1.OCIArtifact) ([]cloudinit.File, error) {
+	if artifact == nil {
+		return nil, nil
+	}
+	ref, err := parseOCIReference(artifact.Reference)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []cloudinit.File{{
+		Path:        ociPullScriptPath,
+		Permissions: "0700",
+		Content:     ociPullScript,
+	}}
+
+	if artifact.PullSecretRef != nil {
+		credentials, err := registryCredentials(ctx, c, namespace, artifact.PullSecretRef.Name, ref.registry)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, cloudinit.File{
+			Path:        ociCredentialsFilePath,
+			Permissions: "0600",
+			Content:     credentials,
+		})
+	}
+
+	return files, nil
+}
+
+// registryCredentials returns the base64 encoded credentials of the registry from the dockerconfigjson secret.
+func registryCredentials(ctx context.Context, c client.Reader, namespace, secretName, registry string) (string, error) {
+	var s corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Name: secretName, Namespace: namespace}, &s); err != nil {
+		return "", fmt.Errorf("failed to get pull secret %s: %w", secretName, err)
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(s.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+		return "", fmt.Errorf("failed to parse pull secret %s: %w", secretName, err)
+	}
+
+	for _, server := range []string{registry, "https://" + registry} {
+		auth, ok := config.Auths[server]
+		if !ok {
+			continue
+		}
+		if auth.Auth != "" {
+			return auth.Auth, nil
+		}
+		return base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password)), nil
+	}
+	return "", fmt.Errorf("no credentials for registry %s found in pull secret %s", registry, secretName)
+}
+
+// createOCIDownloadCommands returns the commands to install the k0s binary from the OCI artifact.
+func createOCIDownloadCommands(artifact *bootstrapv1.OCIArtifact) []string {
+	// The reference is validated when the files are generated
+	ref, _ := parseOCIReference(artifact.Reference)
+	return []string{
+		fmt.Sprintf("%s %s %s %s /usr/local/bin/k0s", ociPullScriptPath, ref.registry, ref.repository, ref.reference),
+		"chmod +x /usr/local/bin/k0s",
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+)
+
+func Test_parseOCIReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    ociReference
+		wantErr bool
+	}{
+		{
+			ref:  "registry.example.com/k0s:v1.28.4-k0s.0",
+			want: ociReference{registry: "registry.example.com", repository: "k0s", reference: "v1.28.4-k0s.0"},
+		},
+		{
+			ref:  "registry.example.com:5000/k0sproject/k0s@sha256:abc",
+			want: ociReference{registry: "registry.example.com:5000", repository: "k0sproject/k0s", reference: "sha256:abc"},
+		},
+		{
+			ref:  "localhost/k0s",
+			want: ociReference{registry: "localhost", repository: "k0s", reference: "latest"},
+		},
+		{
+			ref:  "docker.io/k0sproject/k0s:v1.28.4-k0s.0",
+			want: ociReference{registry: "registry-1.docker.io", repository: "k0sproject/k0s", reference: "v1.28.4-k0s.0"},
+		},
+		{
+			ref:     "k0sproject/k0s:v1.28.4-k0s.0",
+			wantErr: true,
+		},
+		{
+			ref:     "k0s",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := parseOCIReference(tt.ref)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_ociArtifactFiles(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "default"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{
+				"registry.example.com":{"username":"user","password":"pass"},
+				"https://other.example.com":{"auth":"b3RoZXI6cGFzcw=="}}}`)},
+		},
+	).Build()
+
+	files, err := ociArtifactFiles(context.Background(), c, "default", nil)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	files, err = ociArtifactFiles(context.Background(), c, "default", &bootstrapv1.OCIArtifact{Reference: "registry.example.com/k0s:v1.28.4-k0s.0"})
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "/etc/k0smotron/oci-pull.sh", files[0].Path)
+
+	files, err = ociArtifactFiles(context.Background(), c, "default", &bootstrapv1.OCIArtifact{
+		Reference:     "registry.example.com/k0s:v1.28.4-k0s.0",
+		PullSecretRef: &corev1.LocalObjectReference{Name: "pull-secret"},
+	})
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "/etc/k0smotron/oci-credentials", files[1].Path)
+	require.Equal(t, "dXNlcjpwYXNz", files[1].Content)
+
+	files, err = ociArtifactFiles(context.Background(), c, "default", &bootstrapv1.OCIArtifact{
+		Reference:     "other.example.com/k0s:v1.28.4-k0s.0",
+		PullSecretRef: &corev1.LocalObjectReference{Name: "pull-secret"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "b3RoZXI6cGFzcw==", files[1].Content)
+
+	_, err = ociArtifactFiles(context.Background(), c, "default", &bootstrapv1.OCIArtifact{
+		Reference:     "unknown.example.com/k0s:v1.28.4-k0s.0",
+		PullSecretRef: &corev1.LocalObjectReference{Name: "pull-secret"},
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+)
+
+// proxyEnv returns the proxy environment variables of the proxy configuration.
+func proxyEnv(proxy *bootstrapv1.ProxySpec) []string {
+	if proxy == nil {
+		return nil
+	}
+
+	var env []string
+	if proxy.HTTPProxy != "" {
+		env = append(env, "HTTP_PROXY="+proxy.HTTPProxy)
+	}
+	if proxy.HTTPSProxy != "" {
+		env = append(env, "HTTPS_PROXY="+proxy.HTTPSProxy)
+	}
+	if len(proxy.NoProxy) > 0 {
+		env = append(env, "NO_PROXY="+strings.Join(proxy.NoProxy, ","))
+	}
+	return env
+}
+
+// proxyInstallArgs returns the k0s install arguments setting the proxy environment variables to the k0s service.
+// The environment is inherited by the components run by k0s, including containerd.
+func proxyInstallArgs(proxy *bootstrapv1.ProxySpec) []string {
+	var args []string
+	for _, env := range proxyEnv(proxy) {
+		args = append(args, fmt.Sprintf("--env=%s", env))
+	}
+	return args
+}
+
+// withProxyEnv returns the commands with the proxy environment variables exported, so the k0s binary can be
+// downloaded through the proxy. The variables are exported for each command, as the commands may be run in
+// separate shells.
+func withProxyEnv(proxy *bootstrapv1.ProxySpec, commands []string) []string {
+	env := proxyEnv(proxy)
+	if len(env) == 0 {
+		return commands
+	}
+
+	withEnv := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		withEnv = append(withEnv, fmt.Sprintf("export %s && %s", strings.Join(env, " "), cmd))
+	}
+	return withEnv
+}
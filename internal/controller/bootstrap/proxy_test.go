@@ -0,0 +1,35 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+)
+
+func Test_withProxyEnv(t *testing.T) {
+	commands := []string{"curl -sSfL https://get.k0s.sh | sh"}
+
+	require.Equal(t, commands, withProxyEnv(nil, commands))
+	require.Equal(t, commands, withProxyEnv(&bootstrapv1.ProxySpec{}, commands))
+	require.Equal(t, []string{
+		"export HTTPS_PROXY=http://proxy:3128 NO_PROXY=localhost && curl -sSfL https://get.k0s.sh | sh",
+	}, withProxyEnv(&bootstrapv1.ProxySpec{HTTPSProxy: "http://proxy:3128", NoProxy: []string{"localhost"}}, commands))
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/cloudinit"
+)
+
+const (
+	// registriesConfigPath is the directory of the containerd hosts.toml files.
+	registriesConfigPath = "/etc/containerd/certs.d"
+	// registriesContainerdConfig is the containerd configuration imported by k0s, enabling the hosts.toml files.
+	registriesContainerdConfig = "/etc/k0s/containerd.d/k0smotron-registries.toml"
+)
+
+// registryFiles returns the containerd configuration files of the registries.
+func registryFiles(ctx context.Context, c client.Reader, namespace string, registries []bootstrapv1.Registry) ([]cloudinit.File, error) {
+	if len(registries) == 0 {
+		return nil, nil
+	}
+
+	files := []cloudinit.File{{
+		Path:        registriesContainerdConfig,
+		Permissions: "0644",
+		Content: fmt.Sprintf(`version = 2
+
+[plugins."io.containerd.grpc.v1.cri".registry]
+  config_path = %q
+`, registriesConfigPath),
+	}}
+
+	for _, registry := range registries {
+		var authorization string
+		if registry.AuthSecretRef != nil {
+			var s corev1.Secret
+			if err := c.Get(ctx, client.ObjectKey{Name: registry.AuthSecretRef.Name, Namespace: namespace}, &s); err != nil {
+				return nil, fmt.Errorf("failed to get auth secret of registry %s: %w", registry.Host, err)
+			}
+			username, password := s.Data[corev1.BasicAuthUsernameKey], s.Data[corev1.BasicAuthPasswordKey]
+			if len(username) == 0 || len(password) == 0 {
+				return nil, fmt.Errorf("auth secret %s of registry %s must contain the %s and %s keys", registry.AuthSecretRef.Name, registry.Host, corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey)
+			}
+			authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(string(username)+":"+string(password)))
+		}
+
+		permissions := "0644"
+		if authorization != "" {
+			permissions = "0600"
+		}
+		files = append(files, cloudinit.File{
+			Path:        path.Join(registriesConfigPath, registry.Host, "hosts.toml"),
+			Permissions: permissions,
+			Content:     registryHostsToml(registry, authorization),
+		})
+	}
+
+	return files, nil
+}
+
+// registryHostsToml renders the containerd hosts.toml of the registry.
+// See: https://github.com/containerd/containerd/blob/main/docs/hosts.md
+func registryHostsToml(registry bootstrapv1.Registry, authorization string) string {
+	server := "https://" + registry.Host
+	if registry.Host == "docker.io" {
+		server = "https://registry-1.docker.io"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "server = %q\n", server)
+
+	hosts := make([]string, 0, len(registry.Mirrors))
+	for _, mirror := range registry.Mirrors {
+		if !strings.Contains(mirror, "://") {
+			mirror = "https://" + mirror
+		}
+		hosts = append(hosts, mirror)
+	}
+	// The server itself needs a host entry only to configure the TLS verification or the authorization
+	if registry.Insecure || authorization != "" {
+		hosts = append(hosts, server)
+	}
+
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "\n[host.%q]\n", host)
+		fmt.Fprintf(&b, "  capabilities = [\"pull\", \"resolve\"]\n")
+		if registry.Insecure {
+			fmt.Fprintf(&b, "  skip_verify = true\n")
+		}
+		if authorization != "" {
+			fmt.Fprintf(&b, "  [host.%q.header]\n", host)
+			fmt.Fprintf(&b, "    authorization = %q\n", authorization)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+)
+
+func Test_registryFiles(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry-auth", Namespace: "default"},
+			Type:       corev1.SecretTypeBasicAuth,
+			Data:       map[string][]byte{"username": []byte("user"), "password": []byte("pass")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid-auth", Namespace: "default"},
+			Data:       map[string][]byte{"username": []byte("user")},
+		},
+	).Build()
+
+	files, err := registryFiles(context.Background(), c, "default", nil)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	files, err = registryFiles(context.Background(), c, "default", []bootstrapv1.Registry{
+		{
+			Host:    "docker.io",
+			Mirrors: []string{"mirror.example.com", "http://10.0.0.1:5000"},
+		},
+		{
+			Host:          "registry.example.com:5000",
+			Insecure:      true,
+			AuthSecretRef: &bootstrapv1.RegistryAuthSecretRef{Name: "registry-auth"},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+
+	require.Equal(t, "/etc/k0s/containerd.d/k0smotron-registries.toml", files[0].Path)
+	require.Contains(t, files[0].Content, `config_path = "/etc/containerd/certs.d"`)
+
+	require.Equal(t, "/etc/containerd/certs.d/docker.io/hosts.toml", files[1].Path)
+	require.Equal(t, "0644", files[1].Permissions)
+	require.Equal(t, `server = "https://registry-1.docker.io"
+
+[host."https://mirror.example.com"]
+  capabilities = ["pull", "resolve"]
+
+[host."http://10.0.0.1:5000"]
+  capabilities = ["pull", "resolve"]
+`, files[1].Content)
+
+	require.Equal(t, "/etc/containerd/certs.d/registry.example.com:5000/hosts.toml", files[2].Path)
+	require.Equal(t, "0600", files[2].Permissions)
+	require.Equal(t, `server = "https://registry.example.com:5000"
+
+[host."https://registry.example.com:5000"]
+  capabilities = ["pull", "resolve"]
+  skip_verify = true
+  [host."https://registry.example.com:5000".header]
+    authorization = "Basic dXNlcjpwYXNz"
+`, files[2].Content)
+
+	_, err = registryFiles(context.Background(), c, "default", []bootstrapv1.Registry{
+		{Host: "registry.example.com", AuthSecretRef: &bootstrapv1.RegistryAuthSecretRef{Name: "invalid-auth"}},
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	capiutil "sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+const (
+	// certificatesExpiryWarningPeriod is the time before the expiry of the certificates of a machine when
+	// the MachineCertificatesValid condition is marked false.
+	certificatesExpiryWarningPeriod = 30 * 24 * time.Hour
+	certificatesCheckTimeout        = 5 * time.Second
+	defaultAPIPort                  = 6443
+)
+
+// reconcileCertificatesExpiry checks the API server certificate of each ready control plane machine, sets its expiry
+// to the certificates expiry annotation of the machine and sets the MachineCertificatesValid condition.
+// The certificates are checked again only when the annotation is missing or the expiry is close.
+func (c *K0sController) reconcileCertificatesExpiry(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane, machines collections.Machines) {
+	log := log.FromContext(ctx)
+
+	ca, err := secret.GetFromNamespacedName(ctx, c.Client, capiutil.ObjectKey(cluster), secret.ClusterCA)
+	if err != nil {
+		conditions.MarkUnknown(kcp, cpv1beta1.MachineCertificatesValidCondition, cpv1beta1.CertificatesExpiryUnknownReason, "Failed to get the cluster CA: %v", err)
+		return
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca.Data[secret.TLSCrtDataName]) {
+		conditions.MarkUnknown(kcp, cpv1beta1.MachineCertificatesValidCondition, cpv1beta1.CertificatesExpiryUnknownReason, "Failed to parse the cluster CA")
+		return
+	}
+
+	var expiring, unknown []string
+	for _, m := range machines.Filter(isMachineReady).SortedByCreationTimestamp() {
+		expiry, err := c.machineCertificatesExpiry(ctx, kcp, m, roots)
+		if err != nil {
+			log.Error(err, "Failed to check the certificates of the machine", "machine", m.Name)
+			unknown = append(unknown, m.Name)
+			continue
+		}
+		if time.Until(expiry) < certificatesExpiryWarningPeriod {
+			expiring = append(expiring, m.Name)
+		}
+	}
+
+	switch {
+	case len(expiring) > 0:
+		conditions.MarkFalse(kcp, cpv1beta1.MachineCertificatesValidCondition, cpv1beta1.CertificatesExpiringSoonReason, clusterv1.ConditionSeverityWarning,
+			"Certificates of machines %v expire in less than %d days", expiring, int(certificatesExpiryWarningPeriod.Hours()/24))
+	case len(unknown) > 0:
+		conditions.MarkUnknown(kcp, cpv1beta1.MachineCertificatesValidCondition, cpv1beta1.CertificatesExpiryUnknownReason,
+			"Failed to check the certificates of machines %v", unknown)
+	default:
+		conditions.MarkTrue(kcp, cpv1beta1.MachineCertificatesValidCondition)
+	}
+}
+
+// machineCertificatesExpiry returns the expiry of the API server certificate of the machine and updates the
+// certificates expiry annotation of the machine.
+func (c *K0sController) machineCertificatesExpiry(ctx context.Context, kcp *cpv1beta1.K0sControlPlane, m *clusterv1.Machine, roots *x509.CertPool) (time.Time, error) {
+	if value, ok := m.Annotations[clusterv1.MachineCertificatesExpiryDateAnnotation]; ok {
+		expiry, err := time.Parse(time.RFC3339, value)
+		if err == nil && time.Until(expiry) > certificatesExpiryWarningPeriod {
+			return expiry, nil
+		}
+	}
+
+	address := machineAddress(m)
+	if address == "" {
+		return time.Time{}, errors.New("machine has no address")
+	}
+	cert, err := getServingCertificate(ctx, net.JoinHostPort(address, strconv.Itoa(apiPort(kcp))), roots)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	expiry := cert.NotAfter.UTC().Format(time.RFC3339)
+	if m.Annotations[clusterv1.MachineCertificatesExpiryDateAnnotation] != expiry {
+		patch := client.MergeFrom(m.DeepCopy())
+		if m.Annotations == nil {
+			m.Annotations = map[string]string{}
+		}
+		m.Annotations[clusterv1.MachineCertificatesExpiryDateAnnotation] = expiry
+		if err := c.Client.Patch(ctx, m, patch); err != nil {
+			return time.Time{}, fmt.Errorf("error setting certificates expiry annotation: %w", err)
+		}
+	}
+
+	return cert.NotAfter, nil
+}
+
+// getServingCertificate returns the serving certificate of the given address verified against the given CA.
+// The host name is not verified as the machines are reached by their addresses.
+func getServingCertificate(ctx context.Context, address string, roots *x509.CertPool) (*x509.Certificate, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: certificatesCheckTimeout},
+		Config: &tls.Config{
+			//nolint:gosec // the certificate chain is verified against the cluster CA below
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) == 0 {
+					return errors.New("no serving certificate")
+				}
+				cert, err := x509.ParseCertificate(rawCerts[0])
+				if err != nil {
+					return err
+				}
+				_, err = cert.Verify(x509.VerifyOptions{Roots: roots})
+				return err
+			},
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	return conn.(*tls.Conn).ConnectionState().PeerCertificates[0], nil
+}
+
+// machineAddress returns the external address of the machine, or the internal address if it has none.
+func machineAddress(m *clusterv1.Machine) string {
+	internal := ""
+	for _, addr := range m.Status.Addresses {
+		switch addr.Type {
+		case clusterv1.MachineExternalIP:
+			return addr.Address
+		case clusterv1.MachineInternalIP:
+			if internal == "" {
+				internal = addr.Address
+			}
+		}
+	}
+	return internal
+}
+
+// apiPort returns the API server port of the k0s config of the control plane.
+func apiPort(kcp *cpv1beta1.K0sControlPlane) int {
+	if kcp.Spec.K0sConfigSpec.K0s == nil {
+		return defaultAPIPort
+	}
+	port, found, err := unstructured.NestedInt64(kcp.Spec.K0sConfigSpec.K0s.Object, "spec", "api", "port")
+	if err != nil || !found || port == 0 {
+		return defaultAPIPort
+	}
+	return int(port)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func TestK0sController_reconcileCertificatesExpiry(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	apiPort, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	ca := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-ca", Namespace: "default"},
+		Data: map[string][]byte{
+			"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+		},
+	}
+	kcp := &cpv1beta1.K0sControlPlane{
+		Spec: cpv1beta1.K0sControlPlaneSpec{
+			K0sConfigSpec: bootstrapv1.K0sConfigSpec{
+				K0s: &unstructured.Unstructured{Object: map[string]interface{}{
+					"spec": map[string]interface{}{"api": map[string]interface{}{"port": int64(apiPort)}},
+				}},
+			},
+		},
+	}
+	newMachine := func(name string, annotations map[string]string) *clusterv1.Machine {
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+			Status: clusterv1.MachineStatus{
+				BootstrapReady:      true,
+				InfrastructureReady: true,
+				Addresses:           clusterv1.MachineAddresses{{Type: clusterv1.MachineInternalIP, Address: host}},
+			},
+		}
+	}
+	notExpiring := time.Now().Add(2 * certificatesExpiryWarningPeriod).UTC().Format(time.RFC3339)
+	expiring := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		noCA        bool
+		wantStatus  corev1.ConditionStatus
+		wantExpiry  string
+	}{
+		{
+			name:       "certificate checked",
+			wantStatus: corev1.ConditionTrue,
+			wantExpiry: server.Certificate().NotAfter.UTC().Format(time.RFC3339),
+		},
+		{
+			name:        "expiry far in the future is not checked",
+			annotations: map[string]string{clusterv1.MachineCertificatesExpiryDateAnnotation: notExpiring},
+			wantStatus:  corev1.ConditionTrue,
+			wantExpiry:  notExpiring,
+		},
+		{
+			name:        "expiring certificate is checked again",
+			annotations: map[string]string{clusterv1.MachineCertificatesExpiryDateAnnotation: expiring},
+			wantStatus:  corev1.ConditionTrue,
+			wantExpiry:  server.Certificate().NotAfter.UTC().Format(time.RFC3339),
+		},
+		{
+			name:       "no cluster CA",
+			noCA:       true,
+			wantStatus: corev1.ConditionUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMachine("cp-0", tt.annotations)
+			objs := []client.Object{m}
+			if !tt.noCA {
+				objs = append(objs, ca)
+			}
+			scheme := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			require.NoError(t, clusterv1.AddToScheme(scheme))
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+			c := &K0sController{Client: fakeClient, Scheme: scheme}
+
+			kcp := kcp.DeepCopy()
+			c.reconcileCertificatesExpiry(context.Background(), cluster, kcp, collections.FromMachines(m))
+			require.Equal(t, tt.wantStatus, conditions.Get(kcp, cpv1beta1.MachineCertificatesValidCondition).Status)
+
+			if tt.wantExpiry != "" {
+				var got clusterv1.Machine
+				require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKeyFromObject(m), &got))
+				require.Equal(t, tt.wantExpiry, got.Annotations[clusterv1.MachineCertificatesExpiryDateAnnotation])
+			}
+		})
+	}
+}
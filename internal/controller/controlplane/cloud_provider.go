@@ -0,0 +1,231 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+
+	"github.com/imdario/mergo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kmapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
+)
+
+const (
+	// controlPlaneCloudProviderCredentialsField is the field index of the Secret referenced by
+	// spec.cloudProvider.credentialsSecretRef.
+	controlPlaneCloudProviderCredentialsField = "spec.cloudProvider.credentialsSecretRef"
+	// cloudControllerManagerRelease is the name of the Helm release of the cloud-controller-manager.
+	cloudControllerManagerRelease = "cloud-controller-manager"
+	// enableCloudProviderArg enables the external cloud provider of the kubelet of k0s.
+	enableCloudProviderArg = "--enable-cloud-provider"
+)
+
+// cloudProvider is the cloud-controller-manager chart of a cloud provider and the Secret holding the credentials
+// the chart reads.
+type cloudProvider struct {
+	repository kmapi.HelmRepository
+	chartName  string
+	secretName string
+	values     map[string]interface{}
+}
+
+// The charts schedule the cloud-controller-manager on the control plane nodes by default, but the k0s controllers
+// don't run a kubelet unless enabled, so the node selector is removed.
+var cloudProviders = map[string]cloudProvider{
+	"openstack": {
+		repository: kmapi.HelmRepository{Name: "cpo", URL: "https://kubernetes.github.io/cloud-provider-openstack"},
+		chartName:  "cpo/openstack-cloud-controller-manager",
+		secretName: "cloud-config",
+		values: map[string]interface{}{
+			"secret":       map[string]interface{}{"enabled": true, "create": false, "name": "cloud-config"},
+			"nodeSelector": map[string]interface{}{"node-role.kubernetes.io/control-plane": nil},
+		},
+	},
+	"aws": {
+		repository: kmapi.HelmRepository{Name: "aws-cloud-controller-manager", URL: "https://kubernetes.github.io/cloud-provider-aws"},
+		chartName:  "aws-cloud-controller-manager/aws-cloud-controller-manager",
+		secretName: "aws-cloud-credentials",
+		values: map[string]interface{}{
+			"nodeSelector": map[string]interface{}{"node-role.kubernetes.io/control-plane": nil},
+			"env": []interface{}{
+				awsCredentialsEnv("AWS_ACCESS_KEY_ID"),
+				awsCredentialsEnv("AWS_SECRET_ACCESS_KEY"),
+			},
+		},
+	},
+	"hetzner": {
+		repository: kmapi.HelmRepository{Name: "hcloud", URL: "https://charts.hetzner.cloud"},
+		chartName:  "hcloud/hcloud-cloud-controller-manager",
+		secretName: "hcloud",
+	},
+}
+
+// awsCredentialsEnv reads an environment variable of the AWS cloud-controller-manager from the credentials. The
+// variables are optional, so the instance profile of the nodes can be used instead.
+func awsCredentialsEnv(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name": name,
+		"valueFrom": map[string]interface{}{
+			"secretKeyRef": map[string]interface{}{"name": "aws-cloud-credentials", "key": name, "optional": true},
+		},
+	}
+}
+
+// setCloudProviderChart adds the cloud-controller-manager chart of the cloud provider to the Helm extensions of the
+// k0s config, with the values of the spec merged over the values of the provider.
+func setCloudProviderChart(k0sConfig *unstructured.Unstructured, spec *cpv1beta1.CloudProviderSpec) error {
+	provider, ok := cloudProviders[spec.Name]
+	if !ok {
+		return fmt.Errorf("unsupported cloud provider %q", spec.Name)
+	}
+
+	values := runtime.DeepCopyJSON(provider.values)
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	if spec.Values != nil && len(spec.Values.Raw) > 0 {
+		var override map[string]interface{}
+		if err := json.Unmarshal(spec.Values.Raw, &override); err != nil {
+			return fmt.Errorf("error parsing the values of the cloud provider: %w", err)
+		}
+		if err := mergo.Merge(&values, override, mergo.WithOverride); err != nil {
+			return fmt.Errorf("error merging the values of the cloud provider: %w", err)
+		}
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	return kutil.AddHelmChart(k0sConfig.Object, provider.repository, kmapi.HelmChart{
+		Name:      cloudControllerManagerRelease,
+		ChartName: provider.chartName,
+		Version:   spec.ChartVersion,
+		Namespace: metav1.NamespaceSystem,
+		Values:    &runtime.RawExtension{Raw: raw},
+	})
+}
+
+// withEnableCloudProvider adds the arg enabling the external cloud provider of the kubelet to the k0s args.
+func withEnableCloudProvider(args []string) []string {
+	if slices.Contains(args, enableCloudProviderArg) || slices.Contains(args, enableCloudProviderArg+"=true") {
+		return args
+	}
+	return append(args, enableCloudProviderArg)
+}
+
+// reconcileCloudProviderCredentials copies the credentials of the cloud provider to the Secret read by the
+// cloud-controller-manager in the cluster, so a rotation of the credentials is applied to the cluster too.
+func (c *K0sController) reconcileCloudProviderCredentials(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane) error {
+	if kcp.Spec.CloudProvider == nil {
+		return nil
+	}
+	provider, ok := cloudProviders[kcp.Spec.CloudProvider.Name]
+	if !ok {
+		return fmt.Errorf("unsupported cloud provider %q", kcp.Spec.CloudProvider.Name)
+	}
+
+	var credentials corev1.Secret
+	key := client.ObjectKey{Namespace: kcp.Namespace, Name: kcp.Spec.CloudProvider.CredentialsSecretRef.Name}
+	if err := c.Client.Get(ctx, key, &credentials); err != nil {
+		return fmt.Errorf("error getting the cloud provider credentials: %w", err)
+	}
+
+	kubeClient, err := c.getKubeClient(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	return applyCloudProviderSecret(ctx, kubeClient, provider.secretName, credentials.Data)
+}
+
+func applyCloudProviderSecret(ctx context.Context, kubeClient kubernetes.Interface, name string, data map[string][]byte) error {
+	secrets := kubeClient.CoreV1().Secrets(metav1.NamespaceSystem)
+	existing, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: metav1.NamespaceSystem,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "k0smotron"},
+			},
+			Data: data,
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("error creating the cloud provider credentials: %w", err)
+		}
+		log.FromContext(ctx).Info("Created the cloud provider credentials in the cluster", "secret", name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting the cloud provider credentials of the cluster: %w", err)
+	}
+	if reflect.DeepEqual(existing.Data, data) {
+		return nil
+	}
+
+	existing.Data = data
+	if _, err := secrets.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating the cloud provider credentials: %w", err)
+	}
+	log.FromContext(ctx).Info("Updated the cloud provider credentials in the cluster", "secret", name)
+	return nil
+}
+
+// requestsForCloudProviderCredentials maps the Secret holding the cloud provider credentials to the control planes
+// referencing it.
+func (c *K0sController) requestsForCloudProviderCredentials(ctx context.Context, obj client.Object) []reconcile.Request {
+	var kcps cpv1beta1.K0sControlPlaneList
+	if err := c.Client.List(ctx, &kcps, client.InNamespace(obj.GetNamespace()), client.MatchingFields{controlPlaneCloudProviderCredentialsField: obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list K0sControlPlanes")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, kcp := range kcps.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: kcp.Name, Namespace: kcp.Namespace}})
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Name < requests[j].Name })
+	return requests
+}
+
+// indexControlPlaneCloudProviderCredentials indexes the control planes by the Secret holding their cloud provider
+// credentials.
+func indexControlPlaneCloudProviderCredentials(obj client.Object) []string {
+	kcp, ok := obj.(*cpv1beta1.K0sControlPlane)
+	if !ok || kcp.Spec.CloudProvider == nil {
+		return nil
+	}
+	return []string{kcp.Spec.CloudProvider.CredentialsSecretRef.Name}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func Test_setCloudProviderChart(t *testing.T) {
+	k0sConfig := newK0sConfig()
+	err := setCloudProviderChart(k0sConfig, &cpv1beta1.CloudProviderSpec{
+		Name:         "openstack",
+		ChartVersion: "2.29.0",
+		Values:       &runtime.RawExtension{Raw: []byte(`{"secret":{"name":"openstack-config"},"cluster":{"name":"test"}}`)},
+	})
+	require.NoError(t, err)
+
+	repositories, _, err := unstructured.NestedSlice(k0sConfig.Object, "spec", "extensions", "helm", "repositories")
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{map[string]interface{}{"name": "cpo", "url": "https://kubernetes.github.io/cloud-provider-openstack"}}, repositories)
+
+	charts, _, err := unstructured.NestedSlice(k0sConfig.Object, "spec", "extensions", "helm", "charts")
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"name":      "cloud-controller-manager",
+		"chartname": "cpo/openstack-cloud-controller-manager",
+		"namespace": "kube-system",
+		"version":   "2.29.0",
+		"values":    "cluster:\n  name: test\nnodeSelector:\n  node-role.kubernetes.io/control-plane: null\nsecret:\n  create: false\n  enabled: true\n  name: openstack-config\n",
+	}}, charts)
+
+	require.Error(t, setCloudProviderChart(newK0sConfig(), &cpv1beta1.CloudProviderSpec{Name: "unknown"}))
+}
+
+func Test_machineK0sConfigSpec_cloudProvider(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{Spec: cpv1beta1.K0sControlPlaneSpec{
+		CloudProvider: &cpv1beta1.CloudProviderSpec{Name: "hetzner"},
+	}}
+	kcp.Spec.K0sConfigSpec.Args = []string{"--enable-worker"}
+
+	spec, err := machineK0sConfigSpec(kcp, "cp-0", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"--enable-worker", "--enable-cloud-provider"}, spec.Args)
+	charts, _, err := unstructured.NestedSlice(spec.K0s.Object, "spec", "extensions", "helm", "charts")
+	require.NoError(t, err)
+	require.Len(t, charts, 1)
+
+	require.Equal(t, []string{"--enable-cloud-provider=true"}, withEnableCloudProvider([]string{"--enable-cloud-provider=true"}))
+}
+
+func Test_applyCloudProviderSecret(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := kubefake.NewSimpleClientset()
+
+	require.NoError(t, applyCloudProviderSecret(ctx, kubeClient, "hcloud", map[string][]byte{"token": []byte("v1")}))
+	secret, err := kubeClient.CoreV1().Secrets("kube-system").Get(ctx, "hcloud", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "v1", string(secret.Data["token"]))
+	require.Equal(t, "k0smotron", secret.Labels["app.kubernetes.io/managed-by"])
+
+	require.NoError(t, applyCloudProviderSecret(ctx, kubeClient, "hcloud", map[string][]byte{"token": []byte("v2")}))
+	secret, err = kubeClient.CoreV1().Secrets("kube-system").Get(ctx, "hcloud", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "v2", string(secret.Data["token"]))
+}
+
+func TestK0sController_requestsForCloudProviderCredentials(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, cpv1beta1.AddToScheme(scheme))
+
+	newKCP := func(name, secret string) *cpv1beta1.K0sControlPlane {
+		kcp := &cpv1beta1.K0sControlPlane{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		if secret != "" {
+			kcp.Spec.CloudProvider = &cpv1beta1.CloudProviderSpec{
+				Name:                 "hetzner",
+				CredentialsSecretRef: corev1.LocalObjectReference{Name: secret},
+			}
+		}
+		return kcp
+	}
+	c := &K0sController{Client: fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(newKCP("b", "hcloud"), newKCP("a", "hcloud"), newKCP("c", "other"), newKCP("d", "")).
+		WithIndex(&cpv1beta1.K0sControlPlane{}, controlPlaneCloudProviderCredentialsField, indexControlPlaneCloudProviderCredentials).
+		Build()}
+
+	requests := c.requestsForCloudProviderCredentials(context.Background(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "hcloud", Namespace: "default"}})
+	require.Len(t, requests, 2)
+	require.Equal(t, "a", requests[0].Name)
+	require.Equal(t, "b", requests[1].Name)
+}
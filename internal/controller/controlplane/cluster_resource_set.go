@@ -0,0 +1,137 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	addonsv1 "sigs.k8s.io/cluster-api/exp/addons/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// clusterResourceSetLabel selects the cluster in the ClusterResourceSet created for it.
+const clusterResourceSetLabel = "k0smotron.io/cluster-resource-set"
+
+// +kubebuilder:rbac:groups=addons.cluster.x-k8s.io,resources=clusterresourcesets,verbs=get;list;watch;create;update;patch;delete
+
+// reconcileClusterResourceSet creates the ClusterResourceSet applying the resources of the spec to the cluster and
+// labels the cluster to be selected by it. Without the spec, the ClusterResourceSet and the label are removed.
+func reconcileClusterResourceSet(ctx context.Context, c client.Client, scheme *runtime.Scheme, cluster *clusterv1.Cluster, owner client.Object, spec *kapi.ClusterResourceSetSpec) error {
+	logger := log.FromContext(ctx)
+
+	crs := &addonsv1.ClusterResourceSet{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: clusterResourceSetName(cluster)}, crs)
+	if spec == nil && meta.IsNoMatchError(err) {
+		// The ClusterResourceSet feature of Cluster API is disabled
+		return nil
+	}
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	exists := err == nil
+
+	if spec == nil {
+		if exists && metav1.IsControlledBy(crs, owner) {
+			logger.Info("ClusterResourceSet removed from the spec, deleting it")
+			if err := c.Delete(ctx, crs); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+		return labelClusterForClusterResourceSet(ctx, c, cluster, false)
+	}
+
+	strategy := spec.Strategy
+	if strategy == "" {
+		strategy = string(addonsv1.ClusterResourceSetStrategyApplyOnce)
+	}
+	resources := make([]addonsv1.ResourceRef, 0, len(spec.Resources))
+	for _, r := range spec.Resources {
+		resources = append(resources, addonsv1.ResourceRef{Name: r.Name, Kind: r.Kind})
+	}
+	desired := addonsv1.ClusterResourceSetSpec{
+		ClusterSelector: metav1.LabelSelector{MatchLabels: map[string]string{clusterResourceSetLabel: cluster.Name}},
+		Resources:       resources,
+		Strategy:        strategy,
+	}
+
+	if exists && !metav1.IsControlledBy(crs, owner) {
+		return fmt.Errorf("ClusterResourceSet %s/%s exists and is not controlled by the control plane", crs.Namespace, crs.Name)
+	}
+	// The strategy of a ClusterResourceSet is immutable, so the ClusterResourceSet is replaced
+	if exists && crs.Spec.Strategy != desired.Strategy {
+		logger.Info("ClusterResourceSet strategy changed, replacing it", "strategy", desired.Strategy)
+		if err := c.Delete(ctx, crs); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		crs = &addonsv1.ClusterResourceSet{}
+		exists = false
+	}
+
+	if err := labelClusterForClusterResourceSet(ctx, c, cluster, true); err != nil {
+		return err
+	}
+
+	if exists {
+		if equality.Semantic.DeepEqual(crs.Spec, desired) {
+			return nil
+		}
+		crs.Spec = desired
+		return c.Update(ctx, crs)
+	}
+
+	crs.Name = clusterResourceSetName(cluster)
+	crs.Namespace = cluster.Namespace
+	crs.Labels = map[string]string{clusterv1.ClusterNameLabel: cluster.Name}
+	crs.Spec = desired
+	if err := ctrl.SetControllerReference(owner, crs, scheme); err != nil {
+		return err
+	}
+	logger.Info("Creating the ClusterResourceSet", "name", crs.Name)
+	return c.Create(ctx, crs)
+}
+
+// labelClusterForClusterResourceSet sets or removes the label selecting the cluster in its ClusterResourceSet.
+func labelClusterForClusterResourceSet(ctx context.Context, c client.Client, cluster *clusterv1.Cluster, selected bool) error {
+	if _, ok := cluster.Labels[clusterResourceSetLabel]; ok == selected {
+		return nil
+	}
+
+	patch := client.MergeFrom(cluster.DeepCopy())
+	if selected {
+		if cluster.Labels == nil {
+			cluster.Labels = map[string]string{}
+		}
+		cluster.Labels[clusterResourceSetLabel] = cluster.Name
+	} else {
+		delete(cluster.Labels, clusterResourceSetLabel)
+	}
+	return c.Patch(ctx, cluster, patch)
+}
+
+func clusterResourceSetName(cluster *clusterv1.Cluster) string {
+	return fmt.Sprintf("%s-addons", cluster.Name)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	addonsv1 "sigs.k8s.io/cluster-api/exp/addons/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestReconcileClusterResourceSet(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, clusterv1.AddToScheme(scheme))
+	require.NoError(t, addonsv1.AddToScheme(scheme))
+	require.NoError(t, cpv1beta1.AddToScheme(scheme))
+
+	ctx := context.Background()
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	kcp := &cpv1beta1.K0smotronControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "kcp-uid"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster, kcp).Build()
+
+	spec := &kapi.ClusterResourceSetSpec{
+		Resources: []kapi.ClusterResourceSetResource{{Name: "calico", Kind: "ConfigMap"}, {Name: "cloud-credentials", Kind: "Secret"}},
+	}
+	require.NoError(t, reconcileClusterResourceSet(ctx, c, scheme, cluster, kcp, spec))
+
+	var updated clusterv1.Cluster
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(cluster), &updated))
+	assert.Equal(t, "test", updated.Labels[clusterResourceSetLabel])
+
+	var crs addonsv1.ClusterResourceSet
+	key := client.ObjectKey{Namespace: "default", Name: "test-addons"}
+	require.NoError(t, c.Get(ctx, key, &crs))
+	assert.True(t, metav1.IsControlledBy(&crs, kcp))
+	assert.Equal(t, map[string]string{clusterResourceSetLabel: "test"}, crs.Spec.ClusterSelector.MatchLabels)
+	assert.Equal(t, []addonsv1.ResourceRef{{Name: "calico", Kind: "ConfigMap"}, {Name: "cloud-credentials", Kind: "Secret"}}, crs.Spec.Resources)
+	assert.Equal(t, "ApplyOnce", crs.Spec.Strategy)
+
+	// A changed strategy replaces the ClusterResourceSet
+	spec.Strategy = "Reconcile"
+	require.NoError(t, reconcileClusterResourceSet(ctx, c, scheme, cluster, kcp, spec))
+	require.NoError(t, c.Get(ctx, key, &crs))
+	assert.Equal(t, "Reconcile", crs.Spec.Strategy)
+
+	// Without the spec, the ClusterResourceSet and the label are removed
+	require.NoError(t, reconcileClusterResourceSet(ctx, c, scheme, cluster, kcp, nil))
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, key, &crs)))
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(cluster), &updated))
+	assert.NotContains(t, updated.Labels, clusterResourceSetLabel)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/failuredomains"
+)
+
+// pickFailureDomain returns the control plane failure domain of the cluster with the fewest given machines,
+// or nil if the cluster doesn't report any failure domains.
+func pickFailureDomain(cluster *clusterv1.Cluster, machines collections.Machines) *string {
+	fds := cluster.Status.FailureDomains.FilterControlPlane()
+	if len(fds) == 0 {
+		return nil
+	}
+	return failuredomains.PickFewest(fds, machines)
+}
+
+// machinesInMostPopulatedFailureDomain returns the candidates to delete in the failure domain with the most
+// machines, so the machines stay spread across the failure domains. All the candidates are returned if the
+// cluster doesn't report any failure domains.
+func machinesInMostPopulatedFailureDomain(cluster *clusterv1.Cluster, machines collections.Machines, candidates collections.Machines) collections.Machines {
+	fds := cluster.Status.FailureDomains.FilterControlPlane()
+	if len(fds) == 0 {
+		return candidates
+	}
+
+	// Remove the machines outside of the known failure domains first
+	if unknown := candidates.Filter(collections.Not(collections.InFailureDomains(fds.GetIDs()...))); unknown.Len() > 0 {
+		return unknown
+	}
+
+	fd := failuredomains.PickMost(fds, machines, candidates)
+	if fd == nil {
+		return candidates
+	}
+	return candidates.Filter(collections.InFailureDomains(fd))
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+)
+
+func withFailureDomain(m *clusterv1.Machine, failureDomain string) *clusterv1.Machine {
+	m.Spec.FailureDomain = ptr.To(failureDomain)
+	return m
+}
+
+func newFailureDomainsTestCluster(ids ...string) *clusterv1.Cluster {
+	cluster := &clusterv1.Cluster{}
+	for _, id := range ids {
+		if cluster.Status.FailureDomains == nil {
+			cluster.Status.FailureDomains = clusterv1.FailureDomains{}
+		}
+		cluster.Status.FailureDomains[id] = clusterv1.FailureDomainSpec{ControlPlane: true}
+	}
+	return cluster
+}
+
+func Test_pickFailureDomain(t *testing.T) {
+	tests := []struct {
+		name     string
+		cluster  *clusterv1.Cluster
+		machines collections.Machines
+		want     *string
+	}{
+		{
+			name:     "no failure domains",
+			cluster:  newFailureDomainsTestCluster(),
+			machines: collections.FromMachines(newTestMachine("cp-0", 1, false)),
+		},
+		{
+			name:    "failure domain with fewest machines",
+			cluster: newFailureDomainsTestCluster("az-1", "az-2", "az-3"),
+			machines: collections.FromMachines(
+				withFailureDomain(newTestMachine("cp-0", 1, false), "az-1"),
+				withFailureDomain(newTestMachine("cp-1", 2, false), "az-2"),
+				withFailureDomain(newTestMachine("cp-2", 3, false), "az-1"),
+			),
+			want: ptr.To("az-3"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, pickFailureDomain(tt.cluster, tt.machines))
+		})
+	}
+}
+
+func Test_machinesInMostPopulatedFailureDomain(t *testing.T) {
+	machines := collections.FromMachines(
+		withFailureDomain(newTestMachine("cp-0", 1, false), "az-1"),
+		withFailureDomain(newTestMachine("cp-1", 2, false), "az-2"),
+		withFailureDomain(newTestMachine("cp-2", 3, false), "az-1"),
+		withFailureDomain(newTestMachine("cp-3", 4, false), "az-2"),
+		withFailureDomain(newTestMachine("cp-4", 5, false), "az-2"),
+	)
+
+	tests := []struct {
+		name       string
+		cluster    *clusterv1.Cluster
+		machines   collections.Machines
+		candidates collections.Machines
+		want       []string
+	}{
+		{
+			name:       "no failure domains",
+			cluster:    newFailureDomainsTestCluster(),
+			machines:   machines,
+			candidates: machines,
+			want:       []string{"cp-0", "cp-1", "cp-2", "cp-3", "cp-4"},
+		},
+		{
+			name:       "most populated failure domain",
+			cluster:    newFailureDomainsTestCluster("az-1", "az-2"),
+			machines:   machines,
+			candidates: machines,
+			want:       []string{"cp-1", "cp-3", "cp-4"},
+		},
+		{
+			name:       "most populated failure domain with candidates",
+			cluster:    newFailureDomainsTestCluster("az-1", "az-2"),
+			machines:   machines,
+			candidates: machines.Filter(collections.InFailureDomains(ptr.To("az-1"))),
+			want:       []string{"cp-0", "cp-2"},
+		},
+		{
+			name:       "machines outside of failure domains first",
+			cluster:    newFailureDomainsTestCluster("az-1", "az-2"),
+			machines:   collections.FromMachines(append(machines.UnsortedList(), newTestMachine("cp-5", 6, false))...),
+			candidates: collections.FromMachines(append(machines.UnsortedList(), newTestMachine("cp-5", 6, false))...),
+			want:       []string{"cp-5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := machinesInMostPopulatedFailureDomain(tt.cluster, tt.machines, tt.candidates)
+			require.ElementsMatch(t, tt.want, got.Names())
+		})
+	}
+}
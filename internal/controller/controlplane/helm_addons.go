@@ -0,0 +1,106 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+const (
+	// helmAddonsReadyLabel is set on the clusters whose kubeconfig secret can be read by the Cluster API Add-on
+	// Provider for Helm.
+	helmAddonsReadyLabel = "k0smotron.io/helm-addons-ready"
+	// helmAddonsLabelsAnnotation lists the keys of the labels set on the cluster for the HelmChartProxies, so the
+	// labels removed from the spec are removed from the cluster.
+	helmAddonsLabelsAnnotation = "k0smotron.io/helm-addons-labels"
+)
+
+// reconcileHelmAddons labels the cluster with the labels of the spec and the helmAddonsReadyLabel once its kubeconfig
+// secret is in the format read by the Cluster API Add-on Provider for Helm, i.e. the value key of the
+// <cluster>-kubeconfig secret. Without the spec, the labels are removed.
+func reconcileHelmAddons(ctx context.Context, c client.Client, cluster *clusterv1.Cluster, spec *kapi.HelmAddonsSpec) error {
+	desired := map[string]string{}
+	if spec != nil {
+		kubeconfigSecret := &corev1.Secret{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: secret.Name(cluster.Name, secret.Kubeconfig)}, kubeconfigSecret)
+		if apierrors.IsNotFound(err) || (err == nil && len(kubeconfigSecret.Data[secret.KubeconfigDataName]) == 0) {
+			// The HelmChartProxies would fail to install the charts, the labels are set once the secret is written
+			log.FromContext(ctx).V(1).Info("Waiting for the kubeconfig secret to label the cluster for the HelmChartProxies")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for k, v := range spec.Labels {
+			desired[k] = v
+		}
+		desired[helmAddonsReadyLabel] = "true"
+	}
+
+	var applied []string
+	if v := cluster.Annotations[helmAddonsLabelsAnnotation]; v != "" {
+		applied = strings.Split(v, ",")
+	}
+	keys := make([]string, 0, len(desired))
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	updated := cluster.DeepCopy()
+	for _, k := range applied {
+		if _, ok := desired[k]; !ok {
+			delete(updated.Labels, k)
+		}
+	}
+	if len(desired) > 0 && updated.Labels == nil {
+		updated.Labels = map[string]string{}
+	}
+	for k, v := range desired {
+		updated.Labels[k] = v
+	}
+	if len(keys) > 0 {
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[helmAddonsLabelsAnnotation] = strings.Join(keys, ",")
+	} else {
+		delete(updated.Annotations, helmAddonsLabelsAnnotation)
+	}
+
+	if maps.Equal(cluster.Labels, updated.Labels) && maps.Equal(cluster.Annotations, updated.Annotations) {
+		return nil
+	}
+
+	patch := client.MergeFrom(cluster.DeepCopy())
+	cluster.Labels = updated.Labels
+	cluster.Annotations = updated.Annotations
+	return c.Patch(ctx, cluster, patch)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestReconcileHelmAddons(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, clusterv1.AddToScheme(scheme))
+
+	ctx := context.Background()
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{
+		Name:      "test",
+		Namespace: "default",
+		Labels:    map[string]string{"env": "dev"},
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster).Build()
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(cluster), cluster))
+
+	spec := &kapi.HelmAddonsSpec{Labels: map[string]string{"cni": "calico", "ingress": "nginx"}}
+
+	// The cluster isn't labeled before the kubeconfig secret is written
+	require.NoError(t, reconcileHelmAddons(ctx, c, cluster, spec))
+	assert.Equal(t, map[string]string{"env": "dev"}, cluster.Labels)
+
+	kubeconfigSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-kubeconfig", Namespace: "default"},
+		Data:       map[string][]byte{"value": []byte("kubeconfig")},
+	}
+	require.NoError(t, c.Create(ctx, kubeconfigSecret))
+	require.NoError(t, reconcileHelmAddons(ctx, c, cluster, spec))
+
+	var updated clusterv1.Cluster
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(cluster), &updated))
+	assert.Equal(t, map[string]string{"env": "dev", "cni": "calico", "ingress": "nginx", helmAddonsReadyLabel: "true"}, updated.Labels)
+	assert.Equal(t, "cni,ingress,"+helmAddonsReadyLabel, updated.Annotations[helmAddonsLabelsAnnotation])
+
+	// The labels removed from the spec are removed from the cluster
+	spec.Labels = map[string]string{"cni": "cilium"}
+	require.NoError(t, reconcileHelmAddons(ctx, c, cluster, spec))
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(cluster), &updated))
+	assert.Equal(t, map[string]string{"env": "dev", "cni": "cilium", helmAddonsReadyLabel: "true"}, updated.Labels)
+
+	// Without the spec, only the labels set by the user remain
+	require.NoError(t, reconcileHelmAddons(ctx, c, cluster, nil))
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(cluster), &updated))
+	assert.Equal(t, map[string]string{"env": "dev"}, updated.Labels)
+	assert.NotContains(t, updated.Annotations, helmAddonsLabelsAnnotation)
+}
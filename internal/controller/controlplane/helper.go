@@ -21,8 +21,8 @@ import (
 	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
 )
 
-func (c *K0sController) createMachine(ctx context.Context, name string, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane, infraRef corev1.ObjectReference) (*clusterv1.Machine, error) {
-	machine, err := c.generateMachine(ctx, name, cluster, kcp, infraRef)
+func (c *K0sController) createMachine(ctx context.Context, name string, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane, infraRef corev1.ObjectReference, failureDomain *string, templateHash string) (*clusterv1.Machine, error) {
+	machine, err := c.generateMachine(ctx, name, cluster, kcp, infraRef, failureDomain, templateHash)
 	if err != nil {
 		return nil, fmt.Errorf("error generating machine: %w", err)
 	}
@@ -53,6 +53,32 @@ func (c *K0sController) deleteMachine(ctx context.Context, name string, kcp *cpv
 	return nil
 }
 
+// removeControlPlaneMachine removes the etcd member of the machine from the cluster and deletes the machine, its bootstrap
+// config and infrastructure machine once the member has left, so the etcd quorum is not lost.
+func (c *K0sController) removeControlPlaneMachine(ctx context.Context, name string, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane, kubeClient *kubernetes.Clientset) error {
+	left, err := c.leaveEtcdMember(ctx, kcp, name, kubeClient)
+	if err != nil {
+		return fmt.Errorf("error removing etcd member %s: %w", name, err)
+	}
+	if !left {
+		return fmt.Errorf("waiting for etcd member %s to leave the cluster", name)
+	}
+
+	if err := c.deleteBootstrapConfig(ctx, name, kcp); err != nil {
+		return fmt.Errorf("error deleting bootstrap config: %w", err)
+	}
+
+	if err := c.deleteMachineFromTemplate(ctx, name, cluster, kcp); err != nil {
+		return fmt.Errorf("error deleting machine from template: %w", err)
+	}
+
+	if err := c.deleteMachine(ctx, name, kcp); err != nil {
+		return fmt.Errorf("error deleting machine: %w", err)
+	}
+
+	return nil
+}
+
 func (c *K0sController) machineExist(ctx context.Context, name string, kcp *cpv1beta1.K0sControlPlane) (bool, error) {
 	var machine clusterv1.Machine
 
@@ -66,7 +92,7 @@ func (c *K0sController) machineExist(ctx context.Context, name string, kcp *cpv1
 	return true, nil
 }
 
-func (c *K0sController) generateMachine(_ context.Context, name string, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane, infraRef corev1.ObjectReference) (*clusterv1.Machine, error) {
+func (c *K0sController) generateMachine(_ context.Context, name string, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane, infraRef corev1.ObjectReference, failureDomain *string, templateHash string) (*clusterv1.Machine, error) {
 	ver, err := semver.NewVersion(kcp.Spec.Version)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing version %q: %w", kcp.Spec.Version, err)
@@ -85,6 +111,10 @@ func (c *K0sController) generateMachine(_ context.Context, name string, cluster
 				"cluster.x-k8s.io/control-plane":        "true",
 				"cluster.x-k8s.io/generateMachine-role": "control-plane",
 			},
+			Annotations: map[string]string{
+				cpv1beta1.PreTerminateHookCleanupAnnotation: "",
+				cpv1beta1.MachineTemplateHashAnnotation:     templateHash,
+			},
 		},
 		Spec: clusterv1.MachineSpec{
 			Version:     &v,
@@ -97,6 +127,7 @@ func (c *K0sController) generateMachine(_ context.Context, name string, cluster
 				},
 			},
 			InfrastructureRef: infraRef,
+			FailureDomain:     failureDomain,
 		},
 	}, nil
 }
@@ -200,57 +231,27 @@ func (c *K0sController) createAutopilotPlan(ctx context.Context, kcp *cpv1beta1.
 		return fmt.Errorf("error getting control plane machines: %w", err)
 	}
 
-	amd64DownloadURL := `https://get.k0sproject.io/` + kcp.Spec.Version + `/k0s-` + kcp.Spec.Version + `-amd64`
-	arm64DownloadURL := `https://get.k0sproject.io/` + kcp.Spec.Version + `/k0s-` + kcp.Spec.Version + `-arm64`
-	armDownloadURL := `https://get.k0sproject.io/` + kcp.Spec.Version + `/k0s-` + kcp.Spec.Version + `-arm`
-	if kcp.Spec.K0sConfigSpec.DownloadURL != "" {
-		amd64DownloadURL = kcp.Spec.K0sConfigSpec.DownloadURL
-		arm64DownloadURL = kcp.Spec.K0sConfigSpec.DownloadURL
-		armDownloadURL = kcp.Spec.K0sConfigSpec.DownloadURL
-	}
-
-	timestamp := fmt.Sprintf("%d", time.Now().Unix())
-	plan := []byte(`
-	{
-		"apiVersion": "autopilot.k0sproject.io/v1beta2",
-		"kind": "Plan",
-		"metadata": {
-		  "name": "autopilot"
+	id := fmt.Sprintf("id-%s-%d", kcp.Name, time.Now().Unix())
+	targets := map[string]interface{}{
+		"controllers": map[string]interface{}{
+			"discovery": map[string]interface{}{
+				"static": map[string]interface{}{
+					"nodes": machines.Names(),
+				},
+			},
 		},
-		"spec": {
-			"id": "id-` + kcp.Name + `-` + timestamp + `",
-			"timestamp": "` + timestamp + `",
-			"commands": [{
-				"k0supdate": {
-					"version": "` + kcp.Spec.Version + `",
-					"platforms": {
-						"linux-amd64": {
-							"url": "` + amd64DownloadURL + `"
-						},
-						"linux-arm64": {
-							"url": "` + arm64DownloadURL + `"
-						},
-						"linux-arm": {
-							"url": "` + armDownloadURL + `"
-						}
-					},
-					"targets": {
-						"controllers": {
-							"discovery": {
-							    "static": {
-									"nodes": ["` + strings.Join(machines.Names(), `","`) + `"]
-								}
-							}
-						}
-					}
-				}
-			}]
+	}
+	// Upgrade the workers with the same plan, autopilot upgrades them once the controllers are upgraded
+	if kcp.Spec.WorkerUpgrade != nil {
+		workers, err := listUpgradeWorkers(ctx, clientset, kcp.Spec.WorkerUpgrade)
+		if err != nil {
+			return err
+		}
+		if len(workers) > 0 {
+			targets["workers"] = workersTarget(kcp.Spec.WorkerUpgrade)
+			kcp.Status.WorkerUpgrade = &cpv1beta1.WorkerUpgradeStatus{Version: kcp.Spec.Version, PlanID: id, Workers: int32(len(workers))}
 		}
-	}`)
+	}
 
-	return clientset.RESTClient().Post().
-		AbsPath("/apis/autopilot.k0sproject.io/v1beta2/plans").
-		Body(plan).
-		Do(ctx).
-		Error()
+	return replaceAutopilotPlan(ctx, clientset, kcp, id, targets)
 }
@@ -0,0 +1,183 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func TestK0sController_removeControlPlaneMachine(t *testing.T) {
+	const (
+		controlNodePath = "/apis/autopilot.k0sproject.io/v1beta2/controlnodes/cp-1"
+		etcdMemberPath  = "/apis/etcd.k0sproject.io/v1beta1/etcdmembers/cp-1"
+	)
+	joined := func(status string) string {
+		return `{"apiVersion":"etcd.k0sproject.io/v1beta1","kind":"EtcdMember","metadata":{"name":"cp-1"},` +
+			`"status":{"conditions":[{"type":"Joined","status":"` + status + `"}]}}`
+	}
+
+	tests := []struct {
+		name string
+		// etcdMember responds to the requests for the EtcdMember of the machine
+		etcdMember func(w http.ResponseWriter, r *http.Request)
+		wantErr    string
+		removed    bool
+	}{
+		{
+			name: "member still present",
+			etcdMember: func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(joined("True")))
+			},
+			wantErr: "waiting for etcd member cp-1 to leave the cluster",
+		},
+		{
+			name: "member left",
+			etcdMember: func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(joined("False")))
+			},
+			removed: true,
+		},
+		{
+			name: "member gone",
+			etcdMember: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			removed: true,
+		},
+		{
+			name: "member lookup error",
+			etcdMember: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				_, _ = w.Write([]byte(joined("True")))
+			},
+			wantErr: "error removing etcd member cp-1: error getting etcd member",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var etcdMemberRequests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case controlNodePath:
+					_, _ = w.Write([]byte(`{"apiVersion":"autopilot.k0sproject.io/v1beta2","kind":"ControlNode","metadata":{"name":"cp-1"}}`))
+				case etcdMemberPath:
+					etcdMemberRequests = append(etcdMemberRequests, r.Method)
+					tt.etcdMember(w, r)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+			clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			require.NoError(t, err)
+
+			kcp, cluster, machineTemplate, objs := newRemoveControlPlaneMachineObjects()
+			scheme := runtime.NewScheme()
+			require.NoError(t, clusterv1.AddToScheme(scheme))
+			require.NoError(t, bootstrapv1.AddToScheme(scheme))
+			c := &K0sController{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objs, machineTemplate)...).Build(),
+				Scheme: scheme,
+			}
+
+			err = c.removeControlPlaneMachine(context.Background(), "cp-1", cluster, kcp, clientset)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			// The member is marked to leave before its status is checked
+			require.Equal(t, http.MethodPatch, etcdMemberRequests[0])
+
+			// The machine is kept until its etcd member has left, so the etcd quorum is not lost
+			for _, obj := range objs {
+				err := c.Client.Get(context.Background(), client.ObjectKeyFromObject(obj), obj)
+				if tt.removed {
+					require.True(t, apierrors.IsNotFound(err), "%T %s should be deleted", obj, obj.GetName())
+				} else {
+					require.NoError(t, err)
+				}
+			}
+		})
+	}
+}
+
+// newRemoveControlPlaneMachineObjects returns a control plane of 3 machines, its machine template and the objects
+// of its machine cp-1.
+func newRemoveControlPlaneMachineObjects() (*cpv1beta1.K0sControlPlane, *clusterv1.Cluster, client.Object, []client.Object) {
+	kcp := &cpv1beta1.K0sControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "cp", Namespace: "default"},
+		Spec: cpv1beta1.K0sControlPlaneSpec{
+			Replicas: 3,
+			Version:  "v1.28.4+k0s.0",
+			MachineTemplate: &cpv1beta1.K0sControlPlaneMachineTemplate{
+				InfrastructureRef: corev1.ObjectReference{
+					APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+					Kind:       "DockerMachineTemplate",
+					Name:       "template",
+					Namespace:  "default",
+				},
+			},
+		},
+	}
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: "default"}}
+
+	machineTemplate := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{"customImage": "kindest/node:v1.28.0"},
+			},
+		},
+	}}
+	machineTemplate.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+	machineTemplate.SetKind("DockerMachineTemplate")
+	machineTemplate.SetName("template")
+	machineTemplate.SetNamespace("default")
+
+	infraMachine := &unstructured.Unstructured{}
+	infraMachine.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+	infraMachine.SetKind("DockerMachine")
+	infraMachine.SetName("cp-1")
+	infraMachine.SetNamespace("default")
+
+	machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "cp-1", Namespace: "default"}}
+	bootstrapConfig := &bootstrapv1.K0sControllerConfig{ObjectMeta: metav1.ObjectMeta{Name: "cp-1", Namespace: "default"}}
+
+	return kcp, cluster, machineTemplate, []client.Object{infraMachine, machine, bootstrapConfig}
+}
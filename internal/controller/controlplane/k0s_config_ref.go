@@ -0,0 +1,83 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
+)
+
+// controlPlaneK0sConfigRefField is the field index of the ConfigMap or the Secret referenced by spec.k0sConfigRef.
+const controlPlaneK0sConfigRefField = "spec.k0sConfigRef"
+
+// resolveK0sConfigRef replaces the k0s config of the spec in memory with the referenced one, merged with the inline
+// config. The machines and the dynamic config are compared with the resolved config, so a change of the reference
+// rolls out like a change of the inline config.
+func (c *K0sController) resolveK0sConfigRef(ctx context.Context, kcp *cpv1beta1.K0sControlPlane) error {
+	k0sConfig, err := kutil.ResolveK0sConfig(ctx, c.Client, kcp.Namespace, kcp.Spec.K0sConfigRef, kcp.Spec.K0sConfigSpec.K0s)
+	if err != nil {
+		return fmt.Errorf("error resolving k0sConfigRef: %w", err)
+	}
+	kcp.Spec.K0sConfigSpec.K0s = k0sConfig
+	return nil
+}
+
+// requestsForK0sConfigRef maps the ConfigMap or the Secret holding a k0s config to the control planes referencing it.
+func (c *K0sController) requestsForK0sConfigRef(ctx context.Context, obj client.Object) []reconcile.Request {
+	requests, err := c.controlPlanesForK0sConfigRef(ctx, obj)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list K0sControlPlanes")
+		return nil
+	}
+	return requests
+}
+
+// controlPlanesForK0sConfigRef returns the requests of the control planes referencing the ConfigMap or the Secret,
+// sorted by name.
+func (c *K0sController) controlPlanesForK0sConfigRef(ctx context.Context, obj client.Object) ([]reconcile.Request, error) {
+	var kcps cpv1beta1.K0sControlPlaneList
+	key := kutil.K0sConfigRefIndexKey(obj, obj.GetName())
+	if err := c.Client.List(ctx, &kcps, client.InNamespace(obj.GetNamespace()), client.MatchingFields{controlPlaneK0sConfigRefField: key}); err != nil {
+		return nil, err
+	}
+
+	var requests []reconcile.Request
+	for _, kcp := range kcps.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: kcp.Name, Namespace: kcp.Namespace}})
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Name < requests[j].Name })
+	return requests, nil
+}
+
+// indexControlPlaneK0sConfigRef indexes the control planes by the ConfigMap or the Secret holding their referenced
+// k0s config.
+func indexControlPlaneK0sConfigRef(obj client.Object) []string {
+	kcp, ok := obj.(*cpv1beta1.K0sControlPlane)
+	if !ok {
+		return nil
+	}
+	return kutil.K0sConfigRefIndexKeys(kcp.Spec.K0sConfigRef)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kmapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestResolveK0sConfigRef(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, cpv1beta1.AddToScheme(scheme))
+
+	baseline := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "baseline", Namespace: "default"},
+		Data:       map[string]string{"k0s.yaml": "spec:\n  api:\n    port: 7443\n  network:\n    provider: calico\n"},
+	}
+	kcp := &cpv1beta1.K0sControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default"},
+		Spec: cpv1beta1.K0sControlPlaneSpec{
+			K0sConfigRef: &kmapi.K0sConfigRef{ConfigMapRef: &corev1.LocalObjectReference{Name: "baseline"}},
+			K0sConfigSpec: bootstrapv1.K0sConfigSpec{
+				K0s: &unstructured.Unstructured{Object: map[string]interface{}{
+					"apiVersion": "k0s.k0sproject.io/v1beta1",
+					"kind":       "ClusterConfig",
+					"spec":       map[string]interface{}{"network": map[string]interface{}{"provider": "kuberouter"}},
+				}},
+			},
+		},
+	}
+	inline := &cpv1beta1.K0sControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "inline", Namespace: "default"}}
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(baseline, kcp, inline).
+		WithIndex(&cpv1beta1.K0sControlPlane{}, controlPlaneK0sConfigRefField, indexControlPlaneK0sConfigRef).
+		Build()
+	r := &K0sController{Client: c}
+
+	requests, err := r.controlPlanesForK0sConfigRef(context.Background(), baseline)
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	require.Equal(t, "kcp", requests[0].Name)
+	require.Equal(t, requests, r.requestsForK0sConfigRef(context.Background(), baseline))
+
+	require.NoError(t, r.resolveK0sConfigRef(context.Background(), kcp))
+	require.Equal(t, 7443, apiPort(kcp))
+	provider, _, err := unstructured.NestedString(kcp.Spec.K0sConfigSpec.K0s.Object, "spec", "network", "provider")
+	require.NoError(t, err)
+	require.Equal(t, "kuberouter", provider)
+
+	// The control planes without a reference keep their inline config
+	require.NoError(t, r.resolveK0sConfigRef(context.Background(), inline))
+	require.Nil(t, inline.Spec.K0sConfigSpec.K0s)
+}
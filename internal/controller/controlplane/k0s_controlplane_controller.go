@@ -20,7 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"net/http"
 
 	"github.com/google/uuid"
 	appsv1 "k8s.io/api/apps/v1"
@@ -31,25 +31,29 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/ptr"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	kubeadmbootstrapv1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1beta1"
 	capiutil "sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
 	"sigs.k8s.io/cluster-api/util/kubeconfig"
 	"sigs.k8s.io/cluster-api/util/secret"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
 	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
 	"github.com/k0sproject/k0smotron/internal/controller/util"
-)
-
-const (
-	defaultK0sSuffix  = "k0s.0"
-	defaultK0sVersion = "v1.27.9+k0s.0"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
 )
 
 type K0sController struct {
@@ -57,11 +61,24 @@ type K0sController struct {
 	Scheme     *runtime.Scheme
 	ClientSet  *kubernetes.Clientset
 	RESTConfig *rest.Config
+	// Recorder records the Events about the upgrades and the remediated machines.
+	Recorder record.EventRecorder
+	// MaxConcurrentReconciles is the maximum number of K0sControlPlanes reconciled at the same time.
+	MaxConcurrentReconciles int
+	// KubeClients caches the clients to the API of the child clusters. If nil, a client is created for every use.
+	KubeClients *util.KubeClientCache
+	// Shard selects the resources reconciled by the controller. All the resources are reconciled if nil.
+	Shard *sharding.Shard
+
+	// httpClient is used by the preflight checks, overridden in tests
+	httpClient *http.Client
 }
 
 // +kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=k0scontrolplanes/status,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=k0scontrolplanes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=k0scontrolplanes/finalizers,verbs=update
 // +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=*,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;clusters/status,verbs=get;list;watch;update;patch
 
@@ -79,18 +96,24 @@ func (c *K0sController) Reconcile(ctx context.Context, req ctrl.Request) (res ct
 	}
 
 	if !kcp.ObjectMeta.DeletionTimestamp.IsZero() {
-		log.Info("K0sControlPlane is being deleted, no action needed")
-		return ctrl.Result{}, nil
+		log.Info("K0sControlPlane is being deleted, removing the pre-terminate hooks of the machines")
+		return ctrl.Result{}, c.reconcileDelete(ctx, kcp)
 	}
 
-	if kcp.Spec.Version == "" {
-		kcp.Spec.Version = defaultK0sVersion
+	if !controllerutil.ContainsFinalizer(kcp, cpv1beta1.K0sControlPlaneFinalizer) {
+		patch := client.MergeFrom(kcp.DeepCopy())
+		controllerutil.AddFinalizer(kcp, cpv1beta1.K0sControlPlaneFinalizer)
+		if err := c.Patch(ctx, kcp, patch); err != nil {
+			return ctrl.Result{}, fmt.Errorf("error adding finalizer: %w", err)
+		}
 	}
 
-	if !strings.Contains(kcp.Spec.Version, "+k0s.") {
-		kcp.Spec.Version = fmt.Sprintf("%s+%s", kcp.Spec.Version, defaultK0sSuffix)
+	if kcp.Spec.Version == "" {
+		kcp.Spec.Version = kutil.DefaultK0sVersion
 	}
 
+	kcp.Spec.Version = kutil.K0sVersion(kcp.Spec.Version)
+
 	cluster, err := capiutil.GetOwnerCluster(ctx, c.Client, kcp.ObjectMeta)
 	if err != nil {
 		log.Error(err, "Failed to get owner cluster")
@@ -102,37 +125,88 @@ func (c *K0sController) Reconcile(ctx context.Context, req ctrl.Request) (res ct
 	}
 
 	log = log.WithValues("cluster", cluster.Name)
+	ctx = tracing.WithCluster(ctx, cluster.Name)
 
 	if annotations.IsPaused(cluster, kcp) {
 		log.Info("Reconciliation is paused for this object")
 		return ctrl.Result{}, nil
 	}
 
+	if err := c.resolveK0sConfigRef(ctx, kcp); err != nil {
+		log.Error(err, "Failed to resolve k0s config reference")
+		return ctrl.Result{}, err
+	}
+
+	defer func() {
+		if derr := c.updateStatus(ctx, cluster, kcp); derr != nil {
+			log.Error(derr, "Failed to update status")
+			if err == nil {
+				err = derr
+			}
+		}
+	}()
+
 	if err := c.ensureCertificates(ctx, cluster, kcp); err != nil {
+		conditions.MarkFalse(kcp, cpv1beta1.CertificatesAvailableCondition, cpv1beta1.CertificatesGenerationFailedReason, clusterv1.ConditionSeverityWarning, err.Error())
 		log.Error(err, "Failed to ensure certificates")
 		return ctrl.Result{}, err
 	}
+	conditions.MarkTrue(kcp, cpv1beta1.CertificatesAvailableCondition)
 
 	if err := c.reconcileTunneling(ctx, cluster, kcp); err != nil {
 		log.Error(err, "Failed to reconcile tunneling")
 		return ctrl.Result{}, err
 	}
 
+	if err := c.reconcileVIPEndpoint(ctx, cluster, kcp); err != nil {
+		log.Error(err, "Failed to reconcile VIP endpoint")
+		return ctrl.Result{}, err
+	}
+
+	if err := c.reconcileDynamicConfig(ctx, cluster, kcp); err != nil {
+		// Don't return error from dynamic config reconciliation, as the child cluster may not be available yet
+		log.Error(err, "Failed to reconcile dynamic config")
+	}
+
+	if err := c.reconcileCloudProviderCredentials(ctx, cluster, kcp); err != nil {
+		// Don't return error from the credentials reconciliation, as the child cluster may not be available yet
+		log.Error(err, "Failed to reconcile cloud provider credentials")
+	}
+
 	replicasToReport, err := c.reconcile(ctx, cluster, kcp)
 	if err != nil {
 		return res, err
 	}
 
+	if err := reconcileClusterResourceSet(ctx, c.Client, c.Scheme, cluster, kcp, kcp.Spec.ClusterResourceSet); err != nil {
+		return res, fmt.Errorf("error reconciling ClusterResourceSet: %w", err)
+	}
+
+	if err := reconcileHelmAddons(ctx, c.Client, cluster, kcp.Spec.HelmAddons); err != nil {
+		return res, fmt.Errorf("error labeling the cluster for the HelmChartProxies: %w", err)
+	}
+
+	if upgradingWorkers, err := c.reconcileWorkerUpgrade(ctx, cluster, kcp); err != nil {
+		// Don't return error from worker upgrade reconciliation, as the child cluster may not be available yet
+		log.Error(err, "Failed to reconcile worker upgrade")
+	} else if upgradingWorkers {
+		res = ctrl.Result{RequeueAfter: workerUpgradeRequeueAfter}
+	}
+
 	// TODO: We need to have bit more detailed status and conditions handling
 	kcp.Status.Ready = true
 	kcp.Status.ExternalManagedControlPlane = false
 	kcp.Status.Inititalized = true
 	kcp.Status.ControlPlaneReady = true
 	kcp.Status.Replicas = replicasToReport
-	kcp.Status.Version = kcp.Spec.Version
-	err = c.Status().Update(ctx, kcp)
+	kcp.Status.Selector = collections.ControlPlaneSelectorForCluster(cluster.Name).String()
+	kcp.Status.K0sVersion = kcp.Spec.Version
+	kcp.Status.Version, err = kutil.KubernetesVersion(kcp.Spec.Version)
+	if err != nil {
+		return res, err
+	}
 
-	return res, err
+	return res, nil
 
 }
 
@@ -210,92 +284,179 @@ func (c *K0sController) reconcile(ctx context.Context, cluster *clusterv1.Cluste
 }
 
 func (c *K0sController) reconcileMachines(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane) (int32, error) {
-	replicasToReport := kcp.Spec.Replicas
-	// TODO: Scale down machines if needed
-	if kcp.Status.Replicas > kcp.Spec.Replicas {
+	if kcp.IsSingleNode() && kcp.Spec.Replicas > 1 {
+		return kcp.Status.Replicas, fmt.Errorf("single node control plane supports only 1 replica, got %d", kcp.Spec.Replicas)
+	}
+
+	if err := c.reconcilePreTerminateHooks(ctx, cluster, kcp); err != nil {
+		return kcp.Status.Replicas, err
+	}
+
+	if err := c.reconcileUnhealthyMachines(ctx, cluster, kcp); err != nil {
+		return kcp.Status.Replicas, err
+	}
+
+	machines, err := c.getControlPlaneMachines(ctx, kcp)
+	if err != nil {
+		return kcp.Status.Replicas, fmt.Errorf("error getting control plane machines: %w", err)
+	}
+
+	k0sVersion := kcp.Status.K0sVersion
+	if k0sVersion == "" {
+		// The control planes created before the k0s version was reported have it in the version
+		k0sVersion = kcp.Status.Version
+	}
+	upgrading := k0sVersion != "" && kcp.Spec.Version != k0sVersion
+
+	outdated, err := c.machinesToRollout(ctx, kcp, machines)
+	if err != nil {
+		return int32(machines.Len()), err
+	}
+	if outdated.Len() > 0 {
+		if upgrading {
+			util.RecordEvent(c.Recorder, kcp, corev1.EventTypeNormal, util.UpgradeStartedReason,
+				"Upgrading from %s to %s, replacing %d control plane machines", k0sVersion, kcp.Spec.Version, outdated.Len())
+		}
+		return c.rolloutMachines(ctx, cluster, kcp, machines, outdated)
+	}
+
+	// Scale down the machines one at a time
+	if machines.Len() > int(kcp.Spec.Replicas) {
+		// Wait for the previous machine to be deleted to avoid etcd issues
+		if machines.Filter(collections.HasDeletionTimestamp).Len() > 0 {
+			return int32(machines.Len()), fmt.Errorf("waiting for previous machine to be deleted")
+		}
+
 		kubeClient, err := c.getKubeClient(ctx, cluster)
 		if err != nil {
-			return replicasToReport, fmt.Errorf("error getting cluster client set for deletion: %w", err)
+			return int32(machines.Len()), fmt.Errorf("error getting cluster client set for deletion: %w", err)
 		}
 
 		// Remove the last machine and report the new number of replicas to status
 		// On the next reconcile, the next machine will be removed
-		if kcp.Status.Replicas > kcp.Spec.Replicas {
-			// Wait for the previous machine to be deleted to avoid etcd issues
-			previousMachineName := machineName(kcp.Name, int(kcp.Status.Replicas))
-			exist, err := c.machineExist(ctx, previousMachineName, kcp)
-			if err != nil {
-				return kcp.Status.Replicas, fmt.Errorf("error checking machine existance: %w", err)
-			}
-			if exist {
-				return kcp.Status.Replicas, fmt.Errorf("waiting for previous machine to be deleted")
-			}
+		name := lastMachineName(machinesInMostPopulatedFailureDomain(cluster, machines, machines))
+		if err := c.removeControlPlaneMachine(ctx, name, cluster, kcp, kubeClient); err != nil {
+			return int32(machines.Len()), err
+		}
 
-			replicasToReport = kcp.Status.Replicas - 1
-			name := machineName(kcp.Name, int(kcp.Status.Replicas-1))
+		return int32(machines.Len() - 1), nil
+	}
 
-			if err := c.markChildControlNodeToLeave(ctx, name, kubeClient); err != nil {
-				return replicasToReport, fmt.Errorf("error marking controlnode to leave: %w", err)
+	if kcp.Spec.UpdateStrategy == "" || kcp.Spec.UpdateStrategy == cpv1beta1.UpdateInPlace {
+		if upgrading {
+			kubeClient, err := c.getKubeClient(ctx, cluster)
+			if err != nil {
+				return kcp.Spec.Replicas, fmt.Errorf("error getting cluster client set for machine update: %w", err)
 			}
 
-			if err := c.deleteBootstrapConfig(ctx, name, kcp); err != nil {
-				return replicasToReport, fmt.Errorf("error deleting machine from template: %w", err)
+			err = c.createAutopilotPlan(ctx, kcp, cluster, kubeClient)
+			if err != nil {
+				return kcp.Spec.Replicas, fmt.Errorf("error creating autopilot plan: %w", err)
 			}
+			util.RecordEvent(c.Recorder, kcp, corev1.EventTypeNormal, util.UpgradeStartedReason,
+				"Upgrading from %s to %s in place with an autopilot plan", k0sVersion, kcp.Spec.Version)
+		}
 
-			if err := c.deleteMachineFromTemplate(ctx, name, cluster, kcp); err != nil {
-				return replicasToReport, fmt.Errorf("error deleting machine from template: %w", err)
+		// Update the existing machines in place
+		for _, m := range machines.Filter(collections.Not(collections.HasDeletionTimestamp)) {
+			if err := c.createControlPlaneMachine(ctx, m.Name, cluster, kcp, m.Spec.FailureDomain); err != nil {
+				return kcp.Spec.Replicas, err
 			}
+		}
+	}
 
-			if err := c.deleteMachine(ctx, name, kcp); err != nil {
-				return replicasToReport, fmt.Errorf("error deleting machine from template: %w", err)
-			}
+	if err := c.createMachines(ctx, missingMachineNames(kcp, machines, int(kcp.Spec.Replicas)), cluster, kcp, machines); err != nil {
+		return kcp.Spec.Replicas, err
+	}
 
-			return replicasToReport, nil
-		}
+	return kcp.Spec.Replicas, nil
+}
+
+// createMachines creates the control plane machines with the given names. Each machine is placed in the failure
+// domain with the fewest of the given machines and the machines created before it.
+func (c *K0sController) createMachines(ctx context.Context, names []string, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane, machines collections.Machines) error {
+	if len(names) == 0 {
+		return nil
+	}
+	if err := c.reconcilePreflightChecks(ctx, kcp); err != nil {
+		return err
 	}
 
-	if kcp.Status.Version != "" && kcp.Spec.Version != kcp.Status.Version {
-		kubeClient, err := c.getKubeClient(ctx, cluster)
-		if err != nil {
-			return replicasToReport, fmt.Errorf("error getting cluster client set for machine update: %w", err)
+	machines = machines.Filter(collections.Not(collections.HasDeletionTimestamp))
+	for _, name := range names {
+		failureDomain := pickFailureDomain(cluster, machines)
+		if err := c.createControlPlaneMachine(ctx, name, cluster, kcp, failureDomain); err != nil {
+			return err
 		}
+		machines.Insert(&clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       clusterv1.MachineSpec{FailureDomain: failureDomain},
+		})
+	}
+	return nil
+}
 
-		err = c.createAutopilotPlan(ctx, kcp, cluster, kubeClient)
-		if err != nil {
-			return replicasToReport, fmt.Errorf("error creating autopilot plan: %w", err)
-		}
+// createControlPlaneMachine creates or updates the infrastructure machine, the machine and the bootstrap config
+// of the control plane machine.
+func (c *K0sController) createControlPlaneMachine(ctx context.Context, name string, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane, failureDomain *string) error {
+	machineFromTemplate, err := c.createMachineFromTemplate(ctx, name, cluster, kcp)
+	if err != nil {
+		return fmt.Errorf("error creating machine from template: %w", err)
 	}
 
-	for i := 0; i < int(kcp.Spec.Replicas); i++ {
-		name := machineName(kcp.Name, i)
+	infraRef := corev1.ObjectReference{
+		APIVersion: machineFromTemplate.GetAPIVersion(),
+		Kind:       machineFromTemplate.GetKind(),
+		Name:       machineFromTemplate.GetName(),
+		Namespace:  kcp.Namespace,
+	}
 
-		machineFromTemplate, err := c.createMachineFromTemplate(ctx, name, cluster, kcp)
-		if err != nil {
-			return replicasToReport, fmt.Errorf("error creating machine from template: %w", err)
-		}
+	machineTemplate, err := c.getMachineTemplate(ctx, kcp)
+	if err != nil {
+		return fmt.Errorf("error getting machine template: %w", err)
+	}
+	templateHash, err := machineTemplateHash(machineTemplate)
+	if err != nil {
+		return err
+	}
 
-		infraRef := corev1.ObjectReference{
-			APIVersion: machineFromTemplate.GetAPIVersion(),
-			Kind:       machineFromTemplate.GetKind(),
-			Name:       machineFromTemplate.GetName(),
-			Namespace:  kcp.Namespace,
-		}
+	machine, err := c.createMachine(ctx, name, cluster, kcp, infraRef, failureDomain, templateHash)
+	if err != nil {
+		return fmt.Errorf("error creating machine: %w", err)
+	}
 
-		machine, err := c.createMachine(ctx, name, cluster, kcp, infraRef)
-		if err != nil {
-			return replicasToReport, fmt.Errorf("error creating machine: %w", err)
-		}
+	err = c.createBootstrapConfig(ctx, name, cluster, kcp, machine)
+	if err != nil {
+		return fmt.Errorf("error creating bootstrap config: %w", err)
+	}
 
-		err = c.createBootstrapConfig(ctx, name, cluster, kcp, machine)
-		if err != nil {
-			return replicasToReport, fmt.Errorf("error creating bootstrap config: %w", err)
-		}
+	return nil
+}
+
+// reconcileDynamicConfig applies the k0s config to the ClusterConfig of the child cluster, so k0s reconciles the
+// dynamic fields on the running controllers without replacing the machines.
+func (c *K0sController) reconcileDynamicConfig(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane) error {
+	if !kutil.DynamicConfigEnabled(kcp.Spec.K0sConfigSpec.Args) {
+		return nil
 	}
 
-	return replicasToReport, nil
+	k0sConfig, err := clusterK0sConfig(kcp)
+	if err != nil {
+		return err
+	}
+	if k0sConfig == nil {
+		return nil
+	}
+
+	return kutil.ReconcileDynamicConfig(ctx, cluster, c.Client, kutil.DynamicConfig(k0sConfig))
 }
 
 func (c *K0sController) createBootstrapConfig(ctx context.Context, name string, _ *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane, machine *clusterv1.Machine) error {
+	k0sConfigSpec, err := machineK0sConfigSpec(kcp, name, machine.Spec.FailureDomain)
+	if err != nil {
+		return err
+	}
+
 	controllerConfig := bootstrapv1.K0sControllerConfig{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1",
@@ -315,7 +476,8 @@ func (c *K0sController) createBootstrapConfig(ctx context.Context, name string,
 		},
 		Spec: bootstrapv1.K0sControllerConfigSpec{
 			Version:       kcp.Spec.Version,
-			K0sConfigSpec: &kcp.Spec.K0sConfigSpec,
+			VIP:           kcp.Spec.VIP,
+			K0sConfigSpec: k0sConfigSpec,
 		},
 	}
 
@@ -406,7 +568,7 @@ token = ` + frpToken + `
 	}
 
 	_ = ctrl.SetControllerReference(kcp, &cm, c.Scheme)
-	err = c.Client.Patch(ctx, &cm, client.Apply, &client.PatchOptions{FieldManager: "k0s-bootstrap"})
+	err = util.ApplyConfigMap(ctx, c.Client, &cm, &client.PatchOptions{FieldManager: "k0s-bootstrap"})
 	if err != nil {
 		return fmt.Errorf("error creating ConfigMap: %w", err)
 	}
@@ -571,7 +733,29 @@ func machineName(base string, i int) string {
 
 // SetupWithManager sets up the controller with the Manager.
 func (c *K0sController) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &clusterv1.Machine{}, machineControlPlaneField, indexMachineControlPlane); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &cpv1beta1.K0sControlPlane{}, controlPlaneK0sConfigRefField, indexControlPlaneK0sConfigRef); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &cpv1beta1.K0sControlPlane{}, controlPlaneCloudProviderCredentialsField, indexControlPlaneCloudProviderCredentials); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&cpv1beta1.K0sControlPlane{}).
-		Complete(c)
+		Owns(&clusterv1.Machine{}).
+		// The objects of the tunneling server
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
+		// The shared k0s configs referenced by the control planes
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(c.requestsForK0sConfigRef)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(c.requestsForK0sConfigRef)).
+		// The cloud provider credentials copied to the clusters
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(c.requestsForCloudProviderCredentials)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: c.MaxConcurrentReconciles}).
+		Complete(c.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("K0sControlPlane", c)))
 }
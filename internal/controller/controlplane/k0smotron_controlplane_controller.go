@@ -19,15 +19,19 @@ package controlplane
 import (
 	"context"
 	"fmt"
-	"reflect"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -36,6 +40,7 @@ import (
 	"sigs.k8s.io/cluster-api/util"
 	capiutil "sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/conditions"
 	"sigs.k8s.io/cluster-api/util/secret"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -44,6 +49,10 @@ import (
 
 	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
 	kapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
 )
 
 type K0smotronController struct {
@@ -51,6 +60,10 @@ type K0smotronController struct {
 	Scheme     *runtime.Scheme
 	ClientSet  *kubernetes.Clientset
 	RESTConfig *rest.Config
+	// Recorder records the Events about the upgrades of the hosted control plane.
+	Recorder record.EventRecorder
+	// Shard selects the resources reconciled by the controller. All the resources are reconciled if nil.
+	Shard *sharding.Shard
 }
 
 type Scope struct {
@@ -63,6 +76,8 @@ type Scope struct {
 // +kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=k0smotroncontrolplanes,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=*,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;clusters/status,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 func (c *K0smotronController) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, err error) {
 
@@ -94,6 +109,7 @@ func (c *K0smotronController) Reconcile(ctx context.Context, req ctrl.Request) (
 	}
 
 	log = log.WithValues("cluster", cluster.Name)
+	ctx = tracing.WithCluster(ctx, cluster.Name)
 
 	if annotations.IsPaused(cluster, kcp) {
 		log.Info("Reconciliation is paused for this object")
@@ -118,12 +134,30 @@ func (c *K0smotronController) Reconcile(ctx context.Context, req ctrl.Request) (
 		}
 	}
 
+	if err := reconcileClusterResourceSet(ctx, c.Client, c.Scheme, cluster, kcp, kcp.Spec.ClusterResourceSet); err != nil {
+		return res, fmt.Errorf("error reconciling ClusterResourceSet: %w", err)
+	}
+
+	if err := reconcileHelmAddons(ctx, c.Client, cluster, kcp.Spec.HelmAddons); err != nil {
+		return res, fmt.Errorf("error labeling the cluster for the HelmChartProxies: %w", err)
+	}
+
 	// TODO: We need to have bit more detailed status and conditions handling
 	kcp.Status.Ready = ready
 	kcp.Status.ExternalManagedControlPlane = true
-	kcp.Status.Inititalized = true
+	// The control plane is initialized once the kubeconfig secret is written, so the ClusterResourceSets can be
+	// applied as soon as Cluster API marks the control plane initialized
+	kcp.Status.Inititalized = kcp.Status.Inititalized || ready
 	kcp.Status.ControlPlaneReady = true
-	err = c.Status().Update(ctx, kcp)
+	if err := c.updateReplicasStatus(ctx, cluster, kcp); err != nil {
+		return res, fmt.Errorf("error updating replicas status: %w", err)
+	}
+	if !conditions.IsTrue(kcp, cpv1beta1.ControlPlaneUpToDateCondition) && res.IsZero() {
+		// The rollout of the control plane pods doesn't trigger the reconciliation of the control plane
+		res = ctrl.Result{RequeueAfter: 10 * time.Second}
+	}
+	status := kcp.Status
+	err = kcutil.UpdateStatus(ctx, c.Client, kcp, func(kcp *cpv1beta1.K0smotronControlPlane) { kcp.Status = status })
 
 	return res, err
 
@@ -214,6 +248,15 @@ func (c *K0smotronController) reconcile(ctx context.Context, cluster *clusterv1.
 			return ctrl.Result{}, false, fmt.Errorf("failed to ensure certificates for K0smotronControlPlane %s/%s", kcp.Namespace, kcp.Name)
 		}
 	}
+	var foundCluster kapi.Cluster
+	err := c.Client.Get(ctx, types.NamespacedName{Name: cluster.Name, Namespace: cluster.Namespace}, &foundCluster)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, false, err
+	}
+	if err == nil && kcp.Spec.ExternalAddress == "" {
+		kcp.Spec.ExternalAddress = foundCluster.Spec.ExternalAddress
+	}
+
 	kcluster := kapi.Cluster{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: kapi.GroupVersion.String(),
@@ -237,30 +280,72 @@ func (c *K0smotronController) reconcile(ctx context.Context, cluster *clusterv1.
 		Spec: kcp.Spec,
 	}
 
-	var foundCluster kapi.Cluster
-	err := c.Client.Get(ctx, types.NamespacedName{Name: kcluster.Name, Namespace: kcluster.Namespace}, &foundCluster)
-	if err != nil && apierrors.IsNotFound(err) {
+	// Apply the changes of the spec, e.g. replicas, resources or persistence, to the running cluster
+	if apierrors.IsNotFound(err) || !equality.Semantic.DeepEqual(foundCluster.Spec, kcp.Spec) {
 		if err := c.Client.Patch(ctx, &kcluster, client.Apply, &client.PatchOptions{
 			FieldManager: "k0smotron",
 		}); err != nil {
 			return ctrl.Result{}, false, err
 		}
-	} else if err == nil {
-		if kcp.Spec.ExternalAddress == "" {
-			kcp.Spec.ExternalAddress = foundCluster.Spec.ExternalAddress
+		if foundCluster.Spec.Version != "" && foundCluster.Spec.Version != kcp.Spec.Version {
+			kcutil.RecordEvent(c.Recorder, kcp, corev1.EventTypeNormal, kcutil.UpgradeStartedReason,
+				"Upgrading the hosted control plane from %s to %s", foundCluster.Spec.Version, kcp.Spec.Version)
 		}
-		if !reflect.DeepEqual(foundCluster.Spec, kcp.Spec) {
-			err := c.Client.Patch(ctx, &kcluster, client.Apply, &client.PatchOptions{
-				FieldManager: "k0smotron",
-			})
+	}
 
-			return ctrl.Result{}, false, err
+	return ctrl.Result{}, foundCluster.Status.Ready, nil
+}
+
+// updateReplicasStatus sets the replicas of the control plane statefulset to the control plane status.
+// The control plane is up to date once the k0smotron Cluster has the current spec and all the control plane pods
+// are updated and ready. The version is reported once the control plane is up to date.
+func (c *K0smotronController) updateReplicasStatus(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0smotronControlPlane) error {
+	var kmc kapi.Cluster
+	err := c.Client.Get(ctx, capiutil.ObjectKey(cluster), &kmc)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	kcp.Status.ReconciliationStatus = kmc.Status.ReconciliationStatus
+
+	var sts appsv1.StatefulSet
+	err = c.Client.Get(ctx, types.NamespacedName{Name: kapi.GetStatefulSetName(cluster.Name), Namespace: cluster.Namespace}, &sts)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			conditions.MarkFalse(kcp, cpv1beta1.ControlPlaneUpToDateCondition, cpv1beta1.ControlPlaneUpdatingReason, clusterv1.ConditionSeverityInfo,
+				"Waiting for the control plane statefulset")
+			return nil
 		}
+		return err
+	}
 
-		return ctrl.Result{}, foundCluster.Status.Ready, nil
+	kcp.Status.Replicas = sts.Status.Replicas
+	kcp.Status.UpdatedReplicas = sts.Status.UpdatedReplicas
+	kcp.Status.ReadyReplicas = sts.Status.ReadyReplicas
+	kcp.Status.UnavailableReplicas = sts.Status.Replicas - sts.Status.ReadyReplicas
+
+	switch {
+	case !equality.Semantic.DeepEqual(kmc.Spec, kcp.Spec):
+		conditions.MarkFalse(kcp, cpv1beta1.ControlPlaneUpToDateCondition, cpv1beta1.ControlPlaneUpdatingReason, clusterv1.ConditionSeverityInfo,
+			"Applying the spec to the k0smotron cluster")
+	case sts.Status.ObservedGeneration != sts.Generation || ptr.Deref(sts.Spec.Replicas, 1) != kcp.Spec.Replicas ||
+		sts.Status.UpdatedReplicas != kcp.Spec.Replicas || sts.Status.ReadyReplicas != kcp.Spec.Replicas:
+		conditions.MarkFalse(kcp, cpv1beta1.ControlPlaneUpToDateCondition, cpv1beta1.ControlPlaneUpdatingReason, clusterv1.ConditionSeverityInfo,
+			"%d of %d control plane pods are up to date and ready", min(sts.Status.UpdatedReplicas, sts.Status.ReadyReplicas), kcp.Spec.Replicas)
+	default:
+		conditions.MarkTrue(kcp, cpv1beta1.ControlPlaneUpToDateCondition)
+		kcp.Status.K0sVersion = kcp.Spec.Version
+		if kcp.Spec.Version != "" {
+			kcp.Status.Version, err = kutil.KubernetesVersion(kcp.Spec.Version)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	return ctrl.Result{}, false, err
+	return nil
 }
 
 func (c *K0smotronController) ensureCertificates(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0smotronControlPlane) error {
@@ -273,5 +358,5 @@ func (c *K0smotronController) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&cpv1beta1.K0smotronControlPlane{}).
 		Owns(&kapi.Cluster{}, builder.MatchEveryOwner).
-		Complete(c)
+		Complete(c.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("K0smotronControlPlane", c)))
 }
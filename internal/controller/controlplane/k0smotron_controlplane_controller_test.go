@@ -0,0 +1,105 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestK0smotronController_updateReplicasStatus(t *testing.T) {
+	spec := kapi.ClusterSpec{Replicas: 3, Version: "v1.28.4-k0s.0"}
+
+	tests := []struct {
+		name           string
+		clusterSpec    kapi.ClusterSpec
+		status         appsv1.StatefulSetStatus
+		wantUpToDate   bool
+		wantVersion    string
+		wantK0sVersion string
+	}{
+		{
+			name:           "spec not applied",
+			clusterSpec:    kapi.ClusterSpec{Replicas: 1, Version: "v1.28.4-k0s.0"},
+			status:         appsv1.StatefulSetStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, ReadyReplicas: 3},
+			wantVersion:    "v1.27.2",
+			wantK0sVersion: "v1.27.2-k0s.0",
+		},
+		{
+			name:           "rolling out",
+			clusterSpec:    spec,
+			status:         appsv1.StatefulSetStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 1, ReadyReplicas: 2},
+			wantVersion:    "v1.27.2",
+			wantK0sVersion: "v1.27.2-k0s.0",
+		},
+		{
+			name:           "updated",
+			clusterSpec:    spec,
+			status:         appsv1.StatefulSetStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, ReadyReplicas: 3},
+			wantUpToDate:   true,
+			wantVersion:    "v1.28.4",
+			wantK0sVersion: "v1.28.4-k0s.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kmc := &kapi.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				Spec:       tt.clusterSpec,
+				Status:     kapi.ClusterStatus{ReconciliationStatus: "Reconciliation successful"},
+			}
+			sts := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: kapi.GetStatefulSetName("test"), Namespace: "default", Generation: 2},
+				Spec:       appsv1.StatefulSetSpec{Replicas: ptr.To(int32(3))},
+				Status:     tt.status,
+			}
+			scheme := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			require.NoError(t, kapi.AddToScheme(scheme))
+			c := &K0smotronController{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(kmc, sts).Build()}
+
+			cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+			kcp := &cpv1beta1.K0smotronControlPlane{
+				Spec:   spec,
+				Status: cpv1beta1.K0smotronControlPlaneStatus{Version: "v1.27.2", K0sVersion: "v1.27.2-k0s.0"},
+			}
+
+			require.NoError(t, c.updateReplicasStatus(context.Background(), cluster, kcp))
+			require.Equal(t, tt.status.Replicas, kcp.Status.Replicas)
+			require.Equal(t, tt.status.UpdatedReplicas, kcp.Status.UpdatedReplicas)
+			require.Equal(t, tt.status.ReadyReplicas, kcp.Status.ReadyReplicas)
+			require.Equal(t, tt.status.Replicas-tt.status.ReadyReplicas, kcp.Status.UnavailableReplicas)
+			require.Equal(t, "Reconciliation successful", kcp.Status.ReconciliationStatus)
+			require.Equal(t, tt.wantUpToDate, conditions.IsTrue(kcp, cpv1beta1.ControlPlaneUpToDateCondition))
+			require.Equal(t, tt.wantVersion, kcp.Status.Version)
+			require.Equal(t, tt.wantK0sVersion, kcp.Status.K0sVersion)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
+)
+
+// machineK0sConfigSpec returns the k0s config spec of the control plane machine with the machine overrides
+// matching the name and the failure domain of the machine applied.
+func machineK0sConfigSpec(kcp *cpv1beta1.K0sControlPlane, name string, failureDomain *string) (*bootstrapv1.K0sConfigSpec, error) {
+	spec := kcp.Spec.K0sConfigSpec.DeepCopy()
+
+	k0sConfig, err := clusterK0sConfig(kcp)
+	if err != nil {
+		return nil, err
+	}
+	spec.K0s = k0sConfig
+	if kcp.Spec.CloudProvider != nil {
+		spec.Args = withEnableCloudProvider(spec.Args)
+	}
+
+	for i, override := range kcp.Spec.MachineOverrides {
+		matches, err := machineOverrideMatches(override, name, failureDomain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid machine override %d: %w", i, err)
+		}
+		if !matches {
+			continue
+		}
+
+		spec.Args = append(spec.Args, override.Args...)
+
+		envNames := make([]string, 0, len(override.Env))
+		for envName := range override.Env {
+			envNames = append(envNames, envName)
+		}
+		sort.Strings(envNames)
+		for _, envName := range envNames {
+			spec.Args = append(spec.Args, fmt.Sprintf("--env=%s=%s", envName, override.Env[envName]))
+		}
+
+		if len(override.ExtraArgs) > 0 {
+			if spec.K0s == nil {
+				spec.K0s = newK0sConfig()
+			}
+			for arg, value := range override.ExtraArgs {
+				if err := unstructured.SetNestedField(spec.K0s.Object, value, "spec", "api", "extraArgs", arg); err != nil {
+					return nil, fmt.Errorf("error setting extra arg %s of machine override %d: %w", arg, i, err)
+				}
+			}
+		}
+	}
+
+	return spec, nil
+}
+
+// clusterK0sConfig returns a copy of the k0s config of the control plane with the worker profiles of the control
+// plane rendered into it.
+func clusterK0sConfig(kcp *cpv1beta1.K0sControlPlane) (*unstructured.Unstructured, error) {
+	var k0sConfig *unstructured.Unstructured
+	if kcp.Spec.K0sConfigSpec.K0s != nil {
+		k0sConfig = kcp.Spec.K0sConfigSpec.K0s.DeepCopy()
+	}
+	if len(kcp.Spec.WorkerProfiles) == 0 && kcp.Spec.CloudProvider == nil {
+		return k0sConfig, nil
+	}
+
+	if k0sConfig == nil {
+		k0sConfig = newK0sConfig()
+	}
+	if len(kcp.Spec.WorkerProfiles) > 0 {
+		if err := kutil.SetWorkerProfiles(k0sConfig.Object, kcp.Spec.WorkerProfiles); err != nil {
+			return nil, fmt.Errorf("error setting worker profiles: %w", err)
+		}
+	}
+	if kcp.Spec.CloudProvider != nil {
+		if err := setCloudProviderChart(k0sConfig, kcp.Spec.CloudProvider); err != nil {
+			return nil, fmt.Errorf("error setting the cloud provider chart: %w", err)
+		}
+	}
+	return k0sConfig, nil
+}
+
+func newK0sConfig() *unstructured.Unstructured {
+	k0sConfig := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	k0sConfig.SetAPIVersion("k0s.k0sproject.io/v1beta1")
+	k0sConfig.SetKind("ClusterConfig")
+	return k0sConfig
+}
+
+// machineOverrideMatches checks whether the machine override applies to the machine with the given name and
+// failure domain.
+func machineOverrideMatches(override cpv1beta1.MachineOverride, name string, failureDomain *string) (bool, error) {
+	if override.FailureDomain != "" && (failureDomain == nil || *failureDomain != override.FailureDomain) {
+		return false, nil
+	}
+	if override.NamePattern == "" {
+		return true, nil
+	}
+	return path.Match(override.NamePattern, name)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kmapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func Test_machineK0sConfigSpec(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{
+		Spec: cpv1beta1.K0sControlPlaneSpec{
+			K0sConfigSpec: bootstrapv1.K0sConfigSpec{
+				Args: []string{"--enable-worker"},
+				K0s: &unstructured.Unstructured{Object: map[string]interface{}{
+					"spec": map[string]interface{}{
+						"api": map[string]interface{}{"extraArgs": map[string]interface{}{"anonymous-auth": "true"}},
+					},
+				}},
+			},
+			MachineOverrides: []cpv1beta1.MachineOverride{
+				{
+					NamePattern: "cp-0",
+					Args:        []string{"--debug"},
+					Env:         map[string]string{"HTTPS_PROXY": "http://proxy:3128", "HTTP_PROXY": "http://proxy:3128"},
+				},
+				{
+					FailureDomain: "zone-b",
+					ExtraArgs:     map[string]string{"advertise-address": "10.0.1.10"},
+				},
+				{
+					NamePattern:   "cp-*",
+					FailureDomain: "zone-b",
+					Args:          []string{"--kubelet-extra-args=--node-ip=10.0.1.10"},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		machine       string
+		failureDomain *string
+		wantArgs      []string
+		wantExtraArgs map[string]interface{}
+	}{
+		{
+			name:          "no matching override",
+			machine:       "cp-1",
+			failureDomain: ptr.To("zone-a"),
+			wantArgs:      []string{"--enable-worker"},
+			wantExtraArgs: map[string]interface{}{"anonymous-auth": "true"},
+		},
+		{
+			name:          "name pattern",
+			machine:       "cp-0",
+			wantArgs:      []string{"--enable-worker", "--debug", "--env=HTTPS_PROXY=http://proxy:3128", "--env=HTTP_PROXY=http://proxy:3128"},
+			wantExtraArgs: map[string]interface{}{"anonymous-auth": "true"},
+		},
+		{
+			name:          "failure domain",
+			machine:       "cp-2",
+			failureDomain: ptr.To("zone-b"),
+			wantArgs:      []string{"--enable-worker", "--kubelet-extra-args=--node-ip=10.0.1.10"},
+			wantExtraArgs: map[string]interface{}{"anonymous-auth": "true", "advertise-address": "10.0.1.10"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := machineK0sConfigSpec(kcp, tt.machine, tt.failureDomain)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantArgs, spec.Args)
+
+			extraArgs, _, err := unstructured.NestedMap(spec.K0s.Object, "spec", "api", "extraArgs")
+			require.NoError(t, err)
+			require.Equal(t, tt.wantExtraArgs, extraArgs)
+		})
+	}
+
+	// The spec of the control plane is not modified
+	require.Equal(t, []string{"--enable-worker"}, kcp.Spec.K0sConfigSpec.Args)
+	extraArgs, _, _ := unstructured.NestedMap(kcp.Spec.K0sConfigSpec.K0s.Object, "spec", "api", "extraArgs")
+	require.Equal(t, map[string]interface{}{"anonymous-auth": "true"}, extraArgs)
+}
+
+func Test_machineK0sConfigSpec_invalidPattern(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{
+		Spec: cpv1beta1.K0sControlPlaneSpec{
+			MachineOverrides: []cpv1beta1.MachineOverride{{NamePattern: "cp-[", Args: []string{"--debug"}}},
+		},
+	}
+
+	_, err := machineK0sConfigSpec(kcp, "cp-0", nil)
+	require.Error(t, err)
+}
+
+func Test_machineK0sConfigSpec_workerProfiles(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{
+		Spec: cpv1beta1.K0sControlPlaneSpec{
+			WorkerProfiles: []kmapi.WorkerProfile{
+				{Name: "custom", Values: runtime.RawExtension{Raw: []byte(`{"maxPods":200}`)}},
+			},
+		},
+	}
+
+	spec, err := machineK0sConfigSpec(kcp, "cp-0", nil)
+	require.NoError(t, err)
+	require.Equal(t, "ClusterConfig", spec.K0s.GetKind())
+	workerProfiles, _, err := unstructured.NestedSlice(spec.K0s.Object, "spec", "workerProfiles")
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"name": "custom", "values": map[string]interface{}{"maxPods": float64(200)}},
+	}, workerProfiles)
+
+	// The k0s config of the control plane is not modified
+	require.Nil(t, kcp.Spec.K0sConfigSpec.K0s)
+}
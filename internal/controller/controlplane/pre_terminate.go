@@ -0,0 +1,120 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"fmt"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+// reconcilePreTerminateHooks removes the etcd members of the deleted machines waiting for the pre-terminate hook
+// and removes the hook once the member has left, so the infrastructure of the machine can be deleted.
+func (c *K0sController) reconcilePreTerminateHooks(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane) error {
+	machines, err := c.getControlPlaneMachines(ctx, kcp)
+	if err != nil {
+		return fmt.Errorf("error getting control plane machines: %w", err)
+	}
+
+	waiting := machines.Filter(collections.HasDeletionTimestamp, isWaitingForPreTerminateHook)
+	if waiting.Len() == 0 {
+		return nil
+	}
+
+	kubeClient, err := c.getKubeClient(ctx, cluster)
+	if err != nil {
+		return fmt.Errorf("error getting cluster client set for machine deletion: %w", err)
+	}
+
+	for _, m := range waiting {
+		log.FromContext(ctx).Info("Removing etcd member of deleted machine", "machine", m.Name)
+		left, err := c.leaveEtcdMember(ctx, kcp, m.Name, kubeClient)
+		if err != nil {
+			return fmt.Errorf("error removing etcd member %s: %w", m.Name, err)
+		}
+		if !left {
+			return fmt.Errorf("waiting for etcd member %s to leave the cluster", m.Name)
+		}
+		if err := c.removePreTerminateHook(ctx, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reconcileDelete removes the pre-terminate hooks of the control plane machines, as the etcd members don't need
+// to leave the cluster when the whole control plane is deleted, and removes the finalizer of the control plane.
+func (c *K0sController) reconcileDelete(ctx context.Context, kcp *cpv1beta1.K0sControlPlane) error {
+	if !controllerutil.ContainsFinalizer(kcp, cpv1beta1.K0sControlPlaneFinalizer) {
+		return nil
+	}
+
+	machines, err := c.getControlPlaneMachines(ctx, kcp)
+	if err != nil {
+		return fmt.Errorf("error getting control plane machines: %w", err)
+	}
+	for _, m := range machines {
+		if err := c.removePreTerminateHook(ctx, m); err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range kcp.OwnerReferences {
+		if ref.Kind == "Cluster" {
+			c.KubeClients.Invalidate(client.ObjectKey{Namespace: kcp.Namespace, Name: ref.Name})
+		}
+	}
+
+	patch := client.MergeFrom(kcp.DeepCopy())
+	controllerutil.RemoveFinalizer(kcp, cpv1beta1.K0sControlPlaneFinalizer)
+	if err := c.Patch(ctx, kcp, patch); err != nil {
+		return fmt.Errorf("error removing finalizer: %w", err)
+	}
+	return nil
+}
+
+func (c *K0sController) removePreTerminateHook(ctx context.Context, m *clusterv1.Machine) error {
+	if _, ok := m.Annotations[cpv1beta1.PreTerminateHookCleanupAnnotation]; !ok {
+		return nil
+	}
+
+	patch := client.MergeFrom(m.DeepCopy())
+	delete(m.Annotations, cpv1beta1.PreTerminateHookCleanupAnnotation)
+	if err := c.Patch(ctx, m, patch); err != nil {
+		return fmt.Errorf("error removing pre-terminate hook of machine %s: %w", m.Name, err)
+	}
+	return nil
+}
+
+// isWaitingForPreTerminateHook checks that the machine has the pre-terminate hook and the machine controller
+// waits for it, i.e. the node of the machine is drained.
+func isWaitingForPreTerminateHook(m *clusterv1.Machine) bool {
+	if _, ok := m.Annotations[cpv1beta1.PreTerminateHookCleanupAnnotation]; !ok {
+		return false
+	}
+	return conditions.IsFalse(m, clusterv1.PreTerminateDeleteHookSucceededCondition) &&
+		conditions.GetReason(m, clusterv1.PreTerminateDeleteHookSucceededCondition) == clusterv1.WaitingExternalHookReason
+}
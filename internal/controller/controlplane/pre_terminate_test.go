@@ -0,0 +1,105 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func Test_isWaitingForPreTerminateHook(t *testing.T) {
+	hook := map[string]string{cpv1beta1.PreTerminateHookCleanupAnnotation: ""}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		waiting     bool
+		want        bool
+	}{
+		{name: "waiting for hook", annotations: hook, waiting: true, want: true},
+		{name: "draining", annotations: hook, want: false},
+		{name: "no hook", waiting: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "cp-0", Annotations: tt.annotations}}
+			if tt.waiting {
+				conditions.MarkFalse(m, clusterv1.PreTerminateDeleteHookSucceededCondition, clusterv1.WaitingExternalHookReason, clusterv1.ConditionSeverityInfo, "")
+			}
+			require.Equal(t, tt.want, isWaitingForPreTerminateHook(m))
+		})
+	}
+}
+
+func TestK0sController_reconcileDelete(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "cp",
+			Namespace:  "default",
+			UID:        "kcp-uid",
+			Finalizers: []string{cpv1beta1.K0sControlPlaneFinalizer, "other"},
+		},
+	}
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "cp-0",
+			Namespace:   "default",
+			Labels:      map[string]string{clusterv1.MachineControlPlaneLabel: "true"},
+			Annotations: map[string]string{cpv1beta1.PreTerminateHookCleanupAnnotation: ""},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: cpv1beta1.GroupVersion.String(),
+				Kind:       "K0sControlPlane",
+				Name:       kcp.Name,
+				UID:        kcp.UID,
+				Controller: ptr.To(true),
+			}},
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, clusterv1.AddToScheme(scheme))
+	require.NoError(t, cpv1beta1.AddToScheme(scheme))
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(kcp, machine).
+		WithIndex(&clusterv1.Machine{}, machineControlPlaneField, indexMachineControlPlane).
+		Build()
+	c := &K0sController{Client: fakeClient, Scheme: scheme}
+
+	require.NoError(t, c.reconcileDelete(context.Background(), kcp))
+
+	var gotMachine clusterv1.Machine
+	require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKeyFromObject(machine), &gotMachine))
+	require.NotContains(t, gotMachine.Annotations, cpv1beta1.PreTerminateHookCleanupAnnotation)
+
+	var gotKCP cpv1beta1.K0sControlPlane
+	require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKeyFromObject(kcp), &gotKCP))
+	require.Equal(t, []string{"other"}, gotKCP.Finalizers)
+}
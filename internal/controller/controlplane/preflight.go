@@ -0,0 +1,108 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+// preflightCheckTimeout is the timeout of the requests made by the preflight checks.
+const preflightCheckTimeout = 10 * time.Second
+
+// reconcilePreflightChecks checks that the control plane machines can be bootstrapped before they are created, so
+// the failures are reported in the PreflightChecksSucceeded condition instead of machines never becoming ready.
+// The k0s binary is checked only if the machines download it from a URL, not if it's pre-installed.
+func (c *K0sController) reconcilePreflightChecks(ctx context.Context, kcp *cpv1beta1.K0sControlPlane) error {
+	if _, ok := kcp.Annotations[cpv1beta1.SkipPreflightChecksAnnotation]; ok {
+		conditions.Delete(kcp, cpv1beta1.PreflightChecksSucceededCondition)
+		return nil
+	}
+
+	if url := k0sDownloadURL(kcp); url != "" {
+		if err := checkDownloadURL(ctx, c.preflightHTTPClient(), url); err != nil {
+			conditions.MarkFalse(kcp, cpv1beta1.PreflightChecksSucceededCondition, cpv1beta1.PreflightCheckFailedReason, clusterv1.ConditionSeverityError,
+				"The k0s binary can't be downloaded from %s: %s. Set spec.k0sConfigSpec.downloadURL to a reachable mirror or pre-install k0s on the machines.", url, err)
+			return fmt.Errorf("preflight check failed: %w", err)
+		}
+	}
+
+	conditions.MarkTrue(kcp, cpv1beta1.PreflightChecksSucceededCondition)
+	return nil
+}
+
+func (c *K0sController) preflightHTTPClient() *http.Client {
+	if c.httpClient != nil {
+		return c.httpClient
+	}
+	return &http.Client{Timeout: preflightCheckTimeout}
+}
+
+// k0sDownloadURL returns the URL the machines download the k0s binary from, or an empty string if k0s is
+// pre-installed or downloaded from an OCI artifact. The install script downloads the binary from the GitHub
+// release of the version, the amd64 binary is checked as the architecture of the machines is not known.
+func k0sDownloadURL(kcp *cpv1beta1.K0sControlPlane) string {
+	switch {
+	case kcp.Spec.K0sConfigSpec.PreInstalledK0s, kcp.Spec.K0sConfigSpec.OCIArtifact != nil:
+		return ""
+	case kcp.Spec.K0sConfigSpec.DownloadURL != "":
+		return kcp.Spec.K0sConfigSpec.DownloadURL
+	default:
+		return fmt.Sprintf("https://github.com/k0sproject/k0s/releases/download/%s/k0s-%s-amd64", kcp.Spec.Version, kcp.Spec.Version)
+	}
+}
+
+// checkDownloadURL checks that the URL can be downloaded. Only the headers are requested, or the first byte if the
+// server doesn't allow HEAD requests.
+func checkDownloadURL(ctx context.Context, httpClient *http.Client, url string) error {
+	resp, err := doPreflightRequest(ctx, httpClient, http.MethodHead, url)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		if resp, err = doPreflightRequest(ctx, httpClient, http.MethodGet, url); err != nil {
+			return err
+		}
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func doPreflightRequest(ctx context.Context, httpClient *http.Client, method string, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp, resp.Body.Close()
+}
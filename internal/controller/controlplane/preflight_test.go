@@ -0,0 +1,112 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func TestK0sController_reconcilePreflightChecks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/k0s":
+			w.WriteHeader(http.StatusOK)
+		case "/k0s-no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusPartialContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		spec        bootstrapv1.K0sConfigSpec
+		wantErr     bool
+		wantStatus  corev1.ConditionStatus
+	}{
+		{
+			name:       "reachable download URL",
+			spec:       bootstrapv1.K0sConfigSpec{DownloadURL: server.URL + "/k0s"},
+			wantStatus: corev1.ConditionTrue,
+		},
+		{
+			name:       "download URL without HEAD requests",
+			spec:       bootstrapv1.K0sConfigSpec{DownloadURL: server.URL + "/k0s-no-head"},
+			wantStatus: corev1.ConditionTrue,
+		},
+		{
+			name:       "missing download URL",
+			spec:       bootstrapv1.K0sConfigSpec{DownloadURL: server.URL + "/missing"},
+			wantErr:    true,
+			wantStatus: corev1.ConditionFalse,
+		},
+		{
+			name:       "pre-installed k0s",
+			spec:       bootstrapv1.K0sConfigSpec{PreInstalledK0s: true, DownloadURL: server.URL + "/missing"},
+			wantStatus: corev1.ConditionTrue,
+		},
+		{
+			name:        "skipped checks",
+			annotations: map[string]string{cpv1beta1.SkipPreflightChecksAnnotation: ""},
+			spec:        bootstrapv1.K0sConfigSpec{DownloadURL: server.URL + "/missing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kcp := &cpv1beta1.K0sControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "cp", Annotations: tt.annotations},
+				Spec:       cpv1beta1.K0sControlPlaneSpec{Version: "v1.28.4+k0s.0", K0sConfigSpec: tt.spec},
+			}
+			c := &K0sController{httpClient: server.Client()}
+
+			err := c.reconcilePreflightChecks(context.Background(), kcp)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			if tt.wantStatus == "" {
+				require.False(t, conditions.Has(kcp, cpv1beta1.PreflightChecksSucceededCondition))
+				return
+			}
+			require.Equal(t, tt.wantStatus, conditions.Get(kcp, cpv1beta1.PreflightChecksSucceededCondition).Status)
+		})
+	}
+}
+
+func Test_k0sDownloadURL(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{Spec: cpv1beta1.K0sControlPlaneSpec{Version: "v1.28.4+k0s.0"}}
+	require.Equal(t, "https://github.com/k0sproject/k0s/releases/download/v1.28.4+k0s.0/k0s-v1.28.4+k0s.0-amd64", k0sDownloadURL(kcp))
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/patch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines;machines/status,verbs=get;list;watch;update;patch;delete
+
+// reconcileUnhealthyMachines replaces the control plane machines marked for remediation by a MachineHealthCheck.
+// Only one machine is remediated at a time and only if the etcd quorum is kept after the machine is removed.
+// The machine is recreated with the same name on the next reconciliation.
+func (c *K0sController) reconcileUnhealthyMachines(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane) error {
+	log := log.FromContext(ctx)
+
+	machines, err := c.getControlPlaneMachines(ctx, kcp)
+	if err != nil {
+		return fmt.Errorf("error getting control plane machines: %w", err)
+	}
+
+	m := machineToRemediate(machines)
+	if m == nil {
+		return nil
+	}
+
+	if machines.Filter(collections.HasDeletionTimestamp).Len() > 0 {
+		return fmt.Errorf("waiting for machine deletion to complete before remediating machine %s", m.Name)
+	}
+
+	onlyMachine := machines.Len() == 1 && kcp.Spec.AllowUnsafeNonHA
+	if !canSafelyRemediate(machines) && !onlyMachine {
+		log.Info("Remediation of the control plane machine is not allowed, etcd would lose quorum", "machine", m.Name)
+		return nil
+	}
+
+	retryCount, retryAfter, allowed := remediationRetry(kcp, m, time.Now())
+	if !allowed {
+		log.Info("Remediation of the control plane machine reached the retry limit", "machine", m.Name, "retries", kcp.Status.LastRemediation.RetryCount)
+		return c.markRemediationFailed(ctx, m, "Remediation reached the limit of %d retries", *kcp.Spec.Remediation.MaxRetries)
+	}
+	if retryAfter > 0 {
+		return fmt.Errorf("waiting %s before retrying remediation of machine %s", retryAfter.Round(time.Second), m.Name)
+	}
+
+	kubeClient, err := c.getKubeClient(ctx, cluster)
+	if err != nil {
+		return fmt.Errorf("error getting cluster client set for remediation: %w", err)
+	}
+	if onlyMachine {
+		// The only etcd member can't leave the cluster, the machine is removed without leaving
+		kubeClient = nil
+	}
+
+	patchHelper, err := patch.NewHelper(m, c.Client)
+	if err != nil {
+		return err
+	}
+	conditions.MarkFalse(m, clusterv1.MachineOwnerRemediatedCondition, clusterv1.RemediationInProgressReason, clusterv1.ConditionSeverityWarning, "")
+	if err := patchHelper.Patch(ctx, m); err != nil {
+		return fmt.Errorf("error patching machine %s: %w", m.Name, err)
+	}
+
+	kcp.Status.LastRemediation = &cpv1beta1.LastRemediationStatus{
+		Machine:    m.Name,
+		Timestamp:  metav1.Now(),
+		RetryCount: retryCount,
+	}
+
+	log.Info("Remediating unhealthy control plane machine", "machine", m.Name, "retry", retryCount)
+	if err := c.removeControlPlaneMachine(ctx, m.Name, cluster, kcp, kubeClient); err != nil {
+		return err
+	}
+	util.RecordEvent(c.Recorder, kcp, corev1.EventTypeNormal, util.MachineRemediatedReason,
+		"Removed unhealthy control plane machine %s, retry %d", m.Name, retryCount)
+
+	return fmt.Errorf("waiting for unhealthy machine %s to be replaced", m.Name)
+}
+
+// remediationRetry returns the retry count of the remediation of the machine, how long to wait before the
+// remediation can be retried and whether the retry limit allows the remediation. The machine is replaced
+// with the same name, so the remediation is a retry if the last remediated machine has the same name and
+// the remediation happened within the min healthy period.
+func remediationRetry(kcp *cpv1beta1.K0sControlPlane, m *clusterv1.Machine, now time.Time) (int32, time.Duration, bool) {
+	last := kcp.Status.LastRemediation
+	if last == nil || last.Machine != m.Name || now.Sub(last.Timestamp.Time) > kcp.GetMinHealthyPeriod() {
+		return 0, 0, true
+	}
+
+	retryCount := last.RetryCount + 1
+	if kcp.Spec.Remediation == nil {
+		return retryCount, 0, true
+	}
+	if kcp.Spec.Remediation.MaxRetries != nil && retryCount > *kcp.Spec.Remediation.MaxRetries {
+		return retryCount, 0, false
+	}
+
+	if retryAfter := last.Timestamp.Add(kcp.Spec.Remediation.RetryPeriod.Duration).Sub(now); retryAfter > 0 {
+		return retryCount, retryAfter, true
+	}
+	return retryCount, 0, true
+}
+
+// markRemediationFailed reports on the machine that the control plane gave up remediating it.
+func (c *K0sController) markRemediationFailed(ctx context.Context, m *clusterv1.Machine, messageFormat string, messageArgs ...interface{}) error {
+	if conditions.GetReason(m, clusterv1.MachineOwnerRemediatedCondition) == clusterv1.RemediationFailedReason {
+		return nil
+	}
+
+	patchHelper, err := patch.NewHelper(m, c.Client)
+	if err != nil {
+		return err
+	}
+	conditions.MarkFalse(m, clusterv1.MachineOwnerRemediatedCondition, clusterv1.RemediationFailedReason, clusterv1.ConditionSeverityError, messageFormat, messageArgs...)
+	if err := patchHelper.Patch(ctx, m); err != nil {
+		return fmt.Errorf("error patching machine %s: %w", m.Name, err)
+	}
+	return nil
+}
+
+// machineControlPlaneField is the field index of the name of the K0sControlPlane controlling a Machine.
+const machineControlPlaneField = "metadata.controller.k0sControlPlane"
+
+// getControlPlaneMachines returns the machines owned by the control plane.
+func (c *K0sController) getControlPlaneMachines(ctx context.Context, kcp *cpv1beta1.K0sControlPlane) (collections.Machines, error) {
+	var machineList clusterv1.MachineList
+	err := c.Client.List(ctx, &machineList, client.InNamespace(kcp.Namespace), client.MatchingFields{machineControlPlaneField: kcp.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	// Match the owner by UID, the index only holds the name of the owner
+	return collections.FromMachineList(&machineList).Filter(func(m *clusterv1.Machine) bool {
+		return metav1.IsControlledBy(m, kcp)
+	}), nil
+}
+
+// indexMachineControlPlane indexes the machines by the name of the K0sControlPlane controlling them.
+func indexMachineControlPlane(obj client.Object) []string {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.Kind != "K0sControlPlane" {
+		return nil
+	}
+	if gv, err := schema.ParseGroupVersion(owner.APIVersion); err != nil || gv.Group != cpv1beta1.GroupVersion.Group {
+		return nil
+	}
+	return []string{owner.Name}
+}
+
+// machineToRemediate returns the oldest machine flagged by a MachineHealthCheck for remediation by the owner.
+func machineToRemediate(machines collections.Machines) *clusterv1.Machine {
+	unhealthy := machines.Filter(collections.HasUnhealthyCondition, collections.Not(collections.HasDeletionTimestamp))
+	if unhealthy.Len() == 0 {
+		return nil
+	}
+	return unhealthy.Oldest()
+}
+
+// hasEtcdMembers checks whether the control plane machines are members of the etcd cluster managed by k0s.
+func hasEtcdMembers(kcp *cpv1beta1.K0sControlPlane) bool {
+	return kcp.Spec.K0sConfigSpec.ExternalEtcd == nil && !kcp.IsSingleNode()
+}
+
+// canSafelyRemediate checks that the healthy members still form the etcd quorum after one machine is removed.
+func canSafelyRemediate(machines collections.Machines) bool {
+	members := machines.Len() - 1
+	if members < 1 {
+		return false
+	}
+	healthy := machines.Filter(collections.Not(collections.HasUnhealthyCondition)).Len()
+	return healthy >= members/2+1
+}
+
+// leaveEtcdMember removes the etcd member of the machine from the cluster. The controlnode is marked to leave,
+// so the node runs `k0s etcd leave` on shutdown. On k0s versions managing the etcd members with the EtcdMember
+// resource, the member is removed by k0s and the function reports whether the member has already left.
+// The machines of a control plane using an external etcd cluster or the single node mode are not etcd members,
+// so there is nothing to leave.
+func (c *K0sController) leaveEtcdMember(ctx context.Context, kcp *cpv1beta1.K0sControlPlane, name string, clientset *kubernetes.Clientset) (bool, error) {
+	if !hasEtcdMembers(kcp) {
+		return true, nil
+	}
+	if err := c.markChildControlNodeToLeave(ctx, name, clientset); err != nil {
+		return false, err
+	}
+	if clientset == nil {
+		return true, nil
+	}
+
+	path := "/apis/etcd.k0sproject.io/v1beta1/etcdmembers/" + name
+	err := clientset.RESTClient().
+		Patch(types.MergePatchType).
+		AbsPath(path).
+		Body([]byte(`{"spec":{"leave":true}}`)).
+		Do(ctx).
+		Error()
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("error marking etcd member to leave: %w", err)
+	}
+
+	data, err := clientset.RESTClient().Get().AbsPath(path).DoRaw(ctx)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("error getting etcd member: %w", err)
+	}
+
+	return etcdMemberLeft(data)
+}
+
+func etcdMemberLeft(data []byte) (bool, error) {
+	var member struct {
+		Status struct {
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(data, &member); err != nil {
+		return false, fmt.Errorf("error decoding etcd member: %w", err)
+	}
+	for _, cond := range member.Status.Conditions {
+		if cond.Type == "Joined" {
+			return cond.Status == "False", nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func newTestMachine(name string, created int64, unhealthy bool) *clusterv1.Machine {
+	m := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.Unix(created, 0),
+		},
+	}
+	if unhealthy {
+		m.Status.Conditions = clusterv1.Conditions{
+			{Type: clusterv1.MachineHealthCheckSucceededCondition, Status: corev1.ConditionFalse},
+			{Type: clusterv1.MachineOwnerRemediatedCondition, Status: corev1.ConditionFalse},
+		}
+	}
+	return m
+}
+
+func Test_machineToRemediate(t *testing.T) {
+	tests := []struct {
+		name     string
+		machines collections.Machines
+		want     string
+	}{
+		{
+			name:     "all healthy",
+			machines: collections.FromMachines(newTestMachine("cp-0", 1, false), newTestMachine("cp-1", 2, false)),
+			want:     "",
+		},
+		{
+			name:     "oldest unhealthy",
+			machines: collections.FromMachines(newTestMachine("cp-0", 1, false), newTestMachine("cp-1", 3, true), newTestMachine("cp-2", 2, true)),
+			want:     "cp-2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := machineToRemediate(tt.machines)
+			if tt.want == "" {
+				require.Nil(t, m)
+				return
+			}
+			require.Equal(t, tt.want, m.Name)
+		})
+	}
+}
+
+func Test_hasEtcdMembers(t *testing.T) {
+	tests := []struct {
+		name string
+		spec bootstrapv1.K0sConfigSpec
+		want bool
+	}{
+		{
+			name: "local etcd",
+			want: true,
+		},
+		{
+			name: "external etcd",
+			spec: bootstrapv1.K0sConfigSpec{ExternalEtcd: &bootstrapv1.ExternalEtcd{Endpoints: []string{"https://etcd:2379"}}},
+			want: false,
+		},
+		{
+			name: "single node",
+			spec: bootstrapv1.K0sConfigSpec{Args: []string{"--single"}},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kcp := &cpv1beta1.K0sControlPlane{Spec: cpv1beta1.K0sControlPlaneSpec{K0sConfigSpec: tt.spec}}
+			require.Equal(t, tt.want, hasEtcdMembers(kcp))
+		})
+	}
+}
+
+func Test_canSafelyRemediate(t *testing.T) {
+	tests := []struct {
+		name     string
+		machines collections.Machines
+		want     bool
+	}{
+		{
+			name:     "single machine",
+			machines: collections.FromMachines(newTestMachine("cp-0", 1, true)),
+			want:     false,
+		},
+		{
+			name:     "one of three unhealthy",
+			machines: collections.FromMachines(newTestMachine("cp-0", 1, false), newTestMachine("cp-1", 2, false), newTestMachine("cp-2", 3, true)),
+			want:     true,
+		},
+		{
+			name:     "two of three unhealthy",
+			machines: collections.FromMachines(newTestMachine("cp-0", 1, false), newTestMachine("cp-1", 2, true), newTestMachine("cp-2", 3, true)),
+			want:     false,
+		},
+		{
+			name: "two of five unhealthy",
+			machines: collections.FromMachines(newTestMachine("cp-0", 1, false), newTestMachine("cp-1", 2, false), newTestMachine("cp-2", 3, false),
+				newTestMachine("cp-3", 4, true), newTestMachine("cp-4", 5, true)),
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, canSafelyRemediate(tt.machines))
+		})
+	}
+}
+
+func Test_etcdMemberLeft(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{
+			name: "no status",
+			data: `{"spec":{"leave":true}}`,
+			want: false,
+		},
+		{
+			name: "joined",
+			data: `{"status":{"conditions":[{"type":"Joined","status":"True"}]}}`,
+			want: false,
+		},
+		{
+			name: "left",
+			data: `{"status":{"conditions":[{"type":"Joined","status":"False"}]}}`,
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, err := etcdMemberLeft([]byte(tt.data))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, left)
+		})
+	}
+}
+
+func Test_remediationRetry(t *testing.T) {
+	now := time.Unix(10000, 0)
+	machine := newTestMachine("cp-0", 1, true)
+	lastRemediation := func(name string, ago time.Duration, retryCount int32) *cpv1beta1.LastRemediationStatus {
+		return &cpv1beta1.LastRemediationStatus{Machine: name, Timestamp: metav1.NewTime(now.Add(-ago)), RetryCount: retryCount}
+	}
+
+	tests := []struct {
+		name           string
+		remediation    *cpv1beta1.RemediationStrategy
+		last           *cpv1beta1.LastRemediationStatus
+		wantRetryCount int32
+		wantRetryAfter time.Duration
+		wantAllowed    bool
+	}{
+		{
+			name:        "first remediation",
+			wantAllowed: true,
+		},
+		{
+			name:        "another machine remediated last",
+			last:        lastRemediation("cp-1", time.Minute, 2),
+			remediation: &cpv1beta1.RemediationStrategy{MaxRetries: ptr.To(int32(1))},
+			wantAllowed: true,
+		},
+		{
+			name:        "machine was healthy for the min healthy period",
+			last:        lastRemediation("cp-0", 2*time.Hour, 2),
+			remediation: &cpv1beta1.RemediationStrategy{MaxRetries: ptr.To(int32(1))},
+			wantAllowed: true,
+		},
+		{
+			name:           "retry without limits",
+			last:           lastRemediation("cp-0", time.Minute, 2),
+			wantRetryCount: 3,
+			wantAllowed:    true,
+		},
+		{
+			name:           "retry within the limit",
+			last:           lastRemediation("cp-0", time.Minute, 0),
+			remediation:    &cpv1beta1.RemediationStrategy{MaxRetries: ptr.To(int32(1))},
+			wantRetryCount: 1,
+			wantAllowed:    true,
+		},
+		{
+			name:           "retry limit reached",
+			last:           lastRemediation("cp-0", time.Minute, 1),
+			remediation:    &cpv1beta1.RemediationStrategy{MaxRetries: ptr.To(int32(1))},
+			wantRetryCount: 2,
+			wantAllowed:    false,
+		},
+		{
+			name:           "retry period not elapsed",
+			last:           lastRemediation("cp-0", time.Minute, 0),
+			remediation:    &cpv1beta1.RemediationStrategy{RetryPeriod: metav1.Duration{Duration: 5 * time.Minute}},
+			wantRetryCount: 1,
+			wantRetryAfter: 4 * time.Minute,
+			wantAllowed:    true,
+		},
+		{
+			name:           "retry period elapsed",
+			last:           lastRemediation("cp-0", 10*time.Minute, 0),
+			remediation:    &cpv1beta1.RemediationStrategy{RetryPeriod: metav1.Duration{Duration: 5 * time.Minute}},
+			wantRetryCount: 1,
+			wantAllowed:    true,
+		},
+		{
+			name: "custom min healthy period",
+			last: lastRemediation("cp-0", 10*time.Minute, 1),
+			remediation: &cpv1beta1.RemediationStrategy{
+				MaxRetries:       ptr.To(int32(1)),
+				MinHealthyPeriod: &metav1.Duration{Duration: 5 * time.Minute},
+			},
+			wantAllowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kcp := &cpv1beta1.K0sControlPlane{
+				Spec:   cpv1beta1.K0sControlPlaneSpec{Remediation: tt.remediation},
+				Status: cpv1beta1.K0sControlPlaneStatus{LastRemediation: tt.last},
+			}
+			retryCount, retryAfter, allowed := remediationRetry(kcp, machine, now)
+			require.Equal(t, tt.wantRetryCount, retryCount)
+			require.Equal(t, tt.wantRetryAfter, retryAfter)
+			require.Equal(t, tt.wantAllowed, allowed)
+		})
+	}
+}
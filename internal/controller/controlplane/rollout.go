@@ -0,0 +1,324 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
+)
+
+// rolloutMachines replaces the outdated machines. An outdated machine is removed only if the API server and the
+// etcd cluster are healthy and enough machines stay available, limited by the max unavailable machines. Otherwise,
+// the replacement machines are created, limited by the max surge, and must become available before the next
+// outdated machine is removed.
+func (c *K0sController) rolloutMachines(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane, machines collections.Machines, outdated collections.Machines) (int32, error) {
+	log := log.FromContext(ctx)
+
+	if machines.Filter(collections.HasDeletionTimestamp).Len() > 0 {
+		return int32(machines.Len()), fmt.Errorf("waiting for previous machine to be deleted")
+	}
+
+	m := machinesInMostPopulatedFailureDomain(cluster, machines, outdated).Oldest()
+	if canRemoveMachine(kcp, machines, m) {
+		kubeClient, err := c.getKubeClient(ctx, cluster)
+		if err != nil {
+			return int32(machines.Len()), fmt.Errorf("error getting cluster client set for rollout: %w", err)
+		}
+		if err := checkRolloutHealth(ctx, kcp, kubeClient); err != nil {
+			return int32(machines.Len()), err
+		}
+		if machines.Len() == 1 {
+			if !kcp.Spec.AllowUnsafeNonHA {
+				return int32(machines.Len()), fmt.Errorf("refusing to remove the only control plane machine %s, set spec.allowUnsafeNonHA to replace it", m.Name)
+			}
+			// The only etcd member can't leave the cluster, the machine is removed without leaving
+			kubeClient = nil
+		}
+
+		log.Info("Removing outdated control plane machine", "machine", m.Name)
+		if err := c.removeControlPlaneMachine(ctx, m.Name, cluster, kcp, kubeClient); err != nil {
+			return int32(machines.Len()), err
+		}
+		return int32(machines.Len() - 1), nil
+	}
+
+	if n := machinesToCreate(kcp, machines, outdated); n > 0 {
+		names := missingMachineNames(kcp, machines, machines.Len()+n)
+		log.Info("Creating control plane machines for rollout", "machines", names)
+		// Spread the replacement machines across the failure domains along with the up-to-date machines
+		if err := c.createMachines(ctx, names, cluster, kcp, machines.Difference(outdated)); err != nil {
+			return int32(machines.Len()), err
+		}
+		return int32(machines.Len() + n), nil
+	}
+
+	if notAvailable := machines.Filter(collections.Not(isMachineAvailable(kcp.GetSoakTime()))); notAvailable.Len() > 0 {
+		return int32(machines.Len()), fmt.Errorf("waiting for machines %v to be available", notAvailable.Names())
+	}
+	return int32(machines.Len()), fmt.Errorf("waiting for machines to be available")
+}
+
+// canRemoveMachine checks that the number of available machines doesn't drop below the desired number of replicas
+// minus the max unavailable machines when the machine is removed.
+func canRemoveMachine(kcp *cpv1beta1.K0sControlPlane, machines collections.Machines, m *clusterv1.Machine) bool {
+	available := machines.Filter(isMachineAvailable(kcp.GetSoakTime()))
+	if _, ok := available[m.Name]; ok {
+		return available.Len()-1 >= int(kcp.Spec.Replicas-kcp.GetMaxUnavailable())
+	}
+	return available.Len() >= int(kcp.Spec.Replicas-kcp.GetMaxUnavailable())
+}
+
+// machinesToCreate returns the number of replacement machines to create, limited by the max surge and the number
+// of machines still to replace.
+func machinesToCreate(kcp *cpv1beta1.K0sControlPlane, machines collections.Machines, outdated collections.Machines) int {
+	n := int(kcp.Spec.Replicas+kcp.GetMaxSurge()) - machines.Len()
+	if missing := int(kcp.Spec.Replicas) - machines.Difference(outdated).Len(); missing < n {
+		n = missing
+	}
+	return max(n, 0)
+}
+
+// checkRolloutHealth checks that the API server is available and all the etcd members have joined the etcd cluster
+// before an outdated machine is removed.
+func checkRolloutHealth(ctx context.Context, kcp *cpv1beta1.K0sControlPlane, kubeClient *kubernetes.Clientset) error {
+	if _, err := kubeClient.Discovery().ServerVersion(); err != nil {
+		return fmt.Errorf("waiting for the API server to be available: %w", err)
+	}
+
+	if !hasEtcdMembers(kcp) {
+		return nil
+	}
+	data, err := kubeClient.RESTClient().Get().AbsPath("/apis/etcd.k0sproject.io/v1beta1/etcdmembers").DoRaw(ctx)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// The k0s version doesn't manage the etcd members with the EtcdMember resource
+			return nil
+		}
+		return fmt.Errorf("error getting etcd members: %w", err)
+	}
+	unhealthy, err := unhealthyEtcdMembers(data)
+	if err != nil {
+		return err
+	}
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("waiting for etcd members %s to join the cluster", strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
+
+// machinesToRollout returns the machines to replace. The machines created before the rollout restart annotation
+// are replaced with any update strategy, the machines with an outdated spec only with Recreate and RollingUpdate.
+func (c *K0sController) machinesToRollout(ctx context.Context, kcp *cpv1beta1.K0sControlPlane, machines collections.Machines) (collections.Machines, error) {
+	rollout, err := restartedMachines(kcp, machines)
+	if err != nil {
+		return nil, err
+	}
+
+	if kcp.Spec.UpdateStrategy != "" && kcp.Spec.UpdateStrategy != cpv1beta1.UpdateInPlace {
+		outdated, err := c.outdatedMachines(ctx, kcp, machines)
+		if err != nil {
+			return nil, fmt.Errorf("error checking outdated machines: %w", err)
+		}
+		rollout.Insert(outdated.UnsortedList()...)
+	}
+
+	return rollout, nil
+}
+
+// restartedMachines returns the machines created before the time set in the rollout restart annotation.
+func restartedMachines(kcp *cpv1beta1.K0sControlPlane, machines collections.Machines) (collections.Machines, error) {
+	value, ok := kcp.Annotations[cpv1beta1.RolloutRestartAnnotation]
+	if !ok {
+		return collections.New(), nil
+	}
+
+	restartAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %w", cpv1beta1.RolloutRestartAnnotation, value, err)
+	}
+
+	return machines.Filter(collections.Not(collections.HasDeletionTimestamp), func(m *clusterv1.Machine) bool {
+		return m.CreationTimestamp.Time.Before(restartAt)
+	}), nil
+}
+
+// outdatedMachines returns the machines that don't match the version, the machine template or the k0s config
+// of the control plane.
+func (c *K0sController) outdatedMachines(ctx context.Context, kcp *cpv1beta1.K0sControlPlane, machines collections.Machines) (collections.Machines, error) {
+	ver, err := semver.NewVersion(kcp.Spec.Version)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing version %q: %w", kcp.Spec.Version, err)
+	}
+	version := fmt.Sprintf("%d.%d.%d", ver.Major(), ver.Minor(), ver.Patch())
+
+	// The machines created before the template hash was introduced don't have the annotation, they are compared
+	// only by the name of the template
+	var templateHash string
+	machineTemplate, err := c.getMachineTemplate(ctx, kcp)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("error getting machine template: %w", err)
+	}
+	if err == nil {
+		if templateHash, err = machineTemplateHash(machineTemplate); err != nil {
+			return nil, err
+		}
+	}
+
+	outdated := collections.New()
+	for _, m := range machines.Filter(collections.Not(collections.HasDeletionTimestamp)) {
+		if hash, ok := m.Annotations[cpv1beta1.MachineTemplateHashAnnotation]; ok && templateHash != "" && hash != templateHash {
+			outdated.Insert(m)
+			continue
+		}
+
+		if m.Spec.Version == nil || strings.TrimPrefix(*m.Spec.Version, "v") != version {
+			outdated.Insert(m)
+			continue
+		}
+
+		upToDate, err := c.isK0sConfigUpToDate(ctx, kcp, m)
+		if err != nil {
+			return nil, err
+		}
+		if !upToDate {
+			outdated.Insert(m)
+			continue
+		}
+
+		infraMachine := new(unstructured.Unstructured)
+		infraMachine.SetAPIVersion(m.Spec.InfrastructureRef.APIVersion)
+		infraMachine.SetKind(m.Spec.InfrastructureRef.Kind)
+		err = c.Client.Get(ctx, client.ObjectKey{Name: m.Spec.InfrastructureRef.Name, Namespace: m.Namespace}, infraMachine)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("error getting infrastructure machine of %s: %w", m.Name, err)
+		}
+		if infraMachine.GetAnnotations()[clusterv1.TemplateClonedFromNameAnnotation] != kcp.Spec.MachineTemplate.InfrastructureRef.Name {
+			outdated.Insert(m)
+		}
+	}
+
+	return outdated, nil
+}
+
+// isK0sConfigUpToDate checks whether the k0s config the machine was bootstrapped with differs from the config of
+// the control plane, with the machine overrides applied, only in the fields applied to the running controllers
+// with the dynamic config. A change of the VIP rolls out the machines too.
+func (c *K0sController) isK0sConfigUpToDate(ctx context.Context, kcp *cpv1beta1.K0sControlPlane, m *clusterv1.Machine) (bool, error) {
+	if m.Spec.Bootstrap.ConfigRef == nil {
+		return true, nil
+	}
+
+	var config bootstrapv1.K0sControllerConfig
+	err := c.Client.Get(ctx, client.ObjectKey{Name: m.Spec.Bootstrap.ConfigRef.Name, Namespace: m.Namespace}, &config)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("error getting bootstrap config of %s: %w", m.Name, err)
+	}
+	if !equality.Semantic.DeepEqual(config.Spec.VIP, kcp.Spec.VIP) {
+		return false, nil
+	}
+	k0sConfigSpec, err := machineK0sConfigSpec(kcp, m.Name, m.Spec.FailureDomain)
+	if err != nil {
+		return false, err
+	}
+	if config.Spec.K0sConfigSpec == nil {
+		return k0sConfigSpec.K0s == nil, nil
+	}
+
+	return kutil.StaticConfigEqual(config.Spec.K0s, k0sConfigSpec.K0s, kutil.DynamicConfigEnabled(kcp.Spec.K0sConfigSpec.Args)), nil
+}
+
+// isMachineReady checks that the machine is bootstrapped and its infrastructure is provisioned.
+func isMachineReady(m *clusterv1.Machine) bool {
+	return m.Status.BootstrapReady && m.Status.InfrastructureReady
+}
+
+// isMachineAvailable returns a filter for the machines that have been ready for the soak time.
+func isMachineAvailable(soakTime time.Duration) collections.Func {
+	return func(m *clusterv1.Machine) bool {
+		if !isMachineReady(m) {
+			return false
+		}
+		if soakTime == 0 {
+			return true
+		}
+		if !conditions.IsTrue(m, clusterv1.ReadyCondition) {
+			return false
+		}
+		return time.Since(conditions.GetLastTransitionTime(m, clusterv1.ReadyCondition).Time) >= soakTime
+	}
+}
+
+// missingMachineNames returns the names of the machines to create to have the given number of machines.
+// The names use the lowest indexes not used by the existing machines.
+func missingMachineNames(kcp *cpv1beta1.K0sControlPlane, machines collections.Machines, replicas int) []string {
+	used := map[string]bool{}
+	for _, m := range machines {
+		used[m.Name] = true
+	}
+
+	var names []string
+	missing := replicas - machines.Filter(collections.Not(collections.HasDeletionTimestamp)).Len()
+	for i := 0; len(names) < missing; i++ {
+		name := machineName(kcp.Name, i)
+		if !used[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// lastMachineName returns the name of the machine with the highest index.
+func lastMachineName(machines collections.Machines) string {
+	names := machines.Names()
+	sort.Slice(names, func(i, j int) bool {
+		return machineIndex(names[i]) < machineIndex(names[j])
+	})
+	return names[len(names)-1]
+}
+
+func machineIndex(name string) int {
+	i, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	if err != nil {
+		return -1
+	}
+	return i
+}
@@ -0,0 +1,429 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func Test_missingMachineNames(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "cp"}}
+	deleting := newTestMachine("cp-1", 2, false)
+	deleting.DeletionTimestamp = ptr.To(metav1.Now())
+
+	tests := []struct {
+		name     string
+		machines collections.Machines
+		replicas int
+		want     []string
+	}{
+		{
+			name:     "no machines",
+			machines: collections.New(),
+			replicas: 3,
+			want:     []string{"cp-0", "cp-1", "cp-2"},
+		},
+		{
+			name:     "all machines exist",
+			machines: collections.FromMachines(newTestMachine("cp-0", 1, false), newTestMachine("cp-1", 2, false)),
+			replicas: 2,
+			want:     nil,
+		},
+		{
+			name:     "replaced machine",
+			machines: collections.FromMachines(newTestMachine("cp-1", 2, false), newTestMachine("cp-2", 3, false)),
+			replicas: 3,
+			want:     []string{"cp-0"},
+		},
+		{
+			name:     "machine being deleted",
+			machines: collections.FromMachines(newTestMachine("cp-0", 1, false), deleting),
+			replicas: 2,
+			want:     []string{"cp-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, missingMachineNames(kcp, tt.machines, tt.replicas))
+		})
+	}
+}
+
+func Test_lastMachineName(t *testing.T) {
+	machines := collections.FromMachines(newTestMachine("cp-2", 1, false), newTestMachine("cp-10", 2, false), newTestMachine("cp-9", 3, false))
+	require.Equal(t, "cp-10", lastMachineName(machines))
+}
+
+func TestK0sController_outdatedMachines(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "cp", Namespace: "default"},
+		Spec: cpv1beta1.K0sControlPlaneSpec{
+			Version: "v1.28.4+k0s.0",
+			MachineTemplate: &cpv1beta1.K0sControlPlaneMachineTemplate{
+				InfrastructureRef: corev1.ObjectReference{
+					APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+					Kind:       "DockerMachineTemplate",
+					Name:       "template-v2",
+					Namespace:  "default",
+				},
+			},
+			K0sConfigSpec: bootstrapv1.K0sConfigSpec{
+				Args: []string{"--enable-dynamic-config"},
+				K0s: &unstructured.Unstructured{Object: map[string]interface{}{
+					"spec": map[string]interface{}{
+						"api":        map[string]interface{}{"port": int64(6443)},
+						"extensions": map[string]interface{}{"helm": map[string]interface{}{"concurrencyLevel": int64(5)}},
+					},
+				}},
+			},
+		},
+	}
+
+	newBootstrapConfig := func(m *clusterv1.Machine, k0sConfig map[string]interface{}) *bootstrapv1.K0sControllerConfig {
+		k0sConfig["apiVersion"] = "k0s.k0sproject.io/v1beta1"
+		k0sConfig["kind"] = "ClusterConfig"
+		m.Spec.Bootstrap.ConfigRef = &corev1.ObjectReference{Name: m.Name}
+		return &bootstrapv1.K0sControllerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: m.Name, Namespace: "default"},
+			Spec: bootstrapv1.K0sControllerConfigSpec{
+				K0sConfigSpec: &bootstrapv1.K0sConfigSpec{K0s: &unstructured.Unstructured{Object: k0sConfig}},
+			},
+		}
+	}
+
+	newMachine := func(name, version, template string) (*clusterv1.Machine, *unstructured.Unstructured) {
+		infraMachine := &unstructured.Unstructured{}
+		infraMachine.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+		infraMachine.SetKind("DockerMachine")
+		infraMachine.SetName(name)
+		infraMachine.SetNamespace("default")
+		infraMachine.SetAnnotations(map[string]string{clusterv1.TemplateClonedFromNameAnnotation: template})
+
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: clusterv1.MachineSpec{
+				Version: ptr.To(version),
+				InfrastructureRef: corev1.ObjectReference{
+					APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+					Kind:       "DockerMachine",
+					Name:       name,
+				},
+			},
+		}, infraMachine
+	}
+
+	upToDate, upToDateInfra := newMachine("cp-0", "v1.28.4", "template-v2")
+	oldVersion, oldVersionInfra := newMachine("cp-1", "v1.27.9", "template-v2")
+	oldTemplate, oldTemplateInfra := newMachine("cp-2", "v1.28.4", "template-v1")
+	oldDynamicConfig, oldDynamicConfigInfra := newMachine("cp-3", "v1.28.4", "template-v2")
+	oldDynamicConfigBootstrap := newBootstrapConfig(oldDynamicConfig, map[string]interface{}{
+		"spec": map[string]interface{}{
+			"api": map[string]interface{}{"port": int64(6443)},
+		},
+	})
+	oldStaticConfig, oldStaticConfigInfra := newMachine("cp-4", "v1.28.4", "template-v2")
+	oldStaticConfigBootstrap := newBootstrapConfig(oldStaticConfig, map[string]interface{}{
+		"spec": map[string]interface{}{
+			"api":        map[string]interface{}{"port": int64(7443)},
+			"extensions": map[string]interface{}{"helm": map[string]interface{}{"concurrencyLevel": int64(5)}},
+		},
+	})
+
+	machineTemplate := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{"customImage": "kindest/node:v1.28.0"},
+			},
+		},
+	}}
+	machineTemplate.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+	machineTemplate.SetKind("DockerMachineTemplate")
+	machineTemplate.SetName("template-v2")
+	machineTemplate.SetNamespace("default")
+	templateHash, err := machineTemplateHash(machineTemplate)
+	require.NoError(t, err)
+
+	currentTemplateHash, currentTemplateHashInfra := newMachine("cp-5", "v1.28.4", "template-v2")
+	currentTemplateHash.Annotations = map[string]string{cpv1beta1.MachineTemplateHashAnnotation: templateHash}
+	changedTemplate, changedTemplateInfra := newMachine("cp-6", "v1.28.4", "template-v2")
+	changedTemplate.Annotations = map[string]string{cpv1beta1.MachineTemplateHashAnnotation: "outdated"}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clusterv1.AddToScheme(scheme))
+	require.NoError(t, bootstrapv1.AddToScheme(scheme))
+	c := &K0sController{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			upToDateInfra, oldVersionInfra, oldTemplateInfra, oldDynamicConfigInfra, oldStaticConfigInfra,
+			currentTemplateHashInfra, changedTemplateInfra, oldDynamicConfigBootstrap, oldStaticConfigBootstrap,
+			machineTemplate,
+		).Build(),
+	}
+
+	outdated, err := c.outdatedMachines(context.Background(), kcp, collections.FromMachines(
+		upToDate, oldVersion, oldTemplate, oldDynamicConfig, oldStaticConfig, currentTemplateHash, changedTemplate,
+	))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"cp-1", "cp-2", "cp-4", "cp-6"}, outdated.Names())
+
+	kcp.Spec.K0sConfigSpec.Args = nil
+	outdated, err = c.outdatedMachines(context.Background(), kcp, collections.FromMachines(upToDate, oldDynamicConfig))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"cp-3"}, outdated.Names())
+}
+
+func Test_restartedMachines(t *testing.T) {
+	restartAt := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	newMachine := func(name string, created time.Time) *clusterv1.Machine {
+		return &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)}}
+	}
+	machines := collections.FromMachines(
+		newMachine("cp-0", restartAt.Add(-time.Hour)),
+		newMachine("cp-1", restartAt.Add(time.Hour)),
+	)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:        "machines created before restart",
+			annotations: map[string]string{cpv1beta1.RolloutRestartAnnotation: restartAt.Format(time.RFC3339)},
+			want:        []string{"cp-0"},
+		},
+		{
+			name:        "invalid timestamp",
+			annotations: map[string]string{cpv1beta1.RolloutRestartAnnotation: "yesterday"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kcp := &cpv1beta1.K0sControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "cp", Annotations: tt.annotations}}
+			restarted, err := restartedMachines(kcp, machines)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.ElementsMatch(t, tt.want, restarted.Names())
+		})
+	}
+}
+
+func TestK0sControlPlane_GetMaxSurge(t *testing.T) {
+	tests := []struct {
+		name string
+		spec cpv1beta1.K0sControlPlaneSpec
+		want int32
+	}{
+		{
+			name: "in place",
+			spec: cpv1beta1.K0sControlPlaneSpec{UpdateStrategy: cpv1beta1.UpdateInPlace},
+			want: 0,
+		},
+		{
+			name: "recreate",
+			spec: cpv1beta1.K0sControlPlaneSpec{UpdateStrategy: cpv1beta1.UpdateRecreate},
+			want: 0,
+		},
+		{
+			name: "rolling update with default surge",
+			spec: cpv1beta1.K0sControlPlaneSpec{UpdateStrategy: cpv1beta1.UpdateRollingUpdate},
+			want: 1,
+		},
+		{
+			name: "rolling update without surge",
+			spec: cpv1beta1.K0sControlPlaneSpec{
+				UpdateStrategy: cpv1beta1.UpdateRollingUpdate,
+				RollingUpdate:  &cpv1beta1.RollingUpdate{MaxSurge: ptr.To(int32(0))},
+			},
+			want: 0,
+		},
+		{
+			name: "rolling update of single node",
+			spec: cpv1beta1.K0sControlPlaneSpec{
+				UpdateStrategy: cpv1beta1.UpdateRollingUpdate,
+				K0sConfigSpec:  bootstrapv1.K0sConfigSpec{Args: []string{"--single"}},
+			},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kcp := &cpv1beta1.K0sControlPlane{Spec: tt.spec}
+			require.Equal(t, tt.want, kcp.GetMaxSurge())
+		})
+	}
+}
+
+func TestK0sControlPlane_GetMaxUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		spec cpv1beta1.K0sControlPlaneSpec
+		want int32
+	}{
+		{
+			name: "recreate",
+			spec: cpv1beta1.K0sControlPlaneSpec{Replicas: 3, UpdateStrategy: cpv1beta1.UpdateRecreate},
+			want: 1,
+		},
+		{
+			name: "rolling update with default surge",
+			spec: cpv1beta1.K0sControlPlaneSpec{Replicas: 3, UpdateStrategy: cpv1beta1.UpdateRollingUpdate},
+			want: 0,
+		},
+		{
+			name: "rolling update without surge",
+			spec: cpv1beta1.K0sControlPlaneSpec{
+				Replicas:       3,
+				UpdateStrategy: cpv1beta1.UpdateRollingUpdate,
+				RollingUpdate:  &cpv1beta1.RollingUpdate{MaxSurge: ptr.To(int32(0))},
+			},
+			want: 1,
+		},
+		{
+			name: "limited by etcd quorum",
+			spec: cpv1beta1.K0sControlPlaneSpec{
+				Replicas:       5,
+				UpdateStrategy: cpv1beta1.UpdateRollingUpdate,
+				RollingUpdate:  &cpv1beta1.RollingUpdate{MaxUnavailable: ptr.To(int32(3))},
+			},
+			want: 2,
+		},
+		{
+			name: "single replica",
+			spec: cpv1beta1.K0sControlPlaneSpec{
+				Replicas:       1,
+				UpdateStrategy: cpv1beta1.UpdateRollingUpdate,
+				RollingUpdate:  &cpv1beta1.RollingUpdate{MaxUnavailable: ptr.To(int32(1))},
+			},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kcp := &cpv1beta1.K0sControlPlane{Spec: tt.spec}
+			require.Equal(t, tt.want, kcp.GetMaxUnavailable())
+		})
+	}
+}
+
+func Test_isMachineAvailable(t *testing.T) {
+	newMachine := func(ready bool, readySince time.Duration) *clusterv1.Machine {
+		m := &clusterv1.Machine{Status: clusterv1.MachineStatus{BootstrapReady: ready, InfrastructureReady: ready}}
+		if ready {
+			conditions.MarkTrue(m, clusterv1.ReadyCondition)
+			m.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-readySince))
+		}
+		return m
+	}
+
+	require.True(t, isMachineAvailable(0)(newMachine(true, 0)))
+	require.False(t, isMachineAvailable(0)(newMachine(false, 0)))
+	require.False(t, isMachineAvailable(10*time.Minute)(newMachine(true, time.Minute)))
+	require.True(t, isMachineAvailable(10*time.Minute)(newMachine(true, time.Hour)))
+}
+
+func Test_canRemoveMachine_machinesToCreate(t *testing.T) {
+	newMachine := func(name string, ready bool) *clusterv1.Machine {
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     clusterv1.MachineStatus{BootstrapReady: ready, InfrastructureReady: ready},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		spec       cpv1beta1.K0sControlPlaneSpec
+		upToDate   []*clusterv1.Machine
+		outdated   []*clusterv1.Machine
+		wantRemove bool
+		wantCreate int
+	}{
+		{
+			name:       "surge creates the replacement first",
+			spec:       cpv1beta1.K0sControlPlaneSpec{Replicas: 3, UpdateStrategy: cpv1beta1.UpdateRollingUpdate},
+			outdated:   []*clusterv1.Machine{newMachine("cp-0", true), newMachine("cp-1", true), newMachine("cp-2", true)},
+			wantCreate: 1,
+		},
+		{
+			name:     "surge waits for the replacement to be available",
+			spec:     cpv1beta1.K0sControlPlaneSpec{Replicas: 3, UpdateStrategy: cpv1beta1.UpdateRollingUpdate},
+			upToDate: []*clusterv1.Machine{newMachine("cp-3", false)},
+			outdated: []*clusterv1.Machine{newMachine("cp-0", true), newMachine("cp-1", true), newMachine("cp-2", true)},
+		},
+		{
+			name:       "surge removes the outdated machine",
+			spec:       cpv1beta1.K0sControlPlaneSpec{Replicas: 3, UpdateStrategy: cpv1beta1.UpdateRollingUpdate},
+			upToDate:   []*clusterv1.Machine{newMachine("cp-3", true)},
+			outdated:   []*clusterv1.Machine{newMachine("cp-0", true), newMachine("cp-1", true), newMachine("cp-2", true)},
+			wantRemove: true,
+		},
+		{
+			name: "max unavailable removes machines first",
+			spec: cpv1beta1.K0sControlPlaneSpec{
+				Replicas:       5,
+				UpdateStrategy: cpv1beta1.UpdateRollingUpdate,
+				RollingUpdate:  &cpv1beta1.RollingUpdate{MaxSurge: ptr.To(int32(0)), MaxUnavailable: ptr.To(int32(2))},
+			},
+			outdated:   []*clusterv1.Machine{newMachine("cp-0", true), newMachine("cp-1", true), newMachine("cp-2", true), newMachine("cp-3", true)},
+			wantRemove: true,
+			wantCreate: 1,
+		},
+		{
+			name: "max unavailable creates the replacements",
+			spec: cpv1beta1.K0sControlPlaneSpec{
+				Replicas:       5,
+				UpdateStrategy: cpv1beta1.UpdateRollingUpdate,
+				RollingUpdate:  &cpv1beta1.RollingUpdate{MaxSurge: ptr.To(int32(0)), MaxUnavailable: ptr.To(int32(2))},
+			},
+			outdated:   []*clusterv1.Machine{newMachine("cp-0", true), newMachine("cp-1", true), newMachine("cp-2", true)},
+			wantCreate: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kcp := &cpv1beta1.K0sControlPlane{Spec: tt.spec}
+			outdated := collections.FromMachines(tt.outdated...)
+			machines := collections.FromMachines(append(tt.upToDate, tt.outdated...)...)
+
+			require.Equal(t, tt.wantRemove, canRemoveMachine(kcp, machines, outdated.Oldest()))
+			require.Equal(t, tt.wantCreate, machinesToCreate(kcp, machines, outdated))
+		})
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+// updateStatus sets the replicas and the conditions of the control plane and updates its status.
+func (c *K0sController) updateStatus(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane) error {
+	machines, err := c.getControlPlaneMachines(ctx, kcp)
+	if err != nil {
+		return fmt.Errorf("error getting control plane machines: %w", err)
+	}
+	machines = machines.Filter(collections.Not(collections.HasDeletionTimestamp))
+
+	outdated, err := c.machinesToRollout(ctx, kcp, machines)
+	if err != nil {
+		return err
+	}
+
+	ready := machines.Filter(isMachineReady).Len()
+	kcp.Status.UpdatedReplicas = int32(machines.Len() - outdated.Len())
+	kcp.Status.ReadyReplicas = int32(ready)
+	kcp.Status.UnavailableReplicas = int32(machines.Len() - ready)
+
+	kcp.Status.MachineFailureDomains = nil
+	for _, m := range machines {
+		if m.Spec.FailureDomain == nil {
+			continue
+		}
+		if kcp.Status.MachineFailureDomains == nil {
+			kcp.Status.MachineFailureDomains = map[string]string{}
+		}
+		kcp.Status.MachineFailureDomains[m.Name] = *m.Spec.FailureDomain
+	}
+
+	if machines.Len() > 0 {
+		conditions.SetAggregate(kcp, cpv1beta1.MachinesReadyCondition, machines.ConditionGetters(), conditions.AddSourceRef(), conditions.WithStepCounterIf(false))
+	}
+
+	if outdated.Len() > 0 {
+		conditions.MarkFalse(kcp, cpv1beta1.MachinesSpecUpToDateCondition, cpv1beta1.RollingUpdateInProgressReason, clusterv1.ConditionSeverityWarning,
+			"Rolling %d replicas with outdated spec (%d replicas up to date)", outdated.Len(), machines.Len()-outdated.Len())
+	} else {
+		conditions.MarkTrue(kcp, cpv1beta1.MachinesSpecUpToDateCondition)
+	}
+
+	switch {
+	case machines.Len() < int(kcp.Spec.Replicas):
+		conditions.MarkFalse(kcp, cpv1beta1.ResizedCondition, cpv1beta1.ScalingUpReason, clusterv1.ConditionSeverityInfo,
+			"Scaling up control plane to %d replicas (actual %d)", kcp.Spec.Replicas, machines.Len())
+	case machines.Len() > int(kcp.Spec.Replicas):
+		conditions.MarkFalse(kcp, cpv1beta1.ResizedCondition, cpv1beta1.ScalingDownReason, clusterv1.ConditionSeverityInfo,
+			"Scaling down control plane to %d replicas (actual %d)", kcp.Spec.Replicas, machines.Len())
+	default:
+		conditions.MarkTrue(kcp, cpv1beta1.ResizedCondition)
+	}
+
+	c.reconcileAvailability(ctx, cluster, kcp)
+	c.reconcileCertificatesExpiry(ctx, cluster, kcp, machines)
+
+	conditions.SetSummary(kcp, conditions.WithConditions(
+		cpv1beta1.AvailableCondition,
+		cpv1beta1.CertificatesAvailableCondition,
+		cpv1beta1.MachinesReadyCondition,
+		cpv1beta1.MachinesSpecUpToDateCondition,
+		cpv1beta1.ResizedCondition,
+		cpv1beta1.EtcdClusterHealthyCondition,
+		cpv1beta1.PreflightChecksSucceededCondition,
+	))
+
+	status := kcp.Status
+	return util.UpdateStatus(ctx, c.Client, kcp, func(kcp *cpv1beta1.K0sControlPlane) { kcp.Status = status })
+}
+
+// reconcileAvailability sets the Available and EtcdClusterHealthy conditions by inspecting the child cluster.
+// The etcd cluster is not inspected when the control plane machines are not etcd members.
+func (c *K0sController) reconcileAvailability(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane) {
+	if !hasEtcdMembers(kcp) {
+		defer conditions.Delete(kcp, cpv1beta1.EtcdClusterHealthyCondition)
+	}
+
+	kubeClient, err := c.getKubeClient(ctx, cluster)
+	if err != nil {
+		conditions.MarkFalse(kcp, cpv1beta1.AvailableCondition, cpv1beta1.WaitingForKubeconfigReason, clusterv1.ConditionSeverityInfo, "")
+		conditions.MarkUnknown(kcp, cpv1beta1.EtcdClusterHealthyCondition, cpv1beta1.EtcdClusterUnknownReason, "Waiting for the admin kubeconfig")
+		return
+	}
+
+	if _, err := kubeClient.Discovery().ServerVersion(); err != nil {
+		// The connections of the client may be stale, e.g. after the API server moved
+		c.KubeClients.Invalidate(client.ObjectKeyFromObject(cluster))
+		conditions.MarkFalse(kcp, cpv1beta1.AvailableCondition, cpv1beta1.APIServerUnreachableReason, clusterv1.ConditionSeverityWarning, err.Error())
+		conditions.MarkUnknown(kcp, cpv1beta1.EtcdClusterHealthyCondition, cpv1beta1.EtcdClusterUnknownReason, "The API server is not reachable")
+		return
+	}
+	conditions.MarkTrue(kcp, cpv1beta1.AvailableCondition)
+
+	if !hasEtcdMembers(kcp) {
+		return
+	}
+
+	data, err := kubeClient.RESTClient().Get().AbsPath("/apis/etcd.k0sproject.io/v1beta1/etcdmembers").DoRaw(ctx)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			conditions.MarkUnknown(kcp, cpv1beta1.EtcdClusterHealthyCondition, cpv1beta1.EtcdClusterUnknownReason, "The EtcdMember API is not available")
+			return
+		}
+		conditions.MarkUnknown(kcp, cpv1beta1.EtcdClusterHealthyCondition, cpv1beta1.EtcdClusterUnknownReason, err.Error())
+		return
+	}
+
+	unhealthy, err := unhealthyEtcdMembers(data)
+	if err != nil {
+		conditions.MarkUnknown(kcp, cpv1beta1.EtcdClusterHealthyCondition, cpv1beta1.EtcdClusterUnknownReason, err.Error())
+		return
+	}
+	if len(unhealthy) > 0 {
+		conditions.MarkFalse(kcp, cpv1beta1.EtcdClusterHealthyCondition, cpv1beta1.EtcdClusterUnhealthyReason, clusterv1.ConditionSeverityError,
+			"Etcd members %s have not joined the cluster", strings.Join(unhealthy, ", "))
+		return
+	}
+	conditions.MarkTrue(kcp, cpv1beta1.EtcdClusterHealthyCondition)
+}
+
+// unhealthyEtcdMembers returns the names of the etcd members that have not joined the etcd cluster
+// and are not leaving it.
+func unhealthyEtcdMembers(data []byte) ([]string, error) {
+	var members struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				Leave bool `json:"leave"`
+			} `json:"spec"`
+			Status struct {
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("error decoding etcd members: %w", err)
+	}
+
+	var unhealthy []string
+	for _, m := range members.Items {
+		if m.Spec.Leave {
+			continue
+		}
+		joined := false
+		for _, cond := range m.Status.Conditions {
+			if cond.Type == "Joined" {
+				joined = cond.Status == "True"
+			}
+		}
+		if !joined {
+			unhealthy = append(unhealthy, m.Metadata.Name)
+		}
+	}
+	return unhealthy, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func TestK0sController_updateStatus(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "cp", Namespace: "default", UID: "kcp-uid"},
+		Spec:       cpv1beta1.K0sControlPlaneSpec{Replicas: 3, Version: "v1.28.4+k0s.0"},
+	}
+	newMachine := func(name string, ready bool) *clusterv1.Machine {
+		m := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels:    map[string]string{clusterv1.MachineControlPlaneLabel: "true"},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: cpv1beta1.GroupVersion.String(),
+					Kind:       "K0sControlPlane",
+					Name:       kcp.Name,
+					UID:        kcp.UID,
+					Controller: ptr.To(true),
+				}},
+			},
+			Status: clusterv1.MachineStatus{BootstrapReady: ready, InfrastructureReady: ready},
+		}
+		if ready {
+			conditions.MarkTrue(m, clusterv1.ReadyCondition)
+		} else {
+			conditions.MarkFalse(m, clusterv1.ReadyCondition, clusterv1.WaitingForInfrastructureFallbackReason, clusterv1.ConditionSeverityInfo, "")
+		}
+		return m
+	}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, clusterv1.AddToScheme(scheme))
+	require.NoError(t, cpv1beta1.AddToScheme(scheme))
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(kcp, withFailureDomain(newMachine("cp-0", true), "az-1"), newMachine("cp-1", false)).
+		WithStatusSubresource(kcp).
+		WithIndex(&clusterv1.Machine{}, machineControlPlaneField, indexMachineControlPlane).
+		Build()
+	c := &K0sController{Client: fakeClient, Scheme: scheme}
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	require.NoError(t, c.updateStatus(context.Background(), cluster, kcp))
+
+	var got cpv1beta1.K0sControlPlane
+	require.NoError(t, fakeClient.Get(context.Background(), client.ObjectKeyFromObject(kcp), &got))
+	require.Equal(t, int32(2), got.Status.UpdatedReplicas)
+	require.Equal(t, int32(1), got.Status.ReadyReplicas)
+	require.Equal(t, int32(1), got.Status.UnavailableReplicas)
+	require.Equal(t, map[string]string{"cp-0": "az-1"}, got.Status.MachineFailureDomains)
+	require.True(t, conditions.IsFalse(&got, cpv1beta1.ResizedCondition))
+	require.Equal(t, cpv1beta1.ScalingUpReason, conditions.GetReason(&got, cpv1beta1.ResizedCondition))
+	require.True(t, conditions.IsFalse(&got, cpv1beta1.MachinesReadyCondition))
+	require.True(t, conditions.IsTrue(&got, cpv1beta1.MachinesSpecUpToDateCondition))
+	require.Equal(t, cpv1beta1.WaitingForKubeconfigReason, conditions.GetReason(&got, cpv1beta1.AvailableCondition))
+	require.True(t, conditions.IsUnknown(&got, cpv1beta1.EtcdClusterHealthyCondition))
+	require.True(t, conditions.IsFalse(&got, clusterv1.ReadyCondition))
+}
+
+func Test_unhealthyEtcdMembers(t *testing.T) {
+	data := `{"items":[
+		{"metadata":{"name":"cp-0"},"status":{"conditions":[{"type":"Joined","status":"True"}]}},
+		{"metadata":{"name":"cp-1"},"status":{"conditions":[{"type":"Joined","status":"False"}]}},
+		{"metadata":{"name":"cp-2"},"spec":{"leave":true},"status":{"conditions":[{"type":"Joined","status":"False"}]}},
+		{"metadata":{"name":"cp-3"}}
+	]}`
+	unhealthy, err := unhealthyEtcdMembers([]byte(data))
+	require.NoError(t, err)
+	require.Equal(t, []string{"cp-1", "cp-3"}, unhealthy)
+}
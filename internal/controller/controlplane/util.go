@@ -2,7 +2,11 @@ package controlplane
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
@@ -18,8 +22,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/tracing"
 )
 
+// kubeClientTimeout is the timeout of the requests to the child cluster API.
+const kubeClientTimeout = 10 * time.Second
+
 func (c *K0sController) getMachineTemplate(ctx context.Context, kcp *cpv1beta1.K0sControlPlane) (*unstructured.Unstructured, error) {
 	infRef := kcp.Spec.MachineTemplate.InfrastructureRef
 
@@ -37,6 +46,20 @@ func (c *K0sController) getMachineTemplate(ctx context.Context, kcp *cpv1beta1.K
 	return machineTemplate, nil
 }
 
+// machineTemplateHash returns the hash of the machine template of the infrastructure machine template.
+func machineTemplateHash(machineTemplate *unstructured.Unstructured) (string, error) {
+	template, _, err := unstructured.NestedMap(machineTemplate.UnstructuredContent(), "spec", "template")
+	if err != nil {
+		return "", fmt.Errorf("error getting spec.template map on %v %q: %w", machineTemplate.GroupVersionKind(), machineTemplate.GetName(), err)
+	}
+
+	data, err := json.Marshal(template)
+	if err != nil {
+		return "", fmt.Errorf("error encoding spec.template of %v %q: %w", machineTemplate.GroupVersionKind(), machineTemplate.GetName(), err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
 func (c *K0sController) generateKubeconfig(ctx context.Context, cluster *clusterv1.Cluster, endpoint string) (*api.Config, error) {
 	clusterName := util.ObjectKey(cluster)
 	clusterCA, err := secret.GetFromNamespacedName(ctx, c.Client, clusterName, secret.ClusterCA)
@@ -90,19 +113,26 @@ func (c *K0sController) createKubeconfigSecret(ctx context.Context, cfg *api.Con
 	return c.Create(ctx, kcSecret)
 }
 
+// getKubeClient returns the client to the API of the child cluster, through the API tunnel of the cluster if there is
+// one. The client is reused until the admin kubeconfig of the cluster changes or the client is invalidated.
 func (c *K0sController) getKubeClient(ctx context.Context, cluster *clusterv1.Cluster) (*kubernetes.Clientset, error) {
-	data, err := kubeconfig.FromSecret(ctx, c.Client, client.ObjectKey{Namespace: cluster.Namespace, Name: cluster.Name})
+	key := client.ObjectKey{Namespace: cluster.Namespace, Name: cluster.Name}
+	data, err := kcutil.KubeconfigFromSecret(ctx, c.Client, key)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching %s kubeconfig from secret: %w", cluster.Name, err)
 	}
-	config, err := clientcmd.NewClientConfigFromBytes(data)
-	if err != nil {
-		return nil, fmt.Errorf("error generating %s clientconfig: %w", cluster.Name, err)
-	}
-	restConfig, err := config.ClientConfig()
-	if err != nil {
-		return nil, fmt.Errorf("error generating %s restconfig:  %w", cluster.Name, err)
-	}
 
-	return kubernetes.NewForConfig(restConfig)
+	return c.KubeClients.Get(key, data, func(data []byte) (*kubernetes.Clientset, error) {
+		config, err := clientcmd.NewClientConfigFromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("error generating %s clientconfig: %w", cluster.Name, err)
+		}
+		restConfig, err := config.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("error generating %s restconfig:  %w", cluster.Name, err)
+		}
+		restConfig.Timeout = kubeClientTimeout
+
+		return kubernetes.NewForConfig(tracing.WrapRESTConfig(restConfig, cluster.Name))
+	})
 }
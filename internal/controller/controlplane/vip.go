@@ -0,0 +1,50 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"fmt"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+// reconcileVIPEndpoint sets the control plane endpoint of the cluster to the VIP announced by kube-vip on the
+// controllers. CAPI copies the endpoint of the infrastructure cluster only to a cluster without an endpoint, so the
+// endpoint is set on the cluster directly.
+func (c *K0sController) reconcileVIPEndpoint(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane) error {
+	if kcp.Spec.VIP == nil {
+		return nil
+	}
+
+	endpoint := clusterv1.APIEndpoint{Host: kcp.Spec.VIP.Address, Port: int32(apiPort(kcp))}
+	if cluster.Spec.ControlPlaneEndpoint == endpoint {
+		return nil
+	}
+
+	patch := client.MergeFrom(cluster.DeepCopy())
+	cluster.Spec.ControlPlaneEndpoint = endpoint
+	if err := c.Client.Patch(ctx, cluster, patch); err != nil {
+		return fmt.Errorf("error setting the control plane endpoint to the VIP: %w", err)
+	}
+	log.FromContext(ctx).Info("Set the control plane endpoint to the VIP", "endpoint", endpoint.String())
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func TestK0sController_reconcileVIPEndpoint(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clusterv1.AddToScheme(scheme))
+
+	ctx := context.Background()
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	c := &K0sController{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster).Build()}
+
+	kcp := &cpv1beta1.K0sControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	require.NoError(t, c.reconcileVIPEndpoint(ctx, cluster, kcp))
+	require.True(t, cluster.Spec.ControlPlaneEndpoint.IsZero())
+
+	kcp.Spec.VIP = &bootstrapv1.VIP{Address: "192.168.1.100"}
+	require.NoError(t, c.reconcileVIPEndpoint(ctx, cluster, kcp))
+
+	var updated clusterv1.Cluster
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(cluster), &updated))
+	require.Equal(t, clusterv1.APIEndpoint{Host: "192.168.1.100", Port: 6443}, updated.Spec.ControlPlaneEndpoint)
+}
+
+func TestK0sController_isK0sConfigUpToDate_VIP(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, bootstrapv1.AddToScheme(scheme))
+
+	vip := &bootstrapv1.VIP{Address: "192.168.1.100", Image: "ghcr.io/kube-vip/kube-vip:v0.7.2"}
+	config := &bootstrapv1.K0sControllerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cp-0", Namespace: "default"},
+		Spec:       bootstrapv1.K0sControllerConfigSpec{VIP: vip},
+	}
+	m := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "cp-0", Namespace: "default"},
+		Spec: clusterv1.MachineSpec{
+			Bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{Name: "cp-0"}},
+		},
+	}
+	c := &K0sController{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(config).Build()}
+
+	kcp := &cpv1beta1.K0sControlPlane{Spec: cpv1beta1.K0sControlPlaneSpec{VIP: vip.DeepCopy()}}
+	upToDate, err := c.isK0sConfigUpToDate(context.Background(), kcp, m)
+	require.NoError(t, err)
+	require.True(t, upToDate)
+
+	kcp.Spec.VIP.Address = "192.168.1.200"
+	upToDate, err = c.isK0sConfigUpToDate(context.Background(), kcp, m)
+	require.NoError(t, err)
+	require.False(t, upToDate)
+}
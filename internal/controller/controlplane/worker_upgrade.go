@@ -0,0 +1,363 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/controller/util"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
+)
+
+const (
+	// autopilotPlanPath is the path of the autopilot plan. Autopilot runs a single plan named autopilot at a time.
+	autopilotPlanPath = "/apis/autopilot.k0sproject.io/v1beta2/plans/autopilot"
+	// controlPlaneNodeLabel labels the control plane nodes running a worker, which are upgraded as controllers.
+	controlPlaneNodeLabel = "node-role.kubernetes.io/control-plane"
+	// workerUpgradeRequeueAfter is the interval of checking the progress of the plan upgrading the workers.
+	workerUpgradeRequeueAfter = 30 * time.Second
+
+	planStateCompleted   = "Completed"
+	targetStateCompleted = "SignalCompleted"
+)
+
+// autopilotPlanStatus is the part of an autopilot plan used to follow the upgrade of the workers.
+type autopilotPlanStatus struct {
+	ID    string
+	State string
+	// Version is the k0s version of the update command of the plan.
+	Version string
+	// UpgradesWorkers is true if the update command targets the workers.
+	UpgradesWorkers bool
+	Workers         int32
+	UpdatedWorkers  int32
+}
+
+// reconcileWorkerUpgrade upgrades the workers to the k0s version of the control plane with an autopilot plan once the
+// control plane is upgraded, and reports the progress of the plan in the status. It returns true while the upgrade
+// is in progress, so the plan is checked again.
+func (c *K0sController) reconcileWorkerUpgrade(ctx context.Context, cluster *clusterv1.Cluster, kcp *cpv1beta1.K0sControlPlane) (bool, error) {
+	spec := kcp.Spec.WorkerUpgrade
+	if spec == nil {
+		kcp.Status.WorkerUpgrade = nil
+		conditions.Delete(kcp, cpv1beta1.WorkersUpgradedCondition)
+		return false, nil
+	}
+
+	kubeClient, err := c.getKubeClient(ctx, cluster)
+	if err != nil {
+		return false, err
+	}
+
+	plan, err := getAutopilotPlan(ctx, kubeClient)
+	if err != nil {
+		return false, err
+	}
+	// The plan upgrading the workers to the version is already created, follow its progress
+	if plan != nil && plan.UpgradesWorkers && plan.Version == kcp.Spec.Version {
+		return setWorkerUpgradeStatus(kcp, plan), nil
+	}
+
+	status := kcp.Status.WorkerUpgrade
+	if status != nil && status.Version == kcp.Spec.Version && status.State == planStateCompleted {
+		conditions.MarkTrue(kcp, cpv1beta1.WorkersUpgradedCondition)
+		return false, nil
+	}
+
+	workers, err := listUpgradeWorkers(ctx, kubeClient, spec)
+	if err != nil {
+		return false, err
+	}
+	// Without a previous upgrade, the plan is created only if some workers run another Kubernetes version
+	upToDate := len(workers) == 0
+	if status == nil && !upToDate {
+		if upToDate, err = workersAtVersion(workers, kcp.Spec.Version); err != nil {
+			return false, err
+		}
+	}
+	if upToDate {
+		kcp.Status.WorkerUpgrade = &cpv1beta1.WorkerUpgradeStatus{
+			Version:        kcp.Spec.Version,
+			State:          planStateCompleted,
+			Workers:        int32(len(workers)),
+			UpdatedWorkers: int32(len(workers)),
+		}
+		conditions.MarkTrue(kcp, cpv1beta1.WorkersUpgradedCondition)
+		return false, nil
+	}
+
+	machines, err := c.getControlPlaneMachines(ctx, kcp)
+	if err != nil {
+		return false, fmt.Errorf("error getting control plane machines: %w", err)
+	}
+	outdated, err := c.machinesToRollout(ctx, kcp, machines)
+	if err != nil {
+		return false, err
+	}
+	if outdated.Len() > 0 {
+		conditions.MarkFalse(kcp, cpv1beta1.WorkersUpgradedCondition, cpv1beta1.WaitingForControlPlaneUpgradeReason, clusterv1.ConditionSeverityInfo,
+			"Waiting for %d control plane machines to be upgraded", outdated.Len())
+		return true, nil
+	}
+	if plan != nil && planInProgress(plan.State) {
+		conditions.MarkFalse(kcp, cpv1beta1.WorkersUpgradedCondition, cpv1beta1.WaitingForControlPlaneUpgradeReason, clusterv1.ConditionSeverityInfo,
+			"Waiting for the autopilot plan %s to finish", plan.ID)
+		return true, nil
+	}
+
+	id := fmt.Sprintf("id-%s-workers-%d", kcp.Name, time.Now().Unix())
+	if err := replaceAutopilotPlan(ctx, kubeClient, kcp, id, map[string]interface{}{"workers": workersTarget(spec)}); err != nil {
+		return false, fmt.Errorf("error creating autopilot plan: %w", err)
+	}
+	util.RecordEvent(c.Recorder, kcp, corev1.EventTypeNormal, util.UpgradeStartedReason,
+		"Upgrading %d workers to %s with an autopilot plan", len(workers), kcp.Spec.Version)
+
+	kcp.Status.WorkerUpgrade = &cpv1beta1.WorkerUpgradeStatus{Version: kcp.Spec.Version, PlanID: id, Workers: int32(len(workers))}
+	conditions.MarkFalse(kcp, cpv1beta1.WorkersUpgradedCondition, cpv1beta1.WorkerUpgradeInProgressReason, clusterv1.ConditionSeverityInfo,
+		"Upgrading %d workers to %s", len(workers), kcp.Spec.Version)
+	return true, nil
+}
+
+// setWorkerUpgradeStatus reports the progress of the plan upgrading the workers. It returns true while the plan is
+// in progress.
+func setWorkerUpgradeStatus(kcp *cpv1beta1.K0sControlPlane, plan *autopilotPlanStatus) bool {
+	status := &cpv1beta1.WorkerUpgradeStatus{
+		Version:        plan.Version,
+		PlanID:         plan.ID,
+		State:          plan.State,
+		Workers:        plan.Workers,
+		UpdatedWorkers: plan.UpdatedWorkers,
+	}
+	// Autopilot lists the workers of the plan once it resolves the targets
+	if prev := kcp.Status.WorkerUpgrade; status.Workers == 0 && prev != nil && prev.PlanID == plan.ID {
+		status.Workers = prev.Workers
+	}
+	kcp.Status.WorkerUpgrade = status
+
+	switch {
+	case plan.State == planStateCompleted:
+		conditions.MarkTrue(kcp, cpv1beta1.WorkersUpgradedCondition)
+		return false
+	case planInProgress(plan.State):
+		conditions.MarkFalse(kcp, cpv1beta1.WorkersUpgradedCondition, cpv1beta1.WorkerUpgradeInProgressReason, clusterv1.ConditionSeverityInfo,
+			"Upgraded %d of %d workers to %s", status.UpdatedWorkers, status.Workers, status.Version)
+		return true
+	default:
+		conditions.MarkFalse(kcp, cpv1beta1.WorkersUpgradedCondition, cpv1beta1.WorkerUpgradeFailedReason, clusterv1.ConditionSeverityError,
+			"Autopilot plan %s is %s, upgraded %d of %d workers to %s", plan.ID, plan.State, status.UpdatedWorkers, status.Workers, status.Version)
+		return false
+	}
+}
+
+// planInProgress returns true if autopilot is still processing a plan in the state. The other states than the
+// completed one are failures, e.g. ApplyFailed or IncompleteTargets.
+func planInProgress(state string) bool {
+	switch state {
+	case "", "NewPlan", "Schedulable", "SchedulableWait":
+		return true
+	}
+	return false
+}
+
+// listUpgradeWorkers lists the worker nodes selected for the upgrade.
+func listUpgradeWorkers(ctx context.Context, clientset *kubernetes.Clientset, spec *cpv1beta1.WorkerUpgradeSpec) ([]corev1.Node, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: workerNodeSelector(spec)})
+	if err != nil {
+		return nil, fmt.Errorf("error listing worker nodes: %w", err)
+	}
+	return nodes.Items, nil
+}
+
+// workerNodeSelector returns the label selector of the worker nodes to upgrade, excluding the control plane nodes.
+func workerNodeSelector(spec *cpv1beta1.WorkerUpgradeSpec) string {
+	selector := "!" + controlPlaneNodeLabel
+	if spec.NodeSelector != "" {
+		selector += "," + spec.NodeSelector
+	}
+	return selector
+}
+
+// workersAtVersion returns true if the kubelets of the nodes run the Kubernetes version of the k0s version.
+// The k0s build of the nodes is not known, so the nodes running another build of the same Kubernetes version
+// are considered up to date.
+func workersAtVersion(nodes []corev1.Node, k0sVersion string) (bool, error) {
+	version, err := kutil.KubernetesVersion(k0sVersion)
+	if err != nil {
+		return false, err
+	}
+	for _, node := range nodes {
+		kubeletVersion := node.Status.NodeInfo.KubeletVersion
+		if kubeletVersion != version && !strings.HasPrefix(kubeletVersion, version+"+") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// workersTarget returns the autopilot target upgrading the selected workers in batches of the concurrent workers.
+func workersTarget(spec *cpv1beta1.WorkerUpgradeSpec) map[string]interface{} {
+	concurrent := spec.Concurrent
+	if concurrent < 1 {
+		concurrent = 1
+	}
+	return map[string]interface{}{
+		"discovery": map[string]interface{}{
+			"selector": map[string]interface{}{
+				"labels": workerNodeSelector(spec),
+			},
+		},
+		"limits": map[string]interface{}{
+			"concurrent": concurrent,
+		},
+	}
+}
+
+// replaceAutopilotPlan creates the autopilot plan updating the targets to the k0s version of the control plane.
+// A finished plan is deleted first, as autopilot runs a single plan at a time.
+func replaceAutopilotPlan(ctx context.Context, clientset *kubernetes.Clientset, kcp *cpv1beta1.K0sControlPlane, id string, targets map[string]interface{}) error {
+	current, err := getAutopilotPlan(ctx, clientset)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		if planInProgress(current.State) {
+			return fmt.Errorf("autopilot plan %s is in progress", current.ID)
+		}
+		err := clientset.RESTClient().Delete().AbsPath(autopilotPlanPath).Do(ctx).Error()
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting autopilot plan %s: %w", current.ID, err)
+		}
+	}
+
+	plan, err := json.Marshal(autopilotPlan(kcp, id, targets))
+	if err != nil {
+		return err
+	}
+	return clientset.RESTClient().Post().
+		AbsPath("/apis/autopilot.k0sproject.io/v1beta2/plans").
+		Body(plan).
+		Do(ctx).
+		Error()
+}
+
+// autopilotPlan returns the autopilot plan updating the targets to the k0s version of the control plane.
+func autopilotPlan(kcp *cpv1beta1.K0sControlPlane, id string, targets map[string]interface{}) map[string]interface{} {
+	platforms := map[string]interface{}{}
+	for _, arch := range []string{"amd64", "arm64", "arm"} {
+		url := `https://get.k0sproject.io/` + kcp.Spec.Version + `/k0s-` + kcp.Spec.Version + `-` + arch
+		if kcp.Spec.K0sConfigSpec.DownloadURL != "" {
+			url = kcp.Spec.K0sConfigSpec.DownloadURL
+		}
+		platforms["linux-"+arch] = map[string]interface{}{"url": url}
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "autopilot.k0sproject.io/v1beta2",
+		"kind":       "Plan",
+		"metadata": map[string]interface{}{
+			"name": "autopilot",
+		},
+		"spec": map[string]interface{}{
+			"id":        id,
+			"timestamp": fmt.Sprintf("%d", time.Now().Unix()),
+			"commands": []interface{}{
+				map[string]interface{}{
+					"k0supdate": map[string]interface{}{
+						"version":   kcp.Spec.Version,
+						"platforms": platforms,
+						"targets":   targets,
+					},
+				},
+			},
+		},
+	}
+}
+
+// getAutopilotPlan returns the autopilot plan of the cluster, or nil if there is none.
+func getAutopilotPlan(ctx context.Context, clientset *kubernetes.Clientset) (*autopilotPlanStatus, error) {
+	data, err := clientset.RESTClient().Get().AbsPath(autopilotPlanPath).DoRaw(ctx)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting autopilot plan: %w", err)
+	}
+	return parseAutopilotPlan(data)
+}
+
+func parseAutopilotPlan(data []byte) (*autopilotPlanStatus, error) {
+	var plan struct {
+		Spec struct {
+			ID       string `json:"id"`
+			Commands []struct {
+				K0sUpdate *struct {
+					Version string `json:"version"`
+					Targets struct {
+						Workers json.RawMessage `json:"workers"`
+					} `json:"targets"`
+				} `json:"k0supdate"`
+			} `json:"commands"`
+		} `json:"spec"`
+		Status struct {
+			State    string `json:"state"`
+			Commands []struct {
+				K0sUpdate *struct {
+					Workers []struct {
+						Name  string `json:"name"`
+						State string `json:"state"`
+					} `json:"workers"`
+				} `json:"k0supdate"`
+			} `json:"commands"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("error decoding autopilot plan: %w", err)
+	}
+
+	status := &autopilotPlanStatus{ID: plan.Spec.ID, State: plan.Status.State}
+	for _, cmd := range plan.Spec.Commands {
+		if cmd.K0sUpdate == nil {
+			continue
+		}
+		status.Version = cmd.K0sUpdate.Version
+		status.UpgradesWorkers = len(cmd.K0sUpdate.Targets.Workers) > 0 && string(cmd.K0sUpdate.Targets.Workers) != "null"
+	}
+	for _, cmd := range plan.Status.Commands {
+		if cmd.K0sUpdate == nil {
+			continue
+		}
+		for _, w := range cmd.K0sUpdate.Workers {
+			status.Workers++
+			if w.State == targetStateCompleted {
+				status.UpdatedWorkers++
+			}
+		}
+	}
+	return status, nil
+}
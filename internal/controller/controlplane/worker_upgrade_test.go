@@ -0,0 +1,143 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controlplane
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func Test_parseAutopilotPlan(t *testing.T) {
+	data := `{
+		"spec":{"id":"id-cp-workers-1","commands":[{"k0supdate":{"version":"v1.29.2+k0s.0","targets":{"workers":{"limits":{"concurrent":2}}}}}]},
+		"status":{"state":"Schedulable","commands":[{"k0supdate":{
+			"controllers":[{"name":"cp-0","state":"SignalCompleted"}],
+			"workers":[{"name":"w-0","state":"SignalCompleted"},{"name":"w-1","state":"SignalSent"},{"name":"w-2","state":"SignalPending"}]
+		}}]}
+	}`
+	plan, err := parseAutopilotPlan([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, &autopilotPlanStatus{
+		ID:              "id-cp-workers-1",
+		State:           "Schedulable",
+		Version:         "v1.29.2+k0s.0",
+		UpgradesWorkers: true,
+		Workers:         3,
+		UpdatedWorkers:  1,
+	}, plan)
+
+	data = `{"spec":{"id":"id-cp-1","commands":[{"k0supdate":{"version":"v1.29.2+k0s.0","targets":{"controllers":{}}}}]},"status":{"state":"Completed"}}`
+	plan, err = parseAutopilotPlan([]byte(data))
+	require.NoError(t, err)
+	assert.False(t, plan.UpgradesWorkers)
+	assert.Equal(t, "Completed", plan.State)
+}
+
+func Test_setWorkerUpgradeStatus(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{Status: cpv1beta1.K0sControlPlaneStatus{
+		WorkerUpgrade: &cpv1beta1.WorkerUpgradeStatus{Version: "v1.29.2+k0s.0", PlanID: "id-1", Workers: 3},
+	}}
+
+	// The workers are not resolved by autopilot yet
+	require.True(t, setWorkerUpgradeStatus(kcp, &autopilotPlanStatus{ID: "id-1", State: "NewPlan", Version: "v1.29.2+k0s.0", UpgradesWorkers: true}))
+	assert.Equal(t, int32(3), kcp.Status.WorkerUpgrade.Workers)
+	assert.Equal(t, cpv1beta1.WorkerUpgradeInProgressReason, conditions.GetReason(kcp, cpv1beta1.WorkersUpgradedCondition))
+
+	require.True(t, setWorkerUpgradeStatus(kcp, &autopilotPlanStatus{ID: "id-1", State: "Schedulable", Version: "v1.29.2+k0s.0", UpgradesWorkers: true, Workers: 4, UpdatedWorkers: 2}))
+	assert.Equal(t, &cpv1beta1.WorkerUpgradeStatus{Version: "v1.29.2+k0s.0", PlanID: "id-1", State: "Schedulable", Workers: 4, UpdatedWorkers: 2}, kcp.Status.WorkerUpgrade)
+	assert.Equal(t, "Upgraded 2 of 4 workers to v1.29.2+k0s.0", conditions.GetMessage(kcp, cpv1beta1.WorkersUpgradedCondition))
+
+	require.False(t, setWorkerUpgradeStatus(kcp, &autopilotPlanStatus{ID: "id-1", State: "ApplyFailed", Version: "v1.29.2+k0s.0", UpgradesWorkers: true, Workers: 4, UpdatedWorkers: 3}))
+	assert.Equal(t, cpv1beta1.WorkerUpgradeFailedReason, conditions.GetReason(kcp, cpv1beta1.WorkersUpgradedCondition))
+
+	require.False(t, setWorkerUpgradeStatus(kcp, &autopilotPlanStatus{ID: "id-1", State: "Completed", Version: "v1.29.2+k0s.0", UpgradesWorkers: true, Workers: 4, UpdatedWorkers: 4}))
+	assert.True(t, conditions.IsTrue(kcp, cpv1beta1.WorkersUpgradedCondition))
+}
+
+func Test_workersAtVersion(t *testing.T) {
+	node := func(version string) corev1.Node {
+		return corev1.Node{Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KubeletVersion: version}}}
+	}
+
+	ok, err := workersAtVersion([]corev1.Node{node("v1.29.2+k0s"), node("v1.29.2")}, "v1.29.2+k0s.1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = workersAtVersion([]corev1.Node{node("v1.29.2+k0s"), node("v1.28.7+k0s")}, "v1.29.2+k0s.1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func Test_autopilotPlan(t *testing.T) {
+	kcp := &cpv1beta1.K0sControlPlane{
+		Spec: cpv1beta1.K0sControlPlaneSpec{
+			Version:       "v1.29.2+k0s.0",
+			K0sConfigSpec: bootstrapv1.K0sConfigSpec{DownloadURL: "https://example.com/k0s"},
+		},
+	}
+	targets := map[string]interface{}{"workers": workersTarget(&cpv1beta1.WorkerUpgradeSpec{Concurrent: 2, NodeSelector: "pool=default"})}
+
+	b, err := json.Marshal(autopilotPlan(kcp, "id-cp-workers-1", targets))
+	require.NoError(t, err)
+
+	var plan struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			ID       string `json:"id"`
+			Commands []struct {
+				K0sUpdate struct {
+					Version   string `json:"version"`
+					Platforms map[string]struct {
+						URL string `json:"url"`
+					} `json:"platforms"`
+					Targets struct {
+						Workers struct {
+							Discovery struct {
+								Selector struct {
+									Labels string `json:"labels"`
+								} `json:"selector"`
+							} `json:"discovery"`
+							Limits struct {
+								Concurrent int `json:"concurrent"`
+							} `json:"limits"`
+						} `json:"workers"`
+					} `json:"targets"`
+				} `json:"k0supdate"`
+			} `json:"commands"`
+		} `json:"spec"`
+	}
+	require.NoError(t, json.Unmarshal(b, &plan))
+	assert.Equal(t, "autopilot", plan.Metadata.Name)
+	assert.Equal(t, "id-cp-workers-1", plan.Spec.ID)
+	require.Len(t, plan.Spec.Commands, 1)
+	update := plan.Spec.Commands[0].K0sUpdate
+	assert.Equal(t, "v1.29.2+k0s.0", update.Version)
+	assert.Len(t, update.Platforms, 3)
+	assert.Equal(t, "https://example.com/k0s", update.Platforms["linux-arm64"].URL)
+	assert.Equal(t, "!node-role.kubernetes.io/control-plane,pool=default", update.Targets.Workers.Discovery.Selector.Labels)
+	assert.Equal(t, 2, update.Targets.Workers.Limits.Concurrent)
+}
@@ -0,0 +1,355 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/secret"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+const (
+	apiTunnelPort          = 6443
+	apiTunnelSSHKeyDir     = "/etc/k0smotron/ssh"
+	apiTunnelKnownHostsDir = "/etc/k0smotron/known_hosts"
+)
+
+// apiTunnelName returns the name of the deployment and the service of the API tunnel of the cluster.
+func apiTunnelName(c *infrastructure.RemoteCluster) string {
+	return c.Name + "-api-tunnel"
+}
+
+// apiTunnelServer returns the API server URL of the workload cluster through the API tunnel of the cluster.
+func apiTunnelServer(c *infrastructure.RemoteCluster) string {
+	return fmt.Sprintf("https://%s.%s.svc:%d", apiTunnelName(c), c.Namespace, apiTunnelPort)
+}
+
+// reconcileAPITunnel runs the SSH tunnel to the API of the workload cluster and points the kubeconfig the controllers
+// use to it. If the tunnel is disabled, the tunnel and its kubeconfig are removed, so the controllers use the control
+// plane endpoint again.
+func (r *ClusterController) reconcileAPITunnel(ctx context.Context, c *infrastructure.RemoteCluster, cluster *clusterv1.Cluster) (ctrl.Result, error) {
+	if c.Spec.APITunnel == nil {
+		for _, obj := range []client.Object{&appsv1.Deployment{}, &v1.Service{}} {
+			obj.SetName(apiTunnelName(c))
+			obj.SetNamespace(c.Namespace)
+			if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, fmt.Errorf("failed to delete the API tunnel: %w", err)
+			}
+		}
+		conditions.Delete(c, infrastructure.APITunnelReadyCondition)
+		if cluster == nil {
+			return ctrl.Result{}, nil
+		}
+		kubeconfigSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: kcutil.APITunnelKubeconfigName(cluster.Name), Namespace: cluster.Namespace}}
+		if err := r.Delete(ctx, kubeconfigSecret); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to delete the API tunnel kubeconfig secret: %w", err)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	knownHosts, err := r.reconcileAPITunnelKnownHosts(ctx, c)
+	if err != nil {
+		conditions.MarkFalse(c, infrastructure.APITunnelReadyCondition, infrastructure.APITunnelFailedReason, clusterv1.ConditionSeverityError, "%s", err)
+		return ctrl.Result{}, err
+	}
+	if knownHosts == nil {
+		// The tunnel is never connected without verifying the host key
+		deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: apiTunnelName(c), Namespace: c.Namespace}}
+		if err := r.Delete(ctx, deployment); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to delete the API tunnel: %w", err)
+		}
+		conditions.MarkFalse(c, infrastructure.APITunnelReadyCondition, infrastructure.APITunnelNotReadyReason, clusterv1.ConditionSeverityWarning, "Waiting for the host key of %s to be pinned by its RemoteMachine", c.Spec.APITunnel.Address)
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	deployment, err := r.reconcileAPITunnelDeployment(ctx, c, knownHosts)
+	if err != nil {
+		conditions.MarkFalse(c, infrastructure.APITunnelReadyCondition, infrastructure.APITunnelFailedReason, clusterv1.ConditionSeverityError, "%s", err)
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileAPITunnelService(ctx, c); err != nil {
+		conditions.MarkFalse(c, infrastructure.APITunnelReadyCondition, infrastructure.APITunnelFailedReason, clusterv1.ConditionSeverityError, "%s", err)
+		return ctrl.Result{}, err
+	}
+
+	if cluster == nil {
+		conditions.MarkFalse(c, infrastructure.APITunnelReadyCondition, infrastructure.APITunnelNotReadyReason, clusterv1.ConditionSeverityWarning, "Waiting for the owner Cluster")
+		return ctrl.Result{}, nil
+	}
+	found, err := r.reconcileAPITunnelKubeconfig(ctx, c, cluster)
+	if err != nil {
+		conditions.MarkFalse(c, infrastructure.APITunnelReadyCondition, infrastructure.APITunnelFailedReason, clusterv1.ConditionSeverityError, "%s", err)
+		return ctrl.Result{}, err
+	}
+	if !found {
+		conditions.MarkFalse(c, infrastructure.APITunnelReadyCondition, infrastructure.APITunnelNotReadyReason, clusterv1.ConditionSeverityWarning, "Waiting for the kubeconfig of the cluster")
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	if deployment.Status.AvailableReplicas == 0 {
+		conditions.MarkFalse(c, infrastructure.APITunnelReadyCondition, infrastructure.APITunnelNotReadyReason, clusterv1.ConditionSeverityWarning, "Waiting for the tunnel to connect to %s", c.Spec.APITunnel.Address)
+		return ctrl.Result{}, nil
+	}
+	conditions.MarkTrue(c, infrastructure.APITunnelReadyCondition)
+	return ctrl.Result{}, nil
+}
+
+// apiTunnelSSHPort returns the SSH port of the host of the tunnel.
+func apiTunnelSSHPort(tunnel *infrastructure.APITunnelSpec) int {
+	if tunnel.Port == 0 {
+		return 22
+	}
+	return tunnel.Port
+}
+
+// reconcileAPITunnelKnownHosts returns the secret holding the known hosts the host key of the tunnel host is verified
+// against. Unless set in the spec, the secret is generated from the host key pinned by the RemoteMachine of the cluster
+// at the address of the tunnel. It returns nil if the host key is not pinned yet.
+func (r *ClusterController) reconcileAPITunnelKnownHosts(ctx context.Context, c *infrastructure.RemoteCluster) (*infrastructure.SecretRef, error) {
+	tunnel := c.Spec.APITunnel
+	if tunnel.KnownHostsSecretRef != nil {
+		return tunnel.KnownHostsSecretRef, nil
+	}
+	clusterName := c.Labels[clusterv1.ClusterNameLabel]
+	if clusterName == "" {
+		return nil, nil
+	}
+
+	var machines infrastructure.RemoteMachineList
+	if err := r.List(ctx, &machines, client.InNamespace(c.Namespace), client.MatchingLabels{clusterv1.ClusterNameLabel: clusterName}); err != nil {
+		return nil, fmt.Errorf("failed to list the RemoteMachines of the cluster: %w", err)
+	}
+	var hostKey string
+	for _, m := range machines.Items {
+		if m.Status.HostKey != "" && (m.Spec.Address == tunnel.Address || m.SSHAddress() == tunnel.Address) {
+			hostKey = m.Status.HostKey
+			break
+		}
+	}
+	if hostKey == "" {
+		return nil, nil
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the pinned host key of %s: %w", tunnel.Address, err)
+	}
+	address := knownhosts.Normalize(net.JoinHostPort(tunnel.Address, strconv.Itoa(apiTunnelSSHPort(tunnel))))
+
+	knownHosts := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: apiTunnelName(c) + "-known-hosts", Namespace: c.Namespace},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, knownHosts, func() error {
+		knownHosts.Labels = map[string]string{
+			clusterv1.ClusterNameLabel: clusterName,
+			"remote_cluster":           c.Name,
+		}
+		knownHosts.Data = map[string][]byte{"value": []byte(knownhosts.Line([]string{address}, key) + "\n")}
+		return ctrl.SetControllerReference(c, knownHosts, r.Scheme)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile the API tunnel known hosts: %w", err)
+	}
+	return &infrastructure.SecretRef{Name: knownHosts.Name}, nil
+}
+
+// apiTunnelCommand returns the ssh command forwarding the port of the tunnel to the API port on the host. The host key
+// is verified against the known hosts mounted from the secret returned by reconcileAPITunnelKnownHosts.
+func apiTunnelCommand(c *infrastructure.RemoteCluster) []string {
+	tunnel := c.Spec.APITunnel
+	user := tunnel.User
+	if user == "" {
+		user = "root"
+	}
+	apiPort := tunnel.APIPort
+	if apiPort == 0 {
+		apiPort = int(c.Spec.ControlPlaneEndpoint.Port)
+	}
+	if apiPort == 0 {
+		apiPort = 6443
+	}
+
+	cmd := []string{
+		"ssh", "-N",
+		"-i", apiTunnelSSHKeyDir + "/id_key",
+		"-p", strconv.Itoa(apiTunnelSSHPort(tunnel)),
+		"-o", "BatchMode=yes",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=3",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "UserKnownHostsFile=" + apiTunnelKnownHostsDir + "/known_hosts",
+	}
+	return append(cmd,
+		"-L", fmt.Sprintf("0.0.0.0:%d:localhost:%d", apiTunnelPort, apiPort),
+		fmt.Sprintf("%s@%s", user, tunnel.Address),
+	)
+}
+
+func (r *ClusterController) reconcileAPITunnelDeployment(ctx context.Context, c *infrastructure.RemoteCluster, knownHosts *infrastructure.SecretRef) (*appsv1.Deployment, error) {
+	tunnel := c.Spec.APITunnel
+	labels := map[string]string{
+		"app":                      "api-tunnel",
+		clusterv1.ClusterNameLabel: c.Labels[clusterv1.ClusterNameLabel],
+		"remote_cluster":           c.Name,
+	}
+
+	volumes := []v1.Volume{{
+		Name: "ssh-key",
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName:  tunnel.SSHKeyRef.Name,
+				Items:       []v1.KeyToPath{{Key: "value", Path: "id_key"}},
+				DefaultMode: ptr.To[int32](0400),
+			},
+		},
+	}, {
+		Name: "known-hosts",
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: knownHosts.Name,
+				Items:      []v1.KeyToPath{{Key: "value", Path: "known_hosts"}},
+			},
+		},
+	}}
+	mounts := []v1.VolumeMount{
+		{Name: "ssh-key", MountPath: apiTunnelSSHKeyDir, ReadOnly: true},
+		{Name: "known-hosts", MountPath: apiTunnelKnownHostsDir, ReadOnly: true},
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: apiTunnelName(c), Namespace: c.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deployment, func() error {
+		deployment.Labels = labels
+		deployment.Spec.Replicas = ptr.To[int32](1)
+		deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api-tunnel", "remote_cluster": c.Name}}
+		deployment.Spec.Template.Labels = labels
+		deployment.Spec.Template.Spec.Volumes = volumes
+		deployment.Spec.Template.Spec.Containers = []v1.Container{{
+			Name:            "api-tunnel",
+			Image:           tunnel.Image,
+			ImagePullPolicy: v1.PullIfNotPresent,
+			Command:         apiTunnelCommand(c),
+			Ports: []v1.ContainerPort{{
+				Name:          "api",
+				Protocol:      v1.ProtocolTCP,
+				ContainerPort: apiTunnelPort,
+			}},
+			ReadinessProbe: &v1.Probe{
+				ProbeHandler: v1.ProbeHandler{
+					TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(apiTunnelPort)},
+				},
+				PeriodSeconds: 10,
+			},
+			VolumeMounts: mounts,
+		}}
+		return ctrl.SetControllerReference(c, deployment, r.Scheme)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile the API tunnel deployment: %w", err)
+	}
+	return deployment, nil
+}
+
+func (r *ClusterController) reconcileAPITunnelService(ctx context.Context, c *infrastructure.RemoteCluster) error {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: apiTunnelName(c), Namespace: c.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, service, func() error {
+		service.Labels = map[string]string{
+			"app":                      "api-tunnel",
+			clusterv1.ClusterNameLabel: c.Labels[clusterv1.ClusterNameLabel],
+			"remote_cluster":           c.Name,
+		}
+		service.Spec.Type = v1.ServiceTypeClusterIP
+		service.Spec.Selector = map[string]string{"app": "api-tunnel", "remote_cluster": c.Name}
+		service.Spec.Ports = []v1.ServicePort{{
+			Name:       "api",
+			Protocol:   v1.ProtocolTCP,
+			Port:       apiTunnelPort,
+			TargetPort: intstr.FromInt(apiTunnelPort),
+		}}
+		return ctrl.SetControllerReference(c, service, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconcile the API tunnel service: %w", err)
+	}
+	return nil
+}
+
+// reconcileAPITunnelKubeconfig writes the kubeconfig of the cluster pointed to the API tunnel, verifying the API
+// server certificate against the control plane endpoint, to the secret the controllers reach the API of the cluster
+// with. The <cluster>-kubeconfig secret is left pointed to the control plane endpoint for the other consumers. It
+// returns false if the kubeconfig of the cluster doesn't exist yet.
+func (r *ClusterController) reconcileAPITunnelKubeconfig(ctx context.Context, c *infrastructure.RemoteCluster, cluster *clusterv1.Cluster) (bool, error) {
+	kubeconfigSecret := &v1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: secret.Name(cluster.Name, secret.Kubeconfig)}, kubeconfigSecret)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get the kubeconfig secret: %w", err)
+	}
+
+	kc, err := clientcmd.Load(kubeconfigSecret.Data[secret.KubeconfigDataName])
+	if err != nil {
+		return true, fmt.Errorf("failed to parse the kubeconfig: %w", err)
+	}
+	for _, kcCluster := range kc.Clusters {
+		kcCluster.Server = apiTunnelServer(c)
+		kcCluster.TLSServerName = c.Spec.ControlPlaneEndpoint.Host
+	}
+	data, err := clientcmd.Write(*kc)
+	if err != nil {
+		return true, fmt.Errorf("failed to serialize the kubeconfig: %w", err)
+	}
+
+	tunneled := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: kcutil.APITunnelKubeconfigName(cluster.Name), Namespace: cluster.Namespace},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, tunneled, func() error {
+		tunneled.Labels = map[string]string{clusterv1.ClusterNameLabel: cluster.Name}
+		tunneled.Type = clusterv1.ClusterSecretType
+		tunneled.Data = map[string][]byte{secret.KubeconfigDataName: data}
+		return ctrl.SetControllerReference(c, tunneled, r.Scheme)
+	})
+	if err != nil {
+		return true, fmt.Errorf("failed to reconcile the API tunnel kubeconfig secret: %w", err)
+	}
+	return true, nil
+}
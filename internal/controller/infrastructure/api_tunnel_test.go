@@ -0,0 +1,217 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+func TestAPITunnelCommand(t *testing.T) {
+	c := &infrastructure.RemoteCluster{
+		Spec: infrastructure.RemoteClusterSpec{
+			ControlPlaneEndpoint: clusterv1.APIEndpoint{Host: "10.0.0.100", Port: 7443},
+			APITunnel: &infrastructure.APITunnelSpec{
+				Address:   "192.168.1.10",
+				SSHKeyRef: infrastructure.SecretRef{Name: "ssh-key"},
+			},
+		},
+	}
+	require.Equal(t, []string{
+		"ssh", "-N",
+		"-i", "/etc/k0smotron/ssh/id_key",
+		"-p", "22",
+		"-o", "BatchMode=yes",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=3",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "UserKnownHostsFile=/etc/k0smotron/known_hosts/known_hosts",
+		"-L", "0.0.0.0:6443:localhost:7443",
+		"root@192.168.1.10",
+	}, apiTunnelCommand(c))
+
+	c.Spec.APITunnel.Port = 2222
+	c.Spec.APITunnel.User = "k0s"
+	c.Spec.APITunnel.APIPort = 6443
+	require.Equal(t, []string{
+		"ssh", "-N",
+		"-i", "/etc/k0smotron/ssh/id_key",
+		"-p", "2222",
+		"-o", "BatchMode=yes",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=3",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "UserKnownHostsFile=/etc/k0smotron/known_hosts/known_hosts",
+		"-L", "0.0.0.0:6443:localhost:6443",
+		"k0s@192.168.1.10",
+	}, apiTunnelCommand(c))
+}
+
+func TestClusterController_reconcileAPITunnel(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, clusterv1.AddToScheme(scheme))
+	require.NoError(t, infrastructure.AddToScheme(scheme))
+
+	cluster := &clusterv1.Cluster{
+		TypeMeta:   metav1.TypeMeta{APIVersion: clusterv1.GroupVersion.String(), Kind: "Cluster"},
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "default", UID: "cluster-uid"},
+	}
+	rc := &infrastructure.RemoteCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-cluster",
+			Namespace: "default",
+			UID:       "rc-uid",
+			Labels:    map[string]string{clusterv1.ClusterNameLabel: "my-cluster"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: clusterv1.GroupVersion.String(),
+				Kind:       "Cluster",
+				Name:       "my-cluster",
+				UID:        "cluster-uid",
+			}},
+		},
+		Spec: infrastructure.RemoteClusterSpec{
+			ControlPlaneEndpoint: clusterv1.APIEndpoint{Host: "10.0.0.100", Port: 6443},
+			APITunnel: &infrastructure.APITunnelSpec{
+				Address:   "192.168.1.10",
+				Port:      22,
+				User:      "root",
+				SSHKeyRef: infrastructure.SecretRef{Name: "ssh-key"},
+				Image:     "registry.example.com/ssh-client:1.0",
+			},
+		},
+	}
+	kc := api.NewConfig()
+	kc.Clusters["my-cluster"] = &api.Cluster{Server: "https://10.0.0.100:6443"}
+	kcData, err := clientcmd.Write(*kc)
+	require.NoError(t, err)
+	kubeconfig := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster-kubeconfig", Namespace: "default"},
+		Data:       map[string][]byte{"value": kcData},
+	}
+
+	hostKey, err := ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	require.NoError(t, err)
+	machine := &infrastructure.RemoteMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-machine",
+			Namespace: "default",
+			Labels:    map[string]string{clusterv1.ClusterNameLabel: "my-cluster"},
+		},
+		Spec: infrastructure.RemoteMachineSpec{Address: "192.168.1.10"},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster, rc, kubeconfig, machine).WithStatusSubresource(rc, machine).Build()
+	r := &ClusterController{Client: c, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "my-cluster"}}
+
+	// The tunnel waits until the host key is pinned by the machine
+	res, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	require.NotZero(t, res.RequeueAfter)
+	require.True(t, apierrors.IsNotFound(c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "my-cluster-api-tunnel"}, &appsv1.Deployment{})))
+	require.NoError(t, c.Get(context.Background(), req.NamespacedName, rc))
+	require.Equal(t, infrastructure.APITunnelNotReadyReason, conditions.GetReason(rc, infrastructure.APITunnelReadyCondition))
+
+	machine.Status.HostKey = hostKeyString(hostKey.PublicKey())
+	require.NoError(t, c.Status().Update(context.Background(), machine))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	var knownHosts v1.Secret
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "my-cluster-api-tunnel-known-hosts"}, &knownHosts))
+	require.Equal(t, knownhosts.Line([]string{"192.168.1.10"}, hostKey.PublicKey())+"\n", string(knownHosts.Data["value"]))
+	require.True(t, metav1.IsControlledBy(&knownHosts, rc))
+
+	var deployment appsv1.Deployment
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "my-cluster-api-tunnel"}, &deployment))
+	require.Equal(t, "registry.example.com/ssh-client:1.0", deployment.Spec.Template.Spec.Containers[0].Image)
+	require.Equal(t, "ssh-key", deployment.Spec.Template.Spec.Volumes[0].Secret.SecretName)
+	require.Equal(t, "my-cluster-api-tunnel-known-hosts", deployment.Spec.Template.Spec.Volumes[1].Secret.SecretName)
+	require.True(t, metav1.IsControlledBy(&deployment, rc))
+
+	var service v1.Service
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "my-cluster-api-tunnel"}, &service))
+	require.Equal(t, deployment.Spec.Selector.MatchLabels, service.Spec.Selector)
+
+	// The controllers reach the API through the tunnel, the kubeconfig of the cluster is left to the endpoint
+	var tunneled v1.Secret
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "my-cluster-api-tunnel-kubeconfig"}, &tunneled))
+	require.True(t, metav1.IsControlledBy(&tunneled, rc))
+	kc, err = clientcmd.Load(tunneled.Data["value"])
+	require.NoError(t, err)
+	require.Equal(t, "https://my-cluster-api-tunnel.default.svc:6443", kc.Clusters["my-cluster"].Server)
+	require.Equal(t, "10.0.0.100", kc.Clusters["my-cluster"].TLSServerName)
+
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(kubeconfig), kubeconfig))
+	require.Equal(t, kcData, kubeconfig.Data["value"])
+
+	data, err := kcutil.KubeconfigFromSecret(context.Background(), c, client.ObjectKeyFromObject(cluster))
+	require.NoError(t, err)
+	require.Equal(t, tunneled.Data["value"], data)
+
+	// The tunnel is not ready until the deployment is available
+	require.NoError(t, c.Get(context.Background(), req.NamespacedName, rc))
+	require.True(t, rc.Status.Ready)
+	require.Equal(t, infrastructure.APITunnelNotReadyReason, conditions.GetReason(rc, infrastructure.APITunnelReadyCondition))
+
+	deployment.Status.AvailableReplicas = 1
+	require.NoError(t, c.Status().Update(context.Background(), &deployment))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), req.NamespacedName, rc))
+	require.True(t, conditions.IsTrue(rc, infrastructure.APITunnelReadyCondition))
+
+	// Disabling the tunnel removes it and its kubeconfig
+	rc.Spec.APITunnel = nil
+	require.NoError(t, c.Update(context.Background(), rc))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	require.True(t, apierrors.IsNotFound(c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "my-cluster-api-tunnel"}, &appsv1.Deployment{})))
+	require.True(t, apierrors.IsNotFound(c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "my-cluster-api-tunnel"}, &v1.Service{})))
+	require.True(t, apierrors.IsNotFound(c.Get(context.Background(), client.ObjectKeyFromObject(&tunneled), &v1.Secret{})))
+	data, err = kcutil.KubeconfigFromSecret(context.Background(), c, client.ObjectKeyFromObject(cluster))
+	require.NoError(t, err)
+	require.Equal(t, kcData, data)
+	require.NoError(t, c.Get(context.Background(), req.NamespacedName, rc))
+	require.Nil(t, conditions.Get(rc, infrastructure.APITunnelReadyCondition))
+}
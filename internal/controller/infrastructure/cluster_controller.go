@@ -19,22 +19,33 @@ package infrastructure
 import (
 	"context"
 
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/cluster-api/util"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
 )
 
 type ClusterController struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Shard selects the resources reconciled by the controller. All the resources are reconciled if nil.
+	Shard *sharding.Shard
 }
 
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=remoteclusters,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=remoteclusters/status,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=remotemachines,verbs=get;list;watch
 
 func (r *ClusterController) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, err error) {
 	log := log.FromContext(ctx).WithValues("remotecluster", req.NamespacedName)
@@ -50,18 +61,32 @@ func (r *ClusterController) Reconcile(ctx context.Context, req ctrl.Request) (re
 		return ctrl.Result{}, err
 	}
 
-	// Nothing really to do, except put the cluster in a ready state
+	cluster, err := util.GetOwnerCluster(ctx, r.Client, c.ObjectMeta)
+	if err != nil {
+		log.Error(err, "Failed to get owner cluster")
+		return ctrl.Result{}, err
+	}
+
+	res, err = r.reconcileAPITunnel(ctx, c, cluster)
+	if err != nil {
+		log.Error(err, "Failed to reconcile the API tunnel")
+	}
+
+	// Nothing else to do, except put the cluster in a ready state
 	c.Status.Ready = true
 	if err := r.Status().Update(ctx, c); err != nil {
 		log.Error(err, "Failed to update RemoteCluster status")
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	return res, err
 }
 
 func (r *ClusterController) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&infrastructure.RemoteCluster{}).
-		Complete(r)
+		Owns(&appsv1.Deployment{}).
+		Owns(&v1.Service{}).
+		Owns(&v1.Secret{}).
+		Complete(r.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("RemoteCluster", r)))
 }
@@ -0,0 +1,112 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/k0sproject/rig"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+)
+
+const (
+	// maxArtifactSize is the maximum size of an artifact, the secret of all the artifacts must fit in 1MiB.
+	maxArtifactSize = 200 * 1024
+
+	cloudInitLogCommand = "if [ -f /var/log/cloud-init-output.log ]; then tail -n 500 /var/log/cloud-init-output.log; fi"
+	k0sLogCommand       = "if command -v journalctl > /dev/null 2>&1; then journalctl --no-pager -n 500 -u k0scontroller -u k0sworker; " +
+		"else tail -n 500 /var/log/k0scontroller.log /var/log/k0sworker.log 2> /dev/null; true; fi"
+)
+
+// artifactsError is a provisioning error with the artifacts collected from the machine to debug it.
+type artifactsError struct {
+	error
+	artifacts map[string]string
+}
+
+func (e artifactsError) Unwrap() error {
+	return e.error
+}
+
+// collectFailureArtifacts collects the logs of the failed provisioning from the machine. The logs that can't be
+// collected are replaced by the error collecting them.
+func (p *SSHProvisioner) collectFailureArtifacts(conn *rig.Connection, err error, commandOutput string) map[string]string {
+	artifacts := map[string]string{"error": err.Error()}
+	if commandOutput != "" {
+		artifacts["command-output"] = commandOutput
+	}
+	for key, cmd := range map[string]string{"cloud-init.log": cloudInitLogCommand, "k0s.log": k0sLogCommand} {
+		output, err := p.exec(conn, cmd, "")
+		if err != nil {
+			output = fmt.Sprintf("failed to collect the log: %s: %s", err, output)
+		}
+		if output != "" {
+			artifacts[key] = output
+		}
+	}
+	for key, artifact := range artifacts {
+		if len(artifact) > maxArtifactSize {
+			// Keep the end of the logs
+			artifacts[key] = artifact[len(artifact)-maxArtifactSize:]
+		}
+	}
+	return artifacts
+}
+
+// saveFailureArtifacts saves the artifacts of the failed provisioning, if any, to a secret of the machine and
+// references it in the status of the machine.
+func (r *RemoteMachineController) saveFailureArtifacts(ctx context.Context, rm *infrastructure.RemoteMachine, provisionErr error) error {
+	var artifactsErr artifactsError
+	if !errors.As(provisionErr, &artifactsErr) {
+		return nil
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-failure-artifacts", rm.Name),
+			Namespace: rm.Namespace,
+		},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if clusterName, ok := rm.Labels[clusterv1.ClusterNameLabel]; ok {
+			if secret.Labels == nil {
+				secret.Labels = map[string]string{}
+			}
+			secret.Labels[clusterv1.ClusterNameLabel] = clusterName
+		}
+		secret.Type = v1.SecretTypeOpaque
+		secret.Data = make(map[string][]byte, len(artifactsErr.artifacts))
+		for key, artifact := range artifactsErr.artifacts {
+			secret.Data[key] = []byte(artifact)
+		}
+		return ctrl.SetControllerReference(rm, secret, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+
+	rm.Status.FailureArtifactsRef = &infrastructure.SecretRef{Name: secret.Name}
+	return nil
+}
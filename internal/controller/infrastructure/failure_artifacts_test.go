@@ -0,0 +1,73 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+)
+
+func TestRemoteMachineController_saveFailureArtifacts(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, infrastructure.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	r := &RemoteMachineController{Client: c, Scheme: scheme}
+
+	rm := &infrastructure.RemoteMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rm",
+			Namespace: "default",
+			UID:       "uid",
+			Labels:    map[string]string{clusterv1.ClusterNameLabel: "my-cluster"},
+		},
+	}
+
+	// Errors without artifacts, e.g. the connection failures, are not saved
+	require.NoError(t, r.saveFailureArtifacts(context.Background(), rm, errors.New("failed to connect to host")))
+	require.Nil(t, rm.Status.FailureArtifactsRef)
+
+	for _, output := range []string{"first attempt", "second attempt"} {
+		err := fmt.Errorf("provisioning failed: %w", artifactsError{
+			error:     errors.New("failed to run command"),
+			artifacts: map[string]string{"error": "failed to run command", "command-output": output},
+		})
+		require.NoError(t, r.saveFailureArtifacts(context.Background(), rm, err))
+	}
+	require.Equal(t, &infrastructure.SecretRef{Name: "rm-failure-artifacts"}, rm.Status.FailureArtifactsRef)
+
+	var secret v1.Secret
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "rm-failure-artifacts"}, &secret))
+	require.Equal(t, "second attempt", string(secret.Data["command-output"]))
+	require.Equal(t, "failed to run command", string(secret.Data["error"]))
+	require.Equal(t, "my-cluster", secret.Labels[clusterv1.ClusterNameLabel])
+	require.Len(t, secret.OwnerReferences, 1)
+	require.Equal(t, "RemoteMachine", secret.OwnerReferences[0].Kind)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	kmapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/tracing"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
+)
+
+const defaultHealthCheckInterval = time.Minute
+
+// reconcileHealth probes the health of a provisioned machine once an interval and reports it in the HostReachable
+// and K0sRunning conditions. A machine failing the probe too many times in a row is marked as failed, so Cluster API
+// reports the failure on the Machine and a MachineHealthCheck remediates it.
+func (r *RemoteMachineController) reconcileHealth(ctx context.Context, rm *infrastructure.RemoteMachine, mode RemoteMachineMode) (ctrl.Result, error) {
+	log := log.FromContext(ctx).WithValues("remotemachine", rm.Name)
+
+	// The machines provisioned by a job don't have the SSH credentials, and the failed ones are being remediated
+	if rm.Spec.ProvisionJob != nil || rm.Status.FailureReason != "" {
+		return ctrl.Result{}, nil
+	}
+
+	interval := rm.Spec.HealthCheck.Interval.Duration
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	if last := rm.Status.LastHealthCheckTime; last != nil {
+		if wait := interval - time.Since(last.Time); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	credentials, err := r.getSSHCredentials(ctx, rm)
+	if err != nil {
+		log.Error(err, "Failed to get ssh credentials")
+		return ctrl.Result{}, err
+	}
+
+	prober := r.healthProber
+	if prober == nil {
+		prober = probeHealth
+	}
+	_, span := tracing.Start(ctx, "SSHHealthProbe", sshSpanAttributes(rm)...)
+	reachable, components, err := prober(rm, credentials, mode)
+	tracing.End(span, err)
+	rm.Status.Components = components
+
+	now := metav1.Now()
+	rm.Status.LastHealthCheckTime = &now
+	switch {
+	case !reachable:
+		conditions.MarkFalse(rm, infrastructure.HostReachableCondition, infrastructure.HostUnreachableReason, clusterv1.ConditionSeverityWarning, "%s", err)
+		conditions.MarkUnknown(rm, infrastructure.K0sRunningCondition, infrastructure.HostUnreachableReason, "The machine can't be reached")
+	case err != nil:
+		conditions.MarkTrue(rm, infrastructure.HostReachableCondition)
+		conditions.MarkFalse(rm, infrastructure.K0sRunningCondition, infrastructure.K0sNotRunningReason, clusterv1.ConditionSeverityWarning, "%s", err)
+	default:
+		conditions.MarkTrue(rm, infrastructure.HostReachableCondition)
+		conditions.MarkTrue(rm, infrastructure.K0sRunningCondition)
+		rm.Status.HealthCheckFailures = 0
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+
+	rm.Status.HealthCheckFailures++
+	log.Info("Health probe failed", "failures", rm.Status.HealthCheckFailures, "error", err.Error())
+
+	threshold := rm.Spec.HealthCheck.FailureThreshold
+	if threshold > 0 && rm.Status.HealthCheckFailures >= threshold {
+		rm.Status.FailureReason = infrastructure.HealthCheckFailedReason
+		rm.Status.FailureMessage = fmt.Sprintf("The health probe failed %d times in a row: %s", rm.Status.HealthCheckFailures, err)
+		rm.Status.Ready = false
+		return ctrl.Result{}, nil
+	}
+
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// probeHealth connects to the machine over SSH and checks that k0s is running on it with "k0s status". The health
+// of the k0s control plane components is checked on the controllers as well.
+func probeHealth(rm *infrastructure.RemoteMachine, credentials sshCredentials, mode RemoteMachineMode) (bool, []kmapi.ComponentHealth, error) {
+	connection, err := sshConnection(rm, credentials)
+	if err != nil {
+		return false, nil, err
+	}
+	if err := connection.Connect(); err != nil {
+		return false, nil, fmt.Errorf("failed to connect to host: %w", err)
+	}
+	defer connection.Disconnect()
+
+	p := &SSHProvisioner{machine: rm, credentials: credentials}
+	if output, err := p.exec(connection, "k0s status", ""); err != nil {
+		return true, nil, fmt.Errorf("k0s status failed: %w: %s", err, output)
+	}
+
+	if mode != ModeController {
+		return true, nil, nil
+	}
+	output, err := p.exec(connection, "sh -s", kutil.ComponentHealthScript)
+	if err != nil {
+		return true, []kmapi.ComponentHealth{{Name: "k0s", Message: fmt.Sprintf("component health check failed: %s", err)}}, nil
+	}
+	components, err := kutil.ParseComponentHealth(output)
+	if err != nil {
+		return true, []kmapi.ComponentHealth{{Name: "k0s", Message: err.Error()}}, nil
+	}
+
+	return true, components, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	kmapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestRemoteMachineController_reconcileHealth(t *testing.T) {
+	tests := []struct {
+		name             string
+		failures         int
+		lastCheck        time.Duration
+		reachable        bool
+		probeErr         error
+		components       []kmapi.ComponentHealth
+		wantProbed       bool
+		wantResult       ctrl.Result
+		wantFailures     int
+		wantReachable    bool
+		wantK0sRunning   bool
+		wantFailedReason string
+	}{
+		{
+			name:           "healthy",
+			failures:       2,
+			reachable:      true,
+			components:     []kmapi.ComponentHealth{{Name: "k0s", Healthy: true}, {Name: "scheduler", Message: "connection refused"}},
+			wantProbed:     true,
+			wantResult:     ctrl.Result{RequeueAfter: time.Minute},
+			wantReachable:  true,
+			wantK0sRunning: true,
+		},
+		{
+			name:         "unreachable",
+			probeErr:     errors.New("connection refused"),
+			wantProbed:   true,
+			wantResult:   ctrl.Result{RequeueAfter: time.Minute},
+			wantFailures: 1,
+		},
+		{
+			name:          "k0s not running",
+			reachable:     true,
+			probeErr:      errors.New("k0s status failed"),
+			wantProbed:    true,
+			wantResult:    ctrl.Result{RequeueAfter: time.Minute},
+			wantFailures:  1,
+			wantReachable: true,
+		},
+		{
+			name:             "failure threshold reached",
+			failures:         2,
+			probeErr:         errors.New("connection refused"),
+			wantProbed:       true,
+			wantFailures:     3,
+			wantFailedReason: infrastructure.HealthCheckFailedReason,
+		},
+		{
+			name:         "probed recently",
+			failures:     1,
+			lastCheck:    30 * time.Second,
+			wantFailures: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			c := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "ssh-key", Namespace: "default"},
+					Data:       map[string][]byte{"value": []byte("secret-key")},
+				}).
+				Build()
+
+			probed := false
+			r := &RemoteMachineController{
+				Client: c,
+				healthProber: func(_ *infrastructure.RemoteMachine, credentials sshCredentials, mode RemoteMachineMode) (bool, []kmapi.ComponentHealth, error) {
+					require.Equal(t, "secret-key", string(credentials.key))
+					require.Equal(t, ModeController, mode)
+					probed = true
+					return tt.reachable, tt.components, tt.probeErr
+				},
+			}
+
+			rm := &infrastructure.RemoteMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "rm", Namespace: "default"},
+				Spec: infrastructure.RemoteMachineSpec{
+					SSHKeyRef:   infrastructure.SecretRef{Name: "ssh-key"},
+					HealthCheck: &infrastructure.HealthCheckSpec{Interval: metav1.Duration{Duration: time.Minute}, FailureThreshold: 3},
+				},
+				Status: infrastructure.RemoteMachineStatus{Ready: true, HealthCheckFailures: tt.failures},
+			}
+			if tt.lastCheck > 0 {
+				rm.Status.LastHealthCheckTime = &metav1.Time{Time: time.Now().Add(-tt.lastCheck)}
+			}
+
+			res, err := r.reconcileHealth(context.Background(), rm, ModeController)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantProbed, probed)
+			require.Equal(t, tt.wantFailures, rm.Status.HealthCheckFailures)
+			require.Equal(t, tt.wantFailedReason, rm.Status.FailureReason)
+			require.Equal(t, tt.wantFailedReason == "", rm.Status.Ready)
+			if !tt.wantProbed {
+				require.Greater(t, res.RequeueAfter, time.Duration(0))
+				require.LessOrEqual(t, res.RequeueAfter, 30*time.Second)
+				return
+			}
+			require.Equal(t, tt.wantResult, res)
+			require.Equal(t, tt.wantReachable, conditions.IsTrue(rm, infrastructure.HostReachableCondition))
+			require.Equal(t, tt.wantK0sRunning, conditions.IsTrue(rm, infrastructure.K0sRunningCondition))
+			require.Equal(t, tt.components, rm.Status.Components)
+		})
+	}
+}
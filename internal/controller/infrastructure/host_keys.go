@@ -0,0 +1,228 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // used by the hashed host names of the known hosts
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	api "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+)
+
+// hostKeyScanTimeout is the timeout of the connection made to read the host key of a machine.
+const hostKeyScanTimeout = 30 * time.Second
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+type dialFunc func(network, address string) (net.Conn, error)
+
+// pinHostKeys pins the host keys of the machine and its bastion host in the status, so the SSH connections are
+// made only to the hosts presenting the pinned keys. The keys are read from the hosts at the first connection and
+// verified against the known hosts, if set, or trusted on first use otherwise. The pinned keys are verified against
+// the known hosts on every connection, so removing a key from the known hosts revokes it.
+func pinHostKeys(rm *api.RemoteMachine, bastionAuthM []ssh.AuthMethod, knownHosts []byte) error {
+	dial := dialFunc((&net.Dialer{Timeout: hostKeyScanTimeout}).Dial)
+	if bastion := rm.Spec.Bastion; bastion != nil {
+		bastionAddress := net.JoinHostPort(bastion.Address, strconv.Itoa(bastion.Port))
+		bastionKey, err := pinHostKey(&rm.Status.BastionHostKey, bastionAddress, knownHosts, dial)
+		if err != nil {
+			return fmt.Errorf("bastion: %w", err)
+		}
+
+		if rm.Status.HostKey == "" {
+			// The host key of the machine is read through the bastion host
+			bastionClient, err := ssh.Dial("tcp", bastionAddress, &ssh.ClientConfig{
+				User:            bastion.User,
+				Auth:            bastionAuthM,
+				HostKeyCallback: ssh.FixedHostKey(bastionKey),
+				Timeout:         hostKeyScanTimeout,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to connect to bastion host: %w", err)
+			}
+			defer bastionClient.Close()
+			dial = bastionClient.Dial
+		}
+	}
+
+	_, err := pinHostKey(&rm.Status.HostKey, net.JoinHostPort(rm.SSHAddress(), strconv.Itoa(rm.Spec.Port)), knownHosts, dial)
+	return err
+}
+
+// pinHostKey returns the pinned host key of the address, reading and pinning it first if it's not pinned yet.
+func pinHostKey(pinned *string, address string, knownHosts []byte, dial dialFunc) (ssh.PublicKey, error) {
+	var (
+		key ssh.PublicKey
+		err error
+	)
+	if *pinned != "" {
+		key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(*pinned))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pinned host key of %s: %w", address, err)
+		}
+	} else {
+		key, err = scanHostKey(address, dial)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if knownHosts != nil {
+		if err := checkKnownHost(knownHosts, address, key); err != nil {
+			return nil, err
+		}
+	}
+
+	*pinned = hostKeyString(key)
+	return key, nil
+}
+
+// scanHostKey returns the host key presented by the SSH server at the address. The connection is closed as soon as
+// the key is received, before authenticating.
+func scanHostKey(address string, dial dialFunc) (ssh.PublicKey, error) {
+	conn, err := dial("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+	// The connections made through the bastion host don't support deadlines
+	_ = conn.SetDeadline(time.Now().Add(hostKeyScanTimeout))
+
+	var key ssh.PublicKey
+	_, _, _, err = ssh.NewClientConn(conn, address, &ssh.ClientConfig{
+		HostKeyCallback: func(_ string, _ net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errHostKeyScanned
+		},
+	})
+	if key == nil {
+		return nil, fmt.Errorf("failed to read host key of %s: %w", address, err)
+	}
+
+	return key, nil
+}
+
+// checkKnownHost checks that the host key of the address is listed in the known hosts and not revoked.
+func checkKnownHost(knownHosts []byte, address string, key ssh.PublicKey) error {
+	host := knownhosts.Normalize(address)
+	known := false
+	rest := knownHosts
+	for {
+		marker, hosts, knownKey, _, next, err := ssh.ParseKnownHosts(rest)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse known hosts: %w", err)
+		}
+		rest = next
+
+		sameKey := bytes.Equal(knownKey.Marshal(), key.Marshal())
+		switch {
+		case marker == "revoked" && sameKey:
+			return fmt.Errorf("host key of %s is revoked", address)
+		case marker == "" && sameKey && knownHostMatches(hosts, host):
+			known = true
+		}
+	}
+
+	if !known {
+		return fmt.Errorf("host key %s of %s is not in the known hosts", ssh.FingerprintSHA256(key), address)
+	}
+	return nil
+}
+
+// knownHostMatches checks whether the host patterns of a known hosts entry match the normalized address.
+// The patterns can be hashed host names, have * and ? wildcards and be negated with !.
+func knownHostMatches(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "|1|") {
+			matched = matched || hashedHostMatches(pattern, host)
+			continue
+		}
+		negated := strings.HasPrefix(pattern, "!")
+		if wildcardMatch(strings.TrimPrefix(pattern, "!"), host) {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// hashedHostMatches checks whether the hashed host name, in the |1|salt|hash format, matches the host.
+func hashedHostMatches(pattern string, host string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+func wildcardMatch(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// hostKeyString returns the host key in the format of the authorized keys, e.g. "ssh-ed25519 AAAAC3Nza...".
+func hostKeyString(key ssh.PublicKey) string {
+	return key.Type() + " " + base64.StdEncoding.EncodeToString(key.Marshal())
+}
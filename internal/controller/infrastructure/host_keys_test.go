@@ -0,0 +1,170 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return signer
+}
+
+// newTestSSHServer starts an SSH server presenting the host key and returns its address.
+func newTestSSHServer(t *testing.T, hostKey ssh.Signer) string {
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestCheckKnownHost(t *testing.T) {
+	key := newTestHostKey(t).PublicKey()
+	otherKey := newTestHostKey(t).PublicKey()
+
+	tests := []struct {
+		name       string
+		knownHosts string
+		address    string
+		wantErr    string
+	}{
+		{
+			name:       "known host",
+			knownHosts: knownhosts.Line([]string{"10.0.0.1"}, key),
+			address:    "10.0.0.1:22",
+		},
+		{
+			name:       "known host with port",
+			knownHosts: knownhosts.Line([]string{"10.0.0.1:2222"}, key),
+			address:    "10.0.0.1:2222",
+		},
+		{
+			name:       "hashed host",
+			knownHosts: knownhosts.Line([]string{knownhosts.HashHostname("node.example.com")}, key),
+			address:    "node.example.com:22",
+		},
+		{
+			name:       "wildcard",
+			knownHosts: "# nodes\n" + knownhosts.Line([]string{"*.example.com"}, key),
+			address:    "node.example.com:22",
+		},
+		{
+			name:       "negated host",
+			knownHosts: knownhosts.Line([]string{"*.example.com", "!node.example.com"}, key),
+			address:    "node.example.com:22",
+			wantErr:    "is not in the known hosts",
+		},
+		{
+			name:       "other port",
+			knownHosts: knownhosts.Line([]string{"10.0.0.1"}, key),
+			address:    "10.0.0.1:2222",
+			wantErr:    "is not in the known hosts",
+		},
+		{
+			name:       "other key",
+			knownHosts: knownhosts.Line([]string{"10.0.0.1"}, otherKey),
+			address:    "10.0.0.1:22",
+			wantErr:    "is not in the known hosts",
+		},
+		{
+			name:       "revoked key",
+			knownHosts: knownhosts.Line([]string{"10.0.0.1"}, key) + "\n@revoked * " + hostKeyString(key),
+			address:    "10.0.0.1:22",
+			wantErr:    "host key of 10.0.0.1:22 is revoked",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkKnownHost([]byte(tt.knownHosts), tt.address, key)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestPinHostKey(t *testing.T) {
+	hostKey := newTestHostKey(t)
+	address := newTestSSHServer(t, hostKey)
+	dial := dialFunc(net.Dial)
+
+	t.Run("trust on first use", func(t *testing.T) {
+		var pinned string
+		key, err := pinHostKey(&pinned, address, nil, dial)
+		require.NoError(t, err)
+		require.Equal(t, hostKeyString(hostKey.PublicKey()), pinned)
+		require.Equal(t, hostKey.PublicKey().Marshal(), key.Marshal())
+	})
+
+	t.Run("known hosts", func(t *testing.T) {
+		var pinned string
+		_, err := pinHostKey(&pinned, address, []byte(knownhosts.Line([]string{address}, hostKey.PublicKey())), dial)
+		require.NoError(t, err)
+		require.Equal(t, hostKeyString(hostKey.PublicKey()), pinned)
+	})
+
+	t.Run("unknown host", func(t *testing.T) {
+		var pinned string
+		_, err := pinHostKey(&pinned, address, []byte(knownhosts.Line([]string{"10.0.0.1"}, hostKey.PublicKey())), dial)
+		require.ErrorContains(t, err, "is not in the known hosts")
+		require.Empty(t, pinned)
+	})
+
+	t.Run("pinned key is kept", func(t *testing.T) {
+		pinned := hostKeyString(newTestHostKey(t).PublicKey())
+		want := pinned
+		_, err := pinHostKey(&pinned, address, nil, dial)
+		require.NoError(t, err)
+		require.Equal(t, want, pinned)
+	})
+
+	t.Run("pinned key removed from known hosts", func(t *testing.T) {
+		pinned := hostKeyString(newTestHostKey(t).PublicKey())
+		_, err := pinHostKey(&pinned, address, []byte(knownhosts.Line([]string{address}, hostKey.PublicKey())), dial)
+		require.ErrorContains(t, err, "is not in the known hosts")
+	})
+}
@@ -128,13 +128,18 @@ func (p *JobProvisioner) Provision(ctx context.Context) error {
 func (p *JobProvisioner) extractCloudInit(cloudInit *cloudinit.CloudInit) (volume v1.Volume, volumeMounts []v1.VolumeMount, secretData map[string][]byte) {
 	machineDSN := p.machineDSN()
 
-	var sshCommand, scpCommand string
+	sshCommand, scpCommand := p.provisionJob.SSHCommand, p.provisionJob.SCPCommand
+	if bastion := p.remoteMachine.Spec.Bastion; bastion != nil {
+		// Both ssh and scp connect through the bastion with the ProxyJump option
+		proxyJump := fmt.Sprintf("-o ProxyJump=%s@%s:%d", bastion.User, bastion.Address, bastion.Port)
+		sshCommand = fmt.Sprintf("%s %s", sshCommand, proxyJump)
+		scpCommand = fmt.Sprintf("%s %s", scpCommand, proxyJump)
+	}
 	if p.remoteMachine.Spec.Port != 0 {
-		sshCommand = fmt.Sprintf("%s -p %d %s", p.provisionJob.SSHCommand, p.remoteMachine.Spec.Port, machineDSN)
-		scpCommand = fmt.Sprintf("%s -P %d", p.provisionJob.SCPCommand, p.remoteMachine.Spec.Port)
+		sshCommand = fmt.Sprintf("%s -p %d %s", sshCommand, p.remoteMachine.Spec.Port, machineDSN)
+		scpCommand = fmt.Sprintf("%s -P %d", scpCommand, p.remoteMachine.Spec.Port)
 	} else {
-		sshCommand = fmt.Sprintf("%s %s", p.provisionJob.SSHCommand, machineDSN)
-		scpCommand = p.provisionJob.SCPCommand
+		sshCommand = fmt.Sprintf("%s %s", sshCommand, machineDSN)
 	}
 
 	volume = v1.Volume{
@@ -0,0 +1,324 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/k0sproject/rig"
+	"gopkg.in/yaml.v3"
+
+	api "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/cloudinit"
+)
+
+const (
+	networkRendererNetplan        = "Netplan"
+	networkRendererNetworkManager = "NetworkManager"
+
+	netplanConfigPath           = "/etc/netplan/60-k0smotron.yaml"
+	networkManagerConnectionDir = "/etc/NetworkManager/system-connections"
+	networkManagerIDPrefix      = "k0smotron-"
+)
+
+// The network configuration is applied in the background, as applying it may drop the SSH connection, and the
+// machine is reconnected to after it.
+var (
+	networkReconnectDelay    = 5 * time.Second
+	networkReconnectAttempts = 12
+)
+
+// networkConfig is the static network configuration of a machine rendered for its network configuration tool.
+type networkConfig struct {
+	files        []cloudinit.File
+	applyCommand string
+	// address is the address the machine is connected to after the configuration has been applied.
+	address string
+}
+
+// renderNetworkConfig renders the static network configuration. The machine keeps being connected to the address
+// if the configuration keeps it, otherwise to the first configured address.
+func renderNetworkConfig(network *api.NetworkSpec, address string) (networkConfig, error) {
+	config := networkConfig{address: address}
+	if err := validateNetwork(network); err != nil {
+		return config, err
+	}
+
+	var configured []string
+	for _, iface := range network.Interfaces {
+		configured = append(configured, iface.Addresses...)
+		for _, vlan := range iface.VLANs {
+			configured = append(configured, vlan.Addresses...)
+		}
+	}
+	if ip := net.ParseIP(address); ip != nil && len(configured) > 0 {
+		config.address = addressIP(configured[0])
+		for _, a := range configured {
+			if addressIP(a) == ip.String() {
+				config.address = address
+				break
+			}
+		}
+	}
+
+	switch network.Renderer {
+	case "", networkRendererNetplan:
+		content, err := renderNetplan(network)
+		if err != nil {
+			return config, err
+		}
+		config.files = []cloudinit.File{{Path: netplanConfigPath, Content: content, Permissions: "0600"}}
+		config.applyCommand = "netplan apply"
+	case networkRendererNetworkManager:
+		cmds := []string{"nmcli connection reload"}
+		for _, iface := range network.Interfaces {
+			config.files = append(config.files, renderNetworkManagerConnection(network, iface.Name, "", 0, iface.Addresses, iface.Gateway, iface.MTU))
+			cmds = append(cmds, "nmcli connection up "+networkManagerIDPrefix+iface.Name)
+			for _, vlan := range iface.VLANs {
+				config.files = append(config.files, renderNetworkManagerConnection(network, vlanName(iface.Name, vlan.ID), iface.Name, vlan.ID, vlan.Addresses, vlan.Gateway, 0))
+				cmds = append(cmds, "nmcli connection up "+networkManagerIDPrefix+vlanName(iface.Name, vlan.ID))
+			}
+		}
+		config.applyCommand = strings.Join(cmds, " && ")
+	default:
+		return config, fmt.Errorf("unsupported network renderer %q", network.Renderer)
+	}
+
+	return config, nil
+}
+
+func validateNetwork(network *api.NetworkSpec) error {
+	for _, iface := range network.Interfaces {
+		if iface.Name == "" {
+			return fmt.Errorf("interface name must be set")
+		}
+		if err := validateAddresses(iface.Name, iface.Addresses, iface.Gateway); err != nil {
+			return err
+		}
+		for _, vlan := range iface.VLANs {
+			if err := validateAddresses(vlanName(iface.Name, vlan.ID), vlan.Addresses, vlan.Gateway); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ns := range network.Nameservers {
+		if net.ParseIP(ns) == nil {
+			return fmt.Errorf("invalid nameserver %q", ns)
+		}
+	}
+	return nil
+}
+
+func validateAddresses(name string, addresses []string, gateway string) error {
+	for _, a := range addresses {
+		if _, _, err := net.ParseCIDR(a); err != nil {
+			return fmt.Errorf("invalid address %q of interface %s: %w", a, name, err)
+		}
+	}
+	if gateway != "" && net.ParseIP(gateway) == nil {
+		return fmt.Errorf("invalid gateway %q of interface %s", gateway, name)
+	}
+	return nil
+}
+
+func renderNetplan(network *api.NetworkSpec) (string, error) {
+	var nameservers map[string]interface{}
+	if len(network.Nameservers) > 0 || len(network.SearchDomains) > 0 {
+		nameservers = map[string]interface{}{}
+		if len(network.Nameservers) > 0 {
+			nameservers["addresses"] = network.Nameservers
+		}
+		if len(network.SearchDomains) > 0 {
+			nameservers["search"] = network.SearchDomains
+		}
+	}
+
+	ethernets := map[string]interface{}{}
+	vlans := map[string]interface{}{}
+	for _, iface := range network.Interfaces {
+		ethernets[iface.Name] = netplanInterface(iface.Addresses, iface.Gateway, iface.MTU, nameservers)
+		for _, vlan := range iface.VLANs {
+			v := netplanInterface(vlan.Addresses, vlan.Gateway, 0, nameservers)
+			v["id"] = vlan.ID
+			v["link"] = iface.Name
+			vlans[vlanName(iface.Name, vlan.ID)] = v
+		}
+	}
+
+	config := map[string]interface{}{
+		"version":   2,
+		"ethernets": ethernets,
+	}
+	if len(vlans) > 0 {
+		config["vlans"] = vlans
+	}
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(map[string]interface{}{"network": config}); err != nil {
+		return "", fmt.Errorf("failed to render netplan configuration: %w", err)
+	}
+	return b.String(), nil
+}
+
+func netplanInterface(addresses []string, gateway string, mtu int, nameservers map[string]interface{}) map[string]interface{} {
+	iface := map[string]interface{}{
+		"dhcp4": false,
+		"dhcp6": false,
+	}
+	if len(addresses) > 0 {
+		iface["addresses"] = addresses
+	}
+	if gateway != "" {
+		iface["routes"] = []interface{}{map[string]interface{}{"to": "default", "via": gateway}}
+	}
+	if mtu > 0 {
+		iface["mtu"] = mtu
+	}
+	if nameservers != nil {
+		iface["nameservers"] = nameservers
+	}
+	return iface
+}
+
+// renderNetworkManagerConnection renders the keyfile of the NetworkManager connection of an interface, or of a VLAN
+// interface if the parent is set.
+func renderNetworkManagerConnection(network *api.NetworkSpec, name, parent string, vlanID int, addresses []string, gateway string, mtu int) cloudinit.File {
+	var b strings.Builder
+	id := networkManagerIDPrefix + name
+
+	connType := "ethernet"
+	if parent != "" {
+		connType = "vlan"
+	}
+	fmt.Fprintf(&b, "[connection]\nid=%s\ntype=%s\ninterface-name=%s\nautoconnect-priority=100\n", id, connType, name)
+	if parent != "" {
+		fmt.Fprintf(&b, "\n[vlan]\nid=%d\nparent=%s\n", vlanID, parent)
+	} else if mtu > 0 {
+		fmt.Fprintf(&b, "\n[ethernet]\nmtu=%d\n", mtu)
+	}
+
+	searchWritten := false
+	for _, family := range []struct {
+		section  string
+		ipv6     bool
+		disabled string
+	}{{"ipv4", false, "disabled"}, {"ipv6", true, "ignore"}} {
+		var familyAddresses, familyNameservers []string
+		for _, a := range addresses {
+			if isIPv6(addressIP(a)) == family.ipv6 {
+				familyAddresses = append(familyAddresses, a)
+			}
+		}
+		for _, ns := range network.Nameservers {
+			if isIPv6(ns) == family.ipv6 {
+				familyNameservers = append(familyNameservers, ns)
+			}
+		}
+
+		fmt.Fprintf(&b, "\n[%s]\n", family.section)
+		if len(familyAddresses) == 0 {
+			fmt.Fprintf(&b, "method=%s\n", family.disabled)
+			continue
+		}
+		b.WriteString("method=manual\n")
+		for i, a := range familyAddresses {
+			fmt.Fprintf(&b, "address%d=%s\n", i+1, a)
+		}
+		if gateway != "" && isIPv6(gateway) == family.ipv6 {
+			fmt.Fprintf(&b, "gateway=%s\n", gateway)
+		}
+		if len(familyNameservers) > 0 {
+			fmt.Fprintf(&b, "dns=%s;\n", strings.Join(familyNameservers, ";"))
+		}
+		if len(network.SearchDomains) > 0 && !searchWritten {
+			fmt.Fprintf(&b, "dns-search=%s;\n", strings.Join(network.SearchDomains, ";"))
+			searchWritten = true
+		}
+	}
+
+	return cloudinit.File{
+		Path:        path.Join(networkManagerConnectionDir, id+".nmconnection"),
+		Content:     b.String(),
+		Permissions: "0600",
+	}
+}
+
+// configureNetwork applies the static network configuration of the machine and returns the connection to the machine
+// made after it. The connection is made to the configured address if the configuration changes the address of the
+// machine.
+func (p *SSHProvisioner) configureNetwork(conn *rig.Connection) (*rig.Connection, error) {
+	config, err := renderNetworkConfig(p.machine.Spec.Network, p.machine.Spec.Address)
+	if err != nil {
+		return conn, fmt.Errorf("invalid network configuration: %w", err)
+	}
+
+	for _, file := range config.files {
+		if err := p.uploadFile(conn, file); err != nil {
+			return conn, fmt.Errorf("failed to write network configuration: %w", err)
+		}
+	}
+
+	// Detach the apply from the SSH session, it may be dropped by the new configuration
+	apply := fmt.Sprintf("nohup sh -c %s > /dev/null 2>&1 < /dev/null &", shellQuote("sleep 2; "+config.applyCommand))
+	if output, err := p.exec(conn, apply, ""); err != nil {
+		return conn, fmt.Errorf("failed to apply network configuration: %w: %s", err, output)
+	}
+	conn.Disconnect()
+
+	if config.address != p.machine.Spec.Address {
+		p.machine.Status.ConfiguredAddress = config.address
+	} else {
+		p.machine.Status.ConfiguredAddress = ""
+	}
+	p.log.Info("applied network configuration", "address", p.machine.SSHAddress())
+
+	var lastErr error
+	for i := 0; i < networkReconnectAttempts; i++ {
+		time.Sleep(networkReconnectDelay)
+		newConn, err := sshConnection(p.machine, p.credentials)
+		if err != nil {
+			return conn, err
+		}
+		if lastErr = newConn.Connect(); lastErr == nil {
+			return newConn, nil
+		}
+	}
+	return conn, retryableError{fmt.Errorf("failed to reconnect to %s after applying the network configuration: %w", p.machine.SSHAddress(), lastErr)}
+}
+
+func vlanName(parent string, id int) string {
+	return fmt.Sprintf("%s.%d", parent, id)
+}
+
+// addressIP returns the IP of an address in the CIDR notation.
+func addressIP(cidr string) string {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return cidr
+	}
+	return ip.String()
+}
+
+func isIPv6(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() == nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	api "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+)
+
+func testNetwork(renderer string) *api.NetworkSpec {
+	return &api.NetworkSpec{
+		Renderer: renderer,
+		Interfaces: []api.NetworkInterface{{
+			Name:      "eno1",
+			Addresses: []string{"10.0.0.10/24", "fd00::10/64"},
+			Gateway:   "10.0.0.1",
+			MTU:       9000,
+			VLANs:     []api.VLAN{{ID: 100, Addresses: []string{"10.0.100.10/24"}}},
+		}},
+		Nameservers:   []string{"10.0.0.2", "fd00::2"},
+		SearchDomains: []string{"example.com"},
+	}
+}
+
+func TestRenderNetworkConfig_netplan(t *testing.T) {
+	config, err := renderNetworkConfig(testNetwork(""), "192.168.1.50")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.10", config.address)
+	require.Equal(t, "netplan apply", config.applyCommand)
+	require.Len(t, config.files, 1)
+	require.Equal(t, "/etc/netplan/60-k0smotron.yaml", config.files[0].Path)
+	require.Equal(t, "0600", config.files[0].Permissions)
+	require.Equal(t, `network:
+  ethernets:
+    eno1:
+      addresses:
+        - 10.0.0.10/24
+        - fd00::10/64
+      dhcp4: false
+      dhcp6: false
+      mtu: 9000
+      nameservers:
+        addresses:
+          - 10.0.0.2
+          - fd00::2
+        search:
+          - example.com
+      routes:
+        - to: default
+          via: 10.0.0.1
+  version: 2
+  vlans:
+    eno1.100:
+      addresses:
+        - 10.0.100.10/24
+      dhcp4: false
+      dhcp6: false
+      id: 100
+      link: eno1
+      nameservers:
+        addresses:
+          - 10.0.0.2
+          - fd00::2
+        search:
+          - example.com
+`, config.files[0].Content)
+}
+
+func TestRenderNetworkConfig_networkManager(t *testing.T) {
+	config, err := renderNetworkConfig(testNetwork("NetworkManager"), "10.0.100.10")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.100.10", config.address)
+	require.Equal(t, "nmcli connection reload && nmcli connection up k0smotron-eno1 && nmcli connection up k0smotron-eno1.100", config.applyCommand)
+	require.Len(t, config.files, 2)
+
+	require.Equal(t, "/etc/NetworkManager/system-connections/k0smotron-eno1.nmconnection", config.files[0].Path)
+	require.Equal(t, `[connection]
+id=k0smotron-eno1
+type=ethernet
+interface-name=eno1
+autoconnect-priority=100
+
+[ethernet]
+mtu=9000
+
+[ipv4]
+method=manual
+address1=10.0.0.10/24
+gateway=10.0.0.1
+dns=10.0.0.2;
+dns-search=example.com;
+
+[ipv6]
+method=manual
+address1=fd00::10/64
+dns=fd00::2;
+`, config.files[0].Content)
+
+	require.Equal(t, "/etc/NetworkManager/system-connections/k0smotron-eno1.100.nmconnection", config.files[1].Path)
+	require.Equal(t, `[connection]
+id=k0smotron-eno1.100
+type=vlan
+interface-name=eno1.100
+autoconnect-priority=100
+
+[vlan]
+id=100
+parent=eno1
+
+[ipv4]
+method=manual
+address1=10.0.100.10/24
+dns=10.0.0.2;
+dns-search=example.com;
+
+[ipv6]
+method=ignore
+`, config.files[1].Content)
+}
+
+func TestRenderNetworkConfig_address(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+	}{
+		{name: "kept", address: "10.0.0.10", want: "10.0.0.10"},
+		{name: "changed", address: "192.168.1.50", want: "10.0.0.10"},
+		{name: "dns name", address: "node-0.example.com", want: "node-0.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := renderNetworkConfig(testNetwork(""), tt.address)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, config.address)
+		})
+	}
+}
+
+func TestRenderNetworkConfig_invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		network *api.NetworkSpec
+		wantErr string
+	}{
+		{
+			name:    "address without prefix",
+			network: &api.NetworkSpec{Interfaces: []api.NetworkInterface{{Name: "eno1", Addresses: []string{"10.0.0.10"}}}},
+			wantErr: `invalid address "10.0.0.10" of interface eno1: invalid CIDR address: 10.0.0.10`,
+		},
+		{
+			name:    "invalid gateway",
+			network: &api.NetworkSpec{Interfaces: []api.NetworkInterface{{Name: "eno1", VLANs: []api.VLAN{{ID: 10, Gateway: "gw"}}}}},
+			wantErr: `invalid gateway "gw" of interface eno1.10`,
+		},
+		{
+			name:    "invalid nameserver",
+			network: &api.NetworkSpec{Interfaces: []api.NetworkInterface{{Name: "eno1"}}, Nameservers: []string{"dns.example.com"}},
+			wantErr: `invalid nameserver "dns.example.com"`,
+		},
+		{
+			name:    "unsupported renderer",
+			network: &api.NetworkSpec{Renderer: "ifupdown", Interfaces: []api.NetworkInterface{{Name: "eno1"}}},
+			wantErr: `unsupported network renderer "ifupdown"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderNetworkConfig(tt.network, "10.0.0.10")
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
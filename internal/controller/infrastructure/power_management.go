@@ -0,0 +1,137 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/redfish"
+)
+
+// powerClient creates the Redfish client of the power management using the credentials in the given namespace.
+func (r *RemoteMachineController) powerClient(ctx context.Context, namespace string, pm *infrastructure.PowerManagementSpec) (*redfish.Client, error) {
+	secret := &v1.Secret{}
+	key := client.ObjectKey{
+		Namespace: namespace,
+		Name:      pm.CredentialsRef.Name,
+	}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("failed to get power management credentials: %w", err)
+	}
+
+	return redfish.NewClient(pm.Address, pm.SystemID, string(secret.Data["username"]), string(secret.Data["password"]), pm.InsecureSkipVerify), nil
+}
+
+// reconcilePowerOn powers on the machine before it's provisioned, e.g. a pooled machine powered off when it was
+// released back to the pool.
+func (r *RemoteMachineController) reconcilePowerOn(ctx context.Context, rm *infrastructure.RemoteMachine) error {
+	err := r.powerOn(ctx, rm)
+	if err != nil {
+		conditions.MarkFalse(rm, infrastructure.PoweredOnCondition, infrastructure.PowerManagementFailedReason, clusterv1.ConditionSeverityError,
+			"The machine can't be powered on through %s: %s", rm.Spec.PowerManagement.Address, err)
+		return err
+	}
+
+	conditions.MarkTrue(rm, infrastructure.PoweredOnCondition)
+	return nil
+}
+
+func (r *RemoteMachineController) powerOn(ctx context.Context, rm *infrastructure.RemoteMachine) error {
+	c, err := r.powerClient(ctx, rm.Namespace, rm.Spec.PowerManagement)
+	if err != nil {
+		return err
+	}
+
+	state, err := c.PowerState(ctx)
+	if err != nil {
+		return err
+	}
+	if state != redfish.PowerStateOff {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Powering on RemoteMachine", "powerState", state)
+	return c.Reset(ctx, redfish.ResetOn)
+}
+
+// powerCycleIfStuck power-cycles the machine if it hasn't accepted the SSH connection for the power cycle timeout
+// since the preflight checks started failing or since the machine was last power-cycled.
+func (r *RemoteMachineController) powerCycleIfStuck(ctx context.Context, rm *infrastructure.RemoteMachine) error {
+	pm := rm.Spec.PowerManagement
+	if pm == nil || pm.PowerCycleAfter == nil || pm.PowerCycleAfter.Duration == 0 {
+		return nil
+	}
+
+	failing := conditions.Get(rm, infrastructure.PreflightChecksSucceededCondition)
+	if failing == nil || failing.Status != v1.ConditionFalse {
+		return nil
+	}
+	stuckSince := failing.LastTransitionTime.Time
+	if rm.Status.LastPowerCycleTime != nil && rm.Status.LastPowerCycleTime.After(stuckSince) {
+		stuckSince = rm.Status.LastPowerCycleTime.Time
+	}
+	if time.Since(stuckSince) < pm.PowerCycleAfter.Duration {
+		return nil
+	}
+
+	c, err := r.powerClient(ctx, rm.Namespace, pm)
+	if err != nil {
+		return err
+	}
+	state, err := c.PowerState(ctx)
+	if err != nil {
+		return err
+	}
+	resetType := redfish.ResetForceRestart
+	if state == redfish.PowerStateOff {
+		resetType = redfish.ResetOn
+	}
+
+	log.FromContext(ctx).Info("Power-cycling RemoteMachine not accepting SSH connections", "stuckSince", stuckSince, "resetType", resetType)
+	if err := c.Reset(ctx, resetType); err != nil {
+		return err
+	}
+	now := metav1.Now()
+	rm.Status.LastPowerCycleTime = &now
+
+	return nil
+}
+
+// powerOffPooledMachine powers off the pooled machine released back to the pool.
+func (r *RemoteMachineController) powerOffPooledMachine(ctx context.Context, pooledMachine *infrastructure.PooledRemoteMachine) error {
+	pm := pooledMachine.Spec.Machine.PowerManagement
+	if pm == nil {
+		return nil
+	}
+
+	c, err := r.powerClient(ctx, pooledMachine.Namespace, pm)
+	if err != nil {
+		return err
+	}
+
+	return c.Reset(ctx, redfish.ResetForceOff)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+)
+
+// fakeBMC is a Redfish service with a single computer system.
+type fakeBMC struct {
+	powerState string
+	resets     []string
+}
+
+func (b *fakeBMC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method + " " + r.URL.Path {
+	case "GET /redfish/v1/Systems":
+		fmt.Fprint(w, `{"Members": [{"@odata.id": "/redfish/v1/Systems/1"}]}`)
+	case "GET /redfish/v1/Systems/1":
+		fmt.Fprintf(w, `{"PowerState": %q}`, b.powerState)
+	case "POST /redfish/v1/Systems/1/Actions/ComputerSystem.Reset":
+		body := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b.resets = append(b.resets, body["ResetType"])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newPowerManagementTestController(t *testing.T, objs ...client.Object) *RemoteMachineController {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, infrastructure.AddToScheme(scheme))
+	objs = append(objs, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bmc", Namespace: "default"},
+		Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("secret")},
+	})
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&infrastructure.PooledRemoteMachine{}).
+		WithIndex(&infrastructure.PooledRemoteMachine{}, pooledMachineReservedForField, indexPooledMachineReservedFor).
+		Build()
+	return &RemoteMachineController{Client: c}
+}
+
+func TestRemoteMachineController_reconcilePowerOn(t *testing.T) {
+	tests := []struct {
+		powerState string
+		wantResets []string
+	}{
+		{powerState: "Off", wantResets: []string{"On"}},
+		{powerState: "On"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.powerState, func(t *testing.T) {
+			bmc := &fakeBMC{powerState: tt.powerState}
+			srv := httptest.NewServer(bmc)
+			defer srv.Close()
+
+			r := newPowerManagementTestController(t)
+			rm := &infrastructure.RemoteMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "rm", Namespace: "default"},
+				Spec: infrastructure.RemoteMachineSpec{
+					PowerManagement: &infrastructure.PowerManagementSpec{Address: srv.URL, CredentialsRef: infrastructure.SecretRef{Name: "bmc"}},
+				},
+			}
+			require.NoError(t, r.reconcilePowerOn(context.Background(), rm))
+			require.Equal(t, tt.wantResets, bmc.resets)
+			require.True(t, conditions.IsTrue(rm, infrastructure.PoweredOnCondition))
+		})
+	}
+}
+
+func TestRemoteMachineController_powerCycleIfStuck(t *testing.T) {
+	tests := []struct {
+		name           string
+		failingFor     time.Duration
+		lastPowerCycle time.Duration
+		wantResets     []string
+	}{
+		{
+			name:       "not stuck yet",
+			failingFor: 5 * time.Minute,
+		},
+		{
+			name:       "stuck",
+			failingFor: 15 * time.Minute,
+			wantResets: []string{"ForceRestart"},
+		},
+		{
+			name:           "power-cycled recently",
+			failingFor:     30 * time.Minute,
+			lastPowerCycle: 5 * time.Minute,
+		},
+		{
+			name:           "stuck after power cycle",
+			failingFor:     30 * time.Minute,
+			lastPowerCycle: 15 * time.Minute,
+			wantResets:     []string{"ForceRestart"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bmc := &fakeBMC{powerState: "On"}
+			srv := httptest.NewServer(bmc)
+			defer srv.Close()
+
+			r := newPowerManagementTestController(t)
+			rm := &infrastructure.RemoteMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "rm", Namespace: "default"},
+				Spec: infrastructure.RemoteMachineSpec{
+					PowerManagement: &infrastructure.PowerManagementSpec{
+						Address:         srv.URL,
+						CredentialsRef:  infrastructure.SecretRef{Name: "bmc"},
+						PowerCycleAfter: &metav1.Duration{Duration: 10 * time.Minute},
+					},
+				},
+			}
+			conditions.Set(rm, &clusterv1.Condition{
+				Type:               infrastructure.PreflightChecksSucceededCondition,
+				Status:             v1.ConditionFalse,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-tt.failingFor)),
+			})
+			if tt.lastPowerCycle != 0 {
+				rm.Status.LastPowerCycleTime = &metav1.Time{Time: time.Now().Add(-tt.lastPowerCycle)}
+			}
+
+			require.NoError(t, r.powerCycleIfStuck(context.Background(), rm))
+			require.Equal(t, tt.wantResets, bmc.resets)
+			if tt.wantResets != nil {
+				require.WithinDuration(t, time.Now(), rm.Status.LastPowerCycleTime.Time, time.Minute)
+			}
+		})
+	}
+}
+
+func TestRemoteMachineController_returnMachineToPoolPowersOff(t *testing.T) {
+	bmc := &fakeBMC{powerState: "On"}
+	srv := httptest.NewServer(bmc)
+	defer srv.Close()
+
+	pm := newPooledMachine("pooled", "default", nil, "rm")
+	pm.Spec.Machine.PowerManagement = &infrastructure.PowerManagementSpec{Address: srv.URL, CredentialsRef: infrastructure.SecretRef{Name: "bmc"}}
+	r := newPowerManagementTestController(t, pm)
+
+	rm := &infrastructure.RemoteMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "rm", Namespace: "default"},
+		Spec:       infrastructure.RemoteMachineSpec{Pool: "default"},
+	}
+	require.NoError(t, r.returnMachineToPool(context.Background(), rm, nil))
+	require.Equal(t, []string{"ForceOff"}, bmc.resets)
+
+	var got infrastructure.PooledRemoteMachine
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(pm), &got))
+	require.False(t, got.Status.Reserved)
+}
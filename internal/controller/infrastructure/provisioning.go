@@ -0,0 +1,128 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+)
+
+const (
+	defaultProvisionMaxAttempts    = 5
+	defaultProvisionInitialBackoff = 10 * time.Second
+	defaultProvisionMaxBackoff     = 5 * time.Minute
+
+	// provisioningSlotRequeueAfter is the time to wait before checking again whether a machine waiting for the
+	// other machines of the cluster to be provisioned can be provisioned.
+	provisioningSlotRequeueAfter = 10 * time.Second
+)
+
+// retryableError marks the provisioning errors the provisioning is retried on, e.g. the SSH connection failures.
+type retryableError struct {
+	error
+}
+
+func (e retryableError) Unwrap() error {
+	return e.error
+}
+
+func isRetryable(err error) bool {
+	var retryable retryableError
+	return errors.As(err, &retryable)
+}
+
+// provisionLimiter limits the number of machines of a cluster provisioned at the same time.
+type provisionLimiter struct {
+	mu       sync.Mutex
+	inFlight map[types.NamespacedName]int
+}
+
+// acquire reserves a provisioning slot of the cluster, unless the limit of the cluster is reached.
+func (l *provisionLimiter) acquire(cluster types.NamespacedName, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit > 0 && l.inFlight[cluster] >= limit {
+		return false
+	}
+	if l.inFlight == nil {
+		l.inFlight = map[types.NamespacedName]int{}
+	}
+	l.inFlight[cluster]++
+	return true
+}
+
+// release releases a provisioning slot of the cluster.
+func (l *provisionLimiter) release(cluster types.NamespacedName) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight[cluster]--
+	if l.inFlight[cluster] <= 0 {
+		delete(l.inFlight, cluster)
+	}
+}
+
+// getProvisioningSpec returns the provisioning settings of the RemoteCluster of the cluster, or the defaults if the
+// infrastructure of the cluster is not a RemoteCluster.
+func (r *RemoteMachineController) getProvisioningSpec(ctx context.Context, cluster *clusterv1.Cluster) (infrastructure.ProvisioningSpec, error) {
+	ref := cluster.Spec.InfrastructureRef
+	if ref == nil || ref.Kind != "RemoteCluster" {
+		return infrastructure.ProvisioningSpec{}, nil
+	}
+
+	rc := &infrastructure.RemoteCluster{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: ref.Name}, rc); err != nil {
+		return infrastructure.ProvisioningSpec{}, err
+	}
+	return rc.Spec.Provisioning, nil
+}
+
+// provisionMaxAttempts returns the number of attempts to provision a machine on retryable errors.
+func provisionMaxAttempts(spec infrastructure.ProvisioningSpec) int {
+	if spec.MaxAttempts > 0 {
+		return spec.MaxAttempts
+	}
+	return defaultProvisionMaxAttempts
+}
+
+// provisionBackoff returns the time to wait after the given failed attempt before the next one.
+func provisionBackoff(spec infrastructure.ProvisioningSpec, attempt int) time.Duration {
+	backoff, maxBackoff := defaultProvisionInitialBackoff, defaultProvisionMaxBackoff
+	if spec.InitialBackoff != nil {
+		backoff = spec.InitialBackoff.Duration
+	}
+	if spec.MaxBackoff != nil {
+		maxBackoff = spec.MaxBackoff.Duration
+	}
+
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/k0sproject/rig"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+)
+
+// provisioningHooks are the hooks of a machine with their scripts read from the secrets.
+type provisioningHooks struct {
+	preBootstrap []provisioningHook
+	postJoin     []provisioningHook
+}
+
+// provisioningHook is either a command or a script run on a machine.
+type provisioningHook struct {
+	name    string
+	command string
+	script  string
+}
+
+// getProvisioningHooks returns the provisioning hooks of the machine, reading the hook scripts from their secrets.
+func (r *RemoteMachineController) getProvisioningHooks(ctx context.Context, rm *infrastructure.RemoteMachine) (provisioningHooks, error) {
+	var hooks provisioningHooks
+	if rm.Spec.Hooks == nil {
+		return hooks, nil
+	}
+
+	var err error
+	hooks.preBootstrap, err = r.resolveHooks(ctx, rm.Namespace, rm.Spec.Hooks.PreBootstrap)
+	if err != nil {
+		return hooks, err
+	}
+	hooks.postJoin, err = r.resolveHooks(ctx, rm.Namespace, rm.Spec.Hooks.PostJoin)
+	return hooks, err
+}
+
+func (r *RemoteMachineController) resolveHooks(ctx context.Context, namespace string, specs []infrastructure.ProvisioningHook) ([]provisioningHook, error) {
+	hooks := make([]provisioningHook, 0, len(specs))
+	for _, spec := range specs {
+		if (spec.Command == "") == (spec.ScriptRef == nil) {
+			return nil, fmt.Errorf("hook %q must have either a command or a scriptRef", spec.Name)
+		}
+
+		hook := provisioningHook{name: spec.Name, command: spec.Command}
+		if spec.ScriptRef != nil {
+			secret := &v1.Secret{}
+			if err := r.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: spec.ScriptRef.Name}, secret); err != nil {
+				return nil, fmt.Errorf("the script secret %s of hook %q can't be read: %w", spec.ScriptRef.Name, spec.Name, err)
+			}
+			hook.script = string(secret.Data["value"])
+			if hook.script == "" {
+				return nil, fmt.Errorf("the script secret %s of hook %q has no value", spec.ScriptRef.Name, spec.Name)
+			}
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// runHooks runs the hooks on the machine in order, stopping at the first failing one.
+func (p *SSHProvisioner) runHooks(conn *rig.Connection, stage string, hooks []provisioningHook) error {
+	for _, hook := range hooks {
+		var (
+			output string
+			err    error
+		)
+		if hook.script != "" {
+			output, err = p.exec(conn, "sh -s", hook.script)
+		} else {
+			output, err = p.exec(conn, hook.command, "")
+		}
+		if err != nil {
+			return fmt.Errorf("%s hook %q failed: %w: %s", stage, hook.name, err, output)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+)
+
+func TestRemoteMachineController_getProvisioningHooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		hooks   *infrastructure.ProvisioningHooks
+		want    provisioningHooks
+		wantErr string
+	}{
+		{
+			name: "no hooks",
+		},
+		{
+			name: "command and script",
+			hooks: &infrastructure.ProvisioningHooks{
+				PreBootstrap: []infrastructure.ProvisioningHook{{Name: "raid", ScriptRef: &infrastructure.SecretRef{Name: "raid-script"}}},
+				PostJoin:     []infrastructure.ProvisioningHook{{Name: "inventory", Command: "register-host"}},
+			},
+			want: provisioningHooks{
+				preBootstrap: []provisioningHook{{name: "raid", script: "mdadm --create /dev/md0"}},
+				postJoin:     []provisioningHook{{name: "inventory", command: "register-host"}},
+			},
+		},
+		{
+			name: "neither command nor script",
+			hooks: &infrastructure.ProvisioningHooks{
+				PreBootstrap: []infrastructure.ProvisioningHook{{Name: "empty"}},
+			},
+			wantErr: `hook "empty" must have either a command or a scriptRef`,
+		},
+		{
+			name: "both command and script",
+			hooks: &infrastructure.ProvisioningHooks{
+				PostJoin: []infrastructure.ProvisioningHook{{Name: "both", Command: "true", ScriptRef: &infrastructure.SecretRef{Name: "raid-script"}}},
+			},
+			wantErr: `hook "both" must have either a command or a scriptRef`,
+		},
+		{
+			name: "missing script secret",
+			hooks: &infrastructure.ProvisioningHooks{
+				PreBootstrap: []infrastructure.ProvisioningHook{{Name: "harden", ScriptRef: &infrastructure.SecretRef{Name: "harden-script"}}},
+			},
+			wantErr: `the script secret harden-script of hook "harden" can't be read: secrets "harden-script" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			c := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "raid-script", Namespace: "default"},
+					Data:       map[string][]byte{"value": []byte("mdadm --create /dev/md0")},
+				}).
+				Build()
+			r := &RemoteMachineController{Client: c}
+
+			rm := &infrastructure.RemoteMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "rm", Namespace: "default"},
+				Spec:       infrastructure.RemoteMachineSpec{Hooks: tt.hooks},
+			}
+			hooks, err := r.getProvisioningHooks(context.Background(), rm)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, hooks)
+		})
+	}
+}
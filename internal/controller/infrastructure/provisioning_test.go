@@ -0,0 +1,84 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+)
+
+func TestProvisionLimiter(t *testing.T) {
+	var l provisionLimiter
+	cluster := types.NamespacedName{Namespace: "default", Name: "my-cluster"}
+	other := types.NamespacedName{Namespace: "default", Name: "other-cluster"}
+
+	require.True(t, l.acquire(cluster, 2))
+	require.True(t, l.acquire(cluster, 2))
+	require.False(t, l.acquire(cluster, 2))
+	require.True(t, l.acquire(other, 2))
+
+	l.release(cluster)
+	require.True(t, l.acquire(cluster, 2))
+
+	for i := 0; i < 10; i++ {
+		require.True(t, l.acquire(other, 0))
+	}
+}
+
+func TestProvisionBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    infrastructure.ProvisioningSpec
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first attempt", attempt: 1, want: 10 * time.Second},
+		{name: "doubled", attempt: 3, want: 40 * time.Second},
+		{name: "capped", attempt: 10, want: 5 * time.Minute},
+		{
+			name:    "custom",
+			spec:    infrastructure.ProvisioningSpec{InitialBackoff: &metav1.Duration{Duration: time.Second}, MaxBackoff: &metav1.Duration{Duration: 3 * time.Second}},
+			attempt: 2,
+			want:    2 * time.Second,
+		},
+		{
+			name:    "custom capped",
+			spec:    infrastructure.ProvisioningSpec{InitialBackoff: &metav1.Duration{Duration: time.Second}, MaxBackoff: &metav1.Duration{Duration: 3 * time.Second}},
+			attempt: 3,
+			want:    3 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, provisionBackoff(tt.spec, tt.attempt))
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	require.True(t, isRetryable(retryableError{errors.New("connection refused")}))
+	require.True(t, isRetryable(fmt.Errorf("provisioning failed: %w", retryableError{errors.New("connection refused")})))
+	require.False(t, isRetryable(errors.New("command failed")))
+}
@@ -19,26 +19,48 @@ package infrastructure
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 
 	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	kmapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/util/retry"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	capiutil "sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/conditions"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/secretstore"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
 )
 
 var ErrPooledMachineNotFound = fmt.Errorf("free pooled machine not found")
 
+// preflightCheckRequeueAfter is the time to wait before running the failed preflight checks again.
+const preflightCheckRequeueAfter = 30 * time.Second
+
+// pooledMachineReservedForField is the field index of the RemoteMachine a PooledRemoteMachine is reserved for,
+// as namespace/name.
+const pooledMachineReservedForField = "status.machineRef"
+
 type Provisioner interface {
 	Provision(ctx context.Context) error
 	Cleanup(ctx context.Context, mode RemoteMachineMode) error
@@ -49,6 +71,19 @@ type RemoteMachineController struct {
 	Scheme     *runtime.Scheme
 	ClientSet  *kubernetes.Clientset
 	RESTConfig *rest.Config
+	// SecretStores are the external secret stores the SSH credentials of the machines are read from.
+	SecretStores *secretstore.Registry
+	// MaxConcurrentProvisions is the maximum number of machines reconciled, and so provisioned, at the same time.
+	MaxConcurrentProvisions int
+	// Recorder records the Events about the failed provisioning of the machines.
+	Recorder record.EventRecorder
+	// Shard selects the resources reconciled by the controller. All the resources are reconciled if nil.
+	Shard *sharding.Shard
+
+	// healthProber probes the health of a provisioned machine. Defaults to probeHealth.
+	healthProber func(rm *infrastructure.RemoteMachine, credentials sshCredentials, mode RemoteMachineMode) (reachable bool, components []kmapi.ComponentHealth, err error)
+
+	provisions provisionLimiter
 }
 
 type RemoteMachineMode int
@@ -69,6 +104,7 @@ const (
 // +kubebuilder:rbac:groups=exp.cluster.x-k8s.io,resources=machinepools;machinepools/status,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *RemoteMachineController) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, err error) {
 	log := log.FromContext(ctx).WithValues("remotemachine", req.NamespacedName)
@@ -107,27 +143,32 @@ func (r *RemoteMachineController) Reconcile(ctx context.Context, req ctrl.Reques
 	}
 
 	log = log.WithValues("machine", machine.Name)
+	ctx = tracing.WithCluster(ctx, machine.Spec.ClusterName)
 
+	var (
+		provisioning infrastructure.ProvisioningSpec
+		clusterKey   types.NamespacedName
+	)
 	if rm.ObjectMeta.DeletionTimestamp.IsZero() {
 		defer func() {
 			// Always update the RemoteMachine status with the phase the state machine is in
-			if err := r.Status().Update(ctx, rm); err != nil {
+			if err := r.updateStatus(ctx, rm); err != nil {
 				log.Error(err, "Failed to update RemoteMachine status")
 			}
 		}()
 
-		if rm.Spec.Pool != "" {
+		if rm.UsesPool() {
 			err := r.reservePooledMachine(ctx, rm)
 			if err != nil {
 				log.Error(err, "Error reserving PooledMachine")
 				return ctrl.Result{Requeue: true}, err
 			}
 		} else if rm.Spec.ProvisionJob == nil {
-			if rm.Spec.Address == "" || rm.Spec.SSHKeyRef.Name == "" {
+			if rm.Spec.Address == "" || (rm.Spec.SSHKeyRef.Name == "" && rm.Spec.SSHCredentialsRef == nil) {
 				rm.Status.FailureReason = "MissingFields"
-				rm.Status.FailureMessage = "If pool is empty, following fields are required: address, sshKeyRef"
+				rm.Status.FailureMessage = "If pool is empty, following fields are required: address, sshKeyRef or sshCredentialsRef"
 				rm.Status.Ready = false
-				if err := r.Status().Update(ctx, rm); err != nil {
+				if err := r.updateStatus(ctx, rm); err != nil {
 					log.Error(err, "Failed to update RemoteMachine status")
 				}
 				return ctrl.Result{Requeue: true}, nil
@@ -156,10 +197,42 @@ func (r *RemoteMachineController) Reconcile(ctx context.Context, req ctrl.Reques
 		}
 
 		if rm.Spec.ProviderID != "" {
+			if rm.Spec.HealthCheck != nil {
+				return r.reconcileHealth(ctx, rm, mode)
+			}
 			log.Info("RemoteMachine already has ProviderID, skipping reconciliation")
 			return ctrl.Result{}, nil
 		}
 
+		provisioning, err = r.getProvisioningSpec(ctx, cluster)
+		if err != nil {
+			log.Error(err, "Failed to get RemoteCluster")
+			return ctrl.Result{}, err
+		}
+		clusterKey = types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name}
+		if conditions.GetReason(rm, infrastructure.ProvisionedCondition) == infrastructure.ProvisionFailedReason &&
+			rm.Status.ProvisionAttempts >= provisionMaxAttempts(provisioning) {
+			log.Info("Provisioning failed permanently, waiting for the machine to be remediated")
+			return ctrl.Result{}, nil
+		}
+
+		if rm.Spec.PowerManagement != nil {
+			if err := r.reconcilePowerOn(ctx, rm); err != nil {
+				log.Error(err, "Failed to power on RemoteMachine")
+				return ctrl.Result{RequeueAfter: preflightCheckRequeueAfter}, nil
+			}
+		}
+
+		if rm.Spec.ProvisionJob == nil {
+			if err := r.reconcilePreflightChecks(ctx, rm); err != nil {
+				log.Error(err, "Preflight checks failed")
+				if err := r.powerCycleIfStuck(ctx, rm); err != nil {
+					log.Error(err, "Failed to power-cycle RemoteMachine")
+				}
+				return ctrl.Result{RequeueAfter: preflightCheckRequeueAfter}, nil
+			}
+		}
+
 		if machine.Spec.Bootstrap.DataSecretName == nil {
 			log.Info("Waiting for Bootstrap Controller to set bootstrap data")
 			return ctrl.Result{Requeue: true}, nil
@@ -185,29 +258,37 @@ func (r *RemoteMachineController) Reconcile(ctx context.Context, req ctrl.Reques
 			log:           log,
 		}
 	} else {
-		// Get the ssh key
-		sshKey, err := r.getSSHKey(ctx, rm)
+		credentials, err := r.getSSHCredentials(ctx, rm)
 		if err != nil {
-			log.Error(err, "Failed to get ssh key")
+			log.Error(err, "Failed to get ssh credentials")
+			return ctrl.Result{Requeue: true}, err
+		}
+
+		hooks, err := r.getProvisioningHooks(ctx, rm)
+		if err != nil {
+			log.Error(err, "Failed to get provisioning hooks")
 			return ctrl.Result{Requeue: true}, err
 		}
 
 		p = &SSHProvisioner{
 			bootstrapData: bootstrapData,
-			sshKey:        sshKey,
+			credentials:   credentials,
+			hooks:         hooks,
 			machine:       rm,
 			log:           log,
+			recorder:      r.Recorder,
 		}
 	}
 
 	if !rm.ObjectMeta.DeletionTimestamp.IsZero() {
 		if controllerutil.ContainsFinalizer(rm, RemoteMachineFinalizer) {
-			if err := p.Cleanup(ctx, mode); err != nil {
-				log.Error(err, "Failed to cleanup RemoteMachine")
+			cleanupErr := p.Cleanup(ctx, mode)
+			if cleanupErr != nil {
+				log.Error(cleanupErr, "Failed to cleanup RemoteMachine")
 			}
-			if rm.Spec.Pool != "" {
+			if rm.UsesPool() {
 				// Return the machine back to pool
-				if err := r.returnMachineToPool(ctx, rm); err != nil {
+				if err := r.returnMachineToPool(ctx, rm, cleanupErr); err != nil {
 					return ctrl.Result{}, err
 				}
 			}
@@ -223,42 +304,59 @@ func (r *RemoteMachineController) Reconcile(ctx context.Context, req ctrl.Reques
 		controllerutil.AddFinalizer(rm, RemoteMachineFinalizer)
 	}
 
+	if !r.provisions.acquire(clusterKey, provisioning.MaxConcurrent) {
+		log.Info("Waiting for the other machines of the cluster to be provisioned")
+		conditions.MarkFalse(rm, infrastructure.ProvisionedCondition, infrastructure.WaitingForProvisioningSlotReason, clusterv1.ConditionSeverityInfo,
+			"Waiting for the other machines of the cluster to be provisioned, at most %d machines are provisioned at the same time", provisioning.MaxConcurrent)
+		return ctrl.Result{RequeueAfter: provisioningSlotRequeueAfter}, nil
+	}
+	defer r.provisions.release(clusterKey)
+
 	defer func() {
 		log.Info("Reconcile complete")
 		if err != nil {
 			rm.Status.FailureReason = "ProvisionFailed"
 			rm.Status.FailureMessage = err.Error()
 			rm.Status.Ready = false
-		} else {
+		} else if conditions.IsTrue(rm, infrastructure.ProvisionedCondition) {
 			rm.Status.FailureReason = ""
 			rm.Status.FailureMessage = ""
 			rm.Status.Ready = true
 		}
 		log.Info(fmt.Sprintf("Updating RemoteMachine status: %+v", rm.Status))
 		// Always update the RemoteMachine status with the phase the state machine is in
-		if err := r.Status().Update(ctx, rm); err != nil {
+		if err := r.updateStatus(ctx, rm); err != nil {
 			log.Error(err, "Failed to update RemoteMachine status")
 		}
 	}()
 
 	err = p.Provision(ctx)
+	rm.Status.ProvisionAttempts++
 	if err != nil {
-		log.Error(err, "Failed to provision RemoteMachine")
-		return ctrl.Result{}, err
+		log.Error(err, "Failed to provision RemoteMachine", "attempt", rm.Status.ProvisionAttempts)
+		if err := r.saveFailureArtifacts(ctx, rm, err); err != nil {
+			log.Error(err, "Failed to save provisioning failure artifacts")
+		}
+		return r.handleProvisionError(rm, provisioning, err)
 	}
+	rm.Status.LastProvisionError = ""
+	conditions.MarkTrue(rm, infrastructure.ProvisionedCondition)
 
 	rm.Spec.ProviderID = fmt.Sprintf("remote-machine://%s:%d", rm.Spec.Address, rm.Spec.Port)
 
+	// The update returns the stored status, keep the status, e.g. the pinned host keys, for the status update
+	status := rm.Status
 	if err := r.Update(ctx, rm); err != nil {
 		log.Error(err, "Failed to update RemoteMachine")
 		return ctrl.Result{}, err
 	}
+	rm.Status = status
 
 	m := machine.DeepCopy()
 	m.Status.Addresses = []clusterv1.MachineAddress{
 		{
 			Type:    clusterv1.MachineExternalIP,
-			Address: rm.Spec.Address,
+			Address: rm.SSHAddress(),
 		},
 	}
 
@@ -273,35 +371,96 @@ func (r *RemoteMachineController) Reconcile(ctx context.Context, req ctrl.Reques
 	return ctrl.Result{}, nil
 }
 
+// handleProvisionError records the failed provisioning attempt. The retryable errors, e.g. the SSH connection
+// failures, are retried after a backoff without failing the machine, until the attempts run out.
+func (r *RemoteMachineController) handleProvisionError(rm *infrastructure.RemoteMachine, provisioning infrastructure.ProvisioningSpec, err error) (ctrl.Result, error) {
+	rm.Status.LastProvisionError = err.Error()
+	maxAttempts := provisionMaxAttempts(provisioning)
+
+	if !isRetryable(err) {
+		conditions.MarkFalse(rm, infrastructure.ProvisionedCondition, infrastructure.ProvisionFailedReason, clusterv1.ConditionSeverityError,
+			"Provisioning attempt %d of %d failed: %s", rm.Status.ProvisionAttempts, maxAttempts, err)
+		util.RecordEvent(r.Recorder, rm, v1.EventTypeWarning, util.ProvisioningFailedReason,
+			"Provisioning attempt %d of %d failed: %s", rm.Status.ProvisionAttempts, maxAttempts, err)
+		return ctrl.Result{}, err
+	}
+
+	if rm.Status.ProvisionAttempts >= maxAttempts {
+		conditions.MarkFalse(rm, infrastructure.ProvisionedCondition, infrastructure.ProvisionFailedReason, clusterv1.ConditionSeverityError,
+			"Provisioning failed after %d attempts: %s", rm.Status.ProvisionAttempts, err)
+		util.RecordEvent(r.Recorder, rm, v1.EventTypeWarning, util.ProvisioningFailedReason,
+			"Provisioning failed after %d attempts: %s", rm.Status.ProvisionAttempts, err)
+		rm.Status.FailureReason = "ProvisionFailed"
+		rm.Status.FailureMessage = err.Error()
+		rm.Status.Ready = false
+		return ctrl.Result{}, nil
+	}
+
+	backoff := provisionBackoff(provisioning, rm.Status.ProvisionAttempts)
+	conditions.MarkFalse(rm, infrastructure.ProvisionedCondition, infrastructure.ProvisionRetryingReason, clusterv1.ConditionSeverityWarning,
+		"Provisioning attempt %d of %d failed, retrying in %s: %s", rm.Status.ProvisionAttempts, maxAttempts, backoff, err)
+	return ctrl.Result{RequeueAfter: backoff}, nil
+}
+
+// updateStatus writes the status of the remote machine, retrying on the conflicts with the concurrent changes.
+func (r *RemoteMachineController) updateStatus(ctx context.Context, rm *infrastructure.RemoteMachine) error {
+	status := rm.Status
+	return util.UpdateStatus(ctx, r.Client, rm, func(rm *infrastructure.RemoteMachine) { rm.Status = status })
+}
+
 func (r *RemoteMachineController) reservePooledMachine(ctx context.Context, rm *infrastructure.RemoteMachine) error {
 	pooledMachineList := &infrastructure.PooledRemoteMachineList{}
 	if err := r.Client.List(ctx, pooledMachineList, client.InNamespace(rm.Namespace)); err != nil {
 		return fmt.Errorf("failed to list pooled machines: %w", err)
 	}
 
+	selector := labels.Everything()
+	if rm.Spec.PoolSelector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(rm.Spec.PoolSelector)
+		if err != nil {
+			conditions.MarkFalse(rm, infrastructure.PooledMachineReservedCondition, infrastructure.NoMatchingPooledMachineReason, clusterv1.ConditionSeverityError,
+				"Invalid pool selector: %s", err)
+			return fmt.Errorf("invalid pool selector: %w", err)
+		}
+	}
+
 	var (
-		firstFreePooledMachine *infrastructure.PooledRemoteMachine
-		foundPooledMachine     *infrastructure.PooledRemoteMachine
+		foundPooledMachine *infrastructure.PooledRemoteMachine
+		freePooledMachines []*infrastructure.PooledRemoteMachine
+		matching           int
 	)
-	for _, pm := range pooledMachineList.Items {
-		if pm.Spec.Pool == rm.Spec.Pool {
-			if pm.Status.Reserved && pm.Status.MachineRef.Name == rm.GetName() {
-				foundPooledMachine = &pm
-				break
-			}
-
-			if !pm.Status.Reserved {
-				firstFreePooledMachine = &pm
-			}
+	for i := range pooledMachineList.Items {
+		pm := &pooledMachineList.Items[i]
+		if pm.Status.Reserved && pm.Status.MachineRef.Name == rm.GetName() {
+			foundPooledMachine = pm
+			break
+		}
+		if rm.Spec.Pool != "" && pm.Spec.Pool != rm.Spec.Pool {
+			continue
+		}
+		if !selector.Matches(labels.Set(pm.Labels)) {
+			continue
+		}
+		matching++
+		if !pm.Status.Reserved {
+			freePooledMachines = append(freePooledMachines, pm)
 		}
 	}
 
-	if foundPooledMachine == nil && firstFreePooledMachine == nil {
+	if foundPooledMachine == nil && len(freePooledMachines) == 0 {
+		if matching == 0 {
+			conditions.MarkFalse(rm, infrastructure.PooledMachineReservedCondition, infrastructure.NoMatchingPooledMachineReason, clusterv1.ConditionSeverityWarning,
+				"No pooled machine matches %s", poolCriteria(rm))
+		} else {
+			conditions.MarkFalse(rm, infrastructure.PooledMachineReservedCondition, infrastructure.NoMatchingPooledMachineReason, clusterv1.ConditionSeverityWarning,
+				"All %d pooled machines matching %s are reserved", matching, poolCriteria(rm))
+		}
 		return ErrPooledMachineNotFound
 	}
 
-	if foundPooledMachine == nil && firstFreePooledMachine != nil {
-		foundPooledMachine = firstFreePooledMachine
+	if foundPooledMachine == nil {
+		foundPooledMachine = bestPooledMachine(freePooledMachines)
 		foundPooledMachine.Status.Reserved = true
 		foundPooledMachine.Status.MachineRef = infrastructure.RemoteMachineRef{
 			Name:      rm.GetName(),
@@ -313,49 +472,163 @@ func (r *RemoteMachineController) reservePooledMachine(ctx context.Context, rm *
 			return fmt.Errorf("failed to update pooled machine status: %w", err)
 		}
 	}
+	conditions.MarkTrue(rm, infrastructure.PooledMachineReservedCondition)
 
 	rm.Spec.Address = foundPooledMachine.Spec.Machine.Address
 	rm.Spec.Port = foundPooledMachine.Spec.Machine.Port
 	rm.Spec.User = foundPooledMachine.Spec.Machine.User
+	rm.Spec.UseSudo = foundPooledMachine.Spec.Machine.UseSudo
+	rm.Spec.SudoPasswordSecretRef = foundPooledMachine.Spec.Machine.SudoPasswordSecretRef
 	rm.Spec.SSHKeyRef = foundPooledMachine.Spec.Machine.SSHKeyRef
+	rm.Spec.SSHCredentialsRef = foundPooledMachine.Spec.Machine.SSHCredentialsRef
+	rm.Spec.KnownHostsSecretRef = foundPooledMachine.Spec.Machine.KnownHostsSecretRef
+	rm.Spec.Bastion = foundPooledMachine.Spec.Machine.Bastion
+	rm.Spec.CleanupCommands = foundPooledMachine.Spec.Machine.CleanupCommands
+	rm.Spec.Network = foundPooledMachine.Spec.Machine.Network
+	rm.Spec.Hooks = foundPooledMachine.Spec.Machine.Hooks
+	if rm.Spec.HealthCheck == nil {
+		rm.Spec.HealthCheck = foundPooledMachine.Spec.Machine.HealthCheck
+	}
+	rm.Spec.PowerManagement = foundPooledMachine.Spec.Machine.PowerManagement
 
 	return nil
 }
 
-func (r *RemoteMachineController) returnMachineToPool(ctx context.Context, rm *infrastructure.RemoteMachine) error {
-	if rm.Spec.Pool == "" {
+// bestPooledMachine returns the pooled machine with the fewest labels, so the more specific machines are kept for
+// the machines selecting them. The ties are broken by the name.
+func bestPooledMachine(pooledMachines []*infrastructure.PooledRemoteMachine) *infrastructure.PooledRemoteMachine {
+	sort.Slice(pooledMachines, func(i, j int) bool {
+		if len(pooledMachines[i].Labels) != len(pooledMachines[j].Labels) {
+			return len(pooledMachines[i].Labels) < len(pooledMachines[j].Labels)
+		}
+		return pooledMachines[i].Name < pooledMachines[j].Name
+	})
+	return pooledMachines[0]
+}
+
+// poolCriteria describes the pool and the pool selector of the machine.
+func poolCriteria(rm *infrastructure.RemoteMachine) string {
+	var criteria []string
+	if rm.Spec.Pool != "" {
+		criteria = append(criteria, fmt.Sprintf("pool %q", rm.Spec.Pool))
+	}
+	if rm.Spec.PoolSelector != nil {
+		criteria = append(criteria, fmt.Sprintf("selector %q", metav1.FormatLabelSelector(rm.Spec.PoolSelector)))
+	}
+	return strings.Join(criteria, " and ")
+}
+
+// returnMachineToPool releases the pooled machine reserved for the RemoteMachine. If the cleanup of the machine
+// failed, the pooled machine stays reserved with the cleanup failure recorded, so it's not reused before it's clean.
+// The released machine is powered off, if it has power management.
+func (r *RemoteMachineController) returnMachineToPool(ctx context.Context, rm *infrastructure.RemoteMachine, cleanupErr error) error {
+	if !rm.UsesPool() {
 		return nil
 	}
 
-	pool := rm.Spec.Pool
 	pooledMachines := &infrastructure.PooledRemoteMachineList{}
-	err := r.List(ctx, pooledMachines, &client.ListOptions{})
+	err := r.List(ctx, pooledMachines, client.MatchingFields{pooledMachineReservedForField: rm.Namespace + "/" + rm.Name})
 	if err != nil {
 		return fmt.Errorf("failed to list pooled machines: %w", err)
 	}
+
+	for _, pooledMachine := range pooledMachines.Items {
+		// A machine that failed to be cleaned up stays reserved
+		pooledMachine.Status.Reserved = cleanupErr != nil
+		pooledMachine.Status.MachineRef = infrastructure.RemoteMachineRef{}
+		if cleanupErr != nil {
+			pooledMachine.Status.CleanupFailureMessage = cleanupErr.Error()
+		} else if err := r.powerOffPooledMachine(ctx, &pooledMachine); err != nil {
+			// The machine is powered on again when it's reserved, so it's released anyway
+			log.FromContext(ctx).Error(err, "Failed to power off pooled machine", "pooledmachine", pooledMachine.Name)
+		}
+		status := pooledMachine.Status
+		if err := util.UpdateStatus(ctx, r.Client, &pooledMachine, func(pm *infrastructure.PooledRemoteMachine) { pm.Status = status }); err != nil {
+			return fmt.Errorf("failed to update pooled machine: %w", err)
+		}
+	}
 	if len(pooledMachines.Items) == 0 {
-		return fmt.Errorf("no pooled machines found for pool %s", pool)
+		log := log.FromContext(ctx).WithValues("remotemachine", rm.Name)
+		log.Error(fmt.Errorf("no pooled machine found for remote machine"), rm.Namespace, rm.Name)
 	}
 
-	for _, pooledMachine := range pooledMachines.Items {
-		if pooledMachine.Status.Reserved &&
-			pooledMachine.Status.MachineRef.Name == rm.Name &&
-			pooledMachine.Status.MachineRef.Namespace == rm.Namespace {
-
-			pooledMachine.Status.Reserved = false
-			pooledMachine.Status.MachineRef = infrastructure.RemoteMachineRef{}
-			if err := r.Status().Update(ctx, &pooledMachine); err != nil {
-				return fmt.Errorf("failed to update pooled machine: %w", err)
-			}
-			return nil
+	return nil
+}
+
+// reconcilePreflightChecks checks that the machine accepts the SSH connection before it's provisioned, so wrong
+// credentials are reported in the PreflightChecksSucceeded condition while the bootstrap data is generated.
+func (r *RemoteMachineController) reconcilePreflightChecks(ctx context.Context, rm *infrastructure.RemoteMachine) error {
+	credentials, err := r.getSSHCredentials(ctx, rm)
+	if err != nil {
+		conditions.MarkFalse(rm, infrastructure.PreflightChecksSucceededCondition, infrastructure.PreflightCheckFailedReason, clusterv1.ConditionSeverityError,
+			"The SSH credentials can't be read: %s", err)
+		return err
+	}
+
+	if err := checkSSHConnection(rm, credentials); err != nil {
+		if rm.Spec.Bastion != nil {
+			conditions.MarkFalse(rm, infrastructure.PreflightChecksSucceededCondition, infrastructure.PreflightCheckFailedReason, clusterv1.ConditionSeverityError,
+				"The SSH connection to %s@%s:%d through the bastion %s@%s:%d failed: %s. Check the addresses, the users and the SSH credentials of the machine and the bastion.",
+				rm.Spec.User, rm.SSHAddress(), rm.Spec.Port, rm.Spec.Bastion.User, rm.Spec.Bastion.Address, rm.Spec.Bastion.Port, err)
+			return err
 		}
+		conditions.MarkFalse(rm, infrastructure.PreflightChecksSucceededCondition, infrastructure.PreflightCheckFailedReason, clusterv1.ConditionSeverityError,
+			"The SSH connection to %s@%s:%d failed: %s. Check the address, the user and the SSH credentials of the machine.", rm.Spec.User, rm.SSHAddress(), rm.Spec.Port, err)
+		return err
 	}
-	log := log.FromContext(ctx).WithValues("remotemachine", rm.Name)
-	log.Error(fmt.Errorf("no pooled machine found for remote machine"), rm.Namespace, rm.Name)
 
+	conditions.MarkTrue(rm, infrastructure.PreflightChecksSucceededCondition)
 	return nil
 }
 
+// getSSHCredentials returns the credentials to connect to the machine and its bastion host over SSH.
+func (r *RemoteMachineController) getSSHCredentials(ctx context.Context, rm *infrastructure.RemoteMachine) (sshCredentials, error) {
+	var credentials sshCredentials
+	if ref := rm.Spec.SSHCredentialsRef; ref != nil {
+		source, err := r.SecretStores.Source(ref.Provider)
+		if err != nil {
+			return credentials, err
+		}
+		sourcePath, err := secretstore.SourcePath(rm.Namespace, ref.Path)
+		if err != nil {
+			return credentials, err
+		}
+		data, err := source.Get(ctx, sourcePath)
+		if err != nil {
+			return credentials, fmt.Errorf("the SSH credentials %s can't be read from %s: %w", ref.Path, ref.Provider, err)
+		}
+		credentials.key = []byte(data["value"])
+		credentials.password = data["password"]
+		credentials.sudoPassword = data["sudoPassword"]
+	} else {
+		key, err := r.getSSHKey(ctx, rm)
+		if err != nil {
+			return credentials, fmt.Errorf("the SSH key secret %s can't be read: %w", rm.Spec.SSHKeyRef.Name, err)
+		}
+		credentials.key = key
+
+		sudoPassword, err := r.getSudoPassword(ctx, rm)
+		if err != nil {
+			return credentials, fmt.Errorf("the sudo password secret %s can't be read: %w", rm.Spec.SudoPasswordSecretRef.Name, err)
+		}
+		credentials.sudoPassword = sudoPassword
+	}
+
+	bastionKey, err := r.getBastionSSHKey(ctx, rm)
+	if err != nil {
+		return credentials, fmt.Errorf("the bastion SSH key secret %s can't be read: %w", rm.Spec.Bastion.SSHKeyRef.Name, err)
+	}
+	credentials.bastionKey = bastionKey
+
+	knownHosts, err := r.getKnownHosts(ctx, rm)
+	if err != nil {
+		return credentials, fmt.Errorf("the known hosts secret %s can't be read: %w", rm.Spec.KnownHostsSecretRef.Name, err)
+	}
+	credentials.knownHosts = knownHosts
+
+	return credentials, nil
+}
+
 func (r *RemoteMachineController) getSSHKey(ctx context.Context, rm *infrastructure.RemoteMachine) ([]byte, error) {
 	secret := &v1.Secret{}
 	key := client.ObjectKey{
@@ -371,6 +644,67 @@ func (r *RemoteMachineController) getSSHKey(ctx context.Context, rm *infrastruct
 
 }
 
+// getBastionSSHKey returns the SSH key of the bastion host, or nil if the SSH key of the machine is used for it.
+func (r *RemoteMachineController) getBastionSSHKey(ctx context.Context, rm *infrastructure.RemoteMachine) ([]byte, error) {
+	if rm.Spec.Bastion == nil || rm.Spec.Bastion.SSHKeyRef == nil {
+		return nil, nil
+	}
+
+	secret := &v1.Secret{}
+	key := client.ObjectKey{
+		Namespace: rm.Namespace,
+		Name:      rm.Spec.Bastion.SSHKeyRef.Name,
+	}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return nil, err
+	}
+
+	return secret.Data["value"], nil
+}
+
+// getKnownHosts returns the known hosts the host keys are verified against, or nil if the host keys are trusted on
+// first use.
+// getSudoPassword returns the sudo password of the user, or an empty string if passwordless sudo is used.
+func (r *RemoteMachineController) getSudoPassword(ctx context.Context, rm *infrastructure.RemoteMachine) (string, error) {
+	if !rm.Spec.UseSudo || rm.Spec.SudoPasswordSecretRef == nil {
+		return "", nil
+	}
+
+	secret := &v1.Secret{}
+	key := client.ObjectKey{
+		Namespace: rm.Namespace,
+		Name:      rm.Spec.SudoPasswordSecretRef.Name,
+	}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return "", err
+	}
+	if len(secret.Data["value"]) == 0 {
+		return "", fmt.Errorf("no password in the key \"value\"")
+	}
+
+	return string(secret.Data["value"]), nil
+}
+
+func (r *RemoteMachineController) getKnownHosts(ctx context.Context, rm *infrastructure.RemoteMachine) ([]byte, error) {
+	if rm.Spec.KnownHostsSecretRef == nil {
+		return nil, nil
+	}
+
+	secret := &v1.Secret{}
+	key := client.ObjectKey{
+		Namespace: rm.Namespace,
+		Name:      rm.Spec.KnownHostsSecretRef.Name,
+	}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return nil, err
+	}
+	if len(secret.Data["value"]) == 0 {
+		return nil, fmt.Errorf("no known hosts in the key \"value\"")
+	}
+
+	return secret.Data["value"], nil
+}
+
 func (r *RemoteMachineController) getBootstrapData(ctx context.Context, machine *clusterv1.Machine) ([]byte, error) {
 	if machine.Spec.Bootstrap.DataSecretName == nil {
 		return nil, fmt.Errorf("wait for bootstap secret for the machine: %s", machine.Name)
@@ -385,11 +719,30 @@ func (r *RemoteMachineController) getBootstrapData(ctx context.Context, machine
 		return nil, err
 	}
 
+	// The provisioners apply the cloud-init data themselves, so other formats cannot be used
+	if format, ok := secret.Data["format"]; ok && string(format) != "cloud-config" {
+		return nil, fmt.Errorf("unsupported bootstrap data format %q for the machine: %s", format, machine.Name)
+	}
+
 	return secret.Data["value"], nil
 }
 
+// indexPooledMachineReservedFor indexes the reserved pooled machines by the RemoteMachine they're reserved for.
+func indexPooledMachineReservedFor(obj client.Object) []string {
+	pm, ok := obj.(*infrastructure.PooledRemoteMachine)
+	if !ok || !pm.Status.Reserved || pm.Status.MachineRef.Name == "" {
+		return nil
+	}
+	return []string{pm.Status.MachineRef.Namespace + "/" + pm.Status.MachineRef.Name}
+}
+
 func (r *RemoteMachineController) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &infrastructure.PooledRemoteMachine{}, pooledMachineReservedForField, indexPooledMachineReservedFor); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&infrastructure.RemoteMachine{}).
-		Complete(r)
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentProvisions}).
+		Complete(r.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("RemoteMachine", r)))
 }
@@ -0,0 +1,347 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/secretstore"
+)
+
+func newPooledMachine(name, pool string, labels map[string]string, reservedBy string) *infrastructure.PooledRemoteMachine {
+	pm := &infrastructure.PooledRemoteMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+		Spec: infrastructure.PooledRemoteMachineSpec{
+			Pool:    pool,
+			Machine: infrastructure.PooledMachineSpec{Address: name + ".example.com", Port: 22, User: "root"},
+		},
+	}
+	if reservedBy != "" {
+		pm.Status = infrastructure.PooledRemoteMachineStatus{
+			Reserved:   true,
+			MachineRef: infrastructure.RemoteMachineRef{Name: reservedBy, Namespace: "default"},
+		}
+	}
+	return pm
+}
+
+func TestRemoteMachineController_reservePooledMachine(t *testing.T) {
+	pooledMachines := []client.Object{
+		newPooledMachine("gpu-a", "default", map[string]string{"gpu": "true", "zone": "a"}, ""),
+		newPooledMachine("gpu-b-fast", "default", map[string]string{"gpu": "true", "zone": "b", "disk": "ssd"}, ""),
+		newPooledMachine("gpu-b", "default", map[string]string{"gpu": "true", "zone": "b"}, ""),
+		newPooledMachine("cpu-b", "default", map[string]string{"zone": "b"}, "other"),
+		newPooledMachine("gpu-c", "other", map[string]string{"gpu": "true", "zone": "c"}, ""),
+	}
+
+	tests := []struct {
+		name        string
+		spec        infrastructure.RemoteMachineSpec
+		wantAddress string
+		wantMessage string
+	}{
+		{
+			name:        "pool",
+			spec:        infrastructure.RemoteMachineSpec{Pool: "default"},
+			wantAddress: "gpu-a.example.com",
+		},
+		{
+			name: "selector",
+			spec: infrastructure.RemoteMachineSpec{
+				PoolSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"gpu": "true", "zone": "b"}},
+			},
+			wantAddress: "gpu-b.example.com",
+		},
+		{
+			name: "pool and selector",
+			spec: infrastructure.RemoteMachineSpec{
+				Pool:         "other",
+				PoolSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"gpu": "true"}},
+			},
+			wantAddress: "gpu-c.example.com",
+		},
+		{
+			name: "no match",
+			spec: infrastructure.RemoteMachineSpec{
+				PoolSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "d"}},
+			},
+			wantMessage: `No pooled machine matches selector "zone=d"`,
+		},
+		{
+			name: "all reserved",
+			spec: infrastructure.RemoteMachineSpec{
+				Pool:         "default",
+				PoolSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "gpu", Operator: metav1.LabelSelectorOpDoesNotExist}}},
+			},
+			wantMessage: `All 1 pooled machines matching pool "default" and selector "!gpu" are reserved`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			require.NoError(t, infrastructure.AddToScheme(scheme))
+			c := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(pooledMachines...).
+				WithStatusSubresource(&infrastructure.PooledRemoteMachine{}).
+				Build()
+			r := &RemoteMachineController{Client: c}
+
+			rm := &infrastructure.RemoteMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "rm", Namespace: "default"},
+				Spec:       tt.spec,
+			}
+			err := r.reservePooledMachine(context.Background(), rm)
+			if tt.wantMessage != "" {
+				require.ErrorIs(t, err, ErrPooledMachineNotFound)
+				require.True(t, conditions.IsFalse(rm, infrastructure.PooledMachineReservedCondition))
+				require.Equal(t, tt.wantMessage, conditions.GetMessage(rm, infrastructure.PooledMachineReservedCondition))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantAddress, rm.Spec.Address)
+			require.True(t, conditions.IsTrue(rm, infrastructure.PooledMachineReservedCondition))
+
+			// The reserved pooled machine is found again on the next reconciliation
+			rm.Spec.Address = ""
+			require.NoError(t, r.reservePooledMachine(context.Background(), rm))
+			require.Equal(t, tt.wantAddress, rm.Spec.Address)
+		})
+	}
+}
+
+func TestRemoteMachineController_returnMachineToPool(t *testing.T) {
+	tests := []struct {
+		name         string
+		cleanupErr   error
+		wantReserved bool
+		wantMessage  string
+	}{
+		{
+			name: "cleaned up",
+		},
+		{
+			name:         "cleanup failed",
+			cleanupErr:   errors.New("failed to reset k0s"),
+			wantReserved: true,
+			wantMessage:  "failed to reset k0s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			require.NoError(t, infrastructure.AddToScheme(scheme))
+			pm := newPooledMachine("pooled", "default", nil, "rm")
+			c := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(pm).
+				WithStatusSubresource(&infrastructure.PooledRemoteMachine{}).
+				WithIndex(&infrastructure.PooledRemoteMachine{}, pooledMachineReservedForField, indexPooledMachineReservedFor).
+				Build()
+			r := &RemoteMachineController{Client: c}
+
+			rm := &infrastructure.RemoteMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "rm", Namespace: "default"},
+				Spec:       infrastructure.RemoteMachineSpec{Pool: "default"},
+			}
+			require.NoError(t, r.returnMachineToPool(context.Background(), rm, tt.cleanupErr))
+
+			var got infrastructure.PooledRemoteMachine
+			require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(pm), &got))
+			require.Equal(t, tt.wantReserved, got.Status.Reserved)
+			require.Empty(t, got.Status.MachineRef)
+			require.Equal(t, tt.wantMessage, got.Status.CleanupFailureMessage)
+
+			// The machine that failed to be cleaned up is not reserved again
+			err := r.reservePooledMachine(context.Background(), rm)
+			if tt.wantReserved {
+				require.ErrorIs(t, err, ErrPooledMachineNotFound)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+type fakeSecretSource struct {
+	data map[string]map[string]string
+}
+
+func (s *fakeSecretSource) Put(_ context.Context, _ secretstore.Key, _ map[string]string) error {
+	return nil
+}
+
+func (s *fakeSecretSource) Delete(_ context.Context, _ secretstore.Key) error {
+	return nil
+}
+
+func (s *fakeSecretSource) Get(_ context.Context, path string) (map[string]string, error) {
+	data, ok := s.data[path]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func TestRemoteMachineController_getSSHCredentials(t *testing.T) {
+	stores := secretstore.NewRegistry(secretstore.ProviderKubernetes)
+	stores.Register(secretstore.ProviderVault, &fakeSecretSource{data: map[string]map[string]string{
+		"k0smotron/default/machines/node-0": {"value": "vault-key", "password": "vault-password", "sudoPassword": "vault-sudo-password"},
+		"k0smotron/other/machines/node-0":   {"value": "other-key", "password": "other-password", "sudoPassword": "other-sudo-password"},
+	}})
+
+	tests := []struct {
+		name             string
+		spec             infrastructure.RemoteMachineSpec
+		wantKey          string
+		wantPassword     string
+		wantSudoPassword string
+		wantErr          string
+	}{
+		{
+			name:    "secret",
+			spec:    infrastructure.RemoteMachineSpec{SSHKeyRef: infrastructure.SecretRef{Name: "ssh-key"}},
+			wantKey: "secret-key",
+		},
+		{
+			name: "external secret store",
+			spec: infrastructure.RemoteMachineSpec{
+				SSHKeyRef:         infrastructure.SecretRef{Name: "ssh-key"},
+				SSHCredentialsRef: &infrastructure.ExternalSecretRef{Provider: secretstore.ProviderVault, Path: "machines/node-0"},
+			},
+			wantKey:          "vault-key",
+			wantPassword:     "vault-password",
+			wantSudoPassword: "vault-sudo-password",
+		},
+		{
+			name: "sudo password",
+			spec: infrastructure.RemoteMachineSpec{
+				SSHKeyRef:             infrastructure.SecretRef{Name: "ssh-key"},
+				UseSudo:               true,
+				SudoPasswordSecretRef: &infrastructure.SecretRef{Name: "sudo-password"},
+			},
+			wantKey:          "secret-key",
+			wantSudoPassword: "secret-sudo-password",
+		},
+		{
+			name: "sudo password without sudo",
+			spec: infrastructure.RemoteMachineSpec{
+				SSHKeyRef:             infrastructure.SecretRef{Name: "ssh-key"},
+				SudoPasswordSecretRef: &infrastructure.SecretRef{Name: "sudo-password"},
+			},
+			wantKey: "secret-key",
+		},
+		{
+			name: "missing sudo password secret",
+			spec: infrastructure.RemoteMachineSpec{
+				SSHKeyRef:             infrastructure.SecretRef{Name: "ssh-key"},
+				UseSudo:               true,
+				SudoPasswordSecretRef: &infrastructure.SecretRef{Name: "other-password"},
+			},
+			wantErr: `the sudo password secret other-password can't be read: secrets "other-password" not found`,
+		},
+		{
+			name: "missing external secret",
+			spec: infrastructure.RemoteMachineSpec{
+				SSHCredentialsRef: &infrastructure.ExternalSecretRef{Provider: secretstore.ProviderVault, Path: "machines/node-1"},
+			},
+			wantErr: "the SSH credentials machines/node-1 can't be read from Vault: not found",
+		},
+		{
+			name: "external secret of another namespace",
+			spec: infrastructure.RemoteMachineSpec{
+				SSHCredentialsRef: &infrastructure.ExternalSecretRef{Provider: secretstore.ProviderVault, Path: "../other/machines/node-0"},
+			},
+			wantErr: "the SSH credentials ../other/machines/node-0 can't be read from Vault: not found",
+		},
+		{
+			name: "secret store not configured",
+			spec: infrastructure.RemoteMachineSpec{
+				SSHCredentialsRef: &infrastructure.ExternalSecretRef{Provider: secretstore.ProviderAWSSecretsManager, Path: "machines/node-0"},
+			},
+			wantErr: "secret store AWSSecretsManager is not configured",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			c := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(&v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "ssh-key", Namespace: "default"},
+					Data:       map[string][]byte{"value": []byte("secret-key")},
+				}, &v1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "sudo-password", Namespace: "default"},
+					Data:       map[string][]byte{"value": []byte("secret-sudo-password")},
+				}).
+				Build()
+			r := &RemoteMachineController{Client: c, SecretStores: stores}
+
+			rm := &infrastructure.RemoteMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "rm", Namespace: "default"},
+				Spec:       tt.spec,
+			}
+			credentials, err := r.getSSHCredentials(context.Background(), rm)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantKey, string(credentials.key))
+			require.Equal(t, tt.wantPassword, credentials.password)
+			require.Equal(t, tt.wantSudoPassword, credentials.sudoPassword)
+		})
+	}
+}
+
+func TestRemoteMachineController_handleProvisionError(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &RemoteMachineController{Recorder: recorder}
+	provisioning := infrastructure.ProvisioningSpec{MaxAttempts: 2}
+	rm := &infrastructure.RemoteMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "rm", Namespace: "default"},
+	}
+
+	rm.Status.ProvisionAttempts = 1
+	res, err := r.handleProvisionError(rm, provisioning, retryableError{errors.New("connection refused")})
+	require.NoError(t, err)
+	require.NotZero(t, res.RequeueAfter)
+	require.Empty(t, recorder.Events)
+
+	rm.Status.ProvisionAttempts = 2
+	_, err = r.handleProvisionError(rm, provisioning, retryableError{errors.New("connection refused")})
+	require.NoError(t, err)
+	require.Equal(t, "ProvisionFailed", rm.Status.FailureReason)
+	require.Len(t, recorder.Events, 1)
+	require.Equal(t, "Warning ProvisioningFailed Provisioning failed after 2 attempts: connection refused", <-recorder.Events)
+}
@@ -0,0 +1,324 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
+)
+
+const inventoryLabelPrefix = "label."
+
+// RemoteMachineInventoryController creates, updates and deletes the PooledRemoteMachines of the hosts of the
+// RemoteMachineInventories.
+type RemoteMachineInventoryController struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Shard selects the resources reconciled by the controller. All the resources are reconciled if nil.
+	Shard *sharding.Shard
+}
+
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=remotemachineinventories,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=remotemachineinventories/status,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+func (r *RemoteMachineInventoryController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx).WithValues("remotemachineinventory", req.NamespacedName)
+	log.Info("Reconciling RemoteMachineInventory")
+
+	inv := &infrastructure.RemoteMachineInventory{}
+	if err := r.Get(ctx, req.NamespacedName, inv); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("RemoteMachineInventory not found, ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get RemoteMachineInventory")
+		return ctrl.Result{}, err
+	}
+	if !inv.DeletionTimestamp.IsZero() {
+		// The pooled machines are garbage collected
+		return ctrl.Result{}, nil
+	}
+
+	syncErr := r.syncInventory(ctx, inv)
+	if syncErr != nil {
+		log.Error(syncErr, "Failed to sync RemoteMachineInventory")
+		conditions.MarkFalse(inv, infrastructure.InventorySyncedCondition, infrastructure.InventorySyncFailedReason, clusterv1.ConditionSeverityError, "%s", syncErr)
+	} else {
+		conditions.MarkTrue(inv, infrastructure.InventorySyncedCondition)
+	}
+
+	if err := r.Status().Update(ctx, inv); err != nil {
+		log.Error(err, "Failed to update RemoteMachineInventory status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, syncErr
+}
+
+// syncInventory creates and updates the pooled machines of the hosts of the inventory and deletes the pooled machines
+// of the removed hosts. The reserved pooled machines are neither updated nor deleted, until they are released.
+func (r *RemoteMachineInventoryController) syncInventory(ctx context.Context, inv *infrastructure.RemoteMachineInventory) error {
+	hosts, err := r.getInventoryHosts(ctx, inv)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	names := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		names[host.Name] = true
+		if err := r.syncPooledMachine(ctx, inv, host); err != nil {
+			errs = append(errs, fmt.Errorf("pooled machine %s: %w", host.Name, err))
+		}
+	}
+
+	pooledMachines := &infrastructure.PooledRemoteMachineList{}
+	if err := r.List(ctx, pooledMachines, client.InNamespace(inv.Namespace), client.MatchingLabels{infrastructure.InventoryLabel: inv.Name}); err != nil {
+		return err
+	}
+	machines := 0
+	for i := range pooledMachines.Items {
+		pm := &pooledMachines.Items[i]
+		if names[pm.Name] || !metav1.IsControlledBy(pm, inv) {
+			machines++
+			continue
+		}
+		if pm.Status.Reserved {
+			machines++
+			continue
+		}
+		if err := r.Delete(ctx, pm); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("pooled machine %s: %w", pm.Name, err))
+		}
+	}
+	inv.Status.Machines = machines
+
+	return errors.Join(errs...)
+}
+
+func (r *RemoteMachineInventoryController) syncPooledMachine(ctx context.Context, inv *infrastructure.RemoteMachineInventory, host infrastructure.InventoryHost) error {
+	pm := &infrastructure.PooledRemoteMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: host.Name, Namespace: inv.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, pm, func() error {
+		if pm.ResourceVersion != "" {
+			if !metav1.IsControlledBy(pm, inv) {
+				return fmt.Errorf("already exists and is not managed by the inventory")
+			}
+			if pm.Status.Reserved {
+				return nil
+			}
+		}
+
+		pm.Labels = map[string]string{}
+		for k, v := range host.Labels {
+			pm.Labels[k] = v
+		}
+		pm.Labels[infrastructure.InventoryLabel] = inv.Name
+
+		machine := inv.Spec.Machine
+		pm.Spec.Pool = inv.Spec.Pool
+		pm.Spec.Machine = infrastructure.PooledMachineSpec{
+			Address:               host.Address,
+			Port:                  host.Port,
+			User:                  host.User,
+			UseSudo:               machine.UseSudo,
+			SudoPasswordSecretRef: machine.SudoPasswordSecretRef,
+			SSHKeyRef:             machine.SSHKeyRef,
+			SSHCredentialsRef:     machine.SSHCredentialsRef,
+			KnownHostsSecretRef:   machine.KnownHostsSecretRef,
+			Bastion:               machine.Bastion,
+		}
+		if pm.Spec.Machine.Port == 0 {
+			pm.Spec.Machine.Port = machine.Port
+		}
+		if pm.Spec.Machine.Port == 0 {
+			pm.Spec.Machine.Port = 22
+		}
+		if pm.Spec.Machine.User == "" {
+			pm.Spec.Machine.User = machine.User
+		}
+		if pm.Spec.Machine.User == "" {
+			pm.Spec.Machine.User = "root"
+		}
+
+		return ctrl.SetControllerReference(inv, pm, r.Scheme)
+	})
+	return err
+}
+
+// getInventoryHosts returns the hosts of the inventory and of its ConfigMap.
+func (r *RemoteMachineInventoryController) getInventoryHosts(ctx context.Context, inv *infrastructure.RemoteMachineInventory) ([]infrastructure.InventoryHost, error) {
+	hosts := append([]infrastructure.InventoryHost{}, inv.Spec.Hosts...)
+
+	if ref := inv.Spec.HostsFrom; ref != nil {
+		cm := &v1.ConfigMap{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: inv.Namespace, Name: ref.Name}, cm); err != nil {
+			return nil, fmt.Errorf("the hosts ConfigMap %s can't be read: %w", ref.Name, err)
+		}
+		key := ref.Key
+		if key == "" {
+			key = "hosts.csv"
+		}
+		data, ok := cm.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("the hosts ConfigMap %s has no key %q", ref.Name, key)
+		}
+		csvHosts, err := parseInventoryCSV(data)
+		if err != nil {
+			return nil, fmt.Errorf("the hosts ConfigMap %s is invalid: %w", ref.Name, err)
+		}
+		hosts = append(hosts, csvHosts...)
+	}
+
+	seen := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		if seen[host.Name] {
+			return nil, fmt.Errorf("duplicate host %s", host.Name)
+		}
+		seen[host.Name] = true
+	}
+	return hosts, nil
+}
+
+// parseInventoryCSV parses the hosts in the CSV format. The first line is the header, with the columns "name" and
+// "address" and optionally "port", "user" and a "label.<key>" column for each label. Lines starting with # are ignored.
+func parseInventoryCSV(data string) ([]infrastructure.InventoryHost, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	columns := map[string]bool{}
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		header[i] = column
+		switch {
+		case column == "name", column == "address", column == "port", column == "user":
+		case strings.HasPrefix(column, inventoryLabelPrefix) && len(column) > len(inventoryLabelPrefix):
+		default:
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+		columns[column] = true
+	}
+	if !columns["name"] || !columns["address"] {
+		return nil, fmt.Errorf("the columns \"name\" and \"address\" are required")
+	}
+
+	var hosts []infrastructure.InventoryHost
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		var host infrastructure.InventoryHost
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch column := header[i]; column {
+			case "name":
+				host.Name = value
+			case "address":
+				host.Address = value
+			case "port":
+				if value == "" {
+					continue
+				}
+				port, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid port %q", line, value)
+				}
+				host.Port = port
+			case "user":
+				host.User = value
+			default:
+				if value == "" {
+					continue
+				}
+				if host.Labels == nil {
+					host.Labels = map[string]string{}
+				}
+				host.Labels[strings.TrimPrefix(column, inventoryLabelPrefix)] = value
+			}
+		}
+		if host.Name == "" || host.Address == "" {
+			return nil, fmt.Errorf("line %d: name and address are required", line)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// requestsForConfigMap maps a ConfigMap to the inventories reading their hosts from it.
+func (r *RemoteMachineInventoryController) requestsForConfigMap(ctx context.Context, obj client.Object) []reconcile.Request {
+	inventories := &infrastructure.RemoteMachineInventoryList{}
+	if err := r.List(ctx, inventories, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list RemoteMachineInventories")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, inv := range inventories.Items {
+		if inv.Spec.HostsFrom != nil && inv.Spec.HostsFrom.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: inv.Namespace, Name: inv.Name}})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RemoteMachineInventoryController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&infrastructure.RemoteMachineInventory{}).
+		Owns(&infrastructure.PooledRemoteMachine{}).
+		Watches(&v1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.requestsForConfigMap)).
+		Complete(r.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("RemoteMachineInventory", r)))
+}
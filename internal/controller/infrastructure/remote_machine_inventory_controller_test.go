@@ -0,0 +1,210 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+)
+
+func TestParseInventoryCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []infrastructure.InventoryHost
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			data: "",
+		},
+		{
+			name: "hosts with overrides and labels",
+			data: `# rack 1
+name,address,port,user,label.zone
+worker-1,10.0.0.1,,,a
+worker-2, 10.0.0.2,2222,ubuntu,
+`,
+			want: []infrastructure.InventoryHost{
+				{Name: "worker-1", Address: "10.0.0.1", Labels: map[string]string{"zone": "a"}},
+				{Name: "worker-2", Address: "10.0.0.2", Port: 2222, User: "ubuntu"},
+			},
+		},
+		{
+			name:    "unknown column",
+			data:    "name,address,password\nworker-1,10.0.0.1,secret\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing address column",
+			data:    "name,port\nworker-1,22\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing address",
+			data:    "name,address\nworker-1,\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			data:    "name,address,port\nworker-1,10.0.0.1,ssh\n",
+			wantErr: true,
+		},
+		{
+			name:    "wrong number of fields",
+			data:    "name,address\nworker-1,10.0.0.1,22\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInventoryCSV(tt.data)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRemoteMachineInventoryController_Reconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, infrastructure.AddToScheme(scheme))
+
+	inv := &infrastructure.RemoteMachineInventory{
+		ObjectMeta: metav1.ObjectMeta{Name: "rack-1", Namespace: "default", UID: "inv-uid"},
+		Spec: infrastructure.RemoteMachineInventorySpec{
+			Pool: "workers",
+			Machine: infrastructure.InventoryMachineSpec{
+				User:      "ubuntu",
+				UseSudo:   true,
+				SSHKeyRef: infrastructure.SecretRef{Name: "ssh-key"},
+			},
+			Hosts: []infrastructure.InventoryHost{
+				{Name: "worker-1", Address: "10.0.0.1", Port: 2222, Labels: map[string]string{"zone": "a"}},
+			},
+			HostsFrom: &infrastructure.ConfigMapKeyRef{Name: "hosts"},
+		},
+	}
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "hosts", Namespace: "default"},
+		Data:       map[string]string{"hosts.csv": "name,address,user\nworker-2,10.0.0.2,root\nworker-3,10.0.0.3,\n"},
+	}
+
+	// A pooled machine of a removed host, a reserved one and one not managed by the inventory
+	removed := &infrastructure.PooledRemoteMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-9", Namespace: "default", Labels: map[string]string{infrastructure.InventoryLabel: "rack-1"}},
+		Spec:       infrastructure.PooledRemoteMachineSpec{Pool: "workers", Machine: infrastructure.PooledMachineSpec{Address: "10.0.0.9"}},
+	}
+	reserved := &infrastructure.PooledRemoteMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-8", Namespace: "default", Labels: map[string]string{infrastructure.InventoryLabel: "rack-1"}},
+		Spec:       infrastructure.PooledRemoteMachineSpec{Pool: "workers", Machine: infrastructure.PooledMachineSpec{Address: "10.0.0.8"}},
+		Status:     infrastructure.PooledRemoteMachineStatus{Reserved: true},
+	}
+	for _, pm := range []*infrastructure.PooledRemoteMachine{removed, reserved} {
+		require.NoError(t, ctrl.SetControllerReference(inv, pm, scheme))
+	}
+	unmanaged := &infrastructure.PooledRemoteMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-3", Namespace: "default"},
+		Spec:       infrastructure.PooledRemoteMachineSpec{Pool: "workers", Machine: infrastructure.PooledMachineSpec{Address: "10.0.0.3"}},
+	}
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(inv, cm, removed, reserved, unmanaged).
+		WithStatusSubresource(inv, reserved).
+		Build()
+	r := &RemoteMachineInventoryController{Client: c, Scheme: scheme}
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "rack-1"}}
+	_, err := r.Reconcile(context.Background(), req)
+	require.ErrorContains(t, err, "worker-3")
+
+	var pm infrastructure.PooledRemoteMachine
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "worker-1"}, &pm))
+	require.Equal(t, "workers", pm.Spec.Pool)
+	require.Equal(t, infrastructure.PooledMachineSpec{
+		Address:   "10.0.0.1",
+		Port:      2222,
+		User:      "ubuntu",
+		UseSudo:   true,
+		SSHKeyRef: infrastructure.SecretRef{Name: "ssh-key"},
+	}, pm.Spec.Machine)
+	require.Equal(t, map[string]string{"zone": "a", infrastructure.InventoryLabel: "rack-1"}, pm.Labels)
+	require.True(t, metav1.IsControlledBy(&pm, inv))
+
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "worker-2"}, &pm))
+	require.Equal(t, 22, pm.Spec.Machine.Port)
+	require.Equal(t, "root", pm.Spec.Machine.User)
+
+	// The unmanaged pooled machine is left untouched
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "worker-3"}, &pm))
+	require.Equal(t, "10.0.0.3", pm.Spec.Machine.Address)
+	require.Empty(t, pm.OwnerReferences)
+
+	// The pooled machine of the removed host is deleted, the reserved one is kept until released
+	require.Error(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "worker-9"}, &pm))
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "worker-8"}, &pm))
+
+	require.NoError(t, c.Get(context.Background(), req.NamespacedName, inv))
+	require.Equal(t, 3, inv.Status.Machines)
+	require.True(t, conditions.IsFalse(inv, infrastructure.InventorySyncedCondition))
+	require.Equal(t, infrastructure.InventorySyncFailedReason, conditions.GetReason(inv, infrastructure.InventorySyncedCondition))
+}
+
+func TestRemoteMachineInventoryController_requestsForConfigMap(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, infrastructure.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&infrastructure.RemoteMachineInventory{
+			ObjectMeta: metav1.ObjectMeta{Name: "rack-1", Namespace: "default"},
+			Spec:       infrastructure.RemoteMachineInventorySpec{Pool: "workers", HostsFrom: &infrastructure.ConfigMapKeyRef{Name: "hosts"}},
+		},
+		&infrastructure.RemoteMachineInventory{
+			ObjectMeta: metav1.ObjectMeta{Name: "rack-2", Namespace: "default"},
+			Spec:       infrastructure.RemoteMachineInventorySpec{Pool: "workers", HostsFrom: &infrastructure.ConfigMapKeyRef{Name: "other-hosts"}},
+		},
+		&infrastructure.RemoteMachineInventory{
+			ObjectMeta: metav1.ObjectMeta{Name: "rack-3", Namespace: "other"},
+			Spec:       infrastructure.RemoteMachineInventorySpec{Pool: "workers", HostsFrom: &infrastructure.ConfigMapKeyRef{Name: "hosts"}},
+		},
+	).Build()
+	r := &RemoteMachineInventoryController{Client: c, Scheme: scheme}
+
+	requests := r.requestsForConfigMap(context.Background(), &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "hosts", Namespace: "default"}})
+	require.Len(t, requests, 1)
+	require.Equal(t, "rack-1", requests[0].Name)
+}
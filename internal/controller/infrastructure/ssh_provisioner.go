@@ -24,19 +24,45 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-logr/logr"
 	api "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
 	"github.com/k0sproject/k0smotron/internal/cloudinit"
+	"github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/tracing"
 	"github.com/k0sproject/rig"
+	"github.com/k0sproject/rig/exec"
+	"github.com/k0sproject/rig/pkg/ssh/hostkey"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/tools/record"
 )
 
 type SSHProvisioner struct {
 	bootstrapData []byte
 	machine       *api.RemoteMachine
-	sshKey        []byte
+	credentials   sshCredentials
+	hooks         provisioningHooks
 	log           logr.Logger
+	// recorder records the commands run on the machine for auditing. The commands are not audited if it is nil, e.g.
+	// for the health probes.
+	recorder record.EventRecorder
+}
+
+// sshCredentials are the credentials to connect to a machine and its bastion host over SSH.
+type sshCredentials struct {
+	// key is the SSH private key of the machine.
+	key []byte
+	// password is the SSH password of the machine.
+	password string
+	// bastionKey is the SSH private key of the bastion host. If empty, the SSH key of the machine is used.
+	bastionKey []byte
+	// knownHosts are the known hosts the host keys are verified against. If nil, the host keys are trusted on first use.
+	knownHosts []byte
+	// sudoPassword is the sudo password of the user. If empty, passwordless sudo is used.
+	sudoPassword string
 }
 
 const stopCommandTemplate = `(command -v systemctl > /dev/null 2>&1 && systemctl stop %s) || (command -v rc-service > /dev/null 2>&1 && rc-service %s stop) || (echo "Not a supported init system"; false)`
@@ -46,39 +72,65 @@ const (
 	workerService = "k0sworker"
 )
 
+// bootstrapSentinelFile is created by the bootstrap data once the bootstrap has completed.
+const bootstrapSentinelFile = "/run/cluster-api/bootstrap-success.complete"
+
+// resetCommand resets k0s, if the machine was provisioned far enough for k0s to be installed.
+const resetCommand = "if command -v k0s > /dev/null 2>&1; then k0s reset; fi"
+
 // Provision provisions a new machine
 // The provisioning process is as follows:
 // 1. Open SSH connection to the machine
-// 2. Execute the bootstrap script
-// 3. Check sentinel file at /run/cluster-api/bootstrap-success.complete
-// 4. success
-func (p *SSHProvisioner) Provision(_ context.Context) error {
+// 2. Apply the static network configuration and reconnect
+// 3. Run the pre-bootstrap hooks
+// 4. Execute the bootstrap script
+// 5. Check sentinel file at /run/cluster-api/bootstrap-success.complete
+// 6. Run the post-join hooks
+// 7. success
+func (p *SSHProvisioner) Provision(ctx context.Context) (err error) {
+	_, span := tracing.Start(ctx, "SSHProvision", sshSpanAttributes(p.machine)...)
+	defer func() { tracing.End(span, err) }()
+
 	// Parse the bootstrap data
 	cloudInit := &cloudinit.CloudInit{}
-	err := yaml.Unmarshal(p.bootstrapData, cloudInit)
+	err = yaml.Unmarshal(p.bootstrapData, cloudInit)
 	if err != nil {
 		return fmt.Errorf("failed to parse bootstrap data: %w", err)
 	}
 
-	authM, err := rig.ParseSSHPrivateKey([]byte(p.sshKey), nil)
+	connection, err := sshConnection(p.machine, p.credentials)
 	if err != nil {
-		return fmt.Errorf("failed to parse ssh key: %w", err)
+		return err
 	}
 
-	connection := &rig.Connection{
-		SSH: &rig.SSH{
-			Address:     p.machine.Spec.Address,
-			Port:        p.machine.Spec.Port,
-			User:        p.machine.Spec.User,
-			AuthMethods: authM,
-		},
+	if err := connection.Connect(); err != nil {
+		if errors.Is(err, hostkey.ErrHostKeyMismatch) {
+			return fmt.Errorf("failed to connect to host: %w", err)
+		}
+		return retryableError{fmt.Errorf("failed to connect to host: %w", err)}
 	}
 
-	if err := connection.Connect(); err != nil {
-		return fmt.Errorf("failed to connect to host: %w", err)
+	// The connection is replaced if the network configuration changes the address of the machine
+	defer func() { connection.Disconnect() }()
+
+	// The output of the failed bootstrap command
+	var commandOutput string
+	defer func() {
+		if err != nil && !isRetryable(err) {
+			err = artifactsError{error: err, artifacts: p.collectFailureArtifacts(connection, err, commandOutput)}
+		}
+	}()
+
+	if p.machine.Spec.Network != nil {
+		connection, err = p.configureNetwork(connection)
+		if err != nil {
+			return err
+		}
 	}
 
-	defer connection.Disconnect()
+	if err := p.runHooks(connection, "pre-bootstrap", p.hooks.preBootstrap); err != nil {
+		return err
+	}
 
 	// Write files first
 	for _, file := range cloudInit.Files {
@@ -89,66 +141,200 @@ func (p *SSHProvisioner) Provision(_ context.Context) error {
 
 	// Execute the bootstrap script commands
 	for _, cmd := range cloudInit.RunCmds {
-		output, err := connection.ExecOutput(cmd)
+		output, err := p.exec(connection, cmd, "")
 		if err != nil {
 			p.log.Error(err, "failed to run command", "output", output)
+			commandOutput = output
 			return fmt.Errorf("failed to run command: %w", err)
 		}
 	}
 
 	// Check for sentinel file
-	fsys := connection.SudoFsys()
-	if _, err := fsys.Stat("/run/cluster-api/bootstrap-success.complete"); err != nil {
+	if !p.machine.Spec.UseSudo {
+		fsys := connection.SudoFsys()
+		if _, err := fsys.Stat(bootstrapSentinelFile); err != nil {
+			return errors.New("bootstrap sentinel file not found")
+		}
+	} else if _, err := p.exec(connection, "test -f "+bootstrapSentinelFile, ""); err != nil {
 		return errors.New("bootstrap sentinel file not found")
 	}
 
-	return nil
+	return p.runHooks(connection, "post-join", p.hooks.postJoin)
 }
 
-// Cleanup cleans up a machine
-// The provisioning process is as follows:
-// 1. Open SSH connection to the machine
-// 2. Stops k0s
-// 3. Removes node from etcd
-// 4. Runs k0s reset
-func (p *SSHProvisioner) Cleanup(_ context.Context, mode RemoteMachineMode) error {
-	if mode == ModeNonK0s {
-		return nil
+// sshConnection returns the SSH connection to the machine. If the machine has a bastion host, the connection is
+// made through it, using the bastion SSH key or the SSH key of the machine if the bastion key is not set.
+// The connection is made only to the hosts presenting the host keys pinned in the status of the machine.
+func sshConnection(rm *api.RemoteMachine, credentials sshCredentials) (*rig.Connection, error) {
+	var (
+		authM []ssh.AuthMethod
+		err   error
+	)
+	if len(credentials.key) > 0 {
+		authM, err = rig.ParseSSHPrivateKey(credentials.key, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ssh key: %w", err)
+		}
 	}
 
-	authM, err := rig.ParseSSHPrivateKey(p.sshKey, nil)
-	if err != nil {
-		return fmt.Errorf("failed to parse ssh key: %w", err)
+	bastionAuthM := authM
+	if rm.Spec.Bastion != nil && len(credentials.bastionKey) > 0 {
+		bastionAuthM, err = rig.ParseSSHPrivateKey(credentials.bastionKey, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bastion ssh key: %w", err)
+		}
+	}
+
+	// The password of the machine is not sent to the bastion host
+	if credentials.password != "" {
+		authM = append(authM[:len(authM):len(authM)], ssh.Password(credentials.password))
+	}
+	if len(authM) == 0 {
+		return nil, fmt.Errorf("neither ssh key nor password is set")
+	}
+
+	if err := pinHostKeys(rm, bastionAuthM, credentials.knownHosts); err != nil {
+		return nil, fmt.Errorf("host key verification failed: %w", err)
 	}
 
 	connection := &rig.Connection{
 		SSH: &rig.SSH{
-			Address:     p.machine.Spec.Address,
-			Port:        p.machine.Spec.Port,
-			User:        p.machine.Spec.User,
+			Address:     rm.SSHAddress(),
+			Port:        rm.Spec.Port,
+			User:        rm.Spec.User,
+			HostKey:     rm.Status.HostKey,
 			AuthMethods: authM,
 		},
 	}
 
+	if bastion := rm.Spec.Bastion; bastion != nil {
+		connection.SSH.Bastion = &rig.SSH{
+			Address:     bastion.Address,
+			Port:        bastion.Port,
+			User:        bastion.User,
+			HostKey:     rm.Status.BastionHostKey,
+			AuthMethods: bastionAuthM,
+		}
+	}
+
+	return connection, nil
+}
+
+// sshSpanAttributes are the attributes of the spans of the SSH sessions to the machine.
+func sshSpanAttributes(rm *api.RemoteMachine) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("k0smotron.remotemachine.name", rm.Name),
+		attribute.String("net.peer.name", rm.SSHAddress()),
+	}
+}
+
+// checkSSHConnection checks that the machine accepts the SSH connection with the given keys, and that the user can
+// run commands with sudo if the machine uses it.
+func checkSSHConnection(rm *api.RemoteMachine, credentials sshCredentials) error {
+	connection, err := sshConnection(rm, credentials)
+	if err != nil {
+		return err
+	}
+	if err := connection.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to host: %w", err)
+	}
+	defer connection.Disconnect()
+
+	if rm.Spec.UseSudo {
+		cmd, opts := sudoCommand("true", "", credentials.sudoPassword)
+		if output, err := connection.ExecOutput(cmd, opts...); err != nil {
+			return fmt.Errorf("failed to run sudo: %w: %s", err, output)
+		}
+	}
+
+	return nil
+}
+
+// exec runs the command on the machine, with sudo if the machine uses it, passing stdin to the command.
+func (p *SSHProvisioner) exec(conn *rig.Connection, cmd, stdin string) (output string, err error) {
+	if p.recorder != nil {
+		defer func() { util.AuditCommand(p.recorder, p.machine, p.machine.SSHAddress(), cmd, output, err) }()
+	}
+
+	if p.machine.Spec.UseSudo {
+		sudoCmd, opts := sudoCommand(cmd, stdin, p.credentials.sudoPassword)
+		return conn.ExecOutput(sudoCmd, opts...)
+	}
+	if stdin != "" {
+		return conn.ExecOutput(cmd, exec.Stdin(stdin))
+	}
+	return conn.ExecOutput(cmd)
+}
+
+// sudoCommand wraps the command to be run by a shell with sudo. The sudo password, if any, is passed to sudo on
+// the standard input ahead of the input of the command, and redacted from the logs.
+func sudoCommand(cmd, stdin, password string) (string, []exec.Option) {
+	if password == "" {
+		var opts []exec.Option
+		if stdin != "" {
+			opts = append(opts, exec.Stdin(stdin))
+		}
+		return "sudo -n -- sh -c " + shellQuote(cmd), opts
+	}
+	return "sudo -S -p '' -- sh -c " + shellQuote(cmd), []exec.Option{exec.Stdin(password + "\n" + stdin), exec.RedactString(password)}
+}
+
+// shellQuote quotes the string as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Cleanup cleans up a machine
+// The cleanup process is as follows:
+// 1. Open SSH connection to the machine
+// 2. Removes node from etcd
+// 3. Stops k0s
+// 4. Runs k0s reset
+// 5. Runs the cleanup commands of the machine
+func (p *SSHProvisioner) Cleanup(ctx context.Context, mode RemoteMachineMode) (err error) {
+	if mode == ModeNonK0s && len(p.machine.Spec.CleanupCommands) == 0 {
+		return nil
+	}
+
+	_, span := tracing.Start(ctx, "SSHCleanup", sshSpanAttributes(p.machine)...)
+	defer func() { tracing.End(span, err) }()
+
+	connection, err := sshConnection(p.machine, p.credentials)
+	if err != nil {
+		return err
+	}
+
 	if err := connection.Connect(); err != nil {
-		p.log.Error(err, "failed to connect to host")
+		return fmt.Errorf("failed to connect to host: %w", err)
 	}
 
 	defer connection.Disconnect()
 
-	var cmds []string
-	if mode == ModeController {
-		cmds = append(cmds, "k0s etcd leave")
-		cmds = append(cmds, fmt.Sprintf(stopCommandTemplate, ctrlService, ctrlService))
-	} else {
-		cmds = append(cmds, fmt.Sprintf(stopCommandTemplate, workerService, workerService))
+	if mode != ModeNonK0s {
+		// Leaving etcd fails on the last member and stopping k0s fails if it's not running, the reset fails if
+		// k0s is still running, so only the reset is checked
+		var cmds []string
+		if mode == ModeController {
+			cmds = append(cmds, "k0s etcd leave")
+			cmds = append(cmds, fmt.Sprintf(stopCommandTemplate, ctrlService, ctrlService))
+		} else {
+			cmds = append(cmds, fmt.Sprintf(stopCommandTemplate, workerService, workerService))
+		}
+		for _, cmd := range cmds {
+			output, err := p.exec(connection, cmd, "")
+			if err != nil {
+				p.log.Error(err, "failed to run command", "output", output)
+			}
+		}
+
+		if output, err := p.exec(connection, resetCommand, ""); err != nil {
+			return fmt.Errorf("failed to reset k0s: %w: %s", err, output)
+		}
 	}
-	cmds = append(cmds, "k0s reset")
 
-	for _, cmd := range cmds {
-		output, err := connection.ExecOutput(cmd)
-		if err != nil {
-			p.log.Error(err, "failed to run command", "output", output)
+	for _, cmd := range p.machine.Spec.CleanupCommands {
+		if output, err := p.exec(connection, cmd, ""); err != nil {
+			return fmt.Errorf("failed to run cleanup command %q: %w: %s", cmd, err, output)
 		}
 	}
 
@@ -156,13 +342,24 @@ func (p *SSHProvisioner) Cleanup(_ context.Context, mode RemoteMachineMode) erro
 }
 
 func (p *SSHProvisioner) uploadFile(conn *rig.Connection, file cloudinit.File) error {
-	fsys := conn.SudoFsys()
 	// Ensure base dir exists for target
 	dir := filepath.Dir(file.Path)
 	perms, err := file.PermissionsAsInt()
 	if err != nil {
 		return fmt.Errorf("failed to parse permissions: %w", err)
 	}
+
+	if p.machine.Spec.UseSudo {
+		// The file system of rig supports only passwordless sudo, so the file is written by a shell run with sudo
+		cmd := fmt.Sprintf("mkdir -p %s && cat > %s && chmod %o %s", shellQuote(dir), shellQuote(file.Path), perms, shellQuote(file.Path))
+		if output, err := p.exec(conn, cmd, file.Content); err != nil {
+			return fmt.Errorf("failed to write remote file: %w: %s", err, output)
+		}
+		p.log.Info("uploaded file", "path", file.Path, "permissions", perms)
+		return nil
+	}
+
+	fsys := conn.SudoFsys()
 	if err := fsys.MkDirAll(dir, fs.FileMode(perms)); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
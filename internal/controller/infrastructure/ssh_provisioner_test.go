@@ -0,0 +1,45 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package infrastructure
+
+import (
+	"os/exec"
+	"testing"
+
+	rigexec "github.com/k0sproject/rig/exec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSudoCommand(t *testing.T) {
+	cmd, opts := sudoCommand(`echo "it's" > /tmp/out`, "", "")
+	require.Equal(t, `sudo -n -- sh -c 'echo "it'\''s" > /tmp/out'`, cmd)
+	require.Empty(t, opts)
+
+	cmd, opts = sudoCommand("sh -s", "echo hello", "secret")
+	require.Equal(t, `sudo -S -p '' -- sh -c 'sh -s'`, cmd)
+	o := rigexec.Build(opts...)
+	require.Equal(t, "secret\necho hello", o.Stdin)
+	require.Equal(t, "[REDACTED]\necho hello", o.Redact(o.Stdin))
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, s := range []string{"plain", "it's", `"double" $HOME; rm -rf /`, "multi\nline"} {
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(s)).Output()
+		require.NoError(t, err)
+		require.Equal(t, s, string(out))
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/cluster-api/util/certs"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/secret"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
+)
+
+const (
+	// fleetStatusInterval is the interval the fleet status is computed at, on top of the changes of the clusters,
+	// as the certificates get closer to their expiry without any change.
+	fleetStatusInterval = 10 * time.Minute
+	// fleetCertificatesExpiryWarningPeriod is the time before the expiry of its certificates when a cluster is
+	// counted as having expiring certificates.
+	fleetCertificatesExpiryWarningPeriod = 30 * 24 * time.Hour
+)
+
+// hostedClusterCertificates are the purposes of the certificates generated for the hosted clusters.
+var hostedClusterCertificates = []secret.Purpose{
+	secret.ClusterCA,
+	secret.FrontProxyCA,
+	secret.EtcdCA,
+	secret.APIServerEtcdClient,
+	"etcd-server",
+	"etcd-peer",
+}
+
+// ClusterFleetStatusReconciler maintains the ClusterFleetStatus summarizing all the clusters managed by k0smotron.
+type ClusterFleetStatusReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Shard selects the resources reconciled by the controller. All the resources are reconciled if nil.
+	Shard *sharding.Shard
+}
+
+//+kubebuilder:rbac:groups=k0smotron.io,resources=clusterfleetstatuses,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=k0smotron.io,resources=clusterfleetstatuses/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=k0smotron.io,resources=clusters,verbs=get;list;watch
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=k0scontrolplanes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+func (r *ClusterFleetStatusReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if req.Name != km.ClusterFleetStatusName {
+		return ctrl.Result{}, nil
+	}
+
+	fleet := &km.ClusterFleetStatus{}
+	if err := r.Get(ctx, req.NamespacedName, fleet); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		fleet.Name = km.ClusterFleetStatusName
+		if err := r.Create(ctx, fleet); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to create the ClusterFleetStatus: %w", err)
+		}
+	}
+
+	hosted, err := r.summarizeHostedClusters(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	controlPlanes, err := r.summarizeControlPlanes(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	fleet.Status = km.ClusterFleetStatusStatus{
+		HostedClusters: hosted,
+		ControlPlanes:  controlPlanes,
+		LastUpdateTime: metav1.Now(),
+	}
+	if err := r.Status().Update(ctx, fleet); err != nil {
+		logger.Error(err, "Failed to update ClusterFleetStatus status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: fleetStatusInterval}, nil
+}
+
+// summarizeHostedClusters summarizes the k0smotron Clusters. A cluster is pending upgrade while its StatefulSet
+// rolls out a new revision.
+func (r *ClusterFleetStatusReconciler) summarizeHostedClusters(ctx context.Context) (km.FleetSummary, error) {
+	var clusters km.ClusterList
+	if err := r.List(ctx, &clusters); err != nil {
+		return km.FleetSummary{}, err
+	}
+
+	summary := km.FleetSummary{}
+	versions := map[string]int32{}
+	for i := range clusters.Items {
+		kmc := &clusters.Items[i]
+		summary.Total++
+		versions[kmc.Spec.GetVersion()]++
+
+		if !meta.IsStatusConditionTrue(kmc.Status.Conditions, km.ReadyCondition) {
+			summary.NotReady++
+		}
+
+		var sts apps.StatefulSet
+		err := r.Get(ctx, types.NamespacedName{Name: kmc.GetStatefulSetName(), Namespace: kmc.Namespace}, &sts)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return km.FleetSummary{}, err
+		}
+		if err == nil && isStatefulSetRollingOut(&sts) {
+			summary.PendingUpgrade++
+		}
+
+		expiring, err := r.hasExpiringCertificates(ctx, kmc)
+		if err != nil {
+			return km.FleetSummary{}, err
+		}
+		if expiring {
+			summary.ExpiringCertificates++
+		}
+	}
+	summary.Versions = versionCounts(versions)
+
+	return summary, nil
+}
+
+// summarizeControlPlanes summarizes the K0sControlPlanes. Their pending upgrades and expiring certificates are read
+// from their MachinesSpecUpToDate and MachineCertificatesValid conditions.
+func (r *ClusterFleetStatusReconciler) summarizeControlPlanes(ctx context.Context) (km.FleetSummary, error) {
+	var kcps cpv1beta1.K0sControlPlaneList
+	if err := r.List(ctx, &kcps); err != nil {
+		return km.FleetSummary{}, err
+	}
+
+	summary := km.FleetSummary{}
+	versions := map[string]int32{}
+	for i := range kcps.Items {
+		kcp := &kcps.Items[i]
+		summary.Total++
+		if kcp.Spec.Version != "" {
+			versions[kcp.Spec.Version]++
+		}
+		if !kcp.Status.Ready {
+			summary.NotReady++
+		}
+		if conditions.IsFalse(kcp, cpv1beta1.MachinesSpecUpToDateCondition) {
+			summary.PendingUpgrade++
+		}
+		if conditions.GetReason(kcp, cpv1beta1.MachineCertificatesValidCondition) == cpv1beta1.CertificatesExpiringSoonReason {
+			summary.ExpiringCertificates++
+		}
+	}
+	summary.Versions = versionCounts(versions)
+
+	return summary, nil
+}
+
+// hasExpiringCertificates returns true if any of the certificates generated for the hosted cluster expires soon.
+// The certificates that are not generated are ignored.
+func (r *ClusterFleetStatusReconciler) hasExpiringCertificates(ctx context.Context, kmc *km.Cluster) (bool, error) {
+	for _, purpose := range hostedClusterCertificates {
+		var s v1.Secret
+		err := r.Get(ctx, types.NamespacedName{Name: secret.Name(kmc.Name, purpose), Namespace: kmc.Namespace}, &s)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return false, err
+		}
+		if isCertificateExpiring(s.Data[secret.TLSCrtDataName], time.Now()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isCertificateExpiring returns true if the PEM encoded certificate expires in less than
+// fleetCertificatesExpiryWarningPeriod. Certificates that can't be decoded are not counted as expiring.
+func isCertificateExpiring(data []byte, now time.Time) bool {
+	cert, err := certs.DecodeCertPEM(data)
+	if err != nil || cert == nil {
+		return false
+	}
+	return cert.NotAfter.Sub(now) < fleetCertificatesExpiryWarningPeriod
+}
+
+// isStatefulSetRollingOut returns true while the pods of the StatefulSet are not all updated to its current revision.
+func isStatefulSetRollingOut(sts *apps.StatefulSet) bool {
+	return sts.Status.UpdateRevision != "" && sts.Status.CurrentRevision != sts.Status.UpdateRevision
+}
+
+// versionCounts returns the counts by version sorted by version.
+func versionCounts(versions map[string]int32) []km.VersionCount {
+	counts := make([]km.VersionCount, 0, len(versions))
+	for version, count := range versions {
+		counts = append(counts, km.VersionCount{Version: version, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Version < counts[j].Version
+	})
+	return counts
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterFleetStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		// The status updates of the ClusterFleetStatus itself don't trigger a new summary
+		For(&km.ClusterFleetStatus{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&km.Cluster{}, handler.EnqueueRequestsFromMapFunc(requestForFleetStatus)).
+		Watches(&cpv1beta1.K0sControlPlane{}, handler.EnqueueRequestsFromMapFunc(requestForFleetStatus)).
+		Complete(r.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("ClusterFleetStatus", r)))
+}
+
+// requestForFleetStatus maps the changes of the clusters to the ClusterFleetStatus.
+func requestForFleetStatus(_ context.Context, _ client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: km.ClusterFleetStatusName}}}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestClusterFleetStatusReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+	require.NoError(t, cpv1beta1.AddToScheme(scheme))
+
+	ready := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "ready", Namespace: "default"},
+		Spec:       km.ClusterSpec{Version: "v1.29.1-k0s.0"},
+		Status: km.ClusterStatus{Conditions: []metav1.Condition{
+			{Type: km.ReadyCondition, Status: metav1.ConditionTrue},
+		}},
+	}
+	upgrading := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "upgrading", Namespace: "default"},
+		Spec:       km.ClusterSpec{Version: "v1.29.1"},
+	}
+	upgradingSts := &apps.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kmc-upgrading", Namespace: "default"},
+		Status:     apps.StatefulSetStatus{CurrentRevision: "kmc-upgrading-1", UpdateRevision: "kmc-upgrading-2"},
+	}
+	expiring := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "expiring", Namespace: "default"},
+		Status: km.ClusterStatus{Conditions: []metav1.Condition{
+			{Type: km.ReadyCondition, Status: metav1.ConditionTrue},
+		}},
+	}
+	expiringCert := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "expiring-etcd-server", Namespace: "default"},
+		Data:       map[string][]byte{"tls.crt": testCertificatePEM(t, time.Now().Add(24*time.Hour))},
+	}
+	validCert := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ready-etcd-server", Namespace: "default"},
+		Data:       map[string][]byte{"tls.crt": testCertificatePEM(t, time.Now().Add(365*24*time.Hour))},
+	}
+	kcp := &cpv1beta1.K0sControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default"},
+		Spec:       cpv1beta1.K0sControlPlaneSpec{Version: "v1.29.1+k0s.0"},
+	}
+	conditions.MarkFalse(kcp, cpv1beta1.MachinesSpecUpToDateCondition, cpv1beta1.RollingUpdateInProgressReason, clusterv1.ConditionSeverityWarning, "")
+	conditions.MarkFalse(kcp, cpv1beta1.MachineCertificatesValidCondition, cpv1beta1.CertificatesExpiringSoonReason, clusterv1.ConditionSeverityWarning, "")
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(ready, upgrading, upgradingSts, expiring, expiringCert, validCert, kcp).
+		WithStatusSubresource(&km.ClusterFleetStatus{}).
+		Build()
+	r := &ClusterFleetStatusReconciler{Client: c, Scheme: scheme}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: km.ClusterFleetStatusName}})
+	require.NoError(t, err)
+
+	var fleet km.ClusterFleetStatus
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: km.ClusterFleetStatusName}, &fleet))
+	assert.Equal(t, km.FleetSummary{
+		Total:                3,
+		NotReady:             1,
+		PendingUpgrade:       1,
+		ExpiringCertificates: 1,
+		Versions: []km.VersionCount{
+			{Version: "v1.27.9-k0s.0", Count: 1},
+			{Version: "v1.29.1-k0s.0", Count: 2},
+		},
+	}, fleet.Status.HostedClusters)
+	assert.Equal(t, km.FleetSummary{
+		Total:                1,
+		NotReady:             1,
+		PendingUpgrade:       1,
+		ExpiringCertificates: 1,
+		Versions:             []km.VersionCount{{Version: "v1.29.1+k0s.0", Count: 1}},
+	}, fleet.Status.ControlPlanes)
+	assert.False(t, fleet.Status.LastUpdateTime.IsZero())
+}
+
+func testCertificatePEM(t *testing.T, notAfter time.Time) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -28,6 +28,7 @@ import (
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -35,14 +36,24 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
 	"github.com/k0sproject/k0smotron/internal/controller/util"
 	"github.com/k0sproject/k0smotron/internal/exec"
+	"github.com/k0sproject/k0smotron/internal/secretstore"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
 )
 
 // JoinTokenRequestReconciler reconciles a JoinTokenRequest object
@@ -51,12 +62,31 @@ type JoinTokenRequestReconciler struct {
 	Scheme     *runtime.Scheme
 	ClientSet  *kubernetes.Clientset
 	RESTConfig *rest.Config
+	// SecretStores holds the external stores the tokens can be written to instead of Kubernetes Secrets.
+	SecretStores *secretstore.Registry
+	// Recorder records the Events about the issued and invalidated tokens.
+	Recorder record.EventRecorder
+	// MaxConcurrentReconciles is the maximum number of JoinTokenRequests reconciled at the same time.
+	MaxConcurrentReconciles int
+	// Shard selects the resources reconciled by the controller. All the resources are reconciled if nil.
+	Shard *sharding.Shard
+
+	// tokenInvalidator invalidates the issued token in the cluster. Defaults to invalidateClusterToken.
+	tokenInvalidator func(ctx context.Context, jtr *km.JoinTokenRequest, namespace string) error
 }
 
+// jtrClusterNamespaceField is the field index of the resolved cluster namespace of a JoinTokenRequest.
+const jtrClusterNamespaceField = "spec.clusterRef.resolvedNamespace"
+
+// jtrClusterField is the field index of the referenced cluster of a JoinTokenRequest, as namespace/name.
+const jtrClusterField = "spec.clusterRef.resolved"
+
 //+kubebuilder:rbac:groups=k0smotron.io,resources=jointokenrequests,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=k0smotron.io,resources=jointokenrequests/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=k0smotron.io,resources=jointokenrequests/finalizers,verbs=update
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=k0smotron.io,resources=referencegrants,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 func (r *JoinTokenRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
@@ -70,19 +100,49 @@ func (r *JoinTokenRequestReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	clusterNamespace := clusterRefNamespace(jtr.Spec.ClusterRef, &jtr)
+	ctx = tracing.WithCluster(ctx, jtr.Spec.ClusterRef.Name)
+	if jtr.ObjectMeta.DeletionTimestamp.IsZero() {
+		granted, err := isReferenceGranted(ctx, r.Client,
+			km.ReferenceGrantFrom{Group: km.GroupVersion.Group, Kind: "JoinTokenRequest", Namespace: jtr.Namespace},
+			clusterNamespace,
+			km.ReferenceGrantTo{Group: km.GroupVersion.Group, Kind: "Cluster", Name: jtr.Spec.ClusterRef.Name})
+		if err != nil {
+			r.updateStatus(ctx, jtr, "Failed checking reference grants")
+			return ctrl.Result{}, util.ReconcileError(err)
+		}
+		if !granted {
+			logger.Info("Cross-namespace cluster reference is not permitted by any ReferenceGrant", "clusterNamespace", clusterNamespace)
+			if jtr.Status.TokenID != "" {
+				if err := r.revokeToken(ctx, &jtr, clusterNamespace); err != nil {
+					r.updateStatus(ctx, jtr, "Failed revoking token")
+					return ctrl.Result{}, util.ReconcileError(err)
+				}
+			}
+			r.updateStatus(ctx, jtr, fmt.Sprintf("Reference to cluster %s/%s not permitted", clusterNamespace, jtr.Spec.ClusterRef.Name))
+			return ctrl.Result{}, nil
+		}
+	}
+
 	var cluster km.Cluster
-	err := r.Client.Get(ctx, types.NamespacedName{Name: jtr.Spec.ClusterRef.Name, Namespace: jtr.Spec.ClusterRef.Namespace}, &cluster)
+	err := r.Client.Get(ctx, types.NamespacedName{Name: jtr.Spec.ClusterRef.Name, Namespace: clusterNamespace}, &cluster)
 	if err != nil {
 		r.updateStatus(ctx, jtr, "Failed getting cluster")
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		return ctrl.Result{}, util.ReconcileError(err)
 	}
 	jtr.Status.ClusterUID = cluster.GetUID()
 
+	store, err := r.SecretStores.Get(cluster.GetSecretStoreProvider())
+	if err != nil {
+		r.updateStatus(ctx, jtr, "Failed getting secret store")
+		return ctrl.Result{}, util.ReconcileError(err)
+	}
+
 	logger.Info("Reconciling")
-	pod, err := util.FindStatefulSetPod(ctx, r.ClientSet, km.GetStatefulSetName(jtr.Spec.ClusterRef.Name), jtr.Spec.ClusterRef.Namespace)
+	pod, err := util.FindStatefulSetPod(ctx, r.ClientSet, km.GetStatefulSetName(jtr.Spec.ClusterRef.Name), clusterNamespace)
 	if err != nil {
 		r.updateStatus(ctx, jtr, "Failed finding pods in statefulset")
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		return ctrl.Result{}, util.ReconcileError(err)
 	}
 
 	finalizerName := "jointokenrequests.k0smotron.io/finalizer"
@@ -91,6 +151,12 @@ func (r *JoinTokenRequestReconciler) Reconcile(ctx context.Context, req ctrl.Req
 			if err := r.invalidateToken(ctx, &jtr, pod); err != nil {
 				return ctrl.Result{}, err
 			}
+			util.RecordEvent(r.Recorder, &jtr, v1.EventTypeNormal, util.TokenInvalidatedReason, "Invalidated token %s", jtr.Status.TokenID)
+			if store != nil {
+				if err := store.Delete(ctx, secretstore.Key{Namespace: jtr.Namespace, Name: jtr.Name}); err != nil {
+					return ctrl.Result{}, fmt.Errorf("failed to delete token from secret store: %w", err)
+				}
+			}
 			controllerutil.RemoveFinalizer(&jtr, finalizerName)
 			if err := r.Update(ctx, &jtr); err != nil {
 				return ctrl.Result{}, err
@@ -104,46 +170,198 @@ func (r *JoinTokenRequestReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	}
 
 	if jtr.Status.TokenID != "" {
-		logger.Info("Already reconciled")
-		return ctrl.Result{}, nil
+		recreate, err := r.tokenSecretDeleted(ctx, jtr, store)
+		if err != nil {
+			r.updateStatus(ctx, jtr, "Failed getting secret")
+			return ctrl.Result{}, util.ReconcileError(err)
+		}
+		if !recreate {
+			if err := r.rewriteTokenAddress(ctx, jtr, cluster, store); err != nil {
+				r.updateStatus(ctx, jtr, "Failed rewriting token address")
+				return ctrl.Result{}, util.ReconcileError(err)
+			}
+			logger.Info("Already reconciled")
+			return ctrl.Result{}, nil
+		}
+
+		// The token of the deleted secret is replaced with a new one
+		logger.Info("Token secret deleted, creating a new token")
+		if err := r.invalidateToken(ctx, &jtr, pod); err != nil {
+			r.updateStatus(ctx, jtr, "Failed invalidating token")
+			return ctrl.Result{}, util.ReconcileError(err)
+		}
+		util.RecordEvent(r.Recorder, &jtr, v1.EventTypeNormal, util.TokenInvalidatedReason, "Invalidated token %s", jtr.Status.TokenID)
+		jtr.Status.TokenID = ""
+		jtr.Status.ExpiresAt = nil
+	}
+
+	if _, err := time.ParseDuration(jtr.Spec.Expiry); jtr.Spec.Expiry != "" && err != nil {
+		r.updateStatus(ctx, jtr, "Invalid expiry")
+		return ctrl.Result{}, util.ReconcileError(util.InvalidSpec(fmt.Errorf("invalid expiry %q: %w", jtr.Spec.Expiry, err)))
 	}
 
 	cmd := fmt.Sprintf("k0s token create --role=%s --expiry=%s", jtr.Spec.Role, jtr.Spec.Expiry)
-	token, err := exec.PodExecCmdOutput(ctx, r.ClientSet, r.RESTConfig, pod.Name, pod.Namespace, cmd)
+	token, err := exec.PodExecCmdOutput(ctx, r.ClientSet, r.RESTConfig, pod.Name, client.ObjectKeyFromObject(&cluster), cmd)
+	// The output is the join token, so it is not audited
+	util.AuditCommand(r.Recorder, &jtr, pod.Name, cmd, "", err)
 	if err != nil {
 		r.updateStatus(ctx, jtr, "Failed getting token")
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		return ctrl.Result{}, util.ReconcileError(err)
 	}
 
 	newToken, newKubeconfig, err := ReplaceTokenPort(token, cluster)
 	if err != nil {
 		r.updateStatus(ctx, jtr, "Failed update token URL")
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		return ctrl.Result{}, util.ReconcileError(err)
 	}
 
-	if err := r.reconcileSecret(ctx, jtr, newToken); err != nil {
+	if err := r.reconcileSecret(ctx, jtr, newToken, store); err != nil {
 		r.updateStatus(ctx, jtr, "Failed creating secret")
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		return ctrl.Result{}, util.ReconcileError(err)
 	}
 
 	tokenID, err := getTokenID(newKubeconfig, jtr.Spec.Role)
 	if err != nil {
 		r.updateStatus(ctx, jtr, "Failed getting token id")
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		return ctrl.Result{}, util.ReconcileError(err)
 	}
 	jtr.Status.TokenID = tokenID
+	if expiry, err := time.ParseDuration(jtr.Spec.Expiry); err == nil && expiry > 0 {
+		expiresAt := metav1.NewTime(time.Now().Add(expiry).Truncate(time.Second))
+		jtr.Status.ExpiresAt = &expiresAt
+	}
+	util.RecordEvent(r.Recorder, &jtr, v1.EventTypeNormal, util.TokenCreatedReason, "Created %s token %s", jtr.Spec.Role, tokenID)
 	r.updateStatus(ctx, jtr, "Reconciliation successful")
 	return ctrl.Result{}, nil
 }
 
+// revokeToken removes the token secret and invalidates the issued token in the cluster.
+func (r *JoinTokenRequestReconciler) revokeToken(ctx context.Context, jtr *km.JoinTokenRequest, namespace string) error {
+	secret := v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jtr.Name,
+			Namespace: jtr.Namespace,
+		},
+	}
+	if err := r.Client.Delete(ctx, &secret); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete token secret: %w", err)
+	}
+
+	store, err := r.secretStore(ctx, jtr, namespace)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		if err := store.Delete(ctx, secretstore.Key{Namespace: jtr.Namespace, Name: jtr.Name}); err != nil {
+			return fmt.Errorf("failed to delete token from secret store: %w", err)
+		}
+	}
+
+	invalidate := r.tokenInvalidator
+	if invalidate == nil {
+		invalidate = r.invalidateClusterToken
+	}
+	if err := invalidate(ctx, jtr, namespace); err != nil {
+		return fmt.Errorf("failed to invalidate token: %w", err)
+	}
+	util.RecordEvent(r.Recorder, jtr, v1.EventTypeNormal, util.TokenInvalidatedReason, "Invalidated token %s", jtr.Status.TokenID)
+
+	jtr.Status.TokenID = ""
+	jtr.Status.ExpiresAt = nil
+	return nil
+}
+
+// tokenSecretDeleted returns true if the secret of the issued token has been deleted. The tokens written to an external
+// secret store are not checked.
+func (r *JoinTokenRequestReconciler) tokenSecretDeleted(ctx context.Context, jtr km.JoinTokenRequest, store secretstore.Store) (bool, error) {
+	if store != nil {
+		return false, nil
+	}
+	err := r.Client.Get(ctx, client.ObjectKey{Namespace: jtr.Namespace, Name: jtr.Name}, &v1.Secret{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// rewriteTokenAddress rewrites the API server address in the token of the secret if the address of the cluster has
+// changed since the token was issued, e.g. after the service type of the cluster was changed. The token itself stays
+// valid, so it's not re-issued. The tokens written to an external secret store are not rewritten.
+func (r *JoinTokenRequestReconciler) rewriteTokenAddress(ctx context.Context, jtr km.JoinTokenRequest, cluster km.Cluster, store secretstore.Store) error {
+	if store != nil || cluster.Spec.ExternalAddress == "" {
+		return nil
+	}
+
+	var secret v1.Secret
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: jtr.Namespace, Name: jtr.Name}, &secret); err != nil {
+		return err
+	}
+	token := string(secret.Data["token"])
+	current, err := tokenServer(token)
+	if err != nil {
+		return err
+	}
+	newToken, cfg, err := ReplaceTokenPort(token, cluster)
+	if err != nil {
+		return err
+	}
+	if cfg.Clusters["k0s"].Server == current {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Cluster address changed, rewriting the token", "server", cfg.Clusters["k0s"].Server)
+	return r.reconcileSecret(ctx, jtr, newToken, nil)
+}
+
+// tokenServer returns the API server address in the kubeconfig of the token.
+func tokenServer(token string) (string, error) {
+	b, err := tokenDecode(token)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := clientcmd.Load(b)
+	if err != nil {
+		return "", err
+	}
+	cluster, ok := cfg.Clusters["k0s"]
+	if !ok {
+		return "", fmt.Errorf("the token has no k0s cluster")
+	}
+	return cluster.Server, nil
+}
+
+// secretStore returns the external secret store of the referenced cluster or nil if the token is stored in a Secret.
+func (r *JoinTokenRequestReconciler) secretStore(ctx context.Context, jtr *km.JoinTokenRequest, namespace string) (secretstore.Store, error) {
+	var cluster km.Cluster
+	err := r.Client.Get(ctx, types.NamespacedName{Name: jtr.Spec.ClusterRef.Name, Namespace: namespace}, &cluster)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+	return r.SecretStores.Get(cluster.GetSecretStoreProvider())
+}
+
+func (r *JoinTokenRequestReconciler) invalidateClusterToken(ctx context.Context, jtr *km.JoinTokenRequest, namespace string) error {
+	pod, err := util.FindStatefulSetPod(ctx, r.ClientSet, km.GetStatefulSetName(jtr.Spec.ClusterRef.Name), namespace)
+	if err != nil {
+		return err
+	}
+	return r.invalidateToken(ctx, jtr, pod)
+}
+
 func (r *JoinTokenRequestReconciler) invalidateToken(ctx context.Context, jtr *km.JoinTokenRequest, pod *v1.Pod) error {
 	cmd := fmt.Sprintf("k0s token invalidate %s", jtr.Status.TokenID)
-	_, err := exec.PodExecCmdOutput(ctx, r.ClientSet, r.RESTConfig, pod.Name, pod.Namespace, cmd)
+	output, err := exec.PodExecCmdOutput(ctx, r.ClientSet, r.RESTConfig, pod.Name, types.NamespacedName{Namespace: pod.Namespace, Name: jtr.Spec.ClusterRef.Name}, cmd)
+	util.AuditCommand(r.Recorder, jtr, pod.Name, cmd, output, err)
 	return err
 }
 
-func (r *JoinTokenRequestReconciler) reconcileSecret(ctx context.Context, jtr km.JoinTokenRequest, token string) error {
+func (r *JoinTokenRequestReconciler) reconcileSecret(ctx context.Context, jtr km.JoinTokenRequest, token string, store secretstore.Store) error {
 	logger := log.FromContext(ctx)
+	if store != nil {
+		logger.Info("Writing token to the secret store")
+		return store.Put(ctx, secretstore.Key{Namespace: jtr.Namespace, Name: jtr.Name}, map[string]string{"token": token})
+	}
+
 	logger.Info("Reconciling configmap")
 
 	cm, err := r.generateSecret(&jtr, token)
@@ -187,16 +405,111 @@ func (r *JoinTokenRequestReconciler) generateSecret(jtr *km.JoinTokenRequest, to
 func (r *JoinTokenRequestReconciler) updateStatus(ctx context.Context, jtr km.JoinTokenRequest, status string) {
 	logger := log.FromContext(ctx)
 	jtr.Status.ReconciliationStatus = status
-	if err := r.Status().Update(ctx, &jtr); err != nil {
+	newStatus := jtr.Status
+	if err := util.UpdateStatus(ctx, r.Client, &jtr, func(jtr *km.JoinTokenRequest) { jtr.Status = newStatus }); err != nil {
 		logger.Error(err, fmt.Sprintf("Unable to update status: %s", status))
 	}
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *JoinTokenRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &km.JoinTokenRequest{}, jtrClusterNamespaceField, indexJoinTokenRequestClusterNamespace); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &km.JoinTokenRequest{}, jtrClusterField, indexJoinTokenRequestCluster); err != nil {
+		return err
+	}
+
+	// The new requests get their tokens from a separate controller, ahead of the other reconciles
+	lock := util.NewKeyLock()
+	newRequest := util.CreatedPredicate(func(e event.CreateEvent) bool {
+		jtr := e.Object.(*km.JoinTokenRequest)
+		return jtr.Status.TokenID == "" && jtr.Status.ReconciliationStatus == ""
+	})
+	if err := ctrl.NewControllerManagedBy(mgr).
+		Named("jointokenrequest-provisioning").
+		For(&km.JoinTokenRequest{}, builder.WithPredicates(newRequest)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(r.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("JoinTokenRequest", util.ProvisioningLane(util.Exclusive(lock, r), r.tokenIssued)))); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&km.JoinTokenRequest{}).
-		Complete(r)
+		For(&km.JoinTokenRequest{}, builder.WithPredicates(predicate.Not(newRequest))).
+		// A deleted token secret is replaced right away
+		Owns(&v1.Secret{}).
+		Watches(&km.ReferenceGrant{}, handler.EnqueueRequestsFromMapFunc(r.requestsForReferenceGrant)).
+		// The requests waiting for their cluster are reconciled once it's created or gets ready, and the issued tokens
+		// are rewritten when the address of the cluster changes
+		Watches(&km.Cluster{}, handler.EnqueueRequestsFromMapFunc(r.requestsForCluster), builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldCluster, newCluster := e.ObjectOld.(*km.Cluster), e.ObjectNew.(*km.Cluster)
+				return (!oldCluster.Status.Ready && newCluster.Status.Ready) ||
+					oldCluster.Spec.ExternalAddress != newCluster.Spec.ExternalAddress ||
+					oldCluster.Spec.Service.APIPort != newCluster.Spec.Service.APIPort
+			},
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		})).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(r.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("JoinTokenRequest", util.Exclusive(lock, r))))
+}
+
+// tokenIssued returns whether the token of the request is issued or the request is gone, so it's left to the main
+// controller.
+func (r *JoinTokenRequestReconciler) tokenIssued(ctx context.Context, key types.NamespacedName) bool {
+	var jtr km.JoinTokenRequest
+	return r.Client.Get(ctx, key, &jtr) != nil || jtr.Status.TokenID != ""
+}
+
+// requestsForReferenceGrant returns the cross-namespace JoinTokenRequests referencing a cluster in the namespace of the grant.
+func (r *JoinTokenRequestReconciler) requestsForReferenceGrant(ctx context.Context, obj client.Object) []reconcile.Request {
+	var jtrs km.JoinTokenRequestList
+	if err := r.List(ctx, &jtrs, client.MatchingFields{jtrClusterNamespaceField: obj.GetNamespace()}); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list JoinTokenRequests")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, jtr := range jtrs.Items {
+		if jtr.Namespace == obj.GetNamespace() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: jtr.Name, Namespace: jtr.Namespace}})
+	}
+
+	return requests
+}
+
+// requestsForCluster returns the JoinTokenRequests referencing the cluster.
+func (r *JoinTokenRequestReconciler) requestsForCluster(ctx context.Context, obj client.Object) []reconcile.Request {
+	var jtrs km.JoinTokenRequestList
+	if err := r.List(ctx, &jtrs, client.MatchingFields{jtrClusterField: obj.GetNamespace() + "/" + obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list JoinTokenRequests")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(jtrs.Items))
+	for _, jtr := range jtrs.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: jtr.Name, Namespace: jtr.Namespace}})
+	}
+	return requests
+}
+
+func indexJoinTokenRequestCluster(obj client.Object) []string {
+	jtr, ok := obj.(*km.JoinTokenRequest)
+	if !ok {
+		return nil
+	}
+	return []string{clusterRefNamespace(jtr.Spec.ClusterRef, jtr) + "/" + jtr.Spec.ClusterRef.Name}
+}
+
+func indexJoinTokenRequestClusterNamespace(obj client.Object) []string {
+	jtr, ok := obj.(*km.JoinTokenRequest)
+	if !ok {
+		return nil
+	}
+	return []string{clusterRefNamespace(jtr.Spec.ClusterRef, jtr)}
 }
 
 func replaceKubeconfigPort(in string, cluster km.Cluster) (string, *api.Config, error) {
@@ -209,8 +522,12 @@ func replaceKubeconfigPort(in string, cluster km.Cluster) (string, *api.Config,
 	if err != nil {
 		return "", nil, err
 	}
-	parts := strings.Split(u.Host, ":")
-	u.Host = fmt.Sprintf("%s:%d", parts[0], cluster.Spec.Service.APIPort)
+	host := strings.Split(u.Host, ":")[0]
+	// k0s may still run with the previous address while the pods are restarted with a new one
+	if cluster.Spec.ExternalAddress != "" {
+		host = cluster.Spec.ExternalAddress
+	}
+	u.Host = fmt.Sprintf("%s:%d", host, cluster.Spec.Service.APIPort)
 
 	cfg.Clusters["k0s"].Server = u.String()
 
@@ -0,0 +1,295 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/secretstore"
+)
+
+func newJoinTokenRequestTestClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&km.JoinTokenRequest{}).
+		WithIndex(&km.JoinTokenRequest{}, jtrClusterNamespaceField, indexJoinTokenRequestClusterNamespace).
+		WithIndex(&km.JoinTokenRequest{}, jtrClusterField, indexJoinTokenRequestCluster).
+		Build()
+}
+
+func TestJoinTokenRequestReconciler_crossNamespaceDenied(t *testing.T) {
+	jtr := &km.JoinTokenRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-token", Namespace: "tenant"},
+		Spec: km.JoinTokenRequestSpec{
+			ClusterRef: km.ClusterRef{Name: "my-cluster", Namespace: "clusters"},
+			Role:       "worker",
+		},
+	}
+	c := newJoinTokenRequestTestClient(t, jtr)
+	r := &JoinTokenRequestReconciler{Client: c, Scheme: c.Scheme()}
+
+	res, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "my-token", Namespace: "tenant"}})
+	require.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, res)
+
+	var got km.JoinTokenRequest
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(jtr), &got))
+	assert.Equal(t, "Reference to cluster clusters/my-cluster not permitted", got.Status.ReconciliationStatus)
+	assert.Empty(t, got.Status.TokenID)
+
+	err = c.Get(context.Background(), client.ObjectKeyFromObject(jtr), &v1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestJoinTokenRequestReconciler_revokedGrantInvalidatesToken(t *testing.T) {
+	jtr := &km.JoinTokenRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-token", Namespace: "tenant"},
+		Spec: km.JoinTokenRequestSpec{
+			ClusterRef: km.ClusterRef{Name: "my-cluster", Namespace: "clusters"},
+			Role:       "worker",
+		},
+		Status: km.JoinTokenRequestStatus{
+			ReconciliationStatus: "Reconciliation successful",
+			TokenID:              "abcdef",
+		},
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-token", Namespace: "tenant"},
+		StringData: map[string]string{"token": "token"},
+	}
+	c := newJoinTokenRequestTestClient(t, jtr, secret)
+
+	var invalidated []string
+	recorder := record.NewFakeRecorder(10)
+	r := &JoinTokenRequestReconciler{
+		Client:   c,
+		Scheme:   c.Scheme(),
+		Recorder: recorder,
+		tokenInvalidator: func(_ context.Context, jtr *km.JoinTokenRequest, namespace string) error {
+			invalidated = append(invalidated, namespace+"/"+jtr.Status.TokenID)
+			return nil
+		},
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "my-token", Namespace: "tenant"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"clusters/abcdef"}, invalidated)
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Normal TokenInvalidated Invalidated token abcdef", <-recorder.Events)
+
+	var got km.JoinTokenRequest
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(jtr), &got))
+	assert.Equal(t, "Reference to cluster clusters/my-cluster not permitted", got.Status.ReconciliationStatus)
+	assert.Empty(t, got.Status.TokenID)
+
+	err = c.Get(context.Background(), client.ObjectKeyFromObject(secret), &v1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+type fakeSecretStore struct {
+	data map[secretstore.Key]map[string]string
+}
+
+func (s *fakeSecretStore) Put(_ context.Context, key secretstore.Key, data map[string]string) error {
+	s.data[key] = data
+	return nil
+}
+
+func (s *fakeSecretStore) Delete(_ context.Context, key secretstore.Key) error {
+	delete(s.data, key)
+	return nil
+}
+
+func (s *fakeSecretStore) Get(_ context.Context, path string) (map[string]string, error) {
+	for key, data := range s.data {
+		if key.Path() == path {
+			return data, nil
+		}
+	}
+	return nil, secretstore.ErrNotFound
+}
+
+func TestJoinTokenRequestReconciler_revokedGrantDeletesStoredToken(t *testing.T) {
+	jtr := &km.JoinTokenRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-token", Namespace: "tenant"},
+		Spec: km.JoinTokenRequestSpec{
+			ClusterRef: km.ClusterRef{Name: "my-cluster", Namespace: "clusters"},
+			Role:       "worker",
+		},
+		Status: km.JoinTokenRequestStatus{TokenID: "abcdef"},
+	}
+	cluster := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "clusters"},
+		Spec:       km.ClusterSpec{SecretStore: &km.SecretStoreSpec{Provider: secretstore.ProviderVault}},
+	}
+	c := newJoinTokenRequestTestClient(t, jtr, cluster)
+
+	key := secretstore.Key{Namespace: "tenant", Name: "my-token"}
+	store := &fakeSecretStore{data: map[secretstore.Key]map[string]string{key: {"token": "token"}}}
+	stores := secretstore.NewRegistry(secretstore.ProviderKubernetes)
+	stores.Register(secretstore.ProviderVault, store)
+
+	r := &JoinTokenRequestReconciler{
+		Client:       c,
+		Scheme:       c.Scheme(),
+		SecretStores: stores,
+		tokenInvalidator: func(_ context.Context, _ *km.JoinTokenRequest, _ string) error {
+			return nil
+		},
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "my-token", Namespace: "tenant"}})
+	require.NoError(t, err)
+	assert.Empty(t, store.data)
+}
+
+func TestJoinTokenRequestReconciler_requestsForReferenceGrant(t *testing.T) {
+	c := newJoinTokenRequestTestClient(t,
+		&km.JoinTokenRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "cross-namespace", Namespace: "tenant"},
+			Spec:       km.JoinTokenRequestSpec{ClusterRef: km.ClusterRef{Name: "my-cluster", Namespace: "clusters"}},
+		},
+		&km.JoinTokenRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "same-namespace", Namespace: "clusters"},
+			Spec:       km.JoinTokenRequestSpec{ClusterRef: km.ClusterRef{Name: "my-cluster"}},
+		},
+		&km.JoinTokenRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-cluster", Namespace: "tenant"},
+			Spec:       km.JoinTokenRequestSpec{ClusterRef: km.ClusterRef{Name: "my-cluster", Namespace: "other"}},
+		},
+	)
+	r := &JoinTokenRequestReconciler{Client: c, Scheme: c.Scheme()}
+
+	grant := &km.ReferenceGrant{ObjectMeta: metav1.ObjectMeta{Name: "grant", Namespace: "clusters"}}
+	requests := r.requestsForReferenceGrant(context.Background(), grant)
+	require.Len(t, requests, 1)
+	assert.Equal(t, types.NamespacedName{Name: "cross-namespace", Namespace: "tenant"}, requests[0].NamespacedName)
+}
+
+func TestJoinTokenRequestReconciler_requestsForCluster(t *testing.T) {
+	c := newJoinTokenRequestTestClient(t,
+		&km.JoinTokenRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "cross-namespace", Namespace: "tenant"},
+			Spec:       km.JoinTokenRequestSpec{ClusterRef: km.ClusterRef{Name: "my-cluster", Namespace: "clusters"}},
+		},
+		&km.JoinTokenRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "same-namespace", Namespace: "clusters"},
+			Spec:       km.JoinTokenRequestSpec{ClusterRef: km.ClusterRef{Name: "my-cluster"}},
+		},
+		&km.JoinTokenRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-cluster", Namespace: "clusters"},
+			Spec:       km.JoinTokenRequestSpec{ClusterRef: km.ClusterRef{Name: "other-cluster"}},
+		},
+	)
+	r := &JoinTokenRequestReconciler{Client: c, Scheme: c.Scheme()}
+
+	cluster := &km.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "clusters"}}
+	requests := r.requestsForCluster(context.Background(), cluster)
+	names := []string{}
+	for _, req := range requests {
+		names = append(names, req.Namespace+"/"+req.Name)
+	}
+	assert.ElementsMatch(t, []string{"tenant/cross-namespace", "clusters/same-namespace"}, names)
+}
+
+func TestJoinTokenRequestReconciler_tokenSecretDeleted(t *testing.T) {
+	jtr := km.JoinTokenRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-token", Namespace: "tenant"},
+		Status:     km.JoinTokenRequestStatus{TokenID: "abc123"},
+	}
+	c := newJoinTokenRequestTestClient(t)
+	r := &JoinTokenRequestReconciler{Client: c, Scheme: c.Scheme()}
+
+	deleted, err := r.tokenSecretDeleted(context.Background(), jtr, nil)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	// The tokens in an external secret store are not checked
+	deleted, err = r.tokenSecretDeleted(context.Background(), jtr, &fakeSecretStore{})
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	require.NoError(t, c.Create(context.Background(), &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-token", Namespace: "tenant"}}))
+	deleted, err = r.tokenSecretDeleted(context.Background(), jtr, nil)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestJoinTokenRequestReconciler_rewriteTokenAddress(t *testing.T) {
+	kubeconfig := `apiVersion: v1
+kind: Config
+clusters:
+- name: k0s
+  cluster:
+    server: https://10.0.0.1:30443
+contexts:
+- name: k0s
+  context:
+    cluster: k0s
+    user: kubelet-bootstrap
+current-context: k0s
+users:
+- name: kubelet-bootstrap
+  user:
+    token: abcdef.0123456789abcdef
+`
+	token, err := tokenEncode([]byte(kubeconfig))
+	require.NoError(t, err)
+
+	jtr := km.JoinTokenRequest{ObjectMeta: metav1.ObjectMeta{Name: "my-token", Namespace: "default"}}
+	c := newJoinTokenRequestTestClient(t, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-token", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte(token)},
+	})
+	r := &JoinTokenRequestReconciler{Client: c, Scheme: c.Scheme()}
+	cluster := km.Cluster{Spec: km.ClusterSpec{
+		ExternalAddress: "10.0.0.1",
+		Service:         km.ServiceSpec{Type: v1.ServiceTypeNodePort, APIPort: 30443},
+	}}
+
+	// The token is up to date, so the secret is not rewritten
+	require.NoError(t, r.rewriteTokenAddress(context.Background(), jtr, cluster, nil))
+
+	// The address of the new service replaces the one k0s issued the token with
+	cluster.Spec.ExternalAddress = "lb.example.com"
+	cluster.Spec.Service = km.ServiceSpec{Type: v1.ServiceTypeLoadBalancer, APIPort: 6443}
+	newToken, _, err := ReplaceTokenPort(token, cluster)
+	require.NoError(t, err)
+	server, err := tokenServer(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, "https://lb.example.com:6443", server)
+}
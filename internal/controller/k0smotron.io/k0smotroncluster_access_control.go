@@ -0,0 +1,178 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/exec"
+)
+
+const accessControlManifestsPath = "/var/lib/k0s/manifests/k0smotron-access-control"
+
+// reconcileAccessControlCM creates the manifests of the initial access control of the cluster. The configmap is
+// mounted to the k0s manifests directory, so k0s applies the bindings as soon as the control plane is up.
+func (r *ClusterReconciler) reconcileAccessControlCM(ctx context.Context, kmc km.Cluster) error {
+	if len(kmc.Spec.AccessControl.ClusterRoleBindings) == 0 {
+		// The existing configmap is kept empty, so k0s removes the previously created bindings
+		exists, err := r.accessControlCMExists(ctx, &kmc)
+		if err != nil || !exists {
+			return err
+		}
+	}
+
+	cm, err := r.generateAccessControlCM(&kmc)
+	if err != nil {
+		return err
+	}
+
+	return kcutil.ApplyConfigMap(ctx, r.Client, &cm, patchOpts...)
+}
+
+func (r *ClusterReconciler) accessControlCMExists(ctx context.Context, kmc *km.Cluster) (bool, error) {
+	var cm v1.ConfigMap
+	err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetAccessControlConfigMapName(), Namespace: kmc.Namespace}, &cm)
+	if err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return true, nil
+}
+
+func (r *ClusterReconciler) generateAccessControlCM(kmc *km.Cluster) (v1.ConfigMap, error) {
+	var manifests []string
+	for _, binding := range kmc.Spec.AccessControl.ClusterRoleBindings {
+		crb := rbacv1.ClusterRoleBinding{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "rbac.authorization.k8s.io/v1",
+				Kind:       "ClusterRoleBinding",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: binding.Name,
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     binding.ClusterRole,
+			},
+			Subjects: binding.Subjects,
+		}
+		b, err := yaml.Marshal(crb)
+		if err != nil {
+			return v1.ConfigMap{}, fmt.Errorf("failed to marshal ClusterRoleBinding %s: %w", binding.Name, err)
+		}
+		manifests = append(manifests, string(b))
+	}
+
+	cm := v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        kmc.GetAccessControlConfigMapName(),
+			Namespace:   kmc.Namespace,
+			Labels:      labelsForCluster(kmc),
+			Annotations: annotationsForCluster(kmc),
+		},
+		Data: map[string]string{
+			"clusterrolebindings.yaml": strings.Join(manifests, "---\n"),
+		},
+	}
+
+	err := ctrl.SetControllerReference(kmc, &cm, r.Scheme)
+	return cm, err
+}
+
+// reconcileBreakGlassSecret issues the kubeconfig of the break-glass user. The client certificate is issued only once,
+// the secret must be deleted to issue a new one.
+func (r *ClusterReconciler) reconcileBreakGlassSecret(ctx context.Context, kmc km.Cluster) error {
+	logger := log.FromContext(ctx)
+	user := kmc.Spec.AccessControl.BreakGlassUser
+
+	var secret v1.Secret
+	err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetBreakGlassConfigSecretName(), Namespace: kmc.Namespace}, &secret)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	exists := err == nil
+
+	if user == nil {
+		if exists {
+			logger.Info("Break-glass user removed, deleting the kubeconfig secret")
+			return client.IgnoreNotFound(r.Client.Delete(ctx, &secret))
+		}
+		return nil
+	}
+	if exists {
+		return nil
+	}
+
+	pod, err := r.findStatefulSetPod(ctx, kmc.GetStatefulSetName(), kmc.Namespace)
+	if err != nil {
+		return err
+	}
+
+	cmd := fmt.Sprintf("k0s kubeconfig create %s", user.Name)
+	if len(user.Groups) > 0 {
+		cmd = fmt.Sprintf("%s --groups %s", cmd, strings.Join(user.Groups, ","))
+	}
+	output, err := exec.PodExecCmdOutput(ctx, r.ClientSet, r.RESTConfig, pod.Name, client.ObjectKeyFromObject(&kmc), cmd)
+	// The output is the kubeconfig of the user, so it is not audited
+	kcutil.AuditCommand(r.Recorder, &kmc, pod.Name, cmd, "", err)
+	if err != nil {
+		return err
+	}
+
+	output, _, err = replaceKubeconfigPort(output, kmc)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Break-glass kubeconfig generated, creating the secret")
+
+	secret = v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        kmc.GetBreakGlassConfigSecretName(),
+			Namespace:   kmc.Namespace,
+			Labels:      labelsForCluster(&kmc),
+			Annotations: annotationsForCluster(&kmc),
+		},
+		StringData: map[string]string{"value": output},
+	}
+
+	if err = ctrl.SetControllerReference(&kmc, &secret, r.Scheme); err != nil {
+		return err
+	}
+
+	return kcutil.ApplySecret(ctx, r.Client, &secret, patchOpts...)
+}
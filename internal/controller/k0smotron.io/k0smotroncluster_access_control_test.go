@@ -0,0 +1,98 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestGenerateAccessControlCM(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+	r := ClusterReconciler{Scheme: scheme}
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: km.ClusterSpec{
+			AccessControl: km.AccessControlSpec{
+				ClusterRoleBindings: []km.ClusterRoleBindingSpec{{
+					Name:        "oidc-admins",
+					ClusterRole: "cluster-admin",
+					Subjects:    []rbacv1.Subject{{Kind: "Group", Name: "oidc:admins"}},
+				}},
+			},
+		},
+	}
+
+	cm, err := r.generateAccessControlCM(kmc)
+	require.NoError(t, err)
+	assert.Equal(t, "kmc-test-access-control", cm.Name)
+	assert.Equal(t, `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  creationTimestamp: null
+  name: oidc-admins
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cluster-admin
+subjects:
+- kind: Group
+  name: oidc:admins
+`, cm.Data["clusterrolebindings.yaml"])
+}
+
+func TestReconcileAccessControlCM_removedBindings(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	kmc := km.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	r := ClusterReconciler{Client: c, Scheme: scheme}
+
+	// No bindings, no configmap is created
+	require.NoError(t, r.reconcileAccessControlCM(context.Background(), kmc))
+	exists, err := r.accessControlCMExists(context.Background(), &kmc)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Bindings removed, the existing configmap is kept mounted with no manifests
+	require.NoError(t, c.Create(context.Background(), &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: kmc.GetAccessControlConfigMapName(), Namespace: "default"},
+		Data:       map[string]string{"clusterrolebindings.yaml": "kind: ClusterRoleBinding"},
+	}))
+	exists, err = r.accessControlCMExists(context.Background(), &kmc)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	cm, err := r.generateAccessControlCM(&kmc)
+	require.NoError(t, err)
+	assert.Equal(t, "", cm.Data["clusterrolebindings.yaml"])
+}
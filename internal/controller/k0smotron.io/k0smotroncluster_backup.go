@@ -0,0 +1,281 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+const (
+	// etcdBackupSnapshotPath is the etcd snapshot taken on the etcd data volume before the volume is backed up.
+	etcdBackupSnapshotPath = "/var/lib/k0s/etcd/velero-snapshot.db"
+	// veleroHookTimeout is the time Velero waits for the backup hooks.
+	veleroHookTimeout = "2m"
+	// veleroBackupVolumesAnnotation lists the volumes of the pod backed up with the Velero file system backup.
+	veleroBackupVolumesAnnotation = "backup.velero.io/backup-volumes"
+)
+
+var veleroBackupListGVK = schema.GroupVersionKind{Group: "velero.io", Version: "v1", Kind: "BackupList"}
+
+// veleroBackupResources are the resources of the namespace needed to restore the control plane. The pods are
+// included so Velero runs their backup hooks and backs up their volumes.
+var veleroBackupResources = []string{
+	"clusters.k0smotron.io",
+	"statefulsets.apps",
+	"pods",
+	"persistentvolumeclaims",
+	"persistentvolumes",
+	"services",
+	"secrets",
+	"configmaps",
+}
+
+// addVeleroEtcdHooks annotates the etcd pods to take an etcd snapshot on the data volume before Velero backs the
+// volume up and to remove it afterwards, so the backup holds a consistent copy of the data even if the volume
+// is copied while etcd is writing to it.
+func addVeleroEtcdHooks(kmc *km.Cluster, template *v1.PodTemplateSpec) {
+	velero := kmc.Spec.Backup.Velero
+	if velero == nil {
+		return
+	}
+
+	preCommand, _ := json.Marshal([]string{"/bin/bash", "-c", fmt.Sprintf("etcdctl snapshot save %s", etcdBackupSnapshotPath)})
+	postCommand, _ := json.Marshal([]string{"/bin/bash", "-c", fmt.Sprintf("rm -f %s", etcdBackupSnapshotPath)})
+	annotations := map[string]string{
+		"pre.hook.backup.velero.io/container":  "etcd",
+		"pre.hook.backup.velero.io/command":    string(preCommand),
+		"pre.hook.backup.velero.io/on-error":   "Fail",
+		"pre.hook.backup.velero.io/timeout":    veleroHookTimeout,
+		"post.hook.backup.velero.io/container": "etcd",
+		"post.hook.backup.velero.io/command":   string(postCommand),
+		"post.hook.backup.velero.io/timeout":   veleroHookTimeout,
+	}
+	if velero.FSBackup {
+		annotations[veleroBackupVolumesAnnotation] = "etcd-data"
+	}
+	setPodAnnotations(template, annotations)
+}
+
+// addVeleroControllerVolumes opts the persistent volume of the controller pods in to the Velero file system backup.
+func addVeleroControllerVolumes(kmc *km.Cluster, template *v1.PodTemplateSpec) {
+	velero := kmc.Spec.Backup.Velero
+	if velero == nil || !velero.FSBackup || kmc.Spec.Persistence.Type != "pvc" || kmc.Spec.Persistence.PersistentVolumeClaim == nil {
+		return
+	}
+	setPodAnnotations(template, map[string]string{veleroBackupVolumesAnnotation: kmc.Spec.Persistence.PersistentVolumeClaim.Name})
+}
+
+func setPodAnnotations(template *v1.PodTemplateSpec, annotations map[string]string) {
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		template.Annotations[k] = v
+	}
+}
+
+// reconcileVeleroBackupCM generates the Velero Backup of the control plane into a configmap, so the resources needed
+// to restore the control plane don't have to be looked up. The configmap is deleted once Velero is disabled.
+func (r *ClusterReconciler) reconcileVeleroBackupCM(ctx context.Context, kmc km.Cluster) error {
+	if kmc.Spec.Backup.Velero == nil {
+		var cm v1.ConfigMap
+		err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetVeleroBackupConfigMapName(), Namespace: kmc.Namespace}, &cm)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		log.FromContext(ctx).Info("Velero backup disabled, deleting the Backup configmap")
+		return client.IgnoreNotFound(r.Client.Delete(ctx, &cm))
+	}
+
+	cm, err := r.generateVeleroBackupCM(&kmc)
+	if err != nil {
+		return err
+	}
+	return kcutil.ApplyConfigMap(ctx, r.Client, &cm, patchOpts...)
+}
+
+func (r *ClusterReconciler) generateVeleroBackupCM(kmc *km.Cluster) (v1.ConfigMap, error) {
+	velero := kmc.Spec.Backup.Velero
+	namespace := veleroNamespace(kmc)
+
+	backup := map[string]interface{}{
+		"apiVersion": "velero.io/v1",
+		"kind":       "Backup",
+		"metadata": map[string]interface{}{
+			"generateName": fmt.Sprintf("%s-%s-", kmc.Namespace, kmc.Name),
+			"namespace":    namespace,
+			"labels":       defaultClusterLabels(kmc),
+		},
+		"spec": map[string]interface{}{
+			"includedNamespaces": []string{kmc.Namespace},
+			"includedResources":  veleroBackupResources,
+			"snapshotVolumes":    !velero.FSBackup,
+		},
+	}
+	b, err := yaml.Marshal(backup)
+	if err != nil {
+		return v1.ConfigMap{}, fmt.Errorf("failed to marshal the Velero Backup: %w", err)
+	}
+
+	cm := v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        kmc.GetVeleroBackupConfigMapName(),
+			Namespace:   kmc.Namespace,
+			Labels:      labelsForCluster(kmc),
+			Annotations: annotationsForCluster(kmc),
+		},
+		Data: map[string]string{
+			"backup.yaml": string(b),
+		},
+	}
+
+	err = ctrl.SetControllerReference(kmc, &cm, r.Scheme)
+	return cm, err
+}
+
+// veleroNamespace returns the namespace of the Velero installation backing up the cluster.
+func veleroNamespace(kmc *km.Cluster) string {
+	if kmc.Spec.Backup.Velero.Namespace == "" {
+		return "velero"
+	}
+	return kmc.Spec.Backup.Velero.Namespace
+}
+
+// listVeleroBackups lists the Velero backups of the cluster. The backups are selected by the labels of the Backup
+// generated for the cluster, which the Velero Schedules created from it pass on to their backups.
+func (r *ClusterReconciler) listVeleroBackups(ctx context.Context, kmc *km.Cluster) ([]unstructured.Unstructured, error) {
+	backups := &unstructured.UnstructuredList{}
+	backups.SetGroupVersionKind(veleroBackupListGVK)
+	if err := r.Client.List(ctx, backups, client.InNamespace(veleroNamespace(kmc)), client.MatchingLabels(defaultClusterLabels(kmc))); err != nil {
+		return nil, fmt.Errorf("failed to list Velero backups: %w", err)
+	}
+	return backups.Items, nil
+}
+
+// veleroBackup is a finished Velero backup.
+type veleroBackup struct {
+	succeeded bool
+	message   string
+	finished  time.Time
+}
+
+// finishedVeleroBackups returns the finished Velero backups of the given namespace in the order they finished.
+// The partially failed backups count as failed, since the control plane may not be restorable from them.
+func finishedVeleroBackups(backups []unstructured.Unstructured, namespace string) []veleroBackup {
+	var finished []veleroBackup
+	for _, b := range backups {
+		namespaces, _, _ := unstructured.NestedStringSlice(b.Object, "spec", "includedNamespaces")
+		if !slices.Contains(namespaces, namespace) {
+			continue
+		}
+
+		var backup veleroBackup
+		phase, _, _ := unstructured.NestedString(b.Object, "status", "phase")
+		switch phase {
+		case "Completed":
+			backup.succeeded = true
+			backup.message = fmt.Sprintf("Velero backup %s/%s is completed", b.GetNamespace(), b.GetName())
+		case "PartiallyFailed", "Failed", "FailedValidation":
+			reason, _, _ := unstructured.NestedString(b.Object, "status", "failureReason")
+			backup.message = fmt.Sprintf("Velero backup %s/%s finished in phase %s", b.GetNamespace(), b.GetName(), phase)
+			if reason != "" {
+				backup.message += ": " + reason
+			}
+		default:
+			continue
+		}
+
+		// The backups failing the validation are not completed
+		backup.finished = b.GetCreationTimestamp().Time
+		if ts, _, _ := unstructured.NestedString(b.Object, "status", "completionTimestamp"); ts != "" {
+			if t, err := time.Parse(time.RFC3339, ts); err == nil {
+				backup.finished = t
+			}
+		}
+		finished = append(finished, backup)
+	}
+
+	sort.SliceStable(finished, func(i, j int) bool { return finished[i].finished.Before(finished[j].finished) })
+	return finished
+}
+
+// reconcileBackupStatus sets the time of the latest completed and failed Velero backups of the cluster and the
+// BackupSucceeded condition from the latest finished one. The times are kept once Velero deletes the expired backups.
+func (r *ClusterReconciler) reconcileBackupStatus(ctx context.Context, kmc *km.Cluster) {
+	if kmc.Spec.Backup.Velero == nil {
+		meta.RemoveStatusCondition(&kmc.Status.Conditions, km.BackupSucceededCondition)
+		kmc.Status.Backup = nil
+		return
+	}
+
+	backups, err := r.listVeleroBackups(ctx, kmc)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to check the backups of the cluster")
+		setCondition(kmc, km.BackupSucceededCondition, metav1.ConditionUnknown, km.WaitingForBackupReason, err.Error())
+		return
+	}
+
+	if kmc.Status.Backup == nil {
+		kmc.Status.Backup = &km.BackupStatus{}
+	}
+	status := kmc.Status.Backup
+	var lastFailure string
+	for _, b := range finishedVeleroBackups(backups, kmc.Namespace) {
+		finished := metav1.NewTime(b.finished)
+		switch {
+		case b.succeeded && (status.LastSuccessTime == nil || status.LastSuccessTime.Before(&finished)):
+			status.LastSuccessTime = &finished
+		case !b.succeeded && (status.LastFailureTime == nil || !finished.Before(status.LastFailureTime)):
+			status.LastFailureTime = &finished
+			lastFailure = b.message
+		}
+	}
+
+	switch {
+	case status.LastFailureTime != nil && (status.LastSuccessTime == nil || status.LastSuccessTime.Before(status.LastFailureTime)):
+		if lastFailure == "" {
+			lastFailure = fmt.Sprintf("The latest Velero backup failed at %s", status.LastFailureTime.UTC().Format(time.RFC3339))
+		}
+		setCondition(kmc, km.BackupSucceededCondition, metav1.ConditionFalse, km.BackupFailedReason, lastFailure)
+	case status.LastSuccessTime != nil:
+		setCondition(kmc, km.BackupSucceededCondition, metav1.ConditionTrue, km.AvailableReason, "")
+	default:
+		setCondition(kmc, km.BackupSucceededCondition, metav1.ConditionUnknown, km.WaitingForBackupReason, "No Velero backup of the cluster is finished yet")
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestVeleroEtcdHooks(t *testing.T) {
+	r := new(ClusterReconciler)
+
+	sts := r.generateEtcdStatefulSet(&km.Cluster{}, 1)
+	assert.NotContains(t, sts.Spec.Template.Annotations, "pre.hook.backup.velero.io/command")
+
+	kmc := &km.Cluster{Spec: km.ClusterSpec{Backup: km.BackupSpec{Velero: &km.VeleroBackupSpec{}}}}
+	sts = r.generateEtcdStatefulSet(kmc, 1)
+	annotations := sts.Spec.Template.Annotations
+	assert.Equal(t, "etcd", annotations["pre.hook.backup.velero.io/container"])
+	assert.Equal(t, `["/bin/bash","-c","etcdctl snapshot save /var/lib/k0s/etcd/velero-snapshot.db"]`, annotations["pre.hook.backup.velero.io/command"])
+	assert.Equal(t, `["/bin/bash","-c","rm -f /var/lib/k0s/etcd/velero-snapshot.db"]`, annotations["post.hook.backup.velero.io/command"])
+	assert.NotContains(t, annotations, veleroBackupVolumesAnnotation)
+
+	kmc.Spec.Backup.Velero.FSBackup = true
+	sts = r.generateEtcdStatefulSet(kmc, 1)
+	assert.Equal(t, "etcd-data", sts.Spec.Template.Annotations[veleroBackupVolumesAnnotation])
+}
+
+func TestVeleroControllerVolumes(t *testing.T) {
+	kmc := &km.Cluster{Spec: km.ClusterSpec{
+		Persistence: km.PersistenceSpec{Type: "pvc", PersistentVolumeClaim: &km.PersistentVolumeClaim{ObjectMeta: km.ObjectMeta{Name: "kmc-test"}}},
+		Backup:      km.BackupSpec{Velero: &km.VeleroBackupSpec{}},
+	}}
+
+	var template v1.PodTemplateSpec
+	addVeleroControllerVolumes(kmc, &template)
+	assert.Empty(t, template.Annotations)
+
+	kmc.Spec.Backup.Velero.FSBackup = true
+	addVeleroControllerVolumes(kmc, &template)
+	assert.Equal(t, "kmc-test", template.Annotations[veleroBackupVolumesAnnotation])
+}
+
+func TestGenerateVeleroBackupCM(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+	r := &ClusterReconciler{Scheme: scheme}
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant-a"},
+		Spec:       km.ClusterSpec{Backup: km.BackupSpec{Velero: &km.VeleroBackupSpec{Namespace: "backups"}}},
+	}
+	cm, err := r.generateVeleroBackupCM(kmc)
+	require.NoError(t, err)
+	assert.Equal(t, "kmc-test-velero-backup", cm.Name)
+
+	var backup map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(cm.Data["backup.yaml"]), &backup))
+	assert.Equal(t, "velero.io/v1", backup["apiVersion"])
+	assert.Equal(t, "Backup", backup["kind"])
+	metadata := backup["metadata"].(map[string]interface{})
+	assert.Equal(t, "backups", metadata["namespace"])
+	assert.Equal(t, "tenant-a-test-", metadata["generateName"])
+	spec := backup["spec"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"tenant-a"}, spec["includedNamespaces"])
+	assert.Contains(t, spec["includedResources"], "pods")
+	assert.Contains(t, spec["includedResources"], "clusters.k0smotron.io")
+	assert.Equal(t, true, spec["snapshotVolumes"])
+}
+
+func TestReconcileBackupStatus(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+	scheme.AddKnownTypeWithName(veleroBackupListGVK.GroupVersion().WithKind("Backup"), &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(veleroBackupListGVK, &unstructured.UnstructuredList{})
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec:       km.ClusterSpec{Backup: km.BackupSpec{Velero: &km.VeleroBackupSpec{}}},
+	}
+	backup := func(name, phase, completion string) *unstructured.Unstructured {
+		b := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec":   map[string]interface{}{"includedNamespaces": []interface{}{"default"}},
+			"status": map[string]interface{}{"phase": phase, "completionTimestamp": completion, "failureReason": "hook failed"},
+		}}
+		b.SetGroupVersionKind(veleroBackupListGVK.GroupVersion().WithKind("Backup"))
+		b.SetName(name)
+		b.SetNamespace("velero")
+		b.SetLabels(defaultClusterLabels(kmc))
+		return b
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	r := &ClusterReconciler{Client: c, Scheme: scheme}
+	ctx := context.Background()
+
+	r.reconcileBackupStatus(ctx, kmc)
+	require.NotNil(t, kmc.Status.Backup)
+	assert.Nil(t, kmc.Status.Backup.LastSuccessTime)
+	condition := meta.FindStatusCondition(kmc.Status.Conditions, km.BackupSucceededCondition)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionUnknown, condition.Status)
+	assert.Equal(t, km.WaitingForBackupReason, condition.Reason)
+
+	completed := backup("completed", "Completed", "2023-01-01T12:00:00Z")
+	require.NoError(t, c.Create(ctx, completed))
+	require.NoError(t, c.Create(ctx, backup("running", "InProgress", "")))
+	r.reconcileBackupStatus(ctx, kmc)
+	assert.Equal(t, time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC), kmc.Status.Backup.LastSuccessTime.UTC())
+	assert.Nil(t, kmc.Status.Backup.LastFailureTime)
+	assert.True(t, meta.IsStatusConditionTrue(kmc.Status.Conditions, km.BackupSucceededCondition))
+
+	require.NoError(t, c.Create(ctx, backup("failed", "PartiallyFailed", "2023-01-01T13:00:00Z")))
+	r.reconcileBackupStatus(ctx, kmc)
+	assert.Equal(t, time.Date(2023, 1, 1, 13, 0, 0, 0, time.UTC), kmc.Status.Backup.LastFailureTime.UTC())
+	condition = meta.FindStatusCondition(kmc.Status.Conditions, km.BackupSucceededCondition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, km.BackupFailedReason, condition.Reason)
+	assert.Equal(t, "Velero backup velero/failed finished in phase PartiallyFailed: hook failed", condition.Message)
+
+	// The time of the latest success is kept once Velero deletes the expired backup
+	require.NoError(t, c.Delete(ctx, completed))
+	require.NoError(t, c.Create(ctx, backup("recovered", "Completed", "2023-01-01T14:00:00Z")))
+	r.reconcileBackupStatus(ctx, kmc)
+	assert.Equal(t, time.Date(2023, 1, 1, 14, 0, 0, 0, time.UTC), kmc.Status.Backup.LastSuccessTime.UTC())
+	assert.Equal(t, time.Date(2023, 1, 1, 13, 0, 0, 0, time.UTC), kmc.Status.Backup.LastFailureTime.UTC())
+	assert.True(t, meta.IsStatusConditionTrue(kmc.Status.Conditions, km.BackupSucceededCondition))
+
+	kmc.Spec.Backup.Velero = nil
+	r.reconcileBackupStatus(ctx, kmc)
+	assert.Nil(t, kmc.Status.Backup)
+	assert.Nil(t, meta.FindStatusCondition(kmc.Status.Conditions, km.BackupSucceededCondition))
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/exec"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
+)
+
+// reconcileComponentHealth checks the health of the k0s components on all the running controller pods of the
+// cluster and reports it in the status. A failed check is reported as unhealthy k0s on the pod.
+func (r *ClusterReconciler) reconcileComponentHealth(ctx context.Context, kmc *km.Cluster) error {
+	logger := log.FromContext(ctx)
+
+	pods, err := util.FindStatefulSetPods(ctx, r.ClientSet, kmc.GetStatefulSetName(), kmc.Namespace)
+	if err != nil {
+		return err
+	}
+
+	controllers := map[string][]km.ComponentHealth{}
+	for _, pod := range pods {
+		output, err := exec.PodExecCmdOutput(ctx, r.ClientSet, r.RESTConfig, pod.Name, client.ObjectKeyFromObject(kmc), kutil.ComponentHealthScript)
+		if err != nil {
+			logger.Info("Failed to check the component health", "pod", pod.Name, "error", err.Error())
+			controllers[pod.Name] = []km.ComponentHealth{{Name: "k0s", Message: err.Error()}}
+			continue
+		}
+		components, err := kutil.ParseComponentHealth(output)
+		if err != nil {
+			controllers[pod.Name] = []km.ComponentHealth{{Name: "k0s", Message: err.Error()}}
+			continue
+		}
+		controllers[pod.Name] = components
+	}
+	kmc.Status.Components = kutil.MergeComponentHealth(controllers)
+
+	return nil
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// endpointProbeTimeout is the time to wait for the API server and the konnectivity server to respond.
+const endpointProbeTimeout = 5 * time.Second
+
+// readyConditionTypes are the conditions aggregated to the Ready condition, in the order they are checked.
+var readyConditionTypes = []string{
+	km.ServiceReadyCondition,
+	km.StatefulSetReadyCondition,
+	km.EtcdHealthyCondition,
+	km.APIReachableCondition,
+	km.KonnectivityReadyCondition,
+	km.KubeconfigReadyCondition,
+}
+
+// setConditions observes the resources of the cluster, sets the matching conditions and aggregates them
+// to the Ready condition.
+func (r *ClusterReconciler) setConditions(ctx context.Context, kmc *km.Cluster) {
+	svc := r.setServiceCondition(ctx, kmc)
+	r.setStatefulSetCondition(ctx, kmc)
+	r.setEtcdCondition(ctx, kmc)
+	r.setEndpointConditions(ctx, kmc, svc)
+	if meta.FindStatusCondition(kmc.Status.Conditions, km.KubeconfigReadyCondition) == nil {
+		setCondition(kmc, km.KubeconfigReadyCondition, metav1.ConditionUnknown, km.KubeconfigNotReadyReason, "The admin kubeconfig is not generated yet")
+	}
+	setReadyCondition(kmc)
+	kmc.Status.ObservedGeneration = kmc.Generation
+}
+
+// setServiceCondition sets the ServiceReady condition and returns the service if it's ready.
+func (r *ClusterReconciler) setServiceCondition(ctx context.Context, kmc *km.Cluster) *v1.Service {
+	var svc v1.Service
+	err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetServiceName(), Namespace: kmc.Namespace}, &svc)
+	switch {
+	case apierrors.IsNotFound(err):
+		setCondition(kmc, km.ServiceReadyCondition, metav1.ConditionFalse, km.ServiceNotReadyReason, fmt.Sprintf("Service %s does not exist", kmc.GetServiceName()))
+		return nil
+	case err != nil:
+		setCondition(kmc, km.ServiceReadyCondition, metav1.ConditionUnknown, km.ServiceNotReadyReason, err.Error())
+		return nil
+	case svc.Spec.Type == v1.ServiceTypeLoadBalancer && len(svc.Status.LoadBalancer.Ingress) == 0:
+		setCondition(kmc, km.ServiceReadyCondition, metav1.ConditionFalse, km.ServiceNotReadyReason, "Waiting for the load balancer address")
+		return nil
+	}
+	setCondition(kmc, km.ServiceReadyCondition, metav1.ConditionTrue, km.AvailableReason, "")
+	return &svc
+}
+
+func (r *ClusterReconciler) setStatefulSetCondition(ctx context.Context, kmc *km.Cluster) {
+	var sts apps.StatefulSet
+	err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetStatefulSetName(), Namespace: kmc.Namespace}, &sts)
+	switch {
+	case apierrors.IsNotFound(err):
+		setCondition(kmc, km.StatefulSetReadyCondition, metav1.ConditionFalse, km.StatefulSetNotReadyReason, fmt.Sprintf("StatefulSet %s does not exist", kmc.GetStatefulSetName()))
+	case err != nil:
+		setCondition(kmc, km.StatefulSetReadyCondition, metav1.ConditionUnknown, km.StatefulSetNotReadyReason, err.Error())
+	case sts.Status.ObservedGeneration < sts.Generation:
+		setCondition(kmc, km.StatefulSetReadyCondition, metav1.ConditionFalse, km.StatefulSetNotReadyReason, "Waiting for the StatefulSet update to be observed")
+	case sts.Status.UpdatedReplicas != kmc.Spec.Replicas || sts.Status.ReadyReplicas != kmc.Spec.Replicas:
+		setCondition(kmc, km.StatefulSetReadyCondition, metav1.ConditionFalse, km.StatefulSetNotReadyReason,
+			fmt.Sprintf("%d of %d controller pods are updated and %d are ready", sts.Status.UpdatedReplicas, kmc.Spec.Replicas, sts.Status.ReadyReplicas))
+	default:
+		setCondition(kmc, km.StatefulSetReadyCondition, metav1.ConditionTrue, km.AvailableReason, "")
+	}
+}
+
+func (r *ClusterReconciler) setEtcdCondition(ctx context.Context, kmc *km.Cluster) {
+	if kmc.Spec.KineDataSourceURL != "" || kmc.Spec.Etcd.External != nil {
+		meta.RemoveStatusCondition(&kmc.Status.Conditions, km.EtcdHealthyCondition)
+		return
+	}
+
+	var sts apps.StatefulSet
+	err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetEtcdStatefulSetName(), Namespace: kmc.Namespace}, &sts)
+	switch {
+	case apierrors.IsNotFound(err):
+		setCondition(kmc, km.EtcdHealthyCondition, metav1.ConditionFalse, km.EtcdNotHealthyReason, fmt.Sprintf("StatefulSet %s does not exist", kmc.GetEtcdStatefulSetName()))
+	case err != nil:
+		setCondition(kmc, km.EtcdHealthyCondition, metav1.ConditionUnknown, km.EtcdNotHealthyReason, err.Error())
+	case sts.Spec.Replicas == nil || sts.Status.ReadyReplicas != *sts.Spec.Replicas:
+		var replicas int32
+		if sts.Spec.Replicas != nil {
+			replicas = *sts.Spec.Replicas
+		}
+		setCondition(kmc, km.EtcdHealthyCondition, metav1.ConditionFalse, km.EtcdNotHealthyReason,
+			fmt.Sprintf("%d of %d etcd pods are ready", sts.Status.ReadyReplicas, replicas))
+	default:
+		setCondition(kmc, km.EtcdHealthyCondition, metav1.ConditionTrue, km.AvailableReason, "")
+	}
+}
+
+// setEndpointConditions probes the API server and the konnectivity server through the service of the cluster.
+// The endpoints are not probed until the controller pods are ready.
+func (r *ClusterReconciler) setEndpointConditions(ctx context.Context, kmc *km.Cluster, svc *v1.Service) {
+	if svc == nil || !meta.IsStatusConditionTrue(kmc.Status.Conditions, km.StatefulSetReadyCondition) {
+		setCondition(kmc, km.APIReachableCondition, metav1.ConditionUnknown, km.WaitingForStatefulSetReason, "Waiting for the controller pods to be ready")
+		setCondition(kmc, km.KonnectivityReadyCondition, metav1.ConditionUnknown, km.WaitingForStatefulSetReason, "Waiting for the controller pods to be ready")
+		return
+	}
+
+	apiProber := r.apiProber
+	if apiProber == nil {
+		apiProber = r.probeAPI
+	}
+	if err := apiProber(ctx, kmc, serviceAddress(svc, "api")); err != nil {
+		setCondition(kmc, km.APIReachableCondition, metav1.ConditionFalse, km.APIUnreachableReason, err.Error())
+	} else {
+		setCondition(kmc, km.APIReachableCondition, metav1.ConditionTrue, km.AvailableReason, "")
+	}
+
+	konnectivityProber := r.konnectivityProber
+	if konnectivityProber == nil {
+		konnectivityProber = probeTCP
+	}
+	if err := konnectivityProber(ctx, serviceAddress(svc, "konnectivity")); err != nil {
+		setCondition(kmc, km.KonnectivityReadyCondition, metav1.ConditionFalse, km.KonnectivityUnreachableReason, err.Error())
+	} else {
+		setCondition(kmc, km.KonnectivityReadyCondition, metav1.ConditionTrue, km.AvailableReason, "")
+	}
+}
+
+// probeAPI checks the readiness of the API server, trusting the cluster CA.
+func (r *ClusterReconciler) probeAPI(ctx context.Context, kmc *km.Cluster, address string) error {
+	var ca v1.Secret
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: secret.Name(kmc.Name, secret.ClusterCA), Namespace: kmc.Namespace}, &ca); err != nil {
+		return fmt.Errorf("failed to get the cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca.Data[secret.TLSCrtDataName]) {
+		return fmt.Errorf("the cluster CA secret has no valid certificate")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, endpointProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+address+"/readyz", nil)
+	if err != nil {
+		return err
+	}
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the API server is not ready: %s", resp.Status)
+	}
+	return nil
+}
+
+// probeTCP checks that a connection can be opened to the address.
+func probeTCP(ctx context.Context, address string) error {
+	dialer := net.Dialer{Timeout: endpointProbeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// serviceAddress returns the in-cluster address of the named port of the service.
+func serviceAddress(svc *v1.Service, portName string) string {
+	var port int32
+	for _, p := range svc.Spec.Ports {
+		if p.Name == portName {
+			port = p.Port
+		}
+	}
+	return net.JoinHostPort(fmt.Sprintf("%s.%s.svc", svc.Name, svc.Namespace), fmt.Sprint(port))
+}
+
+// setReadyCondition sets the Ready condition to true if all the other conditions are true. Otherwise, the
+// reason and the message of the first condition that is not true are used.
+func setReadyCondition(kmc *km.Cluster) {
+	for _, t := range readyConditionTypes {
+		c := meta.FindStatusCondition(kmc.Status.Conditions, t)
+		if c == nil || c.Status == metav1.ConditionTrue {
+			continue
+		}
+		setCondition(kmc, km.ReadyCondition, metav1.ConditionFalse, c.Reason, fmt.Sprintf("%s: %s", c.Type, c.Message))
+		return
+	}
+	setCondition(kmc, km.ReadyCondition, metav1.ConditionTrue, km.AvailableReason, "")
+}
+
+func setCondition(kmc *km.Cluster, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&kmc.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: kmc.Generation,
+	})
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestSetConditions(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Generation: 2},
+		Spec: km.ClusterSpec{
+			Replicas: 1,
+			Service:  km.ServiceSpec{Type: v1.ServiceTypeClusterIP},
+		},
+	}
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "kmc-test", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeClusterIP,
+			Ports: []v1.ServicePort{{Name: "api", Port: 30443}, {Name: "konnectivity", Port: 30132}},
+		},
+	}
+	sts := &apps.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kmc-test", Namespace: "default"},
+		Status:     apps.StatefulSetStatus{ReadyReplicas: 1, UpdatedReplicas: 1},
+	}
+	etcd := &apps.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kmc-test-etcd", Namespace: "default"},
+		Spec:       apps.StatefulSetSpec{Replicas: ptr.To(int32(1))},
+		Status:     apps.StatefulSetStatus{ReadyReplicas: 0},
+	}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(svc, sts, etcd).Build()
+
+	var probedAPI, probedKonnectivity string
+	r := &ClusterReconciler{
+		Client: c,
+		Scheme: scheme,
+		apiProber: func(_ context.Context, _ *km.Cluster, address string) error {
+			probedAPI = address
+			return nil
+		},
+		konnectivityProber: func(_ context.Context, address string) error {
+			probedKonnectivity = address
+			return errors.New("connection refused")
+		},
+	}
+
+	r.setConditions(context.Background(), kmc)
+	assert.Equal(t, "kmc-test.default.svc:30443", probedAPI)
+	assert.Equal(t, "kmc-test.default.svc:30132", probedKonnectivity)
+	assert.Equal(t, int64(2), kmc.Status.ObservedGeneration)
+	assert.True(t, meta.IsStatusConditionTrue(kmc.Status.Conditions, km.ServiceReadyCondition))
+	assert.True(t, meta.IsStatusConditionTrue(kmc.Status.Conditions, km.StatefulSetReadyCondition))
+	assert.True(t, meta.IsStatusConditionTrue(kmc.Status.Conditions, km.APIReachableCondition))
+	assert.True(t, meta.IsStatusConditionFalse(kmc.Status.Conditions, km.KonnectivityReadyCondition))
+
+	// The first condition that is not true is reported in the Ready condition
+	ready := meta.FindStatusCondition(kmc.Status.Conditions, km.ReadyCondition)
+	require.NotNil(t, ready)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, km.EtcdNotHealthyReason, ready.Reason)
+	assert.Equal(t, "EtcdHealthy: 0 of 1 etcd pods are ready", ready.Message)
+	assert.Equal(t, int64(2), ready.ObservedGeneration)
+
+	// The etcd condition is not set for the clusters using kine
+	kmc.Spec.KineDataSourceURL = "postgres://db"
+	r.konnectivityProber = func(_ context.Context, _ string) error { return nil }
+	setCondition(kmc, km.KubeconfigReadyCondition, metav1.ConditionTrue, km.AvailableReason, "")
+	r.setConditions(context.Background(), kmc)
+	assert.Nil(t, meta.FindStatusCondition(kmc.Status.Conditions, km.EtcdHealthyCondition))
+	assert.True(t, meta.IsStatusConditionTrue(kmc.Status.Conditions, km.ReadyCondition))
+
+	// Nor for the clusters using an external etcd
+	kmc.Spec.KineDataSourceURL = ""
+	kmc.Spec.Etcd.External = &km.ExternalEtcdSpec{Endpoints: []string{"https://etcd:2379"}}
+	r.setConditions(context.Background(), kmc)
+	assert.Nil(t, meta.FindStatusCondition(kmc.Status.Conditions, km.EtcdHealthyCondition))
+	assert.True(t, meta.IsStatusConditionTrue(kmc.Status.Conditions, km.ReadyCondition))
+}
+
+func TestSetConditions_notReady(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: km.ClusterSpec{
+			Replicas:          1,
+			KineDataSourceURL: "postgres://db",
+			Service:           km.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		},
+	}
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "kmc-test-lb", Namespace: "default"},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(svc).Build()
+	r := &ClusterReconciler{
+		Client: c,
+		apiProber: func(_ context.Context, _ *km.Cluster, _ string) error {
+			t.Fatal("the API must not be probed before the controller pods are ready")
+			return nil
+		},
+	}
+
+	r.setConditions(context.Background(), kmc)
+	ready := meta.FindStatusCondition(kmc.Status.Conditions, km.ReadyCondition)
+	require.NotNil(t, ready)
+	assert.Equal(t, km.ServiceNotReadyReason, ready.Reason)
+	assert.Equal(t, "ServiceReady: Waiting for the load balancer address", ready.Message)
+	assert.True(t, meta.IsStatusConditionFalse(kmc.Status.Conditions, km.StatefulSetReadyCondition))
+	api := meta.FindStatusCondition(kmc.Status.Conditions, km.APIReachableCondition)
+	require.NotNil(t, api)
+	assert.Equal(t, metav1.ConditionUnknown, api.Status)
+	kubeconfig := meta.FindStatusCondition(kmc.Status.Conditions, km.KubeconfigReadyCondition)
+	require.NotNil(t, kubeconfig)
+	assert.Equal(t, km.KubeconfigNotReadyReason, kubeconfig.Reason)
+}
@@ -20,22 +20,31 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 
 	"github.com/imdario/mergo"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/yaml"
 
 	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/controller/util"
 	"github.com/k0sproject/k0smotron/internal/util"
 )
 
-const kineDataSourceURLPlaceholder = "__K0SMOTRON_KINE_DATASOURCE_URL_PLACEHOLDER__"
+const (
+	kineDataSourceURLPlaceholder = "__K0SMOTRON_KINE_DATASOURCE_URL_PLACEHOLDER__"
+
+	// clusterK0sConfigRefField is the field index of the ConfigMap or the Secret referenced by spec.k0sConfigRef.
+	clusterK0sConfigRefField = "spec.k0sConfigRef"
+)
 
 // generateConfig merges provided config with k0smotron generated values and generates the k0s config and configmap
 // We use plain map[string]interface{} for the following reasons:
@@ -72,6 +81,26 @@ func (r *ClusterReconciler) generateConfig(kmc *km.Cluster, sans []string) (v1.C
 		return v1.ConfigMap{}, nil, err
 	}
 
+	err = util.SetWorkerProfiles(unstructuredConfig, kmc.Spec.WorkerProfiles)
+	if err != nil {
+		return v1.ConfigMap{}, nil, err
+	}
+
+	err = util.SetHelmExtensions(unstructuredConfig, kmc.Spec.Extensions.Helm)
+	if err != nil {
+		return v1.ConfigMap{}, nil, err
+	}
+
+	err = util.SetCNI(unstructuredConfig, kmc.Spec.CNI, kmc.Spec.ExternalAddress, kmc.Spec.Service.APIPort)
+	if err != nil {
+		return v1.ConfigMap{}, nil, err
+	}
+
+	err = setHubAgentCharts(unstructuredConfig, kmc)
+	if err != nil {
+		return v1.ConfigMap{}, nil, err
+	}
+
 	b, err := yaml.Marshal(unstructuredConfig)
 	if err != nil {
 		return v1.ConfigMap{}, nil, err
@@ -113,8 +142,8 @@ func (r *ClusterReconciler) reconcileK0sConfig(ctx context.Context, kmc *km.Clus
 		kmc.Spec.ExternalAddress = externalAddress
 	}
 
-	if kmc.Spec.KineDataSourceSecretName != "" {
-		kmc.Spec.KineDataSourceURL = kineDataSourceURLPlaceholder
+	if err := r.resolveK0sConfig(ctx, kmc); err != nil {
+		return err
 	}
 
 	sans, err := r.genSANs(kmc)
@@ -133,7 +162,23 @@ func (r *ClusterReconciler) reconcileK0sConfig(ctx context.Context, kmc *km.Clus
 		logger.Error(err, "failed to reconcile dynamic config, kubeconfig may not be available yet")
 	}
 
-	return r.Client.Patch(ctx, &cm, client.Apply, patchOpts...)
+	return kutil.ApplyConfigMap(ctx, r.Client, &cm, patchOpts...)
+}
+
+// resolveK0sConfig sets the k0s config of the cluster merged over the referenced one, and the placeholder of the kine
+// datasource URL read from a secret by the controller pods.
+func (r *ClusterReconciler) resolveK0sConfig(ctx context.Context, kmc *km.Cluster) error {
+	if kmc.Spec.KineDataSourceSecretName != "" {
+		kmc.Spec.KineDataSourceURL = kineDataSourceURLPlaceholder
+	}
+
+	// The referenced config is the base of the inline one, so a change of either regenerates the config
+	k0sConfig, err := util.ResolveK0sConfig(ctx, r.Client, kmc.Namespace, kmc.Spec.K0sConfigRef, kmc.Spec.K0sConfig)
+	if err != nil {
+		return fmt.Errorf("failed to resolve k0sConfigRef: %w", err)
+	}
+	kmc.Spec.K0sConfig = k0sConfig
+	return nil
 }
 
 func (r *ClusterReconciler) reconcileDynamicConfig(ctx context.Context, kmc *km.Cluster, k0sConfig map[string]interface{}) error {
@@ -182,16 +227,8 @@ func (r *ClusterReconciler) detectExternalAddress(ctx context.Context) (string,
 }
 
 func (r *ClusterReconciler) genSANs(kmc *km.Cluster) ([]string, error) {
-	var sans []string
-	if kmc.Spec.ExternalAddress != "" {
-		sans = append(sans, kmc.Spec.ExternalAddress)
-	}
-	svcName := kmc.GetServiceName()
-	svcNamespacedName := fmt.Sprintf("%s.%s", svcName, kmc.Namespace)
-
-	sans = append(sans, svcName)
-	sans = append(sans, svcNamespacedName)
-	sans = append(sans, fmt.Sprintf("%s.svc", svcNamespacedName))
+	sans := clusterSANs(kmc)
+	svcNamespacedName := fmt.Sprintf("%s.%s", kmc.GetServiceName(), kmc.Namespace)
 
 	ips, err := net.LookupHost(svcNamespacedName)
 	if err != nil {
@@ -210,6 +247,24 @@ func (r *ClusterReconciler) genSANs(kmc *km.Cluster) ([]string, error) {
 	return sans, nil
 }
 
+// clusterSANs returns the SANs of the external address, the DNS record and the names of the service of the cluster.
+func clusterSANs(kmc *km.Cluster) []string {
+	var sans []string
+	if kmc.Spec.ExternalAddress != "" {
+		sans = append(sans, kmc.Spec.ExternalAddress)
+	}
+	if kmc.Spec.DNS != nil && kmc.Spec.DNS.Hostname != kmc.Spec.ExternalAddress {
+		sans = append(sans, kmc.Spec.DNS.Hostname)
+	}
+	svcName := kmc.GetServiceName()
+	svcNamespacedName := fmt.Sprintf("%s.%s", svcName, kmc.Namespace)
+
+	sans = append(sans, svcName)
+	sans = append(sans, svcNamespacedName)
+	sans = append(sans, fmt.Sprintf("%s.svc", svcNamespacedName))
+	return sans
+}
+
 func getV1Beta1Spec(kmc *km.Cluster, sans []string) map[string]interface{} {
 	v1beta1Spec := map[string]interface{}{
 		"api": map[string]interface{}{
@@ -221,6 +276,13 @@ func getV1Beta1Spec(kmc *km.Cluster, sans []string) map[string]interface{} {
 			"agentPort": kmc.Spec.Service.KonnectivityPort,
 		},
 	}
+	extraArgs := webhookAPIServerArgs(kmc)
+	if kmc.Spec.Certificates.CertManager != nil {
+		extraArgs["tls-sni-cert-key"] = apiServingCertSNIArg()
+	}
+	if len(extraArgs) > 0 {
+		v1beta1Spec["api"].(map[string]interface{})["extraArgs"] = extraArgs
+	}
 	if kmc.Spec.KineDataSourceURL != "" {
 		v1beta1Spec["storage"] = map[string]interface{}{
 			"type": "kine",
@@ -228,6 +290,23 @@ func getV1Beta1Spec(kmc *km.Cluster, sans []string) map[string]interface{} {
 				"dataSource": kmc.Spec.KineDataSourceURL,
 			},
 		}
+	} else if external := kmc.Spec.Etcd.External; external != nil {
+		etcdPrefix := external.EtcdPrefix
+		if etcdPrefix == "" {
+			etcdPrefix = fmt.Sprintf("%s-%s", kmc.Namespace, kmc.Name)
+		}
+		v1beta1Spec["storage"] = map[string]interface{}{
+			"type": "etcd",
+			"etcd": map[string]interface{}{
+				"externalCluster": map[string]interface{}{
+					"endpoints":      external.Endpoints,
+					"etcdPrefix":     etcdPrefix,
+					"caFile":         "/var/lib/k0s/pki/external-etcd-ca.crt",
+					"clientCertFile": "/var/lib/k0s/pki/external-etcd-client.crt",
+					"clientKeyFile":  "/var/lib/k0s/pki/external-etcd-client.key",
+				},
+			},
+		}
 	} else {
 		v1beta1Spec["storage"] = map[string]interface{}{
 			"type": "etcd",
@@ -244,3 +323,48 @@ func getV1Beta1Spec(kmc *km.Cluster, sans []string) map[string]interface{} {
 	}
 	return v1beta1Spec
 }
+
+// setHubAgentCharts adds the Helm charts of the agents of the hub registrations to the k0s config, so the agents are
+// deployed by k0s with the cluster.
+func setHubAgentCharts(k0sConfig map[string]interface{}, kmc *km.Cluster) error {
+	for _, reg := range kmc.Spec.HubRegistrations {
+		registrar, ok := hubRegistrars[reg.Type].(hubAgentChart)
+		if !ok {
+			continue
+		}
+		repo, chart, err := registrar.agentChart(kmc, reg)
+		if err != nil {
+			return err
+		}
+		if err := util.AddHelmChart(k0sConfig, repo, chart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestsForK0sConfigRef maps the ConfigMap or the Secret holding a k0s config to the clusters referencing it.
+func (r *ClusterReconciler) requestsForK0sConfigRef(ctx context.Context, obj client.Object) []reconcile.Request {
+	var clusters km.ClusterList
+	key := util.K0sConfigRefIndexKey(obj, obj.GetName())
+	if err := r.Client.List(ctx, &clusters, client.InNamespace(obj.GetNamespace()), client.MatchingFields{clusterK0sConfigRefField: key}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list clusters")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, kmc := range clusters.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: kmc.Name, Namespace: kmc.Namespace}})
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Name < requests[j].Name })
+	return requests
+}
+
+// indexClusterK0sConfigRef indexes the clusters by the ConfigMap or the Secret holding their referenced k0s config.
+func indexClusterK0sConfigRef(obj client.Object) []string {
+	kmc, ok := obj.(*km.Cluster)
+	if !ok {
+		return nil
+	}
+	return util.K0sConfigRefIndexKeys(kmc.Spec.K0sConfigRef)
+}
@@ -17,14 +17,19 @@ limitations under the License.
 package k0smotronio
 
 import (
+	"context"
 	"strings"
 	"testing"
 
 	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestGenerateCM(t *testing.T) {
@@ -84,4 +89,156 @@ func TestGenerateCM(t *testing.T) {
 		assert.True(t, strings.Contains(conf, "my.san.address"))
 		assert.True(t, strings.Contains(conf, "my.san.address2"))
 	})
+	t.Run("cert-manager serving certificate", func(t *testing.T) {
+		kmc := km.Cluster{
+			Spec: km.ClusterSpec{
+				ExternalAddress: "my.external.address",
+				Certificates: km.CertificatesSpec{
+					CertManager: &km.CertManagerSpec{
+						IssuerRef: km.CertManagerIssuerRef{Name: "my-issuer"},
+					},
+				},
+			},
+		}
+
+		cm, _, err := r.generateConfig(&kmc, []string{})
+		require.NoError(t, err)
+
+		conf := cm.Data["K0SMOTRON_K0S_YAML"]
+
+		assert.True(t, strings.Contains(conf, "tls-sni-cert-key: "+apiServingCertSNIArg()))
+	})
+	t.Run("worker profiles", func(t *testing.T) {
+		kmc := km.Cluster{
+			Spec: km.ClusterSpec{
+				ExternalAddress: "my.external.address",
+				WorkerProfiles: []km.WorkerProfile{
+					{Name: "custom", Values: runtime.RawExtension{Raw: []byte(`{"maxPods":200}`)}},
+				},
+			},
+		}
+
+		_, k0sConfig, err := r.generateConfig(&kmc, []string{})
+		require.NoError(t, err)
+
+		workerProfiles, _, err := unstructured.NestedSlice(k0sConfig, "spec", "workerProfiles")
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"name": "custom", "values": map[string]interface{}{"maxPods": float64(200)}},
+		}, workerProfiles)
+	})
+	t.Run("helm extensions", func(t *testing.T) {
+		kmc := km.Cluster{
+			Spec: km.ClusterSpec{
+				ExternalAddress: "my.external.address",
+				Extensions: km.ExtensionsSpec{
+					Helm: &km.HelmExtensions{
+						Repositories: []km.HelmRepository{{Name: "cilium", URL: "https://helm.cilium.io/"}},
+						Charts: []km.HelmChart{{
+							Name:      "cilium",
+							ChartName: "cilium/cilium",
+							Namespace: "kube-system",
+							Values:    &runtime.RawExtension{Raw: []byte(`{"operator":{"replicas":1}}`)},
+						}},
+					},
+				},
+			},
+		}
+
+		_, k0sConfig, err := r.generateConfig(&kmc, []string{})
+		require.NoError(t, err)
+
+		charts, _, err := unstructured.NestedSlice(k0sConfig, "spec", "extensions", "helm", "charts")
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"name": "cilium", "chartname": "cilium/cilium", "namespace": "kube-system", "values": "operator:\n  replicas: 1\n"},
+		}, charts)
+	})
+	t.Run("cni preset", func(t *testing.T) {
+		kmc := km.Cluster{
+			Spec: km.ClusterSpec{
+				ExternalAddress: "my.external.address",
+				Service:         km.ServiceSpec{Type: v1.ServiceTypeNodePort, APIPort: 30443},
+				K0sConfig: &unstructured.Unstructured{Object: map[string]interface{}{
+					"apiVersion": "k0s.k0sproject.io/v1beta1",
+					"kind":       "ClusterConfig",
+					"spec": map[string]interface{}{
+						"network": map[string]interface{}{
+							"provider": "calico",
+						},
+					},
+				}},
+				CNI: &km.CNISpec{Preset: "cilium", Cilium: &km.CiliumCNISpec{KubeProxyReplacement: true}},
+			},
+		}
+
+		cm, _, err := r.generateConfig(&kmc, []string{})
+		require.NoError(t, err)
+
+		conf := cm.Data["K0SMOTRON_K0S_YAML"]
+
+		assert.True(t, strings.Contains(conf, "provider: custom"), "The preset must override the provider")
+		assert.True(t, strings.Contains(conf, "chartname: cilium/cilium"))
+		assert.True(t, strings.Contains(conf, "k8sServiceHost: my.external.address"))
+	})
+	t.Run("external etcd", func(t *testing.T) {
+		kmc := km.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec: km.ClusterSpec{
+				ExternalAddress: "my.external.address",
+				Etcd: km.EtcdSpec{External: &km.ExternalEtcdSpec{
+					Endpoints:           []string{"https://etcd-0.example.com:2379", "https://etcd-1.example.com:2379"},
+					ClientCertSecretRef: v1.LocalObjectReference{Name: "etcd-client"},
+				}},
+			},
+		}
+
+		cm, _, err := r.generateConfig(&kmc, []string{})
+		require.NoError(t, err)
+
+		conf := cm.Data["K0SMOTRON_K0S_YAML"]
+
+		assert.True(t, strings.Contains(conf, "- https://etcd-0.example.com:2379\n"))
+		assert.True(t, strings.Contains(conf, "- https://etcd-1.example.com:2379\n"))
+		assert.True(t, strings.Contains(conf, "etcdPrefix: default-test\n"))
+		assert.True(t, strings.Contains(conf, "caFile: /var/lib/k0s/pki/external-etcd-ca.crt\n"))
+		assert.False(t, strings.Contains(conf, kmc.GetEtcdServiceName()))
+	})
+}
+
+func TestRequestsForK0sConfigRef(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	fromConfigMap := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "from-configmap", Namespace: "default"},
+		Spec: km.ClusterSpec{
+			K0sConfigRef: &km.K0sConfigRef{ConfigMapRef: &v1.LocalObjectReference{Name: "baseline"}},
+		},
+	}
+	fromSecret := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "from-secret", Namespace: "default"},
+		Spec: km.ClusterSpec{
+			K0sConfigRef: &km.K0sConfigRef{SecretRef: &v1.LocalObjectReference{Name: "baseline"}},
+		},
+	}
+	inline := &km.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "inline", Namespace: "default"}}
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(fromConfigMap, fromSecret, inline).
+		WithIndex(&km.Cluster{}, clusterK0sConfigRefField, indexClusterK0sConfigRef).
+		Build()
+	r := ClusterReconciler{Client: c, Scheme: scheme}
+
+	requests := r.requestsForK0sConfigRef(context.Background(), &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "baseline", Namespace: "default"}})
+	require.Len(t, requests, 1)
+	assert.Equal(t, "from-configmap", requests[0].Name)
+
+	requests = r.requestsForK0sConfigRef(context.Background(), &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "baseline", Namespace: "default"}})
+	require.Len(t, requests, 1)
+	assert.Equal(t, "from-secret", requests[0].Name)
+
+	requests = r.requestsForK0sConfigRef(context.Background(), &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "baseline", Namespace: "other"}})
+	assert.Empty(t, requests)
 }
@@ -18,26 +18,47 @@ package k0smotronio
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	bootstrapv1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/secret"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/exec"
+	"github.com/k0sproject/k0smotron/internal/notify"
+	"github.com/k0sproject/k0smotron/internal/secretstore"
+	"github.com/k0sproject/k0smotron/internal/sharding"
+	"github.com/k0sproject/k0smotron/internal/tracing"
 )
 
 const defaultKubeAPIPort = 6443
 
+// componentHealthCheckInterval is the interval of the health checks of the k0s components of a ready cluster.
+const componentHealthCheckInterval = time.Minute
+
 var patchOpts []client.PatchOption = []client.PatchOption{
 	client.FieldOwner("k0smotron-operator"),
 	client.ForceOwnership,
@@ -49,13 +70,30 @@ type ClusterReconciler struct {
 	Scheme     *runtime.Scheme
 	ClientSet  *kubernetes.Clientset
 	RESTConfig *rest.Config
+	// SecretStores holds the external stores the admin kubeconfig can be written to instead of a Kubernetes Secret.
+	SecretStores *secretstore.Registry
+	// Recorder records the Events about the failed reconciliations.
+	Recorder record.EventRecorder
+	// Notifier posts the lifecycle events of the clusters to the global webhooks and to the webhooks of the clusters.
+	Notifier *notify.Notifier
+	// MaxConcurrentReconciles is the maximum number of clusters reconciled at the same time.
+	MaxConcurrentReconciles int
+	// Shard selects the resources reconciled by the controller. All the resources are reconciled if nil.
+	Shard *sharding.Shard
+
+	// apiProber checks the readiness of the API server at the address. Defaults to probeAPI.
+	apiProber func(ctx context.Context, kmc *km.Cluster, address string) error
+	// konnectivityProber checks the konnectivity server at the address. Defaults to probeTCP.
+	konnectivityProber func(ctx context.Context, address string) error
 }
 
 //+kubebuilder:rbac:groups=k0smotron.io,resources=clusters,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=k0smotron.io,resources=clusters/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=k0smotron.io,resources=clusters/finalizers,verbs=update
+//+kubebuilder:rbac:groups=k0smotron.io,resources=referencegrants,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=persistentvolumes,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
@@ -63,6 +101,9 @@ type ClusterReconciler struct {
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list
 // +kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
 // +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=externaldns.k8s.io,resources=dnsendpoints,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=lib.projectsveltos.io,resources=sveltosclusters,verbs=get;create;update;patch
+// +kubebuilder:rbac:groups=velero.io,resources=backups,verbs=get;list
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -78,6 +119,10 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 
 	var kmc km.Cluster
 	if err := r.Get(ctx, req.NamespacedName, &kmc); err != nil {
+		if apierrors.IsNotFound(err) {
+			// The exec stream limits of the deleted cluster are not needed anymore
+			exec.Forget(req.NamespacedName)
+		}
 		logger.Error(err, "unable to fetch Cluster")
 		// we'll ignore not-found errors, since they can't be fixed by an immediate
 		// requeue (we'll need to wait for a new notification), and we can get them
@@ -85,103 +130,195 @@ func (r *ClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 	logger.Info("Reconciling")
+	ctx = tracing.WithCluster(ctx, kmc.Name)
+	// The status is patched with the changes since the cluster was read
+	original := kmc.DeepCopy()
 
 	if !kmc.ObjectMeta.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(&kmc, gitopsFinalizer) {
+			if err := r.deleteGitOpsSecrets(ctx, &kmc); err != nil {
+				return ctrl.Result{}, kutil.ReconcileError(err)
+			}
+		}
+		if controllerutil.ContainsFinalizer(&kmc, secretStoreFinalizer) {
+			if err := r.deleteStoredKubeConfig(ctx, &kmc); err != nil {
+				return ctrl.Result{}, kutil.ReconcileError(err)
+			}
+		}
+		if controllerutil.ContainsFinalizer(&kmc, notificationsFinalizer) {
+			if err := r.notifyDeleted(ctx, &kmc); err != nil {
+				return ctrl.Result{}, kutil.ReconcileError(err)
+			}
+		}
 		logger.Info("Cluster is being deleted, no action needed")
 		return ctrl.Result{}, nil
 	}
 
+	// The notifications finalizer is set on kmc before its copies patch the finalizers
+	if err := r.reconcileNotificationsFinalizer(ctx, &kmc); err != nil {
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling notifications", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
+	}
+	wasReady := meta.IsStatusConditionTrue(kmc.Status.Conditions, km.ReadyCondition)
+
 	logger.Info("Reconciling services")
 	if err := r.reconcileServices(ctx, kmc); err != nil {
-		r.updateStatus(ctx, kmc, "Failed reconciling services")
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling services", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
+	}
+
+	if err := r.reconcileAPIServingCertificate(ctx, &kmc); err != nil {
+		if errors.Is(err, errAPIServingCertNotReady) {
+			logger.Info("Waiting for cert-manager to issue the API serving certificate")
+			r.updateStatus(ctx, original, kmc, "Waiting for API serving certificate")
+			return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, nil
+		}
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling API serving certificate", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
 	}
 
 	if err := r.reconcileK0sConfig(ctx, &kmc); err != nil {
-		r.updateStatus(ctx, kmc, "Failed reconciling configmap")
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling configmap", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
 	}
 
 	if err := r.reconcileEntrypointCM(ctx, kmc); err != nil {
-		r.updateStatus(ctx, kmc, "Failed reconciling entrypoint configmap")
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling entrypoint configmap", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
+	}
+
+	if err := r.reconcileAccessControlCM(ctx, kmc); err != nil {
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling access control configmap", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
+	}
+
+	if err := r.reconcileWebhooksCM(ctx, kmc); err != nil {
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling webhooks configmap", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
 	}
 
 	if kmc.Spec.Monitoring.Enabled {
 		if err := r.reconcileMonitoringCM(ctx, kmc); err != nil {
-			r.updateStatus(ctx, kmc, "Failed reconciling prometheus configmap")
-			return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+			r.reconcileFailed(ctx, original, kmc, "Failed reconciling prometheus configmap", err)
+			return ctrl.Result{}, kutil.ReconcileError(err)
+		}
+	}
+
+	if kmc.Spec.Logging.Enabled {
+		if err := r.reconcileLoggingCM(ctx, kmc); err != nil {
+			r.reconcileFailed(ctx, original, kmc, "Failed reconciling log forwarder configmap", err)
+			return ctrl.Result{}, kutil.ReconcileError(err)
 		}
 	}
 
+	if err := r.reconcileVeleroBackupCM(ctx, kmc); err != nil {
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling Velero backup configmap", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
+	}
+
 	if kmc.Spec.CertificateRefs == nil {
 		if err := r.ensureCertificates(ctx, &kmc); err != nil {
-			return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
-		}
-		kmc.Spec.CertificateRefs = []km.CertificateRef{
-			{
-				Type: string(secret.ClusterCA),
-				Name: secret.Name(kmc.Name, secret.ClusterCA),
-			},
-			{
-				Type: string(secret.FrontProxyCA),
-				Name: secret.Name(kmc.Name, secret.FrontProxyCA),
-			},
-			{
-				Type: string(secret.ServiceAccount),
-				Name: secret.Name(kmc.Name, secret.ServiceAccount),
-			},
-			{
-				Type: string(secret.EtcdCA),
-				Name: secret.Name(kmc.Name, secret.EtcdCA),
-			},
+			return ctrl.Result{}, kutil.ReconcileError(err)
 		}
+		kmc.Spec.CertificateRefs = clusterCertificateRefs(&kmc)
 	}
-	if kmc.Spec.KineDataSourceURL == "" {
+	if kmc.Spec.Etcd.External == nil && kmc.Spec.KineDataSourceURL == "" {
 		logger.Info("Reconciling etcd certs")
 		err := r.ensureEtcdCertificates(ctx, &kmc)
 		if err != nil {
-			return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, fmt.Errorf("error generating etcd certificates: %w", err)
+			return ctrl.Result{}, fmt.Errorf("error generating etcd certificates: %w", err)
 		}
-		kmc.Spec.CertificateRefs = append(kmc.Spec.CertificateRefs, km.CertificateRef{
-			Type: string(secret.APIServerEtcdClient),
-			Name: secret.Name(kmc.Name, secret.APIServerEtcdClient),
-		})
 	}
+	kmc.Spec.CertificateRefs = append(kmc.Spec.CertificateRefs, etcdCertificateRefs(&kmc)...)
 
 	logger.Info("Reconciling etcd")
 	if err := r.reconcileEtcd(ctx, &kmc); err != nil {
-		r.updateStatus(ctx, kmc, fmt.Sprintf("Failed reconciling etcd, %+v", err))
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		r.reconcileFailed(ctx, original, kmc, fmt.Sprintf("Failed reconciling etcd, %+v", err), err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
 	}
 
 	logger.Info("Reconciling statefulset")
 	if err := r.reconcileStatefulSet(ctx, kmc); err != nil {
-		r.updateStatus(ctx, kmc, fmt.Sprintf("Failed reconciling statefulset, %+v", err))
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+		r.reconcileFailed(ctx, original, kmc, fmt.Sprintf("Failed reconciling statefulset, %+v", err), err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
+	}
+
+	if err := r.reconcilePodMonitor(ctx, &kmc); err != nil {
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling PodMonitor", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
+	}
+
+	if err := r.setReplicasStatus(ctx, &kmc); err != nil {
+		r.reconcileFailed(ctx, original, kmc, "Failed getting statefulset status", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
+	}
+
+	if err := r.reconcileGitOpsSecrets(ctx, &kmc); err != nil {
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling GitOps secrets", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
 	}
 
-	if err := r.reconcileKubeConfigSecret(ctx, kmc); err != nil {
-		r.updateStatus(ctx, kmc, "Failed reconciling secret")
-		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, err
+	if err := r.reconcileKubeConfigSecret(ctx, &kmc); err != nil {
+		setCondition(&kmc, km.KubeconfigReadyCondition, metav1.ConditionFalse, km.KubeconfigNotReadyReason, err.Error())
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling secret", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
 	}
 
-	r.updateStatus(ctx, kmc, "Reconciliation successful")
-	return ctrl.Result{}, nil
+	setCondition(&kmc, km.KubeconfigReadyCondition, metav1.ConditionTrue, km.AvailableReason, "")
+
+	if err := r.reconcileBreakGlassSecret(ctx, kmc); err != nil {
+		r.reconcileFailed(ctx, original, kmc, "Failed reconciling break-glass secret", err)
+		return ctrl.Result{}, kutil.ReconcileError(err)
+	}
+
+	if err := r.reconcileComponentHealth(ctx, &kmc); err != nil {
+		// The component health is informational, so failing to check it doesn't fail the reconciliation
+		logger.Error(err, "Failed to check the component health")
+	}
+
+	if err := r.reconcileReplicaStatus(ctx, &kmc); err != nil {
+		// The replica status is informational as well
+		logger.Error(err, "Failed to get the replica status")
+	}
+
+	r.reconcileDNSRecord(ctx, &kmc)
+	r.reconcileManifestBundles(ctx, &kmc)
+	r.reconcileHubRegistrations(ctx, &kmc)
+	r.reconcileBackupStatus(ctx, &kmc)
+
+	ready := r.updateStatus(ctx, original, kmc, km.ReconciliationSuccessful)
+	r.reconcileNotifications(ctx, &kmc, wasReady, ready)
+	if !ready {
+		// The components of the cluster become ready asynchronously, so the conditions are observed again
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	// The component health is checked periodically
+	return ctrl.Result{RequeueAfter: componentHealthCheckInterval}, nil
 }
 
-func (r *ClusterReconciler) updateStatus(ctx context.Context, kmc km.Cluster, status string) {
+// updateStatus sets the reconciliation status and the conditions of the cluster, patching the status changed since
+// original, and returns whether the cluster is ready.
+func (r *ClusterReconciler) updateStatus(ctx context.Context, original *km.Cluster, kmc km.Cluster, status string) bool {
 	logger := log.FromContext(ctx)
 	kmc.Status.ReconciliationStatus = status
-	if err := r.Status().Patch(ctx, &kmc, client.Merge); err != nil {
+	r.setConditions(ctx, &kmc)
+	if err := kutil.PatchStatus(ctx, r.Client, &kmc, original); err != nil {
 		logger.Error(err, fmt.Sprintf("Unable to update status: %s", status))
 	}
+	return meta.IsStatusConditionTrue(kmc.Status.Conditions, km.ReadyCondition)
+}
+
+// reconcileFailed records a warning Event about the failed step and sets the reconciliation status.
+func (r *ClusterReconciler) reconcileFailed(ctx context.Context, original *km.Cluster, kmc km.Cluster, status string, err error) {
+	kutil.RecordEvent(r.Recorder, &kmc, v1.EventTypeWarning, kutil.ReconcileFailedReason, "%s: %v", status, err)
+	r.updateStatus(ctx, original, kmc, status)
 }
 
 func (r *ClusterReconciler) updateReadiness(ctx context.Context, kmc km.Cluster, ready bool) {
 	logger := log.FromContext(ctx)
+	original := kmc.DeepCopy()
 	kmc.Status.Ready = ready
-	if err := r.Status().Patch(ctx, &kmc, client.Merge); err != nil {
+	if err := kutil.PatchStatus(ctx, r.Client, &kmc, original); err != nil {
 		logger.Error(err, fmt.Sprintf("Unable to update readiness: %v", ready))
 	}
 }
@@ -196,10 +333,95 @@ func (r *ClusterReconciler) ensureCertificates(ctx context.Context, kmc *km.Clus
 	return nil
 }
 
+// clusterCertificateRefs returns the certificates generated for the cluster, mounted to the controller pods.
+func clusterCertificateRefs(kmc *km.Cluster) []km.CertificateRef {
+	return []km.CertificateRef{
+		{
+			Type: string(secret.ClusterCA),
+			Name: secret.Name(kmc.Name, secret.ClusterCA),
+		},
+		{
+			Type: string(secret.FrontProxyCA),
+			Name: secret.Name(kmc.Name, secret.FrontProxyCA),
+		},
+		{
+			Type: string(secret.ServiceAccount),
+			Name: secret.Name(kmc.Name, secret.ServiceAccount),
+		},
+		{
+			Type: string(secret.EtcdCA),
+			Name: secret.Name(kmc.Name, secret.EtcdCA),
+		},
+	}
+}
+
+// etcdCertificateRefs returns the etcd client certificate of the API server, which is provided by the user for an
+// external etcd. No certificate is needed with kine.
+func etcdCertificateRefs(kmc *km.Cluster) []km.CertificateRef {
+	if kmc.Spec.Etcd.External != nil {
+		return []km.CertificateRef{{
+			Type: externalEtcdClientCertType,
+			Name: kmc.Spec.Etcd.External.ClientCertSecretRef.Name,
+		}}
+	}
+	if kmc.Spec.KineDataSourceURL != "" {
+		return nil
+	}
+	return []km.CertificateRef{{
+		Type: string(secret.APIServerEtcdClient),
+		Name: secret.Name(kmc.Name, secret.APIServerEtcdClient),
+	}}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &km.Cluster{}, clusterMonitoringTokenSecretField, indexClusterMonitoringTokenSecret); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &km.Cluster{}, clusterK0sConfigRefField, indexClusterK0sConfigRef); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &km.Cluster{}, clusterWebhookKubeconfigSecretField, indexClusterWebhookKubeconfigSecrets); err != nil {
+		return err
+	}
+
+	// The new clusters are provisioned by a separate controller, ahead of the periodic reconciles of the existing ones
+	lock := kutil.NewKeyLock()
+	newCluster := kutil.CreatedPredicate(func(e event.CreateEvent) bool {
+		return e.Object.(*km.Cluster).Status.ReconciliationStatus == ""
+	})
+	if err := ctrl.NewControllerManagedBy(mgr).
+		Named("cluster-provisioning").
+		For(&km.Cluster{}, builder.WithPredicates(newCluster)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(r.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("Cluster", kutil.ProvisioningLane(kutil.Exclusive(lock, r), r.clusterProvisioned)))); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&km.Cluster{}).
+		For(&km.Cluster{}, builder.WithPredicates(predicate.Not(newCluster))).
+		// The changes of the generated objects, e.g. a deleted secret or an edited service, are reverted right away
 		Owns(&apps.StatefulSet{}).
-		Complete(r)
+		Owns(&v1.Service{}).
+		Owns(&v1.ConfigMap{}).
+		// The generated secrets are updated by the reconciles themselves, so only the deleted secrets are recreated
+		Owns(&v1.Secret{}, builder.WithPredicates(predicate.Funcs{
+			CreateFunc:  func(event.CreateEvent) bool { return false },
+			UpdateFunc:  func(event.UpdateEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		})).
+		Watches(&v1.Secret{}, handler.EnqueueRequestsFromMapFunc(requestsForAPIServingCertSecret)).
+		Watches(&v1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.requestsForMonitoringTokenSecret)).
+		Watches(&v1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.requestsForK0sConfigRef)).
+		Watches(&v1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.requestsForK0sConfigRef)).
+		Watches(&v1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.requestsForWebhookKubeconfigSecret)).
+		Watches(&km.ReferenceGrant{}, handler.EnqueueRequestsFromMapFunc(r.requestsForReferenceGrant)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(r.Shard.Reconciler(mgr.GetClient(), tracing.Reconciler("Cluster", kutil.Exclusive(lock, r))))
+}
+
+// clusterProvisioned returns whether the cluster is ready or gone, so it's left to the main controller.
+func (r *ClusterReconciler) clusterProvisioned(ctx context.Context, key types.NamespacedName) bool {
+	var kmc km.Cluster
+	return r.Client.Get(ctx, key, &kmc) != nil || kmc.Status.Ready
 }
@@ -0,0 +1,158 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+// errNoServiceAddress is returned until the service of the cluster has an address to publish.
+var errNoServiceAddress = errors.New("the service has no address yet")
+
+// reconcileDNSRecord publishes the DNS record of the API endpoint with the current addresses of the service and sets
+// the DNSRecordPublished condition. The record is updated on every reconciliation, so it follows the changes of the
+// load balancer address or of the nodes.
+func (r *ClusterReconciler) reconcileDNSRecord(ctx context.Context, kmc *km.Cluster) {
+	if kmc.Spec.DNS == nil {
+		meta.RemoveStatusCondition(&kmc.Status.Conditions, km.DNSRecordPublishedCondition)
+		return
+	}
+
+	if err := r.applyDNSEndpoint(ctx, kmc); err != nil {
+		if errors.Is(err, errNoServiceAddress) {
+			setCondition(kmc, km.DNSRecordPublishedCondition, metav1.ConditionFalse, km.DNSRecordNotPublishedReason, "Waiting for the service address")
+			return
+		}
+		log.FromContext(ctx).Error(err, "Failed to publish the DNS record")
+		kutil.RecordEvent(r.Recorder, kmc, v1.EventTypeWarning, kutil.ReconcileFailedReason, "Failed publishing the DNS record: %v", err)
+		setCondition(kmc, km.DNSRecordPublishedCondition, metav1.ConditionFalse, km.DNSRecordNotPublishedReason, err.Error())
+		return
+	}
+	setCondition(kmc, km.DNSRecordPublishedCondition, metav1.ConditionTrue, km.AvailableReason, "")
+}
+
+func (r *ClusterReconciler) applyDNSEndpoint(ctx context.Context, kmc *km.Cluster) error {
+	targets, err := r.apiEndpointTargets(ctx, kmc)
+	if err != nil {
+		return err
+	}
+
+	endpoint := generateDNSEndpoint(kmc, targets)
+	if err := ctrl.SetControllerReference(kmc, endpoint, r.Scheme); err != nil {
+		return err
+	}
+	return r.Client.Patch(ctx, endpoint, client.Apply, patchOpts...)
+}
+
+// apiEndpointTargets returns the addresses the DNS record points to: the load balancer addresses of the service, or
+// the addresses of the nodes for a NodePort service.
+func (r *ClusterReconciler) apiEndpointTargets(ctx context.Context, kmc *km.Cluster) ([]string, error) {
+	var targets []string
+	switch kmc.Spec.Service.Type {
+	case v1.ServiceTypeLoadBalancer:
+		var svc v1.Service
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetLoadBalancerServiceName(), Namespace: kmc.Namespace}, &svc); err != nil {
+			return nil, err
+		}
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				targets = append(targets, ingress.IP)
+			} else if ingress.Hostname != "" {
+				targets = append(targets, ingress.Hostname)
+			}
+		}
+	case v1.ServiceTypeNodePort:
+		nodes, err := r.ClientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		targets = kutil.NodeAddresses(nodes)
+	default:
+		return nil, fmt.Errorf("the DNS record requires a NodePort or LoadBalancer service")
+	}
+
+	if len(targets) == 0 {
+		return nil, errNoServiceAddress
+	}
+	return targets, nil
+}
+
+// generateDNSEndpoint generates the external-dns DNSEndpoint of the API endpoint. The IP addresses are published as
+// A and AAAA records, and a load balancer hostname as a CNAME record.
+func generateDNSEndpoint(kmc *km.Cluster, targets []string) *unstructured.Unstructured {
+	var ipv4, ipv6, hostnames []interface{}
+	for _, target := range targets {
+		ip := net.ParseIP(target)
+		switch {
+		case ip == nil:
+			hostnames = append(hostnames, target)
+		case ip.To4() != nil:
+			ipv4 = append(ipv4, target)
+		default:
+			ipv6 = append(ipv6, target)
+		}
+	}
+
+	var endpoints []interface{}
+	addEndpoint := func(recordType string, targets []interface{}) {
+		if len(targets) == 0 {
+			return
+		}
+		endpoints = append(endpoints, map[string]interface{}{
+			"dnsName":    kmc.Spec.DNS.Hostname,
+			"recordType": recordType,
+			"recordTTL":  kmc.Spec.DNS.TTL,
+			"targets":    targets,
+		})
+	}
+	addEndpoint("A", ipv4)
+	addEndpoint("AAAA", ipv6)
+	// A name with a CNAME record can't have other records
+	if len(endpoints) == 0 && len(hostnames) > 0 {
+		addEndpoint("CNAME", hostnames[:1])
+	}
+
+	labels := labelsForCluster(kmc)
+	for k, v := range kmc.Spec.DNS.Labels {
+		labels[k] = v
+	}
+
+	endpoint := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"endpoints": endpoints},
+	}}
+	endpoint.SetAPIVersion("externaldns.k8s.io/v1alpha1")
+	endpoint.SetKind("DNSEndpoint")
+	endpoint.SetName(kmc.GetDNSEndpointName())
+	endpoint.SetNamespace(kmc.Namespace)
+	endpoint.SetLabels(labels)
+	endpoint.SetAnnotations(annotationsForCluster(kmc))
+	return endpoint
+}
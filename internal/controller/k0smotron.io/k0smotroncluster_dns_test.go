@@ -0,0 +1,107 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestGenerateDNSEndpoint(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: km.ClusterSpec{DNS: &km.DNSSpec{
+			Hostname: "api.test.example.com",
+			TTL:      60,
+			Labels:   map[string]string{"dns": "public"},
+		}},
+	}
+
+	endpoint := generateDNSEndpoint(kmc, []string{"1.1.1.1", "2001:db8::1", "2.2.2.2", "lb.example.com"})
+	assert.Equal(t, "DNSEndpoint", endpoint.GetKind())
+	assert.Equal(t, "kmc-test-api", endpoint.GetName())
+	assert.Equal(t, "public", endpoint.GetLabels()["dns"])
+	endpoints, _, err := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"dnsName":    "api.test.example.com",
+			"recordType": "A",
+			"recordTTL":  int64(60),
+			"targets":    []interface{}{"1.1.1.1", "2.2.2.2"},
+		},
+		map[string]interface{}{
+			"dnsName":    "api.test.example.com",
+			"recordType": "AAAA",
+			"recordTTL":  int64(60),
+			"targets":    []interface{}{"2001:db8::1"},
+		},
+	}, endpoints)
+
+	endpoint = generateDNSEndpoint(kmc, []string{"lb-1.example.com", "lb-2.example.com"})
+	endpoints, _, err = unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"dnsName":    "api.test.example.com",
+			"recordType": "CNAME",
+			"recordTTL":  int64(60),
+			"targets":    []interface{}{"lb-1.example.com"},
+		},
+	}, endpoints)
+}
+
+func TestAPIEndpointTargets(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: km.ClusterSpec{
+			Service: km.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+			DNS:     &km.DNSSpec{Hostname: "api.test.example.com"},
+		},
+	}
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: kmc.GetLoadBalancerServiceName(), Namespace: "default"}}
+	r := &ClusterReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(svc).WithStatusSubresource(svc).Build()}
+	ctx := context.Background()
+
+	_, err := r.apiEndpointTargets(ctx, kmc)
+	assert.ErrorIs(t, err, errNoServiceAddress)
+
+	svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "1.1.1.1"}, {Hostname: "lb.example.com"}}
+	require.NoError(t, r.Client.Status().Update(ctx, svc))
+	targets, err := r.apiEndpointTargets(ctx, kmc)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1", "lb.example.com"}, targets)
+
+	kmc.Spec.Service.Type = v1.ServiceTypeClusterIP
+	_, err = r.apiEndpointTargets(ctx, kmc)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errNoServiceAddress)
+}
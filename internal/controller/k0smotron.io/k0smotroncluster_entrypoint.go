@@ -23,10 +23,10 @@ import (
 	"text/template"
 
 	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/controller/util"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -37,11 +37,17 @@ func init() {
 }
 
 func (r *ClusterReconciler) generateEntrypointCM(kmc *km.Cluster) (v1.ConfigMap, error) {
-	var entrypointBuf bytes.Buffer
-	err := entrypointTmpl.Execute(&entrypointBuf, map[string]string{
+	data := map[string]string{
 		"KineDataSourceURLPlaceholder": kineDataSourceURLPlaceholder,
 		"K0sControllerArgs":            r.getControllerFlags(kmc),
-	})
+	}
+	if kmc.Spec.Logging.Enabled {
+		data["LogFile"] = logFile("controller")
+		data["LogTruncation"] = logTruncationScript(data["LogFile"])
+	}
+
+	var entrypointBuf bytes.Buffer
+	err := entrypointTmpl.Execute(&entrypointBuf, data)
 	if err != nil {
 		return v1.ConfigMap{}, err
 	}
@@ -75,7 +81,7 @@ func (r *ClusterReconciler) reconcileEntrypointCM(ctx context.Context, kmc km.Cl
 		return err
 	}
 
-	return r.Client.Patch(ctx, &cm, client.Apply, patchOpts...)
+	return kutil.ApplyConfigMap(ctx, r.Client, &cm, patchOpts...)
 }
 
 func (r *ClusterReconciler) getControllerFlags(kmc *km.Cluster) string {
@@ -110,6 +116,9 @@ mkdir /etc/k0s && echo "$K0SMOTRON_K0S_YAML" > /etc/k0s/k0s.yaml
 # Substitute the kine datasource URL from the env var
 sed -i "s {{ .KineDataSourceURLPlaceholder }} ${K0SMOTRON_KINE_DATASOURCE_URL} g" /etc/k0s/k0s.yaml
 
-# Run the k0s controller
+{{ if .LogFile }}{{ .LogTruncation }}
+# Run the k0s controller, copying the logs to the log forwarder
+k0s controller {{ .K0sControllerArgs }} 2>&1 | tee -a {{ .LogFile }}
+{{ else }}# Run the k0s controller
 k0s controller {{ .K0sControllerArgs }}
-`
+{{ end }}`
@@ -37,6 +37,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// externalEtcdClientCertType is the type of the certificate reference of the client certificate of an external etcd.
+const externalEtcdClientCertType = "external-etcd-client"
+
 var etcdEntrypointScriptTmpl *template.Template
 
 func init() {
@@ -44,7 +47,7 @@ func init() {
 }
 
 func (r *ClusterReconciler) reconcileEtcd(ctx context.Context, kmc *km.Cluster) error {
-	if kmc.Spec.KineDataSourceURL != "" || kmc.Spec.KineDataSourceSecretName != "" {
+	if kmc.Spec.KineDataSourceURL != "" || kmc.Spec.KineDataSourceSecretName != "" || kmc.Spec.Etcd.External != nil {
 		return nil
 	}
 
@@ -59,6 +62,11 @@ func (r *ClusterReconciler) reconcileEtcd(ctx context.Context, kmc *km.Cluster)
 }
 
 func (r *ClusterReconciler) reconcileEtcdSvc(ctx context.Context, kmc *km.Cluster) error {
+	svc := r.generateEtcdSvc(kmc)
+	return r.Client.Patch(ctx, &svc, client.Apply, patchOpts...)
+}
+
+func (r *ClusterReconciler) generateEtcdSvc(kmc *km.Cluster) v1.Service {
 	labels := labelsForEtcdCluster(kmc)
 
 	svc := v1.Service{
@@ -93,8 +101,7 @@ func (r *ClusterReconciler) reconcileEtcdSvc(ctx context.Context, kmc *km.Cluste
 	}
 
 	_ = ctrl.SetControllerReference(kmc, &svc, r.Scheme)
-
-	return r.Client.Patch(ctx, &svc, client.Apply, patchOpts...)
+	return svc
 }
 
 func (r *ClusterReconciler) reconcileEtcdStatefulSet(ctx context.Context, kmc *km.Cluster) error {
@@ -150,12 +157,19 @@ func (r *ClusterReconciler) generateEtcdStatefulSet(kmc *km.Cluster, replicas in
 		pvc.Spec.StorageClassName = &kmc.Spec.Etcd.Persistence.StorageClass
 	}
 
-	var etcdEntrypointScriptBuf bytes.Buffer
-	_ = etcdEntrypointScriptTmpl.Execute(&etcdEntrypointScriptBuf, struct {
-		Args []string
+	scriptData := struct {
+		Args          []string
+		LogFile       string
+		LogTruncation string
 	}{
 		Args: kmc.Spec.Etcd.Args,
-	})
+	}
+	if kmc.Spec.Logging.Enabled {
+		scriptData.LogFile = logFile("etcd")
+		scriptData.LogTruncation = logTruncationScript(scriptData.LogFile)
+	}
+	var etcdEntrypointScriptBuf bytes.Buffer
+	_ = etcdEntrypointScriptTmpl.Execute(&etcdEntrypointScriptBuf, scriptData)
 
 	statefulSet := apps.StatefulSet{
 		TypeMeta: metav1.TypeMeta{
@@ -285,6 +299,11 @@ func (r *ClusterReconciler) generateEtcdStatefulSet(kmc *km.Cluster, replicas in
 		},
 	}
 
+	if kmc.Spec.Logging.Enabled {
+		addLogForwarder(kmc, &statefulSet.Spec.Template, "etcd")
+	}
+	addVeleroEtcdHooks(kmc, &statefulSet.Spec.Template)
+
 	return statefulSet
 }
 
@@ -342,7 +361,8 @@ if [[ -f /var/lib/k0s/etcd/existing ]]; then
   export ETCD_INITIAL_CLUSTER_STATE="existing"
 fi
 
-etcd --name ${HOSTNAME} \
+{{ if .LogFile }}{{ .LogTruncation }}
+{{ end }}etcd --name ${HOSTNAME} \
   --listen-peer-urls=https://0.0.0.0:2380 \
   --listen-client-urls=https://0.0.0.0:2379 \
   --advertise-client-urls=https://${HOSTNAME}.${SVC_NAME}:2379 \
@@ -363,7 +383,7 @@ etcd --name ${HOSTNAME} \
 {{- range $arg := .Args }}
   {{ $arg }} \
 {{- end }}
-  --data-dir=/var/lib/k0s/etcd 
+  --data-dir=/var/lib/k0s/etcd {{ if .LogFile }}2>&1 | tee -a {{ .LogFile }}{{ end }}
 `
 
 var initEntryScript = `
@@ -0,0 +1,363 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/exec"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// gitopsFinalizer is set on the clusters with GitOps secrets in other namespaces, which are not garbage collected.
+	gitopsFinalizer = "k0smotron.io/gitops"
+	// gitopsClusterNameLabel and gitopsClusterNamespaceLabel select the GitOps secrets of a cluster in all the
+	// namespaces.
+	gitopsClusterNameLabel      = "k0smotron.io/gitops-cluster-name"
+	gitopsClusterNamespaceLabel = "k0smotron.io/gitops-cluster-namespace"
+	// argoCDSecretTypeLabel marks the secrets Argo CD reads the clusters from.
+	argoCDSecretTypeLabel = "argocd.argoproj.io/secret-type"
+	// gitopsUser is the user of the credentials in the GitOps secrets.
+	gitopsUser = "k0smotron-gitops"
+)
+
+// argoCDClusterConfig is the connection config of an Argo CD cluster secret.
+type argoCDClusterConfig struct {
+	TLSClientConfig argoCDTLSClientConfig `json:"tlsClientConfig"`
+}
+
+type argoCDTLSClientConfig struct {
+	CAData   []byte `json:"caData,omitempty"`
+	CertData []byte `json:"certData,omitempty"`
+	KeyData  []byte `json:"keyData,omitempty"`
+}
+
+// reconcileGitOpsSecrets creates the Argo CD and Flux secrets of the cluster and deletes the ones no longer enabled.
+// The credentials in the existing secrets are kept until their certificate is about to expire.
+func (r *ClusterReconciler) reconcileGitOpsSecrets(ctx context.Context, kmc *km.Cluster) error {
+	logger := log.FromContext(ctx)
+
+	var existing v1.SecretList
+	if err := r.Client.List(ctx, &existing, client.MatchingLabels(gitopsSelectorLabels(kmc))); err != nil {
+		return err
+	}
+
+	desired, err := r.gitOpsSecretKeys(ctx, kmc)
+	if err != nil {
+		return err
+	}
+
+	outside := false
+	for key := range desired {
+		outside = outside || key.Namespace != kmc.Namespace
+	}
+	if outside && !controllerutil.ContainsFinalizer(kmc, gitopsFinalizer) {
+		patch := client.MergeFrom(kmc.DeepCopy())
+		controllerutil.AddFinalizer(kmc, gitopsFinalizer)
+		if err := r.Client.Patch(ctx, kmc, patch); err != nil {
+			return err
+		}
+	}
+
+	current := map[client.ObjectKey]*v1.Secret{}
+	for i := range existing.Items {
+		secret := &existing.Items[i]
+		key := client.ObjectKeyFromObject(secret)
+		if desired[key] {
+			current[key] = secret
+			continue
+		}
+		logger.Info("GitOps secret disabled, deleting it", "secret", key)
+		if err := r.Client.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	if !outside && controllerutil.ContainsFinalizer(kmc, gitopsFinalizer) {
+		patch := client.MergeFrom(kmc.DeepCopy())
+		controllerutil.RemoveFinalizer(kmc, gitopsFinalizer)
+		if err := r.Client.Patch(ctx, kmc, patch); err != nil {
+			return err
+		}
+	}
+	if len(desired) == 0 {
+		return nil
+	}
+
+	caCert, err := r.clusterCACert(ctx, kmc)
+	if err != nil {
+		return err
+	}
+	argoCDExisting := current[argoCDSecretKey(kmc)]
+	argoCDCurrent := argoCDExisting != nil && argoCDSecretCurrent(argoCDExisting, kmc, caCert)
+	fluxExisting := current[fluxSecretKey(kmc)]
+	fluxCurrent := fluxExisting != nil && kubeconfigCurrent(fluxExisting.Data["value"], kmc, caCert)
+
+	// Every generated kubeconfig has a new client certificate, so it's generated only when a secret is missing or stale
+	var cfg *api.Config
+	if (desired[argoCDSecretKey(kmc)] && !argoCDCurrent) || (desired[fluxSecretKey(kmc)] && !fluxCurrent) {
+		cfg, err = r.generateGitOpsKubeconfig(ctx, kmc)
+		if err != nil {
+			return err
+		}
+	}
+
+	var secrets []*v1.Secret
+	if desired[argoCDSecretKey(kmc)] {
+		secret := newArgoCDSecret(kmc)
+		if argoCDCurrent {
+			secret.Data = argoCDExisting.Data
+		} else if secret, err = generateArgoCDSecret(kmc, cfg); err != nil {
+			return err
+		}
+		secrets = append(secrets, secret)
+	}
+	if desired[fluxSecretKey(kmc)] {
+		secret := newGitOpsSecret(kmc, fluxNamespace(kmc), kmc.GetFluxKubeconfigSecretName())
+		if fluxCurrent {
+			secret.Data = fluxExisting.Data
+		} else if secret, err = generateFluxSecret(kmc, cfg); err != nil {
+			return err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	for _, secret := range secrets {
+		// The secrets in other namespaces are deleted with the finalizer
+		if secret.Namespace == kmc.Namespace {
+			if err := ctrl.SetControllerReference(kmc, secret, r.Scheme); err != nil {
+				return err
+			}
+		}
+		if err := kcutil.ApplySecret(ctx, r.Client, secret, patchOpts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gitOpsSecretKeys returns the GitOps secrets enabled for the cluster. The secrets in other namespaces are left out
+// unless a ReferenceGrant in their namespace allows the cluster to write them, so the existing ones are deleted when
+// the grant is removed.
+func (r *ClusterReconciler) gitOpsSecretKeys(ctx context.Context, kmc *km.Cluster) (map[client.ObjectKey]bool, error) {
+	var keys []client.ObjectKey
+	if kmc.Spec.GitOps.ArgoCD {
+		keys = append(keys, argoCDSecretKey(kmc))
+	}
+	if kmc.Spec.GitOps.Flux {
+		keys = append(keys, fluxSecretKey(kmc))
+	}
+
+	desired := map[client.ObjectKey]bool{}
+	for _, key := range keys {
+		granted, err := isKubeconfigSecretGranted(ctx, r.Client, kmc, key)
+		if err != nil {
+			return nil, err
+		}
+		if !granted {
+			log.FromContext(ctx).Info("GitOps secret in another namespace is not permitted by any ReferenceGrant", "secret", key)
+			kcutil.RecordEvent(r.Recorder, kmc, v1.EventTypeWarning, kcutil.ReferenceNotPermittedReason, "Secret %s is not permitted by any ReferenceGrant in namespace %s", key.Name, key.Namespace)
+			continue
+		}
+		desired[key] = true
+	}
+	return desired, nil
+}
+
+// deleteGitOpsSecrets deletes the GitOps secrets of the deleted cluster and releases it.
+func (r *ClusterReconciler) deleteGitOpsSecrets(ctx context.Context, kmc *km.Cluster) error {
+	var secrets v1.SecretList
+	if err := r.Client.List(ctx, &secrets, client.MatchingLabels(gitopsSelectorLabels(kmc))); err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		if err := r.Client.Delete(ctx, &secrets.Items[i]); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete the GitOps secret: %w", err)
+		}
+	}
+
+	patch := client.MergeFrom(kmc.DeepCopy())
+	controllerutil.RemoveFinalizer(kmc, gitopsFinalizer)
+	return r.Client.Patch(ctx, kmc, patch)
+}
+
+// generateGitOpsKubeconfig returns a new cluster admin kubeconfig for the GitOps tools.
+func (r *ClusterReconciler) generateGitOpsKubeconfig(ctx context.Context, kmc *km.Cluster) (*api.Config, error) {
+	pod, err := r.findStatefulSetPod(ctx, kmc.GetStatefulSetName(), kmc.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := fmt.Sprintf("k0s kubeconfig create %s --groups system:masters", gitopsUser)
+	output, err := exec.PodExecCmdOutput(ctx, r.ClientSet, r.RESTConfig, pod.Name, client.ObjectKeyFromObject(kmc), cmd)
+	// The output is a kubeconfig, so it is not audited
+	kcutil.AuditCommand(r.Recorder, kmc, pod.Name, cmd, "", err)
+	if err != nil {
+		return nil, err
+	}
+
+	output, _, err = replaceKubeconfigPort(output, *kmc)
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.Load([]byte(output))
+}
+
+// generateArgoCDSecret returns the Argo CD cluster secret connecting to the cluster with the client certificate of
+// the kubeconfig.
+func generateArgoCDSecret(kmc *km.Cluster, cfg *api.Config) (*v1.Secret, error) {
+	cluster, authInfo, err := currentClusterAndAuthInfo(cfg)
+	if err != nil {
+		return nil, err
+	}
+	config, err := json.Marshal(argoCDClusterConfig{TLSClientConfig: argoCDTLSClientConfig{
+		CAData:   cluster.CertificateAuthorityData,
+		CertData: authInfo.ClientCertificateData,
+		KeyData:  authInfo.ClientKeyData,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	secret := newArgoCDSecret(kmc)
+	secret.Data = map[string][]byte{
+		"name":   []byte(argoCDClusterName(kmc)),
+		"server": []byte(cluster.Server),
+		"config": config,
+	}
+	return secret, nil
+}
+
+// newArgoCDSecret returns the Argo CD cluster secret of the cluster without its data.
+func newArgoCDSecret(kmc *km.Cluster) *v1.Secret {
+	secret := newGitOpsSecret(kmc, argoCDNamespace(kmc), kmc.GetArgoCDSecretName())
+	secret.Labels[argoCDSecretTypeLabel] = "cluster"
+	return secret
+}
+
+func argoCDClusterName(kmc *km.Cluster) string {
+	return fmt.Sprintf("%s-%s", kmc.Namespace, kmc.Name)
+}
+
+// argoCDSecretCurrent returns whether the existing Argo CD cluster secret connects to the API endpoint of the cluster,
+// trusts the cluster CA and its client certificate is not about to expire, like kubeconfigCurrent.
+func argoCDSecretCurrent(existing *v1.Secret, kmc *km.Cluster, caCert []byte) bool {
+	var cfg argoCDClusterConfig
+	if err := json.Unmarshal(existing.Data["config"], &cfg); err != nil {
+		return false
+	}
+	return string(existing.Data["name"]) == argoCDClusterName(kmc) &&
+		serverCurrent(string(existing.Data["server"]), kmc) &&
+		(caCert == nil || bytes.Equal(bytes.TrimSpace(cfg.TLSClientConfig.CAData), bytes.TrimSpace(caCert))) &&
+		clientCertificateValid(cfg.TLSClientConfig.CertData)
+}
+
+// generateFluxSecret returns the kubeconfig secret referenced by the Flux Kustomizations and HelmReleases, which read
+// the kubeconfig from the "value" key by default.
+func generateFluxSecret(kmc *km.Cluster, cfg *api.Config) (*v1.Secret, error) {
+	kubeconfig, err := clientcmd.Write(*cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := newGitOpsSecret(kmc, fluxNamespace(kmc), kmc.GetFluxKubeconfigSecretName())
+	secret.Data = map[string][]byte{"value": kubeconfig}
+	return secret, nil
+}
+
+func newGitOpsSecret(kmc *km.Cluster, namespace, name string) *v1.Secret {
+	labels := labelsForCluster(kmc)
+	for k, v := range kmc.Spec.GitOps.Labels {
+		labels[k] = v
+	}
+	for k, v := range gitopsSelectorLabels(kmc) {
+		labels[k] = v
+	}
+
+	return &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: annotationsForCluster(kmc),
+		},
+		Type: v1.SecretTypeOpaque,
+	}
+}
+
+func gitopsSelectorLabels(kmc *km.Cluster) map[string]string {
+	return map[string]string{
+		gitopsClusterNameLabel:      kmc.Name,
+		gitopsClusterNamespaceLabel: kmc.Namespace,
+	}
+}
+
+func argoCDSecretKey(kmc *km.Cluster) client.ObjectKey {
+	return client.ObjectKey{Namespace: argoCDNamespace(kmc), Name: kmc.GetArgoCDSecretName()}
+}
+
+func fluxSecretKey(kmc *km.Cluster) client.ObjectKey {
+	return client.ObjectKey{Namespace: fluxNamespace(kmc), Name: kmc.GetFluxKubeconfigSecretName()}
+}
+
+func argoCDNamespace(kmc *km.Cluster) string {
+	if kmc.Spec.GitOps.ArgoCDNamespace == "" {
+		return "argocd"
+	}
+	return kmc.Spec.GitOps.ArgoCDNamespace
+}
+
+func fluxNamespace(kmc *km.Cluster) string {
+	if kmc.Spec.GitOps.FluxNamespace == "" {
+		return kmc.Namespace
+	}
+	return kmc.Spec.GitOps.FluxNamespace
+}
+
+// currentClusterAndAuthInfo returns the cluster and the credentials of the current context of the kubeconfig.
+func currentClusterAndAuthInfo(cfg *api.Config) (*api.Cluster, *api.AuthInfo, error) {
+	kubeContext, ok := cfg.Contexts[cfg.CurrentContext]
+	if !ok {
+		return nil, nil, fmt.Errorf("current context %q not found in the kubeconfig", cfg.CurrentContext)
+	}
+	cluster, ok := cfg.Clusters[kubeContext.Cluster]
+	if !ok {
+		return nil, nil, fmt.Errorf("cluster %q not found in the kubeconfig", kubeContext.Cluster)
+	}
+	authInfo, ok := cfg.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		return nil, nil, fmt.Errorf("user %q not found in the kubeconfig", kubeContext.AuthInfo)
+	}
+	return cluster, authInfo, nil
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/cert"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func newGitOpsKubeconfig(t *testing.T, server string) *api.Config {
+	certPEM, keyPEM, err := cert.GenerateSelfSignedCertKey(gitopsUser, nil, nil)
+	require.NoError(t, err)
+	cfg := api.NewConfig()
+	cfg.Clusters["k0s"] = &api.Cluster{Server: server, CertificateAuthorityData: []byte("ca")}
+	cfg.AuthInfos[gitopsUser] = &api.AuthInfo{ClientCertificateData: certPEM, ClientKeyData: keyPEM}
+	cfg.Contexts[gitopsUser+"@k0s"] = &api.Context{Cluster: "k0s", AuthInfo: gitopsUser}
+	cfg.CurrentContext = gitopsUser + "@k0s"
+	return cfg
+}
+
+func TestGenerateGitOpsSecrets(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant-a"},
+		Spec: km.ClusterSpec{
+			GitOps:          km.GitOpsSpec{ArgoCD: true, Flux: true, Labels: map[string]string{"env": "prod"}},
+			ExternalAddress: "10.0.0.1",
+			Service:         km.ServiceSpec{APIPort: 30443},
+		},
+	}
+	cfg := newGitOpsKubeconfig(t, "https://10.0.0.1:30443")
+
+	secret, err := generateArgoCDSecret(kmc, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "argocd", secret.Namespace)
+	assert.Equal(t, "kmc-tenant-a-test-argocd", secret.Name)
+	assert.Equal(t, "cluster", secret.Labels[argoCDSecretTypeLabel])
+	assert.Equal(t, "prod", secret.Labels["env"])
+	assert.Equal(t, "test", secret.Labels[gitopsClusterNameLabel])
+	assert.Equal(t, "tenant-a", secret.Labels[gitopsClusterNamespaceLabel])
+	assert.Equal(t, "tenant-a-test", string(secret.Data["name"]))
+	assert.Equal(t, "https://10.0.0.1:30443", string(secret.Data["server"]))
+	var argoCfg argoCDClusterConfig
+	require.NoError(t, json.Unmarshal(secret.Data["config"], &argoCfg))
+	assert.Equal(t, []byte("ca"), argoCfg.TLSClientConfig.CAData)
+	assert.Equal(t, cfg.AuthInfos[gitopsUser].ClientCertificateData, argoCfg.TLSClientConfig.CertData)
+
+	// The secret is kept until the address or the CA of the cluster changes
+	assert.True(t, argoCDSecretCurrent(secret, kmc, []byte("ca")))
+	assert.False(t, argoCDSecretCurrent(secret, kmc, []byte("new-ca")))
+	moved := kmc.DeepCopy()
+	moved.Spec.Service.APIPort = 31443
+	assert.False(t, argoCDSecretCurrent(secret, moved, []byte("ca")))
+
+	secret, err = generateFluxSecret(kmc, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-a", secret.Namespace)
+	assert.Equal(t, "kmc-tenant-a-test-flux-kubeconfig", secret.Name)
+	assert.NotContains(t, secret.Labels, argoCDSecretTypeLabel)
+	fluxCfg, err := clientcmd.Load(secret.Data["value"])
+	require.NoError(t, err)
+	assert.Equal(t, "https://10.0.0.1:30443", fluxCfg.Clusters["k0s"].Server)
+}
+
+func TestReconcileGitOpsSecretsDisabled(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant-a", Finalizers: []string{gitopsFinalizer}},
+	}
+	argoSecret := newGitOpsSecret(kmc, "argocd", kmc.GetArgoCDSecretName())
+	other := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "argocd"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(kmc, argoSecret, other).Build()
+	r := &ClusterReconciler{Client: c, Scheme: scheme}
+
+	// With Argo CD disabled, its secret is deleted and the cluster released
+	require.NoError(t, r.reconcileGitOpsSecrets(context.Background(), kmc))
+	err := c.Get(context.Background(), client.ObjectKeyFromObject(argoSecret), &v1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(other), &v1.Secret{}))
+
+	var updated km.Cluster
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(kmc), &updated))
+	assert.NotContains(t, updated.Finalizers, gitopsFinalizer)
+}
+
+func TestReconcileGitOpsSecretsUpToDate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant-a"},
+		Spec: km.ClusterSpec{
+			GitOps:          km.GitOpsSpec{ArgoCD: true, ArgoCDNamespace: "tenant-a", Flux: true, Labels: map[string]string{"env": "prod"}},
+			ExternalAddress: "10.0.0.1",
+			Service:         km.ServiceSpec{APIPort: 30443},
+		},
+	}
+	cfg := newGitOpsKubeconfig(t, "https://10.0.0.1:30443")
+	argoSecret, err := generateArgoCDSecret(kmc, cfg)
+	require.NoError(t, err)
+	fluxSecret, err := generateFluxSecret(kmc, cfg)
+	require.NoError(t, err)
+	ca := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-ca", Namespace: "tenant-a"},
+		Data:       map[string][]byte{"tls.crt": []byte("ca")},
+	}
+	kmc.Spec.GitOps.Labels["team"] = "a"
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(kmc, ca, argoSecret, fluxSecret).Build()
+	r := &ClusterReconciler{Client: c, Scheme: scheme}
+
+	// No controller pod is needed, the existing credentials are kept without generating new ones
+	require.NoError(t, r.reconcileGitOpsSecrets(context.Background(), kmc))
+	for _, secret := range []*v1.Secret{argoSecret, fluxSecret} {
+		var updated v1.Secret
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(secret), &updated))
+		assert.Equal(t, secret.Data, updated.Data)
+		assert.Equal(t, "a", updated.Labels["team"])
+	}
+}
+
+func TestGitOpsSecretKeysReferenceGrant(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant-a", Finalizers: []string{gitopsFinalizer}},
+		Spec: km.ClusterSpec{
+			GitOps: km.GitOpsSpec{ArgoCD: true, Flux: true, FluxNamespace: "flux-system"},
+		},
+	}
+	grant := &km.ReferenceGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "k0smotron-clusters", Namespace: "argocd"},
+		Spec: km.ReferenceGrantSpec{
+			From: []km.ReferenceGrantFrom{{Group: "k0smotron.io", Kind: "Cluster", Namespace: "tenant-a"}},
+			To:   []km.ReferenceGrantTo{{Group: "", Kind: "Secret"}},
+		},
+	}
+	fluxSecret := newGitOpsSecret(kmc, "flux-system", kmc.GetFluxKubeconfigSecretName())
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(kmc, grant, fluxSecret).Build()
+	r := &ClusterReconciler{Client: c, Scheme: scheme}
+
+	// Only the Argo CD secret is granted
+	desired, err := r.gitOpsSecretKeys(context.Background(), kmc)
+	require.NoError(t, err)
+	assert.Equal(t, map[client.ObjectKey]bool{argoCDSecretKey(kmc): true}, desired)
+
+	// Without a grant, the existing Flux secret is deleted and the cluster released
+	kmc.Spec.GitOps.ArgoCD = false
+	require.NoError(t, r.reconcileGitOpsSecrets(context.Background(), kmc))
+	err = c.Get(context.Background(), client.ObjectKeyFromObject(fluxSecret), &v1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.NotContains(t, kmc.Finalizers, gitopsFinalizer)
+
+	// The secrets in the namespace of the cluster don't need a grant
+	kmc.Spec.GitOps = km.GitOpsSpec{Flux: true}
+	desired, err = r.gitOpsSecretKeys(context.Background(), kmc)
+	require.NoError(t, err)
+	assert.Equal(t, map[client.ObjectKey]bool{fluxSecretKey(kmc): true}, desired)
+}
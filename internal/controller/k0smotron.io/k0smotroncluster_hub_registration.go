@@ -0,0 +1,297 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/kubeconfig"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+const (
+	// hubRegistrationLabel labels the objects applied to the cluster and to the hub with the type of the registration.
+	hubRegistrationLabel = "k0smotron.io/hub-registration"
+	// hubRegistrationFieldOwner is the field manager of the applied objects.
+	hubRegistrationFieldOwner = "k0smotron-hub-registration"
+
+	// ocmAgentNamespace is the namespace of the klusterlet agent, created by the klusterlet operator.
+	ocmAgentNamespace = "open-cluster-management-agent"
+	// ocmBootstrapSecretName is the secret holding the bootstrap kubeconfig the klusterlet registers with.
+	ocmBootstrapSecretName = "bootstrap-hub-kubeconfig"
+
+	// rancherAgentNamespace and rancherAgentName identify the agent deployment of the Rancher registration manifest.
+	rancherAgentNamespace = "cattle-system"
+	rancherAgentName      = "cattle-cluster-agent"
+)
+
+// hubRegistrar registers a cluster in a fleet manager.
+type hubRegistrar interface {
+	// register installs the agent of the fleet manager into the cluster or registers the cluster in the hub.
+	register(ctx context.Context, r *ClusterReconciler, kmc *km.Cluster, reg km.HubRegistration, childClient client.Client) error
+}
+
+// hubAgentChart is implemented by the registrars installing their agent with a Helm chart of the k0s extensions.
+type hubAgentChart interface {
+	agentChart(kmc *km.Cluster, reg km.HubRegistration) (km.HelmRepository, km.HelmChart, error)
+}
+
+var hubRegistrars = map[string]hubRegistrar{
+	"ocm":     ocmRegistrar{},
+	"rancher": rancherRegistrar{},
+	"sveltos": sveltosRegistrar{},
+}
+
+// reconcileHubRegistrations registers the cluster in the fleet managers once its API is reachable and sets the
+// HubRegistered condition. The registrations are applied on every reconciliation, so the credentials rotated in the
+// referenced Secrets are applied too.
+func (r *ClusterReconciler) reconcileHubRegistrations(ctx context.Context, kmc *km.Cluster) {
+	if len(kmc.Spec.HubRegistrations) == 0 {
+		meta.RemoveStatusCondition(&kmc.Status.Conditions, km.HubRegisteredCondition)
+		return
+	}
+	if !meta.IsStatusConditionTrue(kmc.Status.Conditions, km.APIReachableCondition) {
+		setCondition(kmc, km.HubRegisteredCondition, metav1.ConditionUnknown, km.WaitingForAPIReason, "Waiting for the API server to be reachable")
+		return
+	}
+
+	if err := r.registerHubs(ctx, kmc); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to register the cluster in the hubs")
+		kutil.RecordEvent(r.Recorder, kmc, v1.EventTypeWarning, kutil.ReconcileFailedReason, "Failed registering the cluster in the hubs: %v", err)
+		setCondition(kmc, km.HubRegisteredCondition, metav1.ConditionFalse, km.HubNotRegisteredReason, err.Error())
+		return
+	}
+	setCondition(kmc, km.HubRegisteredCondition, metav1.ConditionTrue, km.AvailableReason, "")
+}
+
+func (r *ClusterReconciler) registerHubs(ctx context.Context, kmc *km.Cluster) error {
+	childClient, err := r.newChildClusterClient(ctx, kmc)
+	if err != nil {
+		return fmt.Errorf("failed to create workload cluster client: %w", err)
+	}
+
+	for _, reg := range kmc.Spec.HubRegistrations {
+		registrar, ok := hubRegistrars[reg.Type]
+		if !ok {
+			return fmt.Errorf("unsupported hub registration %s", reg.Type)
+		}
+		if err := registrar.register(ctx, r, kmc, reg, childClient); err != nil {
+			return fmt.Errorf("failed to register the cluster in %s: %w", reg.Type, err)
+		}
+	}
+	return nil
+}
+
+// hubClusterName returns the name of the cluster in the hub.
+func hubClusterName(kmc *km.Cluster, reg km.HubRegistration) string {
+	if reg.ClusterName != "" {
+		return reg.ClusterName
+	}
+	return kmc.Name
+}
+
+// hubCredentials returns the key of the Secret referenced by the registration. Nil is returned if the registration
+// has no Secret and the key is optional.
+func (r *ClusterReconciler) hubCredentials(ctx context.Context, kmc *km.Cluster, reg km.HubRegistration, key string, optional bool) ([]byte, error) {
+	if reg.SecretRef == nil {
+		if optional {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("secretRef must be set")
+	}
+
+	var secret v1.Secret
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: kmc.Namespace, Name: reg.SecretRef.Name}, &secret); err != nil {
+		return nil, err
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no key %s", secret.Name, key)
+	}
+	return value, nil
+}
+
+// applyHubRegistrationObject applies an object of the registration with server-side apply.
+func applyHubRegistrationObject(ctx context.Context, c client.Client, reg km.HubRegistration, obj client.Object) error {
+	return applyObject(ctx, c, obj, hubRegistrationFieldOwner, hubRegistrationLabel, reg.Type)
+}
+
+// ocmRegistrar installs the open-cluster-management klusterlet with its Helm chart and provides the bootstrap
+// kubeconfig of the hub to it. The cluster joins the hub once its ManagedCluster is accepted on the hub.
+type ocmRegistrar struct{}
+
+func (ocmRegistrar) agentChart(kmc *km.Cluster, reg km.HubRegistration) (km.HelmRepository, km.HelmChart, error) {
+	values, err := json.Marshal(map[string]interface{}{
+		"klusterlet": map[string]interface{}{"clusterName": hubClusterName(kmc, reg)},
+	})
+	if err != nil {
+		return km.HelmRepository{}, km.HelmChart{}, err
+	}
+	return km.HelmRepository{Name: "ocm", URL: "https://open-cluster-management.io/helm-charts"},
+		km.HelmChart{
+			Name:      "klusterlet",
+			ChartName: "ocm/klusterlet",
+			Namespace: "open-cluster-management",
+			Values:    &runtime.RawExtension{Raw: values},
+		}, nil
+}
+
+func (ocmRegistrar) register(ctx context.Context, r *ClusterReconciler, kmc *km.Cluster, reg km.HubRegistration, childClient client.Client) error {
+	bootstrapKubeconfig, err := r.hubCredentials(ctx, kmc, reg, "kubeconfig", false)
+	if err != nil {
+		return err
+	}
+
+	// The namespace is created by the klusterlet operator, so the secret is created once the chart is installed
+	if err := childClient.Get(ctx, client.ObjectKey{Name: ocmAgentNamespace}, &v1.Namespace{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("waiting for the klusterlet to create the %s namespace", ocmAgentNamespace)
+		}
+		return err
+	}
+
+	return applyHubRegistrationObject(ctx, childClient, reg, &v1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: ocmBootstrapSecretName, Namespace: ocmAgentNamespace},
+		Data:       map[string][]byte{"kubeconfig": bootstrapKubeconfig},
+	})
+}
+
+// rancherRegistrar applies the registration manifest of an imported Rancher cluster. The manifest is applied only
+// until the agent is deployed, as Rancher upgrades the agent afterwards.
+type rancherRegistrar struct{}
+
+func (rancherRegistrar) register(ctx context.Context, r *ClusterReconciler, kmc *km.Cluster, reg km.HubRegistration, childClient client.Client) error {
+	err := childClient.Get(ctx, client.ObjectKey{Namespace: rancherAgentNamespace, Name: rancherAgentName}, &appsv1.Deployment{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	manifestURL, err := r.hubCredentials(ctx, kmc, reg, "manifestURL", false)
+	if err != nil {
+		return err
+	}
+	b, err := downloadManifestBundle(ctx, string(manifestURL))
+	if err != nil {
+		return err
+	}
+	objects, err := decodeManifests([][]byte{b})
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := applyHubRegistrationObject(ctx, childClient, reg, obj); err != nil {
+			return fmt.Errorf("failed to apply %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+	log.FromContext(ctx).Info("Applied the Rancher registration manifest")
+	return nil
+}
+
+// sveltosRegistrar registers the cluster in Sveltos with a SveltosCluster and the kubeconfig secret it refers to.
+// Sveltos deploys its agent to the cluster itself.
+type sveltosRegistrar struct{}
+
+func (sveltosRegistrar) register(ctx context.Context, r *ClusterReconciler, kmc *km.Cluster, reg km.HubRegistration, _ client.Client) error {
+	hubKubeconfig, err := r.hubCredentials(ctx, kmc, reg, "kubeconfig", true)
+	if err != nil {
+		return err
+	}
+	hubClient := r.Client
+	if hubKubeconfig != nil {
+		restConfig, err := clientcmd.RESTConfigFromKubeConfig(hubKubeconfig)
+		if err != nil {
+			return fmt.Errorf("failed to parse the kubeconfig of the hub: %w", err)
+		}
+		hubClient, err = client.New(restConfig, client.Options{Scheme: r.Client.Scheme()})
+		if err != nil {
+			return err
+		}
+	}
+
+	clusterKubeconfig, err := kubeconfig.FromSecret(ctx, r.Client, util.ObjectKey(kmc))
+	if err != nil {
+		return err
+	}
+
+	name := hubClusterName(kmc, reg)
+	namespace := reg.Namespace
+	if namespace == "" {
+		namespace = kmc.Namespace
+	}
+	secret := &v1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: name + "-sveltos-kubeconfig", Namespace: namespace},
+		Data:       map[string][]byte{"kubeconfig": clusterKubeconfig},
+	}
+	// The kubeconfig is only shared with another namespace of the management cluster if a ReferenceGrant allows it
+	if hubKubeconfig == nil && namespace != kmc.Namespace {
+		granted, err := isKubeconfigSecretGranted(ctx, r.Client, kmc, client.ObjectKeyFromObject(secret))
+		if err != nil {
+			return err
+		}
+		if !granted {
+			if err := r.Client.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			return fmt.Errorf("secret %s/%s is not permitted by any ReferenceGrant", namespace, secret.Name)
+		}
+	}
+	sveltosCluster := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"kubeconfigName":    secret.Name,
+			"kubeconfigKeyName": "kubeconfig",
+		},
+	}}
+	sveltosCluster.SetAPIVersion("lib.projectsveltos.io/v1beta1")
+	sveltosCluster.SetKind("SveltosCluster")
+	sveltosCluster.SetName(name)
+	sveltosCluster.SetNamespace(namespace)
+	sveltosCluster.SetLabels(reg.Labels)
+
+	for _, obj := range []client.Object{secret, sveltosCluster} {
+		// The objects in the management cluster are removed with the cluster
+		if hubKubeconfig == nil && namespace == kmc.Namespace {
+			if err := controllerutil.SetOwnerReference(kmc, obj, r.Client.Scheme()); err != nil {
+				return err
+			}
+		}
+		if err := applyHubRegistrationObject(ctx, hubClient, reg, obj); err != nil {
+			return fmt.Errorf("failed to apply %s %s: %w", obj.GetObjectKind().GroupVersionKind().Kind, obj.GetName(), err)
+		}
+	}
+	return nil
+}
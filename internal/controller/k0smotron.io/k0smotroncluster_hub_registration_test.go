@@ -0,0 +1,128 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestSetHubAgentCharts(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: km.ClusterSpec{HubRegistrations: []km.HubRegistration{
+			{Type: "ocm", ClusterName: "edge-1"},
+			{Type: "rancher"},
+			{Type: "sveltos"},
+		}},
+	}
+	k0sConfig := map[string]interface{}{}
+	require.NoError(t, setHubAgentCharts(k0sConfig, kmc))
+
+	repositories, _, err := unstructured.NestedSlice(k0sConfig, "spec", "extensions", "helm", "repositories")
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{map[string]interface{}{"name": "ocm", "url": "https://open-cluster-management.io/helm-charts"}}, repositories)
+
+	charts, _, err := unstructured.NestedSlice(k0sConfig, "spec", "extensions", "helm", "charts")
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"name":      "klusterlet",
+		"chartname": "ocm/klusterlet",
+		"namespace": "open-cluster-management",
+		"values":    "klusterlet:\n  clusterName: edge-1\n",
+	}}, charts)
+}
+
+func TestHubCredentials(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	kmc := &km.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hub", Namespace: "default"},
+		Data:       map[string][]byte{"kubeconfig": []byte("hub-kubeconfig")},
+	}
+	r := &ClusterReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()}
+	ctx := context.Background()
+
+	reg := km.HubRegistration{Type: "ocm", SecretRef: &v1.LocalObjectReference{Name: "hub"}}
+	value, err := r.hubCredentials(ctx, kmc, reg, "kubeconfig", false)
+	require.NoError(t, err)
+	assert.Equal(t, "hub-kubeconfig", string(value))
+
+	_, err = r.hubCredentials(ctx, kmc, reg, "manifestURL", false)
+	assert.Error(t, err)
+
+	value, err = r.hubCredentials(ctx, kmc, km.HubRegistration{Type: "sveltos"}, "kubeconfig", true)
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	_, err = r.hubCredentials(ctx, kmc, km.HubRegistration{Type: "ocm"}, "kubeconfig", false)
+	assert.Error(t, err)
+}
+
+func TestReconcileHubRegistrationsWaitsForAPI(t *testing.T) {
+	r := &ClusterReconciler{}
+	kmc := &km.Cluster{
+		Spec: km.ClusterSpec{HubRegistrations: []km.HubRegistration{{Type: "sveltos"}}},
+	}
+
+	r.reconcileHubRegistrations(context.Background(), kmc)
+	condition := meta.FindStatusCondition(kmc.Status.Conditions, km.HubRegisteredCondition)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionUnknown, condition.Status)
+	assert.Equal(t, km.WaitingForAPIReason, condition.Reason)
+
+	kmc.Spec.HubRegistrations = nil
+	r.reconcileHubRegistrations(context.Background(), kmc)
+	assert.Nil(t, meta.FindStatusCondition(kmc.Status.Conditions, km.HubRegisteredCondition))
+}
+
+func TestSveltosRegistrationReferenceGrant(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	kmc := &km.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	kubeconfigSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-kubeconfig", Namespace: "default"},
+		Data:       map[string][]byte{"value": []byte("kubeconfig")},
+	}
+	existing := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "test-sveltos-kubeconfig", Namespace: "projectsveltos"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(kubeconfigSecret, existing).Build()
+	r := &ClusterReconciler{Client: c, Scheme: scheme}
+
+	// The kubeconfig isn't shared with another namespace without a grant, and the shared one is deleted
+	err := sveltosRegistrar{}.register(context.Background(), r, kmc, km.HubRegistration{Type: "sveltos", Namespace: "projectsveltos"}, nil)
+	require.ErrorContains(t, err, "not permitted by any ReferenceGrant")
+	err = c.Get(context.Background(), client.ObjectKeyFromObject(existing), &v1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
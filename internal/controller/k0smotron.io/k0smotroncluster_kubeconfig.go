@@ -17,36 +17,96 @@ limitations under the License.
 package k0smotronio
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
 
 	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kcutil "github.com/k0sproject/k0smotron/internal/controller/util"
 	"github.com/k0sproject/k0smotron/internal/exec"
+	"github.com/k0sproject/k0smotron/internal/secretstore"
+	"github.com/k0sproject/k0smotron/internal/tracing"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/cert"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/secret"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func (r *ClusterReconciler) reconcileKubeConfigSecret(ctx context.Context, kmc km.Cluster) error {
+// secretStoreFinalizer is set on the clusters storing the admin kubeconfig in an external secret store.
+const secretStoreFinalizer = "k0smotron.io/secret-store"
+
+func (r *ClusterReconciler) reconcileKubeConfigSecret(ctx context.Context, kmc *km.Cluster) error {
 	logger := log.FromContext(ctx)
-	pod, err := r.findStatefulSetPod(ctx, kmc.GetStatefulSetName(), kmc.Namespace)
 
+	store, err := r.SecretStores.Get(kmc.GetSecretStoreProvider())
+	if err != nil {
+		return err
+	}
+	// The external secret is not garbage collected, so the finalizer must be set before writing it
+	if store != nil && !controllerutil.ContainsFinalizer(kmc, secretStoreFinalizer) {
+		patch := client.MergeFrom(kmc.DeepCopy())
+		controllerutil.AddFinalizer(kmc, secretStoreFinalizer)
+		if err := r.Client.Patch(ctx, kmc, patch); err != nil {
+			return err
+		}
+	}
+
+	if store != nil {
+		if err := r.deleteKubeConfigSecret(ctx, kmc); err != nil {
+			return err
+		}
+	}
+
+	caCert, err := r.clusterCACert(ctx, kmc)
+	if err != nil {
+		return err
+	}
+	existing, err := r.adminKubeConfig(ctx, kmc, store)
+	if err != nil {
+		return err
+	}
+	if existing != nil && kubeconfigCurrent(existing, kmc, caCert) {
+		// Every generated kubeconfig has a new client certificate, so it's generated only when the existing one is
+		// missing or stale
+		logger.V(1).Info("Kubeconfig up to date")
+		return nil
+	}
+
+	pod, err := r.findStatefulSetPod(ctx, kmc.GetStatefulSetName(), kmc.Namespace)
 	if err != nil {
 		return err
 	}
 
-	output, err := exec.PodExecCmdOutput(ctx, r.ClientSet, r.RESTConfig, pod.Name, kmc.Namespace, "k0s kubeconfig create admin --groups system:masters")
+	cmd := "k0s kubeconfig create admin --groups system:masters"
+	output, err := exec.PodExecCmdOutput(ctx, r.ClientSet, r.RESTConfig, pod.Name, client.ObjectKeyFromObject(kmc), cmd)
+	// Only the generation of a new kubeconfig is audited, its output is the admin kubeconfig so it is left out
+	kcutil.AuditCommand(r.Recorder, kmc, pod.Name, cmd, "", err)
 	if err != nil {
 		return err
 	}
 
-	output, _, err = replaceKubeconfigPort(output, kmc)
+	output, _, err = replaceKubeconfigPort(output, *kmc)
 	if err != nil {
 		return err
 	}
 
+	if store != nil {
+		logger.Info("Kubeconfig generated, writing it to the secret store")
+		return store.Put(ctx, secretstore.Key{Namespace: kmc.Namespace, Name: kmc.GetAdminConfigSecretName()}, map[string]string{"value": output})
+	}
+
 	logger.Info("Kubeconfig generated, creating the secret")
 
 	secret := v1.Secret{
@@ -58,16 +118,151 @@ func (r *ClusterReconciler) reconcileKubeConfigSecret(ctx context.Context, kmc k
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        kmc.GetAdminConfigSecretName(),
 			Namespace:   kmc.Namespace,
-			Labels:      labelsForCluster(&kmc),
-			Annotations: annotationsForCluster(&kmc),
+			Labels:      labelsForCluster(kmc),
+			Annotations: annotationsForCluster(kmc),
 		},
 		StringData: map[string]string{"value": output},
 		Type:       clusterv1.ClusterSecretType,
 	}
 
-	if err = ctrl.SetControllerReference(&kmc, &secret, r.Scheme); err != nil {
+	if err = ctrl.SetControllerReference(kmc, &secret, r.Scheme); err != nil {
 		return err
 	}
 
-	return r.Client.Patch(ctx, &secret, client.Apply, patchOpts...)
+	return kcutil.ApplySecret(ctx, r.Client, &secret, patchOpts...)
+}
+
+// deleteKubeConfigSecret deletes the admin kubeconfig Secret written before the cluster was switched to an external
+// secret store, so it's not left behind with credentials that are no longer renewed. The Secrets not controlled by the
+// cluster are left as they are.
+func (r *ClusterReconciler) deleteKubeConfigSecret(ctx context.Context, kmc *km.Cluster) error {
+	var existing v1.Secret
+	err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetAdminConfigSecretName(), Namespace: kmc.Namespace}, &existing)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(&existing, kmc) {
+		return nil
+	}
+	log.FromContext(ctx).Info("Kubeconfig written to the secret store, deleting the kubeconfig secret")
+	return client.IgnoreNotFound(r.Client.Delete(ctx, &existing))
+}
+
+// adminKubeConfig returns the admin kubeconfig stored in the Kubernetes Secret or in the external secret store, or nil
+// if it's not stored yet.
+func (r *ClusterReconciler) adminKubeConfig(ctx context.Context, kmc *km.Cluster, store secretstore.Store) ([]byte, error) {
+	if store != nil {
+		data, err := store.Get(ctx, secretstore.Key{Namespace: kmc.Namespace, Name: kmc.GetAdminConfigSecretName()}.Path())
+		if errors.Is(err, secretstore.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubeconfig from secret store: %w", err)
+		}
+		return []byte(data["value"]), nil
+	}
+
+	var existing v1.Secret
+	err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetAdminConfigSecretName(), Namespace: kmc.Namespace}, &existing)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return existing.Data["value"], nil
+}
+
+// newChildClusterClient returns a client to the API of the cluster using the admin kubeconfig, which is read from
+// the external secret store if the cluster uses one. The requests are traced.
+func (r *ClusterReconciler) newChildClusterClient(ctx context.Context, kmc *km.Cluster) (client.Client, error) {
+	store, err := r.SecretStores.Get(kmc.GetSecretStoreProvider())
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return tracing.NewClusterClient(ctx, "k0smotron", r.Client, util.ObjectKey(kmc))
+	}
+
+	kubeconfig, err := r.adminKubeConfig(ctx, kmc, store)
+	if err != nil {
+		return nil, err
+	}
+	if kubeconfig == nil {
+		return nil, fmt.Errorf("kubeconfig %s not found in secret store", kmc.GetAdminConfigSecretName())
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return client.New(tracing.WrapRESTConfig(restConfig, kmc.Name), client.Options{Scheme: r.Client.Scheme()})
+}
+
+// kubeconfigRenewBefore is how long before the expiry of its client certificate the admin kubeconfig is replaced.
+const kubeconfigRenewBefore = 30 * 24 * time.Hour
+
+// kubeconfigCurrent returns whether the kubeconfig connects to the API endpoint of the cluster, trusts the cluster CA
+// and its client certificate is not about to expire, so a new one doesn't need to be generated. The CA is not
+// compared if it's not known.
+func kubeconfigCurrent(kubeconfig []byte, kmc *km.Cluster, caCert []byte) bool {
+	cfg, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return false
+	}
+	cluster, authInfo, err := currentClusterAndAuthInfo(cfg)
+	if err != nil {
+		return false
+	}
+	return serverCurrent(cluster.Server, kmc) &&
+		(caCert == nil || bytes.Equal(bytes.TrimSpace(cluster.CertificateAuthorityData), bytes.TrimSpace(caCert))) &&
+		clientCertificateValid(authInfo.ClientCertificateData)
+}
+
+// serverCurrent returns whether the server URL of a kubeconfig points to the API port and the external address, if
+// known, of the cluster, as set by replaceKubeconfigPort.
+func serverCurrent(server string, kmc *km.Cluster) bool {
+	u, err := url.Parse(server)
+	if err != nil {
+		return false
+	}
+	if u.Port() != strconv.Itoa(kmc.Spec.Service.APIPort) {
+		return false
+	}
+	return kmc.Spec.ExternalAddress == "" || u.Hostname() == kmc.Spec.ExternalAddress
+}
+
+// clusterCACert returns the PEM encoded CA certificate of the cluster, or nil if the CA is not managed in a secret.
+func (r *ClusterReconciler) clusterCACert(ctx context.Context, kmc *km.Cluster) ([]byte, error) {
+	var ca v1.Secret
+	err := r.Client.Get(ctx, client.ObjectKey{Name: clusterCASecretName(kmc), Namespace: kmc.Namespace}, &ca)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the cluster CA: %w", err)
+	}
+	return ca.Data[secret.TLSCrtDataName], nil
+}
+
+// clientCertificateValid returns whether the PEM encoded client certificate is not about to expire.
+func clientCertificateValid(data []byte) bool {
+	certs, err := cert.ParseCertsPEM(data)
+	return err == nil && time.Until(certs[0].NotAfter) >= kubeconfigRenewBefore
+}
+
+// deleteStoredKubeConfig removes the admin kubeconfig from the external secret store and releases the cluster.
+func (r *ClusterReconciler) deleteStoredKubeConfig(ctx context.Context, kmc *km.Cluster) error {
+	store, err := r.SecretStores.Get(kmc.GetSecretStoreProvider())
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		if err := store.Delete(ctx, secretstore.Key{Namespace: kmc.Namespace, Name: kmc.GetAdminConfigSecretName()}); err != nil {
+			return fmt.Errorf("failed to delete kubeconfig from secret store: %w", err)
+		}
+	}
+
+	patch := client.MergeFrom(kmc.DeepCopy())
+	controllerutil.RemoveFinalizer(kmc, secretStoreFinalizer)
+	return r.Client.Patch(ctx, kmc, patch)
 }
@@ -0,0 +1,228 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/secretstore"
+)
+
+// newAdminKubeconfig returns an admin kubeconfig connecting to the server with a client certificate valid for the
+// given time.
+func newAdminKubeconfig(t *testing.T, server string, ca []byte, validity time.Duration) []byte {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	caCert, err := cert.NewSelfSignedCACert(cert.Config{CommonName: "admin"}, caKey)
+	require.NoError(t, err)
+	// The client certificate is self-signed, only its validity is checked
+	template := *caCert
+	template.NotAfter = time.Now().Add(validity)
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, caKey.Public(), caKey)
+	require.NoError(t, err)
+
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(caKey)
+	require.NoError(t, err)
+
+	cfg := api.NewConfig()
+	cfg.Clusters["k0s"] = &api.Cluster{Server: server, CertificateAuthorityData: ca}
+	cfg.AuthInfos["admin"] = &api.AuthInfo{
+		ClientCertificateData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		ClientKeyData:         keyPEM,
+	}
+	cfg.Contexts["admin@k0s"] = &api.Context{Cluster: "k0s", AuthInfo: "admin"}
+	cfg.CurrentContext = "admin@k0s"
+	b, err := clientcmd.Write(*cfg)
+	require.NoError(t, err)
+	return b
+}
+
+func TestKubeconfigCurrent(t *testing.T) {
+	kmc := &km.Cluster{Spec: km.ClusterSpec{ExternalAddress: "10.0.0.1", Service: km.ServiceSpec{APIPort: 30443}}}
+	year := 365 * 24 * time.Hour
+
+	assert.True(t, kubeconfigCurrent(newAdminKubeconfig(t, "https://10.0.0.1:30443", []byte("ca\n"), year), kmc, []byte("ca")))
+	// The CA is not compared if it's not known
+	assert.True(t, kubeconfigCurrent(newAdminKubeconfig(t, "https://10.0.0.1:30443", []byte("ca"), year), kmc, nil))
+	assert.False(t, kubeconfigCurrent(newAdminKubeconfig(t, "https://10.0.0.1:30443", []byte("old-ca"), year), kmc, []byte("ca")))
+	assert.False(t, kubeconfigCurrent(newAdminKubeconfig(t, "https://10.0.0.1:31443", []byte("ca"), year), kmc, []byte("ca")))
+	assert.False(t, kubeconfigCurrent(newAdminKubeconfig(t, "https://10.0.0.2:30443", []byte("ca"), year), kmc, []byte("ca")))
+	assert.False(t, kubeconfigCurrent(newAdminKubeconfig(t, "https://10.0.0.1:30443", []byte("ca"), 24*time.Hour), kmc, []byte("ca")))
+	assert.False(t, kubeconfigCurrent(nil, kmc, []byte("ca")))
+
+	// Without an external address, k0s sets the host
+	kmc.Spec.ExternalAddress = ""
+	assert.True(t, kubeconfigCurrent(newAdminKubeconfig(t, "https://10.0.0.2:30443", []byte("ca"), year), kmc, []byte("ca")))
+}
+
+func TestReconcileKubeConfigSecret_upToDate(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "clusters"},
+		Spec:       km.ClusterSpec{ExternalAddress: "10.0.0.1", Service: km.ServiceSpec{APIPort: 30443}},
+	}
+	kubeconfig := newAdminKubeconfig(t, "https://10.0.0.1:30443", []byte("ca"), 365*24*time.Hour)
+	c := newJoinTokenRequestTestClient(t, kmc,
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-cluster-ca", Namespace: "clusters"},
+			Data:       map[string][]byte{"tls.crt": []byte("ca")},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: kmc.GetAdminConfigSecretName(), Namespace: "clusters"},
+			Data:       map[string][]byte{"value": kubeconfig},
+		},
+	)
+
+	// No controller pod is needed, the existing kubeconfig is kept without generating a new one
+	recorder := record.NewFakeRecorder(10)
+	r := &ClusterReconciler{Client: c, Scheme: c.Scheme(), Recorder: recorder}
+	require.NoError(t, r.reconcileKubeConfigSecret(context.Background(), kmc))
+	// No command runs, so none is audited
+	assert.Empty(t, recorder.Events)
+
+	var secret v1.Secret
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: kmc.GetAdminConfigSecretName(), Namespace: "clusters"}, &secret))
+	assert.Equal(t, kubeconfig, secret.Data["value"])
+}
+
+func TestReconcileKubeConfigSecret_secretStoreUpToDate(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "clusters", Finalizers: []string{secretStoreFinalizer}},
+		Spec: km.ClusterSpec{
+			ExternalAddress: "10.0.0.1",
+			Service:         km.ServiceSpec{APIPort: 30443},
+			SecretStore:     &km.SecretStoreSpec{Provider: secretstore.ProviderVault},
+		},
+	}
+	c := newJoinTokenRequestTestClient(t, kmc)
+
+	key := secretstore.Key{Namespace: "clusters", Name: kmc.GetAdminConfigSecretName()}
+	caPEM, _, err := cert.GenerateSelfSignedCertKey("ca", nil, nil)
+	require.NoError(t, err)
+	kubeconfig := string(newAdminKubeconfig(t, "https://10.0.0.1:30443", caPEM, 365*24*time.Hour))
+	store := &fakeSecretStore{data: map[secretstore.Key]map[string]string{key: {"value": kubeconfig}}}
+	stores := secretstore.NewRegistry(secretstore.ProviderKubernetes)
+	stores.Register(secretstore.ProviderVault, store)
+
+	// No controller pod is needed, the stored kubeconfig is kept without generating a new one
+	r := &ClusterReconciler{Client: c, Scheme: c.Scheme(), SecretStores: stores}
+	require.NoError(t, r.reconcileKubeConfigSecret(context.Background(), kmc))
+	assert.Equal(t, kubeconfig, store.data[key]["value"])
+
+	// The clients to the cluster are created from the stored kubeconfig
+	_, err = r.newChildClusterClient(context.Background(), kmc)
+	require.NoError(t, err)
+	delete(store.data, key)
+	_, err = r.newChildClusterClient(context.Background(), kmc)
+	assert.ErrorContains(t, err, "kubeconfig my-cluster-kubeconfig not found in secret store")
+}
+
+func TestReconcileKubeConfigSecret_secretStoreSwitch(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "clusters", UID: "kmc-uid", Finalizers: []string{secretStoreFinalizer}},
+		Spec: km.ClusterSpec{
+			ExternalAddress: "10.0.0.1",
+			Service:         km.ServiceSpec{APIPort: 30443},
+			SecretStore:     &km.SecretStoreSpec{Provider: secretstore.ProviderVault},
+		},
+	}
+	kubeconfig := newAdminKubeconfig(t, "https://10.0.0.1:30443", nil, 365*24*time.Hour)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: kmc.GetAdminConfigSecretName(), Namespace: "clusters"},
+		Data:       map[string][]byte{"value": kubeconfig},
+	}
+	c := newJoinTokenRequestTestClient(t, kmc, secret)
+
+	key := secretstore.Key{Namespace: "clusters", Name: kmc.GetAdminConfigSecretName()}
+	store := &fakeSecretStore{data: map[secretstore.Key]map[string]string{key: {"value": string(kubeconfig)}}}
+	stores := secretstore.NewRegistry(secretstore.ProviderKubernetes)
+	stores.Register(secretstore.ProviderVault, store)
+	r := &ClusterReconciler{Client: c, Scheme: c.Scheme(), SecretStores: stores}
+
+	// The secret not written by k0smotron is kept
+	require.NoError(t, r.reconcileKubeConfigSecret(context.Background(), kmc))
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(secret), secret))
+
+	// The secret written before the switch to the secret store is deleted
+	require.NoError(t, ctrl.SetControllerReference(kmc, secret, c.Scheme()))
+	require.NoError(t, c.Update(context.Background(), secret))
+	require.NoError(t, r.reconcileKubeConfigSecret(context.Background(), kmc))
+	assert.True(t, apierrors.IsNotFound(c.Get(context.Background(), client.ObjectKeyFromObject(secret), &v1.Secret{})))
+	assert.Equal(t, string(kubeconfig), store.data[key]["value"])
+}
+
+func TestReconcileKubeConfigSecret_secretStoreFinalizer(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "clusters"},
+		Spec:       km.ClusterSpec{SecretStore: &km.SecretStoreSpec{Provider: secretstore.ProviderVault}},
+	}
+	c := newJoinTokenRequestTestClient(t, kmc)
+
+	key := secretstore.Key{Namespace: "clusters", Name: kmc.GetAdminConfigSecretName()}
+	store := &fakeSecretStore{data: map[secretstore.Key]map[string]string{}}
+	stores := secretstore.NewRegistry(secretstore.ProviderKubernetes)
+	stores.Register(secretstore.ProviderVault, store)
+
+	// The API server has no controller pods, so the kubeconfig cannot be generated
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","items":[]}`))
+	}))
+	defer srv.Close()
+	clientSet, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	require.NoError(t, err)
+
+	r := &ClusterReconciler{Client: c, Scheme: c.Scheme(), ClientSet: clientSet, SecretStores: stores}
+
+	// The finalizer is set on the cluster of the caller, so its later patches keep it
+	assert.Error(t, r.reconcileKubeConfigSecret(context.Background(), kmc))
+	assert.True(t, controllerutil.ContainsFinalizer(kmc, secretStoreFinalizer))
+	var stored km.Cluster
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(kmc), &stored))
+	assert.True(t, controllerutil.ContainsFinalizer(&stored, secretStoreFinalizer))
+
+	store.data[key] = map[string]string{"value": "kubeconfig"}
+	require.NoError(t, r.deleteStoredKubeConfig(context.Background(), kmc))
+	assert.Empty(t, store.data)
+	assert.False(t, controllerutil.ContainsFinalizer(kmc, secretStoreFinalizer))
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(kmc), &stored))
+	assert.False(t, controllerutil.ContainsFinalizer(&stored, secretStoreFinalizer))
+}
@@ -0,0 +1,360 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/controller/util"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// loggingConfigHashAnnotation is set on the pod templates to roll the pods when the logging config changes,
+	// as the log forwarder doesn't reload it.
+	loggingConfigHashAnnotation = "k0smotron.io/logging-config-hash"
+	// logDir is the mount path of the volume the control plane components write their logs to for the log forwarder.
+	logDir            = "/var/log/k0smotron"
+	logVolumeName     = "k0smotron-logs"
+	logForwarderName  = "log-forwarder"
+	fluentBitConfDir  = "/fluent-bit/etc/k0smotron"
+	fluentBitConfName = "fluent-bit.conf"
+	// maxLogFileSize is the size the log files are truncated at. The logs are forwarded as they are written, so
+	// the files only need to hold the logs not yet forwarded.
+	maxLogFileSize = 100 * 1024 * 1024
+
+	logOutputLoki       = "loki"
+	logOutputSyslog     = "syslog"
+	logOutputCloudWatch = "cloudwatch"
+)
+
+// logFile returns the path of the log file of the component in the volume shared with the log forwarder.
+func logFile(component string) string {
+	return path.Join(logDir, component+".log")
+}
+
+// logTruncationScript returns the shell script truncating the log file in the background once it gets too big.
+func logTruncationScript(file string) string {
+	return fmt.Sprintf(`# Truncate the log file once it takes %[2]d bytes, as the logs are forwarded anyway
+touch %[1]s
+(while sleep 60; do
+  if [ "$(wc -c < %[1]s)" -gt %[2]d ]; then
+    : > %[1]s
+  fi
+done) &
+`, file, maxLogFileSize)
+}
+
+// logOutputEnvName returns the name of the environment variable of the log forwarder holding the secret of the
+// i-th output.
+func logOutputEnvName(i int, secret string) string {
+	return fmt.Sprintf("LOG_OUTPUT_%d_%s", i, strings.ToUpper(secret))
+}
+
+// fluentBitSection is a section of the classic fluent-bit config format.
+type fluentBitSection struct {
+	name    string
+	entries [][2]string
+}
+
+func (s *fluentBitSection) set(key, value string) {
+	s.entries = append(s.entries, [2]string{key, value})
+}
+
+// generateFluentBitConf generates the config of the log forwarder. The secrets of the outputs are read from the
+// environment, so the config doesn't contain them.
+func generateFluentBitConf(kmc *km.Cluster) (string, error) {
+	service := fluentBitSection{name: "SERVICE"}
+	service.set("Flush", "1")
+	service.set("Log_Level", "warn")
+
+	input := fluentBitSection{name: "INPUT"}
+	input.set("Name", "tail")
+	input.set("Path", path.Join(logDir, "*.log"))
+	input.set("Tag", "${HOSTNAME}")
+	input.set("DB", path.Join(logDir, "fluent-bit.db"))
+	input.set("Mem_Buf_Limit", "16MB")
+	input.set("Skip_Long_Lines", "On")
+
+	labels := make([]string, 0, len(kmc.Spec.Logging.Labels))
+	for name := range kmc.Spec.Logging.Labels {
+		if !prometheusLabelNameRe.MatchString(name) {
+			return "", fmt.Errorf("invalid logging label name %q", name)
+		}
+		labels = append(labels, name)
+	}
+	sort.Strings(labels)
+	filter := fluentBitSection{name: "FILTER"}
+	filter.set("Name", "record_modifier")
+	filter.set("Match", "*")
+	filter.set("Record", "k0smotron_cluster "+kmc.Name)
+	filter.set("Record", "k0smotron_namespace "+kmc.Namespace)
+	filter.set("Record", "component ${K0SMOTRON_COMPONENT}")
+	filter.set("Record", "pod ${HOSTNAME}")
+	for _, name := range labels {
+		filter.set("Record", name+" "+kmc.Spec.Logging.Labels[name])
+	}
+
+	sections := []fluentBitSection{service, input, filter}
+	if len(kmc.Spec.Logging.Outputs) == 0 {
+		return "", fmt.Errorf("no log outputs defined")
+	}
+	awsCredentialsSecretName := ""
+	for i, o := range kmc.Spec.Logging.Outputs {
+		output, err := fluentBitOutput(kmc, i, o, labels)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s log output: %w", o.Type, err)
+		}
+		sections = append(sections, output)
+
+		// The AWS credentials are read from the environment, so all the outputs must share them
+		if o.CloudWatch != nil && o.CloudWatch.CredentialsSecretName != "" {
+			if awsCredentialsSecretName != "" && awsCredentialsSecretName != o.CloudWatch.CredentialsSecretName {
+				return "", fmt.Errorf("all the cloudwatch log outputs must use the same credentials secret")
+			}
+			awsCredentialsSecretName = o.CloudWatch.CredentialsSecretName
+		}
+	}
+
+	var b strings.Builder
+	for _, s := range sections {
+		fmt.Fprintf(&b, "[%s]\n", s.name)
+		for _, e := range s.entries {
+			if strings.ContainsAny(e[1], "\r\n") {
+				return "", fmt.Errorf("invalid logging config value %q", e[1])
+			}
+			fmt.Fprintf(&b, "    %-20s %s\n", e[0], e[1])
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+func fluentBitOutput(kmc *km.Cluster, i int, o km.LogOutput, labels []string) (fluentBitSection, error) {
+	output := fluentBitSection{name: "OUTPUT"}
+	switch o.Type {
+	case logOutputLoki:
+		if o.Loki == nil {
+			return output, fmt.Errorf("loki must be set")
+		}
+		u, err := url.Parse(o.Loki.URL)
+		if err != nil || u.Hostname() == "" {
+			return output, fmt.Errorf("invalid loki URL %q", o.Loki.URL)
+		}
+		port := u.Port()
+		tls := u.Scheme == "https"
+		if port == "" {
+			port = "80"
+			if tls {
+				port = "443"
+			}
+		}
+		output.set("Name", "loki")
+		output.set("Match", "*")
+		output.set("Host", u.Hostname())
+		output.set("Port", port)
+		output.set("Uri", u.RequestURI())
+		if tls {
+			output.set("Tls", "On")
+		}
+		if o.Loki.TenantID != "" {
+			output.set("Tenant_ID", o.Loki.TenantID)
+		}
+		if o.Loki.BearerTokenSecretName != "" && o.Loki.BasicAuth != nil {
+			return output, fmt.Errorf("can't use both a bearer token and basic auth")
+		}
+		if o.Loki.BearerTokenSecretName != "" {
+			output.set("Bearer_Token", "${"+logOutputEnvName(i, monitoringTokenKey)+"}")
+		}
+		if o.Loki.BasicAuth != nil {
+			output.set("Http_User", o.Loki.BasicAuth.Username)
+			output.set("Http_Passwd", "${"+logOutputEnvName(i, remoteWritePasswordKey)+"}")
+		}
+		output.set("Labels", "job=k0smotron")
+		labelKeys := []string{"$k0smotron_cluster", "$k0smotron_namespace", "$component", "$pod"}
+		for _, name := range labels {
+			labelKeys = append(labelKeys, "$"+name)
+		}
+		output.set("Label_Keys", strings.Join(labelKeys, ","))
+	case logOutputSyslog:
+		if o.Syslog == nil {
+			return output, fmt.Errorf("syslog must be set")
+		}
+		port, mode, format := o.Syslog.Port, o.Syslog.Mode, o.Syslog.Format
+		if port == 0 {
+			port = 514
+		}
+		if mode == "" {
+			mode = "udp"
+		}
+		if format == "" {
+			format = "rfc5424"
+		}
+		output.set("Name", "syslog")
+		output.set("Match", "*")
+		output.set("Host", o.Syslog.Host)
+		output.set("Port", strconv.Itoa(port))
+		output.set("Mode", mode)
+		output.set("Syslog_Format", format)
+		output.set("Syslog_Hostname_Key", "pod")
+		output.set("Syslog_Appname_Key", "component")
+		output.set("Syslog_Message_Key", "log")
+	case logOutputCloudWatch:
+		if o.CloudWatch == nil {
+			return output, fmt.Errorf("cloudWatch must be set")
+		}
+		prefix := o.CloudWatch.LogStreamPrefix
+		if prefix == "" {
+			prefix = fmt.Sprintf("%s.%s.", kmc.Namespace, kmc.Name)
+		}
+		output.set("Name", "cloudwatch_logs")
+		output.set("Match", "*")
+		output.set("region", o.CloudWatch.Region)
+		output.set("log_group_name", o.CloudWatch.LogGroupName)
+		output.set("log_stream_prefix", prefix)
+		output.set("auto_create_group", "On")
+	default:
+		return output, fmt.Errorf("unknown log output type")
+	}
+	return output, nil
+}
+
+// logForwarderEnv returns the environment of the log forwarder, holding the component and the secrets of the
+// outputs.
+func logForwarderEnv(kmc *km.Cluster, component string) []v1.EnvVar {
+	env := []v1.EnvVar{{Name: "K0SMOTRON_COMPONENT", Value: component}}
+	secretEnv := func(name, secretName, key string) v1.EnvVar {
+		return v1.EnvVar{Name: name, ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: secretName},
+			Key:                  key,
+		}}}
+	}
+
+	awsCredentials := false
+	for i, o := range kmc.Spec.Logging.Outputs {
+		switch {
+		case o.Loki != nil && o.Loki.BearerTokenSecretName != "":
+			env = append(env, secretEnv(logOutputEnvName(i, monitoringTokenKey), o.Loki.BearerTokenSecretName, monitoringTokenKey))
+		case o.Loki != nil && o.Loki.BasicAuth != nil:
+			env = append(env, secretEnv(logOutputEnvName(i, remoteWritePasswordKey), o.Loki.BasicAuth.PasswordSecretName, remoteWritePasswordKey))
+		case o.CloudWatch != nil && o.CloudWatch.CredentialsSecretName != "" && !awsCredentials:
+			// The outputs share the credentials, which is validated when generating the config
+			env = append(env,
+				secretEnv("AWS_ACCESS_KEY_ID", o.CloudWatch.CredentialsSecretName, "AWS_ACCESS_KEY_ID"),
+				secretEnv("AWS_SECRET_ACCESS_KEY", o.CloudWatch.CredentialsSecretName, "AWS_SECRET_ACCESS_KEY"))
+			awsCredentials = true
+		}
+	}
+	return env
+}
+
+// addLogForwarder adds the log forwarder sidecar to the pod template. The first container of the pod writes the
+// logs of the component to the volume shared with the sidecar.
+func addLogForwarder(kmc *km.Cluster, podTemplate *v1.PodTemplateSpec, component string) {
+	podTemplate.Spec.Volumes = append(podTemplate.Spec.Volumes, v1.Volume{
+		Name:         logVolumeName,
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+	}, v1.Volume{
+		Name: kmc.GetLoggingConfigMapName(),
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: kmc.GetLoggingConfigMapName()},
+			},
+		},
+	})
+	podTemplate.Spec.Containers[0].VolumeMounts = append(podTemplate.Spec.Containers[0].VolumeMounts, v1.VolumeMount{
+		Name:      logVolumeName,
+		MountPath: logDir,
+	})
+	podTemplate.Spec.Containers = append(podTemplate.Spec.Containers, v1.Container{
+		Name:            logForwarderName,
+		Image:           kmc.Spec.Logging.Image,
+		ImagePullPolicy: v1.PullIfNotPresent,
+		Args:            []string{"--config=" + path.Join(fluentBitConfDir, fluentBitConfName)},
+		Env:             logForwarderEnv(kmc, component),
+		Resources:       kmc.Spec.Logging.Resources,
+		VolumeMounts: []v1.VolumeMount{{
+			Name:      logVolumeName,
+			MountPath: logDir,
+		}, {
+			Name:      kmc.GetLoggingConfigMapName(),
+			MountPath: fluentBitConfDir,
+			ReadOnly:  true,
+		}},
+	})
+
+	if podTemplate.Annotations == nil {
+		podTemplate.Annotations = map[string]string{}
+	}
+	podTemplate.Annotations[loggingConfigHashAnnotation] = loggingConfigHash(kmc)
+}
+
+// loggingConfigHash returns the hash of the logging configuration of the cluster.
+func loggingConfigHash(kmc *km.Cluster) string {
+	b, _ := json.Marshal(kmc.Spec.Logging)
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+func (r *ClusterReconciler) generateLoggingCM(kmc *km.Cluster) (v1.ConfigMap, error) {
+	conf, err := generateFluentBitConf(kmc)
+	if err != nil {
+		return v1.ConfigMap{}, err
+	}
+
+	cm := v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        kmc.GetLoggingConfigMapName(),
+			Namespace:   kmc.Namespace,
+			Labels:      labelsForCluster(kmc),
+			Annotations: annotationsForCluster(kmc),
+		},
+		Data: map[string]string{
+			fluentBitConfName: conf,
+		},
+	}
+
+	_ = ctrl.SetControllerReference(kmc, &cm, r.Scheme)
+	return cm, nil
+}
+
+func (r *ClusterReconciler) reconcileLoggingCM(ctx context.Context, kmc km.Cluster) error {
+	logger := log.FromContext(ctx)
+	logger.Info("Reconciling log forwarder configmap")
+
+	cm, err := r.generateLoggingCM(&kmc)
+	if err != nil {
+		return kutil.InvalidSpec(err)
+	}
+
+	return kutil.ApplyConfigMap(ctx, r.Client, &cm, patchOpts...)
+}
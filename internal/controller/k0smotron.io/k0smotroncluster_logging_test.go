@@ -0,0 +1,159 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestGenerateFluentBitConf(t *testing.T) {
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant-a"},
+		Spec: km.ClusterSpec{
+			Logging: km.LoggingSpec{
+				Enabled: true,
+				Labels:  map[string]string{"env": "prod"},
+				Outputs: []km.LogOutput{{
+					Type: "loki",
+					Loki: &km.LokiOutput{
+						URL:                   "https://loki.example.com/loki/api/v1/push",
+						TenantID:              "tenant-a",
+						BearerTokenSecretName: "loki-token",
+					},
+				}, {
+					Type:   "syslog",
+					Syslog: &km.SyslogOutput{Host: "syslog.example.com"},
+				}, {
+					Type: "cloudwatch",
+					CloudWatch: &km.CloudWatchOutput{
+						Region:                "eu-west-1",
+						LogGroupName:          "k0smotron",
+						CredentialsSecretName: "aws-credentials",
+					},
+				}},
+			},
+		},
+	}
+
+	conf, err := generateFluentBitConf(kmc)
+	require.NoError(t, err)
+	for _, line := range []string{
+		"    Path                 /var/log/k0smotron/*.log\n",
+		"    Record               k0smotron_cluster test\n",
+		"    Record               k0smotron_namespace tenant-a\n",
+		"    Record               env prod\n",
+		"    Host                 loki.example.com\n",
+		"    Port                 443\n",
+		"    Uri                  /loki/api/v1/push\n",
+		"    Tls                  On\n",
+		"    Tenant_ID            tenant-a\n",
+		"    Bearer_Token         ${LOG_OUTPUT_0_TOKEN}\n",
+		"    Label_Keys           $k0smotron_cluster,$k0smotron_namespace,$component,$pod,$env\n",
+		"    Host                 syslog.example.com\n",
+		"    Port                 514\n",
+		"    Mode                 udp\n",
+		"    Syslog_Format        rfc5424\n",
+		"    region               eu-west-1\n",
+		"    log_stream_prefix    tenant-a.test.\n",
+	} {
+		assert.Contains(t, conf, line)
+	}
+
+	env := logForwarderEnv(kmc, "etcd")
+	assert.Equal(t, []string{"K0SMOTRON_COMPONENT", "LOG_OUTPUT_0_TOKEN", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"}, envNames(env))
+	assert.Equal(t, "etcd", env[0].Value)
+	assert.Equal(t, "loki-token", env[1].ValueFrom.SecretKeyRef.Name)
+
+	// The values that would break the config are rejected
+	kmc.Spec.Logging.Labels = map[string]string{"env": "prod\n[OUTPUT]"}
+	_, err = generateFluentBitConf(kmc)
+	assert.Error(t, err)
+
+	kmc.Spec.Logging.Labels = nil
+	kmc.Spec.Logging.Outputs[0].Loki.BasicAuth = &km.BasicAuth{Username: "user", PasswordSecretName: "loki-password"}
+	_, err = generateFluentBitConf(kmc)
+	assert.ErrorContains(t, err, "both a bearer token and basic auth")
+
+	kmc.Spec.Logging.Outputs[0].Loki.BasicAuth = nil
+	kmc.Spec.Logging.Outputs = append(kmc.Spec.Logging.Outputs, km.LogOutput{
+		Type:       "cloudwatch",
+		CloudWatch: &km.CloudWatchOutput{Region: "us-east-1", LogGroupName: "k0smotron", CredentialsSecretName: "other"},
+	})
+	_, err = generateFluentBitConf(kmc)
+	assert.ErrorContains(t, err, "same credentials secret")
+
+	kmc.Spec.Logging.Outputs = []km.LogOutput{{Type: "syslog"}}
+	_, err = generateFluentBitConf(kmc)
+	assert.Error(t, err)
+}
+
+func TestLogForwarder(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+	r := &ClusterReconciler{Scheme: scheme}
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: km.ClusterSpec{
+			Logging: km.LoggingSpec{
+				Enabled: true,
+				Image:   "fluent/fluent-bit:3.0.7",
+				Outputs: []km.LogOutput{{Type: "syslog", Syslog: &km.SyslogOutput{Host: "syslog.example.com"}}},
+			},
+		},
+	}
+
+	cm, err := r.generateEntrypointCM(kmc)
+	require.NoError(t, err)
+	assert.Contains(t, cm.Data["k0smotron-entrypoint.sh"], "2>&1 | tee -a /var/log/k0smotron/controller.log")
+
+	sts := r.generateEtcdStatefulSet(kmc, 1)
+	assert.Contains(t, sts.Spec.Template.Spec.Containers[0].Args[1], "2>&1 | tee -a /var/log/k0smotron/etcd.log")
+	assert.Contains(t, sts.Spec.Template.Spec.Containers[0].VolumeMounts, v1.VolumeMount{Name: logVolumeName, MountPath: logDir})
+	require.Len(t, sts.Spec.Template.Spec.Containers, 2)
+	forwarder := sts.Spec.Template.Spec.Containers[1]
+	assert.Equal(t, logForwarderName, forwarder.Name)
+	assert.Equal(t, "fluent/fluent-bit:3.0.7", forwarder.Image)
+	assert.Equal(t, []string{"--config=/fluent-bit/etc/k0smotron/fluent-bit.conf"}, forwarder.Args)
+	assert.NotEmpty(t, sts.Spec.Template.Annotations[loggingConfigHashAnnotation])
+
+	// Without logging, the scripts are left as they were
+	kmc.Spec.Logging.Enabled = false
+	cm, err = r.generateEntrypointCM(kmc)
+	require.NoError(t, err)
+	assert.NotContains(t, cm.Data["k0smotron-entrypoint.sh"], "tee")
+	assert.Contains(t, cm.Data["k0smotron-entrypoint.sh"], "# Run the k0s controller\nk0s controller --config=/etc/k0s/k0s.yaml --enable-dynamic-config\n")
+	sts = r.generateEtcdStatefulSet(kmc, 1)
+	assert.NotContains(t, sts.Spec.Template.Spec.Containers[0].Args[1], "tee")
+	assert.Len(t, sts.Spec.Template.Spec.Containers, 1)
+}
+
+func envNames(env []v1.EnvVar) []string {
+	names := make([]string, 0, len(env))
+	for _, e := range env {
+		names = append(names, e.Name)
+	}
+	return names
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+const (
+	// manifestBundleLabel labels the objects applied to the cluster with the name of their bundle.
+	manifestBundleLabel = "k0smotron.io/manifest-bundle"
+	// manifestBundleFieldOwner is the field manager of the applied objects.
+	manifestBundleFieldOwner = "k0smotron-manifest-bundles"
+	// manifestBundleMaxSize is the maximum size of a downloaded bundle.
+	manifestBundleMaxSize = 4 << 20
+	// manifestBundleDownloadTimeout is the time to wait for a bundle to be downloaded.
+	manifestBundleDownloadTimeout = 30 * time.Second
+)
+
+// reconcileManifestBundles applies the manifest bundles to the cluster once its API is reachable and sets the
+// ManifestBundlesApplied condition. The objects are applied on every reconciliation, so the changes made to them in the
+// cluster are reverted. The objects removed from the bundles are not deleted from the cluster.
+func (r *ClusterReconciler) reconcileManifestBundles(ctx context.Context, kmc *km.Cluster) {
+	if len(kmc.Spec.ManifestBundles) == 0 {
+		meta.RemoveStatusCondition(&kmc.Status.Conditions, km.ManifestBundlesAppliedCondition)
+		return
+	}
+	if !meta.IsStatusConditionTrue(kmc.Status.Conditions, km.APIReachableCondition) {
+		setCondition(kmc, km.ManifestBundlesAppliedCondition, metav1.ConditionUnknown, km.WaitingForAPIReason, "Waiting for the API server to be reachable")
+		return
+	}
+
+	if err := r.applyManifestBundles(ctx, kmc); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to apply the manifest bundles")
+		kutil.RecordEvent(r.Recorder, kmc, v1.EventTypeWarning, kutil.ReconcileFailedReason, "Failed applying manifest bundles: %v", err)
+		setCondition(kmc, km.ManifestBundlesAppliedCondition, metav1.ConditionFalse, km.ManifestBundlesNotAppliedReason, err.Error())
+		return
+	}
+	setCondition(kmc, km.ManifestBundlesAppliedCondition, metav1.ConditionTrue, km.AvailableReason, "")
+}
+
+func (r *ClusterReconciler) applyManifestBundles(ctx context.Context, kmc *km.Cluster) error {
+	childClient, err := r.newChildClusterClient(ctx, kmc)
+	if err != nil {
+		return fmt.Errorf("failed to create workload cluster client: %w", err)
+	}
+
+	for _, bundle := range kmc.Spec.ManifestBundles {
+		objects, err := r.manifestBundleObjects(ctx, kmc, bundle)
+		if err != nil {
+			return fmt.Errorf("failed to read manifest bundle %s: %w", bundle.Name, err)
+		}
+		for _, obj := range objects {
+			if err := applyManifestBundleObject(ctx, childClient, bundle.Name, obj); err != nil {
+				return fmt.Errorf("failed to apply %s %s of manifest bundle %s: %w", obj.GetKind(), obj.GetName(), bundle.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// manifestBundleObjects reads the manifests of the bundle from its source and decodes them to objects.
+func (r *ClusterReconciler) manifestBundleObjects(ctx context.Context, kmc *km.Cluster, bundle km.ManifestBundle) ([]*unstructured.Unstructured, error) {
+	sources := 0
+	for _, set := range []bool{bundle.Inline != "", bundle.ConfigMapRef != nil, bundle.URL != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("exactly one of inline, configMapRef and url must be set")
+	}
+
+	var documents [][]byte
+	switch {
+	case bundle.Inline != "":
+		documents = append(documents, []byte(bundle.Inline))
+	case bundle.ConfigMapRef != nil:
+		var cm v1.ConfigMap
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: bundle.ConfigMapRef.Name, Namespace: kmc.Namespace}, &cm); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(cm.Data))
+		for key := range cm.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			documents = append(documents, []byte(cm.Data[key]))
+		}
+	default:
+		b, err := downloadManifestBundle(ctx, bundle.URL)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, b)
+	}
+
+	return decodeManifests(documents)
+}
+
+// decodeManifests decodes the YAML or JSON documents to objects, skipping the empty documents.
+func decodeManifests(documents [][]byte) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	for _, document := range documents {
+		decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(document), 4096)
+		for {
+			obj := &unstructured.Unstructured{}
+			err := decoder.Decode(&obj.Object)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode manifest: %w", err)
+			}
+			if len(obj.Object) == 0 {
+				continue
+			}
+			if obj.GetAPIVersion() == "" || obj.GetKind() == "" || obj.GetName() == "" {
+				return nil, fmt.Errorf("manifest has no apiVersion, kind or name")
+			}
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+func downloadManifestBundle(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, manifestBundleDownloadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, manifestBundleMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > manifestBundleMaxSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, manifestBundleMaxSize)
+	}
+	return b, nil
+}
+
+// applyManifestBundleObject applies the object of the bundle, labeled with the name of the bundle.
+func applyManifestBundleObject(ctx context.Context, c client.Client, bundleName string, obj *unstructured.Unstructured) error {
+	return applyObject(ctx, c, obj, manifestBundleFieldOwner, manifestBundleLabel, bundleName)
+}
+
+// applyObject applies the object with server-side apply, taking over the fields changed in the cluster, and labels it
+// with the given label. The namespaced objects without a namespace are applied to the default namespace.
+func applyObject(ctx context.Context, c client.Client, obj client.Object, fieldOwner, label, value string) error {
+	namespaced, err := c.IsObjectNamespaced(obj)
+	if err != nil {
+		return err
+	}
+	if namespaced && obj.GetNamespace() == "" {
+		obj.SetNamespace(metav1.NamespaceDefault)
+	}
+
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[label] = value
+	obj.SetLabels(labels)
+
+	return c.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldOwner), client.ForceOwnership)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+const namespaceManifest = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: ingress
+`
+
+const configMapManifest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: ingress
+data:
+  key: value
+`
+
+func TestManifestBundleObjects(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	kmc := &km.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "addons", Namespace: "default"},
+		Data:       map[string]string{"2-settings.yaml": configMapManifest, "1-namespace.yaml": namespaceManifest},
+	}
+	r := &ClusterReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(cm).Build()}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/bundle.yaml" {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write([]byte(namespaceManifest + "---\n" + configMapManifest))
+	}))
+	defer server.Close()
+
+	for _, bundle := range []km.ManifestBundle{
+		{Name: "inline", Inline: "---\n" + namespaceManifest + "---\n" + configMapManifest + "---\n"},
+		{Name: "configmap", ConfigMapRef: &v1.LocalObjectReference{Name: "addons"}},
+		{Name: "url", URL: server.URL + "/bundle.yaml"},
+	} {
+		objects, err := r.manifestBundleObjects(context.Background(), kmc, bundle)
+		require.NoError(t, err, bundle.Name)
+		require.Len(t, objects, 2, bundle.Name)
+		assert.Equal(t, "Namespace", objects[0].GetKind(), bundle.Name)
+		assert.Equal(t, "ingress", objects[0].GetName(), bundle.Name)
+		assert.Equal(t, "ConfigMap", objects[1].GetKind(), bundle.Name)
+		assert.Equal(t, "settings", objects[1].GetName(), bundle.Name)
+	}
+
+	for _, bundle := range []km.ManifestBundle{
+		{Name: "none"},
+		{Name: "both", Inline: namespaceManifest, URL: server.URL + "/bundle.yaml"},
+		{Name: "missing", URL: server.URL + "/missing.yaml"},
+		{Name: "invalid", Inline: "kind: Namespace\n"},
+	} {
+		_, err := r.manifestBundleObjects(context.Background(), kmc, bundle)
+		assert.Error(t, err, bundle.Name)
+	}
+}
+
+func TestReconcileManifestBundlesWaitsForAPI(t *testing.T) {
+	r := &ClusterReconciler{}
+	kmc := &km.Cluster{
+		Spec: km.ClusterSpec{ManifestBundles: []km.ManifestBundle{{Name: "inline", Inline: namespaceManifest}}},
+	}
+
+	r.reconcileManifestBundles(context.Background(), kmc)
+	condition := meta.FindStatusCondition(kmc.Status.Conditions, km.ManifestBundlesAppliedCondition)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionUnknown, condition.Status)
+	assert.Equal(t, km.WaitingForAPIReason, condition.Reason)
+
+	kmc.Spec.ManifestBundles = nil
+	r.reconcileManifestBundles(context.Background(), kmc)
+	assert.Nil(t, meta.FindStatusCondition(kmc.Status.Conditions, km.ManifestBundlesAppliedCondition))
+}
@@ -19,35 +19,230 @@ package k0smotronio
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
 	"text/template"
 
 	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/controller/util"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
 )
 
-var prometheusConfigTmpl *template.Template
+const (
+	// monitoringAuthHashAnnotation is set on the pod template to roll the pods when the bearer token changes.
+	monitoringAuthHashAnnotation = "k0smotron.io/monitoring-auth-hash"
+	// monitoringConfigHashAnnotation is set on the pod template to roll the pods when the monitoring config changes,
+	// as the prometheus sidecar doesn't reload it.
+	monitoringConfigHashAnnotation = "k0smotron.io/monitoring-config-hash"
+	monitoringTokenKey             = "token"
+	remoteWritePasswordKey         = "password"
+	// defaultScrapeInterval is the scrape interval used if the cluster doesn't set one.
+	defaultScrapeInterval = "10s"
+
+	// clusterMonitoringTokenSecretField is the field index of the monitoring bearer token secret of a Cluster.
+	clusterMonitoringTokenSecretField = "spec.monitoring.auth.bearerTokenSecretName"
+)
+
+var (
+	prometheusConfigTmpl *template.Template
+	nginxConfTmpl        *template.Template
+
+	prometheusLabelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// bearerTokenRe matches the bearer tokens of RFC 6750, which are safe to embed in the nginx config.
+	bearerTokenRe = regexp.MustCompile(`^[a-zA-Z0-9._~+/-]+=*$`)
+)
 
 func init() {
-	prometheusConfigTmpl = template.Must(template.New("prometheus.yml").Parse(prometheusConfigTemplate))
+	funcs := template.FuncMap{"quote": quote}
+	prometheusConfigTmpl = template.Must(template.New("prometheus.yml").Funcs(funcs).Parse(prometheusConfigTemplate))
+	nginxConfTmpl = template.Must(template.New("nginx.conf").Parse(nginxConfTemplate))
+}
+
+// quote quotes the string for YAML.
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// prometheusRelabelConfig is the RelabelConfig in the Prometheus configuration format.
+type prometheusRelabelConfig struct {
+	SourceLabels []string `json:"source_labels,omitempty"`
+	Separator    string   `json:"separator,omitempty"`
+	Regex        string   `json:"regex,omitempty"`
+	TargetLabel  string   `json:"target_label,omitempty"`
+	Replacement  *string  `json:"replacement,omitempty"`
+	Action       string   `json:"action,omitempty"`
+}
+
+// prometheusRemoteWriteConfig is the RemoteWriteSpec in the Prometheus configuration format. The credentials are
+// read from the files of the mounted secrets, so they are not written to the config map.
+type prometheusRemoteWriteConfig struct {
+	URL                 string                    `json:"url"`
+	Authorization       *prometheusAuthorization  `json:"authorization,omitempty"`
+	BasicAuth           *prometheusBasicAuth      `json:"basic_auth,omitempty"`
+	WriteRelabelConfigs []prometheusRelabelConfig `json:"write_relabel_configs,omitempty"`
+}
+
+type prometheusAuthorization struct {
+	CredentialsFile string `json:"credentials_file"`
+}
+
+type prometheusBasicAuth struct {
+	Username     string `json:"username"`
+	PasswordFile string `json:"password_file"`
+}
+
+// relabelConfigs converts the relabeling rules to the Prometheus configuration format.
+func relabelConfigs(rcs []km.RelabelConfig) ([]prometheusRelabelConfig, error) {
+	configs := make([]prometheusRelabelConfig, 0, len(rcs))
+	for _, rc := range rcs {
+		if rc.Regex != "" {
+			if _, err := regexp.Compile(rc.Regex); err != nil {
+				return nil, fmt.Errorf("invalid relabeling regex %q: %w", rc.Regex, err)
+			}
+		}
+		configs = append(configs, prometheusRelabelConfig(rc))
+	}
+	return configs, nil
+}
+
+// marshalIndented marshals the value to YAML, indented with the given prefix.
+func marshalIndented(v interface{}, prefix string) (string, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	for i := range lines {
+		lines[i] = prefix + lines[i]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// metricRelabelConfigs returns the metric_relabel_configs of the scrape jobs, indented to the job level.
+func metricRelabelConfigs(kmc *km.Cluster) (string, error) {
+	if len(kmc.Spec.Monitoring.MetricRelabelings) == 0 {
+		return "", nil
+	}
+
+	configs, err := relabelConfigs(kmc.Spec.Monitoring.MetricRelabelings)
+	if err != nil {
+		return "", fmt.Errorf("invalid metric relabeling: %w", err)
+	}
+	return marshalIndented(map[string]interface{}{"metric_relabel_configs": configs}, "    ")
+}
+
+// remoteWriteConfigs returns the remote_write section of the config.
+func remoteWriteConfigs(kmc *km.Cluster) (string, error) {
+	if len(kmc.Spec.Monitoring.RemoteWrite) == 0 {
+		return "", nil
+	}
+
+	configs := make([]prometheusRemoteWriteConfig, 0, len(kmc.Spec.Monitoring.RemoteWrite))
+	for i, rw := range kmc.Spec.Monitoring.RemoteWrite {
+		if rw.BearerTokenSecretName != "" && rw.BasicAuth != nil {
+			return "", fmt.Errorf("remote write %s can't use both a bearer token and basic auth", rw.URL)
+		}
+		rc, err := relabelConfigs(rw.WriteRelabelings)
+		if err != nil {
+			return "", fmt.Errorf("invalid write relabeling of remote write %s: %w", rw.URL, err)
+		}
+		config := prometheusRemoteWriteConfig{URL: rw.URL, WriteRelabelConfigs: rc}
+		if rw.BearerTokenSecretName != "" {
+			config.Authorization = &prometheusAuthorization{
+				CredentialsFile: path.Join(remoteWriteSecretDir(i), monitoringTokenKey),
+			}
+		}
+		if rw.BasicAuth != nil {
+			config.BasicAuth = &prometheusBasicAuth{
+				Username:     rw.BasicAuth.Username,
+				PasswordFile: path.Join(remoteWriteSecretDir(i), remoteWritePasswordKey),
+			}
+		}
+		configs = append(configs, config)
+	}
+	return marshalIndented(map[string]interface{}{"remote_write": configs}, "")
+}
+
+// remoteWriteSecretName returns the name of the secret holding the credentials of the remote write endpoint, if any.
+func remoteWriteSecretName(rw km.RemoteWriteSpec) string {
+	if rw.BasicAuth != nil {
+		return rw.BasicAuth.PasswordSecretName
+	}
+	return rw.BearerTokenSecretName
+}
+
+// remoteWriteSecretDir returns the directory the credentials of the i-th remote write endpoint are mounted to.
+func remoteWriteSecretDir(i int) string {
+	return fmt.Sprintf("/prometheus/remote-write/%d", i)
+}
+
+// remoteWriteVolumeName returns the name of the volume of the credentials of the i-th remote write endpoint.
+func remoteWriteVolumeName(i int) string {
+	return fmt.Sprintf("monitoring-remote-write-%d", i)
+}
+
+// monitoringConfigHash returns the hash of the monitoring configuration of the cluster.
+func monitoringConfigHash(kmc *km.Cluster) string {
+	b, _ := json.Marshal(kmc.Spec.Monitoring)
+	return fmt.Sprintf("%x", sha256.Sum256(b))
 }
 
 func (r *ClusterReconciler) generateMonitoringCM(kmc *km.Cluster) (v1.ConfigMap, error) {
+	for name := range kmc.Spec.Monitoring.Labels {
+		if !prometheusLabelNameRe.MatchString(name) {
+			return v1.ConfigMap{}, fmt.Errorf("invalid monitoring label name %q", name)
+		}
+	}
+	relabelConfigs, err := metricRelabelConfigs(kmc)
+	if err != nil {
+		return v1.ConfigMap{}, err
+	}
+	remoteWrite, err := remoteWriteConfigs(kmc)
+	if err != nil {
+		return v1.ConfigMap{}, err
+	}
+	scrapeInterval := kmc.Spec.Monitoring.ScrapeInterval
+	if scrapeInterval == "" {
+		scrapeInterval = defaultScrapeInterval
+	}
+
 	var entrypointBuf bytes.Buffer
-	err := prometheusConfigTmpl.Execute(&entrypointBuf, struct {
-		Kmc         *km.Cluster
-		EtcdSvcName string
+	err = prometheusConfigTmpl.Execute(&entrypointBuf, struct {
+		Kmc            *km.Cluster
+		EtcdSvcName    string
+		ScrapeInterval string
+		RelabelConfigs string
+		RemoteWrite    string
 	}{
-		Kmc:         kmc,
-		EtcdSvcName: kmc.GetEtcdServiceName(),
+		Kmc:            kmc,
+		EtcdSvcName:    kmc.GetEtcdServiceName(),
+		ScrapeInterval: scrapeInterval,
+		RelabelConfigs: relabelConfigs,
+		RemoteWrite:    remoteWrite,
 	})
 	if err != nil {
 		return v1.ConfigMap{}, err
 	}
 
+	nginxConf, err := generateNginxConf("")
+	if err != nil {
+		return v1.ConfigMap{}, err
+	}
+
 	cm := v1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "ConfigMap",
@@ -69,22 +264,135 @@ func (r *ClusterReconciler) generateMonitoringCM(kmc *km.Cluster) (v1.ConfigMap,
 	return cm, nil
 }
 
+// generateNginxConf generates the config of the metrics proxy. If the token is set, the metrics are served over
+// HTTPS and only to the requests presenting the token.
+func generateNginxConf(token string) (string, error) {
+	var buf bytes.Buffer
+	if err := nginxConfTmpl.Execute(&buf, struct{ Token string }{Token: token}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// generateMonitoringAuthSecret generates the secret holding the config of the metrics proxy requiring the bearer
+// token. The config is kept in a secret as it contains the token.
+func (r *ClusterReconciler) generateMonitoringAuthSecret(kmc *km.Cluster, token string) (v1.Secret, error) {
+	nginxConf, err := generateNginxConf(token)
+	if err != nil {
+		return v1.Secret{}, err
+	}
+
+	secret := v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        kmc.GetMonitoringAuthSecretName(),
+			Namespace:   kmc.Namespace,
+			Labels:      labelsForCluster(kmc),
+			Annotations: annotationsForCluster(kmc),
+		},
+		StringData: map[string]string{
+			"nginx.conf": nginxConf,
+		},
+	}
+
+	_ = ctrl.SetControllerReference(kmc, &secret, r.Scheme)
+	return secret, nil
+}
+
+// getMonitoringToken returns the bearer token of the metrics endpoint.
+func (r *ClusterReconciler) getMonitoringToken(ctx context.Context, kmc *km.Cluster) (string, error) {
+	var s v1.Secret
+	name := kmc.Spec.Monitoring.Auth.BearerTokenSecretName
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: name, Namespace: kmc.Namespace}, &s); err != nil {
+		return "", fmt.Errorf("failed to get monitoring bearer token secret %s: %w", name, err)
+	}
+
+	token := strings.TrimSpace(string(s.Data[monitoringTokenKey]))
+	if !bearerTokenRe.MatchString(token) {
+		return "", fmt.Errorf("monitoring bearer token secret %s has no valid bearer token under the key %q", name, monitoringTokenKey)
+	}
+	return token, nil
+}
+
 func (r *ClusterReconciler) reconcileMonitoringCM(ctx context.Context, kmc km.Cluster) error {
 	logger := log.FromContext(ctx)
 	logger.Info("Reconciling monitoring configmap")
 
 	cm, err := r.generateMonitoringCM(&kmc)
+	if err != nil {
+		return kutil.InvalidSpec(err)
+	}
+
+	if err := kutil.ApplyConfigMap(ctx, r.Client, &cm, patchOpts...); err != nil {
+		return err
+	}
+
+	if kmc.Spec.Monitoring.Auth == nil {
+		return nil
+	}
+	token, err := r.getMonitoringToken(ctx, &kmc)
+	if err != nil {
+		return err
+	}
+	secret, err := r.generateMonitoringAuthSecret(&kmc, token)
+	if err != nil {
+		return err
+	}
+	return kutil.ApplySecret(ctx, r.Client, &secret, patchOpts...)
+}
+
+// setMonitoringAuthHash sets the hash of the bearer token to the pod template, so the pods are rolled when the
+// token changes.
+func (r *ClusterReconciler) setMonitoringAuthHash(ctx context.Context, kmc *km.Cluster, podTemplate *v1.PodTemplateSpec) error {
+	token, err := r.getMonitoringToken(ctx, kmc)
 	if err != nil {
 		return err
 	}
 
-	return r.Client.Patch(ctx, &cm, client.Apply, patchOpts...)
+	if podTemplate.Annotations == nil {
+		podTemplate.Annotations = map[string]string{}
+	}
+	podTemplate.Annotations[monitoringAuthHashAnnotation] = fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+	return nil
+}
+
+// requestsForMonitoringTokenSecret maps the bearer token secret to the clusters using it.
+func (r *ClusterReconciler) requestsForMonitoringTokenSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	var clusters km.ClusterList
+	if err := r.Client.List(ctx, &clusters, client.InNamespace(obj.GetNamespace()), client.MatchingFields{clusterMonitoringTokenSecretField: obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list clusters")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, kmc := range clusters.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: kmc.Name, Namespace: kmc.Namespace}})
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Name < requests[j].Name })
+	return requests
+}
+
+// indexClusterMonitoringTokenSecret indexes the clusters with monitoring enabled by their bearer token secret.
+func indexClusterMonitoringTokenSecret(obj client.Object) []string {
+	kmc, ok := obj.(*km.Cluster)
+	if !ok || !kmc.Spec.Monitoring.Enabled || kmc.Spec.Monitoring.Auth == nil {
+		return nil
+	}
+	return []string{kmc.Spec.Monitoring.Auth.BearerTokenSecretName}
 }
 
 const prometheusConfigTemplate = `
 global:
-  scrape_interval:     10s
-  evaluation_interval: 10s
+  scrape_interval:     {{ .ScrapeInterval }}
+  evaluation_interval: {{ .ScrapeInterval }}
+  external_labels:
+    k0smotron_namespace: {{ quote .Kmc.Namespace }}
+{{- range $name, $value := .Kmc.Spec.Monitoring.Labels }}
+    {{ $name }}: {{ quote $value }}
+{{- end }}
 scrape_configs:
   - job_name: "k0smotron_cluster_metrics"
     scheme: https
@@ -105,20 +413,31 @@ scrape_configs:
         labels:
           component: kube-controller-manager
           k0smotron_cluster: "{{ .Kmc.Name }}"
+{{- if .RelabelConfigs }}
+{{ .RelabelConfigs }}
+{{- end }}
+{{- if not (or .Kmc.Spec.KineDataSourceURL .Kmc.Spec.Etcd.External) }}
   - job_name: "k0smotron_etcd_metrics"
     scheme: https
     tls_config: 
       insecure_skip_verify: true
-      cert_file: /var/lib/k0s/pki/etcd-ca.crt
-      key_file: /var/lib/k0s/pki/etcd-ca.key
+      cert_file: /var/lib/k0s/pki/apiserver-etcd-client.crt
+      key_file: /var/lib/k0s/pki/apiserver-etcd-client.key
     static_configs:
       - targets: ["{{ .EtcdSvcName }}:2379"]
         labels:
           component: etcd
           k0smotron_cluster: "{{ .Kmc.Name }}"
+{{- if .RelabelConfigs }}
+{{ .RelabelConfigs }}
+{{- end }}
+{{- end }}
+{{- if .RemoteWrite }}
+{{ .RemoteWrite }}
+{{- end }}
 `
 
-const nginxConf = `
+const nginxConfTemplate = `
 worker_processes  2;
 error_log  /dev/stdout warn;
 pid        /var/run/nginx.pid;
@@ -130,8 +449,19 @@ events {
 http {
    server {
       access_log /dev/stdout;
+{{- if .Token }}
+      listen 8090 ssl;
+      ssl_certificate     /var/lib/k0s/pki/server.crt;
+      ssl_certificate_key /var/lib/k0s/pki/server.key;
+{{- else }}
       listen 8090;
+{{- end }}
       location /metrics {
+{{- if .Token }}
+         if ($http_authorization != "Bearer {{ .Token }}") {
+            return 401;
+         }
+{{- end }}
          set $lbr "{";
          set $rbr "}";
          set $q "'";
@@ -0,0 +1,292 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/cluster-api/util/certs"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/notify"
+)
+
+const (
+	// notificationsFinalizer is set on the clusters with notifications, so the webhooks are notified of the deletion.
+	notificationsFinalizer = "k0smotron.io/notifications"
+	// notificationURLKey is the key of the URL in the Secrets of the notification webhooks.
+	notificationURLKey = "url"
+	// notifiedVersionAnnotation is the k0s version the cluster was last observed running, to notify the upgrades once.
+	notifiedVersionAnnotation = "k0smotron.io/notified-version"
+	// notifiedBackupsAnnotation is the completion time of the latest notified Velero backup of the cluster.
+	notifiedBackupsAnnotation = "k0smotron.io/notified-backups-until"
+	// notifiedCertificateExpiryAnnotation is the expiry of the latest notified expiring certificate of the cluster.
+	notifiedCertificateExpiryAnnotation = "k0smotron.io/notified-certificate-expiry"
+	// certificateExpiryNotificationPeriod is the time before the expiry of a certificate of the cluster when it is
+	// notified.
+	certificateExpiryNotificationPeriod = 30 * 24 * time.Hour
+)
+
+// notificationsEnabled returns whether the events of the cluster are posted to any webhook.
+func (r *ClusterReconciler) notificationsEnabled(kmc *km.Cluster) bool {
+	return r.Notifier.Enabled() || len(kmc.Spec.Notifications.Webhooks) > 0
+}
+
+// reconcileNotificationsFinalizer sets the notifications finalizer on the clusters with notifications and notifies
+// the creation of the new ones. The finalizer is removed once the notifications are disabled.
+func (r *ClusterReconciler) reconcileNotificationsFinalizer(ctx context.Context, kmc *km.Cluster) error {
+	enabled := r.notificationsEnabled(kmc)
+	if enabled == controllerutil.ContainsFinalizer(kmc, notificationsFinalizer) {
+		return nil
+	}
+
+	patch := client.MergeFrom(kmc.DeepCopy())
+	if !enabled {
+		controllerutil.RemoveFinalizer(kmc, notificationsFinalizer)
+		return r.Client.Patch(ctx, kmc, patch)
+	}
+	controllerutil.AddFinalizer(kmc, notificationsFinalizer)
+	if err := r.Client.Patch(ctx, kmc, patch); err != nil {
+		return err
+	}
+	// The existing clusters the notifications are enabled for are already reconciled
+	if kmc.Status.ReconciliationStatus == "" {
+		r.notify(ctx, kmc, notify.Event{Type: notify.ClusterCreated, Message: fmt.Sprintf("Cluster is created with k0s %s", kmc.Spec.GetVersion())})
+	}
+	return nil
+}
+
+// notifyDeleted notifies the deletion of the cluster and removes the notifications finalizer. A failed notification
+// doesn't block the deletion.
+func (r *ClusterReconciler) notifyDeleted(ctx context.Context, kmc *km.Cluster) error {
+	r.notify(ctx, kmc, notify.Event{Type: notify.ClusterDeleted, Message: "Cluster is deleted"})
+
+	patch := client.MergeFrom(kmc.DeepCopy())
+	controllerutil.RemoveFinalizer(kmc, notificationsFinalizer)
+	return r.Client.Patch(ctx, kmc, patch)
+}
+
+// reconcileNotifications notifies the changes observed by the reconciliation: the cluster becoming ready, a completed
+// upgrade, the completed and failed Velero backups and the expiring certificates. The notified state is kept in the
+// annotations of the cluster, so each change is notified once. wasReady is the Ready condition before the
+// reconciliation.
+func (r *ClusterReconciler) reconcileNotifications(ctx context.Context, kmc *km.Cluster, wasReady, ready bool) {
+	if !r.notificationsEnabled(kmc) {
+		return
+	}
+	logger := log.FromContext(ctx)
+
+	base := kmc.DeepCopy()
+	if ready && !wasReady {
+		r.notify(ctx, kmc, notify.Event{Type: notify.ClusterReady, Message: "Cluster is ready"})
+	}
+	if ready {
+		if err := r.notifyUpgrade(ctx, kmc); err != nil {
+			logger.Error(err, "Failed to check the upgrade of the cluster")
+		}
+	}
+	if err := r.notifyBackups(ctx, kmc); err != nil {
+		logger.Error(err, "Failed to check the backups of the cluster")
+	}
+	if err := r.notifyCertificateExpiry(ctx, kmc); err != nil {
+		logger.Error(err, "Failed to check the certificates of the cluster")
+	}
+
+	if reflect.DeepEqual(base.Annotations, kmc.Annotations) {
+		return
+	}
+	if err := r.Client.Patch(ctx, kmc, client.MergeFrom(base)); err != nil {
+		logger.Error(err, "Failed to update the notified state of the cluster")
+	}
+}
+
+// notifyUpgrade notifies the upgrade of the cluster once the pods of the new version are rolled out.
+func (r *ClusterReconciler) notifyUpgrade(ctx context.Context, kmc *km.Cluster) error {
+	version := kmc.Spec.GetVersion()
+	notified := kmc.Annotations[notifiedVersionAnnotation]
+	if notified == version {
+		return nil
+	}
+
+	var sts apps.StatefulSet
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetStatefulSetName(), Namespace: kmc.Namespace}, &sts); err != nil {
+		return err
+	}
+	// The cluster may be observed ready before the new version of the StatefulSet is rolled out
+	if len(sts.Spec.Template.Spec.Containers) == 0 || sts.Spec.Template.Spec.Containers[0].Image != kmc.Spec.GetImage() || isStatefulSetRollingOut(&sts) {
+		return nil
+	}
+
+	if notified != "" {
+		r.notify(ctx, kmc, notify.Event{Type: notify.ClusterUpgraded, Message: fmt.Sprintf("Cluster is upgraded from k0s %s to %s", notified, version)})
+	}
+	setAnnotation(kmc, notifiedVersionAnnotation, version)
+	return nil
+}
+
+// notifyBackups notifies the Velero backups of the cluster finished since the last notified one. The backups are
+// selected by the labels of the Backup generated for the cluster, which the Velero Schedules created from it pass
+// on to their backups.
+func (r *ClusterReconciler) notifyBackups(ctx context.Context, kmc *km.Cluster) error {
+	if kmc.Spec.Backup.Velero == nil {
+		return nil
+	}
+	since, err := time.Parse(time.RFC3339, kmc.Annotations[notifiedBackupsAnnotation])
+	if err != nil {
+		// Only the backups finished from now on are notified, not the ones taken before the notifications
+		setAnnotation(kmc, notifiedBackupsAnnotation, time.Now().UTC().Format(time.RFC3339))
+		return nil
+	}
+
+	backups, err := r.listVeleroBackups(ctx, kmc)
+	if err != nil {
+		return err
+	}
+
+	events, latest := backupEvents(backups, kmc.Namespace, since)
+	for _, e := range events {
+		r.notify(ctx, kmc, e)
+	}
+	if latest.After(since) {
+		setAnnotation(kmc, notifiedBackupsAnnotation, latest.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// backupEvents returns the events of the Velero backups of the given namespace finished after since, in the order
+// they finished, and the finish time of the latest one.
+func backupEvents(backups []unstructured.Unstructured, namespace string, since time.Time) ([]notify.Event, time.Time) {
+	var events []notify.Event
+	latest := since
+	for _, b := range finishedVeleroBackups(backups, namespace) {
+		if !b.finished.After(since) {
+			continue
+		}
+		e := notify.Event{Type: notify.BackupFailed, Message: b.message, Time: b.finished.UTC()}
+		if b.succeeded {
+			e.Type = notify.BackupCompleted
+		}
+		events = append(events, e)
+		latest = b.finished
+	}
+	return events, latest
+}
+
+// notifyCertificateExpiry notifies the certificate of the cluster expiring first once it expires in less than
+// certificateExpiryNotificationPeriod. A renewed certificate expiring soon again is notified again.
+func (r *ClusterReconciler) notifyCertificateExpiry(ctx context.Context, kmc *km.Cluster) error {
+	var names []string
+	for _, purpose := range hostedClusterCertificates {
+		names = append(names, secret.Name(kmc.Name, purpose))
+	}
+	if kmc.Spec.Etcd.External != nil {
+		names = append(names, kmc.Spec.Etcd.External.ClientCertSecretRef.Name)
+	}
+	if kmc.Spec.Certificates.CertManager != nil {
+		names = append(names, kmc.GetAPIServingCertificateSecretName())
+	}
+
+	var expiring string
+	var expiry time.Time
+	for _, name := range names {
+		var s v1.Secret
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: name, Namespace: kmc.Namespace}, &s); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		cert, err := certs.DecodeCertPEM(s.Data[secret.TLSCrtDataName])
+		if err != nil || cert == nil {
+			continue
+		}
+		if expiring == "" || cert.NotAfter.Before(expiry) {
+			expiring, expiry = name, cert.NotAfter
+		}
+	}
+	if expiring == "" || time.Until(expiry) > certificateExpiryNotificationPeriod {
+		return nil
+	}
+
+	value := expiry.UTC().Format(time.RFC3339)
+	if kmc.Annotations[notifiedCertificateExpiryAnnotation] == value {
+		return nil
+	}
+	r.notify(ctx, kmc, notify.Event{Type: notify.CertificateExpiring, Message: fmt.Sprintf("The certificate in Secret %s expires at %s", expiring, value)})
+	setAnnotation(kmc, notifiedCertificateExpiryAnnotation, value)
+	return nil
+}
+
+// notify posts the event to the global webhooks and to the webhooks of the cluster. The failed notifications are
+// recorded as warning Events, they don't fail the reconciliation.
+func (r *ClusterReconciler) notify(ctx context.Context, kmc *km.Cluster, e notify.Event) {
+	e.Namespace = kmc.Namespace
+	e.Cluster = kmc.Name
+
+	webhooks, err := r.clusterWebhooks(ctx, kmc)
+	// The webhooks with a valid URL are notified anyway
+	err = errors.Join(err, r.Notifier.Notify(ctx, e, webhooks...))
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to send the notification", "event", e.Type)
+		kutil.RecordEvent(r.Recorder, kmc, v1.EventTypeWarning, kutil.NotificationFailedReason, "Failed to send the %s notification: %v", e.Type, err)
+	}
+}
+
+// clusterWebhooks returns the notification webhooks of the cluster with their URLs read from the Secrets.
+func (r *ClusterReconciler) clusterWebhooks(ctx context.Context, kmc *km.Cluster) ([]notify.Webhook, error) {
+	var webhooks []notify.Webhook
+	var errs []error
+	for _, w := range kmc.Spec.Notifications.Webhooks {
+		var s v1.Secret
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: w.URLSecretRef.Name, Namespace: kmc.Namespace}, &s); err != nil {
+			errs = append(errs, fmt.Errorf("failed to get notification webhook secret: %w", err))
+			continue
+		}
+		url, ok := s.Data[notificationURLKey]
+		if !ok {
+			errs = append(errs, fmt.Errorf("notification webhook secret %s has no %s key", w.URLSecretRef.Name, notificationURLKey))
+			continue
+		}
+
+		webhook := notify.Webhook{URL: string(url), Format: w.Format}
+		for _, t := range w.Events {
+			webhook.Events = append(webhook.Events, notify.EventType(t))
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, errors.Join(errs...)
+}
+
+func setAnnotation(kmc *km.Cluster, key, value string) {
+	if kmc.Annotations == nil {
+		kmc.Annotations = map[string]string{}
+	}
+	kmc.Annotations[key] = value
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/notify"
+)
+
+func TestReconcileNotifications(t *testing.T) {
+	var events []notify.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e notify.Event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		events = append(events, e)
+	}))
+	defer srv.Close()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: km.ClusterSpec{
+			Version: "v1.27.9-k0s.0",
+			Notifications: km.NotificationsSpec{Webhooks: []km.NotificationWebhook{{
+				URLSecretRef: v1.LocalObjectReference{Name: "cmdb"},
+				Events:       []km.NotificationEventType{"ClusterCreated", "ClusterReady", "ClusterUpgraded", "CertificateExpiring"},
+			}}},
+		},
+	}
+	webhookSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cmdb", Namespace: "default"},
+		Data:       map[string][]byte{"url": []byte(srv.URL)},
+	}
+	sts := &apps.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: kmc.GetStatefulSetName(), Namespace: "default"},
+		Spec: apps.StatefulSetSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "controller", Image: kmc.Spec.GetImage()}},
+		}}},
+	}
+	caSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secret.Name(kmc.Name, secret.ClusterCA), Namespace: "default"},
+		Data:       map[string][]byte{secret.TLSCrtDataName: testCertificatePEM(t, time.Now().Add(10*365*24*time.Hour))},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(kmc, webhookSecret, sts, caSecret).Build()
+	r := &ClusterReconciler{Client: c, Scheme: scheme}
+	ctx := context.Background()
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(kmc), kmc))
+	require.NoError(t, r.reconcileNotificationsFinalizer(ctx, kmc))
+	assert.True(t, controllerutil.ContainsFinalizer(kmc, notificationsFinalizer))
+	require.Len(t, events, 1)
+	assert.Equal(t, notify.ClusterCreated, events[0].Type)
+	assert.Equal(t, "test", events[0].Cluster)
+
+	// The first version is recorded without an upgrade notification
+	r.reconcileNotifications(ctx, kmc, false, true)
+	require.Len(t, events, 2)
+	assert.Equal(t, notify.ClusterReady, events[1].Type)
+	assert.Equal(t, "v1.27.9-k0s.0", kmc.Annotations[notifiedVersionAnnotation])
+
+	// The upgrade is notified once the StatefulSet runs the new version
+	kmc.Spec.Version = "v1.28.4-k0s.0"
+	require.NoError(t, c.Update(ctx, kmc))
+	r.reconcileNotifications(ctx, kmc, true, true)
+	assert.Len(t, events, 2)
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(sts), sts))
+	sts.Spec.Template.Spec.Containers[0].Image = kmc.Spec.GetImage()
+	require.NoError(t, c.Update(ctx, sts))
+	r.reconcileNotifications(ctx, kmc, true, true)
+	require.Len(t, events, 3)
+	assert.Equal(t, notify.ClusterUpgraded, events[2].Type)
+	assert.Equal(t, "Cluster is upgraded from k0s v1.27.9-k0s.0 to v1.28.4-k0s.0", events[2].Message)
+
+	// An expiring certificate is notified once
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(caSecret), caSecret))
+	caSecret.Data[secret.TLSCrtDataName] = testCertificatePEM(t, time.Now().Add(10*24*time.Hour))
+	require.NoError(t, c.Update(ctx, caSecret))
+	r.reconcileNotifications(ctx, kmc, true, true)
+	r.reconcileNotifications(ctx, kmc, true, true)
+	require.Len(t, events, 4)
+	assert.Equal(t, notify.CertificateExpiring, events[3].Type)
+	assert.Contains(t, events[3].Message, caSecret.Name)
+
+	var stored km.Cluster
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(kmc), &stored))
+	assert.Equal(t, "v1.28.4-k0s.0", stored.Annotations[notifiedVersionAnnotation])
+	assert.NotEmpty(t, stored.Annotations[notifiedCertificateExpiryAnnotation])
+
+	require.NoError(t, r.notifyDeleted(ctx, kmc))
+	assert.False(t, controllerutil.ContainsFinalizer(kmc, notificationsFinalizer))
+	// The deletion is filtered out by the events of the webhook
+	assert.Len(t, events, 4)
+}
+
+func TestBackupEvents(t *testing.T) {
+	since := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	backup := func(name, namespace, phase, completion string) unstructured.Unstructured {
+		return unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "velero.io/v1",
+			"kind":       "Backup",
+			"metadata":   map[string]interface{}{"name": name, "namespace": "velero"},
+			"spec":       map[string]interface{}{"includedNamespaces": []interface{}{namespace}},
+			"status":     map[string]interface{}{"phase": phase, "completionTimestamp": completion},
+		}}
+	}
+
+	events, latest := backupEvents([]unstructured.Unstructured{
+		backup("old", "default", "Completed", "2023-01-01T11:00:00Z"),
+		backup("failed", "default", "PartiallyFailed", "2023-01-01T14:00:00Z"),
+		backup("completed", "default", "Completed", "2023-01-01T13:00:00Z"),
+		backup("running", "default", "InProgress", ""),
+		backup("other", "other", "Completed", "2023-01-01T13:00:00Z"),
+	}, "default", since)
+	require.Len(t, events, 2)
+	assert.Equal(t, notify.BackupCompleted, events[0].Type)
+	assert.Equal(t, "Velero backup velero/completed is completed", events[0].Message)
+	assert.Equal(t, notify.BackupFailed, events[1].Type)
+	assert.Equal(t, "Velero backup velero/failed finished in phase PartiallyFailed", events[1].Message)
+	assert.Equal(t, time.Date(2023, 1, 1, 14, 0, 0, 0, time.UTC), latest)
+
+	events, latest = backupEvents(nil, "default", since)
+	assert.Empty(t, events)
+	assert.Equal(t, since, latest)
+}
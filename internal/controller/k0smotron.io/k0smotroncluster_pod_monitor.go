@@ -0,0 +1,136 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/cluster-api/util/secret"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// +kubebuilder:rbac:groups=monitoring.coreos.com,resources=podmonitors,verbs=get;list;watch;create;update;patch;delete
+
+// reconcilePodMonitor creates the Prometheus Operator PodMonitor scraping the metrics of the cluster, so no manual
+// scrape configuration is needed. Nothing is done if the Prometheus Operator is not installed.
+func (r *ClusterReconciler) reconcilePodMonitor(ctx context.Context, kmc *km.Cluster) error {
+	logger := log.FromContext(ctx)
+
+	if !kmc.Spec.Monitoring.Enabled {
+		pm := newPodMonitor(kmc)
+		err := r.Client.Delete(ctx, pm)
+		if err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return fmt.Errorf("failed to delete PodMonitor: %w", err)
+		}
+		return nil
+	}
+
+	pm, err := r.generatePodMonitor(kmc)
+	if err != nil {
+		return err
+	}
+	if err := r.Client.Patch(ctx, pm, client.Apply, patchOpts...); err != nil {
+		if meta.IsNoMatchError(err) {
+			logger.V(1).Info("Prometheus Operator is not installed, skipping PodMonitor")
+			return nil
+		}
+		return fmt.Errorf("failed to apply PodMonitor: %w", err)
+	}
+	return nil
+}
+
+func newPodMonitor(kmc *km.Cluster) *unstructured.Unstructured {
+	pm := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	pm.SetAPIVersion("monitoring.coreos.com/v1")
+	pm.SetKind("PodMonitor")
+	pm.SetName(kmc.GetPodMonitorName())
+	pm.SetNamespace(kmc.Namespace)
+	return pm
+}
+
+func (r *ClusterReconciler) generatePodMonitor(kmc *km.Cluster) (*unstructured.Unstructured, error) {
+	endpoint := map[string]interface{}{
+		"port":        "nginx",
+		"path":        "/metrics",
+		"scheme":      "http",
+		"honorLabels": true,
+	}
+	if auth := kmc.Spec.Monitoring.Auth; auth != nil {
+		endpoint["scheme"] = "https"
+		endpoint["authorization"] = map[string]interface{}{
+			"type": "Bearer",
+			"credentials": map[string]interface{}{
+				"name": auth.BearerTokenSecretName,
+				"key":  monitoringTokenKey,
+			},
+		}
+		endpoint["tlsConfig"] = map[string]interface{}{
+			"ca": map[string]interface{}{
+				"secret": map[string]interface{}{
+					"name": clusterCASecretName(kmc),
+					"key":  secret.TLSCrtDataName,
+				},
+			},
+			// The metrics are served with the API server certificate, issued for the service of the cluster
+			"serverName": fmt.Sprintf("%s.%s.svc", kmc.GetServiceName(), kmc.Namespace),
+		}
+	}
+
+	labels := labelsForCluster(kmc)
+	for k, v := range kmc.Spec.Monitoring.PodMonitorLabels {
+		labels[k] = v
+	}
+
+	selector := map[string]interface{}{}
+	for k, v := range defaultClusterLabels(kmc) {
+		selector[k] = v
+	}
+	selector["component"] = "cluster"
+
+	pm := newPodMonitor(kmc)
+	pm.SetLabels(labels)
+	pm.SetAnnotations(annotationsForCluster(kmc))
+	pm.Object["spec"] = map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": selector,
+		},
+		"podMetricsEndpoints": []interface{}{endpoint},
+	}
+
+	if err := ctrl.SetControllerReference(kmc, pm, r.Scheme); err != nil {
+		return nil, err
+	}
+	return pm, nil
+}
+
+// clusterCASecretName returns the name of the secret of the CA of the cluster.
+func clusterCASecretName(kmc *km.Cluster) string {
+	for _, ref := range kmc.Spec.CertificateRefs {
+		if ref.Type == string(secret.ClusterCA) && ref.Name != "" {
+			return ref.Name
+		}
+	}
+	return secret.Name(kmc.Name, secret.ClusterCA)
+}
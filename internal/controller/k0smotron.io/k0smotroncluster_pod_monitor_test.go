@@ -0,0 +1,114 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestGeneratePodMonitor(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+	r := ClusterReconciler{Scheme: scheme}
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "tenant-a"},
+		Spec: km.ClusterSpec{
+			Service: km.ServiceSpec{Type: "ClusterIP"},
+			Monitoring: km.MonitoringSpec{
+				Enabled:          true,
+				PodMonitorLabels: map[string]string{"release": "prometheus"},
+			},
+		},
+	}
+
+	pm, err := r.generatePodMonitor(kmc)
+	require.NoError(t, err)
+	assert.Equal(t, "PodMonitor", pm.GetKind())
+	assert.Equal(t, "kmc-test", pm.GetName())
+	assert.Equal(t, "prometheus", pm.GetLabels()["release"])
+	require.Len(t, pm.GetOwnerReferences(), 1)
+
+	selector, _, err := unstructured.NestedStringMap(pm.Object, "spec", "selector", "matchLabels")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"app": "k0smotron", "cluster": "test", "component": "cluster"}, selector)
+
+	endpoints, _, err := unstructured.NestedSlice(pm.Object, "spec", "podMetricsEndpoints")
+	require.NoError(t, err)
+	require.Len(t, endpoints, 1)
+	endpoint := endpoints[0].(map[string]interface{})
+	assert.Equal(t, "nginx", endpoint["port"])
+	assert.Equal(t, "http", endpoint["scheme"])
+	assert.NotContains(t, endpoint, "authorization")
+
+	kmc.Spec.Monitoring.Auth = &km.MonitoringAuthSpec{BearerTokenSecretName: "metrics-token"}
+	kmc.Spec.CertificateRefs = []km.CertificateRef{{Type: "ca", Name: "custom-ca"}}
+	pm, err = r.generatePodMonitor(kmc)
+	require.NoError(t, err)
+	endpoints, _, err = unstructured.NestedSlice(pm.Object, "spec", "podMetricsEndpoints")
+	require.NoError(t, err)
+	endpoint = endpoints[0].(map[string]interface{})
+	assert.Equal(t, "https", endpoint["scheme"])
+	token, _, err := unstructured.NestedStringMap(endpoint, "authorization", "credentials")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "metrics-token", "key": "token"}, token)
+	ca, _, err := unstructured.NestedStringMap(endpoint, "tlsConfig", "ca", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "custom-ca", "key": "tls.crt"}, ca)
+	serverName, _, err := unstructured.NestedString(endpoint, "tlsConfig", "serverName")
+	require.NoError(t, err)
+	assert.Equal(t, "kmc-test.tenant-a.svc", serverName)
+}
+
+func TestReconcilePodMonitorWithoutPrometheusOperator(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+	// The API server returns no match for the kinds of the CRDs not installed
+	noMatch := &meta.NoKindMatchError{GroupKind: schema.GroupKind{Group: "monitoring.coreos.com", Kind: "PodMonitor"}, SearchedVersions: []string{"v1"}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Patch: func(_ context.Context, _ client.WithWatch, _ client.Object, _ client.Patch, _ ...client.PatchOption) error {
+			return noMatch
+		},
+		Delete: func(_ context.Context, _ client.WithWatch, _ client.Object, _ ...client.DeleteOption) error {
+			return noMatch
+		},
+	}).Build()
+	r := ClusterReconciler{Client: c, Scheme: scheme}
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec:       km.ClusterSpec{Monitoring: km.MonitoringSpec{Enabled: true}},
+	}
+	require.NoError(t, r.reconcilePodMonitor(context.Background(), kmc))
+
+	kmc.Spec.Monitoring.Enabled = false
+	require.NoError(t, r.reconcilePodMonitor(context.Background(), kmc))
+}
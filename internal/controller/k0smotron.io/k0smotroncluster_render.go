@@ -0,0 +1,211 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+// redactedValue replaces the secret values in the rendered objects.
+const redactedValue = "<redacted>"
+
+// Render generates the objects the cluster is reconciled into without applying them, so the changes of the spec
+// can be reviewed before they are rolled out. The objects are rendered in the order they are applied. The objects
+// generated from the state of the cluster, i.e. the certificates and the kubeconfigs, are not rendered, and the
+// secret values are redacted.
+//
+// The management cluster is only read, for the referenced k0s config and secrets and the addresses of the existing
+// service. The cluster is not modified.
+func (r *ClusterReconciler) Render(ctx context.Context, kmc *km.Cluster) ([]client.Object, error) {
+	kmc = kmc.DeepCopy()
+	var objs []client.Object
+
+	svc := r.generateService(kmc)
+	_ = ctrl.SetControllerReference(kmc, &svc, r.Scheme)
+	objs = append(objs, &svc)
+
+	if kmc.Spec.ExternalAddress == "" {
+		address, err := r.renderExternalAddress(ctx, kmc, &svc)
+		if err != nil {
+			return nil, err
+		}
+		kmc.Spec.ExternalAddress = address
+	}
+
+	if kmc.Spec.Certificates.CertManager != nil {
+		cert, err := r.generateAPIServingCertificate(kmc)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, cert)
+	}
+
+	if err := r.resolveK0sConfig(ctx, kmc); err != nil {
+		return nil, err
+	}
+	sans, err := r.renderSANs(ctx, kmc)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := r.generateConfig(kmc, sans)
+	if err != nil {
+		return nil, err
+	}
+	objs = append(objs, &config)
+
+	entrypoint, err := r.generateEntrypointCM(kmc)
+	if err != nil {
+		return nil, err
+	}
+	objs = append(objs, &entrypoint)
+
+	if len(kmc.Spec.AccessControl.ClusterRoleBindings) > 0 {
+		cm, err := r.generateAccessControlCM(kmc)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, &cm)
+	}
+
+	if kmc.Spec.Webhooks.Admission != nil {
+		cm := r.generateWebhooksCM(kmc)
+		objs = append(objs, &cm)
+	}
+
+	if kmc.Spec.Monitoring.Enabled {
+		cm, err := r.generateMonitoringCM(kmc)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, &cm)
+		if kmc.Spec.Monitoring.Auth != nil {
+			secret, err := r.generateMonitoringAuthSecret(kmc, redactedValue)
+			if err != nil {
+				return nil, err
+			}
+			objs = append(objs, &secret)
+		}
+	}
+
+	if kmc.Spec.Logging.Enabled {
+		cm, err := r.generateLoggingCM(kmc)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, &cm)
+	}
+
+	if kmc.Spec.Backup.Velero != nil {
+		cm, err := r.generateVeleroBackupCM(kmc)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, &cm)
+	}
+
+	if kmc.Spec.CertificateRefs == nil {
+		kmc.Spec.CertificateRefs = clusterCertificateRefs(kmc)
+	}
+	kmc.Spec.CertificateRefs = append(kmc.Spec.CertificateRefs, etcdCertificateRefs(kmc)...)
+
+	if kmc.Spec.KineDataSourceURL == "" && kmc.Spec.Etcd.External == nil {
+		etcdSvc := r.generateEtcdSvc(kmc)
+		etcdStatefulSet := r.generateEtcdStatefulSet(kmc, calculateDesiredReplicas(kmc))
+		_ = ctrl.SetControllerReference(kmc, &etcdStatefulSet, r.Scheme)
+		objs = append(objs, &etcdSvc, &etcdStatefulSet)
+	}
+
+	objs = append(objs, r.generateTelemetryCM(kmc))
+	statefulSet, err := r.generateStatefulSet(ctx, kmc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate statefulset: %w", err)
+	}
+	objs = append(objs, &statefulSet)
+
+	if kmc.Spec.Monitoring.Enabled {
+		pm, err := r.generatePodMonitor(kmc)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, pm)
+	}
+
+	return objs, nil
+}
+
+// renderExternalAddress returns the external address the cluster would get: the hostname of its DNS record, the
+// address of its existing load balancer or the address of a node for a NodePort service.
+func (r *ClusterReconciler) renderExternalAddress(ctx context.Context, kmc *km.Cluster, svc *v1.Service) (string, error) {
+	if kmc.Spec.DNS != nil {
+		return kmc.Spec.DNS.Hostname, nil
+	}
+
+	switch kmc.Spec.Service.Type {
+	case v1.ServiceTypeLoadBalancer:
+		var existing v1.Service
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(svc), &existing); err != nil {
+			return "", client.IgnoreNotFound(err)
+		}
+		if len(existing.Status.LoadBalancer.Ingress) == 0 {
+			return "", nil
+		}
+		if ip := existing.Status.LoadBalancer.Ingress[0].IP; ip != "" {
+			return ip, nil
+		}
+		return existing.Status.LoadBalancer.Ingress[0].Hostname, nil
+	case v1.ServiceTypeNodePort:
+		var nodes v1.NodeList
+		if err := r.Client.List(ctx, &nodes); err != nil {
+			return "", err
+		}
+		if len(nodes.Items) == 0 {
+			return "", nil
+		}
+		return util.FindNodeAddress(&nodes), nil
+	}
+	return "", nil
+}
+
+// renderSANs returns the SANs of the cluster with the cluster IPs of its existing service. Unlike the reconciliation,
+// the addresses are not resolved with the DNS of the management cluster, which may not be reachable.
+func (r *ClusterReconciler) renderSANs(ctx context.Context, kmc *km.Cluster) ([]string, error) {
+	sans := clusterSANs(kmc)
+
+	var svc v1.Service
+	err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetServiceName(), Namespace: kmc.Namespace}, &svc)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return sans, nil
+		}
+		return nil, err
+	}
+	for _, ip := range svc.Spec.ClusterIPs {
+		if ip != v1.ClusterIPNone {
+			sans = append(sans, ip)
+		}
+	}
+	return sans, nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestRender(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "uid"},
+		Spec: km.ClusterSpec{
+			Replicas: 2,
+			Service:  km.ServiceSpec{Type: v1.ServiceTypeNodePort, APIPort: 30443, KonnectivityPort: 30132},
+			Monitoring: km.MonitoringSpec{
+				Enabled: true,
+				Auth:    &km.MonitoringAuthSpec{BearerTokenSecretName: "metrics-token"},
+			},
+		},
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.2.3.4"}}},
+	}
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: kmc.GetServiceName(), Namespace: "default"},
+		Spec:       v1.ServiceSpec{ClusterIP: "10.96.0.10", ClusterIPs: []string{"10.96.0.10"}},
+	}
+	token := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "metrics-token", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("secret-token")},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node, svc, token).Build()
+	r := ClusterReconciler{Client: c, Scheme: scheme}
+
+	objs, err := r.Render(context.Background(), kmc)
+	require.NoError(t, err)
+
+	var names []string
+	rendered := map[string]client.Object{}
+	for _, obj := range objs {
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		names = append(names, kind+"/"+obj.GetName())
+		rendered[kind+"/"+obj.GetName()] = obj
+		require.Equal(t, "default", obj.GetNamespace())
+		require.Len(t, obj.GetOwnerReferences(), 1, obj.GetName())
+		require.Equal(t, kmc.UID, obj.GetOwnerReferences()[0].UID)
+	}
+	assert.Equal(t, []string{
+		"Service/" + kmc.GetNodePortServiceName(),
+		"ConfigMap/" + kmc.GetConfigMapName(),
+		"ConfigMap/" + kmc.GetEntrypointConfigMapName(),
+		"ConfigMap/" + kmc.GetMonitoringConfigMapName(),
+		"Secret/" + kmc.GetMonitoringAuthSecretName(),
+		"Service/" + kmc.GetEtcdServiceName(),
+		"StatefulSet/" + kmc.GetEtcdStatefulSetName(),
+		"ConfigMap/kmc-test-telemetry-config",
+		"StatefulSet/" + kmc.GetStatefulSetName(),
+		"PodMonitor/" + kmc.GetPodMonitorName(),
+	}, names)
+
+	config := rendered["ConfigMap/"+kmc.GetConfigMapName()].(*v1.ConfigMap).Data["K0SMOTRON_K0S_YAML"]
+	assert.Contains(t, config, "externalAddress: 1.2.3.4")
+	assert.Contains(t, config, "- 10.96.0.10")
+
+	secret := rendered["Secret/"+kmc.GetMonitoringAuthSecretName()].(*v1.Secret)
+	assert.NotContains(t, secret.StringData["nginx.conf"], "secret-token")
+	assert.Contains(t, secret.StringData["nginx.conf"], redactedValue)
+
+	etcd := rendered["StatefulSet/"+kmc.GetEtcdStatefulSetName()].(*apps.StatefulSet)
+	assert.Equal(t, int32(3), *etcd.Spec.Replicas)
+
+	// The management cluster is not modified and the spec of the cluster is kept
+	var configMaps v1.ConfigMapList
+	require.NoError(t, c.List(context.Background(), &configMaps))
+	assert.Empty(t, configMaps.Items)
+	assert.Empty(t, kmc.Spec.ExternalAddress)
+	assert.Nil(t, kmc.Spec.CertificateRefs)
+}
+
+func TestRender_Kine(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: km.ClusterSpec{
+			Service:                  km.ServiceSpec{Type: v1.ServiceTypeClusterIP, APIPort: 30443, KonnectivityPort: 30132},
+			KineDataSourceSecretName: "kine",
+		},
+	}
+	r := ClusterReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).Build(), Scheme: scheme}
+
+	objs, err := r.Render(context.Background(), kmc)
+	require.NoError(t, err)
+
+	for _, obj := range objs {
+		require.NotEqual(t, kmc.GetEtcdStatefulSetName(), obj.GetName())
+		if cm, ok := obj.(*v1.ConfigMap); ok && cm.Name == kmc.GetConfigMapName() {
+			assert.Contains(t, cm.Data["K0SMOTRON_K0S_YAML"], kineDataSourceURLPlaceholder)
+		}
+	}
+}
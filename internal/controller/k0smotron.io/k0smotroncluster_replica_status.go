@@ -0,0 +1,155 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/controller/util"
+	"github.com/k0sproject/k0smotron/internal/exec"
+)
+
+// etcdEndpointStatusCommand prints the status of all the members of the etcd cluster as JSON.
+const etcdEndpointStatusCommand = "etcdctl endpoint status --cluster -w json"
+
+// etcdEndpointStatus is an entry of the output of etcdEndpointStatusCommand.
+type etcdEndpointStatus struct {
+	Endpoint string `json:"Endpoint"`
+	Status   struct {
+		Header struct {
+			MemberID uint64 `json:"member_id"`
+		} `json:"header"`
+		Leader    uint64 `json:"leader"`
+		IsLearner bool   `json:"isLearner"`
+	} `json:"Status"`
+}
+
+// reconcileReplicaStatus reports the status of all the controller and etcd pods of the cluster. The etcd roles are
+// read from a running etcd pod, and left empty if they can't be read.
+func (r *ClusterReconciler) reconcileReplicaStatus(ctx context.Context, kmc *km.Cluster) error {
+	logger := log.FromContext(ctx)
+
+	controllers, err := util.ListStatefulSetPods(ctx, r.ClientSet, kmc.GetStatefulSetName(), kmc.Namespace)
+	if err != nil {
+		return err
+	}
+	replicas := make([]km.ReplicaStatus, 0, len(controllers))
+	for _, pod := range controllers {
+		replicas = append(replicas, replicaStatusForPod(pod, "controller"))
+	}
+
+	if kmc.Spec.KineDataSourceURL == "" && kmc.Spec.Etcd.External == nil {
+		etcds, err := util.ListStatefulSetPods(ctx, r.ClientSet, kmc.GetEtcdStatefulSetName(), kmc.Namespace)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		roles, err := r.etcdRoles(ctx, kmc, etcds)
+		if err != nil {
+			logger.Info("Failed to read the etcd member roles", "error", err.Error())
+		}
+		for _, pod := range etcds {
+			replica := replicaStatusForPod(pod, "etcd")
+			replica.EtcdRole = roles[pod.Name]
+			replicas = append(replicas, replica)
+		}
+	}
+
+	sort.Slice(replicas, func(i, j int) bool {
+		return replicas[i].Name < replicas[j].Name
+	})
+	kmc.Status.ReplicaStatus = replicas
+
+	return nil
+}
+
+// etcdRoles returns the roles of the etcd members by the names of their pods. The status of the cluster is read
+// from the first running pod that answers.
+func (r *ClusterReconciler) etcdRoles(ctx context.Context, kmc *km.Cluster, pods []v1.Pod) (map[string]string, error) {
+	var lastErr error
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+		output, err := exec.PodContainerExecCmdOutput(ctx, r.ClientSet, r.RESTConfig, pod.Name, client.ObjectKeyFromObject(kmc), "etcd", etcdEndpointStatusCommand)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return parseEtcdRoles(output)
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no running etcd pods")
+	}
+	return nil, lastErr
+}
+
+// parseEtcdRoles parses the output of etcdEndpointStatusCommand to the roles of the members by the names of their
+// pods. The members advertise their client URL as https://<pod>.<service>:2379.
+func parseEtcdRoles(output string) (map[string]string, error) {
+	var statuses []etcdEndpointStatus
+	if err := json.Unmarshal([]byte(output), &statuses); err != nil {
+		return nil, fmt.Errorf("failed to parse the etcd endpoint status: %w", err)
+	}
+
+	roles := make(map[string]string, len(statuses))
+	for _, s := range statuses {
+		u, err := url.Parse(s.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid etcd endpoint %s: %w", s.Endpoint, err)
+		}
+		podName, _, _ := strings.Cut(u.Hostname(), ".")
+
+		switch {
+		case s.Status.IsLearner:
+			roles[podName] = "learner"
+		case s.Status.Header.MemberID == s.Status.Leader:
+			roles[podName] = "leader"
+		default:
+			roles[podName] = "follower"
+		}
+	}
+	return roles, nil
+}
+
+func replicaStatusForPod(pod v1.Pod, component string) km.ReplicaStatus {
+	replica := km.ReplicaStatus{
+		Name:      pod.Name,
+		Component: component,
+		Phase:     pod.Status.Phase,
+		NodeName:  pod.Spec.NodeName,
+	}
+	for _, c := range pod.Status.ContainerStatuses {
+		replica.RestartCount += c.RestartCount
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			replica.Ready = c.Status == v1.ConditionTrue
+		}
+	}
+	return replica
+}
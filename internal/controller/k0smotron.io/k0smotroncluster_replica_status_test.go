@@ -0,0 +1,74 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestParseEtcdRoles(t *testing.T) {
+	output := `[
+{"Endpoint":"https://kmc-test-etcd-0.kmc-test-etcd:2379","Status":{"header":{"cluster_id":1,"member_id":17237436991929493444,"revision":5},"version":"3.5.13","leader":17237436991929493444,"isLearner":false}},
+{"Endpoint":"https://kmc-test-etcd-1.kmc-test-etcd:2379","Status":{"header":{"cluster_id":1,"member_id":9372538179322589801,"revision":5},"version":"3.5.13","leader":17237436991929493444,"isLearner":false}},
+{"Endpoint":"https://kmc-test-etcd-2.kmc-test-etcd:2379","Status":{"header":{"cluster_id":1,"member_id":10501334649042878790,"revision":5},"version":"3.5.13","leader":17237436991929493444,"isLearner":true}}
+]`
+
+	roles, err := parseEtcdRoles(output)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"kmc-test-etcd-0": "leader",
+		"kmc-test-etcd-1": "follower",
+		"kmc-test-etcd-2": "learner",
+	}, roles)
+
+	_, err = parseEtcdRoles("Error: context deadline exceeded")
+	assert.Error(t, err)
+}
+
+func TestReplicaStatusForPod(t *testing.T) {
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kmc-test-0"},
+		Spec:       v1.PodSpec{NodeName: "node-1"},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			Conditions: []v1.PodCondition{
+				{Type: v1.PodScheduled, Status: v1.ConditionTrue},
+				{Type: v1.PodReady, Status: v1.ConditionFalse},
+			},
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "controller", RestartCount: 3},
+				{Name: "monitoring", RestartCount: 1},
+			},
+		},
+	}
+
+	assert.Equal(t, km.ReplicaStatus{
+		Name:         "kmc-test-0",
+		Component:    "controller",
+		Phase:        v1.PodRunning,
+		Ready:        false,
+		RestartCount: 4,
+		NodeName:     "node-1",
+	}, replicaStatusForPod(pod, "controller"))
+}
@@ -129,6 +129,22 @@ func (r *ClusterReconciler) reconcileServices(ctx context.Context, kmc km.Cluste
 	if err := r.Client.Patch(ctx, &svc, client.Apply, patchOpts...); err != nil {
 		return err
 	}
+	// The services of the previous type are kept until the address of the new one is known
+	previous, err := r.previousServices(ctx, &kmc)
+	if err != nil {
+		return err
+	}
+	if err := r.resetPreviousServiceAddress(ctx, &kmc, previous); err != nil {
+		return err
+	}
+	if kmc.Spec.DNS != nil && kmc.Spec.ExternalAddress == "" {
+		// The hostname of the DNS record is stable, unlike the address of the service
+		logger.Info("Using the DNS record as external address", "address", kmc.Spec.DNS.Hostname)
+		kmc.Spec.ExternalAddress = kmc.Spec.DNS.Hostname
+		if err := r.Client.Update(ctx, &kmc); err != nil {
+			return err
+		}
+	}
 	// Wait for LB address to be available
 	logger.Info("Waiting for loadbalancer address")
 	if kmc.Spec.Service.Type == v1.ServiceTypeLoadBalancer && kmc.Spec.ExternalAddress == "" {
@@ -170,5 +186,5 @@ func (r *ClusterReconciler) reconcileServices(ctx context.Context, kmc km.Cluste
 		}
 	}
 
-	return nil
+	return r.deletePreviousServices(ctx, &kmc, previous)
 }
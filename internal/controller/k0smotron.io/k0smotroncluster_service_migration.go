@@ -0,0 +1,118 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	"github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+// previousServices returns the services of the cluster created for another service type than the current one, i.e.
+// the services left behind by a change of spec.service.type.
+func (r *ClusterReconciler) previousServices(ctx context.Context, kmc *km.Cluster) ([]v1.Service, error) {
+	var services []v1.Service
+	for _, name := range []string{kmc.GetClusterIPServiceName(), kmc.GetNodePortServiceName(), kmc.GetLoadBalancerServiceName()} {
+		if name == kmc.GetServiceName() {
+			continue
+		}
+		var svc v1.Service
+		err := r.Client.Get(ctx, client.ObjectKey{Name: name, Namespace: kmc.Namespace}, &svc)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if metav1.IsControlledBy(&svc, kmc) {
+			services = append(services, svc)
+		}
+	}
+	return services, nil
+}
+
+// resetPreviousServiceAddress clears the external address of the cluster if it was detected from a previous service,
+// so the address of the new service is detected and the k0s config, the certificates, the kubeconfig and the join
+// tokens follow it. An address set by the user, e.g. the hostname of the DNS record, is kept.
+func (r *ClusterReconciler) resetPreviousServiceAddress(ctx context.Context, kmc *km.Cluster, previous []v1.Service) error {
+	if kmc.Spec.ExternalAddress == "" {
+		return nil
+	}
+	for _, svc := range previous {
+		addresses, err := r.serviceAddresses(ctx, &svc)
+		if err != nil {
+			return err
+		}
+		for _, addr := range addresses {
+			if addr != kmc.Spec.ExternalAddress {
+				continue
+			}
+			log.FromContext(ctx).Info("Service type changed, detecting the address of the new service", "previousService", svc.Name, "previousAddress", addr)
+			kmc.Spec.ExternalAddress = ""
+			return r.Client.Update(ctx, kmc)
+		}
+	}
+	return nil
+}
+
+// deletePreviousServices deletes the services of the previous service type once the cluster has the address of the
+// new service.
+func (r *ClusterReconciler) deletePreviousServices(ctx context.Context, kmc *km.Cluster, previous []v1.Service) error {
+	if kmc.Spec.ExternalAddress == "" && kmc.Spec.Service.Type != v1.ServiceTypeClusterIP {
+		return nil
+	}
+	for i := range previous {
+		log.FromContext(ctx).Info("Deleting the service of the previous service type", "service", previous[i].Name)
+		if err := r.Client.Delete(ctx, &previous[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serviceAddresses returns the addresses the external address of the cluster is detected from for the service: the
+// load balancer addresses of a LoadBalancer service or the node addresses of a NodePort service.
+func (r *ClusterReconciler) serviceAddresses(ctx context.Context, svc *v1.Service) ([]string, error) {
+	switch svc.Spec.Type {
+	case v1.ServiceTypeLoadBalancer:
+		var addresses []string
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				addresses = append(addresses, ingress.IP)
+			}
+			if ingress.Hostname != "" {
+				addresses = append(addresses, ingress.Hostname)
+			}
+		}
+		return addresses, nil
+	case v1.ServiceTypeNodePort:
+		nodes, err := r.ClientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return util.NodeAddresses(nodes), nil
+	default:
+		return nil, nil
+	}
+}
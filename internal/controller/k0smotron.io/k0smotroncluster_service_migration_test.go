@@ -0,0 +1,94 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestServiceMigration(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	// The cluster was migrated from a LoadBalancer service to a ClusterIP one behind an ingress
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "uid"},
+		Spec: km.ClusterSpec{
+			ExternalAddress: "1.1.1.1",
+			Service:         km.ServiceSpec{Type: v1.ServiceTypeClusterIP},
+		},
+	}
+	owner := metav1.OwnerReference{APIVersion: km.GroupVersion.String(), Kind: "Cluster", Name: "test", UID: "uid", Controller: ptr.To(true)}
+	lb := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: kmc.GetLoadBalancerServiceName(), Namespace: "default", OwnerReferences: []metav1.OwnerReference{owner}},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		Status:     v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "1.1.1.1"}}}},
+	}
+	// Services not created by k0smotron are left alone
+	nodePort := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: kmc.GetNodePortServiceName(), Namespace: "default"},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeNodePort},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(kmc, lb, nodePort).Build()
+	r := &ClusterReconciler{Client: c, Scheme: scheme}
+	ctx := context.Background()
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(kmc), kmc))
+
+	previous, err := r.previousServices(ctx, kmc)
+	require.NoError(t, err)
+	require.Len(t, previous, 1)
+	assert.Equal(t, lb.Name, previous[0].Name)
+
+	// The address of the load balancer is not the address of the cluster anymore
+	require.NoError(t, r.resetPreviousServiceAddress(ctx, kmc, previous))
+	assert.Empty(t, kmc.Spec.ExternalAddress)
+
+	require.NoError(t, r.deletePreviousServices(ctx, kmc, previous))
+	err = c.Get(ctx, client.ObjectKeyFromObject(lb), &v1.Service{})
+	assert.True(t, apierrors.IsNotFound(err))
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(nodePort), &v1.Service{}))
+}
+
+func TestServiceMigration_keepsUserAddress(t *testing.T) {
+	r := &ClusterReconciler{}
+	kmc := &km.Cluster{Spec: km.ClusterSpec{
+		ExternalAddress: "api.example.com",
+		Service:         km.ServiceSpec{Type: v1.ServiceTypeNodePort},
+	}}
+	previous := []v1.Service{{
+		Spec:   v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		Status: v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{Hostname: "lb.example.com"}}}},
+	}}
+
+	require.NoError(t, r.resetPreviousServiceAddress(context.Background(), kmc, previous))
+	assert.Equal(t, "api.example.com", kmc.Spec.ExternalAddress)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+const (
+	// apiServingCertLabel is set on the cert-manager issued secret to map it back to the cluster.
+	apiServingCertLabel = "k0smotron.io/api-serving-cert"
+	// apiServingCertHashAnnotation is set on the pod template to roll the pods when the certificate is renewed.
+	apiServingCertHashAnnotation = "k0smotron.io/api-serving-cert-hash"
+	apiServingCertMountPath      = "/var/lib/k0smotron/api-serving-cert"
+	apiServingCertVolumeName     = "api-serving-cert"
+)
+
+// errAPIServingCertNotReady is returned when the API serving certificate cannot be issued yet.
+var errAPIServingCertNotReady = errors.New("API serving certificate is not issued yet")
+
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+
+// reconcileAPIServingCertificate creates the cert-manager Certificate for the API server serving certificate
+// and checks that the resulting secret is issued.
+func (r *ClusterReconciler) reconcileAPIServingCertificate(ctx context.Context, kmc *km.Cluster) error {
+	if kmc.Spec.Certificates.CertManager == nil {
+		return nil
+	}
+
+	cert, err := r.generateAPIServingCertificate(kmc)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Client.Patch(ctx, cert, client.Apply, patchOpts...); err != nil {
+		return fmt.Errorf("failed to apply cert-manager certificate: %w", err)
+	}
+
+	var s v1.Secret
+	err = r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetAPIServingCertificateSecretName(), Namespace: kmc.Namespace}, &s)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return errAPIServingCertNotReady
+		}
+		return err
+	}
+	if len(s.Data[v1.TLSCertKey]) == 0 || len(s.Data[v1.TLSPrivateKeyKey]) == 0 {
+		return errAPIServingCertNotReady
+	}
+
+	return nil
+}
+
+func (r *ClusterReconciler) generateAPIServingCertificate(kmc *km.Cluster) (*unstructured.Unstructured, error) {
+	certManager := kmc.Spec.Certificates.CertManager
+
+	var dnsNames, ipAddresses []interface{}
+	if kmc.Spec.ExternalAddress != "" {
+		if net.ParseIP(kmc.Spec.ExternalAddress) != nil {
+			ipAddresses = append(ipAddresses, kmc.Spec.ExternalAddress)
+		} else {
+			dnsNames = append(dnsNames, kmc.Spec.ExternalAddress)
+		}
+	}
+	if kmc.Spec.DNS != nil && kmc.Spec.DNS.Hostname != kmc.Spec.ExternalAddress {
+		dnsNames = append(dnsNames, kmc.Spec.DNS.Hostname)
+	}
+	for _, name := range certManager.DNSNames {
+		dnsNames = append(dnsNames, name)
+	}
+	if len(dnsNames) == 0 && len(ipAddresses) == 0 {
+		// Wait for the external address to be detected
+		return nil, errAPIServingCertNotReady
+	}
+
+	spec := map[string]interface{}{
+		"secretName": kmc.GetAPIServingCertificateSecretName(),
+		"issuerRef": map[string]interface{}{
+			"name":  certManager.IssuerRef.Name,
+			"kind":  certManager.IssuerRef.Kind,
+			"group": certManager.IssuerRef.Group,
+		},
+		"secretTemplate": map[string]interface{}{
+			"labels": map[string]interface{}{
+				apiServingCertLabel: kmc.Name,
+			},
+		},
+		"usages": []interface{}{"server auth", "digital signature", "key encipherment"},
+	}
+	if len(dnsNames) > 0 {
+		spec["dnsNames"] = dnsNames
+	}
+	if len(ipAddresses) > 0 {
+		spec["ipAddresses"] = ipAddresses
+	}
+
+	cert := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	cert.SetAPIVersion("cert-manager.io/v1")
+	cert.SetKind("Certificate")
+	cert.SetName(kmc.GetAPIServingCertificateName())
+	cert.SetNamespace(kmc.Namespace)
+	cert.SetLabels(labelsForCluster(kmc))
+	cert.SetAnnotations(annotationsForCluster(kmc))
+
+	if err := ctrl.SetControllerReference(kmc, cert, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// mountAPIServingCert mounts the API serving certificate secret to the controller and sets the hash of the
+// certificate to the pod template, so the pods are rolled when cert-manager renews the certificate.
+func (r *ClusterReconciler) mountAPIServingCert(ctx context.Context, kmc *km.Cluster, podTemplate *v1.PodTemplateSpec) error {
+	var s v1.Secret
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetAPIServingCertificateSecretName(), Namespace: kmc.Namespace}, &s); err != nil {
+		return fmt.Errorf("failed to get API serving certificate secret: %w", err)
+	}
+
+	if podTemplate.Annotations == nil {
+		podTemplate.Annotations = map[string]string{}
+	}
+	podTemplate.Annotations[apiServingCertHashAnnotation] = fmt.Sprintf("%x", sha256.Sum256(append(s.Data[v1.TLSCertKey], s.Data[v1.TLSPrivateKeyKey]...)))
+
+	// The volume is replaced if the pod template already has it, e.g. from the patches of the spec
+	podTemplate.Spec.Volumes = slices.DeleteFunc(podTemplate.Spec.Volumes, func(v v1.Volume) bool {
+		return v.Name == apiServingCertVolumeName
+	})
+	podTemplate.Spec.Volumes = append(podTemplate.Spec.Volumes, v1.Volume{
+		Name: apiServingCertVolumeName,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: kmc.GetAPIServingCertificateSecretName(),
+				Items: []v1.KeyToPath{
+					{Key: v1.TLSCertKey, Path: v1.TLSCertKey},
+					{Key: v1.TLSPrivateKeyKey, Path: v1.TLSPrivateKeyKey},
+				},
+			},
+		},
+	})
+	container := &podTemplate.Spec.Containers[0]
+	container.VolumeMounts = slices.DeleteFunc(container.VolumeMounts, func(m v1.VolumeMount) bool {
+		return m.Name == apiServingCertVolumeName
+	})
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+		Name:      apiServingCertVolumeName,
+		MountPath: apiServingCertMountPath,
+		ReadOnly:  true,
+	})
+
+	return nil
+}
+
+// apiServingCertSNIArg returns the kube-apiserver tls-sni-cert-key argument for the API serving certificate.
+// The certificate is served only for the names it contains, so the k0s issued certificate is still used
+// for the in-cluster communication.
+func apiServingCertSNIArg() string {
+	return fmt.Sprintf("%s/%s,%s/%s", apiServingCertMountPath, v1.TLSCertKey, apiServingCertMountPath, v1.TLSPrivateKeyKey)
+}
+
+// requestsForAPIServingCertSecret maps the API serving certificate secret to the cluster.
+func requestsForAPIServingCertSecret(_ context.Context, obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[apiServingCertLabel]
+	if !ok || name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}}}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestGenerateAPIServingCertificate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+	r := ClusterReconciler{Scheme: scheme}
+
+	tests := []struct {
+		name            string
+		externalAddress string
+		dnsNames        []string
+		wantDNSNames    []interface{}
+		wantIPs         []interface{}
+		wantErr         bool
+		wantNotReady    bool
+	}{
+		{
+			name:            "hostname external address",
+			externalAddress: "api.example.com",
+			wantDNSNames:    []interface{}{"api.example.com"},
+		},
+		{
+			name:            "ip external address with dns names",
+			externalAddress: "1.2.3.4",
+			dnsNames:        []string{"api.example.com"},
+			wantDNSNames:    []interface{}{"api.example.com"},
+			wantIPs:         []interface{}{"1.2.3.4"},
+		},
+		{
+			name:            "ip external address without dns names",
+			externalAddress: "1.2.3.4",
+			wantIPs:         []interface{}{"1.2.3.4"},
+		},
+		{
+			name:         "dns names before the external address is detected",
+			dnsNames:     []string{"api.example.com"},
+			wantDNSNames: []interface{}{"api.example.com"},
+		},
+		{
+			name:         "external address not detected yet",
+			wantErr:      true,
+			wantNotReady: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kmc := &km.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				Spec: km.ClusterSpec{
+					ExternalAddress: tt.externalAddress,
+					Certificates: km.CertificatesSpec{
+						CertManager: &km.CertManagerSpec{
+							IssuerRef: km.CertManagerIssuerRef{Name: "my-issuer", Kind: "ClusterIssuer", Group: "cert-manager.io"},
+							DNSNames:  tt.dnsNames,
+						},
+					},
+				},
+			}
+
+			cert, err := r.generateAPIServingCertificate(kmc)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantNotReady, errors.Is(err, errAPIServingCertNotReady))
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, "kmc-test-api-serving", cert.GetName())
+			dnsNames, _, _ := unstructured.NestedSlice(cert.Object, "spec", "dnsNames")
+			assert.Equal(t, tt.wantDNSNames, dnsNames)
+			ips, _, _ := unstructured.NestedSlice(cert.Object, "spec", "ipAddresses")
+			assert.Equal(t, tt.wantIPs, ips)
+			secretName, _, _ := unstructured.NestedString(cert.Object, "spec", "secretName")
+			assert.Equal(t, "kmc-test-api-serving-cert", secretName)
+			issuerKind, _, _ := unstructured.NestedString(cert.Object, "spec", "issuerRef", "kind")
+			assert.Equal(t, "ClusterIssuer", issuerKind)
+		})
+	}
+}
+
+func TestMountAPIServingCert(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	kmc := &km.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: kmc.GetAPIServingCertificateSecretName(), Namespace: "default"},
+		Data:       map[string][]byte{v1.TLSCertKey: []byte("cert"), v1.TLSPrivateKeyKey: []byte("key")},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()
+	r := ClusterReconciler{Client: c, Scheme: scheme}
+
+	podTemplate := v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "controller"}}}}
+	require.NoError(t, r.mountAPIServingCert(context.Background(), kmc, &podTemplate))
+	hash := podTemplate.Annotations[apiServingCertHashAnnotation]
+	assert.NotEmpty(t, hash)
+	assert.Equal(t, apiServingCertMountPath, podTemplate.Spec.Containers[0].VolumeMounts[0].MountPath)
+	assert.Equal(t, secret.Name, podTemplate.Spec.Volumes[0].Secret.SecretName)
+
+	// Renewed certificate must change the pod template
+	secret.Data[v1.TLSCertKey] = []byte("renewed")
+	require.NoError(t, c.Update(context.Background(), secret))
+	podTemplate = v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "controller"}}}}
+	require.NoError(t, r.mountAPIServingCert(context.Background(), kmc, &podTemplate))
+	assert.NotEqual(t, hash, podTemplate.Annotations[apiServingCertHashAnnotation])
+
+	// The volume already in the pod template is replaced, not duplicated
+	require.NoError(t, r.mountAPIServingCert(context.Background(), kmc, &podTemplate))
+	assert.Len(t, podTemplate.Spec.Volumes, 1)
+	assert.Len(t, podTemplate.Spec.Containers[0].VolumeMounts, 1)
+
+	requests := requestsForAPIServingCertSecret(context.Background(), &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kmc-test-api-serving-cert", Namespace: "default", Labels: map[string]string{apiServingCertLabel: "test"}},
+	})
+	require.Len(t, requests, 1)
+	assert.Equal(t, types.NamespacedName{Name: "test", Namespace: "default"}, requests[0].NamespacedName)
+}
@@ -41,6 +41,9 @@ var entrypointDefaultMode = int32(0744)
 const (
 	clusterLabel          = "k0smotron.io/cluster"
 	statefulSetAnnotation = "k0smotron.io/statefulset-hash"
+	// externalAddressAnnotation is set on the pod template to restart k0s with the certificates and the config of a
+	// new external address, e.g. after the service type of the cluster was changed.
+	externalAddressAnnotation = "k0smotron.io/external-address"
 )
 
 // findStatefulSetPod returns a first running pod from a StatefulSet
@@ -48,7 +51,7 @@ func (r *ClusterReconciler) findStatefulSetPod(ctx context.Context, statefulSet
 	return util.FindStatefulSetPod(ctx, r.ClientSet, statefulSet, namespace)
 }
 
-func (r *ClusterReconciler) generateStatefulSet(kmc *km.Cluster) (apps.StatefulSet, error) {
+func (r *ClusterReconciler) generateStatefulSet(ctx context.Context, kmc *km.Cluster) (apps.StatefulSet, error) {
 
 	labels := labelsForCluster(kmc)
 
@@ -162,6 +165,15 @@ func (r *ClusterReconciler) generateStatefulSet(kmc *km.Cluster) (apps.StatefulS
 			kmc.Spec.Persistence.Type = "emptyDir"
 		}
 		r.addMonitoringStack(kmc, &statefulSet)
+		if kmc.Spec.Monitoring.Auth != nil {
+			if err := r.setMonitoringAuthHash(ctx, kmc, &statefulSet.Spec.Template); err != nil {
+				return apps.StatefulSet{}, err
+			}
+		}
+	}
+
+	if kmc.Spec.Logging.Enabled {
+		addLogForwarder(kmc, &statefulSet.Spec.Template, "controller")
 	}
 
 	if kmc.Spec.KineDataSourceSecretName != "" {
@@ -229,6 +241,8 @@ func (r *ClusterReconciler) generateStatefulSet(kmc *km.Cluster) (apps.StatefulS
 		})
 	}
 
+	addVeleroControllerVolumes(kmc, &statefulSet.Spec.Template)
+
 	for _, manifest := range kmc.Spec.Manifests {
 		statefulSet.Spec.Template.Spec.Volumes = append(statefulSet.Spec.Template.Spec.Volumes, manifest)
 
@@ -239,35 +253,33 @@ func (r *ClusterReconciler) generateStatefulSet(kmc *km.Cluster) (apps.StatefulS
 		})
 	}
 
-	// Create k0s telemetry config in the configmap and mount it to the controller pod
-	// If user disables k0s telemetry this will have not effect.
-	cm := &v1.ConfigMap{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: "v1",
-			Kind:       "ConfigMap",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("kmc-%s-telemetry-config", kmc.Name),
-			Namespace: kmc.Namespace,
-		},
-		Data: map[string]string{
-			"configmap.yaml": `
-apiVersion: v1
-kind: ConfigMap
-metadata:
-  name: k0s-telemetry
-  namespace: kube-system
-data:
-  provider: "k0smotron"
-`,
-		},
-	}
-	if err := ctrl.SetControllerReference(kmc, cm, r.Scheme); err != nil {
-		return apps.StatefulSet{}, err
+	// The emptied configmap stays mounted, so k0s removes the previously created bindings
+	hasAccessControl := len(kmc.Spec.AccessControl.ClusterRoleBindings) > 0
+	if !hasAccessControl {
+		exists, err := r.accessControlCMExists(ctx, kmc)
+		if err != nil {
+			return apps.StatefulSet{}, err
+		}
+		hasAccessControl = exists
 	}
-	if err := r.Client.Patch(context.Background(), cm, client.Apply, patchOpts...); err != nil {
-		return apps.StatefulSet{}, err
+	if hasAccessControl {
+		statefulSet.Spec.Template.Spec.Volumes = append(statefulSet.Spec.Template.Spec.Volumes, v1.Volume{
+			Name: kmc.GetAccessControlConfigMapName(),
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: kmc.GetAccessControlConfigMapName()},
+				},
+			},
+		})
+		statefulSet.Spec.Template.Spec.Containers[0].VolumeMounts = append(statefulSet.Spec.Template.Spec.Containers[0].VolumeMounts, v1.VolumeMount{
+			Name:      kmc.GetAccessControlConfigMapName(),
+			MountPath: accessControlManifestsPath,
+			ReadOnly:  true,
+		})
 	}
+
+	// Mount the k0s telemetry config to the controller pod. If user disables k0s telemetry this will have not effect.
+	cm := r.generateTelemetryCM(kmc)
 	statefulSet.Spec.Template.Spec.Volumes = append(statefulSet.Spec.Template.Spec.Volumes, v1.Volume{
 		Name: cm.Name,
 		VolumeSource: v1.VolumeSource{
@@ -283,6 +295,18 @@ data:
 		ReadOnly:  true,
 	})
 
+	if kmc.Spec.Certificates.CertManager != nil {
+		if err := r.mountAPIServingCert(ctx, kmc, &statefulSet.Spec.Template); err != nil {
+			return apps.StatefulSet{}, err
+		}
+	}
+
+	if err := r.mountWebhooks(ctx, kmc, &statefulSet.Spec.Template); err != nil {
+		return apps.StatefulSet{}, err
+	}
+
+	setPodAnnotations(&statefulSet.Spec.Template, map[string]string{externalAddressAnnotation: kmc.Spec.ExternalAddress})
+
 	err := ctrl.SetControllerReference(kmc, &statefulSet, r.Scheme)
 
 	statefulSet.Annotations = map[string]string{
@@ -292,6 +316,33 @@ data:
 	return statefulSet, err
 }
 
+// generateTelemetryCM generates the k0s telemetry config of the cluster, applied by k0s from its manifests directory.
+func (r *ClusterReconciler) generateTelemetryCM(kmc *km.Cluster) *v1.ConfigMap {
+	cm := &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("kmc-%s-telemetry-config", kmc.Name),
+			Namespace: kmc.Namespace,
+		},
+		Data: map[string]string{
+			"configmap.yaml": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: k0s-telemetry
+  namespace: kube-system
+data:
+  provider: "k0smotron"
+`,
+		},
+	}
+	_ = ctrl.SetControllerReference(kmc, cm, r.Scheme)
+	return cm
+}
+
 // mountSecrets mounts the certificates as secrets to the controller and creates
 // an init container that copies the certificates to the correct location
 func (r *ClusterReconciler) mountSecrets(kmc *km.Cluster, sfs *apps.StatefulSet) {
@@ -380,6 +431,26 @@ func (r *ClusterReconciler) mountSecrets(kmc *km.Cluster, sfs *apps.StatefulSet)
 					},
 				},
 			})
+		case externalEtcdClientCertType:
+			projectedSecrets = append(projectedSecrets, v1.VolumeProjection{
+				Secret: &v1.SecretProjection{
+					LocalObjectReference: v1.LocalObjectReference{Name: cert.Name},
+					Items: []v1.KeyToPath{
+						{
+							Key:  "ca.crt",
+							Path: "external-etcd-ca.crt",
+						},
+						{
+							Key:  "tls.crt",
+							Path: "external-etcd-client.crt",
+						},
+						{
+							Key:  "tls.key",
+							Path: "external-etcd-client.key",
+						},
+					},
+				},
+			})
 
 		}
 	}
@@ -417,25 +488,49 @@ func (r *ClusterReconciler) mountSecrets(kmc *km.Cluster, sfs *apps.StatefulSet)
 
 func (r *ClusterReconciler) addMonitoringStack(kmc *km.Cluster, statefulSet *apps.StatefulSet) {
 	nginxConfCMName := kmc.GetMonitoringConfigMapName() + "-nginx"
+	agentArgs := []string{"--storage.tsdb.retention.size=200MB"}
+	if kmc.Spec.Monitoring.Retention != "" {
+		agentArgs = append(agentArgs, "--storage.tsdb.retention.time="+kmc.Spec.Monitoring.Retention)
+	}
+	agentVolumeMounts := []v1.VolumeMount{{
+		Name:      kmc.GetVolumeName(),
+		MountPath: "/var/lib/k0s",
+	}, {
+		Name:      kmc.GetMonitoringConfigMapName(),
+		MountPath: "/prometheus/prometheus.yml",
+		SubPath:   "prometheus.yml",
+	}}
+	for i, rw := range kmc.Spec.Monitoring.RemoteWrite {
+		secretName := remoteWriteSecretName(rw)
+		if secretName == "" {
+			continue
+		}
+		agentVolumeMounts = append(agentVolumeMounts, v1.VolumeMount{
+			Name:      remoteWriteVolumeName(i),
+			MountPath: remoteWriteSecretDir(i),
+			ReadOnly:  true,
+		})
+		statefulSet.Spec.Template.Spec.Volumes = append(statefulSet.Spec.Template.Spec.Volumes, v1.Volume{
+			Name: remoteWriteVolumeName(i),
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{SecretName: secretName},
+			},
+		})
+	}
+
 	statefulSet.Spec.Template.Spec.Containers = append(statefulSet.Spec.Template.Spec.Containers, v1.Container{
 		Name:            "monitoring-agent",
 		Image:           kmc.Spec.Monitoring.PrometheusImage,
 		ImagePullPolicy: v1.PullIfNotPresent,
 		Command:         []string{"prometheus", "--config.file=/prometheus/prometheus.yml"},
-		Args:            []string{"--storage.tsdb.retention.size=200MB"},
+		Args:            agentArgs,
 		Ports: []v1.ContainerPort{{
 			Name:          "prometheus",
 			Protocol:      v1.ProtocolTCP,
 			ContainerPort: int32(9090),
 		}},
-		VolumeMounts: []v1.VolumeMount{{
-			Name:      kmc.GetVolumeName(),
-			MountPath: "/var/lib/k0s",
-		}, {
-			Name:      kmc.GetMonitoringConfigMapName(),
-			MountPath: "/prometheus/prometheus.yml",
-			SubPath:   "prometheus.yml",
-		}},
+		Resources:    kmc.Spec.Monitoring.AgentResources,
+		VolumeMounts: agentVolumeMounts,
 	}, v1.Container{
 		Name:            "monitoring-proxy",
 		Image:           kmc.Spec.Monitoring.ProxyImage,
@@ -445,6 +540,7 @@ func (r *ClusterReconciler) addMonitoringStack(kmc *km.Cluster, statefulSet *app
 			Protocol:      v1.ProtocolTCP,
 			ContainerPort: int32(8090),
 		}},
+		Resources: kmc.Spec.Monitoring.ProxyResources,
 		VolumeMounts: []v1.VolumeMount{{
 			Name:      nginxConfCMName,
 			MountPath: "/etc/nginx/nginx.conf",
@@ -453,10 +549,45 @@ func (r *ClusterReconciler) addMonitoringStack(kmc *km.Cluster, statefulSet *app
 	})
 
 	statefulSet.Spec.Template.Annotations = map[string]string{
-		"prometheus.io/scrape": "true",
-		"prometheus.io/port":   "8090",
-		"prometheus.io/path":   "/metrics",
+		"prometheus.io/scrape":         "true",
+		"prometheus.io/port":           "8090",
+		"prometheus.io/path":           "/metrics",
+		monitoringConfigHashAnnotation: monitoringConfigHash(kmc),
+	}
+
+	nginxConfVolumeSource := v1.VolumeSource{
+		ConfigMap: &v1.ConfigMapVolumeSource{
+			LocalObjectReference: v1.LocalObjectReference{
+				Name: kmc.GetMonitoringConfigMapName(),
+			},
+			DefaultMode: &entrypointDefaultMode,
+			Items: []v1.KeyToPath{{
+				Key:  "nginx.conf",
+				Path: "nginx.conf",
+			}},
+		},
 	}
+	if kmc.Spec.Monitoring.Auth != nil {
+		// The proxy config holding the bearer token is kept in a secret and the API server certificate is used to
+		// serve the metrics over HTTPS.
+		nginxConfVolumeSource = v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: kmc.GetMonitoringAuthSecretName(),
+				Items: []v1.KeyToPath{{
+					Key:  "nginx.conf",
+					Path: "nginx.conf",
+				}},
+			},
+		}
+		proxy := &statefulSet.Spec.Template.Spec.Containers[len(statefulSet.Spec.Template.Spec.Containers)-1]
+		proxy.VolumeMounts = append(proxy.VolumeMounts, v1.VolumeMount{
+			Name:      kmc.GetVolumeName(),
+			MountPath: "/var/lib/k0s",
+			ReadOnly:  true,
+		})
+		statefulSet.Spec.Template.Annotations["prometheus.io/scheme"] = "https"
+	}
+
 	statefulSet.Spec.Template.Spec.Volumes = append(statefulSet.Spec.Template.Spec.Volumes, v1.Volume{
 		Name: kmc.GetMonitoringConfigMapName(),
 		VolumeSource: v1.VolumeSource{
@@ -472,26 +603,18 @@ func (r *ClusterReconciler) addMonitoringStack(kmc *km.Cluster, statefulSet *app
 			},
 		},
 	}, v1.Volume{
-		Name: nginxConfCMName,
-		VolumeSource: v1.VolumeSource{
-			ConfigMap: &v1.ConfigMapVolumeSource{
-				LocalObjectReference: v1.LocalObjectReference{
-					Name: kmc.GetMonitoringConfigMapName(),
-				},
-				DefaultMode: &entrypointDefaultMode,
-				Items: []v1.KeyToPath{{
-					Key:  "nginx.conf",
-					Path: "nginx.conf",
-				}},
-			},
-		},
+		Name:         nginxConfCMName,
+		VolumeSource: nginxConfVolumeSource,
 	})
 }
 
 func (r *ClusterReconciler) reconcileStatefulSet(ctx context.Context, kmc km.Cluster) error {
 	logger := log.FromContext(ctx)
 	logger.Info("Reconciling statefulset")
-	statefulSet, err := r.generateStatefulSet(&kmc)
+	if err := util.ApplyConfigMap(ctx, r.Client, r.generateTelemetryCM(&kmc), patchOpts...); err != nil {
+		return fmt.Errorf("failed to apply telemetry configmap: %w", err)
+	}
+	statefulSet, err := r.generateStatefulSet(ctx, &kmc)
 	if err != nil {
 		return fmt.Errorf("failed to generate statefulset: %w", err)
 	}
@@ -501,6 +624,13 @@ func (r *ClusterReconciler) reconcileStatefulSet(ctx context.Context, kmc km.Clu
 		return r.Client.Patch(ctx, &statefulSet, client.Apply, patchOpts...)
 	} else if err == nil {
 		if !isStatefulSetsEqual(&statefulSet, foundStatefulSet) {
+			if hasImmutableStatefulSetChanges(&statefulSet, foundStatefulSet) {
+				// The selector and the volume claim templates can't be updated, so the statefulset is recreated.
+				// The pods and the existing PVCs are orphaned, so the control plane keeps running and the new
+				// statefulset adopts the pods and rolls them.
+				logger.Info("Recreating statefulset to apply the persistence changes")
+				return r.Client.Delete(ctx, foundStatefulSet, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+			}
 			return r.Client.Patch(ctx, &statefulSet, client.Apply, patchOpts...)
 		}
 
@@ -514,10 +644,35 @@ func (r *ClusterReconciler) reconcileStatefulSet(ctx context.Context, kmc km.Clu
 	return err
 }
 
+// setReplicasStatus sets the number of controller pods and their label selector to the cluster status,
+// so the cluster can be scaled with the scale subresource.
+func (r *ClusterReconciler) setReplicasStatus(ctx context.Context, kmc *km.Cluster) error {
+	kmc.Status.Selector = metav1.FormatLabelSelector(&metav1.LabelSelector{MatchLabels: labelsForCluster(kmc)})
+
+	var statefulSet apps.StatefulSet
+	err := r.Client.Get(ctx, client.ObjectKey{Name: kmc.GetStatefulSetName(), Namespace: kmc.Namespace}, &statefulSet)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			kmc.Status.Replicas = 0
+			return nil
+		}
+		return err
+	}
+	kmc.Status.Replicas = statefulSet.Status.Replicas
+
+	return nil
+}
+
+// hasImmutableStatefulSetChanges returns true if the statefulset has changes in the fields that can't be updated.
+func hasImmutableStatefulSetChanges(new, old *apps.StatefulSet) bool {
+	return !reflect.DeepEqual(new.Spec.Selector, old.Spec.Selector) ||
+		len(new.Spec.VolumeClaimTemplates) != len(old.Spec.VolumeClaimTemplates) ||
+		!equality.Semantic.DeepDerivative(new.Spec.VolumeClaimTemplates, old.Spec.VolumeClaimTemplates)
+}
+
 func isStatefulSetsEqual(new, old *apps.StatefulSet) bool {
 	return *new.Spec.Replicas == *old.Spec.Replicas &&
 		new.Annotations[statefulSetAnnotation] == old.Annotations[statefulSetAnnotation] &&
-		reflect.DeepEqual(new.Spec.Selector, old.Spec.Selector) &&
-		equality.Semantic.DeepDerivative(new.Spec.VolumeClaimTemplates, old.Spec.VolumeClaimTemplates)
+		!hasImmutableStatefulSetChanges(new, old)
 
 }
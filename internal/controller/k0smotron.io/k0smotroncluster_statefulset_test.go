@@ -0,0 +1,125 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestSetReplicasStatus(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, km.AddToScheme(scheme))
+
+	kmc := km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec:       km.ClusterSpec{Replicas: 3},
+	}
+
+	tests := []struct {
+		name         string
+		objs         []client.Object
+		wantReplicas int32
+	}{
+		{
+			name:         "statefulset not created yet",
+			wantReplicas: 0,
+		},
+		{
+			name: "statefulset scaling up",
+			objs: []client.Object{&apps.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: kmc.GetStatefulSetName(), Namespace: "default"},
+				Status:     apps.StatefulSetStatus{Replicas: 2},
+			}},
+			wantReplicas: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ClusterReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.objs...).Build()}
+
+			kmc := kmc.DeepCopy()
+			require.NoError(t, r.setReplicasStatus(context.Background(), kmc))
+			require.Equal(t, tt.wantReplicas, kmc.Status.Replicas)
+			require.Equal(t, "app=k0smotron,cluster=test,component=cluster", kmc.Status.Selector)
+		})
+	}
+}
+
+func TestHasImmutableStatefulSetChanges(t *testing.T) {
+	pvc := func(size string) []v1.PersistentVolumeClaim {
+		return []v1.PersistentVolumeClaim{{
+			ObjectMeta: metav1.ObjectMeta{Name: "kmc-test"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(size)}},
+			},
+		}}
+	}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "k0smotron"}}
+
+	tests := []struct {
+		name string
+		new  []v1.PersistentVolumeClaim
+		old  []v1.PersistentVolumeClaim
+		want bool
+	}{
+		{
+			name: "no persistence",
+		},
+		{
+			name: "same volume claim templates",
+			new:  pvc("1Gi"),
+			old:  pvc("1Gi"),
+		},
+		{
+			name: "volume claim size changed",
+			new:  pvc("2Gi"),
+			old:  pvc("1Gi"),
+			want: true,
+		},
+		{
+			name: "persistence enabled",
+			new:  pvc("1Gi"),
+			want: true,
+		},
+		{
+			name: "persistence disabled",
+			old:  pvc("1Gi"),
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			new := &apps.StatefulSet{Spec: apps.StatefulSetSpec{Selector: selector, VolumeClaimTemplates: tt.new}}
+			old := &apps.StatefulSet{Spec: apps.StatefulSetSpec{Selector: selector, VolumeClaimTemplates: tt.old}}
+			require.Equal(t, tt.want, hasImmutableStatefulSetChanges(new, old))
+		})
+	}
+}
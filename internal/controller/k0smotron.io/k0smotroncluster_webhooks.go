@@ -0,0 +1,211 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+const (
+	// clusterWebhookKubeconfigSecretField is the field index of the Secrets holding the kubeconfigs of the webhooks.
+	clusterWebhookKubeconfigSecretField = "spec.webhooks.kubeconfigSecretRef"
+	// webhooksHashAnnotation is set on the pod template to roll the pods when the kubeconfigs of the webhooks change.
+	webhooksHashAnnotation = "k0smotron.io/webhooks-hash"
+	// webhookKubeconfigKey is the key of the kubeconfig in the Secrets of the webhooks.
+	webhookKubeconfigKey = "kubeconfig"
+
+	webhooksMountPath              = "/var/lib/k0smotron/webhooks"
+	authorizationWebhookKubeconfig = "authorization-webhook.kubeconfig"
+	admissionWebhookKubeconfig     = "admission-webhook.kubeconfig"
+	admissionConfigFile            = "admission-config.yaml"
+)
+
+// admissionConfigTemplate configures the validating and mutating admission plugins to present the credentials of the
+// admission kubeconfig to the webhooks.
+const admissionConfigTemplate = `apiVersion: apiserver.config.k8s.io/v1
+kind: AdmissionConfiguration
+plugins:
+- name: ValidatingAdmissionWebhook
+  configuration:
+    apiVersion: apiserver.config.k8s.io/v1
+    kind: WebhookAdmissionConfiguration
+    kubeConfigFile: %[1]s
+- name: MutatingAdmissionWebhook
+  configuration:
+    apiVersion: apiserver.config.k8s.io/v1
+    kind: WebhookAdmissionConfiguration
+    kubeConfigFile: %[1]s
+`
+
+// reconcileWebhooksCM creates the admission configuration of the API server, mounted to the controller pods with the
+// kubeconfigs of the webhooks.
+func (r *ClusterReconciler) reconcileWebhooksCM(ctx context.Context, kmc km.Cluster) error {
+	if kmc.Spec.Webhooks.Admission == nil {
+		return nil
+	}
+
+	cm := r.generateWebhooksCM(&kmc)
+	return kutil.ApplyConfigMap(ctx, r.Client, &cm, patchOpts...)
+}
+
+func (r *ClusterReconciler) generateWebhooksCM(kmc *km.Cluster) v1.ConfigMap {
+	cm := v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        kmc.GetWebhooksConfigMapName(),
+			Namespace:   kmc.Namespace,
+			Labels:      labelsForCluster(kmc),
+			Annotations: annotationsForCluster(kmc),
+		},
+		Data: map[string]string{
+			admissionConfigFile: fmt.Sprintf(admissionConfigTemplate, filepath.Join(webhooksMountPath, admissionWebhookKubeconfig)),
+		},
+	}
+
+	_ = ctrl.SetControllerReference(kmc, &cm, r.Scheme)
+	return cm
+}
+
+// webhookAPIServerArgs returns the kube-apiserver arguments enabling the webhooks.
+func webhookAPIServerArgs(kmc *km.Cluster) map[string]interface{} {
+	args := map[string]interface{}{}
+	if authz := kmc.Spec.Webhooks.Authorization; authz != nil {
+		// The webhook is only asked about the requests the built-in authorizers of k0s don't allow
+		args["authorization-mode"] = "Node,RBAC,Webhook"
+		args["authorization-webhook-config-file"] = filepath.Join(webhooksMountPath, authorizationWebhookKubeconfig)
+		args["authorization-webhook-version"] = "v1"
+		if authz.CacheAuthorizedTTL != nil {
+			args["authorization-webhook-cache-authorized-ttl"] = authz.CacheAuthorizedTTL.Duration.String()
+		}
+		if authz.CacheUnauthorizedTTL != nil {
+			args["authorization-webhook-cache-unauthorized-ttl"] = authz.CacheUnauthorizedTTL.Duration.String()
+		}
+	}
+	if kmc.Spec.Webhooks.Admission != nil {
+		args["admission-control-config-file"] = filepath.Join(webhooksMountPath, admissionConfigFile)
+	}
+	return args
+}
+
+// mountWebhooks mounts the kubeconfigs of the webhooks and the admission configuration to the controller and sets the
+// hash of the kubeconfigs to the pod template, so the pods are rolled when a kubeconfig changes.
+func (r *ClusterReconciler) mountWebhooks(ctx context.Context, kmc *km.Cluster, podTemplate *v1.PodTemplateSpec) error {
+	var sources []v1.VolumeProjection
+	hash := sha256.New()
+	addKubeconfig := func(ref v1.LocalObjectReference, path string) error {
+		var s v1.Secret
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: ref.Name, Namespace: kmc.Namespace}, &s); err != nil {
+			return fmt.Errorf("failed to get webhook kubeconfig secret: %w", err)
+		}
+		if _, ok := s.Data[webhookKubeconfigKey]; !ok {
+			return fmt.Errorf("webhook kubeconfig secret %s has no %s key", ref.Name, webhookKubeconfigKey)
+		}
+		hash.Write(s.Data[webhookKubeconfigKey])
+		sources = append(sources, v1.VolumeProjection{Secret: &v1.SecretProjection{
+			LocalObjectReference: ref,
+			Items:                []v1.KeyToPath{{Key: webhookKubeconfigKey, Path: path}},
+		}})
+		return nil
+	}
+
+	if authz := kmc.Spec.Webhooks.Authorization; authz != nil {
+		if err := addKubeconfig(authz.KubeconfigSecretRef, authorizationWebhookKubeconfig); err != nil {
+			return err
+		}
+	}
+	if admission := kmc.Spec.Webhooks.Admission; admission != nil {
+		if err := addKubeconfig(admission.KubeconfigSecretRef, admissionWebhookKubeconfig); err != nil {
+			return err
+		}
+		sources = append(sources, v1.VolumeProjection{ConfigMap: &v1.ConfigMapProjection{
+			LocalObjectReference: v1.LocalObjectReference{Name: kmc.GetWebhooksConfigMapName()},
+			Items:                []v1.KeyToPath{{Key: admissionConfigFile, Path: admissionConfigFile}},
+		}})
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	if podTemplate.Annotations == nil {
+		podTemplate.Annotations = map[string]string{}
+	}
+	podTemplate.Annotations[webhooksHashAnnotation] = fmt.Sprintf("%x", hash.Sum(nil))
+
+	podTemplate.Spec.Volumes = append(podTemplate.Spec.Volumes, v1.Volume{
+		Name: "webhooks",
+		VolumeSource: v1.VolumeSource{
+			Projected: &v1.ProjectedVolumeSource{Sources: sources},
+		},
+	})
+	podTemplate.Spec.Containers[0].VolumeMounts = append(podTemplate.Spec.Containers[0].VolumeMounts, v1.VolumeMount{
+		Name:      "webhooks",
+		MountPath: webhooksMountPath,
+		ReadOnly:  true,
+	})
+
+	return nil
+}
+
+// requestsForWebhookKubeconfigSecret maps the Secret holding the kubeconfig of a webhook to the clusters using it.
+func (r *ClusterReconciler) requestsForWebhookKubeconfigSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	var clusters km.ClusterList
+	if err := r.Client.List(ctx, &clusters, client.InNamespace(obj.GetNamespace()), client.MatchingFields{clusterWebhookKubeconfigSecretField: obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list clusters")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, kmc := range clusters.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: kmc.Name, Namespace: kmc.Namespace}})
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Name < requests[j].Name })
+	return requests
+}
+
+// indexClusterWebhookKubeconfigSecrets indexes the clusters by the Secrets holding the kubeconfigs of their webhooks.
+func indexClusterWebhookKubeconfigSecrets(obj client.Object) []string {
+	kmc, ok := obj.(*km.Cluster)
+	if !ok {
+		return nil
+	}
+	var names []string
+	if authz := kmc.Spec.Webhooks.Authorization; authz != nil {
+		names = append(names, authz.KubeconfigSecretRef.Name)
+	}
+	if admission := kmc.Spec.Webhooks.Admission; admission != nil && (len(names) == 0 || names[0] != admission.KubeconfigSecretRef.Name) {
+		names = append(names, admission.KubeconfigSecretRef.Name)
+	}
+	return names
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestWebhookAPIServerArgs(t *testing.T) {
+	kmc := &km.Cluster{}
+	assert.Empty(t, webhookAPIServerArgs(kmc))
+
+	kmc.Spec.Webhooks = km.WebhooksSpec{
+		Authorization: &km.AuthorizationWebhookSpec{
+			KubeconfigSecretRef: v1.LocalObjectReference{Name: "opa"},
+			CacheAuthorizedTTL:  &metav1.Duration{Duration: time.Minute},
+		},
+		Admission: &km.AdmissionWebhookSpec{KubeconfigSecretRef: v1.LocalObjectReference{Name: "webhooks"}},
+	}
+	assert.Equal(t, map[string]interface{}{
+		"authorization-mode":                         "Node,RBAC,Webhook",
+		"authorization-webhook-config-file":          "/var/lib/k0smotron/webhooks/authorization-webhook.kubeconfig",
+		"authorization-webhook-version":              "v1",
+		"authorization-webhook-cache-authorized-ttl": "1m0s",
+		"admission-control-config-file":              "/var/lib/k0smotron/webhooks/admission-config.yaml",
+	}, webhookAPIServerArgs(kmc))
+
+	r := ClusterReconciler{Scheme: &runtime.Scheme{}}
+	kmc.Spec.ExternalAddress = "my.external.address"
+	cm, _, err := r.generateConfig(kmc, []string{})
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(cm.Data["K0SMOTRON_K0S_YAML"], "authorization-mode: Node,RBAC,Webhook"))
+
+	webhooksCM := r.generateWebhooksCM(kmc)
+	assert.Contains(t, webhooksCM.Data[admissionConfigFile], "kubeConfigFile: /var/lib/k0smotron/webhooks/admission-webhook.kubeconfig")
+}
+
+func TestMountWebhooks(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: km.ClusterSpec{Webhooks: km.WebhooksSpec{
+			Authorization: &km.AuthorizationWebhookSpec{KubeconfigSecretRef: v1.LocalObjectReference{Name: "opa"}},
+			Admission:     &km.AdmissionWebhookSpec{KubeconfigSecretRef: v1.LocalObjectReference{Name: "opa"}},
+		}},
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "opa", Namespace: "default"},
+		Data:       map[string][]byte{"kubeconfig": []byte("kubeconfig")},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build()
+	r := ClusterReconciler{Client: c, Scheme: scheme}
+
+	podTemplate := v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "controller"}}}}
+	require.NoError(t, r.mountWebhooks(context.Background(), kmc, &podTemplate))
+	hash := podTemplate.Annotations[webhooksHashAnnotation]
+	assert.NotEmpty(t, hash)
+	assert.Equal(t, webhooksMountPath, podTemplate.Spec.Containers[0].VolumeMounts[0].MountPath)
+	sources := podTemplate.Spec.Volumes[0].Projected.Sources
+	require.Len(t, sources, 3)
+	assert.Equal(t, authorizationWebhookKubeconfig, sources[0].Secret.Items[0].Path)
+	assert.Equal(t, admissionWebhookKubeconfig, sources[1].Secret.Items[0].Path)
+	assert.Equal(t, kmc.GetWebhooksConfigMapName(), sources[2].ConfigMap.Name)
+
+	// A changed kubeconfig must change the pod template
+	secret.Data["kubeconfig"] = []byte("rotated")
+	require.NoError(t, c.Update(context.Background(), secret))
+	podTemplate = v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "controller"}}}}
+	require.NoError(t, r.mountWebhooks(context.Background(), kmc, &podTemplate))
+	assert.NotEqual(t, hash, podTemplate.Annotations[webhooksHashAnnotation])
+
+	assert.Equal(t, []string{"opa"}, indexClusterWebhookKubeconfigSecrets(kmc))
+
+	delete(secret.Data, "kubeconfig")
+	require.NoError(t, c.Update(context.Background(), secret))
+	assert.Error(t, r.mountWebhooks(context.Background(), kmc, &podTemplate))
+}
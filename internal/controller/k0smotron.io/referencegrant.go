@@ -0,0 +1,94 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// clusterRefNamespace returns the namespace of the referenced cluster, defaulting to the namespace of the referencing object.
+func clusterRefNamespace(ref km.ClusterRef, obj client.Object) string {
+	if ref.Namespace == "" {
+		return obj.GetNamespace()
+	}
+	return ref.Namespace
+}
+
+// isReferenceGranted checks whether the referencing resource is allowed to reference the given resource.
+// References within the same namespace are always allowed, cross-namespace references require
+// a ReferenceGrant in the namespace of the referenced resource.
+func isReferenceGranted(ctx context.Context, c client.Client, from km.ReferenceGrantFrom, toNamespace string, to km.ReferenceGrantTo) (bool, error) {
+	if from.Namespace == toNamespace {
+		return true, nil
+	}
+
+	var grants km.ReferenceGrantList
+	if err := c.List(ctx, &grants, client.InNamespace(toNamespace)); err != nil {
+		return false, fmt.Errorf("failed to list reference grants: %w", err)
+	}
+
+	for _, g := range grants.Items {
+		if g.Permits(from, to) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// isKubeconfigSecretGranted checks whether the cluster is allowed to write its kubeconfig to the Secret, e.g. for
+// the GitOps tools. A Secret in another namespace requires a ReferenceGrant in that namespace, from the Cluster to
+// the Secret, so the kubeconfig of a cluster is only shared with the namespaces accepting it.
+func isKubeconfigSecretGranted(ctx context.Context, c client.Client, kmc *km.Cluster, secret client.ObjectKey) (bool, error) {
+	return isReferenceGranted(ctx, c,
+		km.ReferenceGrantFrom{Group: km.GroupVersion.Group, Kind: "Cluster", Namespace: kmc.Namespace},
+		secret.Namespace,
+		km.ReferenceGrantTo{Group: "", Kind: "Secret", Name: secret.Name})
+}
+
+// requestsForReferenceGrant returns the clusters of the namespaces trusted by the grant, whose kubeconfig secrets in
+// the namespace of the grant are created or deleted when the grant changes.
+func (r *ClusterReconciler) requestsForReferenceGrant(ctx context.Context, obj client.Object) []reconcile.Request {
+	grant, ok := obj.(*km.ReferenceGrant)
+	if !ok {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, from := range grant.Spec.From {
+		if from.Group != km.GroupVersion.Group || from.Kind != "Cluster" || from.Namespace == grant.Namespace {
+			continue
+		}
+		var clusters km.ClusterList
+		if err := r.Client.List(ctx, &clusters, client.InNamespace(from.Namespace)); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to list clusters")
+			return nil
+		}
+		for _, kmc := range clusters.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: kmc.Name, Namespace: kmc.Namespace}})
+		}
+	}
+	return requests
+}
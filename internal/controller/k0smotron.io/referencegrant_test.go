@@ -0,0 +1,122 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k0smotronio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestIsReferenceGranted(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+
+	grant := &km.ReferenceGrant{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "allow-tokens",
+			Namespace: "clusters",
+		},
+		Spec: km.ReferenceGrantSpec{
+			From: []km.ReferenceGrantFrom{{Group: "k0smotron.io", Kind: "JoinTokenRequest", Namespace: "tenant"}},
+			To:   []km.ReferenceGrantTo{{Group: "k0smotron.io", Kind: "Cluster", Name: "my-cluster"}},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(grant).Build()
+
+	tests := []struct {
+		name        string
+		from        km.ReferenceGrantFrom
+		toNamespace string
+		to          km.ReferenceGrantTo
+		want        bool
+	}{
+		{
+			name:        "same namespace is always allowed",
+			from:        km.ReferenceGrantFrom{Group: "k0smotron.io", Kind: "JoinTokenRequest", Namespace: "other"},
+			toNamespace: "other",
+			to:          km.ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "any"},
+			want:        true,
+		},
+		{
+			name:        "granted namespace and name",
+			from:        km.ReferenceGrantFrom{Group: "k0smotron.io", Kind: "JoinTokenRequest", Namespace: "tenant"},
+			toNamespace: "clusters",
+			to:          km.ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "my-cluster"},
+			want:        true,
+		},
+		{
+			name:        "different cluster name",
+			from:        km.ReferenceGrantFrom{Group: "k0smotron.io", Kind: "JoinTokenRequest", Namespace: "tenant"},
+			toNamespace: "clusters",
+			to:          km.ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "another-cluster"},
+			want:        false,
+		},
+		{
+			name:        "namespace not in grant",
+			from:        km.ReferenceGrantFrom{Group: "k0smotron.io", Kind: "JoinTokenRequest", Namespace: "other"},
+			toNamespace: "clusters",
+			to:          km.ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "my-cluster"},
+			want:        false,
+		},
+		{
+			name:        "no grants in target namespace",
+			from:        km.ReferenceGrantFrom{Group: "k0smotron.io", Kind: "JoinTokenRequest", Namespace: "tenant"},
+			toNamespace: "default",
+			to:          km.ReferenceGrantTo{Group: "k0smotron.io", Kind: "Cluster", Name: "my-cluster"},
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isReferenceGranted(context.Background(), c, tt.from, tt.toNamespace, tt.to)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestClusterRequestsForReferenceGrant(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+
+	tenantA := &km.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "tenant-a"}}
+	tenantB := &km.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "tenant-b"}}
+	r := &ClusterReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(tenantA, tenantB).Build()}
+
+	grant := &km.ReferenceGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "k0smotron-clusters", Namespace: "argocd"},
+		Spec: km.ReferenceGrantSpec{
+			From: []km.ReferenceGrantFrom{
+				{Group: "k0smotron.io", Kind: "Cluster", Namespace: "tenant-a"},
+				{Group: "k0smotron.io", Kind: "JoinTokenRequest", Namespace: "tenant-b"},
+			},
+			To: []km.ReferenceGrantTo{{Group: "", Kind: "Secret"}},
+		},
+	}
+	requests := r.requestsForReferenceGrant(context.Background(), grant)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "tenant-a", requests[0].Namespace)
+	assert.Equal(t, "a", requests[0].Name)
+}
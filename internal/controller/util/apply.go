@@ -0,0 +1,90 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"maps"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ApplyConfigMap applies the config map with a server-side apply patch, unless the existing config map already has
+// the same data. Skipping the writes that change nothing keeps the resource version, so the watchers of the config
+// map, e.g. GitOps tools, are not woken up by every reconcile.
+func ApplyConfigMap(ctx context.Context, c client.Client, cm *v1.ConfigMap, opts ...client.PatchOption) error {
+	var existing v1.ConfigMap
+	err := c.Get(ctx, client.ObjectKeyFromObject(cm), &existing)
+	if err == nil && ConfigMapUpToDate(&existing, cm) {
+		return nil
+	}
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	return c.Patch(ctx, cm, client.Apply, opts...)
+}
+
+// ApplySecret applies the secret with a server-side apply patch, unless the existing secret already has the same
+// data. See ApplyConfigMap.
+func ApplySecret(ctx context.Context, c client.Client, secret *v1.Secret, opts ...client.PatchOption) error {
+	var existing v1.Secret
+	err := c.Get(ctx, client.ObjectKeyFromObject(secret), &existing)
+	if err == nil && SecretUpToDate(&existing, secret) {
+		return nil
+	}
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	return c.Patch(ctx, secret, client.Apply, opts...)
+}
+
+// ConfigMapUpToDate returns whether the existing config map has the data and the metadata of the desired one.
+func ConfigMapUpToDate(existing, desired *v1.ConfigMap) bool {
+	return maps.Equal(existing.Data, desired.Data) &&
+		maps.EqualFunc(existing.BinaryData, desired.BinaryData, bytes.Equal) &&
+		metadataUpToDate(&existing.ObjectMeta, &desired.ObjectMeta)
+}
+
+// SecretUpToDate returns whether the existing secret has the data, the type and the metadata of the desired one.
+// The string data of the desired secret is compared as the data it's written to.
+func SecretUpToDate(existing, desired *v1.Secret) bool {
+	data := maps.Clone(desired.Data)
+	if data == nil {
+		data = map[string][]byte{}
+	}
+	for k, v := range desired.StringData {
+		data[k] = []byte(v)
+	}
+	return maps.EqualFunc(existing.Data, data, bytes.Equal) &&
+		(desired.Type == "" || existing.Type == desired.Type) &&
+		metadataUpToDate(&existing.ObjectMeta, &desired.ObjectMeta)
+}
+
+// metadataUpToDate returns whether the existing object has the labels, the annotations and the owner references of
+// the desired one.
+func metadataUpToDate(existing, desired *metav1.ObjectMeta) bool {
+	for k, v := range desired.Labels {
+		if existing.Labels[k] != v {
+			return false
+		}
+	}
+	for k, v := range desired.Annotations {
+		if existing.Annotations[k] != v {
+			return false
+		}
+	}
+	for _, ref := range desired.OwnerReferences {
+		found := false
+		for _, existingRef := range existing.OwnerReferences {
+			if existingRef.UID == ref.UID && existingRef.Name == ref.Name && existingRef.Kind == ref.Kind {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
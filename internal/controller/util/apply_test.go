@@ -0,0 +1,81 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestConfigMapUpToDate(t *testing.T) {
+	existing := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test",
+			Labels:          map[string]string{"app": "k0smotron", "extra": "label"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Cluster", Name: "test", UID: "uid"}},
+		},
+		Data: map[string]string{"k0s.yaml": "config"},
+	}
+	desired := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test",
+			Labels:          map[string]string{"app": "k0smotron"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Cluster", Name: "test", UID: "uid"}},
+		},
+		Data: map[string]string{"k0s.yaml": "config"},
+	}
+	assert.True(t, ConfigMapUpToDate(existing, desired))
+
+	desired.Data["k0s.yaml"] = "changed"
+	assert.False(t, ConfigMapUpToDate(existing, desired))
+
+	desired.Data["k0s.yaml"] = "config"
+	desired.Annotations = map[string]string{"new": "annotation"}
+	assert.False(t, ConfigMapUpToDate(existing, desired))
+
+	desired.Annotations = nil
+	desired.OwnerReferences[0].UID = "other"
+	assert.False(t, ConfigMapUpToDate(existing, desired))
+}
+
+func TestSecretUpToDate(t *testing.T) {
+	existing := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Data:       map[string][]byte{"value": []byte("kubeconfig")},
+		Type:       "cluster.x-k8s.io/secret",
+	}
+	desired := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		StringData: map[string]string{"value": "kubeconfig"},
+		Type:       "cluster.x-k8s.io/secret",
+	}
+	assert.True(t, SecretUpToDate(existing, desired))
+
+	desired.StringData["value"] = "rotated"
+	assert.False(t, SecretUpToDate(existing, desired))
+
+	desired.StringData["value"] = "kubeconfig"
+	desired.Type = v1.SecretTypeOpaque
+	assert.False(t, SecretUpToDate(existing, desired))
+}
+
+func TestApplySecretSkipsNoop(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Data:       map[string][]byte{"value": []byte("kubeconfig")},
+	}
+	c := fake.NewClientBuilder().WithObjects(secret.DeepCopy()).Build()
+
+	var before v1.Secret
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(secret), &before))
+	require.NoError(t, ApplySecret(context.Background(), c, secret))
+
+	var after v1.Secret
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(secret), &after))
+	assert.Equal(t, before.ResourceVersion, after.ResourceVersion)
+}
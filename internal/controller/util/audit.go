@@ -0,0 +1,124 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reasons of the Events recorded for the audited commands
+const (
+	// CommandExecutedReason is recorded when a command run by the operator has succeeded
+	CommandExecutedReason = "CommandExecuted"
+	// CommandFailedReason is recorded when a command run by the operator has failed
+	CommandFailedReason = "CommandFailed"
+)
+
+// maxAuditSummaryLength is the maximum length of the command and output summaries in the Events.
+const maxAuditSummaryLength = 256
+
+// AuditEntry is a command the operator has run in a control plane pod or on a machine.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Object is the kind, namespace and name of the object whose reconcile ran the command.
+	Object string `json:"object"`
+	// Target is the pod or the machine the command ran on.
+	Target  string `json:"target"`
+	Command string `json:"command"`
+	// ExitCode is the exit code of the command, or -1 if the command could not be run.
+	ExitCode int `json:"exitCode"`
+	// Output is the summary of the output of the command. It is not recorded for the commands printing credentials.
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+var (
+	auditSinkMu sync.Mutex
+	auditSink   io.Writer
+)
+
+// SetAuditSink sets the writer the audited commands are written to as JSON lines, in addition to the Events.
+func SetAuditSink(w io.Writer) {
+	auditSinkMu.Lock()
+	defer auditSinkMu.Unlock()
+	auditSink = w
+}
+
+// AuditCommand records the command run for the object as an Event on it and in the audit sink, if set. The output
+// is summarized to its last line, callers pass an empty output for the commands printing credentials.
+func AuditCommand(recorder record.EventRecorder, obj client.Object, target, command, output string, err error) {
+	entry := AuditEntry{
+		Time:     time.Now().UTC(),
+		Object:   fmt.Sprintf("%s %s/%s", objectKind(obj), obj.GetNamespace(), obj.GetName()),
+		Target:   target,
+		Command:  command,
+		ExitCode: exitCode(err),
+		Output:   summarizeOutput(output),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		RecordEvent(recorder, obj, corev1.EventTypeWarning, CommandFailedReason, "Command %q failed on %s with exit code %d: %s",
+			truncate(command), target, entry.ExitCode, truncate(entry.Error))
+	} else {
+		RecordEvent(recorder, obj, corev1.EventTypeNormal, CommandExecutedReason, "Command %q succeeded on %s", truncate(command), target)
+	}
+
+	writeAuditEntry(entry)
+}
+
+func writeAuditEntry(entry AuditEntry) {
+	auditSinkMu.Lock()
+	defer auditSinkMu.Unlock()
+	if auditSink == nil {
+		return
+	}
+
+	b, err := json.Marshal(entry)
+	if err == nil {
+		_, err = auditSink.Write(append(b, '\n'))
+	}
+	if err != nil {
+		ctrl.Log.WithName("audit").Error(err, "Failed to write the audit entry", "command", entry.Command)
+	}
+}
+
+// exitCode returns the exit code of the failed command, or -1 if the error does not carry one.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr interface{ ExitStatus() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	return -1
+}
+
+func objectKind(obj client.Object) string {
+	if kind := obj.GetObjectKind().GroupVersionKind().Kind; kind != "" {
+		return kind
+	}
+	return reflect.Indirect(reflect.ValueOf(obj)).Type().Name()
+}
+
+func summarizeOutput(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return truncate(strings.TrimSpace(lines[len(lines)-1]))
+}
+
+func truncate(s string) string {
+	if len(s) <= maxAuditSummaryLength {
+		return s
+	}
+	return s[:maxAuditSummaryLength] + "..."
+}
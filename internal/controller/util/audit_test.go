@@ -0,0 +1,57 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+func TestAuditCommand(t *testing.T) {
+	var sink bytes.Buffer
+	SetAuditSink(&sink)
+	t.Cleanup(func() { SetAuditSink(nil) })
+
+	recorder := record.NewFakeRecorder(2)
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+
+	AuditCommand(recorder, pod, "10.0.0.1", "k0s etcd leave", "ok\nleft the cluster\n", nil)
+	exitErr := utilexec.CodeExitError{Err: errors.New("command terminated with exit code 1"), Code: 1}
+	AuditCommand(recorder, pod, "10.0.0.1", "k0s reset", "", fmt.Errorf("failed to exec command: %w", exitErr))
+
+	assert.Equal(t, `Normal CommandExecuted Command "k0s etcd leave" succeeded on 10.0.0.1`, <-recorder.Events)
+	assert.Equal(t, `Warning CommandFailed Command "k0s reset" failed on 10.0.0.1 with exit code 1: failed to exec command: command terminated with exit code 1`, <-recorder.Events)
+
+	decoder := json.NewDecoder(&sink)
+	var entry AuditEntry
+	require.NoError(t, decoder.Decode(&entry))
+	assert.Equal(t, "Pod default/test", entry.Object)
+	assert.Equal(t, "k0s etcd leave", entry.Command)
+	assert.Equal(t, 0, entry.ExitCode)
+	assert.Equal(t, "left the cluster", entry.Output)
+	assert.Empty(t, entry.Error)
+
+	entry = AuditEntry{}
+	require.NoError(t, decoder.Decode(&entry))
+	assert.Equal(t, "k0s reset", entry.Command)
+	assert.Equal(t, 1, entry.ExitCode)
+	assert.Empty(t, entry.Output)
+	assert.Equal(t, "failed to exec command: command terminated with exit code 1", entry.Error)
+}
+
+func TestAuditCommandWithoutExitCode(t *testing.T) {
+	recorder := record.NewFakeRecorder(1)
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+
+	AuditCommand(recorder, pod, "test", "k0s token create", "", errors.New("connection refused"))
+
+	assert.Equal(t, `Warning CommandFailed Command "k0s token create" failed on test with exit code -1: connection refused`, <-recorder.Events)
+}
@@ -0,0 +1,34 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ErrInvalidSpec marks the errors caused by the spec of the reconciled object. Retrying the reconcile doesn't fix
+// them, only a change of the object does.
+var ErrInvalidSpec = errors.New("invalid spec")
+
+// InvalidSpec marks err as caused by the spec of the reconciled object.
+func InvalidSpec(err error) error {
+	return fmt.Errorf("%w: %w", ErrInvalidSpec, err)
+}
+
+// IsPermanent returns whether retrying the reconcile can't fix the error: the invalid spec of the reconciled object,
+// or the objects generated from it being rejected by the API server.
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrInvalidSpec) || apierrors.IsInvalid(err) || apierrors.IsBadRequest(err)
+}
+
+// ReconcileError returns the error to return from a reconcile. The transient errors are retried by the controller
+// with an exponential backoff, while the permanent errors are returned as terminal errors, so the object is not
+// reconciled again until it changes.
+func ReconcileError(err error) error {
+	if err == nil || !IsPermanent(err) {
+		return err
+	}
+	return reconcile.TerminalError(err)
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileError(t *testing.T) {
+	assert.NoError(t, ReconcileError(nil))
+
+	transient := apierrors.NewServiceUnavailable("etcd is down")
+	assert.False(t, IsPermanent(transient))
+	assert.Equal(t, transient, ReconcileError(transient))
+
+	for _, err := range []error{
+		InvalidSpec(errors.New("label name must match")),
+		fmt.Errorf("failed to apply: %w", apierrors.NewInvalid(schema.GroupKind{Kind: "ConfigMap"}, "test", field.ErrorList{})),
+		apierrors.NewBadRequest("bad request"),
+	} {
+		assert.True(t, IsPermanent(err), err)
+		got := ReconcileError(err)
+		assert.ErrorIs(t, got, reconcile.TerminalError(nil))
+		assert.ErrorIs(t, got, err)
+	}
+}
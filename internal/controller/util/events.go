@@ -0,0 +1,35 @@
+package util
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the Events the controllers record on the objects they reconcile
+const (
+	// TokenCreatedReason is recorded when a join token has been created for a JoinTokenRequest
+	TokenCreatedReason = "TokenCreated"
+	// TokenInvalidatedReason is recorded when the join token of a JoinTokenRequest has been invalidated in the cluster
+	TokenInvalidatedReason = "TokenInvalidated"
+	// UpgradeStartedReason is recorded when a control plane starts upgrading to a new version
+	UpgradeStartedReason = "UpgradeStarted"
+	// MachineRemediatedReason is recorded when an unhealthy control plane machine has been removed for remediation
+	MachineRemediatedReason = "MachineRemediated"
+	// ProvisioningFailedReason is recorded when provisioning a machine has failed for good
+	ProvisioningFailedReason = "ProvisioningFailed"
+	// ReconcileFailedReason is recorded when reconciling a hosted control plane has failed
+	ReconcileFailedReason = "ReconcileFailed"
+	// NotificationFailedReason is recorded when posting a notification about a cluster to a webhook has failed
+	NotificationFailedReason = "NotificationFailed"
+	// ReferenceNotPermittedReason is recorded when a cross-namespace reference is not permitted by any ReferenceGrant
+	ReferenceNotPermittedReason = "ReferenceNotPermitted"
+)
+
+// RecordEvent records an Event on the object. It is a no-op if the recorder is nil, so controllers
+// built without a recorder, e.g. in unit tests, do not need one.
+func RecordEvent(recorder record.EventRecorder, obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if recorder == nil {
+		return
+	}
+	recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// KubeClientCache holds the clients to the API of the child clusters, so the connections are reused across the
+// reconciles instead of being opened for every reconcile. A client is replaced when the kubeconfig of its cluster
+// changes and dropped by Invalidate, e.g. when the API of the cluster doesn't respond.
+// A nil cache creates a new client on every Get.
+type KubeClientCache struct {
+	mu      sync.Mutex
+	clients map[client.ObjectKey]cachedKubeClient
+}
+
+type cachedKubeClient struct {
+	kubeconfigHash [sha256.Size]byte
+	clientSet      *kubernetes.Clientset
+}
+
+// NewKubeClientCache returns an empty KubeClientCache.
+func NewKubeClientCache() *KubeClientCache {
+	return &KubeClientCache{clients: map[client.ObjectKey]cachedKubeClient{}}
+}
+
+// Get returns the client of the cluster. The client is created by newClient from the kubeconfig unless there is a
+// cached client created from the same kubeconfig.
+func (c *KubeClientCache) Get(cluster client.ObjectKey, kubeconfig []byte, newClient func(kubeconfig []byte) (*kubernetes.Clientset, error)) (*kubernetes.Clientset, error) {
+	if c == nil {
+		return newClient(kubeconfig)
+	}
+
+	hash := sha256.Sum256(kubeconfig)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.clients[cluster]; ok && cached.kubeconfigHash == hash {
+		return cached.clientSet, nil
+	}
+
+	clientSet, err := newClient(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[cluster] = cachedKubeClient{kubeconfigHash: hash, clientSet: clientSet}
+	return clientSet, nil
+}
+
+// Invalidate drops the cached client of the cluster, so the next Get creates a new one.
+func (c *KubeClientCache) Invalidate(cluster client.ObjectKey) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.clients, cluster)
+}
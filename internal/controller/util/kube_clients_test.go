@@ -0,0 +1,45 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestKubeClientCache(t *testing.T) {
+	created := 0
+	newClient := func(kubeconfig []byte) (*kubernetes.Clientset, error) {
+		created++
+		return kubernetes.NewForConfig(&rest.Config{Host: string(kubeconfig)})
+	}
+	cluster := client.ObjectKey{Namespace: "default", Name: "test"}
+	cache := NewKubeClientCache()
+
+	first, err := cache.Get(cluster, []byte("https://test:6443"), newClient)
+	require.NoError(t, err)
+	second, err := cache.Get(cluster, []byte("https://test:6443"), newClient)
+	require.NoError(t, err)
+	require.Same(t, first, second)
+	require.Equal(t, 1, created)
+
+	// A rotated kubeconfig replaces the client
+	rotated, err := cache.Get(cluster, []byte("https://rotated:6443"), newClient)
+	require.NoError(t, err)
+	require.NotSame(t, first, rotated)
+	require.Equal(t, 2, created)
+
+	cache.Invalidate(cluster)
+	_, err = cache.Get(cluster, []byte("https://rotated:6443"), newClient)
+	require.NoError(t, err)
+	require.Equal(t, 3, created)
+
+	// Without a cache, a client is created every time
+	var noCache *KubeClientCache
+	_, err = noCache.Get(cluster, []byte("https://test:6443"), newClient)
+	require.NoError(t, err)
+	noCache.Invalidate(cluster)
+	require.Equal(t, 4, created)
+}
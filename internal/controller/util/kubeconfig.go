@@ -0,0 +1,38 @@
+package util
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/cluster-api/util/kubeconfig"
+	"sigs.k8s.io/cluster-api/util/secret"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// APITunnelKubeconfigName returns the name of the secret with the kubeconfig of the cluster pointed to the API tunnel
+// of its RemoteCluster. Unlike the <cluster>-kubeconfig secret, it's only used by the controllers, as the tunnel is
+// only reachable from within the management cluster.
+func APITunnelKubeconfigName(clusterName string) string {
+	return secret.Name(clusterName+"-api-tunnel", secret.Kubeconfig)
+}
+
+// KubeconfigFromSecret returns the kubeconfig the controllers reach the API of the cluster with: the kubeconfig
+// pointed to the API tunnel of the cluster if there is one, the <cluster>-kubeconfig secret otherwise.
+func KubeconfigFromSecret(ctx context.Context, c client.Reader, cluster client.ObjectKey) ([]byte, error) {
+	tunneled := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: APITunnelKubeconfigName(cluster.Name)}, tunneled)
+	if apierrors.IsNotFound(err) {
+		return kubeconfig.FromSecret(ctx, c, cluster)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := tunneled.Data[secret.KubeconfigDataName]
+	if !ok {
+		return nil, fmt.Errorf("missing key %q in the secret %s", secret.KubeconfigDataName, tunneled.Name)
+	}
+	return data, nil
+}
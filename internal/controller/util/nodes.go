@@ -3,15 +3,37 @@ package util
 import (
 	v1 "k8s.io/api/core/v1"
 	"math/rand"
+	"sort"
 )
 
 // FindNodeAddress returns a random node address preferring external address if one is found
 func FindNodeAddress(nodes *v1.NodeList) string {
-	extAddr, intAddr := "", ""
-
 	// Get random node from list
 	node := nodes.Items[rand.Intn(len(nodes.Items))]
 
+	return nodeAddress(node)
+}
+
+// NodeAddresses returns the addresses of all the nodes, preferring the external address of each node, sorted and
+// without duplicates.
+func NodeAddresses(nodes *v1.NodeList) []string {
+	seen := map[string]bool{}
+	var addresses []string
+	for _, node := range nodes.Items {
+		addr := nodeAddress(node)
+		if addr == "" || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+func nodeAddress(node v1.Node) string {
+	extAddr, intAddr := "", ""
+
 	for _, addr := range node.Status.Addresses {
 		if addr.Type == v1.NodeExternalIP {
 			extAddr = addr.Address
@@ -110,3 +110,19 @@ func TestFindNodeAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeAddresses(t *testing.T) {
+	node := func(addresses ...v1.NodeAddress) v1.Node {
+		return v1.Node{Status: v1.NodeStatus{Addresses: addresses}}
+	}
+	nodes := &v1.NodeList{
+		Items: []v1.Node{
+			node(v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.2"}),
+			node(v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"}, v1.NodeAddress{Type: v1.NodeExternalIP, Address: "1.1.1.1"}),
+			node(v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.2"}),
+			node(v1.NodeAddress{Type: v1.NodeHostName, Address: "worker"}),
+		},
+	}
+
+	assert.Equal(t, []string{"1.1.1.1", "10.0.0.2"}, NodeAddresses(nodes))
+}
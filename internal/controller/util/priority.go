@@ -0,0 +1,87 @@
+package util
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// KeyLock serializes the reconciles of an object by the controllers sharing the lock.
+type KeyLock struct {
+	mu   sync.Mutex
+	held map[types.NamespacedName]chan struct{}
+}
+
+// NewKeyLock returns a new KeyLock.
+func NewKeyLock() *KeyLock {
+	return &KeyLock{held: map[types.NamespacedName]chan struct{}{}}
+}
+
+// lock waits until the object is not reconciled by another controller and returns the function unlocking it.
+func (l *KeyLock) lock(ctx context.Context, key types.NamespacedName) (func(), error) {
+	for {
+		l.mu.Lock()
+		done, ok := l.held[key]
+		if !ok {
+			done = make(chan struct{})
+			l.held[key] = done
+			l.mu.Unlock()
+			return func() {
+				l.mu.Lock()
+				delete(l.held, key)
+				l.mu.Unlock()
+				close(done)
+			}, nil
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Exclusive wraps the reconciler so that an object is reconciled by one of the controllers sharing the lock at a time.
+func Exclusive(lock *KeyLock, r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		unlock, err := lock.lock(ctx, req.NamespacedName)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		defer unlock()
+		return r.Reconcile(ctx, req)
+	})
+}
+
+// ProvisioningLane wraps the reconciler of a provisioning controller. The new objects are reconciled by the
+// provisioning controller with its own queue and workers next to the main controller of the objects, so they don't
+// wait behind the periodic reconciles of thousands of existing objects in the queue of the main controller. Both
+// controllers run the same reconciler, serialized per object with Exclusive.
+// Once the object is provisioned, its requeues are dropped, as the main controller is notified by the change of its
+// status and takes over its periodic reconciles.
+func ProvisioningLane(r reconcile.Reconciler, provisioned func(ctx context.Context, key types.NamespacedName) bool) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		res, err := r.Reconcile(ctx, req)
+		if err == nil && provisioned(ctx, req.NamespacedName) {
+			return reconcile.Result{}, nil
+		}
+		return res, err
+	})
+}
+
+// CreatedPredicate returns the predicate of the create events of the objects matching the function, e.g. the objects
+// never reconciled. The main controller of the objects ignores these events with predicate.Not.
+func CreatedPredicate(created func(e event.CreateEvent) bool) predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc:  created,
+		UpdateFunc:  func(event.UpdateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestExclusive(t *testing.T) {
+	lock := NewKeyLock()
+	var running, maxRunning int32
+	r := reconcile.Func(func(context.Context, reconcile.Request) (reconcile.Result, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return reconcile.Result{}, nil
+	})
+	mainLane, provisioningLane := Exclusive(lock, r), Exclusive(lock, r)
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "test"}}
+	done := make(chan error, 2)
+	go func() { _, err := mainLane.Reconcile(context.Background(), req); done <- err }()
+	go func() { _, err := provisioningLane.Reconcile(context.Background(), req); done <- err }()
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+	assert.Equal(t, int32(1), maxRunning)
+
+	// A reconcile waiting for the lock is canceled with its context
+	unlock, err := lock.lock(context.Background(), req.NamespacedName)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = mainLane.Reconcile(ctx, req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	unlock()
+}
+
+func TestProvisioningLane(t *testing.T) {
+	provisioned := false
+	r := ProvisioningLane(reconcile.Func(func(context.Context, reconcile.Request) (reconcile.Result, error) {
+		return reconcile.Result{RequeueAfter: time.Minute}, nil
+	}), func(context.Context, types.NamespacedName) bool { return provisioned })
+
+	res, err := r.Reconcile(context.Background(), reconcile.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, res.RequeueAfter)
+
+	provisioned = true
+	res, err = r.Reconcile(context.Background(), reconcile.Request{})
+	require.NoError(t, err)
+	assert.True(t, res.IsZero())
+}
@@ -11,30 +11,46 @@ import (
 
 // FindStatefulSetPod returns a first running pod from a StatefulSet
 func FindStatefulSetPod(ctx context.Context, clientSet *kubernetes.Clientset, statefulSet string, namespace string) (*v1.Pod, error) {
-	dep, err := clientSet.AppsV1().StatefulSets(namespace).Get(ctx, statefulSet, metav1.GetOptions{})
+	pods, err := FindStatefulSetPods(ctx, clientSet, statefulSet, namespace)
 	if err != nil {
 		return nil, err
 	}
-	selector := metav1.FormatLabelSelector(dep.Spec.Selector)
-	pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: selector,
-	})
+	if len(pods) < 1 {
+		return nil, fmt.Errorf("did not find running pods for statefulSet %s", statefulSet)
+	}
+	return &pods[0], nil
+}
+
+// FindStatefulSetPods returns the running pods of a StatefulSet
+func FindStatefulSetPods(ctx context.Context, clientSet *kubernetes.Clientset, statefulSet string, namespace string) ([]v1.Pod, error) {
+	pods, err := ListStatefulSetPods(ctx, clientSet, statefulSet, namespace)
 	if err != nil {
 		return nil, err
 	}
-	if len(pods.Items) < 1 {
+	if len(pods) < 1 {
 		return nil, fmt.Errorf("did not find matching pods for statefulSet %s", statefulSet)
 	}
-	// Find a running pod
-	var runningPod *v1.Pod
-	for _, p := range pods.Items {
+	var runningPods []v1.Pod
+	for _, p := range pods {
 		if p.Status.Phase == v1.PodRunning {
-			runningPod = &p
-			break
+			runningPods = append(runningPods, p)
 		}
 	}
-	if runningPod == nil {
-		return nil, fmt.Errorf("did not find running pods for statefulSet %s", statefulSet)
+	return runningPods, nil
+}
+
+// ListStatefulSetPods returns all the pods of a StatefulSet, whatever their phase
+func ListStatefulSetPods(ctx context.Context, clientSet *kubernetes.Clientset, statefulSet string, namespace string) ([]v1.Pod, error) {
+	dep, err := clientSet.AppsV1().StatefulSets(namespace).Get(ctx, statefulSet, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	selector := metav1.FormatLabelSelector(dep.Spec.Selector)
+	pods, err := clientSet.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
 	}
-	return runningPod, nil
+	return pods.Items, nil
 }
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// UpdateStatus writes the status of obj. If obj has been changed since it was read, e.g. by a concurrent reconcile,
+// the latest version of obj is read, setStatus sets the status on it again and the update is retried, so the status
+// is not lost on a conflict.
+func UpdateStatus[T client.Object](ctx context.Context, c client.Client, obj T, setStatus func(T)) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		err := c.Status().Update(ctx, obj)
+		if apierrors.IsConflict(err) {
+			if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+				return err
+			}
+			setStatus(obj)
+		}
+		return err
+	})
+}
+
+// PatchStatus writes the changes of the status of obj since original with a merge patch of the status subresource.
+// The fields reset to their omitted zero values, e.g. a false "ready", are patched as well. The patch doesn't carry the
+// resource version, so it doesn't fail when obj has been changed since it was read.
+func PatchStatus(ctx context.Context, c client.Client, obj, original client.Object) error {
+	from, ok := original.DeepCopyObject().(client.Object)
+	if !ok {
+		return fmt.Errorf("unexpected type %T", original)
+	}
+	from.SetResourceVersion(obj.GetResourceVersion())
+	return c.Status().Patch(ctx, obj, client.MergeFrom(from))
+}
@@ -0,0 +1,84 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func newStatusTestClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&km.JoinTokenRequest{}, &km.Cluster{}).
+		Build()
+}
+
+func TestUpdateStatus(t *testing.T) {
+	ctx := context.Background()
+	c := newStatusTestClient(t, &km.JoinTokenRequest{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}})
+
+	var stale, latest km.JoinTokenRequest
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "test", Namespace: "default"}, &stale))
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "test", Namespace: "default"}, &latest))
+	latest.Labels = map[string]string{"changed": "true"}
+	require.NoError(t, c.Update(ctx, &latest))
+
+	// The update of the stale object conflicts, so the status is set on the latest version
+	stale.Status.ReconciliationStatus = "Reconciliation successful"
+	status := stale.Status
+	require.NoError(t, UpdateStatus(ctx, c, &stale, func(jtr *km.JoinTokenRequest) { jtr.Status = status }))
+
+	var got km.JoinTokenRequest
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "test", Namespace: "default"}, &got))
+	require.Equal(t, "Reconciliation successful", got.Status.ReconciliationStatus)
+	require.Equal(t, "true", got.Labels["changed"])
+}
+
+func TestPatchStatus(t *testing.T) {
+	ctx := context.Background()
+	c := newStatusTestClient(t, &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Status:     km.ClusterStatus{Ready: true, ReconciliationStatus: "Reconciling"},
+	})
+
+	var stale, latest km.Cluster
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "test", Namespace: "default"}, &stale))
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "test", Namespace: "default"}, &latest))
+	latest.Labels = map[string]string{"changed": "true"}
+	require.NoError(t, c.Update(ctx, &latest))
+
+	// The stale object is patched without a conflict, including the fields reset to their omitted zero values
+	original := stale.DeepCopy()
+	stale.Status.Ready = false
+	stale.Status.ReconciliationStatus = "Reconciliation successful"
+	require.NoError(t, PatchStatus(ctx, c, &stale, original))
+
+	var got km.Cluster
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "test", Namespace: "default"}, &got))
+	require.Equal(t, "Reconciliation successful", got.Status.ReconciliationStatus)
+	require.False(t, got.Status.Ready)
+	require.Equal(t, "true", got.Labels["changed"])
+
+	// The fields not changed since the original are left as they are
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "test", Namespace: "default"}, &stale))
+	original = stale.DeepCopy()
+	latest = stale
+	latest.Status.Ready = true
+	require.NoError(t, c.Status().Update(ctx, &latest))
+	stale.Status.ReconciliationStatus = "Reconciling"
+	require.NoError(t, PatchStatus(ctx, c, &stale, original))
+
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "test", Namespace: "default"}, &got))
+	require.Equal(t, "Reconciling", got.Status.ReconciliationStatus)
+	require.True(t, got.Status.Ready)
+}
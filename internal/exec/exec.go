@@ -21,15 +21,32 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/k0sproject/k0smotron/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
 )
 
-// PodExecCmdOutput exec command on specific pod and wait the command's output.
-func PodExecCmdOutput(ctx context.Context, client kubernetes.Interface, config *restclient.Config, podName, namespace string, command string) (string, error) {
+// PodExecCmdOutput exec command on specific pod of the cluster and wait the command's output.
+func PodExecCmdOutput(ctx context.Context, client kubernetes.Interface, config *restclient.Config, podName string, cluster types.NamespacedName, command string) (string, error) {
+	return PodContainerExecCmdOutput(ctx, client, config, podName, cluster, "controller", command)
+}
+
+// PodContainerExecCmdOutput exec command in the container of a specific pod of the cluster and wait the command's
+// output. The pod is in the namespace of the cluster.
+func PodContainerExecCmdOutput(ctx context.Context, client kubernetes.Interface, config *restclient.Config, podName string, cluster types.NamespacedName, container string, command string) (_ string, err error) {
+	namespace := cluster.Namespace
+	ctx, span := tracing.Start(ctx, "PodExec",
+		attribute.String("k8s.namespace.name", namespace),
+		attribute.String("k8s.pod.name", podName),
+		attribute.String("k8s.container.name", container),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	cmd := []string{
 		"/bin/sh",
 		"-c",
@@ -42,13 +59,23 @@ func PodExecCmdOutput(ctx context.Context, client kubernetes.Interface, config *
 		Stdout:    true,
 		Stderr:    true,
 		TTY:       false,
-		Container: "controller",
+		Container: container,
 	}
 	req.VersionedParams(option, scheme.ParameterCodec)
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	transport, upgrader, err := spdyRoundTripperFor(cluster, config)
 	if err != nil {
 		return "", err
 	}
+	exec, err := remotecommand.NewSPDYExecutorForTransports(transport, upgrader, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+
+	release, err := currentLimiter.acquire(ctx, cluster)
+	if err != nil {
+		return "", fmt.Errorf("failed waiting to exec command: %w", err)
+	}
+	defer release()
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -0,0 +1,175 @@
+/*
+Copyright 2022 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exec
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Limits bound the exec streams opened to the pods, so a burst of reconciles doesn't open hundreds of exec streams
+// through the API server at the same time.
+type Limits struct {
+	// MaxConcurrent is the maximum number of exec streams open at the same time. Unlimited if 0.
+	MaxConcurrent int
+	// ClusterQPS is the number of exec streams opened per second to the pods of a cluster, i.e. of a control plane.
+	// Unlimited if 0.
+	ClusterQPS float32
+	// ClusterBurst is the number of exec streams opened to the pods of a cluster at once before ClusterQPS applies.
+	ClusterBurst int
+}
+
+type limiter struct {
+	limits Limits
+	slots  chan struct{}
+
+	mu       sync.Mutex
+	clusters map[types.NamespacedName]flowcontrol.RateLimiter
+}
+
+type cachedTLSConfig struct {
+	config    *restclient.Config
+	tlsConfig *tls.Config
+}
+
+var (
+	currentLimiter = newLimiter(Limits{})
+
+	tlsConfigsMu sync.Mutex
+	tlsConfigs   = map[types.NamespacedName]cachedTLSConfig{}
+)
+
+// SetLimits sets the limits of the exec streams opened by PodExecCmdOutput and PodContainerExecCmdOutput.
+func SetLimits(limits Limits) {
+	currentLimiter = newLimiter(limits)
+}
+
+// Forget drops the rate limiter and the TLS config of the exec streams to the pods of the cluster. It's called once
+// the cluster is deleted, so they are not kept for the deleted clusters.
+func Forget(cluster types.NamespacedName) {
+	currentLimiter.forget(cluster)
+
+	tlsConfigsMu.Lock()
+	defer tlsConfigsMu.Unlock()
+	delete(tlsConfigs, cluster)
+}
+
+func newLimiter(limits Limits) *limiter {
+	l := &limiter{limits: limits, clusters: map[types.NamespacedName]flowcontrol.RateLimiter{}}
+	if limits.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	return l
+}
+
+// acquire waits until an exec stream can be opened to a pod of the cluster and returns the function releasing it.
+func (l *limiter) acquire(ctx context.Context, cluster types.NamespacedName) (func(), error) {
+	if rl := l.clusterLimiter(cluster); rl != nil {
+		if err := rl.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *limiter) clusterLimiter(cluster types.NamespacedName) flowcontrol.RateLimiter {
+	if l.limits.ClusterQPS <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rl, ok := l.clusters[cluster]
+	if !ok {
+		burst := l.limits.ClusterBurst
+		if burst < 1 {
+			burst = 1
+		}
+		rl = flowcontrol.NewTokenBucketRateLimiter(l.limits.ClusterQPS, burst)
+		l.clusters[cluster] = rl
+	}
+	return rl
+}
+
+func (l *limiter) forget(cluster types.NamespacedName) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.clusters, cluster)
+}
+
+// tlsConfigFor returns the TLS config of the exec streams to the pods of the cluster. The config is built once per
+// cluster and REST config and resumes the TLS sessions, so the exec streams don't load the certificates and do a full
+// TLS handshake each.
+func tlsConfigFor(cluster types.NamespacedName, config *restclient.Config) (*tls.Config, error) {
+	tlsConfigsMu.Lock()
+	defer tlsConfigsMu.Unlock()
+	if cached, ok := tlsConfigs[cluster]; ok && cached.config == config {
+		return cached.tlsConfig, nil
+	}
+
+	tlsConfig, err := restclient.TLSConfigFor(config)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	tlsConfigs[cluster] = cachedTLSConfig{config: config, tlsConfig: tlsConfig}
+	return tlsConfig, nil
+}
+
+// spdyRoundTripperFor returns the round trippers of an exec stream to a pod of the cluster, like
+// spdy.RoundTripperFor. Every exec stream opens its own connection, which is upgraded and can't be reused, only the
+// TLS sessions are resumed.
+func spdyRoundTripperFor(cluster types.NamespacedName, config *restclient.Config) (http.RoundTripper, *spdy.SpdyRoundTripper, error) {
+	tlsConfig, err := tlsConfigFor(cluster, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if config.Proxy != nil {
+		proxy = config.Proxy
+	}
+	upgradeRoundTripper := spdy.NewRoundTripperWithConfig(spdy.RoundTripperConfig{
+		TLS:        tlsConfig,
+		Proxier:    proxy,
+		PingPeriod: 5 * time.Second,
+	})
+	wrapper, err := restclient.HTTPWrappersForConfig(config, upgradeRoundTripper)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wrapper, upgradeRoundTripper, nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2022 k0s authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exec
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	restclient "k8s.io/client-go/rest"
+)
+
+var (
+	testCluster  = types.NamespacedName{Namespace: "default", Name: "test"}
+	otherCluster = types.NamespacedName{Namespace: "default", Name: "other"}
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(Limits{MaxConcurrent: 1})
+
+	release, err := l.acquire(context.Background(), testCluster)
+	require.NoError(t, err)
+
+	// The second stream waits for the first one to be released
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx, otherCluster)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	release, err = l.acquire(context.Background(), otherCluster)
+	require.NoError(t, err)
+	release()
+}
+
+func TestLimiterPerCluster(t *testing.T) {
+	l := newLimiter(Limits{ClusterQPS: 0.001, ClusterBurst: 1})
+
+	release, err := l.acquire(context.Background(), testCluster)
+	require.NoError(t, err)
+	release()
+
+	// The burst of the cluster is used up, the pods of the other clusters are not limited
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx, testCluster)
+	assert.Error(t, err)
+
+	release, err = l.acquire(context.Background(), otherCluster)
+	require.NoError(t, err)
+	release()
+}
+
+func TestForget(t *testing.T) {
+	currentLimiter = newLimiter(Limits{ClusterQPS: 0.001, ClusterBurst: 1})
+	t.Cleanup(func() { currentLimiter = newLimiter(Limits{}) })
+	config := &restclient.Config{Host: "https://localhost:6443", TLSClientConfig: restclient.TLSClientConfig{Insecure: true}}
+
+	release, err := currentLimiter.acquire(context.Background(), testCluster)
+	require.NoError(t, err)
+	release()
+	tlsConfig, err := tlsConfigFor(testCluster, config)
+	require.NoError(t, err)
+	cached, err := tlsConfigFor(testCluster, config)
+	require.NoError(t, err)
+	assert.Same(t, tlsConfig, cached)
+
+	// The rate limiter and the TLS config of the deleted cluster are dropped
+	Forget(testCluster)
+	assert.NotContains(t, currentLimiter.clusters, testCluster)
+	assert.NotContains(t, tlsConfigs, testCluster)
+
+	release, err = currentLimiter.acquire(context.Background(), testCluster)
+	require.NoError(t, err)
+	release()
+}
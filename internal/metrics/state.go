@@ -0,0 +1,189 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics exposes the state of the k0smotron resources as Prometheus metrics.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// collectTimeout is the timeout of reading the resources at a scrape.
+const collectTimeout = 10 * time.Second
+
+var log = ctrl.Log.WithName("state-metrics")
+
+// resourceDescs are the descriptions of the metrics of a kind of control plane resource.
+type resourceDescs struct {
+	info                *prometheus.Desc
+	specReplicas        *prometheus.Desc
+	statusReplicas      *prometheus.Desc
+	statusReadyReplicas *prometheus.Desc
+	statusReady         *prometheus.Desc
+}
+
+func newResourceDescs(resource, kind string) resourceDescs {
+	labels := []string{"namespace", "name"}
+	return resourceDescs{
+		info: prometheus.NewDesc("k0smotron_"+resource+"_info",
+			"Information about the "+kind+".", append(labels, "version"), nil),
+		specReplicas: prometheus.NewDesc("k0smotron_"+resource+"_spec_replicas",
+			"The desired number of control plane replicas of the "+kind+".", labels, nil),
+		statusReplicas: prometheus.NewDesc("k0smotron_"+resource+"_status_replicas",
+			"The number of control plane replicas of the "+kind+".", labels, nil),
+		statusReadyReplicas: prometheus.NewDesc("k0smotron_"+resource+"_status_ready_replicas",
+			"The number of ready control plane replicas of the "+kind+".", labels, nil),
+		statusReady: prometheus.NewDesc("k0smotron_"+resource+"_status_ready",
+			"Whether the "+kind+" is ready.", labels, nil),
+	}
+}
+
+func (d resourceDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.info
+	ch <- d.specReplicas
+	ch <- d.statusReplicas
+	ch <- d.statusReadyReplicas
+	ch <- d.statusReady
+}
+
+func (d resourceDescs) collect(ch chan<- prometheus.Metric, namespace, name, version string, specReplicas, replicas, readyReplicas int32, ready bool) {
+	ch <- prometheus.MustNewConstMetric(d.info, prometheus.GaugeValue, 1, namespace, name, version)
+	ch <- prometheus.MustNewConstMetric(d.specReplicas, prometheus.GaugeValue, float64(specReplicas), namespace, name)
+	ch <- prometheus.MustNewConstMetric(d.statusReplicas, prometheus.GaugeValue, float64(replicas), namespace, name)
+	ch <- prometheus.MustNewConstMetric(d.statusReadyReplicas, prometheus.GaugeValue, float64(readyReplicas), namespace, name)
+	ch <- prometheus.MustNewConstMetric(d.statusReady, prometheus.GaugeValue, boolValue(ready), namespace, name)
+}
+
+var (
+	clusterDescs               = newResourceDescs("cluster", "k0smotron Cluster")
+	k0sControlPlaneDescs       = newResourceDescs("k0scontrolplane", "K0sControlPlane")
+	k0smotronControlPlaneDescs = newResourceDescs("k0smotroncontrolplane", "K0smotronControlPlane")
+
+	clusterLastBackupDesc = prometheus.NewDesc("k0smotron_cluster_last_backup_timestamp",
+		"The completion time of the latest completed Velero backup of the k0smotron Cluster, in seconds since the epoch. "+
+			"Not reported for the clusters without a completed backup.", []string{"namespace", "name"}, nil)
+	remoteMachineReadyDesc = prometheus.NewDesc("k0smotron_remotemachine_status_ready",
+		"Whether the RemoteMachine is ready.", []string{"namespace", "name"}, nil)
+	joinTokenExpiryDesc = prometheus.NewDesc("k0smotron_jointokenrequest_token_expiry_seconds",
+		"The number of seconds until the token of the JoinTokenRequest expires, negative once it has expired. "+
+			"Not reported for the tokens that don't expire.", []string{"namespace", "name", "cluster"}, nil)
+)
+
+// StateCollector exposes the spec and the status of the k0smotron resources as gauges, in the style of
+// kube-state-metrics. The resources are read from the given reader at each scrape, so it should be backed by
+// the cache of the manager.
+type StateCollector struct {
+	client client.Reader
+	now    func() time.Time
+}
+
+// NewStateCollector returns a StateCollector reading the resources with the given reader.
+func NewStateCollector(c client.Reader) *StateCollector {
+	return &StateCollector{client: c, now: time.Now}
+}
+
+// Describe implements prometheus.Collector.
+func (c *StateCollector) Describe(ch chan<- *prometheus.Desc) {
+	clusterDescs.describe(ch)
+	k0sControlPlaneDescs.describe(ch)
+	k0smotronControlPlaneDescs.describe(ch)
+	ch <- clusterLastBackupDesc
+	ch <- remoteMachineReadyDesc
+	ch <- joinTokenExpiryDesc
+}
+
+// Collect implements prometheus.Collector. The kinds of resources that can't be read are skipped.
+func (c *StateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	var clusters km.ClusterList
+	if c.list(ctx, &clusters) {
+		for _, kmc := range clusters.Items {
+			var ready int32
+			for _, r := range kmc.Status.ReplicaStatus {
+				if r.Component == "controller" && r.Ready {
+					ready++
+				}
+			}
+			clusterDescs.collect(ch, kmc.Namespace, kmc.Name, kmc.Spec.GetVersion(), kmc.Spec.Replicas, kmc.Status.Replicas, ready,
+				meta.IsStatusConditionTrue(kmc.Status.Conditions, km.ReadyCondition))
+			if kmc.Status.Backup != nil && kmc.Status.Backup.LastSuccessTime != nil {
+				ch <- prometheus.MustNewConstMetric(clusterLastBackupDesc, prometheus.GaugeValue, float64(kmc.Status.Backup.LastSuccessTime.Unix()),
+					kmc.Namespace, kmc.Name)
+			}
+		}
+	}
+
+	var kcps cpv1beta1.K0sControlPlaneList
+	if c.list(ctx, &kcps) {
+		for _, kcp := range kcps.Items {
+			k0sControlPlaneDescs.collect(ch, kcp.Namespace, kcp.Name, kcp.Spec.Version, kcp.Spec.Replicas, kcp.Status.Replicas, kcp.Status.ReadyReplicas,
+				kcp.Status.Ready)
+		}
+	}
+
+	var kmcps cpv1beta1.K0smotronControlPlaneList
+	if c.list(ctx, &kmcps) {
+		for _, kcp := range kmcps.Items {
+			k0smotronControlPlaneDescs.collect(ch, kcp.Namespace, kcp.Name, kcp.Spec.GetVersion(), kcp.Spec.Replicas, kcp.Status.Replicas, kcp.Status.ReadyReplicas,
+				kcp.Status.Ready)
+		}
+	}
+
+	var machines infrastructure.RemoteMachineList
+	if c.list(ctx, &machines) {
+		for _, m := range machines.Items {
+			ch <- prometheus.MustNewConstMetric(remoteMachineReadyDesc, prometheus.GaugeValue, boolValue(m.Status.Ready), m.Namespace, m.Name)
+		}
+	}
+
+	var jtrs km.JoinTokenRequestList
+	if c.list(ctx, &jtrs) {
+		for _, jtr := range jtrs.Items {
+			if jtr.Status.ExpiresAt == nil {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(joinTokenExpiryDesc, prometheus.GaugeValue, jtr.Status.ExpiresAt.Sub(c.now()).Seconds(),
+				jtr.Namespace, jtr.Name, jtr.Spec.ClusterRef.Name)
+		}
+	}
+}
+
+func (c *StateCollector) list(ctx context.Context, list client.ObjectList) bool {
+	if err := c.client.List(ctx, list); err != nil {
+		log.Error(err, "Failed to list resources for the state metrics")
+		return false
+	}
+	return true
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
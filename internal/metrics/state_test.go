@@ -0,0 +1,137 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	infrastructure "github.com/k0sproject/k0smotron/api/infrastructure/v1beta1"
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestStateCollector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+	require.NoError(t, cpv1beta1.AddToScheme(scheme))
+	require.NoError(t, infrastructure.AddToScheme(scheme))
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := metav1.NewTime(now.Add(time.Hour))
+	lastBackup := metav1.NewTime(now.Add(-time.Hour))
+	objs := []runtime.Object{
+		&km.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "hosted", Namespace: "default"},
+			Spec:       km.ClusterSpec{Replicas: 3, Version: "v1.29.1"},
+			Status: km.ClusterStatus{
+				Replicas: 3,
+				ReplicaStatus: []km.ReplicaStatus{
+					{Name: "kmc-hosted-0", Component: "controller", Ready: true},
+					{Name: "kmc-hosted-1", Component: "controller", Ready: false},
+					{Name: "kmc-hosted-2", Component: "controller", Ready: true},
+					{Name: "kmc-hosted-etcd-0", Component: "etcd", Ready: true},
+				},
+				Backup:     &km.BackupStatus{LastSuccessTime: &lastBackup},
+				Conditions: []metav1.Condition{{Type: km.ReadyCondition, Status: metav1.ConditionFalse}},
+			},
+		},
+		&cpv1beta1.K0sControlPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default"},
+			Spec:       cpv1beta1.K0sControlPlaneSpec{Replicas: 3, Version: "v1.29.1+k0s.0"},
+			Status:     cpv1beta1.K0sControlPlaneStatus{Replicas: 3, ReadyReplicas: 3, Ready: true},
+		},
+		&infrastructure.RemoteMachine{
+			ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "default"},
+			Status:     infrastructure.RemoteMachineStatus{Ready: true},
+		},
+		&km.JoinTokenRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "expiring", Namespace: "default"},
+			Spec:       km.JoinTokenRequestSpec{ClusterRef: km.ClusterRef{Name: "hosted"}},
+			Status:     km.JoinTokenRequestStatus{TokenID: "abcdef", ExpiresAt: &expiresAt},
+		},
+		&km.JoinTokenRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "forever", Namespace: "default"},
+			Spec:       km.JoinTokenRequestSpec{ClusterRef: km.ClusterRef{Name: "hosted"}},
+			Status:     km.JoinTokenRequestStatus{TokenID: "ghijkl"},
+		},
+	}
+	c := NewStateCollector(fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build())
+	c.now = func() time.Time { return now }
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(c))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	values := map[string][]float64{}
+	var clusterInfo *dto.Metric
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			values[f.GetName()] = append(values[f.GetName()], m.GetGauge().GetValue())
+		}
+		if f.GetName() == "k0smotron_cluster_info" {
+			clusterInfo = f.GetMetric()[0]
+		}
+	}
+
+	assert.Equal(t, []float64{3}, values["k0smotron_cluster_spec_replicas"])
+	assert.Equal(t, []float64{3}, values["k0smotron_cluster_status_replicas"])
+	assert.Equal(t, []float64{2}, values["k0smotron_cluster_status_ready_replicas"])
+	assert.Equal(t, []float64{0}, values["k0smotron_cluster_status_ready"])
+	assert.Equal(t, []float64{float64(lastBackup.Unix())}, values["k0smotron_cluster_last_backup_timestamp"])
+	assert.Equal(t, []float64{3}, values["k0smotron_k0scontrolplane_status_ready_replicas"])
+	assert.Equal(t, []float64{1}, values["k0smotron_k0scontrolplane_status_ready"])
+	assert.Equal(t, []float64{1}, values["k0smotron_remotemachine_status_ready"])
+	assert.Equal(t, []float64{3600}, values["k0smotron_jointokenrequest_token_expiry_seconds"])
+	assert.NotContains(t, values, "k0smotron_k0smotroncontrolplane_info")
+
+	require.NotNil(t, clusterInfo)
+	labels := map[string]string{}
+	for _, l := range clusterInfo.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	assert.Equal(t, map[string]string{"namespace": "default", "name": "hosted", "version": "v1.29.1-k0s.0"}, labels)
+}
+
+func TestStateCollectorWithoutBackup(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, km.AddToScheme(scheme))
+
+	lastFailure := metav1.NewTime(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	kmc := &km.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "hosted", Namespace: "default"},
+		Status:     km.ClusterStatus{Backup: &km.BackupStatus{LastFailureTime: &lastFailure}},
+	}
+	c := NewStateCollector(fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(kmc).Build())
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(c))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		assert.NotEqual(t, "k0smotron_cluster_last_backup_timestamp", f.GetName())
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// EventType is the type of the events about the clusters sent to the webhooks.
+type EventType string
+
+// The events sent about the clusters.
+const (
+	ClusterCreated      EventType = "ClusterCreated"
+	ClusterReady        EventType = "ClusterReady"
+	ClusterUpgraded     EventType = "ClusterUpgraded"
+	ClusterDeleted      EventType = "ClusterDeleted"
+	BackupCompleted     EventType = "BackupCompleted"
+	BackupFailed        EventType = "BackupFailed"
+	CertificateExpiring EventType = "CertificateExpiring"
+)
+
+const (
+	// FormatGeneric posts the event as a JSON object.
+	FormatGeneric = "generic"
+	// FormatSlack posts a Slack-compatible message with the text of the event, also accepted by Mattermost, Rocket.Chat
+	// and the Microsoft Teams workflows.
+	FormatSlack = "slack"
+
+	defaultTimeout = 10 * time.Second
+)
+
+// Event is a notification about a cluster.
+type Event struct {
+	Type      EventType `json:"type"`
+	Namespace string    `json:"namespace"`
+	Cluster   string    `json:"cluster"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
+
+// Text returns the event as a single line of text.
+func (e Event) Text() string {
+	return fmt.Sprintf("[%s] k0smotron cluster %s/%s: %s", e.Type, e.Namespace, e.Cluster, e.Message)
+}
+
+// Webhook is an endpoint the events are posted to.
+type Webhook struct {
+	URL string
+	// Format is the format of the payload, FormatGeneric if empty.
+	Format string
+	// Events are the types of the events posted to the webhook. All the events are posted if empty.
+	Events []EventType
+}
+
+// Accepts returns whether the events of the given type are posted to the webhook.
+func (w Webhook) Accepts(t EventType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifier posts the events to the global webhooks, receiving the events of all the clusters, and to the webhooks
+// of the cluster the event is about. A nil Notifier posts the events to the webhooks of the clusters only.
+type Notifier struct {
+	Webhooks []Webhook
+
+	HTTPClient *http.Client
+}
+
+// New creates a notifier posting the events to the given global webhooks.
+func New(webhooks ...Webhook) *Notifier {
+	return &Notifier{
+		Webhooks:   webhooks,
+		HTTPClient: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// ParseEventTypes parses the comma-separated event types, e.g. the value of a flag.
+func ParseEventTypes(s string) ([]EventType, error) {
+	var types []EventType
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		switch et := EventType(t); et {
+		case ClusterCreated, ClusterReady, ClusterUpgraded, ClusterDeleted, BackupCompleted, BackupFailed, CertificateExpiring:
+			types = append(types, et)
+		default:
+			return nil, fmt.Errorf("unknown notification event type %q", t)
+		}
+	}
+	return types, nil
+}
+
+// Enabled returns whether global webhooks are configured.
+func (n *Notifier) Enabled() bool {
+	return n != nil && len(n.Webhooks) > 0
+}
+
+// Notify posts the event to the global webhooks and to the given webhooks of the cluster accepting its type.
+// All the webhooks are tried, the returned error joins the failed ones.
+func (n *Notifier) Notify(ctx context.Context, e Event, webhooks ...Webhook) error {
+	httpClient := http.DefaultClient
+	if n != nil {
+		webhooks = append(append([]Webhook{}, n.Webhooks...), webhooks...)
+		if n.HTTPClient != nil {
+			httpClient = n.HTTPClient
+		}
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	var errs []error
+	for _, w := range webhooks {
+		if !w.Accepts(e.Type) {
+			continue
+		}
+		if err := post(ctx, httpClient, w, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func post(ctx context.Context, httpClient *http.Client, w Webhook, e Event) error {
+	var payload interface{} = e
+	switch w.Format {
+	case "", FormatGeneric:
+	case FormatSlack:
+		payload = map[string]string{"text": e.Text()}
+	default:
+		return fmt.Errorf("unsupported notification format %q", w.Format)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid notification webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// The URL may hold credentials, e.g. the token of a Slack webhook, so only the host is reported
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("notification to %s failed: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("notification to %s failed with status %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifier_Notify(t *testing.T) {
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies = append(bodies, body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	n := New(Webhook{URL: srv.URL + "/global"})
+	e := Event{
+		Type:      ClusterReady,
+		Namespace: "default",
+		Cluster:   "test",
+		Message:   "Cluster is ready",
+		Time:      time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	err := n.Notify(context.Background(), e,
+		Webhook{URL: srv.URL + "/slack", Format: FormatSlack},
+		Webhook{URL: srv.URL + "/filtered", Events: []EventType{ClusterDeleted}})
+	require.NoError(t, err)
+	require.Len(t, bodies, 2)
+	assert.Equal(t, map[string]interface{}{
+		"type":      "ClusterReady",
+		"namespace": "default",
+		"cluster":   "test",
+		"message":   "Cluster is ready",
+		"time":      "2023-01-01T00:00:00Z",
+	}, bodies[0])
+	assert.Equal(t, map[string]interface{}{"text": "[ClusterReady] k0smotron cluster default/test: Cluster is ready"}, bodies[1])
+
+	// The failed webhooks don't prevent the others from being notified
+	bodies = nil
+	err = n.Notify(context.Background(), e, Webhook{URL: srv.URL + "/fail"}, Webhook{URL: srv.URL + "/other"})
+	assert.ErrorContains(t, err, "failed with status 500")
+	assert.Len(t, bodies, 3)
+
+	var nilNotifier *Notifier
+	assert.False(t, nilNotifier.Enabled())
+	bodies = nil
+	require.NoError(t, nilNotifier.Notify(context.Background(), e, Webhook{URL: srv.URL + "/cluster"}))
+	assert.Len(t, bodies, 1)
+}
+
+func TestParseEventTypes(t *testing.T) {
+	types, err := ParseEventTypes("ClusterReady, BackupFailed,")
+	require.NoError(t, err)
+	assert.Equal(t, []EventType{ClusterReady, BackupFailed}, types)
+
+	types, err = ParseEventTypes("")
+	require.NoError(t, err)
+	assert.Empty(t, types)
+
+	_, err = ParseEventTypes("ClusterReady,Unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package profiling serves the profiles, the runtime metrics and the runtime tuning of the manager to the operators.
+package profiling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// shutdownTimeout is the time the in-flight requests are given to finish when the manager stops.
+const shutdownTimeout = 5 * time.Second
+
+var log = ctrl.Log.WithName("profiling")
+
+// Server serves the endpoints on a loopback address. It runs on every replica of the manager, whether it's the
+// leader or not.
+type Server struct {
+	addr    string
+	handler http.Handler
+}
+
+// NewServer returns the server of the endpoints on addr. The address must be a loopback one, as the endpoints aren't
+// authenticated and expose the internals of the manager, e.g. the command line.
+func NewServer(addr string) (*Server, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pprof bind address %q: %w", addr, err)
+	}
+	if !isLoopback(host) {
+		return nil, fmt.Errorf("pprof bind address %q is not a loopback address", addr)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsAll)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/runtime/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/debug/runtime/tuning", tuning)
+
+	return &Server{addr: addr, handler: mux}, nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable.
+func (s *Server) Start(ctx context.Context) error {
+	srv := &http.Server{Addr: s.addr, Handler: s.handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Error(err, "failed to shut down the profiling server")
+		}
+	}()
+
+	log.Info("serving the profiling endpoints", "address", s.addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// runtimeSettings are the runtime settings read and changed by the tuning endpoint.
+type runtimeSettings struct {
+	GCPercent            int
+	MemoryLimit          int64
+	MutexProfileFraction int
+	BlockProfileRate     int
+}
+
+// blockProfileRate is the last rate set by the tuning endpoint, as the runtime doesn't report it.
+var blockProfileRate atomic.Int64
+
+func currentSettings() runtimeSettings {
+	// The negative values leave the settings as they are, except for SetGCPercent which disables the GC, so the
+	// previous value is put back right away
+	gcPercent := debug.SetGCPercent(-1)
+	debug.SetGCPercent(gcPercent)
+	return runtimeSettings{
+		GCPercent:            gcPercent,
+		MemoryLimit:          debug.SetMemoryLimit(-1),
+		MutexProfileFraction: runtime.SetMutexProfileFraction(-1),
+		BlockProfileRate:     int(blockProfileRate.Load()),
+	}
+}
+
+// tuning reports the runtime settings and changes the ones given as query parameters on POST, e.g.
+// POST /debug/runtime/tuning?gc-percent=50&memory-limit=1073741824.
+func tuning(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		if err := applySettings(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := currentSettings()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "gc-percent %d\nmemory-limit %d\nmutex-profile-fraction %d\nblock-profile-rate %d\n",
+		s.GCPercent, s.MemoryLimit, s.MutexProfileFraction, s.BlockProfileRate)
+}
+
+func applySettings(req *http.Request) error {
+	query := req.URL.Query()
+	values := map[string]int64{}
+	for _, name := range []string{"gc-percent", "memory-limit", "mutex-profile-fraction", "block-profile-rate"} {
+		if !query.Has(name) {
+			continue
+		}
+		v, err := strconv.ParseInt(query.Get(name), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if v < 0 && name != "gc-percent" {
+			return fmt.Errorf("invalid %s: must not be negative", name)
+		}
+		values[name] = v
+	}
+
+	if v, ok := values["gc-percent"]; ok {
+		debug.SetGCPercent(int(v))
+	}
+	if v, ok := values["memory-limit"]; ok {
+		debug.SetMemoryLimit(v)
+	}
+	if v, ok := values["mutex-profile-fraction"]; ok {
+		runtime.SetMutexProfileFraction(int(v))
+	}
+	if v, ok := values["block-profile-rate"]; ok {
+		runtime.SetBlockProfileRate(int(v))
+		blockProfileRate.Store(v)
+	}
+	log.Info("changed the runtime settings", "settings", values)
+	return nil
+}
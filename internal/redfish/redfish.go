@@ -0,0 +1,173 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redfish
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PowerState is the power state of a computer system.
+type PowerState string
+
+const (
+	PowerStateOn  PowerState = "On"
+	PowerStateOff PowerState = "Off"
+)
+
+// ResetType is the type of the reset action of a computer system.
+type ResetType string
+
+const (
+	ResetOn           ResetType = "On"
+	ResetForceOff     ResetType = "ForceOff"
+	ResetForceRestart ResetType = "ForceRestart"
+)
+
+const requestTimeout = 30 * time.Second
+
+// Client manages the power of a computer system through the Redfish API of its BMC.
+type Client struct {
+	// Address is the address of the Redfish service, e.g. https://10.0.0.100
+	Address string
+	// SystemID is the ID of the computer system. If empty, the service must have exactly one system.
+	SystemID string
+	Username string
+	Password string
+
+	HTTPClient *http.Client
+}
+
+// NewClient creates a Redfish client. The BMCs usually have self-signed certificates, so the verification of the
+// certificate can be disabled.
+func NewClient(address, systemID, username, password string, insecureSkipVerify bool) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		//nolint:gosec // opted in by the user for the BMCs with self-signed certificates
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	return &Client{
+		Address:    address,
+		SystemID:   systemID,
+		Username:   username,
+		Password:   password,
+		HTTPClient: &http.Client{Transport: transport, Timeout: requestTimeout},
+	}
+}
+
+type odataRef struct {
+	ID string `json:"@odata.id"`
+}
+
+type collection struct {
+	Members []odataRef `json:"Members"`
+}
+
+type computerSystem struct {
+	PowerState PowerState `json:"PowerState"`
+	Actions    struct {
+		Reset struct {
+			Target string `json:"target"`
+		} `json:"#ComputerSystem.Reset"`
+	} `json:"Actions"`
+}
+
+// PowerState returns the power state of the computer system.
+func (c *Client) PowerState(ctx context.Context) (PowerState, error) {
+	system, _, err := c.system(ctx)
+	if err != nil {
+		return "", err
+	}
+	return system.PowerState, nil
+}
+
+// Reset runs the reset action of the computer system.
+func (c *Client) Reset(ctx context.Context, resetType ResetType) error {
+	system, path, err := c.system(ctx)
+	if err != nil {
+		return err
+	}
+
+	target := system.Actions.Reset.Target
+	if target == "" {
+		target = path + "/Actions/ComputerSystem.Reset"
+	}
+	body, err := json.Marshal(map[string]ResetType{"ResetType": resetType})
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, target, body, nil)
+}
+
+// system returns the computer system and its path.
+func (c *Client) system(ctx context.Context) (*computerSystem, string, error) {
+	path := "/redfish/v1/Systems/" + c.SystemID
+	if c.SystemID == "" {
+		systems := &collection{}
+		if err := c.do(ctx, http.MethodGet, "/redfish/v1/Systems", nil, systems); err != nil {
+			return nil, "", err
+		}
+		if len(systems.Members) != 1 {
+			return nil, "", fmt.Errorf("the Redfish service has %d systems, set the system ID", len(systems.Members))
+		}
+		path = systems.Members[0].ID
+	}
+
+	system := &computerSystem{}
+	if err := c.do(ctx, http.MethodGet, path, nil, system); err != nil {
+		return nil, "", err
+	}
+	return system, path, nil
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, body []byte, out interface{}) error {
+	url := strings.TrimSuffix(c.Address, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.Username, c.Password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("redfish request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("redfish request %s %s failed with status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode redfish response of %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
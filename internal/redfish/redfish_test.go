@@ -0,0 +1,81 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	var resets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /redfish/v1/Systems":
+			fmt.Fprint(w, `{"Members": [{"@odata.id": "/redfish/v1/Systems/1"}]}`)
+		case "GET /redfish/v1/Systems/1":
+			fmt.Fprint(w, `{"PowerState": "Off", "Actions": {"#ComputerSystem.Reset": {"target": "/redfish/v1/Systems/1/Actions/Reset"}}}`)
+		case "GET /redfish/v1/Systems/2":
+			fmt.Fprint(w, `{"PowerState": "On"}`)
+		case "POST /redfish/v1/Systems/1/Actions/Reset", "POST /redfish/v1/Systems/2/Actions/ComputerSystem.Reset":
+			body := map[string]string{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			resets = append(resets, r.URL.Path+" "+body["ResetType"])
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "", "admin", "secret", false)
+	state, err := c.PowerState(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, PowerStateOff, state)
+	require.NoError(t, c.Reset(context.Background(), ResetOn))
+
+	c = NewClient(srv.URL+"/", "2", "admin", "secret", false)
+	state, err = c.PowerState(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, PowerStateOn, state)
+	require.NoError(t, c.Reset(context.Background(), ResetForceOff))
+
+	assert.Equal(t, []string{
+		"/redfish/v1/Systems/1/Actions/Reset On",
+		"/redfish/v1/Systems/2/Actions/ComputerSystem.Reset ForceOff",
+	}, resets)
+
+	c = NewClient(srv.URL, "3", "admin", "secret", false)
+	_, err = c.PowerState(context.Background())
+	assert.ErrorContains(t, err, "failed with status 404")
+
+	c = NewClient(srv.URL, "1", "admin", "wrong", false)
+	_, err = c.PowerState(context.Background())
+	assert.ErrorContains(t, err, "failed with status 401")
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secretstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSSecretsManagerStore stores the credentials in AWS Secrets Manager.
+type AWSSecretsManagerStore struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint overrides the regional Secrets Manager endpoint.
+	Endpoint string
+
+	HTTPClient *http.Client
+	now        func() time.Time
+}
+
+// NewAWSSecretsManagerStoreFromEnv creates an AWS Secrets Manager store configured by AWS_REGION,
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optionally AWS_SESSION_TOKEN.
+func NewAWSSecretsManagerStoreFromEnv() (*AWSSecretsManagerStore, error) {
+	s := &AWSSecretsManagerStore{
+		Region:          os.Getenv("AWS_REGION"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		HTTPClient:      http.DefaultClient,
+	}
+	if s.AccessKeyID == "" || s.SecretAccessKey == "" {
+		return nil, fmt.Errorf("AWS credentials are not set, use AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+type awsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (s *AWSSecretsManagerStore) Put(ctx context.Context, key Key, data map[string]string) error {
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	err = s.call(ctx, "CreateSecret", map[string]interface{}{
+		"Name":         key.Path(),
+		"SecretString": string(value),
+	}, nil)
+	if isAWSError(err, "ResourceExistsException") {
+		err = s.call(ctx, "PutSecretValue", map[string]interface{}{
+			"SecretId":     key.Path(),
+			"SecretString": string(value),
+		}, nil)
+	}
+	return err
+}
+
+func (s *AWSSecretsManagerStore) Delete(ctx context.Context, key Key) error {
+	err := s.call(ctx, "DeleteSecret", map[string]interface{}{
+		"SecretId":                   key.Path(),
+		"ForceDeleteWithoutRecovery": true,
+	}, nil)
+	if isAWSError(err, "ResourceNotFoundException") {
+		return nil
+	}
+	return err
+}
+
+// Get returns the current version of the secret with the name or ARN given as the path. A secret that's not a JSON
+// object of strings is returned in the "value" key.
+func (s *AWSSecretsManagerStore) Get(ctx context.Context, path string) (map[string]string, error) {
+	var output struct {
+		SecretString string `json:"SecretString"`
+	}
+	err := s.call(ctx, "GetSecretValue", map[string]interface{}{"SecretId": path}, &output)
+	if isAWSError(err, "ResourceNotFoundException") {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]string{}
+	if err := json.Unmarshal([]byte(output.SecretString), &data); err != nil {
+		return map[string]string{"value": output.SecretString}, nil
+	}
+	return data, nil
+}
+
+func (s *AWSSecretsManagerStore) call(ctx context.Context, action string, input map[string]interface{}, out interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", s.Region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager."+action)
+
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	s.signV4(req, body, now(), "secretsmanager")
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("AWS Secrets Manager %s request failed: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		var awsErr awsError
+		if json.Unmarshal(msg, &awsErr) == nil && awsErr.Type != "" {
+			return &awsErr
+		}
+		return fmt.Errorf("AWS Secrets Manager %s failed with status %d: %s", action, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode AWS Secrets Manager %s response: %w", action, err)
+		}
+	}
+
+	return nil
+}
+
+func (e *awsError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+func isAWSError(err error, errType string) bool {
+	awsErr, ok := err.(*awsError)
+	if !ok {
+		return false
+	}
+	// The type may be prefixed by the namespace, e.g. com.amazonaws.secretsmanager#ResourceExistsException
+	return awsErr.Type == errType || strings.HasSuffix(awsErr.Type, "#"+errType)
+}
+
+// signV4 signs the request with AWS Signature Version 4.
+func (s *AWSSecretsManagerStore) signV4(req *http.Request, body []byte, now time.Time, service string) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uri,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.Region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
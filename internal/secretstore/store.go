@@ -0,0 +1,157 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secretstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// ProviderKubernetes stores the generated credentials in Kubernetes Secrets.
+	ProviderKubernetes = "Kubernetes"
+	// ProviderVault stores the generated credentials in a HashiCorp Vault KV v2 secrets engine.
+	ProviderVault = "Vault"
+	// ProviderAWSSecretsManager stores the generated credentials in AWS Secrets Manager.
+	ProviderAWSSecretsManager = "AWSSecretsManager"
+
+	pathPrefix = "k0smotron"
+)
+
+// ErrNotFound is returned when no data is stored at the path.
+var ErrNotFound = errors.New("secret not found")
+
+// Store is an external store for the credentials generated by k0smotron.
+type Store interface {
+	// Source reads back the stored data by the path of its key.
+	Source
+	// Put creates or updates the data stored under the given key.
+	Put(ctx context.Context, key Key, data map[string]string) error
+	// Delete removes the data stored under the given key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key Key) error
+}
+
+// Source is an external store the credentials provided by the user, e.g. the SSH keys of the machines, are read from.
+type Source interface {
+	// Get returns the data stored at the given path, or an error wrapping ErrNotFound if there is none.
+	Get(ctx context.Context, path string) (map[string]string, error)
+}
+
+// Key identifies the stored credentials by the namespace and the name the Kubernetes Secret would have.
+type Key struct {
+	Namespace string
+	Name      string
+}
+
+// Path returns the path of the key in the external store.
+func (k Key) Path() string {
+	return path.Join(pathPrefix, k.Namespace, k.Name)
+}
+
+// SourcePath returns the path in the external store of the credentials provided by the user for a resource in the
+// namespace. The path is resolved under the prefix of the namespace, so a resource can't read the credentials stored
+// for other namespaces.
+func SourcePath(namespace, p string) (string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		return "", fmt.Errorf("invalid secret path %q", p)
+	}
+	return Key{Namespace: namespace, Name: name}.Path(), nil
+}
+
+// Registry holds the configured stores and the provider used when a cluster does not select one.
+type Registry struct {
+	defaultProvider string
+	stores          map[string]Store
+}
+
+// NewRegistry creates a registry using the given provider by default.
+func NewRegistry(defaultProvider string) *Registry {
+	if defaultProvider == "" {
+		defaultProvider = ProviderKubernetes
+	}
+	return &Registry{defaultProvider: defaultProvider, stores: map[string]Store{}}
+}
+
+// NewRegistryFromEnv creates a registry with the stores configured in the environment:
+// Vault is configured if VAULT_ADDR is set, AWS Secrets Manager if AWS_REGION is set.
+func NewRegistryFromEnv(defaultProvider string) (*Registry, error) {
+	r := NewRegistry(defaultProvider)
+	if os.Getenv("VAULT_ADDR") != "" {
+		s, err := NewVaultStoreFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		r.Register(ProviderVault, s)
+	}
+	if os.Getenv("AWS_REGION") != "" {
+		s, err := NewAWSSecretsManagerStoreFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		r.Register(ProviderAWSSecretsManager, s)
+	}
+
+	if _, err := r.Get(""); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Register adds the store for the provider.
+func (r *Registry) Register(provider string, s Store) {
+	r.stores[provider] = s
+}
+
+// Get returns the store for the provider, or the default store if the provider is empty.
+// A nil store is returned for the Kubernetes provider, the credentials are then stored in Kubernetes Secrets.
+func (r *Registry) Get(provider string) (Store, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if provider == "" {
+		provider = r.defaultProvider
+	}
+	if provider == ProviderKubernetes {
+		return nil, nil
+	}
+	s, ok := r.stores[provider]
+	if !ok {
+		return nil, fmt.Errorf("secret store %s is not configured", provider)
+	}
+	return s, nil
+}
+
+// Source returns the store of the provider to read the credentials from.
+func (r *Registry) Source(provider string) (Source, error) {
+	if r == nil || provider == ProviderKubernetes {
+		return nil, fmt.Errorf("credentials can't be read from secret store %s", provider)
+	}
+	s, ok := r.stores[provider]
+	if !ok {
+		return nil, fmt.Errorf("secret store %s is not configured", provider)
+	}
+	source, ok := s.(Source)
+	if !ok {
+		return nil, fmt.Errorf("credentials can't be read from secret store %s", provider)
+	}
+	return source, nil
+}
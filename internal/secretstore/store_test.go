@@ -0,0 +1,283 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package secretstore
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Get(t *testing.T) {
+	vault := &VaultStore{}
+	r := NewRegistry(ProviderVault)
+	r.Register(ProviderVault, vault)
+
+	s, err := r.Get("")
+	require.NoError(t, err)
+	assert.Equal(t, vault, s)
+
+	s, err = r.Get(ProviderKubernetes)
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	_, err = r.Get(ProviderAWSSecretsManager)
+	assert.Error(t, err)
+
+	s, err = NewRegistry("").Get("")
+	require.NoError(t, err)
+	assert.Nil(t, s)
+}
+
+func TestSourcePath(t *testing.T) {
+	p, err := SourcePath("default", "machines/node-0")
+	require.NoError(t, err)
+	assert.Equal(t, "k0smotron/default/machines/node-0", p)
+
+	p, err = SourcePath("default", "/../other/machines/node-0")
+	require.NoError(t, err)
+	assert.Equal(t, "k0smotron/default/other/machines/node-0", p)
+
+	_, err = SourcePath("default", "..")
+	assert.Error(t, err)
+}
+
+func TestVaultStore(t *testing.T) {
+	var requests []string
+	var body map[string]map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "my-token", r.Header.Get("X-Vault-Token"))
+		if r.Method == http.MethodPost {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := &VaultStore{Address: srv.URL, Mount: "kv", Token: "my-token", HTTPClient: srv.Client()}
+	key := Key{Namespace: "default", Name: "my-token"}
+	require.NoError(t, s.Put(context.Background(), key, map[string]string{"token": "abc"}))
+	require.NoError(t, s.Delete(context.Background(), key))
+
+	assert.Equal(t, []string{
+		"POST /v1/kv/data/k0smotron/default/my-token",
+		"DELETE /v1/kv/metadata/k0smotron/default/my-token",
+	}, requests)
+	assert.Equal(t, map[string]string{"token": "abc"}, body["data"])
+}
+
+func TestAWSSecretsManagerStore(t *testing.T) {
+	var targets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		targets = append(targets, target)
+		assert.Contains(t, r.Header.Get("Authorization"), "Credential=AKID/20230101/eu-west-1/secretsmanager/aws4_request")
+
+		b, _ := io.ReadAll(r.Body)
+		var input map[string]interface{}
+		require.NoError(t, json.Unmarshal(b, &input))
+
+		switch target {
+		case "secretsmanager.CreateSecret":
+			assert.Equal(t, "k0smotron/default/kmc-admin-kubeconfig", input["Name"])
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"ResourceExistsException","message":"exists"}`))
+		case "secretsmanager.PutSecretValue":
+			assert.Equal(t, `{"value":"kubeconfig"}`, input["SecretString"])
+		case "secretsmanager.DeleteSecret":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := &AWSSecretsManagerStore{
+		Region:          "eu-west-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Endpoint:        srv.URL,
+		HTTPClient:      srv.Client(),
+		now:             func() time.Time { return time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC) },
+	}
+	key := Key{Namespace: "default", Name: "kmc-admin-kubeconfig"}
+	require.NoError(t, s.Put(context.Background(), key, map[string]string{"value": "kubeconfig"}))
+	require.NoError(t, s.Delete(context.Background(), key))
+
+	assert.Equal(t, []string{
+		"secretsmanager.CreateSecret",
+		"secretsmanager.PutSecretValue",
+		"secretsmanager.DeleteSecret",
+	}, targets)
+}
+
+func TestAWSSecretsManagerStore_signV4(t *testing.T) {
+	// Cases of the AWS Signature Version 4 test suite
+	const token = "AQoDYXdzEPT//////////wEXAMPLEtc764bNrC9SAPBSM22wDOk4x4HIZ8j4FZTwdQWLWsKWHGBuFqwAeMicRXmxfpSPfIeoIYRqTflfKD8YUuwthAx7mSEI/qkPpKPi/kMcGdQrmGdeehM4IC1NtBmUpp2wUE8phUZampKsburEDy0KPkyQDYwT7WZ0wq5VSXDvp75YU9HFvlRd8Tx6q6fE8YQcHNVXAkiY9q6d+xo0rKwT38xVqr7ZD0u0iPPkUL64lIZbqBAz+scqKmlzm8FDrypNC9Yjc8fPOLn9FX9KSYvKTr4rvx3iSIlTJabIQwj2ICCR/oLxBA=="
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		contentType   string
+		body          string
+		sessionToken  string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "get-vanilla-query-order-key-case",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:          "post-vanilla",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			contentType:   "application/x-www-form-urlencoded",
+			body:          "Param1=value1",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			name:          "post-sts-header-before",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			sessionToken:  token,
+			signedHeaders: "host;x-amz-date;x-amz-security-token",
+			signature:     "85d96828115b5dc0cfc3bd16ad9e210dd772bbebba041836c64533a82be05ead",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &AWSSecretsManagerStore{
+				Region:          "us-east-1",
+				AccessKeyID:     "AKIDEXAMPLE",
+				SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+				SessionToken:    tt.sessionToken,
+			}
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			s.signV4(req, []byte(tt.body), time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC), "service")
+
+			assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+			assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders="+tt.signedHeaders+", Signature="+tt.signature, req.Header.Get("Authorization"))
+		})
+	}
+}
+
+func TestRegistry_Source(t *testing.T) {
+	vault := &VaultStore{}
+	r := NewRegistry(ProviderKubernetes)
+	r.Register(ProviderVault, vault)
+
+	s, err := r.Source(ProviderVault)
+	require.NoError(t, err)
+	assert.Equal(t, vault, s)
+
+	_, err = r.Source(ProviderKubernetes)
+	assert.Error(t, err)
+
+	_, err = r.Source(ProviderAWSSecretsManager)
+	assert.Error(t, err)
+}
+
+func TestVaultStore_Get(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "my-token", r.Header.Get("X-Vault-Token"))
+		if r.URL.Path != "/v1/kv/data/machines/node-0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": {"data": {"value": "private-key"}, "metadata": {"version": 2}}}`))
+	}))
+	defer srv.Close()
+
+	s := &VaultStore{Address: srv.URL, Mount: "kv", Token: "my-token", HTTPClient: srv.Client()}
+	data, err := s.Get(context.Background(), "/machines/node-0")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"value": "private-key"}, data)
+
+	_, err = s.Get(context.Background(), "machines/node-1")
+	assert.ErrorContains(t, err, "failed with status 404")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestAWSSecretsManagerStore_Get(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+
+		var input map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+		switch input["SecretId"] {
+		case "machines/node-0":
+			_, _ = w.Write([]byte(`{"Name": "machines/node-0", "SecretString": "{\"password\":\"secret\"}"}`))
+		case "machines/node-1":
+			_, _ = w.Write([]byte(`{"Name": "machines/node-1", "SecretString": "private-key"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := &AWSSecretsManagerStore{
+		Region:          "eu-west-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Endpoint:        srv.URL,
+		HTTPClient:      srv.Client(),
+	}
+
+	data, err := s.Get(context.Background(), "machines/node-0")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"password": "secret"}, data)
+
+	data, err = s.Get(context.Background(), "machines/node-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"value": "private-key"}, data)
+
+	_, err = s.Get(context.Background(), "machines/node-2")
+	assert.ErrorContains(t, err, "ResourceNotFoundException")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
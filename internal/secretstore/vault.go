@@ -0,0 +1,130 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secretstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// VaultStore stores the credentials in a HashiCorp Vault KV v2 secrets engine.
+type VaultStore struct {
+	// Address is the address of the Vault server, e.g. https://vault.example.com:8200
+	Address string
+	// Mount is the mount path of the KV v2 secrets engine.
+	Mount string
+	// Token is the Vault token used to authenticate the requests.
+	Token string
+
+	HTTPClient *http.Client
+}
+
+// NewVaultStoreFromEnv creates a Vault store configured by VAULT_ADDR and VAULT_TOKEN or VAULT_TOKEN_FILE.
+// The KV v2 mount path is read from K0SMOTRON_VAULT_MOUNT and defaults to "secret".
+func NewVaultStoreFromEnv() (*VaultStore, error) {
+	token := os.Getenv("VAULT_TOKEN")
+	if tokenFile := os.Getenv("VAULT_TOKEN_FILE"); tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault token file: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token == "" {
+		return nil, fmt.Errorf("vault token is not set, use VAULT_TOKEN or VAULT_TOKEN_FILE")
+	}
+
+	mount := os.Getenv("K0SMOTRON_VAULT_MOUNT")
+	if mount == "" {
+		mount = "secret"
+	}
+
+	return &VaultStore{
+		Address:    os.Getenv("VAULT_ADDR"),
+		Mount:      mount,
+		Token:      token,
+		HTTPClient: http.DefaultClient,
+	}, nil
+}
+
+func (s *VaultStore) Put(ctx context.Context, key Key, data map[string]string) error {
+	body, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	return s.do(ctx, http.MethodPost, "data", key.Path(), body, nil)
+}
+
+func (s *VaultStore) Delete(ctx context.Context, key Key) error {
+	// Deleting the metadata removes all the versions of the secret
+	return s.do(ctx, http.MethodDelete, "metadata", key.Path(), nil, nil)
+}
+
+// Get returns the latest version of the secret at the path of the KV v2 secrets engine.
+func (s *VaultStore) Get(ctx context.Context, path string) (map[string]string, error) {
+	var secret struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := s.do(ctx, http.MethodGet, "data", strings.Trim(path, "/"), nil, &secret); err != nil {
+		return nil, err
+	}
+	return secret.Data.Data, nil
+}
+
+func (s *VaultStore) do(ctx context.Context, method string, endpoint string, path string, body []byte, out interface{}) error {
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", strings.TrimSuffix(s.Address, "/"), strings.Trim(s.Mount, "/"), endpoint, path)
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", s.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if method == http.MethodDelete && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: vault request to %s failed with status %d", ErrNotFound, path, resp.StatusCode)
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("vault request to %s failed with status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode vault response of %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
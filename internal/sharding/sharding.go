@@ -0,0 +1,124 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sharding splits the reconciliation of the k0smotron resources across several k0smotron managers. Every
+// manager reconciles only the resources in the namespaces of its shard.
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+// ShardLabel is the label of the namespaces assigning their resources to a named shard.
+const ShardLabel = "k0smotron.io/shard"
+
+// DefaultShard is the named shard of the namespaces without the ShardLabel.
+const DefaultShard = "default"
+
+// Shard selects the namespaces whose resources are reconciled by a k0smotron manager. The namespaces are assigned
+// either by the ShardLabel of the namespace or by the hash of the namespace name.
+type Shard struct {
+	// Name is the value of the ShardLabel of the namespaces of the shard.
+	Name string
+	// Count is the number of shards the namespace names are hashed into.
+	Count int
+	// Index is the index of the shard among the Count shards, starting from 0.
+	Index int
+}
+
+// Validate checks that the shard is selected either by name or by an index in range.
+func (s *Shard) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.Name != "" && s.Count > 1 {
+		return fmt.Errorf("a shard is selected either by name or by index")
+	}
+	if s.Count > 1 && (s.Index < 0 || s.Index >= s.Count) {
+		return fmt.Errorf("shard index %d out of range of %d shards", s.Index, s.Count)
+	}
+	return nil
+}
+
+// ID returns the identifier of the shard, e.g. to tell apart the leader election leases of the shards. It's empty if
+// the shard contains all the namespaces.
+func (s *Shard) ID() string {
+	switch {
+	case s == nil:
+		return ""
+	case s.Name != "":
+		return s.Name
+	case s.Count > 1:
+		return fmt.Sprintf("%d-of-%d", s.Index, s.Count)
+	}
+	return ""
+}
+
+// Contains returns whether the resources of the namespace belong to the shard. The namespace is read with c only if
+// the shard is selected by name. The cluster-scoped resources, whose namespace is empty, belong to a single shard:
+// the default shard, or the first one of the hashed shards.
+func (s *Shard) Contains(ctx context.Context, c client.Reader, namespace string) (bool, error) {
+	switch {
+	case s == nil:
+		return true, nil
+	case namespace == "":
+		return s.Name == DefaultShard || (s.Name == "" && s.Index == 0), nil
+	case s.Name != "":
+		var ns v1.Namespace
+		if err := c.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		name := ns.Labels[ShardLabel]
+		if name == "" {
+			name = DefaultShard
+		}
+		return name == s.Name, nil
+	case s.Count > 1:
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(namespace))
+		return int(h.Sum32()%uint32(s.Count)) == s.Index, nil
+	}
+	return true, nil
+}
+
+// Reconciler wraps the reconciler so that only the requests in the namespaces of the shard are reconciled. The other
+// requests are left to the managers of the other shards. The labels of the namespaces are read with c. A nil shard
+// reconciles all the requests.
+func (s *Shard) Reconciler(c client.Reader, r reconcile.Reconciler) reconcile.Reconciler {
+	if s == nil {
+		return r
+	}
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		ok, err := s.Contains(ctx, c, req.Namespace)
+		if err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to check the shard of namespace %s: %w", req.Namespace, err)
+		}
+		if !ok {
+			log.FromContext(ctx).V(1).Info("Skipping the request of another shard", "shard", s.ID())
+			return reconcile.Result{}, nil
+		}
+		return r.Reconcile(ctx, req)
+	})
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestShardContains(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithObjects(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "big-tenant", Labels: map[string]string{ShardLabel: "big"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "small-tenant"}},
+	).Build()
+
+	big := &Shard{Name: "big"}
+	ok, err := big.Contains(ctx, c, "big-tenant")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = big.Contains(ctx, c, "small-tenant")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// The namespaces without the label belong to the default shard
+	ok, err = (&Shard{Name: DefaultShard}).Contains(ctx, c, "small-tenant")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Every namespace belongs to exactly one of the hashed shards
+	for i := 0; i < 20; i++ {
+		namespace := fmt.Sprintf("tenant-%d", i)
+		found := 0
+		for index := 0; index < 3; index++ {
+			ok, err := (&Shard{Count: 3, Index: index}).Contains(ctx, c, namespace)
+			require.NoError(t, err)
+			if ok {
+				found++
+			}
+		}
+		assert.Equal(t, 1, found, namespace)
+	}
+
+	var all *Shard
+	ok, err = all.Contains(ctx, c, "small-tenant")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, all.ID())
+}
+
+func TestShardContainsClusterScoped(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().Build()
+
+	// The cluster-scoped resources belong to the default shard only
+	ok, err := (&Shard{Name: DefaultShard}).Contains(ctx, c, "")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = (&Shard{Name: "big"}).Contains(ctx, c, "")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// and to the first one of the hashed shards
+	for index := 0; index < 3; index++ {
+		ok, err := (&Shard{Count: 3, Index: index}).Contains(ctx, c, "")
+		require.NoError(t, err)
+		assert.Equal(t, index == 0, ok)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.Error(t, (&Shard{Name: "big", Count: 2}).Validate())
+	assert.Error(t, (&Shard{Count: 2, Index: 2}).Validate())
+	assert.NoError(t, (&Shard{Count: 2, Index: 1}).Validate())
+	assert.NoError(t, (*Shard)(nil).Validate())
+}
+
+func TestReconciler(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "big-tenant", Labels: map[string]string{ShardLabel: "big"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "small-tenant"}},
+	).Build()
+
+	var reconciled []string
+	inner := reconcile.Func(func(_ context.Context, req reconcile.Request) (reconcile.Result, error) {
+		reconciled = append(reconciled, req.Namespace)
+		return reconcile.Result{}, nil
+	})
+	requests := []string{"big-tenant", "small-tenant", ""}
+	for _, tc := range []struct {
+		shard      *Shard
+		reconciled []string
+	}{
+		{&Shard{Name: "big"}, []string{"big-tenant"}},
+		{&Shard{Name: DefaultShard}, []string{"small-tenant", ""}},
+		{nil, requests},
+	} {
+		reconciled = nil
+		r := tc.shard.Reconciler(c, inner)
+		for _, namespace := range requests {
+			_, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "test"}})
+			require.NoError(t, err)
+		}
+		assert.Equal(t, tc.reconciled, reconciled, tc.shard.ID())
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/cluster-api/controllers/remote"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/k0sproject/k0smotron/internal/controller/util"
+)
+
+const (
+	tracerName  = "github.com/k0sproject/k0smotron"
+	serviceName = "k0smotron"
+
+	// clusterClientTimeout is the timeout of the clients to the child clusters, the same as of remote.NewClusterClient.
+	clusterClientTimeout = 10 * time.Second
+)
+
+// ClusterNameKey is the attribute holding the name of the cluster the span is about.
+const ClusterNameKey = attribute.Key("k0smotron.cluster.name")
+
+type clusterNameContextKey struct{}
+
+// Setup exports the spans to the OTLP gRPC endpoint. If the endpoint is empty, the tracing is disabled and the spans
+// are not recorded. The exporter is configured further with the standard OTEL_EXPORTER_OTLP_* environment variables.
+// The returned function flushes the remaining spans and stops the exporter.
+func Setup(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+	resource, err := sdkresource.Merge(sdkresource.Default(), sdkresource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(resource))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// WithCluster returns a context carrying the cluster name, which is set as an attribute of the current span and of
+// the spans started from the context.
+func WithCluster(ctx context.Context, name string) context.Context {
+	trace.SpanFromContext(ctx).SetAttributes(ClusterNameKey.String(name))
+	return context.WithValue(ctx, clusterNameContextKey{}, name)
+}
+
+// ClusterFromContext returns the cluster name carried by the context, if any.
+func ClusterFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(clusterNameContextKey{}).(string)
+	return name
+}
+
+// Start starts a span with the given attributes and the cluster name carried by the context.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if cluster := ClusterFromContext(ctx); cluster != "" {
+		attrs = append(attrs, ClusterNameKey.String(cluster))
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Reconciler wraps the reconciler so that every reconcile runs in a span named after the controller.
+func Reconciler(controllerName string, r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		ctx, span := Start(ctx, controllerName+".Reconcile",
+			attribute.String("k8s.namespace.name", req.Namespace),
+			attribute.String("k0smotron.object.name", req.Name),
+		)
+		res, err := r.Reconcile(ctx, req)
+		End(span, err)
+		return res, err
+	})
+}
+
+// WrapRESTConfig makes the clients created from the config trace every request made to the API of the cluster.
+func WrapRESTConfig(config *rest.Config, clusterName string) *rest.Config {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(rt, otelhttp.WithSpanOptions(trace.WithAttributes(ClusterNameKey.String(clusterName))))
+	})
+	return config
+}
+
+// NewClusterClient returns a client to the API of the child cluster, like remote.NewClusterClient, with the requests
+// traced. The API is reached through the API tunnel of the cluster if there is one.
+func NewClusterClient(ctx context.Context, sourceName string, c client.Client, cluster client.ObjectKey) (client.Client, error) {
+	kubeconfig, err := util.KubeconfigFromSecret(ctx, c, cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve kubeconfig secret for Cluster %s/%s: %w", cluster.Namespace, cluster.Name, err)
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create REST configuration for Cluster %s/%s: %w", cluster.Namespace, cluster.Name, err)
+	}
+	restConfig.UserAgent = remote.DefaultClusterAPIUserAgent(sourceName)
+	restConfig.Timeout = clusterClientTimeout
+	ret, err := client.New(WrapRESTConfig(restConfig, cluster.Name), client.Options{Scheme: c.Scheme()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for Cluster %s/%s: %w", cluster.Namespace, cluster.Name, err)
+	}
+	return ret, nil
+}
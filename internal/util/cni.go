@@ -0,0 +1,120 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/imdario/mergo"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// SetCNI renders the CNI preset into the spec.network of the k0s config, and adds the Helm chart of the network
+// provider to the Helm extensions if k0s doesn't deploy it. apiHost and apiPort are the address the nodes reach the
+// API server at, used by the network providers replacing kube-proxy.
+func SetCNI(k0sConfig map[string]interface{}, cni *km.CNISpec, apiHost string, apiPort int) error {
+	if cni == nil {
+		return nil
+	}
+
+	switch cni.Preset {
+	case "calico":
+		calico := map[string]interface{}{"mode": "vxlan"}
+		if cni.Calico != nil {
+			if cni.Calico.Mode != "" {
+				calico["mode"] = cni.Calico.Mode
+			}
+			if cni.Calico.MTU > 0 {
+				calico["mtu"] = int64(cni.Calico.MTU)
+			}
+		}
+		if err := unstructured.SetNestedField(k0sConfig, "calico", "spec", "network", "provider"); err != nil {
+			return err
+		}
+		return unstructured.SetNestedField(k0sConfig, calico, "spec", "network", "calico")
+	case "cilium":
+		if err := unstructured.SetNestedField(k0sConfig, "custom", "spec", "network", "provider"); err != nil {
+			return err
+		}
+		return setCilium(k0sConfig, cni.Cilium, apiHost, apiPort)
+	case "none":
+		// The network provider is deployed by the user
+		return unstructured.SetNestedField(k0sConfig, "custom", "spec", "network", "provider")
+	default:
+		return fmt.Errorf("unsupported CNI preset %q", cni.Preset)
+	}
+}
+
+func setCilium(k0sConfig map[string]interface{}, cilium *km.CiliumCNISpec, apiHost string, apiPort int) error {
+	if cilium == nil {
+		cilium = &km.CiliumCNISpec{}
+	}
+
+	values := map[string]interface{}{
+		// k0s allocates the pod CIDRs of the nodes
+		"ipam":     map[string]interface{}{"mode": "kubernetes"},
+		"operator": map[string]interface{}{"replicas": 1},
+	}
+	if cilium.KubeProxyReplacement {
+		if apiHost == "" {
+			return fmt.Errorf("the kube-proxy replacement of cilium requires the external address of the cluster")
+		}
+		if err := unstructured.SetNestedField(k0sConfig, true, "spec", "network", "kubeProxy", "disabled"); err != nil {
+			return err
+		}
+		values["kubeProxyReplacement"] = true
+		values["k8sServiceHost"] = apiHost
+		values["k8sServicePort"] = apiPort
+	}
+	if cilium.Hubble {
+		values["hubble"] = map[string]interface{}{
+			"enabled": true,
+			"relay":   map[string]interface{}{"enabled": true},
+		}
+	}
+	if cilium.Values != nil && len(cilium.Values.Raw) > 0 {
+		overrides := map[string]interface{}{}
+		if err := json.Unmarshal(cilium.Values.Raw, &overrides); err != nil {
+			return fmt.Errorf("failed to parse values of cilium: %w", err)
+		}
+		if err := mergo.Merge(&values, overrides, mergo.WithOverride); err != nil {
+			return err
+		}
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	version := cilium.Version
+	if version == "" {
+		version = "1.15.6"
+	}
+	return AddHelmChart(k0sConfig,
+		km.HelmRepository{Name: "cilium", URL: "https://helm.cilium.io/"},
+		km.HelmChart{
+			Name:      "cilium",
+			ChartName: "cilium/cilium",
+			Version:   version,
+			Namespace: "kube-system",
+			Values:    &runtime.RawExtension{Raw: raw},
+		})
+}
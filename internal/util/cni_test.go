@@ -0,0 +1,90 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestSetCNI_calico(t *testing.T) {
+	k0sConfig := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"network": map[string]interface{}{"provider": "kuberouter", "podCIDR": "10.244.0.0/16"},
+		},
+	}
+
+	err := SetCNI(k0sConfig, &km.CNISpec{Preset: "calico", Calico: &km.CalicoCNISpec{Mode: "ipip", MTU: 1400}}, "", 0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"provider": "calico",
+		"podCIDR":  "10.244.0.0/16",
+		"calico":   map[string]interface{}{"mode": "ipip", "mtu": int64(1400)},
+	}, k0sConfig["spec"].(map[string]interface{})["network"])
+}
+
+func TestSetCNI_cilium(t *testing.T) {
+	k0sConfig := map[string]interface{}{"spec": map[string]interface{}{}}
+
+	err := SetCNI(k0sConfig, &km.CNISpec{Preset: "cilium", Cilium: &km.CiliumCNISpec{
+		Version:              "1.15.6",
+		KubeProxyReplacement: true,
+		Hubble:               true,
+		Values:               &runtime.RawExtension{Raw: []byte(`{"operator":{"replicas":2}}`)},
+	}}, "api.example.com", 6443)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"spec": map[string]interface{}{
+			"network": map[string]interface{}{
+				"provider":  "custom",
+				"kubeProxy": map[string]interface{}{"disabled": true},
+			},
+			"extensions": map[string]interface{}{
+				"helm": map[string]interface{}{
+					"repositories": []interface{}{map[string]interface{}{"name": "cilium", "url": "https://helm.cilium.io/"}},
+					"charts": []interface{}{map[string]interface{}{
+						"name":      "cilium",
+						"chartname": "cilium/cilium",
+						"version":   "1.15.6",
+						"namespace": "kube-system",
+						"values": "hubble:\n  enabled: true\n  relay:\n    enabled: true\nipam:\n  mode: kubernetes\n" +
+							"k8sServiceHost: api.example.com\nk8sServicePort: 6443\nkubeProxyReplacement: true\noperator:\n  replicas: 2\n",
+					}},
+				},
+			},
+		},
+	}, k0sConfig)
+
+	// The kube-proxy replacement needs the address of the API server
+	err = SetCNI(map[string]interface{}{}, &km.CNISpec{Preset: "cilium", Cilium: &km.CiliumCNISpec{KubeProxyReplacement: true}}, "", 6443)
+	require.Error(t, err)
+}
+
+func TestSetCNI_none(t *testing.T) {
+	k0sConfig := map[string]interface{}{}
+	require.NoError(t, SetCNI(k0sConfig, &km.CNISpec{Preset: "none"}, "", 0))
+	require.Equal(t, map[string]interface{}{
+		"spec": map[string]interface{}{"network": map[string]interface{}{"provider": "custom"}},
+	}, k0sConfig)
+
+	require.NoError(t, SetCNI(k0sConfig, nil, "", 0))
+	require.Error(t, SetCNI(k0sConfig, &km.CNISpec{Preset: "flannel"}, "", 0))
+}
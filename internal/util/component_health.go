@@ -0,0 +1,189 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	kapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// ComponentHealthScript checks the health of the k0s control plane components on a controller. It prints a line
+// per check in the form "<check> <exit code> <output>", the newlines of the output replaced with tabs.
+// The API server is probed with the verbose readiness check, which reports the etcd or kine storage as well.
+const ComponentHealthScript = `probe() {
+  name=$1
+  shift
+  out=$("$@" 2>&1)
+  rc=$?
+  echo "$name $rc $(printf '%s' "$out" | tr '\n' '\t')"
+}
+probe k0s k0s status -o json
+probe apiserver curl -sSk --max-time 5 'https://localhost:6443/readyz?verbose'
+probe scheduler curl -sSfk --max-time 5 https://localhost:10259/healthz
+probe controller-manager curl -sSfk --max-time 5 https://localhost:10257/healthz
+probe konnectivity curl -sSf --max-time 5 http://localhost:8092/healthz
+`
+
+// componentNames are the components reported by ParseComponentHealth, in order.
+var componentNames = []string{"k0s", "apiserver", "etcd", "scheduler", "controller-manager", "konnectivity"}
+
+type k0sStatus struct {
+	Version string
+	Role    string
+}
+
+// ParseComponentHealth parses the output of ComponentHealthScript to the health of the components.
+func ParseComponentHealth(output string) ([]kapi.ComponentHealth, error) {
+	results := map[string]kapi.ComponentHealth{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		rc, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit code of the %s check: %s", fields[0], fields[1])
+		}
+		var out string
+		if len(fields) == 3 {
+			out = strings.TrimSpace(strings.ReplaceAll(fields[2], "\t", "\n"))
+		}
+
+		switch name := fields[0]; name {
+		case "k0s":
+			results[name] = parseK0sStatus(rc, out)
+		case "apiserver":
+			results["apiserver"], results["etcd"] = parseReadyz(rc, out)
+		default:
+			results[name] = checkResult(name, rc, out)
+		}
+	}
+
+	var components []kapi.ComponentHealth
+	for _, name := range componentNames {
+		if c, ok := results[name]; ok {
+			components = append(components, c)
+		}
+	}
+	if len(components) == 0 {
+		return nil, fmt.Errorf("no component health checks in the output: %s", output)
+	}
+	return components, nil
+}
+
+func parseK0sStatus(rc int, out string) kapi.ComponentHealth {
+	if rc != 0 {
+		return kapi.ComponentHealth{Name: "k0s", Message: firstLine(out)}
+	}
+	var status k0sStatus
+	if err := json.Unmarshal([]byte(out), &status); err != nil {
+		return kapi.ComponentHealth{Name: "k0s", Message: fmt.Sprintf("failed to parse k0s status: %s", err)}
+	}
+	if !strings.Contains(status.Role, "controller") {
+		return kapi.ComponentHealth{Name: "k0s", Message: fmt.Sprintf("k0s %s is running as %s", status.Version, status.Role)}
+	}
+	return kapi.ComponentHealth{Name: "k0s", Healthy: true}
+}
+
+// parseReadyz parses the output of the verbose readiness check of the API server, e.g. "[+]etcd ok" or
+// "[-]etcd failed: reason withheld", to the health of the API server and its storage.
+func parseReadyz(rc int, out string) (kapi.ComponentHealth, kapi.ComponentHealth) {
+	apiserver := kapi.ComponentHealth{Name: "apiserver"}
+	etcd := kapi.ComponentHealth{Name: "etcd", Message: "The API server storage check is not reported"}
+	if rc != 0 {
+		apiserver.Message = firstLine(out)
+		etcd.Message = "The API server is not reachable"
+		return apiserver, etcd
+	}
+
+	var failed []string
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "[+]etcd "):
+			etcd.Healthy, etcd.Message = true, ""
+		case strings.HasPrefix(line, "[-]etcd "):
+			etcd.Message = strings.TrimPrefix(line, "[-]etcd ")
+		}
+		if strings.HasPrefix(line, "[-]") {
+			failed = append(failed, strings.Fields(strings.TrimPrefix(line, "[-]"))[0])
+		}
+	}
+	if strings.Contains(out, "readyz check passed") {
+		apiserver.Healthy = true
+	} else if len(failed) > 0 {
+		sort.Strings(failed)
+		apiserver.Message = fmt.Sprintf("failed readiness checks: %s", strings.Join(failed, ", "))
+	} else {
+		apiserver.Message = firstLine(out)
+	}
+	return apiserver, etcd
+}
+
+func checkResult(name string, rc int, out string) kapi.ComponentHealth {
+	if rc != 0 {
+		return kapi.ComponentHealth{Name: name, Message: firstLine(out)}
+	}
+	return kapi.ComponentHealth{Name: name, Healthy: true}
+}
+
+// MergeComponentHealth merges the health of the components checked on several controllers. A component is healthy
+// only if it's healthy on all the controllers, the messages are prefixed with the name of the controller.
+func MergeComponentHealth(controllers map[string][]kapi.ComponentHealth) []kapi.ComponentHealth {
+	names := make([]string, 0, len(controllers))
+	for name := range controllers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	merged := map[string]*kapi.ComponentHealth{}
+	var order []string
+	for _, controller := range names {
+		for _, c := range controllers[controller] {
+			m, ok := merged[c.Name]
+			if !ok {
+				m = &kapi.ComponentHealth{Name: c.Name, Healthy: true}
+				merged[c.Name] = m
+				order = append(order, c.Name)
+			}
+			if c.Healthy {
+				continue
+			}
+			m.Healthy = false
+			msg := fmt.Sprintf("%s: %s", controller, c.Message)
+			if m.Message != "" {
+				msg = m.Message + "; " + msg
+			}
+			m.Message = msg
+		}
+	}
+
+	components := make([]kapi.ComponentHealth, 0, len(order))
+	for _, name := range order {
+		components = append(components, *merged[name])
+	}
+	return components
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
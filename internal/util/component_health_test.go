@@ -0,0 +1,72 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestParseComponentHealth(t *testing.T) {
+	output := "k0s 0 {\t  \"Version\": \"v1.29.1+k0s.0\",\t  \"Role\": \"controller\"\t}\n" +
+		"apiserver 28 curl: (28) Operation timed out after 5001 milliseconds with 0 bytes received\n" +
+		"scheduler 0 ok\n" +
+		"controller-manager 7 curl: (7) Failed to connect to localhost port 10257: Connection refused\n" +
+		"konnectivity 0 ok\n"
+
+	components, err := ParseComponentHealth(output)
+	require.NoError(t, err)
+	assert.Equal(t, []kapi.ComponentHealth{
+		{Name: "k0s", Healthy: true},
+		{Name: "apiserver", Message: "curl: (28) Operation timed out after 5001 milliseconds with 0 bytes received"},
+		{Name: "etcd", Message: "The API server is not reachable"},
+		{Name: "scheduler", Healthy: true},
+		{Name: "controller-manager", Message: "curl: (7) Failed to connect to localhost port 10257: Connection refused"},
+		{Name: "konnectivity", Healthy: true},
+	}, components)
+}
+
+func TestParseComponentHealth_readyz(t *testing.T) {
+	components, err := ParseComponentHealth("apiserver 0 [+]ping ok\t[-]etcd failed: reason withheld\t[-]informer-sync failed: reason withheld\treadyz check failed\n")
+	require.NoError(t, err)
+	assert.Equal(t, []kapi.ComponentHealth{
+		{Name: "apiserver", Message: "failed readiness checks: etcd, informer-sync"},
+		{Name: "etcd", Message: "failed: reason withheld"},
+	}, components)
+
+	components, err = ParseComponentHealth("apiserver 0 [+]ping ok\t[+]etcd ok\treadyz check passed\n")
+	require.NoError(t, err)
+	assert.Equal(t, []kapi.ComponentHealth{{Name: "apiserver", Healthy: true}, {Name: "etcd", Healthy: true}}, components)
+
+	_, err = ParseComponentHealth("sh: not found")
+	require.Error(t, err)
+}
+
+func TestMergeComponentHealth(t *testing.T) {
+	merged := MergeComponentHealth(map[string][]kapi.ComponentHealth{
+		"kmc-test-1": {{Name: "k0s", Healthy: true}, {Name: "scheduler", Message: "connection refused"}},
+		"kmc-test-0": {{Name: "k0s", Healthy: true}, {Name: "scheduler", Message: "timeout"}},
+	})
+	assert.Equal(t, []kapi.ComponentHealth{
+		{Name: "k0s", Healthy: true},
+		{Name: "scheduler", Message: "kmc-test-0: timeout; kmc-test-1: connection refused"},
+	}, merged)
+}
@@ -3,9 +3,11 @@ package util
 import (
 	"context"
 	"fmt"
+	"reflect"
+
+	"github.com/k0sproject/k0smotron/internal/tracing"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"sigs.k8s.io/cluster-api/controllers/remote"
 	"sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -19,7 +21,7 @@ func ReconcileDynamicConfig(ctx context.Context, cluster metav1.Object, cli clie
 		return fmt.Errorf("failed to marshal unstructured config: %w", err)
 	}
 
-	chCS, err := remote.NewClusterClient(ctx, "k0smotron", cli, util.ObjectKey(cluster))
+	chCS, err := tracing.NewClusterClient(ctx, "k0smotron", cli, util.ObjectKey(cluster))
 	if err != nil {
 		return fmt.Errorf("failed to create workload cluster client: %w", err)
 	}
@@ -31,3 +33,60 @@ func ReconcileDynamicConfig(ctx context.Context, cluster metav1.Object, cli clie
 
 	return nil
 }
+
+// staticConfigFields are the fields of the k0s config that k0s doesn't reconcile with the dynamic config.
+// They are applied only when the controller is provisioned.
+var staticConfigFields = [][]string{
+	{"spec", "api"},
+	{"spec", "controllerManager"},
+	{"spec", "scheduler"},
+	{"spec", "storage"},
+	{"spec", "install"},
+	{"spec", "featureGates"},
+	{"spec", "network", "podCIDR"},
+	{"spec", "network", "serviceCIDR"},
+	{"spec", "network", "clusterDomain"},
+	{"spec", "network", "dualStack"},
+	{"spec", "network", "controlPlaneLoadBalancing"},
+}
+
+// DynamicConfigEnabled checks whether the controller args enable the k0s dynamic config.
+func DynamicConfigEnabled(args []string) bool {
+	for _, arg := range args {
+		if arg == "--enable-dynamic-config" || arg == "--enable-dynamic-config=true" {
+			return true
+		}
+	}
+	return false
+}
+
+// DynamicConfig returns a copy of the k0s config without the fields that k0s doesn't reconcile with the dynamic config.
+func DynamicConfig(u *unstructured.Unstructured) *unstructured.Unstructured {
+	dynamic := u.DeepCopy()
+	for _, field := range staticConfigFields {
+		unstructured.RemoveNestedField(dynamic.Object, field...)
+	}
+	return dynamic
+}
+
+// StaticConfigEqual checks whether the changes between the k0s configs can be applied to the running controllers.
+// With the dynamic config enabled only the static fields are compared, otherwise the whole spec is compared.
+func StaticConfigEqual(a, b *unstructured.Unstructured, dynamicConfig bool) bool {
+	if !dynamicConfig {
+		return reflect.DeepEqual(nestedField(a, "spec"), nestedField(b, "spec"))
+	}
+	for _, field := range staticConfigFields {
+		if !reflect.DeepEqual(nestedField(a, field...), nestedField(b, field...)) {
+			return false
+		}
+	}
+	return true
+}
+
+func nestedField(u *unstructured.Unstructured, fields ...string) interface{} {
+	if u == nil {
+		return nil
+	}
+	v, _, _ := unstructured.NestedFieldNoCopy(u.Object, fields...)
+	return v
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDynamicConfigEnabled(t *testing.T) {
+	require.True(t, DynamicConfigEnabled([]string{"--enable-worker", "--enable-dynamic-config"}))
+	require.True(t, DynamicConfigEnabled([]string{"--enable-dynamic-config=true"}))
+	require.False(t, DynamicConfigEnabled([]string{"--enable-dynamic-config=false"}))
+	require.False(t, DynamicConfigEnabled(nil))
+}
+
+func TestDynamicConfig(t *testing.T) {
+	config := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"api": map[string]interface{}{"port": int64(6443)},
+			"network": map[string]interface{}{
+				"podCIDR":  "10.244.0.0/16",
+				"provider": "calico",
+			},
+		},
+	}}
+
+	require.Equal(t, map[string]interface{}{
+		"spec": map[string]interface{}{
+			"network": map[string]interface{}{"provider": "calico"},
+		},
+	}, DynamicConfig(config).Object)
+	// The original config is not modified
+	require.Contains(t, config.Object["spec"], "api")
+}
+
+func TestStaticConfigEqual(t *testing.T) {
+	newConfig := func(podCIDR, provider string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"network": map[string]interface{}{
+					"podCIDR":  podCIDR,
+					"provider": provider,
+				},
+			},
+		}}
+	}
+
+	tests := []struct {
+		name          string
+		a             *unstructured.Unstructured
+		b             *unstructured.Unstructured
+		dynamicConfig bool
+		want          bool
+	}{
+		{
+			name:          "dynamic field changed",
+			a:             newConfig("10.244.0.0/16", "calico"),
+			b:             newConfig("10.244.0.0/16", "kuberouter"),
+			dynamicConfig: true,
+			want:          true,
+		},
+		{
+			name:          "static field changed",
+			a:             newConfig("10.244.0.0/16", "calico"),
+			b:             newConfig("10.245.0.0/16", "calico"),
+			dynamicConfig: true,
+			want:          false,
+		},
+		{
+			name: "dynamic field changed without dynamic config",
+			a:    newConfig("10.244.0.0/16", "calico"),
+			b:    newConfig("10.244.0.0/16", "kuberouter"),
+			want: false,
+		},
+		{
+			name: "no config",
+			want: true,
+		},
+		{
+			name:          "config added",
+			b:             newConfig("10.244.0.0/16", "calico"),
+			dynamicConfig: true,
+			want:          false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, StaticConfigEqual(tt.a, tt.b, tt.dynamicConfig))
+		})
+	}
+}
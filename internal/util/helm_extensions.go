@@ -0,0 +1,138 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// SetHelmExtensions renders the Helm extensions into the spec.extensions.helm of the k0s config. The Helm extensions
+// of the k0s config are replaced, while the other extensions, e.g. the storage, are kept.
+func SetHelmExtensions(k0sConfig map[string]interface{}, helm *km.HelmExtensions) error {
+	if helm == nil {
+		return nil
+	}
+
+	repositories := make([]interface{}, 0, len(helm.Repositories))
+	for _, repo := range helm.Repositories {
+		repositories = append(repositories, helmRepository(repo))
+	}
+
+	charts := make([]interface{}, 0, len(helm.Charts))
+	for _, c := range helm.Charts {
+		chart, err := helmChart(c)
+		if err != nil {
+			return err
+		}
+		charts = append(charts, chart)
+	}
+
+	helmConfig := map[string]interface{}{
+		"repositories": repositories,
+		"charts":       charts,
+	}
+	if helm.ConcurrencyLevel > 0 {
+		helmConfig["concurrencyLevel"] = int64(helm.ConcurrencyLevel)
+	}
+
+	return unstructured.SetNestedField(k0sConfig, helmConfig, "spec", "extensions", "helm")
+}
+
+// AddHelmChart adds the chart and its repository to the spec.extensions.helm of the k0s config, replacing the chart
+// and the repository with the same names. The other charts and repositories are kept.
+func AddHelmChart(k0sConfig map[string]interface{}, repo km.HelmRepository, c km.HelmChart) error {
+	chart, err := helmChart(c)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range []struct {
+		name  string
+		value map[string]interface{}
+	}{{"repositories", helmRepository(repo)}, {"charts", chart}} {
+		items, _, err := unstructured.NestedSlice(k0sConfig, "spec", "extensions", "helm", field.name)
+		if err != nil {
+			return fmt.Errorf("failed to get the helm %s of the k0s config: %w", field.name, err)
+		}
+		items = slices.DeleteFunc(items, func(item interface{}) bool {
+			m, ok := item.(map[string]interface{})
+			return ok && m["name"] == field.value["name"]
+		})
+		items = append(items, field.value)
+		if err := unstructured.SetNestedSlice(k0sConfig, items, "spec", "extensions", "helm", field.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func helmRepository(repo km.HelmRepository) map[string]interface{} {
+	repository := map[string]interface{}{
+		"name": repo.Name,
+		"url":  repo.URL,
+	}
+	setIfNotEmpty(repository, "caFile", repo.CAFile)
+	setIfNotEmpty(repository, "certFile", repo.CertFile)
+	setIfNotEmpty(repository, "keyfile", repo.KeyFile)
+	setIfNotEmpty(repository, "username", repo.Username)
+	setIfNotEmpty(repository, "password", repo.Password)
+	if repo.Insecure {
+		repository["insecure"] = true
+	}
+	return repository
+}
+
+func helmChart(c km.HelmChart) (map[string]interface{}, error) {
+	chart := map[string]interface{}{
+		"name":      c.Name,
+		"chartname": c.ChartName,
+		"namespace": c.Namespace,
+	}
+	setIfNotEmpty(chart, "version", c.Version)
+	// k0s expects the values as a YAML document
+	if c.Values != nil && len(c.Values.Raw) > 0 {
+		values := map[string]interface{}{}
+		if err := json.Unmarshal(c.Values.Raw, &values); err != nil {
+			return nil, fmt.Errorf("failed to parse values of chart %s: %w", c.Name, err)
+		}
+		b, err := yaml.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		chart["values"] = string(b)
+	}
+	if c.Timeout != nil {
+		chart["timeout"] = c.Timeout.Duration.String()
+	}
+	if c.Order != 0 {
+		chart["order"] = int64(c.Order)
+	}
+	return chart, nil
+}
+
+func setIfNotEmpty(m map[string]interface{}, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
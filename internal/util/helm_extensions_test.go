@@ -0,0 +1,139 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestSetHelmExtensions(t *testing.T) {
+	k0sConfig := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"extensions": map[string]interface{}{
+				"helm":    map[string]interface{}{"charts": []interface{}{map[string]interface{}{"name": "old"}}},
+				"storage": map[string]interface{}{"type": "openebs_local_storage"},
+			},
+		},
+	}
+
+	err := SetHelmExtensions(k0sConfig, &km.HelmExtensions{
+		ConcurrencyLevel: 2,
+		Repositories: []km.HelmRepository{
+			{Name: "cilium", URL: "https://helm.cilium.io/"},
+			{Name: "private", URL: "https://charts.example.com", Username: "user", Password: "pass", Insecure: true},
+		},
+		Charts: []km.HelmChart{
+			{
+				Name:      "cilium",
+				ChartName: "cilium/cilium",
+				Version:   "1.14.5",
+				Namespace: "kube-system",
+				Values:    &runtime.RawExtension{Raw: []byte(`{"kubeProxyReplacement":"strict","operator":{"replicas":1}}`)},
+				Timeout:   &metav1.Duration{Duration: 10 * time.Minute},
+				Order:     1,
+			},
+			{Name: "ingress", ChartName: "oci://charts.example.com/ingress", Namespace: "ingress"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"spec": map[string]interface{}{
+			"extensions": map[string]interface{}{
+				"helm": map[string]interface{}{
+					"concurrencyLevel": int64(2),
+					"repositories": []interface{}{
+						map[string]interface{}{"name": "cilium", "url": "https://helm.cilium.io/"},
+						map[string]interface{}{"name": "private", "url": "https://charts.example.com", "username": "user", "password": "pass", "insecure": true},
+					},
+					"charts": []interface{}{
+						map[string]interface{}{
+							"name":      "cilium",
+							"chartname": "cilium/cilium",
+							"version":   "1.14.5",
+							"namespace": "kube-system",
+							"values":    "kubeProxyReplacement: strict\noperator:\n  replicas: 1\n",
+							"timeout":   "10m0s",
+							"order":     int64(1),
+						},
+						map[string]interface{}{"name": "ingress", "chartname": "oci://charts.example.com/ingress", "namespace": "ingress"},
+					},
+				},
+				"storage": map[string]interface{}{"type": "openebs_local_storage"},
+			},
+		},
+	}, k0sConfig)
+}
+
+func TestSetHelmExtensions_noExtensions(t *testing.T) {
+	k0sConfig := map[string]interface{}{"spec": map[string]interface{}{}}
+	require.NoError(t, SetHelmExtensions(k0sConfig, nil))
+	require.Equal(t, map[string]interface{}{"spec": map[string]interface{}{}}, k0sConfig)
+}
+
+func TestSetHelmExtensions_invalidValues(t *testing.T) {
+	err := SetHelmExtensions(map[string]interface{}{}, &km.HelmExtensions{
+		Charts: []km.HelmChart{{Name: "cilium", ChartName: "cilium/cilium", Namespace: "kube-system", Values: &runtime.RawExtension{Raw: []byte(`[1]`)}}},
+	})
+	require.ErrorContains(t, err, "failed to parse values of chart cilium")
+}
+
+func TestAddHelmChart(t *testing.T) {
+	k0sConfig := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"extensions": map[string]interface{}{
+				"helm": map[string]interface{}{
+					"repositories": []interface{}{map[string]interface{}{"name": "hcloud", "url": "https://old.example.com"}},
+					"charts": []interface{}{
+						map[string]interface{}{"name": "cilium", "chartname": "cilium/cilium", "namespace": "kube-system"},
+						map[string]interface{}{"name": "cloud-controller-manager", "chartname": "old/ccm", "namespace": "kube-system"},
+					},
+				},
+			},
+		},
+	}
+
+	err := AddHelmChart(k0sConfig,
+		km.HelmRepository{Name: "hcloud", URL: "https://charts.hetzner.cloud"},
+		km.HelmChart{
+			Name:      "cloud-controller-manager",
+			ChartName: "hcloud/hcloud-cloud-controller-manager",
+			Namespace: "kube-system",
+			Values:    &runtime.RawExtension{Raw: []byte(`{"networking":{"enabled":true}}`)},
+		},
+	)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]interface{}{
+		"repositories": []interface{}{map[string]interface{}{"name": "hcloud", "url": "https://charts.hetzner.cloud"}},
+		"charts": []interface{}{
+			map[string]interface{}{"name": "cilium", "chartname": "cilium/cilium", "namespace": "kube-system"},
+			map[string]interface{}{
+				"name":      "cloud-controller-manager",
+				"chartname": "hcloud/hcloud-cloud-controller-manager",
+				"namespace": "kube-system",
+				"values":    "networking:\n  enabled: true\n",
+			},
+		},
+	}, k0sConfig["spec"].(map[string]interface{})["extensions"].(map[string]interface{})["helm"])
+}
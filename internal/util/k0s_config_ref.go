@@ -0,0 +1,136 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/imdario/mergo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// DefaultK0sConfigRefKey is the key of the ConfigMap or the Secret holding the referenced k0s config by default.
+const DefaultK0sConfigRefKey = "k0s.yaml"
+
+// ResolveK0sConfig returns the k0s config referenced by ref with the inline k0s config merged over it. Without
+// a reference, the inline config is returned as is.
+func ResolveK0sConfig(ctx context.Context, c client.Client, namespace string, ref *km.K0sConfigRef, inline *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if ref == nil {
+		return inline, nil
+	}
+
+	data, err := readK0sConfigRef(ctx, c, namespace, ref)
+	if err != nil {
+		return nil, err
+	}
+	base := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("failed to parse the referenced k0s config: %w", err)
+	}
+
+	k0sConfig := &unstructured.Unstructured{Object: base}
+	if k0sConfig.GetAPIVersion() == "" {
+		k0sConfig.SetAPIVersion("k0s.k0sproject.io/v1beta1")
+	}
+	if k0sConfig.GetKind() == "" {
+		k0sConfig.SetKind("ClusterConfig")
+	}
+	if inline != nil {
+		if err := mergo.Merge(&k0sConfig.Object, inline.DeepCopy().Object, mergo.WithOverride); err != nil {
+			return nil, fmt.Errorf("failed to merge the k0s config over the referenced one: %w", err)
+		}
+	}
+	return k0sConfig, nil
+}
+
+func readK0sConfigRef(ctx context.Context, c client.Client, namespace string, ref *km.K0sConfigRef) ([]byte, error) {
+	sources := 0
+	for _, set := range []bool{ref.ConfigMapRef != nil, ref.SecretRef != nil, ref.OCI != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, errors.New("exactly one of configMapRef, secretRef and oci must be set in k0sConfigRef")
+	}
+
+	key := ref.Key
+	if key == "" {
+		key = DefaultK0sConfigRefKey
+	}
+	switch {
+	case ref.ConfigMapRef != nil:
+		var cm corev1.ConfigMap
+		if err := c.Get(ctx, client.ObjectKey{Name: ref.ConfigMapRef.Name, Namespace: namespace}, &cm); err != nil {
+			return nil, fmt.Errorf("failed to get k0s config configmap: %w", err)
+		}
+		data, ok := cm.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("configmap %s has no key %s", cm.Name, key)
+		}
+		return []byte(data), nil
+	case ref.SecretRef != nil:
+		var secret corev1.Secret
+		if err := c.Get(ctx, client.ObjectKey{Name: ref.SecretRef.Name, Namespace: namespace}, &secret); err != nil {
+			return nil, fmt.Errorf("failed to get k0s config secret: %w", err)
+		}
+		data, ok := secret.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no key %s", secret.Name, key)
+		}
+		return data, nil
+	default:
+		data, err := PullOCIArtifact(ctx, c, namespace, ref.OCI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pull k0s config artifact %s: %w", ref.OCI.Reference, err)
+		}
+		return data, nil
+	}
+}
+
+// K0sConfigRefIndexKeys returns the field index values of the ConfigMap or the Secret referenced by ref, so the
+// objects referencing it are reconciled when it changes.
+func K0sConfigRefIndexKeys(ref *km.K0sConfigRef) []string {
+	if ref == nil {
+		return nil
+	}
+	switch {
+	case ref.ConfigMapRef != nil:
+		return []string{K0sConfigRefIndexKey(&corev1.ConfigMap{}, ref.ConfigMapRef.Name)}
+	case ref.SecretRef != nil:
+		return []string{K0sConfigRefIndexKey(&corev1.Secret{}, ref.SecretRef.Name)}
+	}
+	return nil
+}
+
+// K0sConfigRefIndexKey returns the field index value of the named ConfigMap or Secret.
+func K0sConfigRefIndexKey(obj client.Object, name string) string {
+	switch obj.(type) {
+	case *corev1.ConfigMap:
+		return "ConfigMap/" + name
+	case *corev1.Secret:
+		return "Secret/" + name
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+const baselineK0sConfig = `
+apiVersion: k0s.k0sproject.io/v1beta1
+kind: ClusterConfig
+spec:
+  network:
+    provider: calico
+    podCIDR: 10.244.0.0/16
+  telemetry:
+    enabled: false
+`
+
+func TestResolveK0sConfig(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "baseline", Namespace: "default"},
+			Data:       map[string]string{"k0s.yaml": baselineK0sConfig},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "baseline", Namespace: "default"},
+			Data:       map[string][]byte{"config": []byte("spec:\n  api:\n    port: 6443\n")},
+		},
+	).Build()
+	inline := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"network": map[string]interface{}{"provider": "kuberouter"},
+		},
+	}}
+
+	t.Run("without reference", func(t *testing.T) {
+		k0sConfig, err := ResolveK0sConfig(context.Background(), c, "default", nil, inline)
+		require.NoError(t, err)
+		require.Equal(t, inline, k0sConfig)
+	})
+
+	t.Run("configmap merged with the inline config", func(t *testing.T) {
+		ref := &km.K0sConfigRef{ConfigMapRef: &corev1.LocalObjectReference{Name: "baseline"}}
+		k0sConfig, err := ResolveK0sConfig(context.Background(), c, "default", ref, inline)
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"apiVersion": "k0s.k0sproject.io/v1beta1",
+			"kind":       "ClusterConfig",
+			"spec": map[string]interface{}{
+				"network": map[string]interface{}{
+					"provider": "kuberouter",
+					"podCIDR":  "10.244.0.0/16",
+				},
+				"telemetry": map[string]interface{}{"enabled": false},
+			},
+		}, k0sConfig.Object)
+		// The inline config is not modified
+		require.Equal(t, map[string]interface{}{"provider": "kuberouter"}, inline.Object["spec"].(map[string]interface{})["network"])
+	})
+
+	t.Run("secret with a custom key", func(t *testing.T) {
+		ref := &km.K0sConfigRef{SecretRef: &corev1.LocalObjectReference{Name: "baseline"}, Key: "config"}
+		k0sConfig, err := ResolveK0sConfig(context.Background(), c, "default", ref, nil)
+		require.NoError(t, err)
+		require.Equal(t, "ClusterConfig", k0sConfig.GetKind())
+		port, found, err := unstructured.NestedInt64(k0sConfig.Object, "spec", "api", "port")
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, int64(6443), port)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		ref := &km.K0sConfigRef{ConfigMapRef: &corev1.LocalObjectReference{Name: "baseline"}, Key: "missing.yaml"}
+		_, err := ResolveK0sConfig(context.Background(), c, "default", ref, inline)
+		require.ErrorContains(t, err, "has no key missing.yaml")
+	})
+
+	t.Run("several sources", func(t *testing.T) {
+		ref := &km.K0sConfigRef{
+			ConfigMapRef: &corev1.LocalObjectReference{Name: "baseline"},
+			SecretRef:    &corev1.LocalObjectReference{Name: "baseline"},
+		}
+		_, err := ResolveK0sConfig(context.Background(), c, "default", ref, inline)
+		require.ErrorContains(t, err, "exactly one of")
+	})
+}
+
+func TestK0sConfigRefIndexKeys(t *testing.T) {
+	require.Nil(t, K0sConfigRefIndexKeys(nil))
+	require.Equal(t, []string{"ConfigMap/baseline"}, K0sConfigRefIndexKeys(&km.K0sConfigRef{ConfigMapRef: &corev1.LocalObjectReference{Name: "baseline"}}))
+	require.Equal(t, []string{"Secret/baseline"}, K0sConfigRefIndexKeys(&km.K0sConfigRef{SecretRef: &corev1.LocalObjectReference{Name: "baseline"}}))
+	require.Nil(t, K0sConfigRefIndexKeys(&km.K0sConfigRef{OCI: &km.OCIArtifactRef{Reference: "registry.example.com/k0s-config:v1"}}))
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+const (
+	// dockerHubRegistry is the registry API host of docker.io.
+	dockerHubRegistry = "registry-1.docker.io"
+	// ociManifestMediaTypes are the accepted media types of the artifact manifest.
+	ociManifestMediaTypes = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json"
+	// ociArtifactMaxSize is the maximum size of a pulled manifest or layer.
+	ociArtifactMaxSize = 1 << 20
+	// ociPullTimeout is the time to wait for an artifact to be pulled.
+	ociPullTimeout = 30 * time.Second
+)
+
+var authChallengeParamRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// PullOCIArtifact downloads the single layer of the OCI artifact, e.g. a file pushed with `oras push`. The registry
+// credentials are read from the pull secret of the reference in the namespace.
+func PullOCIArtifact(ctx context.Context, c client.Client, namespace string, ref *km.OCIArtifactRef) ([]byte, error) {
+	registry, repository, reference, err := parseOCIReference(ref.Reference)
+	if err != nil {
+		return nil, err
+	}
+
+	puller := &ociPuller{repository: repository}
+	if ref.PullSecretRef != nil {
+		var secret corev1.Secret
+		if err := c.Get(ctx, client.ObjectKey{Name: ref.PullSecretRef.Name, Namespace: namespace}, &secret); err != nil {
+			return nil, fmt.Errorf("failed to get pull secret: %w", err)
+		}
+		if puller.username, puller.password, err = registryCredentials(secret.Data[corev1.DockerConfigJsonKey], registry); err != nil {
+			return nil, fmt.Errorf("failed to read pull secret %s: %w", secret.Name, err)
+		}
+	}
+	scheme := "https"
+	if ref.Insecure {
+		scheme = "http"
+	}
+	puller.baseURL = fmt.Sprintf("%s://%s/v2/%s", scheme, registry, repository)
+
+	ctx, cancel := context.WithTimeout(ctx, ociPullTimeout)
+	defer cancel()
+
+	b, err := puller.get(ctx, "manifests/"+reference, ociManifestMediaTypes)
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Layers []struct {
+			Digest string `json:"digest"`
+			Size   int64  `json:"size"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if len(manifest.Layers) != 1 {
+		return nil, fmt.Errorf("artifact has %d layers, expected 1", len(manifest.Layers))
+	}
+	layer := manifest.Layers[0]
+	if layer.Size > ociArtifactMaxSize {
+		return nil, fmt.Errorf("layer is larger than %d bytes", ociArtifactMaxSize)
+	}
+
+	b, err = puller.get(ctx, "blobs/"+layer.Digest, "")
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := strings.CutPrefix(layer.Digest, "sha256:")
+	if !ok {
+		return nil, fmt.Errorf("unsupported layer digest %s", layer.Digest)
+	}
+	if sum := sha256.Sum256(b); hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("layer doesn't match its digest %s", layer.Digest)
+	}
+	return b, nil
+}
+
+// parseOCIReference splits the reference into the registry host, the repository and the tag or the digest. As for
+// the OCI artifacts of the k0s binary, the registry must be set explicitly.
+func parseOCIReference(ref string) (registry, repository, reference string, err error) {
+	registry, path, ok := strings.Cut(ref, "/")
+	if !ok || path == "" || !(strings.ContainsAny(registry, ".:") || registry == "localhost") {
+		return "", "", "", fmt.Errorf("invalid OCI artifact reference %s: the registry must be set", ref)
+	}
+	if registry == "docker.io" {
+		registry = dockerHubRegistry
+	}
+
+	if repository, digest, ok := strings.Cut(path, "@"); ok {
+		return registry, repository, digest, nil
+	}
+	// The tag is separated by the last colon after the last slash
+	if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") {
+		return registry, path[:i], path[i+1:], nil
+	}
+	return registry, path, "latest", nil
+}
+
+// registryCredentials returns the username and the password of the registry in the dockerconfigjson.
+func registryCredentials(dockerConfig []byte, registry string) (string, string, error) {
+	var config struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(dockerConfig, &config); err != nil {
+		return "", "", err
+	}
+
+	for _, server := range []string{registry, "https://" + registry} {
+		auth, ok := config.Auths[server]
+		if !ok {
+			continue
+		}
+		if auth.Auth == "" {
+			return auth.Username, auth.Password, nil
+		}
+		b, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid auth of registry %s: %w", registry, err)
+		}
+		username, password, _ := strings.Cut(string(b), ":")
+		return username, password, nil
+	}
+	return "", "", fmt.Errorf("no credentials for registry %s", registry)
+}
+
+// ociPuller gets the manifests and the blobs of a repository with the distribution API. It requests a bearer
+// token when the registry asks for one.
+type ociPuller struct {
+	baseURL    string
+	repository string
+	username   string
+	password   string
+	token      string
+}
+
+func (p *ociPuller) get(ctx context.Context, path, accept string) ([]byte, error) {
+	resp, err := p.do(ctx, path, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && p.token == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := p.authorize(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = p.do(ctx, path, accept); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get %s: %s", path, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, ociArtifactMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > ociArtifactMaxSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, ociArtifactMaxSize)
+	}
+	return b, nil
+}
+
+func (p *ociPuller) do(ctx context.Context, path, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	switch {
+	case p.token != "":
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	case p.username != "":
+		req.SetBasicAuth(p.username, p.password)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// authorize requests a pull token from the token server of the bearer challenge.
+func (p *ociPuller) authorize(ctx context.Context, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return fmt.Errorf("registry denied access to %s", p.repository)
+	}
+	values := map[string]string{}
+	for _, m := range authChallengeParamRe.FindAllStringSubmatch(params, -1) {
+		values[m[1]] = m[2]
+	}
+
+	tokenURL, err := url.Parse(values["realm"])
+	if err != nil || values["realm"] == "" {
+		return fmt.Errorf("invalid registry auth challenge %q", challenge)
+	}
+	query := tokenURL.Query()
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", p.repository)
+	}
+	query.Set("scope", scope)
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	if p.username != "" {
+		req.SetBasicAuth(p.username, p.password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get registry token: %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, ociArtifactMaxSize)).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode registry token: %w", err)
+	}
+	p.token = token.Token
+	if p.token == "" {
+		p.token = token.AccessToken
+	}
+	if p.token == "" {
+		return fmt.Errorf("registry token server returned no token")
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestParseOCIReference(t *testing.T) {
+	tests := []struct {
+		ref        string
+		registry   string
+		repository string
+		reference  string
+		wantErr    bool
+	}{
+		{ref: "registry.example.com/configs/k0s:v1", registry: "registry.example.com", repository: "configs/k0s", reference: "v1"},
+		{ref: "localhost:5000/k0s", registry: "localhost:5000", repository: "k0s", reference: "latest"},
+		{ref: "ghcr.io/org/k0s@sha256:abc", registry: "ghcr.io", repository: "org/k0s", reference: "sha256:abc"},
+		{ref: "docker.io/org/k0s", registry: "registry-1.docker.io", repository: "org/k0s", reference: "latest"},
+		{ref: "org/k0s:v1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			registry, repository, reference, err := parseOCIReference(tt.ref)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.registry, registry)
+			require.Equal(t, tt.repository, repository)
+			require.Equal(t, tt.reference, reference)
+		})
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	config := []byte(`{"auths":{"https://other.example.com":{"auth":"dXNlcjpwYXNz"},"registry.example.com":{"username":"u","password":"p"}}}`)
+
+	username, password, err := registryCredentials(config, "other.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "user", username)
+	require.Equal(t, "pass", password)
+
+	username, password, err = registryCredentials(config, "registry.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "u", username)
+	require.Equal(t, "p", password)
+
+	_, _, err = registryCredentials(config, "ghcr.io")
+	require.Error(t, err)
+}
+
+func TestPullOCIArtifact(t *testing.T) {
+	layer := []byte(baselineK0sConfig)
+	sum := sha256.Sum256(layer)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			username, password, ok := r.BasicAuth()
+			if !ok || username != "user" || password != "pass" || r.URL.Query().Get("scope") != "repository:configs/k0s:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"secret-token"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/configs/k0s/manifests/v1":
+			fmt.Fprintf(w, `{"schemaVersion":2,"layers":[{"mediaType":"application/yaml","digest":"%s","size":%d}]}`, digest, len(layer))
+		case "/v2/configs/k0s/blobs/" + digest:
+			_, _ = w.Write(layer)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	c := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "default"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(fmt.Sprintf(`{"auths":{"%s":{"username":"user","password":"pass"}}}`, host)),
+		},
+	}).Build()
+	ref := &km.OCIArtifactRef{
+		Reference:     host + "/configs/k0s:v1",
+		PullSecretRef: &corev1.LocalObjectReference{Name: "pull-secret"},
+		Insecure:      true,
+	}
+
+	b, err := PullOCIArtifact(context.Background(), c, "default", ref)
+	require.NoError(t, err)
+	require.Equal(t, layer, b)
+
+	ref.Reference = host + "/configs/k0s:v2"
+	_, err = PullOCIArtifact(context.Background(), c, "default", ref)
+	require.ErrorContains(t, err, "404")
+}
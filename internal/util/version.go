@@ -0,0 +1,50 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+const (
+	// DefaultK0sSuffix is the k0s build suffix added to the versions that don't have one.
+	DefaultK0sSuffix = "k0s.0"
+	// DefaultK0sVersion is the k0s version of the control planes that don't specify one.
+	DefaultK0sVersion = "v1.27.9+k0s.0"
+)
+
+// K0sVersion returns the k0s version for the given version, adding the default k0s build suffix
+// if the version doesn't have one, e.g. "v1.28.4+k0s.0" for "v1.28.4".
+func K0sVersion(version string) string {
+	if strings.Contains(version, "+k0s.") {
+		return version
+	}
+	return fmt.Sprintf("%s+%s", version, DefaultK0sSuffix)
+}
+
+// KubernetesVersion returns the Kubernetes version of the given k0s version without the k0s build suffix,
+// e.g. "v1.28.4" for "v1.28.4+k0s.0".
+func KubernetesVersion(version string) (string, error) {
+	ver, err := semver.NewVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("error parsing version %q: %w", version, err)
+	}
+	return fmt.Sprintf("v%d.%d.%d", ver.Major(), ver.Minor(), ver.Patch()), nil
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+// SetWorkerProfiles renders the worker profiles into the spec.workerProfiles of the k0s config. The worker profiles
+// of the k0s config are replaced, so the profiles are managed in a single place.
+func SetWorkerProfiles(k0sConfig map[string]interface{}, profiles []km.WorkerProfile) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+
+	workerProfiles := make([]interface{}, 0, len(profiles))
+	for _, profile := range profiles {
+		values := map[string]interface{}{}
+		if len(profile.Values.Raw) > 0 {
+			if err := json.Unmarshal(profile.Values.Raw, &values); err != nil {
+				return fmt.Errorf("failed to parse values of worker profile %s: %w", profile.Name, err)
+			}
+		}
+		workerProfiles = append(workerProfiles, map[string]interface{}{
+			"name":   profile.Name,
+			"values": values,
+		})
+	}
+
+	return unstructured.SetNestedSlice(k0sConfig, workerProfiles, "spec", "workerProfiles")
+}
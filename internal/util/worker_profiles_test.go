@@ -0,0 +1,66 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	km "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestSetWorkerProfiles(t *testing.T) {
+	k0sConfig := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"api": map[string]interface{}{"port": int64(6443)},
+			"workerProfiles": []interface{}{
+				map[string]interface{}{"name": "old", "values": map[string]interface{}{}},
+			},
+		},
+	}
+
+	err := SetWorkerProfiles(k0sConfig, []km.WorkerProfile{
+		{Name: "custom", Values: runtime.RawExtension{Raw: []byte(`{"maxPods":200,"cgroupsPerQOS":false}`)}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"spec": map[string]interface{}{
+			"api": map[string]interface{}{"port": int64(6443)},
+			"workerProfiles": []interface{}{
+				map[string]interface{}{
+					"name":   "custom",
+					"values": map[string]interface{}{"maxPods": float64(200), "cgroupsPerQOS": false},
+				},
+			},
+		},
+	}, k0sConfig)
+}
+
+func TestSetWorkerProfiles_noProfiles(t *testing.T) {
+	k0sConfig := map[string]interface{}{"spec": map[string]interface{}{}}
+	require.NoError(t, SetWorkerProfiles(k0sConfig, nil))
+	require.Equal(t, map[string]interface{}{"spec": map[string]interface{}{}}, k0sConfig)
+}
+
+func TestSetWorkerProfiles_invalidValues(t *testing.T) {
+	err := SetWorkerProfiles(map[string]interface{}{}, []km.WorkerProfile{
+		{Name: "custom", Values: runtime.RawExtension{Raw: []byte(`[1]`)}},
+	})
+	require.ErrorContains(t, err, "failed to parse values of worker profile custom")
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	kapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+const (
+	defaultClusterImage     = "k0sproject/k0s"
+	defaultEtcdImage        = "quay.io/k0sproject/etcd:v3.5.13"
+	defaultAPIPort          = 30443
+	defaultKonnectivityPort = 30132
+
+	// controllerAPIPort is the port of the API server in the controller pods, which the konnectivity port
+	// can't reuse.
+	controllerAPIPort = 6443
+	// The default node port range of the API server of the management cluster, set with --service-node-port-range.
+	minNodePort = 30000
+	maxNodePort = 32767
+)
+
+// +kubebuilder:webhook:path=/mutate-k0smotron-io-v1beta1-cluster,mutating=true,failurePolicy=fail,sideEffects=None,groups=k0smotron.io,resources=clusters,verbs=create;update,versions=v1beta1,name=default.cluster.k0smotron.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-k0smotron-io-v1beta1-cluster,mutating=false,failurePolicy=fail,sideEffects=None,groups=k0smotron.io,resources=clusters,verbs=create;update,versions=v1beta1,name=validation.cluster.k0smotron.io,admissionReviewVersions=v1
+
+// Cluster sets the default images and ports of the k0smotron Cluster and validates its spec, so the errors the
+// reconciliation would hit are reported when the cluster is applied.
+type Cluster struct {
+	Client client.Reader
+}
+
+var _ admission.CustomDefaulter = &Cluster{}
+var _ admission.CustomValidator = &Cluster{}
+
+func (w *Cluster) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&kapi.Cluster{}).
+		WithDefaulter(w).
+		WithValidator(w).
+		Complete()
+}
+
+// Default sets the k0s and etcd images, the service type and the service ports if they are empty.
+func (w *Cluster) Default(_ context.Context, obj runtime.Object) error {
+	kmc, ok := obj.(*kapi.Cluster)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a Cluster but got a %T", obj))
+	}
+
+	if kmc.Spec.Image == "" {
+		kmc.Spec.Image = defaultClusterImage
+	}
+	if kmc.Spec.Etcd.Image == "" && kmc.Spec.Etcd.External == nil {
+		kmc.Spec.Etcd.Image = defaultEtcdImage
+	}
+	if kmc.Spec.Service.Type == "" {
+		kmc.Spec.Service.Type = v1.ServiceTypeClusterIP
+	}
+	if kmc.Spec.Service.APIPort == 0 {
+		kmc.Spec.Service.APIPort = defaultAPIPort
+	}
+	if kmc.Spec.Service.KonnectivityPort == 0 {
+		kmc.Spec.Service.KonnectivityPort = defaultKonnectivityPort
+	}
+
+	return nil
+}
+
+// ValidateCreate validates the spec of the Cluster.
+func (w *Cluster) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	kmc, ok := obj.(*kapi.Cluster)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a Cluster but got a %T", obj))
+	}
+
+	return validateCluster(kmc)
+}
+
+// ValidateUpdate validates the spec of the Cluster if it is changed, so the clusters created before the webhook
+// can still be updated by the controllers, e.g. to remove their finalizers.
+func (w *Cluster) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldKmc, ok := oldObj.(*kapi.Cluster)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a Cluster but got a %T", oldObj))
+	}
+	kmc, ok := newObj.(*kapi.Cluster)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a Cluster but got a %T", newObj))
+	}
+	if equality.Semantic.DeepEqual(oldKmc.Spec, kmc.Spec) {
+		return nil, nil
+	}
+
+	return validateCluster(kmc)
+}
+
+// ValidateDelete allows the deletion of the Cluster.
+func (w *Cluster) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateCluster returns an error listing the invalid fields of the spec, and warnings about the settings
+// k0smotron adjusts when reconciling the cluster.
+func validateCluster(kmc *kapi.Cluster) (admission.Warnings, error) {
+	spec := &kmc.Spec
+	specPath := field.NewPath("spec")
+	var warnings admission.Warnings
+	var errs field.ErrorList
+
+	warnings = append(warnings, validateReplicas(spec, specPath, &errs)...)
+	errs = append(errs, validateImage(spec.Image, specPath.Child("image"))...)
+	errs = append(errs, validateVersion(spec.Version, specPath.Child("version"))...)
+	errs = append(errs, validatePersistence(spec.Persistence, specPath.Child("persistence"))...)
+	warnings = append(warnings, validateService(spec.Service, specPath.Child("service"), &errs)...)
+
+	if len(errs) > 0 {
+		return warnings, apierrors.NewInvalid(kapi.GroupVersion.WithKind("Cluster").GroupKind(), kmc.Name, errs)
+	}
+	return warnings, nil
+}
+
+// validateReplicas validates the replicas and the datastore of the cluster. The etcd deployed by k0smotron
+// needs an odd number of members for its quorum, so it runs one more member than an even number of replicas.
+func validateReplicas(spec *kapi.ClusterSpec, specPath *field.Path, errs *field.ErrorList) admission.Warnings {
+	if spec.Replicas < 0 {
+		*errs = append(*errs, field.Invalid(specPath.Child("replicas"), spec.Replicas, "must be greater than or equal to 0"))
+	}
+
+	if spec.KineDataSourceURL != "" && spec.KineDataSourceSecretName != "" {
+		*errs = append(*errs, field.Forbidden(specPath.Child("kineDataSourceSecretName"), "kineDataSourceURL and kineDataSourceSecretName are mutually exclusive"))
+	}
+	kine := spec.KineDataSourceURL != "" || spec.KineDataSourceSecretName != ""
+	if kine && spec.Etcd.External != nil {
+		*errs = append(*errs, field.Forbidden(specPath.Child("etcd", "external"), "an external etcd can't be used with a kine datasource"))
+	}
+
+	if kine || spec.Etcd.External != nil {
+		return nil
+	}
+	if spec.Replicas > 1 && spec.Replicas%2 == 0 {
+		return admission.Warnings{fmt.Sprintf("spec.replicas: etcd needs an odd number of members, %d etcd members are deployed for %d replicas", spec.Replicas+1, spec.Replicas)}
+	}
+	return nil
+}
+
+// validateImage validates that the k0s image has no tag or digest, as the tag is set from the version.
+func validateImage(image string, path *field.Path) field.ErrorList {
+	name := image[strings.LastIndex(image, "/")+1:]
+	if strings.ContainsAny(name, ":@") {
+		return field.ErrorList{field.Invalid(path, image, "must not include the image tag or digest, the tag is set from spec.version")}
+	}
+	return nil
+}
+
+// validateVersion validates that the version is a k0s version in the format of the k0s image tags,
+// e.g. v1.28.4-k0s.0, or a Kubernetes version to which the default k0s suffix is added.
+func validateVersion(version string, path *field.Path) field.ErrorList {
+	if version == "" {
+		return nil
+	}
+	if strings.Contains(version, "+") {
+		return field.ErrorList{field.Invalid(path, version, fmt.Sprintf("must be in the format of the k0s image tags, e.g. %s", strings.Replace(version, "+", "-", 1)))}
+	}
+	if _, err := semver.NewVersion(version); err != nil {
+		return field.ErrorList{field.Invalid(path, version, "must be a k0s version, e.g. v1.28.4-k0s.0")}
+	}
+	return nil
+}
+
+// validatePersistence validates that the settings of the persistence type are set, and that the settings of
+// the other types are not.
+func validatePersistence(persistence kapi.PersistenceSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	switch persistence.Type {
+	case "", "emptyDir":
+	case "hostPath":
+		if persistence.HostPath == "" {
+			errs = append(errs, field.Required(path.Child("hostPath"), "required for the hostPath persistence type"))
+		}
+	case "pvc":
+		if persistence.PersistentVolumeClaim == nil {
+			errs = append(errs, field.Required(path.Child("persistentVolumeClaim"), "required for the pvc persistence type"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), persistence.Type, []string{"emptyDir", "hostPath", "pvc"}))
+	}
+
+	if persistence.HostPath != "" && persistence.Type != "hostPath" {
+		errs = append(errs, field.Forbidden(path.Child("hostPath"), fmt.Sprintf("not used with the %s persistence type", persistenceType(persistence))))
+	}
+	if persistence.PersistentVolumeClaim != nil && persistence.Type != "pvc" {
+		errs = append(errs, field.Forbidden(path.Child("persistentVolumeClaim"), fmt.Sprintf("not used with the %s persistence type", persistenceType(persistence))))
+	}
+	return errs
+}
+
+func persistenceType(persistence kapi.PersistenceSpec) string {
+	if persistence.Type == "" {
+		return "emptyDir"
+	}
+	return persistence.Type
+}
+
+// validateService validates the ports of the service. The ports of NodePort services are the node ports, which
+// must be in the node port range of the management cluster, checked only with a warning as the range can be changed.
+func validateService(service kapi.ServiceSpec, path *field.Path, errs *field.ErrorList) admission.Warnings {
+	apiPath, konnectivityPath := path.Child("apiPort"), path.Child("konnectivityPort")
+	for _, p := range []struct {
+		path *field.Path
+		port int
+	}{{apiPath, service.APIPort}, {konnectivityPath, service.KonnectivityPort}} {
+		if p.port < 1 || p.port > 65535 {
+			*errs = append(*errs, field.Invalid(p.path, p.port, "must be between 1 and 65535"))
+		}
+	}
+	if service.APIPort == service.KonnectivityPort {
+		*errs = append(*errs, field.Duplicate(konnectivityPath, service.KonnectivityPort))
+	}
+	if service.KonnectivityPort == controllerAPIPort {
+		*errs = append(*errs, field.Forbidden(konnectivityPath, fmt.Sprintf("port %d is used by the API server in the controller pods", controllerAPIPort)))
+	}
+
+	if service.Type != v1.ServiceTypeNodePort {
+		return nil
+	}
+	var warnings admission.Warnings
+	for _, p := range []struct {
+		path *field.Path
+		port int
+	}{{apiPath, service.APIPort}, {konnectivityPath, service.KonnectivityPort}} {
+		if p.port < minNodePort || p.port > maxNodePort {
+			warnings = append(warnings, fmt.Sprintf("%s: node port %d is outside the default node port range %d-%d", p.path, p.port, minNodePort, maxNodePort))
+		}
+	}
+	return warnings
+}
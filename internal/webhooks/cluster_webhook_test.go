@@ -0,0 +1,265 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kapi "github.com/k0sproject/k0smotron/api/k0smotron.io/v1beta1"
+)
+
+func TestCluster_Default(t *testing.T) {
+	w := &Cluster{}
+
+	kmc := &kapi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "kmc", Namespace: "default"}}
+	require.NoError(t, w.Default(context.Background(), kmc))
+	require.Equal(t, "k0sproject/k0s", kmc.Spec.Image)
+	require.Equal(t, "quay.io/k0sproject/etcd:v3.5.13", kmc.Spec.Etcd.Image)
+	require.Equal(t, v1.ServiceTypeClusterIP, kmc.Spec.Service.Type)
+	require.Equal(t, 30443, kmc.Spec.Service.APIPort)
+	require.Equal(t, 30132, kmc.Spec.Service.KonnectivityPort)
+
+	// The defaulting is idempotent, so it can run on every update
+	defaulted := kmc.DeepCopy()
+	require.NoError(t, w.Default(context.Background(), defaulted))
+	require.Equal(t, kmc, defaulted)
+
+	kmc = &kapi.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "kmc", Namespace: "default"},
+		Spec: kapi.ClusterSpec{
+			Image:   "registry.example.com/k0s",
+			Service: kapi.ServiceSpec{Type: v1.ServiceTypeNodePort, APIPort: 31443, KonnectivityPort: 31132},
+			Etcd:    kapi.EtcdSpec{External: &kapi.ExternalEtcdSpec{Endpoints: []string{"https://etcd:2379"}}},
+		},
+	}
+	require.NoError(t, w.Default(context.Background(), kmc))
+	require.Equal(t, "registry.example.com/k0s", kmc.Spec.Image)
+	require.Empty(t, kmc.Spec.Etcd.Image)
+	require.Equal(t, kapi.ServiceSpec{Type: v1.ServiceTypeNodePort, APIPort: 31443, KonnectivityPort: 31132}, kmc.Spec.Service)
+}
+
+func TestCluster_Validate(t *testing.T) {
+	tests := []struct {
+		name         string
+		mutate       func(spec *kapi.ClusterSpec)
+		wantErrs     []string
+		wantWarnings int
+	}{
+		{
+			name:   "defaults",
+			mutate: func(spec *kapi.ClusterSpec) {},
+		},
+		{
+			name: "negative replicas",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Replicas = -1
+			},
+			wantErrs: []string{"spec.replicas"},
+		},
+		{
+			name: "even replicas with the etcd of k0smotron",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Replicas = 2
+			},
+			wantWarnings: 1,
+		},
+		{
+			name: "even replicas with kine",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Replicas = 2
+				spec.KineDataSourceURL = "postgres://postgres:5432/kine"
+			},
+		},
+		{
+			name: "kine URL and secret",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.KineDataSourceURL = "postgres://postgres:5432/kine"
+				spec.KineDataSourceSecretName = "kine"
+			},
+			wantErrs: []string{"spec.kineDataSourceSecretName"},
+		},
+		{
+			name: "kine and external etcd",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.KineDataSourceSecretName = "kine"
+				spec.Etcd.External = &kapi.ExternalEtcdSpec{Endpoints: []string{"https://etcd:2379"}}
+			},
+			wantErrs: []string{"spec.etcd.external"},
+		},
+		{
+			name: "image with a registry port",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Image = "registry.example.com:5000/k0s"
+			},
+		},
+		{
+			name: "image with a tag",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Image = "k0sproject/k0s:v1.28.4-k0s.0"
+			},
+			wantErrs: []string{"spec.image"},
+		},
+		{
+			name: "image with a digest",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Image = "k0sproject/k0s@sha256:4b6b2f4ef5e1e7d3a8b7c6f1c1b2a3d4e5f60718293a4b5c6d7e8f9012345678"
+			},
+			wantErrs: []string{"spec.image"},
+		},
+		{
+			name: "k0s version",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Version = "v1.28.4-k0s.0"
+			},
+		},
+		{
+			name: "Kubernetes version",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Version = "v1.28.4"
+			},
+		},
+		{
+			name: "version with the build metadata",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Version = "v1.28.4+k0s.0"
+			},
+			wantErrs: []string{"spec.version"},
+		},
+		{
+			name: "invalid version",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Version = "latest"
+			},
+			wantErrs: []string{"spec.version"},
+		},
+		{
+			name: "pvc persistence without a pvc",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Persistence.Type = "pvc"
+			},
+			wantErrs: []string{"spec.persistence.persistentVolumeClaim"},
+		},
+		{
+			name: "hostPath persistence without a path",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Persistence.Type = "hostPath"
+			},
+			wantErrs: []string{"spec.persistence.hostPath"},
+		},
+		{
+			name: "pvc with the hostPath persistence",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Persistence = kapi.PersistenceSpec{
+					Type:                  "hostPath",
+					HostPath:              "/var/lib/k0smotron",
+					PersistentVolumeClaim: &kapi.PersistentVolumeClaim{},
+				}
+			},
+			wantErrs: []string{"spec.persistence.persistentVolumeClaim"},
+		},
+		{
+			name: "hostPath with the default persistence",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Persistence.HostPath = "/var/lib/k0smotron"
+			},
+			wantErrs: []string{"spec.persistence.hostPath"},
+		},
+		{
+			name: "port out of range",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Service.APIPort = 70000
+			},
+			wantErrs: []string{"spec.service.apiPort"},
+		},
+		{
+			name: "same API and konnectivity ports",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Service.KonnectivityPort = spec.Service.APIPort
+			},
+			wantErrs: []string{"spec.service.konnectivityPort"},
+		},
+		{
+			name: "konnectivity port of the API server",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Service.KonnectivityPort = 6443
+			},
+			wantErrs: []string{"spec.service.konnectivityPort"},
+		},
+		{
+			name: "node ports out of the node port range",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Service = kapi.ServiceSpec{Type: v1.ServiceTypeNodePort, APIPort: 443, KonnectivityPort: 30132}
+			},
+			wantWarnings: 1,
+		},
+		{
+			name: "load balancer ports",
+			mutate: func(spec *kapi.ClusterSpec) {
+				spec.Service = kapi.ServiceSpec{Type: v1.ServiceTypeLoadBalancer, APIPort: 443, KonnectivityPort: 8132}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Cluster{}
+			kmc := &kapi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "kmc", Namespace: "default"}}
+			require.NoError(t, w.Default(context.Background(), kmc))
+			tt.mutate(&kmc.Spec)
+
+			warnings, err := w.ValidateCreate(context.Background(), kmc)
+			require.Len(t, warnings, tt.wantWarnings)
+			if len(tt.wantErrs) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.True(t, apierrors.IsInvalid(err), err)
+			causes := err.(*apierrors.StatusError).Status().Details.Causes
+			var fields []string
+			for _, c := range causes {
+				fields = append(fields, c.Field)
+			}
+			require.Equal(t, tt.wantErrs, fields)
+		})
+	}
+}
+
+func TestCluster_ValidateUpdate(t *testing.T) {
+	w := &Cluster{}
+	old := &kapi.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "kmc", Namespace: "default", Finalizers: []string{"k0smotron.io/finalizer"}}}
+	require.NoError(t, w.Default(context.Background(), old))
+	old.Spec.Persistence.HostPath = "/var/lib/k0smotron"
+
+	// The clusters created before the webhook can be updated if the spec is not changed
+	kmc := old.DeepCopy()
+	kmc.Finalizers = nil
+	_, err := w.ValidateUpdate(context.Background(), old, kmc)
+	require.NoError(t, err)
+
+	kmc.Spec.Replicas = 3
+	_, err = w.ValidateUpdate(context.Background(), old, kmc)
+	require.True(t, apierrors.IsInvalid(err), err)
+
+	kmc.Spec.Persistence.Type = "hostPath"
+	_, err = w.ValidateUpdate(context.Background(), old, kmc)
+	require.NoError(t, err)
+}
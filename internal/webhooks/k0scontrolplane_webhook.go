@@ -0,0 +1,208 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
+)
+
+const (
+	k0sConfigAPIVersion = "k0s.k0sproject.io/v1beta1"
+	k0sConfigKind       = "ClusterConfig"
+)
+
+// +kubebuilder:webhook:path=/mutate-controlplane-cluster-x-k8s-io-v1beta1-k0scontrolplane,mutating=true,failurePolicy=fail,sideEffects=None,groups=controlplane.cluster.x-k8s.io,resources=k0scontrolplanes,verbs=create;update,versions=v1beta1,name=default.k0scontrolplane.controlplane.cluster.x-k8s.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-controlplane-cluster-x-k8s-io-v1beta1-k0scontrolplane,mutating=false,failurePolicy=fail,sideEffects=None,groups=controlplane.cluster.x-k8s.io,resources=k0scontrolplanes,verbs=create;update,versions=v1beta1,name=validation.k0scontrolplane.controlplane.cluster.x-k8s.io,admissionReviewVersions=v1
+
+// K0sControlPlane sets the defaults of the K0sControlPlane, so they are visible on the stored object, and
+// validates that the version is supported by the kubelets of the worker machines.
+type K0sControlPlane struct {
+	Client client.Reader
+}
+
+var _ admission.CustomDefaulter = &K0sControlPlane{}
+var _ admission.CustomValidator = &K0sControlPlane{}
+
+func (w *K0sControlPlane) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&cpv1beta1.K0sControlPlane{}).
+		WithDefaulter(w).
+		WithValidator(w).
+		Complete()
+}
+
+// Default sets the version of the managed topology of the Cluster, or the default k0s version, the apiVersion
+// and kind of the k0s config and the max surge of the RollingUpdate update strategy.
+func (w *K0sControlPlane) Default(ctx context.Context, obj runtime.Object) error {
+	kcp, ok := obj.(*cpv1beta1.K0sControlPlane)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a K0sControlPlane but got a %T", obj))
+	}
+
+	if kcp.Spec.Version == "" {
+		version, err := w.clusterVersion(ctx, kcp)
+		if err != nil {
+			return err
+		}
+		if version == "" {
+			version = kutil.DefaultK0sVersion
+		}
+		kcp.Spec.Version = version
+	}
+
+	defaultK0sConfig(&kcp.Spec.K0sConfigSpec)
+
+	// Single node control planes can't surge, the max surge is not set so it applies if the control plane
+	// is changed to a multi node one later
+	if kcp.Spec.UpdateStrategy == cpv1beta1.UpdateRollingUpdate && !kcp.IsSingleNode() {
+		if kcp.Spec.RollingUpdate == nil {
+			kcp.Spec.RollingUpdate = &cpv1beta1.RollingUpdate{}
+		}
+		if kcp.Spec.RollingUpdate.MaxSurge == nil {
+			kcp.Spec.RollingUpdate.MaxSurge = ptr.To(kcp.GetMaxSurge())
+		}
+	}
+
+	return nil
+}
+
+// ValidateCreate validates the version of the K0sControlPlane against the worker machines.
+func (w *K0sControlPlane) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	kcp, ok := obj.(*cpv1beta1.K0sControlPlane)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a K0sControlPlane but got a %T", obj))
+	}
+
+	return nil, w.validateVersionSkew(ctx, kcp)
+}
+
+// ValidateUpdate validates the version of the K0sControlPlane against the worker machines if the version is changed.
+func (w *K0sControlPlane) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldKCP, ok := oldObj.(*cpv1beta1.K0sControlPlane)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a K0sControlPlane but got a %T", oldObj))
+	}
+	kcp, ok := newObj.(*cpv1beta1.K0sControlPlane)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a K0sControlPlane but got a %T", newObj))
+	}
+	if oldKCP.Spec.Version == kcp.Spec.Version {
+		return nil, nil
+	}
+
+	return nil, w.validateVersionSkew(ctx, kcp)
+}
+
+// ValidateDelete allows the deletion of the K0sControlPlane.
+func (w *K0sControlPlane) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateVersionSkew returns an error if the version of the control plane is not supported by the kubelets
+// of the worker machines of the cluster, e.g. the upgrade of the control plane skips too many minor versions.
+func (w *K0sControlPlane) validateVersionSkew(ctx context.Context, kcp *cpv1beta1.K0sControlPlane) error {
+	clusterName, ok := kcp.Labels[clusterv1.ClusterNameLabel]
+	if !ok || kcp.Spec.Version == "" {
+		return nil
+	}
+
+	var machines clusterv1.MachineList
+	err := w.Client.List(ctx, &machines,
+		client.InNamespace(objectNamespace(ctx, kcp)),
+		client.MatchingLabels{clusterv1.ClusterNameLabel: clusterName},
+	)
+	if err != nil {
+		return err
+	}
+
+	var errs field.ErrorList
+	for _, m := range machines.Items {
+		if _, ok := m.Labels[clusterv1.MachineControlPlaneLabel]; ok || m.Spec.Version == nil {
+			continue
+		}
+		if err := checkKubeletSkew(kcp.Spec.Version, *m.Spec.Version); err != nil {
+			errs = append(errs, field.Forbidden(field.NewPath("spec", "version"), fmt.Sprintf("worker machine %s: %s", m.Name, err)))
+		}
+	}
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(cpv1beta1.GroupVersion.WithKind("K0sControlPlane").GroupKind(), kcp.Name, errs)
+	}
+
+	return nil
+}
+
+// clusterVersion returns the version of the managed topology of the Cluster the control plane belongs to,
+// or an empty string if the Cluster has no managed topology.
+func (w *K0sControlPlane) clusterVersion(ctx context.Context, kcp *cpv1beta1.K0sControlPlane) (string, error) {
+	name, ok := kcp.Labels[clusterv1.ClusterNameLabel]
+	if !ok {
+		return "", nil
+	}
+
+	var cluster clusterv1.Cluster
+	if err := w.Client.Get(ctx, client.ObjectKey{Name: name, Namespace: objectNamespace(ctx, kcp)}, &cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if cluster.Spec.Topology == nil {
+		return "", nil
+	}
+
+	return cluster.Spec.Topology.Version, nil
+}
+
+// defaultK0sConfig sets the apiVersion and kind of the k0s config, which k0s requires.
+func defaultK0sConfig(spec *bootstrapv1.K0sConfigSpec) {
+	if spec.K0s == nil {
+		return
+	}
+	if spec.K0s.GetAPIVersion() == "" {
+		spec.K0s.SetAPIVersion(k0sConfigAPIVersion)
+	}
+	if spec.K0s.GetKind() == "" {
+		spec.K0s.SetKind(k0sConfigKind)
+	}
+}
+
+// objectNamespace returns the namespace of the object, which is empty on create if the request
+// sets the namespace only in the URL.
+func objectNamespace(ctx context.Context, obj client.Object) string {
+	if obj.GetNamespace() != "" {
+		return obj.GetNamespace()
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return ""
+	}
+	return req.Namespace
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
+)
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clusterv1.AddToScheme(scheme))
+	require.NoError(t, cpv1beta1.AddToScheme(scheme))
+	require.NoError(t, bootstrapv1.AddToScheme(scheme))
+
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func TestK0sControlPlane_Default(t *testing.T) {
+	topologyCluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "default"},
+		Spec:       clusterv1.ClusterSpec{Topology: &clusterv1.Topology{Version: "v1.28.4+k0s.0"}},
+	}
+	clusterLabels := map[string]string{clusterv1.ClusterNameLabel: "my-cluster"}
+
+	tests := []struct {
+		name  string
+		objs  []client.Object
+		kcp   *cpv1beta1.K0sControlPlane
+		check func(t *testing.T, kcp *cpv1beta1.K0sControlPlane)
+	}{
+		{
+			name: "version of the cluster topology",
+			objs: []client.Object{topologyCluster},
+			kcp: &cpv1beta1.K0sControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default", Labels: clusterLabels},
+			},
+			check: func(t *testing.T, kcp *cpv1beta1.K0sControlPlane) {
+				require.Equal(t, "v1.28.4+k0s.0", kcp.Spec.Version)
+			},
+		},
+		{
+			name: "default version without cluster",
+			kcp: &cpv1beta1.K0sControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default", Labels: clusterLabels},
+			},
+			check: func(t *testing.T, kcp *cpv1beta1.K0sControlPlane) {
+				require.Equal(t, kutil.DefaultK0sVersion, kcp.Spec.Version)
+			},
+		},
+		{
+			name: "version is kept",
+			objs: []client.Object{topologyCluster},
+			kcp: &cpv1beta1.K0sControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default", Labels: clusterLabels},
+				Spec:       cpv1beta1.K0sControlPlaneSpec{Version: "v1.27.2+k0s.0"},
+			},
+			check: func(t *testing.T, kcp *cpv1beta1.K0sControlPlane) {
+				require.Equal(t, "v1.27.2+k0s.0", kcp.Spec.Version)
+			},
+		},
+		{
+			name: "k0s config apiVersion and kind",
+			kcp: &cpv1beta1.K0sControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default"},
+				Spec: cpv1beta1.K0sControlPlaneSpec{
+					K0sConfigSpec: bootstrapv1.K0sConfigSpec{
+						K0s: &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}},
+					},
+				},
+			},
+			check: func(t *testing.T, kcp *cpv1beta1.K0sControlPlane) {
+				require.Equal(t, "k0s.k0sproject.io/v1beta1", kcp.Spec.K0sConfigSpec.K0s.GetAPIVersion())
+				require.Equal(t, "ClusterConfig", kcp.Spec.K0sConfigSpec.K0s.GetKind())
+			},
+		},
+		{
+			name: "rolling update max surge",
+			kcp: &cpv1beta1.K0sControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default"},
+				Spec:       cpv1beta1.K0sControlPlaneSpec{UpdateStrategy: cpv1beta1.UpdateRollingUpdate},
+			},
+			check: func(t *testing.T, kcp *cpv1beta1.K0sControlPlane) {
+				require.Equal(t, ptr.To(int32(1)), kcp.Spec.RollingUpdate.MaxSurge)
+			},
+		},
+		{
+			name: "rolling update max surge is kept",
+			kcp: &cpv1beta1.K0sControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default"},
+				Spec: cpv1beta1.K0sControlPlaneSpec{
+					UpdateStrategy: cpv1beta1.UpdateRollingUpdate,
+					RollingUpdate:  &cpv1beta1.RollingUpdate{MaxSurge: ptr.To(int32(0))},
+				},
+			},
+			check: func(t *testing.T, kcp *cpv1beta1.K0sControlPlane) {
+				require.Equal(t, ptr.To(int32(0)), kcp.Spec.RollingUpdate.MaxSurge)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &K0sControlPlane{Client: newTestClient(t, tt.objs...)}
+			require.NoError(t, w.Default(context.Background(), tt.kcp))
+			tt.check(t, tt.kcp)
+
+			// The defaulting is idempotent, so it can run on every update
+			defaulted := tt.kcp.DeepCopy()
+			require.NoError(t, w.Default(context.Background(), defaulted))
+			require.Equal(t, tt.kcp, defaulted)
+		})
+	}
+}
+
+func TestK0sControlPlane_ValidateUpdate(t *testing.T) {
+	clusterLabels := map[string]string{clusterv1.ClusterNameLabel: "my-cluster"}
+	worker := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default", Labels: clusterLabels},
+		Spec:       clusterv1.MachineSpec{ClusterName: "my-cluster", Version: ptr.To("v1.25.16")},
+	}
+	controller := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "controller",
+			Namespace: "default",
+			Labels:    map[string]string{clusterv1.ClusterNameLabel: "my-cluster", clusterv1.MachineControlPlaneLabel: "true"},
+		},
+		Spec: clusterv1.MachineSpec{ClusterName: "my-cluster", Version: ptr.To("v1.28.4+k0s.0")},
+	}
+	oldKCP := &cpv1beta1.K0sControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default", Labels: clusterLabels},
+		Spec:       cpv1beta1.K0sControlPlaneSpec{Version: "v1.28.4+k0s.0"},
+	}
+
+	tests := []struct {
+		name    string
+		version string
+		wantErr bool
+	}{
+		{name: "version not changed", version: "v1.28.4+k0s.0"},
+		{name: "supported skew", version: "v1.28.5+k0s.0"},
+		{name: "unsupported skew", version: "v1.29.0+k0s.0", wantErr: true},
+		{name: "downgrade below worker", version: "v1.24.17+k0s.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &K0sControlPlane{Client: newTestClient(t, worker, controller)}
+			kcp := oldKCP.DeepCopy()
+			kcp.Spec.Version = tt.version
+
+			_, err := w.ValidateUpdate(context.Background(), oldKCP, kcp)
+			if tt.wantErr {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "worker machine worker")
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	kutil "github.com/k0sproject/k0smotron/internal/util"
+)
+
+// +kubebuilder:webhook:path=/mutate-bootstrap-cluster-x-k8s-io-v1beta1-k0sworkerconfig,mutating=true,failurePolicy=fail,sideEffects=None,groups=bootstrap.cluster.x-k8s.io,resources=k0sworkerconfigs,verbs=create;update,versions=v1beta1,name=default.k0sworkerconfig.bootstrap.cluster.x-k8s.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-bootstrap-cluster-x-k8s-io-v1beta1-k0sworkerconfig,mutating=false,failurePolicy=fail,sideEffects=None,groups=bootstrap.cluster.x-k8s.io,resources=k0sworkerconfigs,verbs=create;update,versions=v1beta1,name=validation.k0sworkerconfig.bootstrap.cluster.x-k8s.io,admissionReviewVersions=v1
+
+// K0sWorkerConfig sets the defaults of the K0sWorkerConfig, so they are visible on the stored object, and
+// warns if the version is not supported by the control plane.
+type K0sWorkerConfig struct {
+	Client client.Reader
+}
+
+var _ admission.CustomDefaulter = &K0sWorkerConfig{}
+var _ admission.CustomValidator = &K0sWorkerConfig{}
+
+func (w *K0sWorkerConfig) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&bootstrapv1.K0sWorkerConfig{}).
+		WithDefaulter(w).
+		WithValidator(w).
+		Complete()
+}
+
+// Default sets the version of the Machine owning the config. The Machine is set as the owner after the config is
+// created, so the version is set on the update of the owner reference.
+func (w *K0sWorkerConfig) Default(ctx context.Context, obj runtime.Object) error {
+	config, ok := obj.(*bootstrapv1.K0sWorkerConfig)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a K0sWorkerConfig but got a %T", obj))
+	}
+
+	if config.Spec.Version != "" || config.Spec.DownloadURL != "" || config.Spec.PreInstalledK0s {
+		return nil
+	}
+
+	for _, ref := range config.OwnerReferences {
+		if ref.Kind != "Machine" || ref.APIVersion != clusterv1.GroupVersion.String() {
+			continue
+		}
+
+		var machine clusterv1.Machine
+		if err := w.Client.Get(ctx, client.ObjectKey{Name: ref.Name, Namespace: objectNamespace(ctx, config)}, &machine); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if machine.Spec.Version != nil {
+			config.Spec.Version = kutil.K0sVersion(*machine.Spec.Version)
+		}
+	}
+
+	return nil
+}
+
+// ValidateCreate warns if the version of the K0sWorkerConfig is not supported by the control plane.
+func (w *K0sWorkerConfig) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	config, ok := obj.(*bootstrapv1.K0sWorkerConfig)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a K0sWorkerConfig but got a %T", obj))
+	}
+
+	return w.versionSkewWarnings(ctx, config)
+}
+
+// ValidateUpdate warns if the version of the K0sWorkerConfig is not supported by the control plane.
+func (w *K0sWorkerConfig) ValidateUpdate(ctx context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	config, ok := newObj.(*bootstrapv1.K0sWorkerConfig)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a K0sWorkerConfig but got a %T", newObj))
+	}
+
+	return w.versionSkewWarnings(ctx, config)
+}
+
+// ValidateDelete allows the deletion of the K0sWorkerConfig.
+func (w *K0sWorkerConfig) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// versionSkewWarnings returns a warning if the version of the worker is not supported by the version of the
+// control plane of the cluster, e.g. the worker is upgraded before the control plane. The worker is not denied,
+// as the machines of the cluster can't be created without their bootstrap configs.
+func (w *K0sWorkerConfig) versionSkewWarnings(ctx context.Context, config *bootstrapv1.K0sWorkerConfig) (admission.Warnings, error) {
+	clusterName, ok := config.Labels[clusterv1.ClusterNameLabel]
+	if !ok || config.Spec.Version == "" {
+		return nil, nil
+	}
+
+	namespace := objectNamespace(ctx, config)
+	var cluster clusterv1.Cluster
+	if err := w.Client.Get(ctx, client.ObjectKey{Name: clusterName, Namespace: namespace}, &cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if cluster.Spec.ControlPlaneRef == nil {
+		return nil, nil
+	}
+
+	cp := &unstructured.Unstructured{}
+	cp.SetGroupVersionKind(cluster.Spec.ControlPlaneRef.GroupVersionKind())
+	if err := w.Client.Get(ctx, client.ObjectKey{Name: cluster.Spec.ControlPlaneRef.Name, Namespace: namespace}, cp); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	version, _, err := unstructured.NestedString(cp.Object, "spec", "version")
+	if err != nil || version == "" {
+		return nil, nil
+	}
+
+	if err := checkKubeletSkew(version, config.Spec.Version); err != nil {
+		return admission.Warnings{fmt.Sprintf("spec.version: %s, the worker may fail to join the cluster", err)}, nil
+	}
+
+	return nil, nil
+}
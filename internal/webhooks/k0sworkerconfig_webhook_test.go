@@ -0,0 +1,140 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	bootstrapv1 "github.com/k0sproject/k0smotron/api/bootstrap/v1beta1"
+	cpv1beta1 "github.com/k0sproject/k0smotron/api/controlplane/v1beta1"
+)
+
+func TestK0sWorkerConfig_Default(t *testing.T) {
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-machine", Namespace: "default"},
+		Spec:       clusterv1.MachineSpec{Version: ptr.To("v1.28.4")},
+	}
+	machineOwner := []metav1.OwnerReference{{APIVersion: clusterv1.GroupVersion.String(), Kind: "Machine", Name: "my-machine"}}
+
+	tests := []struct {
+		name   string
+		objs   []client.Object
+		config *bootstrapv1.K0sWorkerConfig
+		want   string
+	}{
+		{
+			name: "version of the owner machine",
+			objs: []client.Object{machine},
+			config: &bootstrapv1.K0sWorkerConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default", OwnerReferences: machineOwner},
+			},
+			want: "v1.28.4+k0s.0",
+		},
+		{
+			name: "no owner machine yet",
+			config: &bootstrapv1.K0sWorkerConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default"},
+			},
+			want: "",
+		},
+		{
+			name: "owner machine not found",
+			config: &bootstrapv1.K0sWorkerConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default", OwnerReferences: machineOwner},
+			},
+			want: "",
+		},
+		{
+			name: "version is kept",
+			objs: []client.Object{machine},
+			config: &bootstrapv1.K0sWorkerConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default", OwnerReferences: machineOwner},
+				Spec:       bootstrapv1.K0sWorkerConfigSpec{Version: "v1.27.2+k0s.0"},
+			},
+			want: "v1.27.2+k0s.0",
+		},
+		{
+			name: "pre-installed k0s",
+			objs: []client.Object{machine},
+			config: &bootstrapv1.K0sWorkerConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default", OwnerReferences: machineOwner},
+				Spec:       bootstrapv1.K0sWorkerConfigSpec{PreInstalledK0s: true},
+			},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &K0sWorkerConfig{Client: newTestClient(t, tt.objs...)}
+			require.NoError(t, w.Default(context.Background(), tt.config))
+			require.Equal(t, tt.want, tt.config.Spec.Version)
+		})
+	}
+}
+
+func TestK0sWorkerConfig_ValidateCreate(t *testing.T) {
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "default"},
+		Spec: clusterv1.ClusterSpec{
+			ControlPlaneRef: &corev1.ObjectReference{
+				APIVersion: cpv1beta1.GroupVersion.String(),
+				Kind:       "K0sControlPlane",
+				Name:       "kcp",
+			},
+		},
+	}
+	kcp := &cpv1beta1.K0sControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "kcp", Namespace: "default"},
+		Spec:       cpv1beta1.K0sControlPlaneSpec{Version: "v1.28.4+k0s.0"},
+	}
+	clusterLabels := map[string]string{clusterv1.ClusterNameLabel: "my-cluster"}
+
+	tests := []struct {
+		name         string
+		version      string
+		wantWarnings bool
+	}{
+		{name: "same version", version: "v1.28.4+k0s.0"},
+		{name: "older worker", version: "v1.27.9+k0s.0"},
+		{name: "worker ahead of the control plane", version: "v1.29.0+k0s.0", wantWarnings: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &K0sWorkerConfig{Client: newTestClient(t, cluster, kcp)}
+			config := &bootstrapv1.K0sWorkerConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default", Labels: clusterLabels},
+				Spec:       bootstrapv1.K0sWorkerConfigSpec{Version: tt.version},
+			}
+
+			warnings, err := w.ValidateCreate(context.Background(), config)
+			require.NoError(t, err)
+			if tt.wantWarnings {
+				require.Len(t, warnings, 1)
+			} else {
+				require.Empty(t, warnings)
+			}
+		})
+	}
+}
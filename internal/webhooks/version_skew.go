@@ -0,0 +1,57 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver"
+)
+
+// maxKubeletSkew returns the number of minor versions the kubelet can be older than the kube-apiserver,
+// which is three since Kubernetes 1.28 and two before.
+func maxKubeletSkew(apiServer *semver.Version) int64 {
+	if apiServer.Major() == 1 && apiServer.Minor() < 28 {
+		return 2
+	}
+	return 3
+}
+
+// checkKubeletSkew returns an error if the kubelet version is not supported by the kube-apiserver version,
+// see https://kubernetes.io/releases/version-skew-policy/#kubelet.
+func checkKubeletSkew(apiServerVersion, kubeletVersion string) error {
+	apiServer, err := semver.NewVersion(apiServerVersion)
+	if err != nil {
+		return fmt.Errorf("error parsing version %q: %w", apiServerVersion, err)
+	}
+	kubelet, err := semver.NewVersion(kubeletVersion)
+	if err != nil {
+		return fmt.Errorf("error parsing version %q: %w", kubeletVersion, err)
+	}
+
+	if kubelet.Major() != apiServer.Major() {
+		return fmt.Errorf("kubelet version %s and control plane version %s have a different major version", kubeletVersion, apiServerVersion)
+	}
+	if kubelet.Minor() > apiServer.Minor() {
+		return fmt.Errorf("kubelet version %s is newer than the control plane version %s", kubeletVersion, apiServerVersion)
+	}
+	if skew := maxKubeletSkew(apiServer); apiServer.Minor()-kubelet.Minor() > skew {
+		return fmt.Errorf("kubelet version %s is more than %d minor versions older than the control plane version %s", kubeletVersion, skew, apiServerVersion)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckKubeletSkew(t *testing.T) {
+	tests := []struct {
+		name      string
+		apiServer string
+		kubelet   string
+		wantErr   bool
+	}{
+		{name: "same version", apiServer: "v1.28.4+k0s.0", kubelet: "v1.28.4+k0s.0"},
+		{name: "older patch", apiServer: "v1.28.4+k0s.0", kubelet: "v1.28.1"},
+		{name: "newer patch", apiServer: "v1.28.1+k0s.0", kubelet: "v1.28.4"},
+		{name: "three minors older since 1.28", apiServer: "v1.28.4+k0s.0", kubelet: "v1.25.16+k0s.0"},
+		{name: "four minors older since 1.28", apiServer: "v1.29.0+k0s.0", kubelet: "v1.25.16+k0s.0", wantErr: true},
+		{name: "two minors older before 1.28", apiServer: "v1.27.9+k0s.0", kubelet: "v1.25.16+k0s.0"},
+		{name: "three minors older before 1.28", apiServer: "v1.27.9+k0s.0", kubelet: "v1.24.17+k0s.0", wantErr: true},
+		{name: "newer minor", apiServer: "v1.27.9+k0s.0", kubelet: "v1.28.4+k0s.0", wantErr: true},
+		{name: "invalid version", apiServer: "latest", kubelet: "v1.28.4+k0s.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkKubeletSkew(tt.apiServer, tt.kubelet)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -91,7 +91,7 @@ func (s *BasicSuite) TestK0sGetsUp() {
 	s.checkClusterStatus(s.Context(), rc)
 
 	s.T().Log("Generating k0smotron join token")
-	token, err := util.GetJoinToken(kc, rc, "kmc-kmc-test-0", "kmc-test", 30443)
+	token, err := util.GetJoinToken(kc, rc, "kmc-test", "kmc-test", 30443)
 	s.Require().NoError(err)
 
 	s.T().Log("joining worker to k0smotron cluster")
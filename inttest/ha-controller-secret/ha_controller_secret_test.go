@@ -64,7 +64,7 @@ func (s *HAControllerSecretSuite) TestK0sGetsUp() {
 	s.Require().NoError(common.WaitForStatefulSet(s.Context(), kc, "kmc-kmc-test-secret", "kmc-test"))
 
 	s.T().Log("Generating k0smotron join token")
-	token, err := util.GetJoinToken(kc, rc, "kmc-kmc-test-secret-0", "kmc-test", 30443)
+	token, err := util.GetJoinToken(kc, rc, "kmc-test-secret", "kmc-test", 30443)
 	s.Require().NoError(err)
 
 	s.T().Log("joining worker to k0smotron cluster")
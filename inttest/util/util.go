@@ -29,6 +29,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/discovery"
@@ -139,8 +140,9 @@ func CreateResources(ctx context.Context, resources []*unstructured.Unstructured
 	return nil
 }
 
-func GetJoinToken(kc *kubernetes.Clientset, rc *rest.Config, name string, namespace string, port int) (string, error) {
-	output, err := exec.PodExecCmdOutput(context.TODO(), kc, rc, name, namespace, "k0s token create --role=worker")
+func GetJoinToken(kc *kubernetes.Clientset, rc *rest.Config, clusterName string, namespace string, port int) (string, error) {
+	podName := v1beta1.GetStatefulSetName(clusterName) + "-0"
+	output, err := exec.PodExecCmdOutput(context.TODO(), kc, rc, podName, types.NamespacedName{Namespace: namespace, Name: clusterName}, "k0s token create --role=worker")
 	if err != nil {
 		return "", fmt.Errorf("failed to get join token: %s", err)
 	}